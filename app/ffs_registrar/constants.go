@@ -6,17 +6,40 @@ const (
 	FunctionName = "ffs.tezsign"
 
 	// usb_ctrlrequest constants
-	evSize            = 12
-	evTypeBind        = 0
-	evTypeUnbind      = 1
-	evTypeEnable      = 2
-	evTypeDisable     = 3
-	evTypeSetup       = 4
-	evTypeSuspend     = 5
-	evTypeResume      = 6
-	vendorReqReady    = 0x5A
-	bmReqTypeVendorIn = 0x81
-	protoVersion      = 0x0001
+	evSize             = 12
+	evTypeBind         = 0
+	evTypeUnbind       = 1
+	evTypeEnable       = 2
+	evTypeDisable      = 3
+	evTypeSetup        = 4
+	evTypeSuspend      = 5
+	evTypeResume       = 6
+	bmReqTypeVendorIn  = 0x81
+	bmReqTypeVendorOut = 0x41
+	usbDirIn           = 0x80
+
+	// Vendor IN requests on EP0. They are answered by the registrar, so the
+	// host can check what it talks to before claiming the bulk endpoints.
+	vendorReqReady    = 0x5A // "TZSG", protocol (LE16), ready flag, link flags, key counts, uptime
+	vendorReqVersion  = 0x5B // app version, NUL-terminated; empty until the app is up
+	vendorReqCommit   = 0x5C // app git commit, NUL-terminated; empty if unknown
+	vendorReqSerial   = 0x5D // device serial number, NUL-terminated
+	vendorReqProtocol = 0x5E // protocol (LE16), oldest host protocol served (LE16)
+
+	// Vendor OUT request without a data stage: the gadget app drops and
+	// reopens its endpoints, clearing a wedged data path.
+	vendorReqReset = 0x5F
+
+	readyReplySize = 18
+
+	protoVersion    = 0x0003
+	protoMinVersion = 0x0001
+
+	// serialPath is the serial number setup-gadget.sh gave the gadget.
+	serialPath = "/sys/kernel/config/usb_gadget/g1/strings/0x409/serialnumber"
+	// bmAttributesPath holds the attributes of our configuration.
+	bmAttributesPath   = "/sys/kernel/config/usb_gadget/g1/configs/c.1/bmAttributes"
+	usbConfigAttWakeup = 0x20
 )
 
 var (
@@ -41,15 +64,19 @@ var (
 	// 	0x07, 0x05, 0x02, 0x02, 0x00, 0x02, 0x00, // EP2 OUT bulk, wMaxPacket=512
 	// }
 
-	// Descriptors for 2 active interfaces (IF0 & IF1)
+	// Descriptors for 3 active interfaces (IF0 sign, IF1 mgmt, IF2 admin),
+	// plus MS OS extended compat IDs so Windows binds WinUSB to all of them
+	// without a driver install.
+	// Requires os_desc to be enabled on the configfs gadget (setup-gadget.sh).
 	deviceDescriptors = []byte{
 		0x03, 0x00, 0x00, 0x00, // fs magic (V2)
-		0x70, 0x00, 0x00, 0x00, // total length = 112 bytes
-		0x03, 0x00, 0x00, 0x00, // flags: HAS_FS_DESC | HAS_HS_DESC
-		0x06, 0x00, 0x00, 0x00, // fs_count = 6 descriptors (IF0+2EP, IF1+2EP)
-		0x06, 0x00, 0x00, 0x00, // hs_count = 6 descriptors (IF0+2EP, IF1+2EP)
+		0xF5, 0x00, 0x00, 0x00, // total length = 245 bytes
+		0x0B, 0x00, 0x00, 0x00, // flags: HAS_FS_DESC | HAS_HS_DESC | HAS_MS_OS_DESC
+		0x09, 0x00, 0x00, 0x00, // fs_count = 9 descriptors (IF0+2EP, IF1+2EP, IF2+2EP)
+		0x09, 0x00, 0x00, 0x00, // hs_count = 9 descriptors (IF0+2EP, IF1+2EP, IF2+2EP)
+		0x01, 0x00, 0x00, 0x00, // os_count = 1 (extended compat ID)
 
-		// FS set (2 interfaces, each: IF + 2 EPs =2 x (9 + 7 + 7)= 46 bytes)
+		// FS set (3 interfaces, each: IF + 2 EPs =3 x (9 + 7 + 7)= 69 bytes)
 		0x09, 0x04, 0x00, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x01, // Interface #0, alt=0, 2 EPs, vendor-specific, iInterface=1
 		0x07, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00, // EP1 IN  bulk, wMaxPacket=64
 		0x07, 0x05, 0x02, 0x02, 0x40, 0x00, 0x00, // EP2 OUT bulk, wMaxPacket=64
@@ -58,7 +85,11 @@ var (
 		0x07, 0x05, 0x83, 0x02, 0x40, 0x00, 0x00, // EP3 IN  bulk, wMaxPacket=64
 		0x07, 0x05, 0x04, 0x02, 0x40, 0x00, 0x00, // EP4 OUT bulk, wMaxPacket=64
 
-		// HS set (2 interfaces, each: IF + 2 EPs =2 x (9 + 7 + 7)= 46 bytes))
+		0x09, 0x04, 0x02, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x03, // Interface #2, alt=0, 2 EPs, vendor, iInterface=3
+		0x07, 0x05, 0x85, 0x02, 0x40, 0x00, 0x00, // EP5 IN  bulk, wMaxPacket=64
+		0x07, 0x05, 0x06, 0x02, 0x40, 0x00, 0x00, // EP6 OUT bulk, wMaxPacket=64
+
+		// HS set (3 interfaces, each: IF + 2 EPs =3 x (9 + 7 + 7)= 69 bytes)
 		0x09, 0x04, 0x00, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x01, // Interface #0, 2 EPs, vendor, iInterface=1
 		0x07, 0x05, 0x81, 0x02, 0x00, 0x02, 0x00, // EP1 IN  bulk, wMaxPacket=512
 		0x07, 0x05, 0x02, 0x02, 0x00, 0x02, 0x00, // EP2 OUT bulk, wMaxPacket=512
@@ -66,6 +97,32 @@ var (
 		0x09, 0x04, 0x01, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x02, // Interface #1, 2 EPs, vendor, iInterface=2
 		0x07, 0x05, 0x83, 0x02, 0x00, 0x02, 0x00, // EP3 IN  bulk, wMaxPacket=512
 		0x07, 0x05, 0x04, 0x02, 0x00, 0x02, 0x00, // EP4 OUT bulk, wMaxPacket=512
+
+		0x09, 0x04, 0x02, 0x00, 0x02, 0xFF, 0x00, 0x00, 0x03, // Interface #2, 2 EPs, vendor, iInterface=3
+		0x07, 0x05, 0x85, 0x02, 0x00, 0x02, 0x00, // EP5 IN  bulk, wMaxPacket=512
+		0x07, 0x05, 0x06, 0x02, 0x00, 0x02, 0x00, // EP6 OUT bulk, wMaxPacket=512
+
+		// OS set: header (11 bytes) + 3 extended compat entries (24 bytes each)
+		0x00,                   // interface (unused for extended compat)
+		0x53, 0x00, 0x00, 0x00, // dwLength = 83 bytes
+		0x01, 0x00, // bcdVersion = 1
+		0x04, 0x00, // wIndex = 4 (extended compat ID)
+		0x03, 0x00, // bCount = 3, reserved
+
+		0x00, 0x01, // bFirstInterfaceNumber = 0, reserved = 1
+		'W', 'I', 'N', 'U', 'S', 'B', 0x00, 0x00, // CompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SubCompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
+
+		0x01, 0x01, // bFirstInterfaceNumber = 1, reserved = 1
+		'W', 'I', 'N', 'U', 'S', 'B', 0x00, 0x00, // CompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SubCompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
+
+		0x02, 0x01, // bFirstInterfaceNumber = 2, reserved = 1
+		'W', 'I', 'N', 'U', 'S', 'B', 0x00, 0x00, // CompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SubCompatibleID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // reserved
 	}
 
 	// V1 Strings for one interface (IF0)
@@ -81,11 +138,11 @@ var (
 	// 	0x74, 0x65, 0x7A, 0x73, 0x69, 0x67, 0x6E, 0x00,
 	// }
 
-	// V1 Strings for one interface (IF0 & IF1)
+	// V1 Strings for the interfaces (IF0, IF1 & IF2)
 	deviceStrings = []byte{
 		0x02, 0x00, 0x00, 0x00, // FUNCTIONFS_STRINGS_MAGIC
-		0x26, 0x00, 0x00, 0x00, // total length = 38 bytes
-		0x02, 0x00, 0x00, 0x00, // 2 strings per language (iInterface=1,2)
+		0x30, 0x00, 0x00, 0x00, // total length = 48 bytes
+		0x03, 0x00, 0x00, 0x00, // 3 strings per language (iInterface=1,2,3)
 		0x01, 0x00, 0x00, 0x00, // 1 language
 
 		// language 0: en-US
@@ -96,5 +153,8 @@ var (
 
 		// string #2: "tezsign-1\0"
 		0x74, 0x65, 0x7A, 0x73, 0x69, 0x67, 0x6E, 0x2D, 0x31, 0x00,
+
+		// string #3: "tezsign-2\0"
+		0x74, 0x65, 0x7A, 0x73, 0x69, 0x67, 0x6E, 0x2D, 0x32, 0x00,
 	}
 )
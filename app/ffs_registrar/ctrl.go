@@ -1,6 +1,15 @@
 package main
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
 // usb_ctrlrequest (Linux / UDC) layout: 8 bytes
 type ctrlReq struct {
@@ -20,3 +29,130 @@ func parseCtrlReq(b []byte) ctrlReq {
 		wLength:       binary.LittleEndian.Uint16(b[6:8]),
 	}
 }
+
+// Link flags in byte 7 of the READY reply.
+const (
+	linkFlagEnabled      = 1 << 0 // the host selected our configuration
+	linkFlagRemoteWakeup = 1 << 1 // the configuration advertises remote wakeup
+	linkFlagSuspended    = 1 << 2 // the bus was suspended since a READY cleared it
+)
+
+// readyClearSuspended in wValue of READY clears linkFlagSuspended once it
+// is replied. Only the session that claims the device sets it, so device
+// listings do not hide a suspend from it.
+const readyClearSuspended = 1 << 0
+
+// vendorInfo answers the vendor IN requests on EP0.
+type vendorInfo struct {
+	ready  atomic.Uint32
+	app    atomic.Pointer[appInfo]   // nil while the app is down
+	status atomic.Pointer[appStatus] // nil until the app reports
+	serial string
+
+	// Owned by the EP0 loop, which also calls reply.
+	enabled      bool
+	remoteWakeup bool
+	suspended    bool
+}
+
+// note tracks the function state the READY reply reports.
+func (v *vendorInfo) note(ev linkEvent) {
+	switch ev {
+	case linkEnabled:
+		v.enabled = true
+	case linkDisabled:
+		v.enabled = false
+	case linkSuspended:
+		v.suspended = true
+	}
+}
+
+// reply returns the data stage for req, or false for requests we STALL.
+func (v *vendorInfo) reply(req ctrlReq) ([]byte, bool) {
+	if req.bmRequestType != bmReqTypeVendorIn {
+		return nil, false
+	}
+	switch req.bRequest {
+	case vendorReqReady:
+		reply := make([]byte, readyReplySize)
+		copy(reply[:4], "TZSG")
+		binary.LittleEndian.PutUint16(reply[4:6], protoVersion)
+		// An app without a configured function cannot serve the host.
+		if v.enabled {
+			reply[6] = byte(v.ready.Load())
+		}
+		reply[7] = v.linkFlags()
+		if req.wValue&readyClearSuspended != 0 {
+			v.suspended = false
+		}
+		// Hosts asking for 8 bytes stop here.
+		if st := v.status.Load(); st != nil && reply[6] == 1 {
+			binary.LittleEndian.PutUint16(reply[8:10], st.keys)
+			binary.LittleEndian.PutUint16(reply[10:12], st.unlocked)
+			binary.LittleEndian.PutUint16(reply[12:14], st.keys-st.unlocked)
+			binary.LittleEndian.PutUint32(reply[14:18], uint32(min(st.uptimeNow()/time.Second, math.MaxUint32)))
+		}
+		return reply, true
+	case vendorReqProtocol:
+		reply := make([]byte, 4)
+		binary.LittleEndian.PutUint16(reply[0:2], protoVersion)
+		binary.LittleEndian.PutUint16(reply[2:4], protoMinVersion)
+		return reply, true
+	case vendorReqVersion, vendorReqCommit:
+		var s string
+		if app := v.app.Load(); app != nil {
+			s = app.version
+			if req.bRequest == vendorReqCommit {
+				s = app.commit
+			}
+		}
+		return cString(s), true
+	case vendorReqSerial:
+		return cString(v.serial), true
+	}
+	return nil, false
+}
+
+func (v *vendorInfo) linkFlags() byte {
+	var f byte
+	if v.enabled {
+		f |= linkFlagEnabled
+	}
+	if v.remoteWakeup {
+		f |= linkFlagRemoteWakeup
+	}
+	if v.suspended {
+		f |= linkFlagSuspended
+	}
+	return f
+}
+
+// cString NUL-terminates s so the data stage is never empty.
+func cString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func readSerial(l *slog.Logger) string {
+	b, err := os.ReadFile(serialPath)
+	if err != nil {
+		l.Warn("device serial unavailable", "err", err)
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// readRemoteWakeup reports whether setup-gadget.sh advertised remote wakeup.
+// The kernel negotiates it with the host (SET_FEATURE never reaches EP0).
+func readRemoteWakeup(l *slog.Logger) bool {
+	b, err := os.ReadFile(bmAttributesPath)
+	if err != nil {
+		l.Warn("configuration attributes unavailable", "err", err)
+		return false
+	}
+	attr, err := strconv.ParseUint(strings.TrimSpace(string(b)), 0, 8)
+	if err != nil {
+		l.Warn("configuration attributes", "value", strings.TrimSpace(string(b)), "err", err)
+		return false
+	}
+	return attr&usbConfigAttWakeup != 0
+}
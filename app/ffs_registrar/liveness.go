@@ -1,39 +1,201 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"io"
 	"log/slog"
 	"net"
 	"os"
-	"sync/atomic"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
 )
 
 // stopServerTimeout is the maximum time to wait for the liveness server to shut down.
 // This prevents blocking the enabled channel consumer if shutdown takes too long.
 const stopServerTimeout = 5 * time.Second
 
-func watchLiveness(sockPath string, ready *atomic.Uint32, l *slog.Logger) {
+// appInfo is what the gadget app reports about itself on the liveness socket.
+type appInfo struct {
+	version string
+	commit  string
+}
+
+// appStatus is the latest status line from the gadget app.
+type appStatus struct {
+	keys     uint16
+	unlocked uint16
+	uptime   time.Duration // at received
+	received time.Time
+}
+
+func (s *appStatus) uptimeNow() time.Duration {
+	return s.uptime + time.Since(s.received)
+}
+
+func watchLiveness(sockPath string, v *vendorInfo, l *slog.Logger) {
 	for {
 		conn, err := net.Dial("unix", sockPath)
 		if err != nil {
-			ready.Store(0)
+			v.ready.Store(0)
 			l.Debug("gadget not ready (socket down), retrying", "err", err)
 			time.Sleep(500 * time.Millisecond)
 			continue
 		}
 		l.Info("connected to gadget liveness socket")
-		ready.Store(1)
-		// Block until the socket dies, then loop.
-		_, _ = io.Copy(io.Discard, conn)
+		v.ready.Store(1)
+		// The app names itself in one line (older apps send nothing), then
+		// sends status lines as its keys change. Read until the socket
+		// dies, then loop.
+		r := bufio.NewReader(conn)
+		if line, err := r.ReadString('\n'); err == nil {
+			info := &appInfo{}
+			if f := strings.Fields(line); len(f) > 0 {
+				info.version = f[0]
+				if len(f) > 1 {
+					info.commit = f[1]
+				}
+			}
+			v.app.Store(info)
+			l.Info("gadget app", "version", info.version, "commit", info.commit)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				if st, ok := parseAppStatus(line); ok {
+					v.status.Store(st)
+				}
+			}
+		}
+		_, _ = io.Copy(io.Discard, r)
 		_ = conn.Close()
-		ready.Store(0)
+		v.ready.Store(0)
+		v.app.Store(nil)
+		v.status.Store(nil)
 		l.Warn("lost liveness socket; marking not ready")
 	}
 }
 
-func serveEnabled(ctx context.Context, sockPath string, l *slog.Logger) <-chan struct{} {
+// parseAppStatus parses "status <keys> <unlocked> <uptime seconds>".
+func parseAppStatus(line string) (*appStatus, bool) {
+	f := strings.Fields(line)
+	if len(f) != 4 || f[0] != "status" {
+		return nil, false
+	}
+	keys, err1 := strconv.ParseUint(f[1], 10, 16)
+	unlocked, err2 := strconv.ParseUint(f[2], 10, 16)
+	up, err3 := strconv.ParseInt(f[3], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || unlocked > keys {
+		return nil, false
+	}
+	return &appStatus{
+		keys:     uint16(keys),
+		unlocked: uint16(unlocked),
+		uptime:   time.Duration(up) * time.Second,
+		received: time.Now(),
+	}, true
+}
+
+// linkEvent is what the EP0 loop reports to the enabled socket server.
+type linkEvent int
+
+const (
+	linkEnabled linkEvent = iota
+	linkDisabled
+	linkSuspended
+	linkResumed
+	linkReset
+)
+
+func (e linkEvent) String() string {
+	switch e {
+	case linkEnabled:
+		return "enabled"
+	case linkDisabled:
+		return "disabled"
+	case linkSuspended:
+		return "suspended"
+	case linkResumed:
+		return "resumed"
+	case linkReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// enabledClients are the gadget connections on the enabled socket. They
+// are told about suspend/resume and closed when the function goes away.
+type enabledClients struct {
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	suspended bool
+}
+
+func (c *enabledClients) add(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns == nil {
+		c.conns = map[net.Conn]struct{}{}
+	}
+	c.conns[conn] = struct{}{}
+	if c.suspended {
+		notifyLink(conn, common.LinkSuspended)
+	}
+}
+
+func (c *enabledClients) remove(conn net.Conn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+func (c *enabledClients) setSuspended(suspended bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.suspended == suspended {
+		return
+	}
+	c.suspended = suspended
+	b := common.LinkResumed
+	if suspended {
+		b = common.LinkSuspended
+	}
+	for conn := range c.conns {
+		notifyLink(conn, b)
+	}
+}
+
+// reset asks every client to reopen its endpoints.
+func (c *enabledClients) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn := range c.conns {
+		notifyLink(conn, common.LinkReset)
+	}
+}
+
+// closeAll drops every client, which tells them the function is gone.
+func (c *enabledClients) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn := range c.conns {
+		_ = conn.Close()
+	}
+	c.conns = nil
+	c.suspended = false
+}
+
+func notifyLink(conn net.Conn, b byte) {
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Write([]byte{b})
+}
+
+func serveEnabled(ctx context.Context, sockPath string, clients *enabledClients, l *slog.Logger) <-chan struct{} {
 	closeCompletedChan := make(chan struct{})
 	go func() {
 		l.Info("starting liveness server", "socket", sockPath)
@@ -49,6 +211,9 @@ func serveEnabled(ctx context.Context, sockPath string, l *slog.Logger) <-chan s
 		go func() {
 			<-ctx.Done()
 			ln.Close()
+			// A closed listener leaves accepted connections open; close
+			// them so the gadget sees the disable right away.
+			clients.closeAll()
 			closeCompletedChan <- struct{}{}
 			close(closeCompletedChan)
 		}()
@@ -66,7 +231,9 @@ func serveEnabled(ctx context.Context, sockPath string, l *slog.Logger) <-chan s
 				l.Error("failed to accept liveness connection", "err", err)
 				continue
 			}
+			clients.add(conn)
 			go func(c net.Conn) {
+				defer clients.remove(c)
 				defer c.Close()
 				io.Copy(io.Discard, c)
 			}(conn)
@@ -75,9 +242,10 @@ func serveEnabled(ctx context.Context, sockPath string, l *slog.Logger) <-chan s
 	return closeCompletedChan
 }
 
-func runEnabledWatcher(enabled <-chan bool, sockPath string, l *slog.Logger) {
+func runEnabledWatcher(link <-chan linkEvent, sockPath string, l *slog.Logger) {
 	var activeCancel context.CancelFunc
 	var closeCompletedChan <-chan struct{}
+	var clients enabledClients
 	isEnabled := false
 
 	// stopServer cancels the current server and waits for cleanup with timeout
@@ -97,15 +265,22 @@ func runEnabledWatcher(enabled <-chan bool, sockPath string, l *slog.Logger) {
 	}
 	defer stopServer()
 
-	for en := range enabled {
-		if en && !isEnabled {
+	for ev := range link {
+		switch {
+		case ev == linkEnabled && !isEnabled:
 			isEnabled = true
 			ctx, cancel := context.WithCancel(context.Background())
 			activeCancel = cancel
-			closeCompletedChan = serveEnabled(ctx, sockPath, l)
-		} else if !en && isEnabled {
+			closeCompletedChan = serveEnabled(ctx, sockPath, &clients, l)
+		case ev == linkDisabled && isEnabled:
 			isEnabled = false
 			stopServer()
+		case ev == linkSuspended || ev == linkResumed:
+			// Also before enable: hosts may suspend a configured but idle
+			// device, and clients connecting later are told on accept.
+			clients.setSuspended(ev == linkSuspended)
+		case ev == linkReset && isEnabled:
+			clients.reset()
 		}
 	}
 }
@@ -1,26 +1,25 @@
 package main
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
 	"log/slog"
 	"os"
-	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/tez-capital/tezsign/app/gadget/common"
 )
 
-// trySendEnabled attempts to send a value to the enabled channel without blocking.
+// trySendLink attempts to send an event to the link channel without blocking.
 // This prevents the EP0 event loop from blocking if events arrive faster than
 // they can be consumed, which would cause USB enumeration failures.
-func trySendEnabled(ch chan<- bool, val bool, l *slog.Logger) {
+func trySendLink(ch chan<- linkEvent, ev linkEvent, l *slog.Logger) {
 	select {
-	case ch <- val:
+	case ch <- ev:
 		// Successfully sent
 	default:
-		l.Warn("enabled channel full, dropping event (consumer may be slow)", "value", val)
+		l.Warn("link channel full, dropping event (consumer may be slow)", "event", ev)
 	}
 }
 
@@ -52,7 +51,23 @@ func triggerSoftConnect(l *slog.Logger) error {
 	return nil
 }
 
-func drainEP0Events(ep0 *os.File, enabled chan<- bool, ready *atomic.Uint32, l *slog.Logger) {
+// stallEP0 STALLs the pending SETUP: FunctionFS halts EP0 when userspace
+// moves data against the request's direction. A 0-byte write on an IN
+// request would instead send an empty data stage.
+func stallEP0(ep0 *os.File, req ctrlReq, l *slog.Logger) {
+	var err error
+	if req.bmRequestType&usbDirIn != 0 {
+		// os.File skips zero-length reads
+		_, err = syscall.Read(int(ep0.Fd()), nil)
+	} else {
+		_, err = ep0.Write(nil)
+	}
+	if err != nil && !errors.Is(err, syscall.EL2HLT) {
+		l.Error("ep0 STALL failed", "err", err)
+	}
+}
+
+func drainEP0Events(ep0 *os.File, link chan<- linkEvent, v *vendorInfo, l *slog.Logger) {
 	buf := make([]byte, evSize)
 
 	for {
@@ -80,23 +95,31 @@ func drainEP0Events(ep0 *os.File, enabled chan<- bool, ready *atomic.Uint32, l *
 			continue
 		case evTypeUnbind:
 			l.Info("tezsign gadget unbound")
+			v.note(linkDisabled)
+			trySendLink(link, linkDisabled, l)
 			continue
 		case evTypeEnable:
 			l.Info("tezsign gadget enabled")
-			trySendEnabled(enabled, true, l)
+			v.note(linkEnabled)
+			trySendLink(link, linkEnabled, l)
 			continue
 		case evTypeDisable:
 			l.Info("tezsign gadget disabled")
-			trySendEnabled(enabled, false, l)
+			v.note(linkDisabled)
+			trySendLink(link, linkDisabled, l)
 			triggerSoftConnect(l)
 			continue
 		case evTypeSuspend:
+			// The configuration stays; the gadget keeps its brokers and
+			// waits instead of tearing them down.
 			l.Info("tezsign gadget suspended")
-			trySendEnabled(enabled, false, l)
+			v.note(linkSuspended)
+			trySendLink(link, linkSuspended, l)
 			continue
 		case evTypeResume:
 			l.Info("tezsign gadget resumed")
-			trySendEnabled(enabled, true, l)
+			v.note(linkResumed)
+			trySendLink(link, linkResumed, l)
 			continue
 		case evTypeSetup:
 			// Handle below
@@ -108,19 +131,21 @@ func drainEP0Events(ep0 *os.File, enabled chan<- bool, ready *atomic.Uint32, l *
 		// ^____ request ____^
 		req := parseCtrlReq(buf[0:8])
 		l.Info("parsed", "type", req.bmRequestType, "request", req.bRequest, "length", req.wLength)
-		// Handle our vendor IN request
-		if req.bmRequestType == bmReqTypeVendorIn && req.bRequest == vendorReqReady {
-			// Prepare reply
-			reply := [8]byte{}
-			copy(reply[:4], []byte("TZSG"))
-			binary.LittleEndian.PutUint16(reply[4:6], protoVersion)
-			reply[6] = byte(ready.Load())
-
-			// Respect host's wLength (shorter read is OK)
-			wlen := int(req.wLength)
-			if wlen > len(reply) {
-				wlen = len(reply)
+		if req.bmRequestType == bmReqTypeVendorOut && req.bRequest == vendorReqReset && req.wLength == 0 {
+			// Reading the empty data stage acks the status stage. os.File
+			// skips zero-length reads, so go to the fd directly.
+			if _, err := syscall.Read(int(ep0.Fd()), nil); err != nil {
+				l.Error("ep0 ack function reset", "err", err)
+				continue
 			}
+			l.Info("host requested a function reset")
+			trySendLink(link, linkReset, l)
+			continue
+		}
+		// Handle our vendor IN requests
+		if reply, ok := v.reply(req); ok {
+			// Respect host's wLength (shorter read is OK)
+			wlen := min(int(req.wLength), len(reply))
 			// Write data stage
 			if _, err := ep0.Write(reply[:wlen]); err != nil {
 				l.Error("ep0 write vendor reply", "err", err)
@@ -128,11 +153,7 @@ func drainEP0Events(ep0 *os.File, enabled chan<- bool, ready *atomic.Uint32, l *
 			continue
 		}
 		l.Warn("Unhandled SETUP request, STALLING", "type", req.bmRequestType, "req", req.bRequest)
-		// A 0-byte write on an unhandled request is the
-		// userspace way to signal a STALL to the kernel.
-		if _, err := ep0.Write(nil); err != nil {
-			l.Error("ep0 write ZLP/STALL failed", "err", err)
-		}
+		stallEP0(ep0, req, l)
 	}
 }
 
@@ -157,14 +178,14 @@ func main() {
 	}
 
 	// Start watching gadget liveness
-	var ready atomic.Uint32
-	go watchLiveness(common.ReadySock, &ready, l)
+	v := &vendorInfo{serial: readSerial(l), remoteWakeup: readRemoteWakeup(l)}
+	go watchLiveness(common.ReadySock, v, l)
 	// Buffer size 8 to handle rapid event sequences (enable/disable/suspend/resume)
 	// without blocking the EP0 event loop
-	enabled := make(chan bool, 8)
-	go runEnabledWatcher(enabled, common.EnabledSock, l)
+	link := make(chan linkEvent, 8)
+	go runEnabledWatcher(link, common.EnabledSock, l)
 
 	l.Info("FFS registrar online; handling EP0 control & events")
 
-	drainEP0Events(ep0, enabled, &ready, l)
+	drainEP0Events(ep0, link, v, l)
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/audit"
+	"github.com/tez-capital/tezsign/keychain"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// envAuditExport exposes the audit directory to the host as a read-only
+	// USB drive ("1" to enable); see tezsign-audit-export.sh.
+	envAuditExport = "TEZSIGN_AUDIT_EXPORT"
+
+	auditDirName       = "audit" // under DATA_STORE
+	signAuditFile      = "sign.log"
+	healthAuditFile    = "health.log"
+	signAuditMaxSize   = 4 << 20
+	healthAuditMaxSize = 1 << 20
+	healthReportEvery  = 5 * time.Minute
+	auditTailWindow    = 64 << 10 // longest record we expect
+	auditPageDefault   = 200
+	auditPageMax       = 1000
+)
+
+// auditLog appends hash-chained JSON lines (see package audit) to a file,
+// keeping one rotated copy (.1) once it grows past max; the chain runs on
+// across the rotation. A nil *auditLog drops records.
+type auditLog struct {
+	path string
+	max  int64
+	l    *slog.Logger
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	torn bool       // the file ends in a partial line (power cut mid-write)
+	seq  uint64     // last record written
+	head audit.Hash // its hash
+}
+
+func newAuditLog(dir, name string, max int64, l *slog.Logger) *auditLog {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		l.Error("audit log disabled", "dir", dir, "err", err)
+		return nil
+	}
+	a := &auditLog{path: filepath.Join(dir, name), max: max, l: l}
+	a.restore()
+	return a
+}
+
+// restore picks the chain up from the last complete record on disk.
+func (a *auditLog) restore() {
+	for _, p := range []string{a.path, a.path + ".1"} {
+		line, torn, err := lastLine(p)
+		if p == a.path {
+			a.torn = torn
+		}
+		if err != nil || line == nil {
+			continue
+		}
+		seq, _, err := audit.Parse(line)
+		if err != nil {
+			a.l.Warn("audit log tail unreadable; starting a new chain", "path", p, "err", err)
+			return
+		}
+		a.seq, a.head = seq, sha256.Sum256(line)
+		return
+	}
+}
+
+// lastLine returns the last newline-terminated line of p, and whether
+// bytes follow it.
+func lastLine(p string) (line []byte, torn bool, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	off := max(st.Size()-auditTailWindow, 0)
+	buf := make([]byte, st.Size()-off)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		return nil, false, err
+	}
+	end := bytes.LastIndexByte(buf, '\n')
+	torn = end != len(buf)-1 && len(buf) > 0
+	if end < 0 {
+		return nil, torn, nil
+	}
+	buf = buf[:end]
+	return buf[bytes.LastIndexByte(buf, '\n')+1:], torn, nil
+}
+
+func (a *auditLog) append(rec any) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	line, h, err := audit.Link(a.seq+1, a.head, rec)
+	if err != nil {
+		a.l.Error("audit record", "path", a.path, "err", err)
+		return
+	}
+	if a.torn {
+		line = append([]byte{'\n'}, line...) // leave the fragment on its own line
+	}
+	if err := a.write(append(line, '\n')); err != nil {
+		a.l.Error("audit write", "path", a.path, "err", err)
+		return
+	}
+	a.torn = false
+	a.seq, a.head = a.seq+1, h
+}
+
+func (a *auditLog) write(line []byte) error {
+	if a.f != nil && a.size+int64(len(line)) > a.max {
+		_ = a.f.Close()
+		a.f = nil
+		if err := os.Rename(a.path, a.path+".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if a.f == nil {
+		f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		a.f, a.size = f, st.Size()
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// scan calls fn for each line, oldest first, until fn returns false. It
+// reads the files as they were when called, without holding up writers.
+func (a *auditLog) scan(fn func(line []byte) bool) error {
+	type part struct {
+		f    *os.File
+		size int64
+	}
+	a.mu.Lock()
+	var parts []part
+	for _, p := range []string{a.path + ".1", a.path} {
+		f, err := os.Open(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			var st os.FileInfo
+			if st, err = f.Stat(); err == nil {
+				parts = append(parts, part{f, st.Size()})
+				continue
+			}
+			_ = f.Close()
+		}
+		a.mu.Unlock()
+		for _, pt := range parts {
+			_ = pt.f.Close()
+		}
+		return err
+	}
+	a.mu.Unlock()
+	defer func() {
+		for _, pt := range parts {
+			_ = pt.f.Close()
+		}
+	}()
+
+	for _, pt := range parts {
+		sc := bufio.NewScanner(io.LimitReader(pt.f, pt.size))
+		sc.Buffer(make([]byte, 64<<10), auditTailWindow)
+		for sc.Scan() {
+			if len(sc.Bytes()) > 0 && !fn(sc.Bytes()) {
+				return nil
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records returns up to limit records with seq > after, and whether more
+// follow. Torn fragments and other lines without a header are left out.
+func (a *auditLog) records(after uint64, limit int) (recs [][]byte, more bool, err error) {
+	err = a.scan(func(line []byte) bool {
+		seq, _, err := audit.Parse(line)
+		if err != nil || seq <= after {
+			return true
+		}
+		if len(recs) == limit {
+			more = true
+			return false
+		}
+		recs = append(recs, bytes.Clone(line))
+		return true
+	})
+	return recs, more, err
+}
+
+// verify walks the whole log and stops at the first broken link. Torn
+// fragments are skipped, as records does; other malformed lines fail.
+func (a *auditLog) verify() (audit.Verifier, error) {
+	var v audit.Verifier
+	var chainErr error
+	err := a.scan(func(line []byte) bool {
+		chainErr = v.Add(line)
+		return chainErr == nil
+	})
+	if err != nil {
+		return v, err
+	}
+	return v, chainErr
+}
+
+type signRecord struct {
+	Time   time.Time `json:"time"`
+	TZ4    string    `json:"tz4"`
+	Kind   string    `json:"kind,omitempty"`
+	Level  uint64    `json:"level,omitempty"`
+	Round  uint32    `json:"round,omitempty"`
+	Result string    `json:"result"` // "signed" or "rejected"
+	Reason string    `json:"reason,omitempty"`
+}
+
+// recordSign logs one sign decision. signErr is the reason for a rejection.
+func (a *auditLog) recordSign(tz4 string, msg []byte, signErr error) {
+	if a == nil {
+		return
+	}
+	rec := signRecord{Time: time.Now().UTC(), TZ4: tz4, Result: "signed"}
+	if kind, level, round, _, err := keychain.DecodeAndValidateSignPayload(msg); err == nil {
+		rec.Kind, rec.Level, rec.Round = kind.String(), level, round
+	}
+	if signErr != nil {
+		rec.Result, rec.Reason = "rejected", signErr.Error()
+	}
+	a.append(rec)
+}
+
+// reportHealth appends a health snapshot every healthReportEvery.
+func (a *auditLog) reportHealth(hm *healthMonitor) {
+	if a == nil {
+		return
+	}
+	for ; ; time.Sleep(healthReportEvery) {
+		raw, err := protojson.Marshal(hm.snapshot())
+		if err != nil {
+			a.l.Error("health report", "err", err)
+			continue
+		}
+		a.append(struct {
+			Time   time.Time       `json:"time"`
+			Health json.RawMessage `json:"health"`
+		}{time.Now().UTC(), raw})
+	}
+}
+
+func auditExportEnabled() bool {
+	return envBool(os.Getenv(envAuditExport))
+}
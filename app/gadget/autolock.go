@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/keychain"
+)
+
+// envAutoLock is how long the host may be gone (gadget disabled or unbound,
+// or USB suspended) before all keys are locked again, as a Go duration
+// such as "5m". Unset or "0" keeps keys unlocked across disconnects.
+const envAutoLock = "TEZSIGN_AUTOLOCK_AFTER"
+
+// autoLocker locks the key ring once the host has been away for too long.
+// The host is away while the function is disabled or the link suspended;
+// the countdown runs from the moment it stops being present.
+type autoLocker struct {
+	kr *keychain.KeyRing
+	l  *slog.Logger
+
+	mu        sync.Mutex
+	after     time.Duration // 0 = disabled
+	disabled  bool
+	suspended bool
+	timer     *time.Timer // armed while away
+	gen       uint64      // bumped on every arm and cancel
+}
+
+func newAutoLocker(kr *keychain.KeyRing, l *slog.Logger) *autoLocker {
+	return &autoLocker{kr: kr, l: l}
+}
+
+// setAfter changes the grace period; it applies from the next disconnect.
+func (a *autoLocker) setAfter(after time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if after == a.after {
+		return
+	}
+	a.after = after
+	if after > 0 {
+		a.l.Info("auto-lock on disconnect enabled", "after", after)
+	} else {
+		a.l.Info("auto-lock on disconnect disabled")
+	}
+}
+
+// disconnected marks the function disabled. The suspend state belongs to
+// the connection that reported it, and a countdown it started goes on.
+func (a *autoLocker) disconnected() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.disabled, a.suspended = true, false
+	a.updateLocked()
+}
+
+// connected marks the function enabled.
+func (a *autoLocker) connected() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.disabled = false
+	a.updateLocked()
+}
+
+// setSuspended follows USB suspend and resume: a suspended host cannot
+// sign, so it counts as away as well.
+func (a *autoLocker) setSuspended(suspended bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.suspended = suspended
+	a.updateLocked()
+}
+
+// updateLocked starts the countdown when the host goes away and cancels
+// it when the host is back. A timer that fires after Stop lost the race
+// finds its generation outdated and does nothing. a.mu is held.
+func (a *autoLocker) updateLocked() {
+	away := a.disabled || a.suspended
+	switch {
+	case away && a.timer == nil && a.after > 0:
+		a.gen++
+		gen, after := a.gen, a.after
+		a.timer = time.AfterFunc(after, func() { a.expire(gen, after) })
+	case !away && a.timer != nil:
+		a.timer.Stop()
+		a.timer = nil
+		a.gen++
+	}
+}
+
+func (a *autoLocker) expire(gen uint64, after time.Duration) {
+	a.mu.Lock()
+	if gen != a.gen {
+		a.mu.Unlock()
+		return
+	}
+	a.timer = nil
+	a.mu.Unlock()
+	if n := a.kr.LockAll(); n > 0 {
+		a.l.Warn("host gone; keys locked", "after", after, "keys", n)
+	}
+}
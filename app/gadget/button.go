@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+const (
+	// envButton names a push button that must be pressed to confirm key
+	// management: "<sysfs gpio number>[,active_low]". Unset, nothing waits.
+	envButton = "TEZSIGN_BUTTON"
+
+	buttonPoll = 20 * time.Millisecond
+	// buttonWait is how long a request waits for the press; hosts allow
+	// for it in their timeouts.
+	buttonWait = 20 * time.Second
+)
+
+var (
+	errNotConfirmed       = fmt.Errorf("not confirmed: press the device button within %s", buttonWait)
+	errConfirmationActive = errors.New("another request is waiting for the device button")
+)
+
+// confirmButton holds requests that change keys or firmware until someone
+// at the device presses the button. A nil *confirmButton confirms at once.
+type confirmButton struct {
+	*gpioInput
+	err     error // why the configured button is unusable
+	l       *slog.Logger
+	pending sync.Mutex // one confirmation at a time
+}
+
+// newConfirmButton reads TEZSIGN_BUTTON. A configured but unreadable pin
+// is logged at error level and confirms nothing: requests needing it fail
+// instead of going through unconfirmed.
+func newConfirmButton(l *slog.Logger) *confirmButton {
+	spec := strings.TrimSpace(os.Getenv(envButton))
+	if spec == "" {
+		return nil
+	}
+	g, err := parseGPIOSpec(spec)
+	if err == nil {
+		_, err = g.active()
+	}
+	if err != nil {
+		l.Error("button unusable; key management refused until fixed", "spec", spec, "err", err)
+		return &confirmButton{err: fmt.Errorf("button %q: %w", spec, err), l: l}
+	}
+	l.Info("button confirmation enabled", "spec", spec)
+	return &confirmButton{gpioInput: g, l: l}
+}
+
+// confirm waits for a fresh press, released then pressed, for up to
+// buttonWait.
+func (b *confirmButton) confirm(ctx context.Context, what string) error {
+	if b == nil {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if !b.pending.TryLock() {
+		return errConfirmationActive
+	}
+	defer b.pending.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, buttonWait)
+	defer cancel()
+	b.l.Info("press the button to confirm", "request", what)
+
+	t := time.NewTicker(buttonPoll)
+	defer t.Stop()
+	released := false
+	for {
+		select {
+		case <-ctx.Done():
+			b.l.Warn("not confirmed", "request", what)
+			return errNotConfirmed
+		case <-t.C:
+		}
+		on, err := b.active()
+		if err != nil {
+			return fmt.Errorf("button: %w", err)
+		}
+		if !on {
+			released = true
+		} else if released {
+			b.l.Info("confirmed", "request", what)
+			return nil
+		}
+	}
+}
+
+// handleConfirmed asks for the button before key management and update
+// commits; other requests pass straight through.
+func handleConfirmed(b *confirmButton, base broker.Handler) broker.Handler {
+	if b == nil {
+		return base
+	}
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		var req signer.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
+		}
+		defer wipeReq(&req)
+		var what string
+		switch req.Payload.(type) {
+		case *signer.Request_NewKeys:
+			what = "new keys"
+		case *signer.Request_DeleteKeys:
+			what = "delete keys"
+		case *signer.Request_InitMaster:
+			what = "init"
+		case *signer.Request_AckTamper:
+			what = "acknowledge tamper"
+		case *signer.Request_UpdateCommit:
+			what = "app update"
+		default:
+			return base(ctx, payload)
+		}
+		if err := b.confirm(ctx, what); err != nil {
+			return marshalErr(rpcNotConfirmed, err.Error()), nil
+		}
+		return base(ctx, payload)
+	}
+}
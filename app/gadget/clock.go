@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// clockFile keeps the last time the host set, so the clock never starts
+	// behind it after a reboot.
+	clockFile = "clock"
+	// clockSlack is how far the host may be behind the gadget without the
+	// request being refused; such pushes leave the clock alone.
+	clockSlack = 2 * time.Second
+)
+
+var (
+	clockMin = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clockMax = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	errClockImplausible = errors.New("set_time: time outside 2025..2100")
+	errClockBackwards   = errors.New("set_time: would move the clock backwards")
+)
+
+// deviceClock sets the system clock from times pushed by the host. The
+// board has no RTC and no network, so without it the audit trail and logs
+// carry whatever time the image booted with. Setting the clock needs
+// CAP_SYS_TIME (granted in tezsign.service).
+type deviceClock struct {
+	mu   sync.Mutex
+	path string
+	l    *slog.Logger
+}
+
+// newDeviceClock restores the last host-provided time if the system clock
+// is behind it, e.g. after a reboot.
+func newDeviceClock(dir string, l *slog.Logger) *deviceClock {
+	c := &deviceClock{path: filepath.Join(dir, clockFile), l: l}
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			l.Warn("clock: read last time", "err", err)
+		}
+		return c
+	}
+	last, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		l.Warn("clock: ignoring unreadable last time", "path", c.path, "err", err)
+		return c
+	}
+	if now := time.Now(); now.Before(last) {
+		if err := setSystemClock(last); err != nil {
+			l.Warn("clock: could not restore last time", "last", last, "err", err)
+		} else {
+			l.Info("clock: restored last host time", "from", now.UTC(), "to", last)
+		}
+	}
+	return c
+}
+
+// set moves the clock to t. A t up to clockSlack behind the current clock
+// is accepted without change; anything earlier is refused unless reset,
+// which the caller allows only with the master passphrase: a clock pushed
+// into the future could otherwise never come back.
+func (c *deviceClock) set(t time.Time, reset bool) (prev time.Time, stepped bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev = time.Now()
+	switch {
+	case t.Before(clockMin) || !t.Before(clockMax):
+		return prev, false, errClockImplausible
+	case reset && t.Before(prev.Add(-clockSlack)):
+		c.l.Warn("clock reset backwards with the master passphrase", "from", prev.UTC(), "to", t.UTC())
+	case t.Before(prev.Add(-clockSlack)):
+		return prev, false, fmt.Errorf("%w: host %s, gadget %s", errClockBackwards,
+			t.UTC().Format(time.RFC3339), prev.UTC().Format(time.RFC3339))
+	case t.Before(prev.Add(clockSlack)):
+		return prev, false, nil
+	}
+
+	if err := setSystemClock(t); err != nil {
+		return prev, false, fmt.Errorf("set_time: %w", err)
+	}
+	if err := os.WriteFile(c.path, []byte(t.UTC().Format(time.RFC3339Nano)+"\n"), 0o600); err != nil {
+		c.l.Warn("clock: save last time", "err", err)
+	}
+	c.l.Info("clock set by host", "from", prev.UTC(), "to", t.UTC())
+	return prev, true, nil
+}
+
+func setSystemClock(t time.Time) error {
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return unix.ClockSettime(unix.CLOCK_REALTIME, &ts)
+}
@@ -6,3 +6,11 @@ var (
 	EnabledSock = "/tmp/tezsign.enabled"
 	ReadySock   = "/tmp/tezsign.ready"
 )
+
+// Bytes the registrar writes on EnabledSock connections. Closing the
+// connection means the function was disabled or unbound.
+const (
+	LinkSuspended byte = 'S' // host suspended the bus; endpoints stall until resume
+	LinkResumed   byte = 'R'
+	LinkReset     byte = 'X' // host asked to reopen the endpoints (vendor request 0x5F)
+)
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/logging"
+)
+
+const (
+	// envConfig points at the gadget config file; defaultConfigPath otherwise.
+	envConfig         = "TEZSIGN_CONFIG"
+	defaultConfigPath = "/data/tezsign.yaml"
+
+	// envSignRate caps signatures per key and kind per minute; 0 = no cap.
+	envSignRate = "TEZSIGN_SIGN_RATE"
+	// defaultSignRate leaves ample room above the Tenderbake cadence of a
+	// few levels per minute, each with at most a few rounds.
+	defaultSignRate = 60
+)
+
+// configEnv maps config keys to the environment variables they stand in
+// for. A variable that is already set wins over the file.
+var configEnv = map[string]string{
+	"log_level":             "LOG_LEVEL",
+	"log_levels":            "LOG_LEVELS",
+	"auto_lock_after":       envAutoLock,
+	"sign_rate_limit":       envSignRate,
+	"features.display":      envDisplay,
+	"features.button":       envButton,
+	"features.led":          envLED,
+	"features.tamper_gpio":  envTamperGPIO,
+	"features.vault":        envVault,
+	"features.audit_export": envAuditExport,
+}
+
+// gadgetConfig is the YAML config file. Keys marked reloadable take effect
+// on SIGHUP; the rest are read once at startup.
+//
+//	log_level: info               # reloadable
+//	log_levels: broker=debug      # reloadable; per component: broker, keychain
+//	auto_lock_after: 10m          # reloadable
+//	handler_timeout: 30s          # reloadable; 0 = none
+//	max_concurrent_requests: 8    # 0 = unlimited
+//	broker_workers: 4             # per interface; 0 = unlimited
+//	sign_rate_limit: 60           # reloadable; per key and kind per minute, 0 = none
+//
+//	features:
+//	  display: ssd1306
+//	  button: 27,active_low
+//	  led: ACT
+//	  tamper_gpio: 17,active_low
+//	  vault: true
+//	  audit_export: true
+type gadgetConfig struct {
+	path   string
+	values map[string]string
+
+	// checked by loadConfig
+	reload        settings
+	maxRequests   int
+	brokerWorkers int
+}
+
+// settings are the reloadable values of a config file. They are checked
+// as a whole before any of them is applied.
+type settings struct {
+	level          *slog.Level // nil = leave as is
+	levels         *string     // nil = leave as is
+	handlerTimeout time.Duration
+	autoLockAfter  time.Duration
+	signRate       int
+}
+
+func configPath() string {
+	if p := strings.TrimSpace(os.Getenv(envConfig)); p != "" {
+		return p
+	}
+	return defaultConfigPath
+}
+
+// loadConfig reads and checks the config file; a missing file is an empty
+// config. Any invalid value fails the whole file.
+func loadConfig(path string) (*gadgetConfig, error) {
+	cfg := &gadgetConfig{path: path, values: map[string]string{}}
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		if cfg.values, err = parseConfig(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// check parses the settings of c, collecting every invalid one.
+func (c *gadgetConfig) check() error {
+	var errs []error
+	if v, ok := c.value("log_level"); ok {
+		if lvl, ok := logging.ParseLevel(v); ok {
+			c.reload.level = &lvl
+		} else {
+			errs = append(errs, fmt.Errorf("log_level: unknown level %q", v))
+		}
+	}
+	if v, ok := c.value("log_levels"); ok {
+		// a scratch Levels only to validate the spec
+		if err := logging.NewLevels(new(slog.LevelVar)).Set(v); err != nil {
+			errs = append(errs, fmt.Errorf("log_levels: %w", err))
+		} else {
+			c.reload.levels = &v
+		}
+	}
+	var err error
+	if c.reload.handlerTimeout, err = c.duration("handler_timeout"); err != nil {
+		errs = append(errs, err)
+	}
+	if c.reload.autoLockAfter, err = c.duration("auto_lock_after"); err != nil {
+		errs = append(errs, err)
+	}
+	c.reload.signRate = defaultSignRate
+	if _, set := c.value("sign_rate_limit"); set {
+		if c.reload.signRate, err = c.int("sign_rate_limit"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.maxRequests, err = c.int("max_concurrent_requests"); err != nil {
+		errs = append(errs, err)
+	}
+	if c.brokerWorkers, err = c.int("broker_workers"); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// value returns a setting, preferring its environment variable.
+func (c *gadgetConfig) value(key string) (string, bool) {
+	if env, ok := configEnv[key]; ok {
+		if v, set := os.LookupEnv(env); set {
+			return v, true
+		}
+	}
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// exportEnv sets the environment variables of configured features that are
+// not set already, so the components that read them pick the file up.
+// Reloadable settings are left out: apply reads them from the file.
+func (c *gadgetConfig) exportEnv() {
+	for key, env := range configEnv {
+		if !strings.HasPrefix(key, "features.") {
+			continue
+		}
+		if v, ok := c.values[key]; ok {
+			if _, set := os.LookupEnv(env); !set {
+				_ = os.Setenv(env, v)
+			}
+		}
+	}
+}
+
+func (c *gadgetConfig) duration(key string) (time.Duration, error) {
+	v, ok := c.value(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+func (c *gadgetConfig) int(key string) (int, error) {
+	v, ok := c.value(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: invalid number %q", key, v)
+	}
+	return n, nil
+}
+
+// runtimeSettings are the reloadable values shared with running code.
+type runtimeSettings struct {
+	level          *slog.LevelVar
+	levels         *logging.Levels
+	handlerTimeout atomic.Int64  // time.Duration
+	requests       chan struct{} // nil = unlimited; fixed at startup
+	brokerWorkers  int           // per interface, 0 = unlimited; fixed at startup
+}
+
+// newRuntimeSettings sets up the startup-only settings; run applies the
+// reloadable ones.
+func newRuntimeSettings(level *slog.LevelVar, levels *logging.Levels, cfg *gadgetConfig) *runtimeSettings {
+	rs := &runtimeSettings{level: level, levels: levels, brokerWorkers: cfg.brokerWorkers}
+	if cfg.maxRequests > 0 {
+		rs.requests = make(chan struct{}, cfg.maxRequests)
+	}
+	return rs
+}
+
+// apply installs the reloadable settings of cfg, which loadConfig checked.
+func (rs *runtimeSettings) apply(cfg *gadgetConfig, al *autoLocker, kr *keychain.KeyRing) {
+	s := cfg.reload
+	if s.level != nil {
+		rs.level.Set(*s.level)
+	}
+	if s.levels != nil {
+		_ = rs.levels.Set(*s.levels) // checked by loadConfig
+	}
+	rs.handlerTimeout.Store(int64(s.handlerTimeout))
+	al.setAfter(s.autoLockAfter)
+	kr.SetSignRate(s.signRate)
+}
+
+// parseConfig reads a YAML mapping of scalars, nested one level for
+// features, into strings keyed "table.key".
+func parseConfig(r io.Reader) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	out := map[string]string{}
+	if err := flattenConfig(doc.Content[0], "", out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func flattenConfig(n *yaml.Node, prefix string, out map[string]string) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected key: value pairs", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		key := prefix + k.Value
+		switch {
+		case v.Kind == yaml.MappingNode && prefix == "":
+			if err := flattenConfig(v, key+".", out); err != nil {
+				return err
+			}
+		case v.Kind == yaml.ScalarNode:
+			if _, dup := out[key]; dup {
+				return fmt.Errorf("line %d: duplicate key %q", k.Line, key)
+			}
+			out[key] = v.Value
+		default:
+			return fmt.Errorf("line %d: %s: expected a single value", v.Line, key)
+		}
+	}
+	return nil
+}
+
+// reloadOnSIGHUP re-reads the config file on SIGHUP and applies its
+// reloadable settings. A file with any invalid value changes nothing.
+func reloadOnSIGHUP(path string, rs *runtimeSettings, al *autoLocker, kr *keychain.KeyRing, l *slog.Logger) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			l.Error("config reload; keeping the current settings", "err", err)
+			continue
+		}
+		rs.apply(cfg, al, kr)
+		l.Info("config reloaded", "path", path)
+	}
+}
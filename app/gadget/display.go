@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+const (
+	// envDisplay selects an I2C OLED: "<model>[,<bus>[,<addr>]]", e.g.
+	// "ssd1306" or "sh1106,/dev/i2c-3,0x3c". Unset disables the display.
+	envDisplay = "TEZSIGN_DISPLAY"
+
+	defaultDisplayBus  = "/dev/i2c-1"
+	defaultDisplayAddr = 0x3c
+
+	displayRefresh = 5 * time.Second
+	displayCols    = 128 / 6 // 5px glyph + 1px spacing
+	displayRows    = 64 / 8
+
+	i2cSlave = 0x0703 // ioctl: set the target address on /dev/i2c-N
+)
+
+// oledDisplay is a 128x64 SSD1306/SH1106 on i2c-dev. The panel is redrawn
+// only when the text changes. A nil *oledDisplay does nothing.
+type oledDisplay struct {
+	f      *os.File
+	sh1106 bool // no horizontal addressing; 132-column RAM, 2px offset
+	l      *slog.Logger
+}
+
+// newOLEDDisplay opens the display configured in TEZSIGN_DISPLAY. Failures
+// are logged and leave the gadget running without a display.
+func newOLEDDisplay(l *slog.Logger) *oledDisplay {
+	spec := strings.TrimSpace(os.Getenv(envDisplay))
+	if spec == "" {
+		return nil
+	}
+	d, err := openOLED(spec, l)
+	if err != nil {
+		l.Warn("display disabled", "spec", spec, "err", err)
+		return nil
+	}
+	l.Info("display enabled", "spec", spec)
+	return d
+}
+
+// displayBus returns the I2C device named in a TEZSIGN_DISPLAY spec.
+func displayBus(spec string) string {
+	if parts := strings.Split(spec, ","); len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+		return strings.TrimSpace(parts[1])
+	}
+	return defaultDisplayBus
+}
+
+func openOLED(spec string, l *slog.Logger) (*oledDisplay, error) {
+	parts := strings.Split(spec, ",")
+	model := strings.ToLower(strings.TrimSpace(parts[0]))
+	if model != "ssd1306" && model != "sh1106" {
+		return nil, fmt.Errorf("unsupported model %q (want ssd1306 or sh1106)", model)
+	}
+	bus, addr := displayBus(spec), uint64(defaultDisplayAddr)
+	if len(parts) > 2 {
+		v, err := strconv.ParseUint(strings.TrimSpace(parts[2]), 0, 7)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", parts[2])
+		}
+		addr = v
+	}
+
+	f, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(addr)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("set i2c address 0x%02x: %w", addr, errno)
+	}
+
+	d := &oledDisplay{f: f, sh1106: model == "sh1106", l: l}
+	if err := d.init(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("init: %w", err)
+	}
+	return d, nil
+}
+
+func (d *oledDisplay) command(cmds ...byte) error {
+	_, err := d.f.Write(append([]byte{0x00}, cmds...))
+	return err
+}
+
+func (d *oledDisplay) data(b []byte) error {
+	_, err := d.f.Write(append([]byte{0x40}, b...))
+	return err
+}
+
+func (d *oledDisplay) init() error {
+	return d.command(
+		0xAE,       // display off
+		0xD5, 0x80, // clock divide
+		0xA8, 0x3F, // multiplex 64
+		0xD3, 0x00, // display offset
+		0x40,       // start line 0
+		0x8D, 0x14, // charge pump on (ignored by SH1106)
+		0x20, 0x00, // horizontal addressing (ignored by SH1106)
+		0xA1,       // segment remap
+		0xC8,       // COM scan descending
+		0xDA, 0x12, // COM pins
+		0x81, 0xCF, // contrast
+		0xD9, 0xF1, // pre-charge
+		0xDB, 0x40, // VCOMH
+		0xA4, // follow RAM
+		0xA6, // normal (not inverted)
+		0xAF, // display on
+	)
+}
+
+// draw renders up to displayRows lines of text.
+func (d *oledDisplay) draw(lines []string) error {
+	var fb [displayRows][128]byte
+	for row, line := range lines {
+		if row >= displayRows {
+			break
+		}
+		for col, r := range []rune(line) {
+			if col >= displayCols {
+				break
+			}
+			if r < 0x20 || r > 0x7E {
+				r = '?'
+			}
+			copy(fb[row][col*6:], font5x7[r-0x20][:])
+		}
+	}
+
+	if !d.sh1106 {
+		if err := d.command(0x21, 0, 127, 0x22, 0, displayRows-1); err != nil {
+			return err
+		}
+	}
+	for page := range fb {
+		if d.sh1106 {
+			if err := d.command(0xB0|byte(page), 0x02, 0x10); err != nil {
+				return err
+			}
+		}
+		if err := d.data(fb[page][:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run refreshes the display from the key ring until ctx ends.
+func (d *oledDisplay) run(ctx context.Context, kr *keychain.KeyRing) {
+	if d == nil {
+		return
+	}
+	defer d.f.Close()
+
+	t := time.NewTicker(displayRefresh)
+	defer t.Stop()
+
+	var shown []string
+	for {
+		lines := displayLines(kr.Status())
+		if !slices.Equal(lines, shown) {
+			if err := d.draw(lines); err != nil {
+				d.l.Warn("display write failed", "err", err)
+			} else {
+				shown = lines
+			}
+		}
+		select {
+		case <-ctx.Done():
+			_ = d.command(0xAE)
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// displayLines lays out the version and, per key, the short tz4 with its
+// lock state and the most recent signed level/round.
+func displayLines(keys []*signer.KeyStatus) []string {
+	lines := []string{"tezsign " + version}
+	slices.SortFunc(keys, func(a, b *signer.KeyStatus) int { return strings.Compare(a.GetKeyId(), b.GetKeyId()) })
+
+	for i, k := range keys {
+		if len(lines)+2 > displayRows {
+			lines = append(lines, fmt.Sprintf("+%d more", len(keys)-i))
+			break
+		}
+		state := "LOCK"
+		switch {
+		case k.GetLockState() == signer.LockState_TAMPERED:
+			state = "TAMP"
+		case k.GetStateCorrupted():
+			state = "CORR"
+		case k.GetLockState() == signer.LockState_UNLOCKED:
+			state = "UNLK"
+		}
+		lines = append(lines, shortTz4(k.GetTz4())+" "+state)
+
+		level, round := k.GetLastBlockLevel(), k.GetLastBlockRound()
+		for _, w := range []struct {
+			level uint64
+			round uint32
+		}{
+			{k.GetLastPreattestationLevel(), k.GetLastPreattestationRound()},
+			{k.GetLastAttestationLevel(), k.GetLastAttestationRound()},
+		} {
+			if w.level > level || (w.level == level && w.round > round) {
+				level, round = w.level, w.round
+			}
+		}
+		lines = append(lines, fmt.Sprintf(" L%d R%d", level, round))
+	}
+	return lines
+}
+
+// shortTz4 keeps the first 7 and last 4 characters: "tz4AbCd..WxYz".
+func shortTz4(tz4 string) string {
+	if len(tz4) <= 13 {
+		return tz4
+	}
+	return tz4[:7] + ".." + tz4[len(tz4)-4:]
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tez-capital/tezsign/keychain"
+)
+
+const (
+	// envEpochCounter keeps a coarse copy of the highest signed level in
+	// memory that survives a swapped data partition:
+	//
+	//	nvram:<path>[@<offset>]        battery-backed RAM (e.g. RTC NVRAM via nvmem)
+	//	otp:<path>@<offset>+<bytes>    one-time-programmable bits, one per epoch
+	//
+	// <path> is typically /sys/bus/nvmem/devices/<name>/nvmem.
+	envEpochCounter = "TEZSIGN_EPOCH_COUNTER"
+	// envEpochLevels is the epoch size in levels.
+	envEpochLevels = "TEZSIGN_EPOCH_LEVELS"
+
+	// Defaults: NVRAM may be rewritten often; fuses are burnt once each.
+	// A step of 2^18 levels is about 24 days at 8 s blocks, so a byte of
+	// counter bits lasts about six months past the base (less with
+	// shorter blocks). Mainnet is dozens of steps in already, which is
+	// why the OTP counter counts from a base rather than from 0.
+	nvramEpochLevels = 4096
+	otpEpochLevels   = 1 << 18
+
+	nvramRecordSize = 16 // magic, epoch (LE), CRC-32 of both
+	otpBaseSize     = 4  // base epoch (LE) ahead of the counter bits
+)
+
+var (
+	nvramMagic = []byte("TZEP")
+
+	errOTPGap       = errors.New("otp: set bit after a clear one (corrupted or tampered)")
+	errOTPExhausted = errors.New("otp: no bits left")
+)
+
+type epochSpec struct {
+	mode   string // "nvram" or "otp"
+	path   string
+	offset int64
+	size   int // otp region bytes
+}
+
+func parseEpochSpec(v string) (epochSpec, error) {
+	var s epochSpec
+	mode, rest, ok := strings.Cut(strings.TrimSpace(v), ":")
+	if !ok || rest == "" {
+		return s, fmt.Errorf("%s: want <mode>:<path>[@<offset>[+<bytes>]]", envEpochCounter)
+	}
+	s.mode = mode
+	path, loc, hasLoc := strings.Cut(rest, "@")
+	s.path = path
+	if hasLoc {
+		off, size, hasSize := strings.Cut(loc, "+")
+		n, err := strconv.ParseInt(off, 0, 64)
+		if err != nil || n < 0 {
+			return s, fmt.Errorf("%s: invalid offset %q", envEpochCounter, off)
+		}
+		s.offset = n
+		if hasSize {
+			if s.size, err = strconv.Atoi(size); err != nil || s.size <= 0 {
+				return s, fmt.Errorf("%s: invalid size %q", envEpochCounter, size)
+			}
+		}
+	}
+	switch {
+	case mode != "nvram" && mode != "otp":
+		return s, fmt.Errorf("%s: unknown mode %q (want nvram or otp)", envEpochCounter, mode)
+	case mode == "otp" && s.size == 0:
+		return s, fmt.Errorf("%s: otp needs @<offset>+<bytes>", envEpochCounter)
+	case mode == "otp" && s.size <= otpBaseSize:
+		return s, fmt.Errorf("%s: otp needs more than %d bytes", envEpochCounter, otpBaseSize)
+	}
+	return s, nil
+}
+
+// setupEpochCounter attaches the configured counter to kr. A configured
+// counter that cannot be read stops the gadget: running without it would
+// silently drop the protection.
+func setupEpochCounter(kr *keychain.KeyRing, l *slog.Logger) error {
+	v := strings.TrimSpace(os.Getenv(envEpochCounter))
+	if v == "" {
+		return nil
+	}
+	spec, err := parseEpochSpec(v)
+	if err != nil {
+		return err
+	}
+
+	var c keychain.EpochCounter
+	levels := uint64(nvramEpochLevels)
+	if spec.mode == "otp" {
+		c, levels = &otpCounter{path: spec.path, offset: spec.offset, size: spec.size}, otpEpochLevels
+	} else {
+		c = &nvramCounter{path: spec.path, offset: spec.offset, l: l}
+	}
+	if s := strings.TrimSpace(os.Getenv(envEpochLevels)); s != "" {
+		if levels, err = strconv.ParseUint(s, 10, 64); err != nil || levels == 0 {
+			return fmt.Errorf("%s: invalid value %q", envEpochLevels, s)
+		}
+	}
+	if err := kr.SetEpochCounter(c, levels); err != nil {
+		return err
+	}
+	l.Info("epoch counter enabled", "mode", spec.mode, "path", spec.path, "levels_per_epoch", levels, "floor", kr.EpochFloor())
+	return nil
+}
+
+func readAt(path string, off int64, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeAt(path string, off int64, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, off); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nvramCounter stores the epoch in a small checksummed record. Battery-
+// backed RAM forgets everything when the battery dies; a missing or broken
+// record therefore reads as 0 with a warning rather than failing.
+type nvramCounter struct {
+	path   string
+	offset int64
+	l      *slog.Logger
+}
+
+func (c *nvramCounter) Load() (uint64, error) {
+	rec, err := readAt(c.path, c.offset, nvramRecordSize)
+	if err != nil {
+		return 0, err
+	}
+	sum := binary.LittleEndian.Uint32(rec[12:])
+	if !bytes.Equal(rec[:4], nvramMagic) || crc32.ChecksumIEEE(rec[:12]) != sum {
+		c.l.Warn("epoch counter record missing or damaged; starting from 0", "path", c.path)
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint64(rec[4:12]), nil
+}
+
+func (c *nvramCounter) Advance(to uint64) error {
+	cur, err := c.Load()
+	if err != nil {
+		return err
+	}
+	if to <= cur {
+		return nil
+	}
+	rec := make([]byte, nvramRecordSize)
+	copy(rec, nvramMagic)
+	binary.LittleEndian.PutUint64(rec[4:12], to)
+	binary.LittleEndian.PutUint32(rec[12:], crc32.ChecksumIEEE(rec[:12]))
+	return writeAt(c.path, c.offset, rec)
+}
+
+// otpCounter is a unary counter over write-once bits. The region starts
+// with the base epoch, burnt on the first advance, i.e. on the device's
+// first signature; the epoch is the base plus the number of set bits
+// after it, filled from bit 0 of the first byte. Burning more bits into
+// the base can only raise it, like the counter.
+type otpCounter struct {
+	path   string
+	offset int64
+	size   int
+}
+
+func (c *otpCounter) Load() (uint64, error) {
+	region, err := readAt(c.path, c.offset, c.size)
+	if err != nil {
+		return 0, err
+	}
+	n, err := otpCount(region[otpBaseSize:])
+	if err != nil {
+		return 0, err
+	}
+	return uint64(binary.LittleEndian.Uint32(region)) + n, nil
+}
+
+// otpCount counts the set bits of a unary counter.
+func otpCount(region []byte) (uint64, error) {
+	var n uint64
+	for i, b := range region {
+		ones := bits.TrailingZeros8(^b) // set bits from bit 0
+		n += uint64(ones)
+		if ones < 8 {
+			if b>>ones != 0 || !allZero(region[i+1:]) {
+				return 0, errOTPGap
+			}
+			break
+		}
+	}
+	return n, nil
+}
+
+func (c *otpCounter) Advance(to uint64) error {
+	region, err := readAt(c.path, c.offset, c.size)
+	if err != nil {
+		return err
+	}
+	base := uint64(binary.LittleEndian.Uint32(region))
+	n, err := otpCount(region[otpBaseSize:])
+	if err != nil {
+		return err
+	}
+	if to <= base+n {
+		return nil
+	}
+	if base == 0 && n == 0 {
+		// first advance: record the base, no counter bit is spent
+		if to > math.MaxUint32 {
+			return fmt.Errorf("otp: base epoch %d does not fit", to)
+		}
+		binary.LittleEndian.PutUint32(region, uint32(to))
+		return writeAt(c.path, c.offset, region[:otpBaseSize])
+	}
+	bitsLeft := uint64(c.size-otpBaseSize) * 8
+	if to-base > bitsLeft {
+		return errOTPExhausted
+	}
+	counter := make([]byte, c.size-otpBaseSize)
+	for i := uint64(0); i < to-base; i++ {
+		counter[i/8] |= 1 << (i % 8)
+	}
+	return writeAt(c.path, c.offset+otpBaseSize, counter)
+}
+
+func allZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -2,12 +2,47 @@ package main
 
 const (
 	rpcUnlockThrottled uint32 = 12
+	rpcTampered        uint32 = 13
+	rpcVaultOpen       uint32 = 14
+
+	rpcNewKeysBadHDIndex uint32 = 40 // hd_index with other than one key_id
+
+	rpcPopFailed uint32 = 100
+
+	rpcTelemetryNoChannel uint32 = 130
+
+	rpcAuditUnavailable uint32 = 140
+	rpcAuditFailed      uint32 = 141
+
+	rpcAttestBadNonce uint32 = 150
+	rpcAttestFailed   uint32 = 151
+	rpcIdentityLocked uint32 = 152
+
+	rpcAckTamperNoPass    uint32 = 120
+	rpcAckTamperThrottled uint32 = 121
+	rpcAckTamperBadPass   uint32 = 122
+	rpcAckTamperFailed    uint32 = 123
 
 	rpcKeyNotFound    uint32 = 31
 	rpcKeyLocked      uint32 = 32
 	rpcStaleWatermark uint32 = 33
 	rpcBadPayload     uint32 = 34
+	rpcRateLimited    uint32 = 35
 
 	rpcDeleteThrottled uint32 = 92
 	rpcDeleteBadPass   uint32 = 93
+
+	rpcSetTimeRefused   uint32 = 160
+	rpcSetTimeThrottled uint32 = 161
+	rpcSetTimeBadPass   uint32 = 162
+
+	rpcUpdateBeginFailed  uint32 = 110
+	rpcUpdateChunkFailed  uint32 = 111
+	rpcUpdateCommitFailed uint32 = 112
+	rpcUpdateDisabled     uint32 = 113
+	rpcUpdateBadSignature uint32 = 114
+
+	rpcBusy            uint32 = 1002 // no free worker within handler_timeout
+	rpcNotAdminRequest uint32 = 1003 // IF2 serves admin requests only
+	rpcNotConfirmed    uint32 = 1004 // the device button was not pressed
 )
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"os"
@@ -17,20 +17,58 @@ import (
 	"github.com/tez-capital/tezsign/keychain"
 	"github.com/tez-capital/tezsign/logging"
 	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/watchdog"
 	"google.golang.org/protobuf/proto"
 )
 
 const (
 	waitEndpointsTime = 30 * time.Second
 
+	// Broker supervision: rebuild with backoff, give up (and let systemd
+	// restart the app) after maxBrokerFailures in a row. A session that
+	// stayed up for brokerHealthyAfter resets the count.
+	maxBrokerFailures  = 5
+	brokerBackoffBase  = 500 * time.Millisecond
+	brokerBackoffMax   = 15 * time.Second
+	brokerHealthyAfter = time.Minute
+	brokerUnplugGrace  = time.Second
+
 	securedAttemptWindow = 30 * time.Second
 	securedAttemptLimit  = 5
+
+	// logRingLines are kept in memory for the Logs RPC.
+	logRingLines = 1000
 )
 
 var securedRPCLimiter = newAttemptLimiter(securedAttemptLimit, securedAttemptWindow)
 
+var errBrokerDown = errors.New("transport failed")
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Println(version)
+		return
+	}
+	if len(os.Args) == 4 && os.Args[1] == "--verify-update" {
+		if err := verifyUpdateFile(os.Args[2], os.Args[3]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The config file fills in settings not given in the environment.
+	cfg, cfgErr := loadConfig(configPath())
+	if cfgErr == nil {
+		cfg.exportEnv()
+	}
+
 	logCfg := logging.NewConfigFromEnv()
+	level := new(slog.LevelVar)
+	level.Set(logCfg.Level)
+	logCfg.Leveler = level
+	levels := logging.NewLevels(level)
+	logCfg.Levels = levels
 	if logCfg.File == "" {
 		dataStore := strings.TrimSpace(os.Getenv("DATA_STORE"))
 		if dataStore != "" {
@@ -43,6 +81,11 @@ func main() {
 		}
 	}
 
+	// the Logs RPC falls back to these when LOG_FILE=none spares the card
+	if os.Getenv("LOG_RING_LINES") == "" {
+		logCfg.RingLines = logRingLines
+	}
+
 	if err := logging.EnsureDir(logCfg.File); err != nil {
 		panic("Could not create dir for path of configuration file!")
 	}
@@ -50,9 +93,17 @@ func main() {
 	l, _ := logging.New(logCfg)
 
 	l.Debug("logging to file", "path", logging.CurrentFile())
-
-	if err := run(l); err != nil {
+	err := cfgErr
+	if err != nil {
+		err = fmt.Errorf("config: %w", err)
+	} else {
+		err = run(l, cfg, newRuntimeSettings(level, levels, cfg))
+	}
+	if err != nil {
 		l.Error("RUN ERROR", slog.Any("err", err))
+		n := watchdog.New()
+		_ = n.Status("failed: " + err.Error())
+		_ = n.Errno(err)
 		os.Exit(1)
 	}
 }
@@ -64,7 +115,8 @@ func handleSignAndStatus(base func(context.Context, []byte) ([]byte, error)) bro
 			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
 		}
 		switch req.Payload.(type) {
-		case *signer.Request_Sign, *signer.Request_Status:
+		case *signer.Request_Sign, *signer.Request_Status, *signer.Request_Pop, *signer.Request_Health, *signer.Request_Telemetry,
+			*signer.Request_Attest:
 			// allowed on IF0
 		default:
 			return marshalErr(98, "wrong interface: use management (IF1) for this request"), nil
@@ -74,6 +126,40 @@ func handleSignAndStatus(base func(context.Context, []byte) ([]byte, error)) bro
 	}
 }
 
+// handleWithLimits applies max_concurrent_requests and handler_timeout.
+// The timeout bounds the wait for a free worker and is the deadline of the
+// request's context; the reply is always the handler's own, and the worker
+// stays taken until the handler returns. Sign requests get no deadline: a
+// sign answered as timed out could still be signed.
+func handleWithLimits(rs *runtimeSettings, base broker.Handler) broker.Handler {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		if timeout := time.Duration(rs.handlerTimeout.Load()); timeout > 0 && !isSignRequest(payload) {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if rs.requests != nil {
+			select {
+			case rs.requests <- struct{}{}:
+				defer func() { <-rs.requests }()
+			case <-ctx.Done():
+				return marshalErr(rpcBusy, "request timed out waiting for a free worker"), nil
+			}
+		}
+		return base(ctx, payload)
+	}
+}
+
+func isSignRequest(payload []byte) bool {
+	var req signer.Request
+	if err := proto.Unmarshal(payload, &req); err != nil {
+		return false
+	}
+	defer wipeReq(&req)
+	_, ok := req.Payload.(*signer.Request_Sign)
+	return ok
+}
+
 func handleMgmtOnly(base func(context.Context, []byte) ([]byte, error)) broker.Handler {
 	return func(ctx context.Context, payload []byte) ([]byte, error) {
 		var req signer.Request
@@ -88,8 +174,33 @@ func handleMgmtOnly(base func(context.Context, []byte) ([]byte, error)) broker.H
 	}
 }
 
-func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog.Logger) broker.Handler {
+// handleAdminOnly serves the admin channel (IF2): logs, health and app
+// updates, so bulk transfers never queue behind or in front of signing.
+func handleAdminOnly(base func(context.Context, []byte) ([]byte, error)) broker.Handler {
 	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		var req signer.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
+		}
+		switch req.Payload.(type) {
+		case *signer.Request_Logs, *signer.Request_Health, *signer.Request_Telemetry,
+			*signer.Request_Audit, *signer.Request_AuditVerify, *signer.Request_SetTime, *signer.Request_Attest,
+			*signer.Request_UpdateBegin, *signer.Request_UpdateChunk, *signer.Request_UpdateCommit:
+			// allowed on IF2
+		default:
+			return marshalErr(rpcNotAdminRequest, "wrong interface: use management (IF1) for this request"), nil
+		}
+		return base(ctx, payload)
+	}
+}
+
+func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, upd *appUpdater, hm *healthMonitor, vault *keystoreVault, signLog *auditLog, ident *deviceIdentity, clk *deviceClock, l *slog.Logger) broker.Handler {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		l := l
+		if id, ok := broker.RequestID(ctx); ok {
+			l = l.With("req_id", hex.EncodeToString(id[:]))
+		}
+
 		var req signer.Request
 		if err := proto.Unmarshal(payload, &req); err != nil {
 			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
@@ -103,12 +214,15 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			defer keychain.MemoryWipe(pass)
 
 			ids := p.Unlock.GetKeyIds()
-			if len(ids) == 0 {
+			if len(ids) == 0 && !vault.sealed() {
 				return marshalErr(10, "unlock: no key_ids provided"), nil
 			}
 			if len(pass) == 0 {
 				return marshalErr(11, "unlock: passphrase required"), nil
 			}
+			if kr.Tampered() {
+				return marshalErr(rpcTampered, keychain.ErrTampered.Error()), nil
+			}
 			if ok, wait := securedRPCLimiter.Allow(); !ok {
 				l.Warn("unlock throttled", slog.Duration("retry_in", wait))
 				msg := fmt.Sprintf(
@@ -119,8 +233,21 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				)
 				return marshalErr(rpcUnlockThrottled, msg), nil
 			}
+			if vault.sealed() {
+				if err := openVault(vault, fs, pass, false); err != nil {
+					l.Error("unlock: open vault", "err", err)
+					return marshalErr(rpcVaultOpen, "unlock: "+err.Error()), nil
+				}
+				l.Info("vault opened")
+			}
+			if len(ids) == 0 { // a sealed vault hid the key list; unlock them all
+				for _, k := range kr.Status() {
+					ids = append(ids, k.GetKeyId())
+				}
+			}
 
 			results := make([]*signer.PerKeyResult, 0, len(ids))
+			verified := false
 			for _, id := range ids {
 				res := &signer.PerKeyResult{KeyId: id}
 				if err := kr.Unlock(id, pass); err != nil {
@@ -128,11 +255,17 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 					res.Error = err.Error()
 					l.Error("unlock", "key", id, "err", err)
 				} else {
-					res.Ok = true
+					res.Ok, verified = true, true
 					l.Debug("UNLOCKED " + id)
 				}
 				results = append(results, res)
 			}
+			// a key opened with pass, so it is the master passphrase
+			if verified && ident != nil {
+				if err := ident.unlock(fs, pass); err != nil {
+					l.Error("unlock: device identity", "err", err)
+				}
+			}
 
 			l.Debug("UNLOCK batch", "count", len(ids))
 
@@ -175,14 +308,16 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 
 			return proto.Marshal(&signer.Response{
 				Payload: &signer.Response_Status{
-					Status: &signer.StatusResponse{Keys: st},
+					Status: &signer.StatusResponse{Keys: st, VaultSealed: vault.sealed(), Release: imageRelease},
 				},
 			})
 
 		case *signer.Request_Sign:
 			tz4 := p.Sign.GetTz4()
 			sig, err := kr.SignAndUpdate(tz4, p.Sign.GetMessage())
+			signLog.recordSign(tz4, p.Sign.GetMessage(), err)
 			if err != nil {
+				l.Warn("SIGN failed", "tz4", tz4, "err", err)
 				switch {
 				case errors.Is(err, keychain.ErrKeyLocked):
 					return marshalErr(rpcKeyLocked, keychain.ErrKeyLocked.Error()), nil
@@ -192,6 +327,8 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 					return marshalErr(rpcStaleWatermark, keychain.ErrStaleWatermark.Error()), nil
 				case errors.Is(err, keychain.ErrBadPayload):
 					return marshalErr(rpcBadPayload, keychain.ErrBadPayload.Error()), nil
+				case errors.Is(err, keychain.ErrRateLimited):
+					return marshalErr(rpcRateLimited, keychain.ErrRateLimited.Error()), nil
 
 				default:
 					return marshalErr(30, "sign: "+err.Error()), nil
@@ -215,10 +352,18 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			if len(ids) == 0 {
 				ids = []string{""}
 			}
+			hdIndex := p.NewKeys.GetHdIndex()
+			if hdIndex != 0 && len(ids) != 1 {
+				return marshalErr(rpcNewKeysBadHDIndex, "new_keys: hd_index needs exactly one key_id"), nil
+			}
 
 			results := make([]*signer.NewKeyPerKeyResult, 0, len(ids))
 			for _, alias := range ids {
-				id, blPubkey, tz4, err := kr.CreateKey(alias, pass)
+				id, blPubkey, tz4, err := kr.CreateKeyAt(alias, pass, keychain.NewKeyOptions{
+					HDIndex:      hdIndex,
+					ReuseHDIndex: p.NewKeys.GetReuseHdIndex(),
+					Overwrite:    p.NewKeys.GetOverwrite(),
+				})
 				r := &signer.NewKeyPerKeyResult{
 					KeyId:    id,
 					BlPubkey: blPubkey,
@@ -297,16 +442,49 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				},
 			})
 
-		case *signer.Request_Logs:
-			path := logging.CurrentFile()
-			if path == "" {
-				return marshalErr(50, "logs: file logging not enabled"), nil
+		case *signer.Request_AckTamper:
+			pass := p.AckTamper.GetPassphrase()
+			defer keychain.MemoryWipe(pass)
+			if len(pass) == 0 {
+				return marshalErr(rpcAckTamperNoPass, "ack_tamper: passphrase required"), nil
+			}
+			if ok, wait := securedRPCLimiter.Allow(); !ok {
+				l.Warn("ack_tamper throttled", slog.Duration("retry_in", wait))
+				msg := fmt.Sprintf(
+					"ack_tamper throttled: retry in ~%s (max %d attempts per %s)",
+					wait.Round(time.Second),
+					securedAttemptLimit,
+					securedAttemptWindow,
+				)
+				return marshalErr(rpcAckTamperThrottled, msg), nil
+			}
+			if !kr.Tampered() {
+				return marshalOK(true), nil
+			}
+			if err := kr.VerifyMasterPassword(pass); err != nil {
+				l.Warn("ack_tamper: bad passphrase", slog.Any("err", err))
+				return marshalErr(rpcAckTamperBadPass, "ack_tamper: invalid passphrase"), nil
+			}
+			if err := kr.ClearTamper(); err != nil {
+				l.Error("ack_tamper", "err", err)
+				return marshalErr(rpcAckTamperFailed, "ack_tamper: "+err.Error()), nil
 			}
 
+			return marshalOK(true), nil
+
+		case *signer.Request_Logs:
 			lim := int(p.Logs.GetLimit())
-			lines, err := logging.TailLastLines(path, lim)
-			if err != nil {
-				return marshalErr(51, fmt.Sprintf("logs: %v", err)), nil
+			path := logging.CurrentFile()
+			var lines []string
+			if ring := logging.CurrentRing(); ring != nil && (p.Logs.GetMemory() || path == "") {
+				lines = ring.Last(lim)
+			} else if path == "" {
+				return marshalErr(50, "logs: file logging not enabled"), nil
+			} else {
+				var err error
+				if lines, err = logging.TailLastLines(path, lim); err != nil {
+					return marshalErr(51, fmt.Sprintf("logs: %v", err)), nil
+				}
 			}
 
 			return proto.Marshal(&signer.Response{
@@ -323,6 +501,13 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			if len(pass) == 0 {
 				return marshalErr(60, "init_master: passphrase required"), nil
 			}
+			if vault.sealed() && vault.exists() { // don't let init try passphrases
+				return marshalErr(61, "init_master: "+keychain.ErrMasterJSONAlreadyInitialized.Error()), nil
+			}
+			if err := openVault(vault, fs, pass, true); err != nil {
+				l.Error("init_master: open vault", "err", err)
+				return marshalErr(rpcVaultOpen, "init_master: "+err.Error()), nil
+			}
 
 			// master.json
 			if err := fs.InitMaster(); err != nil {
@@ -333,6 +518,11 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			if err := fs.WriteSeed(pass, det); err != nil {
 				return marshalErr(62, "init_master/seed: "+err.Error()), nil
 			}
+			if ident != nil {
+				if err := ident.unlock(fs, pass); err != nil {
+					l.Error("init_master: device identity", "err", err)
+				}
+			}
 
 			return marshalOK(true), nil
 
@@ -341,6 +531,9 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			if e != nil {
 				return marshalErr(70, "init_info: "+e.Error()), nil
 			}
+			if vault.sealed() && vault.exists() {
+				master = true // master.json is inside; det is unknown until unlock
+			}
 
 			return proto.Marshal(&signer.Response{
 				Payload: &signer.Response_InitInfo{
@@ -359,22 +552,180 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 
 			return marshalOK(true), nil
 
+		case *signer.Request_Pop:
+			tz4 := p.Pop.GetTz4()
+			pop, err := kr.ProvePossession(tz4)
+			if err != nil {
+				l.Warn("POP failed", "tz4", tz4, "err", err)
+				switch {
+				case errors.Is(err, keychain.ErrKeyLocked):
+					return marshalErr(rpcKeyLocked, keychain.ErrKeyLocked.Error()), nil
+				case errors.Is(err, keychain.ErrKeyNotFound):
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				default:
+					return marshalErr(rpcPopFailed, "pop: "+err.Error()), nil
+				}
+			}
+
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_Pop{
+					Pop: &signer.PopResponse{Pop: pop},
+				},
+			})
+
+		case *signer.Request_UpdateBegin:
+			if err := upd.begin(p.UpdateBegin); err != nil {
+				l.Warn("UPDATE begin", "err", err)
+				if errors.Is(err, errUpdateDisabled) {
+					return marshalErr(rpcUpdateDisabled, err.Error()), nil
+				}
+				return marshalErr(rpcUpdateBeginFailed, err.Error()), nil
+			}
+			l.Info("UPDATE begin", "size", p.UpdateBegin.GetSize())
+			return marshalOK(true), nil
+
+		case *signer.Request_UpdateChunk:
+			if err := upd.chunk(p.UpdateChunk); err != nil {
+				l.Warn("UPDATE chunk", "err", err)
+				return marshalErr(rpcUpdateChunkFailed, err.Error()), nil
+			}
+			return marshalOK(true), nil
+
+		case *signer.Request_UpdateCommit:
+			newVersion, err := upd.commit(ctx)
+			if err != nil {
+				l.Error("UPDATE commit", "err", err)
+				if errors.Is(err, errUpdateVerify) {
+					return marshalErr(rpcUpdateBadSignature, err.Error()), nil
+				}
+				return marshalErr(rpcUpdateCommitFailed, err.Error()), nil
+			}
+			l.Info("UPDATE staged", "version", newVersion, "previous", version)
+
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_UpdateCommit{
+					UpdateCommit: &signer.UpdateCommitResponse{
+						Version:         newVersion,
+						PreviousVersion: version,
+					},
+				},
+			})
+
+		case *signer.Request_Health:
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_Health{Health: hm.snapshot()},
+			})
+
+		case *signer.Request_Audit:
+			if signLog == nil {
+				return marshalErr(rpcAuditUnavailable, "audit: trail not available"), nil
+			}
+			limit := int(p.Audit.GetLimit())
+			if limit <= 0 {
+				limit = auditPageDefault
+			}
+			limit = min(limit, auditPageMax)
+			recs, more, err := signLog.records(p.Audit.GetAfterSeq(), limit)
+			if err != nil {
+				return marshalErr(rpcAuditFailed, "audit: "+err.Error()), nil
+			}
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_Audit{Audit: &signer.AuditResponse{Records: recs, More: more}},
+			})
+
+		case *signer.Request_Attest:
+			nonce := p.Attest.GetNonce()
+			if len(nonce) < signer.AttestNonceMin || len(nonce) > signer.AttestNonceMax {
+				return marshalErr(rpcAttestBadNonce, fmt.Sprintf("attest: nonce must be %d..%d bytes", signer.AttestNonceMin, signer.AttestNonceMax)), nil
+			}
+			if ident == nil {
+				return marshalErr(rpcAttestFailed, "attest: device identity not available"), nil
+			}
+			res, err := ident.attest(nonce)
+			if errors.Is(err, errIdentityLocked) {
+				return marshalErr(rpcIdentityLocked, "attest: "+err.Error()), nil
+			}
+			if err != nil {
+				l.Error("attest", "err", err)
+				return marshalErr(rpcAttestFailed, "attest: "+err.Error()), nil
+			}
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_Attest{Attest: res},
+			})
+
+		case *signer.Request_AuditVerify:
+			if signLog == nil {
+				return marshalErr(rpcAuditUnavailable, "audit: trail not available"), nil
+			}
+			v, err := signLog.verify()
+			res := &signer.AuditVerifyResponse{Ok: err == nil, FirstSeq: v.First, LastSeq: v.Last, HeadHash: v.Head[:]}
+			if err != nil {
+				l.Warn("audit trail verification failed", "err", err)
+				res.Error = err.Error()
+			}
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_AuditVerify{AuditVerify: res},
+			})
+
+		case *signer.Request_SetTime:
+			t := time.Unix(0, p.SetTime.GetUnixNanos())
+			pass := p.SetTime.GetPassphrase()
+			defer keychain.MemoryWipe(pass)
+			reset := len(pass) > 0
+			if reset {
+				if ok, wait := securedRPCLimiter.Allow(); !ok {
+					l.Warn("set_time throttled", slog.Duration("retry_in", wait))
+					msg := fmt.Sprintf(
+						"set_time throttled: retry in ~%s (max %d attempts per %s)",
+						wait.Round(time.Second),
+						securedAttemptLimit,
+						securedAttemptWindow,
+					)
+					return marshalErr(rpcSetTimeThrottled, msg), nil
+				}
+				if err := kr.VerifyMasterPassword(pass); err != nil {
+					l.Warn("set_time: bad passphrase", slog.Any("err", err))
+					return marshalErr(rpcSetTimeBadPass, "set_time: invalid passphrase"), nil
+				}
+			}
+			prev, stepped, err := clk.set(t, reset)
+			if err != nil {
+				l.Warn("set_time refused", "err", err)
+				return marshalErr(rpcSetTimeRefused, err.Error()), nil
+			}
+			return proto.Marshal(&signer.Response{
+				Payload: &signer.Response_SetTime{SetTime: &signer.SetTimeResponse{
+					PreviousUnixNanos: prev.UnixNano(),
+					Stepped:           stepped,
+				}},
+			})
+
+		case *signer.Request_Telemetry:
+			b, ok := broker.FromContext(ctx)
+			if !ok {
+				return marshalErr(rpcTelemetryNoChannel, "telemetry: no channel to push on"), nil
+			}
+			every := hm.subscribe(b, time.Duration(p.Telemetry.GetIntervalSeconds())*time.Second, l)
+			l.Debug("telemetry subscribed", "every", every)
+			return marshalOK(true), nil
+
 		default:
 			return marshalErr(1000, "unknown request"), nil
 		}
 	}
 }
 
-func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRing, l *slog.Logger) error {
+func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRing, upd *appUpdater, hm *healthMonitor, vault *keystoreVault, signLog *auditLog, ident *deviceIdentity, clk *deviceClock, rs *runtimeSettings, btn *confirmButton, l *slog.Logger) error {
 	l.Info("Waiting for endpoints...")
-	in0, out0, in1, out1, err := waitForFunctionFSEndpoints(common.FfsInstanceRoot, waitEndpointsTime)
+	eps, err := waitForFunctionFSEndpoints(common.FfsInstanceRoot, waitEndpointsTime)
 	if err != nil {
 		return err
 	}
 
 	l.Info("Endpoints ready; starting broker",
-		slog.String("IF0.in", in0), slog.String("IF0.out", out0),
-		slog.String("IF1.in", in1), slog.String("IF1.out", out1),
+		slog.String("IF0.in", eps.in0), slog.String("IF0.out", eps.out0),
+		slog.String("IF1.in", eps.in1), slog.String("IF1.out", eps.out1),
+		slog.String("IF2.in", eps.in2), slog.String("IF2.out", eps.out2),
 	)
 
 	select {
@@ -383,31 +734,45 @@ func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRin
 	default:
 	}
 
-	bLogger := broker.WithLogger(l.With("component", "broker"))
+	bLogger := broker.WithLogger(l.With(logging.ComponentKey, "broker"))
+	bWorkers := broker.WithWorkers(rs.brokerWorkers)
 	// IF0 (sign) endpoints
-	in0Fd, err := os.OpenFile(in0, os.O_WRONLY, 0) // device -> host
+	in0Fd, err := os.OpenFile(eps.in0, os.O_WRONLY, 0) // device -> host
 	if err != nil {
 		return fmt.Errorf("open IF0 IN: %w", err)
 	}
 	defer in0Fd.Close()
-	out0Fd, err := os.OpenFile(out0, os.O_RDONLY, 0) // host -> device
+	out0Fd, err := os.OpenFile(eps.out0, os.O_RDONLY, 0) // host -> device
 	if err != nil {
 		return fmt.Errorf("open IF0 OUT: %w", err)
 	}
 	defer out0Fd.Close()
 
-	// IF1 (sign) endpoints
-	in1Fd, err := os.OpenFile(in1, os.O_WRONLY, 0) // device -> host
+	// IF1 (mgmt) endpoints
+	in1Fd, err := os.OpenFile(eps.in1, os.O_WRONLY, 0) // device -> host
 	if err != nil {
 		return fmt.Errorf("open IF1 IN: %w", err)
 	}
 	defer in1Fd.Close()
-	out1Fd, err := os.OpenFile(out1, os.O_RDONLY, 0) // host -> device
+	out1Fd, err := os.OpenFile(eps.out1, os.O_RDONLY, 0) // host -> device
 	if err != nil {
 		return fmt.Errorf("open IF1 OUT: %w", err)
 	}
 	defer out1Fd.Close()
 
+	// IF2 (admin) endpoints, when the registrar describes them
+	var in2Fd, out2Fd *os.File
+	if eps.in2 != "" {
+		if in2Fd, err = os.OpenFile(eps.in2, os.O_WRONLY, 0); err != nil { // device -> host
+			return fmt.Errorf("open IF2 IN: %w", err)
+		}
+		defer in2Fd.Close()
+		if out2Fd, err = os.OpenFile(eps.out2, os.O_RDONLY, 0); err != nil { // host -> device
+			return fmt.Errorf("open IF2 OUT: %w", err)
+		}
+		defer out2Fd.Close()
+	}
+
 	r0, _ := NewReader(out0Fd)
 	w0, _ := NewWriter(in0Fd)
 	r1, _ := NewReader(out1Fd)
@@ -419,70 +784,220 @@ func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRin
 	default:
 	}
 
-	cleanupSock := serveReadySocket(l)
+	cleanupSock := serveReadySocket(kr, hm, l)
 	defer cleanupSock()
 	// IF0: sign channel
-	signBroker := broker.New(r0, w0, bLogger, broker.WithHandler(handleSignAndStatus(handleRequestsFactory(fs, kr, l))))
+	signBroker := broker.New(r0, w0, bLogger, bWorkers, broker.WithHandler(handleSignAndStatus(handleWithLimits(rs, hm.track(handleRequestsFactory(fs, kr, upd, hm, vault, signLog, ident, clk, l))))))
 	defer signBroker.Stop()
 	// IF1: management channel
-	mgmtBroker := broker.New(r1, w1, bLogger, broker.WithHandler(handleMgmtOnly(handleRequestsFactory(fs, kr, l))))
+	mgmtBroker := broker.New(r1, w1, bLogger, bWorkers, broker.WithHandler(handleMgmtOnly(handleConfirmed(btn, handleWithLimits(rs, hm.track(handleRequestsFactory(fs, kr, upd, hm, vault, signLog, ident, clk, l)))))))
 	defer mgmtBroker.Stop()
+	brokers := []*broker.Broker{signBroker, mgmtBroker}
+
+	// IF2: admin channel. Without it adminDone stays nil and never fires;
+	// hosts fall back to IF1 for admin traffic.
+	var adminDone <-chan struct{}
+	if in2Fd != nil {
+		r2, _ := NewReader(out2Fd)
+		w2, _ := NewWriter(in2Fd)
+		adminBroker := broker.New(r2, w2, bLogger, bWorkers, broker.WithHandler(handleAdminOnly(handleConfirmed(btn, handleWithLimits(rs, hm.track(handleRequestsFactory(fs, kr, upd, hm, vault, signLog, ident, clk, l)))))))
+		defer adminBroker.Stop()
+		brokers = append(brokers, adminBroker)
+		adminDone = adminBroker.Done()
+	} else {
+		l.Warn("registrar has no admin interface (IF2); admin traffic stays on IF1")
+	}
+
+	hm.setBrokers(brokers...)
+	defer hm.setBrokers()
 
 	l.Info("Signer gadget online; awaiting requests.")
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-signBroker.Done():
+		return brokerDown(ctx, "sign")
+	case <-mgmtBroker.Done():
+		return brokerDown(ctx, "mgmt")
+	case <-adminDone:
+		return brokerDown(ctx, "admin")
 	}
 }
 
-func run(l *slog.Logger) error {
+// brokerDown tells an unplug, where the enabled socket closes right after the
+// endpoints fail, apart from a broker failing on its own.
+func brokerDown(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(brokerUnplugGrace):
+		return fmt.Errorf("%s broker stopped: %w", name, errBrokerDown)
+	}
+}
 
+// brokerBackoff is the pause before rebuilding the brokers after the n-th
+// consecutive failure.
+func brokerBackoff(n int) time.Duration {
+	d := brokerBackoffBase << (n - 1)
+	if d <= 0 || d > brokerBackoffMax {
+		d = brokerBackoffMax
+	}
+	return d
+}
+
+func run(l *slog.Logger, cfg *gadgetConfig, rs *runtimeSettings) error {
 	// Keystore directory: DATA_STORE/keystore when DATA_STORE is set; else next to binary
-	var baseDir string
+	// (/data/tezsign-vault.d/keystore when the keystore is encrypted)
+	var dataDir, baseDir, updateDir, auditDir string
+	var vault *keystoreVault
 	if ds := strings.TrimSpace(os.Getenv("DATA_STORE")); ds != "" {
+		dataDir = ds
 		baseDir = filepath.Join(ds, "keystore")
+		updateDir = filepath.Join(ds, "update")
+		auditDir = filepath.Join(ds, auditDirName)
+		if vault = newKeystoreVault(); vault != nil {
+			if exists(filepath.Join(baseDir, "master.json")) {
+				l.Warn("vault enabled but an unencrypted keystore exists; it is not migrated", "path", baseDir)
+			}
+			baseDir = filepath.Join(vault.dir, "keystore")
+			l.Info("keystore vault enabled", "sealed", vault.sealed())
+		}
 	} else {
 		baseDir = logging.DefaultFileInExecDir("keystore") // e.g. /path/to/bin/keystore
+		dataDir = filepath.Dir(baseDir)
+		updateDir = logging.DefaultFileInExecDir("update")
+		auditDir = logging.DefaultFileInExecDir(auditDirName)
 	}
 	// Ensure keystore dir exists (0700 since it holds secrets)
 	if err := os.MkdirAll(baseDir, 0o700); err != nil {
 		return fmt.Errorf("keystore mkdir %q: %w", baseDir, err)
 	}
 
+	sp := sandboxPaths{data: []string{dataDir}, exec: []string{updateDir}, config: cfg.path}
+	if vault != nil {
+		sp.data = append(sp.data, vault.dir)
+	}
+	if logDir := filepath.Dir(logging.CurrentFile()); logDir != dataDir {
+		sp.data = append(sp.data, logDir)
+	}
+	if err := enterSandbox(l, sp); err != nil {
+		return err
+	}
+	// Before any key material is loaded.
+	if err := hardenMemory(l); err != nil {
+		return err
+	}
+
+	release, err := loadRelease(releasePath)
+	switch {
+	case err != nil:
+		l.Warn("image release unreadable", "path", releasePath, "err", err)
+	case release != nil:
+		l.Info("image release", "version", release.GetVersion(), "commit", release.GetCommit(), "flavour", release.GetFlavour())
+	}
+	imageRelease = release
+
+	clk := newDeviceClock(dataDir, l)
+	ident, err := loadIdentity(dataDir, l)
+	if err != nil {
+		l.Error("device identity unavailable; attestation disabled", "err", err)
+	}
+
 	fs, err := keychain.NewFileStore(baseDir)
 	if err != nil {
 		return fmt.Errorf("store: %w", err)
 	}
+	switch m, err := openStateMirror(stateMirrorPath); {
+	case err == nil:
+		fs.SetStateMirror(m)
+		l.Info("watermark mirror enabled", "path", stateMirrorPath)
+	case errors.Is(err, os.ErrNotExist):
+		l.Warn("no watermark mirror; watermarks are kept on the data partition only")
+	default:
+		return fmt.Errorf("watermark mirror: %w", err)
+	}
 
-	kr := keychain.NewKeyRing(l, fs)
+	kr := keychain.NewKeyRing(l.With(logging.ComponentKey, "keychain"), fs)
+	if err := setupEpochCounter(kr, l); err != nil {
+		return fmt.Errorf("epoch counter: %w", err)
+	}
+	upd := newAppUpdater(updateDir)
+	go confirmBoot(context.Background(), updateDir, l)
+	hm := newHealthMonitor(baseDir, kr)
+	signLog := newAuditLog(auditDir, signAuditFile, signAuditMaxSize, l)
+	if auditExportEnabled() {
+		go newAuditLog(auditDir, healthAuditFile, healthAuditMaxSize, l).reportHealth(hm)
+	}
+	led := newStatusLED(l)
+	go led.run(context.Background(), kr)
+	go newOLEDDisplay(l).run(context.Background(), kr)
+	tamper, err := newTamperSwitch(l)
+	if err != nil {
+		return fmt.Errorf("tamper switch: %w", err)
+	}
+	go tamper.run(context.Background(), kr)
+	btn := newConfirmButton(l)
+	al := newAutoLocker(kr, l)
+	rs.apply(cfg, al, kr)
+	go reloadOnSIGHUP(cfg.path, rs, al, kr, l)
+	go petWatchdog(context.Background(), hm, l)
 
 	// --- broker handler: parse → validate → sign/deny → respond ---
 
+	failures := 0
 	for {
 		enabled, err := net.Dial("unix", common.EnabledSock)
 		if err != nil {
 			l.Info("gadget not enabled (socket down), retrying", "err", err)
+			al.disconnected()
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 		l.Info("gadget enabled; starting brokers")
+		al.connected()
 
 		ctx, cancel := context.WithCancel(context.Background())
 		ioCopyDone := make(chan struct{})
 		go func() {
 			defer close(ioCopyDone)
-			_, _ = io.Copy(io.Discard, enabled)
-			l.Warn("gadget disabled; stopping brokers")
+			if hm.link.watch(enabled, al.setSuspended, l) {
+				l.Warn("host requested a function reset; reopening endpoints")
+			} else {
+				l.Warn("gadget disabled; stopping brokers")
+			}
 			cancel()
 		}()
 
-		err = runBrokers(ctx, fs, kr, l)
+		started := time.Now()
+		err = runBrokers(ctx, fs, kr, upd, hm, vault, signLog, ident, clk, rs, btn, l)
+		if err != nil && ctx.Err() == nil && hm.link.suspendedSince(started) {
+			// Endpoints fail while the host sleeps; rebuild on resume
+			// without counting a failure or backing off.
+			l.Info("brokers stopped during USB suspend; waiting for resume", "err", err)
+			hm.link.waitResumed(ctx)
+			err = nil
+		}
 		// Cleanup: ensure socket is closed and goroutine exits before retrying
 		cancel()
-		_ = enabled.Close() // Force close to unblock io.Copy
-		<-ioCopyDone        // Wait for io.Copy goroutine to exit
+		_ = enabled.Close() // Force close to unblock the link watcher
+		<-ioCopyDone        // Wait for the watcher goroutine to exit
+		al.disconnected()
+		if time.Since(started) >= brokerHealthyAfter {
+			failures = 0
+		}
 		if err != nil {
 			l.Error("broker error", "err", err)
+			if errors.Is(err, context.Canceled) { // plain unplug or function reset
+				continue
+			}
+			led.fault()
+			failures++
+			if failures >= maxBrokerFailures {
+				return fmt.Errorf("brokers failed %d times in a row: %w", failures, err)
+			}
+			delay := brokerBackoff(failures)
+			l.Warn("rebuilding brokers", "attempt", failures, "in", delay)
+			time.Sleep(delay)
 			continue
 		}
 	}
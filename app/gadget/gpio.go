@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const gpioDir = "/sys/class/gpio"
+
+// gpioInput is a sysfs GPIO input given as "<gpio number>[,active_low]".
+// setup-gadget.sh exports the pin and hands its value to the app.
+type gpioInput struct {
+	value     string // /sys/class/gpio/gpioN/value
+	activeLow bool
+	name      string // gpioN
+}
+
+func parseGPIOSpec(spec string) (*gpioInput, error) {
+	pin, opt, _ := strings.Cut(spec, ",")
+	n, err := strconv.ParseUint(strings.TrimSpace(pin), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gpio %q", pin)
+	}
+	g := &gpioInput{
+		value: fmt.Sprintf("%s/gpio%d/value", gpioDir, n),
+		name:  fmt.Sprintf("gpio%d", n),
+	}
+	switch strings.TrimSpace(opt) {
+	case "":
+	case "active_low":
+		g.activeLow = true
+	default:
+		return nil, fmt.Errorf("unknown option %q (want active_low)", opt)
+	}
+	return g, nil
+}
+
+func (g *gpioInput) active() (bool, error) {
+	raw, err := os.ReadFile(g.value)
+	if err != nil {
+		return false, err
+	}
+	high := bytes.HasPrefix(bytes.TrimSpace(raw), []byte("1"))
+	return high != g.activeLow, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+const (
+	thermalZonePath = "/sys/class/thermal/thermal_zone0/temp"
+	meminfoPath     = "/proc/meminfo"
+)
+
+// healthMonitor gathers the health snapshot served by the Health RPC.
+type healthMonitor struct {
+	start   time.Time
+	dataDir string
+	kr      *keychain.KeyRing
+
+	mu      sync.Mutex
+	brokers []*broker.Broker // current session's brokers, for queue depth
+	subs    map[*broker.Broker]*telemetrySub
+	seq     atomic.Uint64 // last Telemetry sequence number
+
+	link usbLink // host suspend state; see run
+
+	inflight        map[uint64]time.Time // running requests by call id; see track
+	nextCall        uint64
+	brokerDeadSince time.Time
+
+	// storage probe, owned by petWatchdog
+	probedAt time.Time
+	probeErr error
+}
+
+func newHealthMonitor(dataDir string, kr *keychain.KeyRing) *healthMonitor {
+	return &healthMonitor{start: time.Now(), dataDir: dataDir, kr: kr}
+}
+
+// setBrokers replaces the brokers counted in queue depth; call with none
+// when the session ends.
+func (h *healthMonitor) setBrokers(bs ...*broker.Broker) {
+	h.mu.Lock()
+	h.brokers = bs
+	h.mu.Unlock()
+}
+
+func (h *healthMonitor) snapshot() *signer.HealthResponse {
+	res := &signer.HealthResponse{
+		UptimeSeconds:            uint64(time.Since(h.start).Seconds()),
+		Goroutines:               uint32(runtime.NumGoroutine()),
+		WatermarkPersistFailures: h.kr.PersistFailures(),
+		Version:                  version,
+		MemAvailableBytes:        memAvailable(),
+		Signs:                    h.kr.Signs(),
+		SignRejects:              h.kr.SignRejects(),
+	}
+	last, slowest := h.kr.PersistLatency()
+	res.WatermarkPersistLastMicros = uint64(last.Microseconds())
+	res.WatermarkPersistMaxMicros = uint64(slowest.Microseconds())
+
+	if raw, err := os.ReadFile(thermalZonePath); err == nil {
+		if v, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 32); err == nil {
+			res.TemperatureAvailable = true
+			res.TemperatureMillicelsius = int32(v)
+		}
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(h.dataDir, &st); err == nil {
+		res.DataFreeBytes = st.Bavail * uint64(st.Bsize)
+		res.DataTotalBytes = st.Blocks * uint64(st.Bsize)
+	}
+
+	h.mu.Lock()
+	for _, b := range h.brokers {
+		res.QueueDepth += uint32(b.QueueDepth())
+	}
+	h.mu.Unlock()
+
+	return res
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo; 0 if unknown.
+func memAvailable() uint64 {
+	raw, err := os.ReadFile(meminfoPath)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if v, ok := strings.CutPrefix(line, "MemAvailable:"); ok {
+			kb, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
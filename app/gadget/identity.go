@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+	"golang.org/x/sys/unix"
+	"google.golang.org/protobuf/proto"
+)
+
+// identityFile holds the ed25519 seed of the device identity key, sealed
+// under the master passphrase like the keystore (identitySealed and the
+// keychain.SealWithMaster output). It lives next to the keystore, outside
+// the vault. Devices from before sealing have the bare seed, which is
+// sealed at the next unlock.
+const (
+	identityFile   = "identity.key"
+	identityLabel  = "identity"
+	identitySealed = 0x01
+)
+
+var errIdentityLocked = errors.New("device identity locked: unlock with the master passphrase first")
+
+// deviceIdentity signs attestation statements. The key is created at init,
+// or at the first unlock of a keystore that predates it, and never leaves
+// the device. It is usable from the first passphrase the gadget verifies
+// until it stops.
+type deviceIdentity struct {
+	path string
+	l    *slog.Logger
+
+	mu     sync.Mutex
+	key    ed25519.PrivateKey // nil while locked
+	sealed bool               // the file holds the sealed seed
+
+	appOnce sync.Once
+	appSum  []byte
+	appErr  error
+}
+
+// loadIdentity reads the identity file; only a bare seed is usable
+// without the passphrase.
+func loadIdentity(dir string, l *slog.Logger) (*deviceIdentity, error) {
+	d := &deviceIdentity{path: filepath.Join(dir, identityFile), l: l}
+	b, err := os.ReadFile(d.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return d, nil
+	case err != nil:
+		return nil, err
+	case len(b) == ed25519.SeedSize:
+		d.key = ed25519.NewKeyFromSeed(b)
+		keychain.MemoryWipe(b)
+		l.Warn("device identity key is not sealed yet; it will be at the next unlock", "path", d.path)
+		l.Info("device identity", "public_key", fmt.Sprintf("%x", d.publicKey()))
+	case len(b) > 0 && b[0] == identitySealed:
+		d.sealed = true
+	default:
+		return nil, fmt.Errorf("%s: unknown format", d.path)
+	}
+	return d, nil
+}
+
+// unlock makes the identity usable with pass, which the caller has just
+// verified against the keystore: it opens the sealed seed, or creates and
+// seals a new one, or seals a bare one.
+func (d *deviceIdentity) unlock(fs *keychain.FileStore, pass []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.key != nil && d.sealed {
+		return nil
+	}
+	if d.sealed {
+		b, err := os.ReadFile(d.path)
+		if err != nil {
+			return err
+		}
+		seed, err := fs.OpenWithMaster(pass, identityLabel, b[1:])
+		if err != nil {
+			return err
+		}
+		defer keychain.MemoryWipe(seed)
+		if len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("%s: want %d bytes, have %d", d.path, ed25519.SeedSize, len(seed))
+		}
+		d.key = ed25519.NewKeyFromSeed(seed)
+		d.l.Info("device identity", "public_key", fmt.Sprintf("%x", d.publicKey()))
+		return nil
+	}
+
+	key, created := d.key, d.key == nil
+	if created {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+		keychain.MemoryWipe(seed)
+	}
+	sealed, err := fs.SealWithMaster(pass, identityLabel, key.Seed())
+	if err != nil {
+		return err
+	}
+	if err := writeIdentity(d.path, append([]byte{identitySealed}, sealed...)); err != nil {
+		return err
+	}
+	d.key, d.sealed = key, true
+	if created {
+		d.l.Info("device identity key created", "path", d.path, "public_key", fmt.Sprintf("%x", d.publicKey()))
+	} else {
+		d.l.Info("device identity key sealed", "path", d.path)
+	}
+	return nil
+}
+
+// writeIdentity replaces the identity file through a synced temporary file.
+func writeIdentity(p string, b []byte) error {
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+func (d *deviceIdentity) publicKey() ed25519.PublicKey {
+	return d.key.Public().(ed25519.PublicKey)
+}
+
+// appDigest hashes the running binary once.
+func (d *deviceIdentity) appDigest() ([]byte, error) {
+	d.appOnce.Do(func() {
+		self, err := os.Executable()
+		if err != nil {
+			d.appErr = err
+			return
+		}
+		f, err := os.Open(self)
+		if err != nil {
+			d.appErr = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			d.appErr = err
+			return
+		}
+		d.appSum = h.Sum(nil)
+	})
+	return d.appSum, d.appErr
+}
+
+// attest signs nonce together with the running version, binary and settings.
+func (d *deviceIdentity) attest(nonce []byte) (*signer.AttestResponse, error) {
+	d.mu.Lock()
+	key := d.key
+	d.mu.Unlock()
+	if key == nil {
+		return nil, errIdentityLocked
+	}
+	app, err := d.appDigest()
+	if err != nil {
+		return nil, fmt.Errorf("hash app: %w", err)
+	}
+	cfg, err := loadConfig(configPath())
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	sum := cfg.digest()
+	var uts unix.Utsname
+	_ = unix.Uname(&uts)
+
+	st, err := proto.Marshal(&signer.AttestStatement{
+		Nonce:         nonce,
+		Version:       version,
+		AppSha256:     app,
+		ConfigSha256:  sum[:],
+		KernelRelease: unix.ByteSliceToString(uts.Release[:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &signer.AttestResponse{
+		IdentityPublicKey: key.Public().(ed25519.PublicKey),
+		Statement:         st,
+		Signature:         ed25519.Sign(key, signer.AttestMessage(st)),
+	}, nil
+}
+
+// digest hashes the effective settings ("key=value" lines, sorted), the
+// environment taking precedence over the file as everywhere else.
+func (c *gadgetConfig) digest() [sha256.Size]byte {
+	keys := make([]string, 0, len(configEnv)+len(c.values))
+	seen := map[string]bool{}
+	for k := range configEnv {
+		keys, seen[k] = append(keys, k), true
+	}
+	for k := range c.values {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		if v, ok := c.value(k); ok {
+			fmt.Fprintf(h, "%s=%s\n", k, v)
+		}
+	}
+	var out [sha256.Size]byte
+	h.Sum(out[:0])
+	return out
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/keychain"
+)
+
+const (
+	envLED      = "TEZSIGN_LED" // LED name under /sys/class/leds; "none" disables
+	ledsDir     = "/sys/class/leds"
+	ledTick     = 100 * time.Millisecond
+	ledSignHold = 2 * ledTick // how long a signature flashes the LED
+	ledFaultFor = 10 * time.Second
+)
+
+// ledCandidates are tried in order when TEZSIGN_LED is unset.
+var ledCandidates = []string{"ACT", "led0", "board-led", "status", "green:status"}
+
+type ledState int
+
+const (
+	ledLocked  ledState = iota // short blip every 2s
+	ledIdle                    // solid on: keys unlocked, nothing to sign
+	ledSigning                 // brief dark flash per signature
+	ledFault                   // fast blink; also held while tampered
+)
+
+// statusLED drives a board LED in software so only its brightness (and
+// trigger, once) need to be writable by the app; setup-gadget.sh grants
+// that. A nil *statusLED does nothing.
+type statusLED struct {
+	dir string
+	on  []byte
+	l   *slog.Logger
+
+	mu         sync.Mutex
+	faultUntil time.Time
+}
+
+// newStatusLED picks the LED from TEZSIGN_LED or the known board names.
+// It returns nil when no usable LED is found.
+func newStatusLED(l *slog.Logger) *statusLED {
+	names := ledCandidates
+	if v := strings.TrimSpace(os.Getenv(envLED)); v != "" {
+		if v == "none" {
+			return nil
+		}
+		names = []string{v}
+	}
+	for _, name := range names {
+		dir := filepath.Join(ledsDir, name)
+		if !exists(filepath.Join(dir, "brightness")) {
+			continue
+		}
+		on := []byte("1")
+		if raw, err := os.ReadFile(filepath.Join(dir, "max_brightness")); err == nil {
+			on = []byte(strings.TrimSpace(string(raw)))
+		}
+		// Detach kernel triggers (heartbeat, mmc activity) so we own the LED.
+		if err := os.WriteFile(filepath.Join(dir, "trigger"), []byte("none"), 0); err != nil {
+			l.Warn("status LED: cannot take over trigger", "led", name, "err", err)
+			return nil
+		}
+		l.Info("status LED enabled", "led", name)
+		return &statusLED{dir: dir, on: on, l: l}
+	}
+	return nil
+}
+
+// fault shows the error pattern for a while.
+func (s *statusLED) fault() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.faultUntil = time.Now().Add(ledFaultFor)
+	s.mu.Unlock()
+}
+
+func (s *statusLED) faulted(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return now.Before(s.faultUntil)
+}
+
+// run drives the LED from the key ring until ctx ends.
+func (s *statusLED) run(ctx context.Context, kr *keychain.KeyRing) {
+	if s == nil {
+		return
+	}
+	t := time.NewTicker(ledTick)
+	defer t.Stop()
+
+	var (
+		tick       uint64
+		lastSigns  = kr.Signs()
+		lastFails  = kr.PersistFailures()
+		signedAt   time.Time
+		lit, known bool
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			tick++
+			if n := kr.Signs(); n != lastSigns {
+				lastSigns, signedAt = n, now
+			}
+			if n := kr.PersistFailures(); n != lastFails {
+				lastFails = n
+				s.fault()
+			}
+
+			state := ledLocked
+			switch {
+			case kr.Tampered(), s.faulted(now):
+				state = ledFault
+			case now.Sub(signedAt) < ledSignHold:
+				state = ledSigning
+			case kr.UnlockedCount() > 0:
+				state = ledIdle
+			}
+
+			want := ledPattern(state, tick)
+			if known && want == lit {
+				continue
+			}
+			if err := s.set(want); err != nil {
+				s.l.Warn("status LED write failed; disabling", "err", err)
+				return
+			}
+			lit, known = want, true
+		}
+	}
+}
+
+func ledPattern(state ledState, tick uint64) bool {
+	switch state {
+	case ledIdle:
+		return true
+	case ledSigning:
+		return false
+	case ledFault:
+		return (tick/2)%2 == 0
+	default: // ledLocked
+		return tick%20 == 0
+	}
+}
+
+func (s *statusLED) set(lit bool) error {
+	v := []byte("0")
+	if lit {
+		v = s.on
+	}
+	return os.WriteFile(filepath.Join(s.dir, "brightness"), v, 0)
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"time"
 
 	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/keychain"
 )
 
+// readyStatusInterval is how often the ready socket checks the key counts
+// it reports to the registrar.
+const readyStatusInterval = 2 * time.Second
+
 // serveReadySocket holds the socket open while the process is healthy.
 // Registrar will connect and keep a single connection open.
-func serveReadySocket(l *slog.Logger) (cleanup func()) {
+func serveReadySocket(kr *keychain.KeyRing, hm *healthMonitor, l *slog.Logger) (cleanup func()) {
 	_ = os.Remove(common.ReadySock) // stale
 	ln, err := net.Listen("unix", common.ReadySock)
 	if err != nil {
@@ -34,16 +42,19 @@ func serveReadySocket(l *slog.Logger) (cleanup func()) {
 					continue
 				}
 			}
-			// We don’t send anything; keeping the fd open is the signal.
+			// Keeping the fd open is the signal. The first line names the
+			// running app for the registrar's version requests; status lines
+			// follow whenever the key counts change.
 			go func() {
 				defer conn.Close()
-				// Drain/discard forever; if registrar goes away we’ll just accept next time.
-				buf := make([]byte, 1)
-				for {
-					if _, err := conn.Read(buf); err != nil {
-						return
-					}
-				}
+				_, _ = fmt.Fprintf(conn, "%s %s\n", version, commit)
+				gone := make(chan struct{})
+				go func() {
+					// Drain/discard forever; if registrar goes away we’ll just accept next time.
+					defer close(gone)
+					_, _ = io.Copy(io.Discard, conn)
+				}()
+				reportReadyStatus(conn, gone, kr, hm)
 			}()
 		}
 	}()
@@ -54,3 +65,28 @@ func serveReadySocket(l *slog.Logger) (cleanup func()) {
 		_ = os.Remove(common.ReadySock)
 	}
 }
+
+// reportReadyStatus writes "status <keys> <unlocked> <uptime seconds>" lines
+// until gone closes or a write fails.
+func reportReadyStatus(w io.Writer, gone <-chan struct{}, kr *keychain.KeyRing, hm *healthMonitor) {
+	t := time.NewTicker(readyStatusInterval)
+	defer t.Stop()
+	lastKeys, lastUnlocked := -1, -1
+	for {
+		if keys, err := kr.KeyCount(); err == nil {
+			unlocked := kr.UnlockedCount()
+			if keys != lastKeys || unlocked != lastUnlocked {
+				up := int64(time.Since(hm.start).Seconds())
+				if _, err := fmt.Fprintf(w, "status %d %d %d\n", keys, unlocked, up); err != nil {
+					return
+				}
+				lastKeys, lastUnlocked = keys, unlocked
+			}
+		}
+		select {
+		case <-gone:
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// envInsecureMemory lets the gadget start without locked memory or with
+	// disk-backed swap ("1"); for development boards only.
+	envInsecureMemory = "TEZSIGN_INSECURE_MEMORY"
+
+	procSwaps = "/proc/swaps"
+)
+
+var errSwapEnabled = errors.New("disk-backed swap is enabled")
+
+// hardenMemory keeps decrypted keys out of persistent storage: it locks all
+// current and future pages in RAM and refuses disk-backed swap. zram swap
+// lives in RAM and is only reported.
+func hardenMemory(l *slog.Logger) error {
+	var errs []error
+	if err := unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE); err != nil {
+		errs = append(errs, fmt.Errorf("mlockall: %w (raise LimitMEMLOCK)", err))
+	}
+	if err := unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0); err != nil {
+		errs = append(errs, fmt.Errorf("disable core dumps: %w", err))
+	}
+
+	devices, err := swapDevices()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("read %s: %w", procSwaps, err))
+	}
+	var disk []string
+	for _, dev := range devices {
+		if strings.HasPrefix(dev, "/dev/zram") {
+			l.Info("zram swap present (RAM-backed)", "device", dev)
+			continue
+		}
+		disk = append(disk, dev)
+	}
+	if len(disk) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", errSwapEnabled, strings.Join(disk, ", ")))
+	}
+
+	err = errors.Join(errs...)
+	if err == nil {
+		l.Info("memory locked; no disk-backed swap")
+		return nil
+	}
+	if envBool(os.Getenv(envInsecureMemory)) {
+		l.Error("MEMORY NOT HARDENED; decrypted keys may reach disk", "err", err, "override", envInsecureMemory)
+		return nil
+	}
+	return fmt.Errorf("memory hardening: %w", err)
+}
+
+// swapDevices lists the active swap areas.
+func swapDevices() ([]string, error) {
+	f, err := os.Open(procSwaps)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []string
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) > 0 {
+			out = append(out, fields[0])
+		}
+	}
+	return out, sc.Err()
+}
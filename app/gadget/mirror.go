@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// stateMirrorPath links to the reserved tail of the app partition,
+	// attached by attach-state-mirror.sh. Absent on images built without it.
+	stateMirrorPath = "/run/tezsign/state-mirror"
+
+	// Each key's copy lives in its own sector-sized slot:
+	// magic, SHA-256(name)[:16], blob length (LE), blob, CRC-32 of all before.
+	mirrorSlotSize = 512
+	mirrorNameSize = 16
+	mirrorHeadSize = 4 + mirrorNameSize + 2
+	mirrorMaxBlob  = mirrorSlotSize - mirrorHeadSize - 4
+)
+
+var (
+	mirrorMagic = []byte("TZWM")
+
+	errMirrorFull    = errors.New("state mirror: no free slot")
+	errMirrorTooBig  = errors.New("state mirror: state does not fit a slot")
+	errMirrorTooTiny = errors.New("state mirror: device smaller than one slot")
+)
+
+// blockMirror is a keychain.StateMirror over a small raw block device.
+// Slots are located once at open; afterwards each write touches only its
+// own slot. A slot with a bad checksum (e.g. torn by a power cut) counts as
+// free: the keystore copy still holds that key's state.
+type blockMirror struct {
+	mu    sync.Mutex
+	f     *os.File
+	slots map[[mirrorNameSize]byte]int64 // name hash -> slot index
+	free  []int64
+}
+
+// openStateMirror opens the mirror device at path. It returns an error
+// satisfying errors.Is(err, os.ErrNotExist) when the image has none.
+func openStateMirror(path string) (*blockMirror, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_SYNC, 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	count := size / mirrorSlotSize
+	if count == 0 {
+		f.Close()
+		return nil, errMirrorTooTiny
+	}
+
+	m := &blockMirror{f: f, slots: make(map[[mirrorNameSize]byte]int64)}
+	slot := make([]byte, mirrorSlotSize)
+	for i := int64(0); i < count; i++ {
+		if _, err := f.ReadAt(slot, i*mirrorSlotSize); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("state mirror: read slot %d: %w", i, err)
+		}
+		name, _, ok := parseMirrorSlot(slot)
+		if _, dup := m.slots[name]; !ok || dup {
+			m.free = append(m.free, i)
+			continue
+		}
+		m.slots[name] = i
+	}
+	return m, nil
+}
+
+func mirrorNameHash(name string) (h [mirrorNameSize]byte) {
+	sum := sha256.Sum256([]byte(name))
+	copy(h[:], sum[:])
+	return h
+}
+
+func parseMirrorSlot(slot []byte) (name [mirrorNameSize]byte, blob []byte, ok bool) {
+	if !bytes.Equal(slot[:4], mirrorMagic) {
+		return name, nil, false
+	}
+	n := int(binary.LittleEndian.Uint16(slot[4+mirrorNameSize:]))
+	if n > mirrorMaxBlob {
+		return name, nil, false
+	}
+	end := mirrorHeadSize + n
+	if crc32.ChecksumIEEE(slot[:end]) != binary.LittleEndian.Uint32(slot[end:]) {
+		return name, nil, false
+	}
+	copy(name[:], slot[4:])
+	return name, slot[mirrorHeadSize:end], true
+}
+
+func (m *blockMirror) Load(name string) ([]byte, error) {
+	h := mirrorNameHash(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.slots[h]
+	if !ok {
+		return nil, nil
+	}
+	slot := make([]byte, mirrorSlotSize)
+	if _, err := m.f.ReadAt(slot, i*mirrorSlotSize); err != nil {
+		return nil, err
+	}
+	got, blob, ok := parseMirrorSlot(slot)
+	if !ok || got != h {
+		// Torn since open; the next Store rewrites it.
+		return nil, nil
+	}
+	return bytes.Clone(blob), nil
+}
+
+func (m *blockMirror) Store(name string, b []byte) error {
+	if len(b) > mirrorMaxBlob {
+		return errMirrorTooBig
+	}
+	h := mirrorNameHash(name)
+	slot := make([]byte, mirrorSlotSize)
+	copy(slot, mirrorMagic)
+	copy(slot[4:], h[:])
+	binary.LittleEndian.PutUint16(slot[4+mirrorNameSize:], uint16(len(b)))
+	copy(slot[mirrorHeadSize:], b)
+	end := mirrorHeadSize + len(b)
+	binary.LittleEndian.PutUint32(slot[end:], crc32.ChecksumIEEE(slot[:end]))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.slots[h]
+	if !ok {
+		if len(m.free) == 0 {
+			return errMirrorFull
+		}
+		i, m.free = m.free[0], m.free[1:]
+		m.slots[h] = i
+	}
+	_, err := m.f.WriteAt(slot, i*mirrorSlotSize)
+	return err
+}
+
+func (m *blockMirror) Remove(name string) error {
+	h := mirrorNameHash(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.slots[h]
+	if !ok {
+		return nil
+	}
+	if _, err := m.f.WriteAt(make([]byte, mirrorSlotSize), i*mirrorSlotSize); err != nil {
+		return err
+	}
+	delete(m.slots, h)
+	m.free = append(m.free, i)
+	return nil
+}
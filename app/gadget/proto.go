@@ -48,5 +48,15 @@ func wipeReq(r *signer.Request) {
 			keychain.MemoryWipe(p.DeleteKeys.Passphrase)
 			p.DeleteKeys.Passphrase = nil
 		}
+	case *signer.Request_AckTamper:
+		if p.AckTamper != nil && p.AckTamper.Passphrase != nil {
+			keychain.MemoryWipe(p.AckTamper.Passphrase)
+			p.AckTamper.Passphrase = nil
+		}
+	case *signer.Request_SetTime:
+		if p.SetTime != nil && p.SetTime.Passphrase != nil {
+			keychain.MemoryWipe(p.SetTime.Passphrase)
+			p.SetTime.Passphrase = nil
+		}
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// releasePath is written by the image builder (tools/builder/release.go),
+// one KEY=value per line.
+const releasePath = "/etc/tezsign-release"
+
+// imageRelease is reported in every status reply; nil when the gadget does
+// not run from a built image.
+var imageRelease *signer.ImageRelease
+
+// loadRelease reads the release file; a missing file is not an error.
+func loadRelease(path string) (*signer.ImageRelease, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &signer.ImageRelease{}
+	fields := map[string]*string{
+		"TEZSIGN_VERSION":                 &r.Version,
+		"TEZSIGN_COMMIT":                  &r.Commit,
+		"TEZSIGN_BUILD_TIME":              &r.BuildTime,
+		"TEZSIGN_FLAVOUR":                 &r.Flavour,
+		"TEZSIGN_BOARD":                   &r.Board,
+		"TEZSIGN_LAYOUT":                  &r.Layout,
+		"TEZSIGN_PARTITION_LAYOUT_SHA256": &r.PartitionLayoutSha256,
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if field, known := fields[key]; known {
+			*field = value
+		}
+	}
+	return r, sc.Err()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// envInsecureSandbox starts the gadget without the seccomp filter and
+	// Landlock ruleset ("1"); for development boards only.
+	envInsecureSandbox = "TEZSIGN_INSECURE_SANDBOX"
+	// envSandboxed marks the re-executed, sandboxed process. The value
+	// records what was applied.
+	envSandboxed = "TEZSIGN_SANDBOXED"
+)
+
+var errLandlockUnsupported = errors.New("landlock not supported by this kernel")
+
+// sandboxPaths are the only places the gadget may touch once sandboxed,
+// besides the FunctionFS endpoints, the ready socket and read-only system
+// files.
+type sandboxPaths struct {
+	data   []string // read/write: keystore, audit trail, logs
+	exec   []string // read/write/execute: staged app updates
+	config string   // read-only; may not exist
+}
+
+// enterSandbox restricts the gadget so a compromised request parser can
+// neither reach the network nor read or write files outside p.
+//
+// Landlock and no_new_privs only bind the calling thread, and with cgo Go
+// cannot apply them to every thread. So the rules are applied on a locked
+// thread which then re-executes the binary: the new process starts from
+// that thread and is confined as a whole. The second run finds
+// envSandboxed set and returns.
+func enterSandbox(l *slog.Logger, p sandboxPaths) error {
+	if state := os.Getenv(envSandboxed); state != "" {
+		l.Info("sandboxed", "applied", state)
+		return nil
+	}
+	if envBool(os.Getenv(envInsecureSandbox)) {
+		l.Error("SANDBOX DISABLED; a compromised parser can reach the network and filesystem", "override", envInsecureSandbox)
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	for _, dir := range p.exec {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("sandbox: mkdir %q: %w", dir, err)
+		}
+	}
+
+	runtime.LockOSThread() // never unlocked: this thread either execs or the process exits
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("sandbox: no_new_privs: %w", err)
+	}
+	applied := "seccomp,landlock"
+	if err := restrictFilesystem(landlockRules(p, self)); err != nil {
+		if !errors.Is(err, errLandlockUnsupported) {
+			return fmt.Errorf("sandbox: landlock: %w", err)
+		}
+		l.Warn("filesystem access is not restricted", "err", err)
+		applied = "seccomp"
+	}
+	if err := installSeccomp(); err != nil {
+		return fmt.Errorf("sandbox: seccomp: %w", err)
+	}
+
+	l.Info("re-executing sandboxed", "applied", applied)
+	env := append(os.Environ(), envSandboxed+"="+applied)
+	return fmt.Errorf("sandbox: re-exec: %w", syscall.Exec(self, os.Args, env))
+}
+
+// --- Landlock ---
+
+const (
+	llRead  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	llExec  = unix.LANDLOCK_ACCESS_FS_EXECUTE
+	llWrite = unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	llRW    = llRead | llWrite |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REFER
+
+	// llFileOnly are the rights that may be granted on a single file.
+	llFileOnly = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+type landlockRule struct {
+	path   string
+	access uint64
+}
+
+func landlockRules(p sandboxPaths, self string) []landlockRule {
+	rules := []landlockRule{
+		// System files: shared libraries (cgo), time zones, /proc and /sys readings.
+		{"/usr", llRead | llExec},
+		{"/lib", llRead | llExec},
+		{"/lib64", llRead | llExec},
+		{"/etc", llRead},
+		{"/proc", llRead},
+		{"/sys", llRead},
+		{"/dev/null", unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE},
+
+		{self, unix.LANDLOCK_ACCESS_FS_READ_FILE | llExec},
+		{common.FfsInstanceRoot, llRead | unix.LANDLOCK_ACCESS_FS_WRITE_FILE},
+		// The ready socket is recreated on every broker start. Connecting to
+		// unix sockets (enabled, vault, $NOTIFY_SOCKET) needs no rule.
+		{filepath.Dir(common.ReadySock), unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE},
+	}
+	for _, dir := range p.data {
+		rules = append(rules, landlockRule{dir, llRW})
+	}
+	for _, dir := range p.exec {
+		rules = append(rules, landlockRule{dir, llRW | llExec})
+	}
+	if p.config != "" {
+		rules = append(rules, landlockRule{p.config, unix.LANDLOCK_ACCESS_FS_READ_FILE})
+	}
+
+	// Status LED: class entries are symlinks into /sys/devices.
+	if entries, err := os.ReadDir(ledsDir); err == nil {
+		for _, e := range entries {
+			if dir, err := filepath.EvalSymlinks(filepath.Join(ledsDir, e.Name())); err == nil {
+				rules = append(rules, landlockRule{dir, unix.LANDLOCK_ACCESS_FS_WRITE_FILE})
+			}
+		}
+	}
+	if spec := strings.TrimSpace(os.Getenv(envDisplay)); spec != "" {
+		rules = append(rules, landlockRule{displayBus(spec),
+			unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV})
+	}
+	if dev, err := filepath.EvalSymlinks(stateMirrorPath); err == nil {
+		rules = append(rules, landlockRule{dev, unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE})
+	}
+	if spec, err := parseEpochSpec(os.Getenv(envEpochCounter)); err == nil {
+		// nvmem device entries are symlinks into /sys/devices.
+		if path, err := filepath.EvalSymlinks(spec.path); err == nil {
+			rules = append(rules, landlockRule{path, unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE})
+		}
+	}
+	return rules
+}
+
+// landlockABI returns the kernel's Landlock ABI version.
+func landlockABI() (int, error) {
+	v, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+			return 0, fmt.Errorf("%w: %v", errLandlockUnsupported, errno)
+		}
+		return 0, errno
+	}
+	return int(v), nil
+}
+
+// restrictFilesystem confines the calling thread to rules. Rights the
+// running kernel does not know are dropped; missing paths are skipped.
+func restrictFilesystem(rules []landlockRule) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	var attr unix.LandlockRulesetAttr
+	attr.Access_fs = 1<<13 - 1 // ABI 1: EXECUTE .. MAKE_SYM
+	if abi >= 2 {
+		attr.Access_fs |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		attr.Access_fs |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 4 {
+		// No TCP rules are added: all TCP bind/connect is denied.
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	if abi >= 5 {
+		attr.Access_fs |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	if abi >= 6 {
+		attr.Scoped = unix.LANDLOCK_SCOPE_ABSTRACT_UNIX_SOCKET | unix.LANDLOCK_SCOPE_SIGNAL
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, r := range rules {
+		if err := addLandlockRule(int(fd), r, attr.Access_fs); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("restrict self: %w", errno)
+	}
+	return nil
+}
+
+func addLandlockRule(ruleset int, r landlockRule, handled uint64) error {
+	fd, err := unix.Open(r.path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("open %q: %w", r.path, err)
+	}
+	defer unix.Close(fd)
+
+	access := r.access & handled
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %q: %w", r.path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= llFileOnly
+	}
+	if access == 0 {
+		return nil
+	}
+
+	pb := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&pb)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("add rule %q: %w", r.path, errno)
+	}
+	return nil
+}
+
+// --- seccomp ---
+
+// seccompArch is the AUDIT_ARCH_* value seccomp reports for GOARCH.
+var seccompArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+	"arm":   unix.AUDIT_ARCH_ARM,
+}
+
+// seccompDenied are syscalls the gadget never needs that would let a
+// compromised process read other processes, load kernel code or change
+// its view of the filesystem. They fail with EPERM.
+var seccompDenied = []uintptr{
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_PIDFD_GETFD,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_IO_URING_SETUP, // io_uring requests bypass seccomp
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_SETNS,
+	unix.SYS_UNSHARE,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_SWAPON,
+}
+
+// Offsets into struct seccomp_data.
+const (
+	seccompNr    = 0
+	seccompArchK = 4
+	seccompArg0  = 16 // low 32 bits on little-endian
+)
+
+// installSeccomp denies network sockets (everything but AF_UNIX) and the
+// syscalls in seccompDenied for all threads. Foreign syscall ABIs kill the
+// process. It is a denylist, not a strict filter: every other syscall is
+// allowed, including ones the gadget never makes, so it only narrows the
+// kernel surface. Keeping the process away from files is Landlock's job.
+func installSeccomp() error {
+	arch, ok := seccompArch[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+
+	stmt := func(code uint16, k uint32) unix.SockFilter { return unix.SockFilter{Code: code, K: k} }
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: jt, Jf: jf, K: k}
+	}
+	ret := func(k uint32) unix.SockFilter { return stmt(unix.BPF_RET|unix.BPF_K, k) }
+	load := func(off uint32) unix.SockFilter { return stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, off) }
+
+	prog := []unix.SockFilter{
+		load(seccompArchK),
+		jeq(arch, 1, 0),
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompNr),
+		// x32 syscalls share AUDIT_ARCH_X86_64.
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: 0, Jf: 1, K: 0x40000000},
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+	}
+	for _, nr := range seccompDenied {
+		prog = append(prog, jeq(uint32(nr), 0, 1), ret(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)))
+	}
+	prog = append(prog,
+		jeq(unix.SYS_SOCKET, 1, 0),
+		ret(unix.SECCOMP_RET_ALLOW),
+		load(seccompArg0),
+		jeq(unix.AF_UNIX, 0, 1),
+		ret(unix.SECCOMP_RET_ALLOW),
+		ret(unix.SECCOMP_RET_ERRNO|uint32(unix.EAFNOSUPPORT)),
+	)
+
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
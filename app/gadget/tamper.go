@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/keychain"
+)
+
+const (
+	// envTamperGPIO names a case-open switch: "<sysfs gpio number>[,active_low]".
+	// The pin is exported by setup-gadget.sh. Unset disables tamper detection.
+	envTamperGPIO = "TEZSIGN_TAMPER_GPIO"
+
+	tamperPoll = 20 * time.Millisecond
+)
+
+// tamperSwitch polls a GPIO input and trips the key ring's tamper latch
+// while it is active. A nil *tamperSwitch does nothing.
+type tamperSwitch struct {
+	*gpioInput
+	l *slog.Logger
+}
+
+// newTamperSwitch reads TEZSIGN_TAMPER_GPIO. A configured pin that cannot
+// be read fails startup: running on without the switch would let a case be
+// opened unnoticed.
+func newTamperSwitch(l *slog.Logger) (*tamperSwitch, error) {
+	spec := strings.TrimSpace(os.Getenv(envTamperGPIO))
+	if spec == "" {
+		return nil, nil
+	}
+	g, err := parseGPIOSpec(spec)
+	if err == nil {
+		_, err = g.active()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s=%q: %w", envTamperGPIO, spec, err)
+	}
+	l.Info("tamper detection enabled", "spec", spec)
+	return &tamperSwitch{gpioInput: g, l: l}, nil
+}
+
+// run trips the latch whenever the input is active and the latch is clear,
+// so acknowledging with the case still open re-latches immediately.
+func (t *tamperSwitch) run(ctx context.Context, kr *keychain.KeyRing) {
+	if t == nil {
+		return
+	}
+	tick := time.NewTicker(tamperPoll)
+	defer tick.Stop()
+
+	readFailing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		on, err := t.active()
+		if err != nil {
+			if !readFailing {
+				t.l.Error("tamper input unreadable", "err", err)
+				readFailing = true
+			}
+			continue
+		}
+		readFailing = false
+
+		if on && !kr.Tampered() {
+			if err := kr.Tamper(t.name); err != nil {
+				t.l.Error("tamper latch not persisted", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/signer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	telemetryDefaultInterval = 10 * time.Second
+	telemetryMinInterval     = time.Second
+	telemetryMaxInterval     = 5 * time.Minute
+)
+
+// telemetrySub is one channel's subscription; a new Telemetry request on
+// the same broker replaces it.
+type telemetrySub struct {
+	stop chan struct{}
+}
+
+// subscribe pushes a health snapshot to b every interval until b goes down
+// or subscribes again.
+func (h *healthMonitor) subscribe(b *broker.Broker, interval time.Duration, l *slog.Logger) time.Duration {
+	switch {
+	case interval == 0:
+		interval = telemetryDefaultInterval
+	case interval < telemetryMinInterval:
+		interval = telemetryMinInterval
+	case interval > telemetryMaxInterval:
+		interval = telemetryMaxInterval
+	}
+
+	sub := &telemetrySub{stop: make(chan struct{})}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*broker.Broker]*telemetrySub)
+	}
+	if old := h.subs[b]; old != nil {
+		close(old.stop)
+	}
+	h.subs[b] = sub
+	h.mu.Unlock()
+
+	go h.push(b, sub, interval, l)
+	return interval
+}
+
+func (h *healthMonitor) push(b *broker.Broker, sub *telemetrySub, interval time.Duration, l *slog.Logger) {
+	defer func() {
+		h.mu.Lock()
+		if h.subs[b] == sub {
+			delete(h.subs, b)
+		}
+		h.mu.Unlock()
+	}()
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		raw, err := proto.Marshal(&signer.Telemetry{Seq: h.seq.Add(1), Health: h.snapshot()})
+		if err == nil {
+			err = b.Notify(raw)
+		}
+		if err != nil && !errors.Is(err, broker.ErrWriteQueueFull) {
+			l.Debug("telemetry not sent", "err", err)
+		}
+
+		select {
+		case <-sub.stop:
+			return
+		case <-b.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// Set at build time:
+//
+//	-ldflags "-X main.version=v1.2.3 -X main.commit=<git sha> -X main.updatePublicKey=<hex ed25519 pubkey>"
+//
+// Builds without a public key refuse app updates over USB.
+var (
+	version         = "dev"
+	commit          = ""
+	updatePublicKey = ""
+)
+
+const (
+	// maxAppSize keeps uploads well below the app partition size.
+	maxAppSize = 48 << 20
+
+	updatePartFile   = "tezsign.part"
+	updateStagedFile = "tezsign.staged"     // picked up by tezsign-apply-update.path
+	updateSigFile    = "tezsign.staged.sig" // checked again by apply-app-update.sh
+	updateConfirmed  = "confirmed"          // a start that stayed up; see select-app-slot.sh
+	updateTrialFile  = "trial"              // where images before the root-only trial record kept it
+	versionTimeout   = 5 * time.Second
+
+	// bootConfirmDelay is how long a freshly installed app must stay up
+	// before its slot is kept; crashing earlier counts toward a rollback.
+	bootConfirmDelay = 30 * time.Second
+)
+
+var (
+	errUpdateDisabled = errors.New("update: this build has no update public key")
+	errUpdateNotBegun = errors.New("update: no update in progress")
+	errUpdateVerify   = errors.New("update: signature verification failed")
+)
+
+// appUpdater receives a new gadget binary in chunks and stages it once its
+// digest and signature check out. One upload at a time; a new begin
+// discards an unfinished one.
+type appUpdater struct {
+	dir string
+
+	mu      sync.Mutex
+	f       *os.File
+	h       hash.Hash
+	size    uint64
+	written uint64
+	sum     []byte
+	sig     []byte
+}
+
+func newAppUpdater(dir string) *appUpdater {
+	return &appUpdater{dir: dir}
+}
+
+func (u *appUpdater) begin(r *signer.UpdateBeginRequest) error {
+	if updatePublicKey == "" {
+		return errUpdateDisabled
+	}
+	if r.GetSize() == 0 || r.GetSize() > maxAppSize {
+		return fmt.Errorf("update: size %d out of range (max %d)", r.GetSize(), maxAppSize)
+	}
+	if len(r.GetSha256()) != sha256.Size || len(r.GetSignature()) != ed25519.SignatureSize {
+		return errors.New("update: bad digest or signature length")
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.resetLocked()
+
+	if err := os.MkdirAll(u.dir, 0o700); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(u.dir, updatePartFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	u.f = f
+	u.h = sha256.New()
+	u.size = r.GetSize()
+	u.sum = bytes.Clone(r.GetSha256())
+	u.sig = bytes.Clone(r.GetSignature())
+	return nil
+}
+
+func (u *appUpdater) chunk(r *signer.UpdateChunkRequest) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.f == nil {
+		return errUpdateNotBegun
+	}
+	data := r.GetData()
+	if r.GetOffset() != u.written {
+		return fmt.Errorf("update: chunk at offset %d, expected %d", r.GetOffset(), u.written)
+	}
+	if u.written+uint64(len(data)) > u.size {
+		return fmt.Errorf("update: chunk exceeds announced size %d", u.size)
+	}
+	if _, err := u.f.Write(data); err != nil {
+		u.resetLocked()
+		return fmt.Errorf("update: %w", err)
+	}
+	u.h.Write(data)
+	u.written += uint64(len(data))
+	return nil
+}
+
+// commit verifies the upload, runs it once to read its version and moves it
+// into place for the root helper. It returns the staged version.
+func (u *appUpdater) commit(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.f == nil {
+		return "", errUpdateNotBegun
+	}
+	defer u.resetLocked()
+
+	if u.written != u.size {
+		return "", fmt.Errorf("update: received %d of %d bytes", u.written, u.size)
+	}
+	if err := u.f.Sync(); err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	if !bytes.Equal(u.h.Sum(nil), u.sum) {
+		return "", errors.New("update: sha256 mismatch")
+	}
+
+	part := u.f.Name()
+	// Verify what is on disk, not what went through the hash.
+	bin, err := os.ReadFile(part)
+	if err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	if err := verifyUpdate(bin, u.sig); err != nil {
+		return "", err
+	}
+
+	if err := os.Chmod(part, 0o755); err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, part, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("update: new binary does not run: %w", err)
+	}
+	newVersion := strings.TrimSpace(string(out))
+
+	// The root helper checks the signature again on its own copy, since
+	// this user can still change the staged file after the check above.
+	if err := os.WriteFile(filepath.Join(u.dir, updateSigFile), u.sig, 0o600); err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	if err := os.Rename(part, filepath.Join(u.dir, updateStagedFile)); err != nil {
+		return "", fmt.Errorf("update: %w", err)
+	}
+	return newVersion, nil
+}
+
+func verifyUpdate(bin, sig []byte) error {
+	pub, err := hex.DecodeString(updatePublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errUpdateDisabled
+	}
+	if !ed25519.Verify(pub, bin, sig) {
+		return errUpdateVerify
+	}
+	return nil
+}
+
+// verifyUpdateFile is --verify-update, for apply-app-update.sh: it checks
+// a binary against a raw ed25519 signature with this build's key.
+func verifyUpdateFile(path, sigPath string) error {
+	bin, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	return verifyUpdate(bin, sig)
+}
+
+func (u *appUpdater) resetLocked() {
+	if u.f != nil {
+		name := u.f.Name()
+		_ = u.f.Close()
+		_ = os.Remove(name) // no-op after a successful rename
+	}
+	u.f, u.h = nil, nil
+	u.size, u.written = 0, 0
+	u.sum, u.sig = nil, nil
+}
+
+// confirmBoot reports this start as good once the process has stayed up for
+// bootConfirmDelay. select-app-slot.sh, which keeps the trial record where
+// the app cannot write, takes the marker before the next start and keeps a
+// slot on trial for good. Older images kept the trial record here, and the
+// app confirmed by deleting it.
+func confirmBoot(ctx context.Context, dir string, l *slog.Logger) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(bootConfirmDelay):
+	}
+	if err := os.WriteFile(filepath.Join(dir, updateConfirmed), nil, 0o600); err != nil {
+		l.Error("update: confirm boot", "err", err)
+		return
+	}
+	if err := os.Remove(filepath.Join(dir, updateTrialFile)); err == nil {
+		l.Info("update confirmed", "version", version)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		l.Error("update: confirm boot", "err", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+)
+
+// usbLink follows USB suspend/resume as reported by the registrar on the
+// enabled socket. While the host sleeps the endpoints stall; that is not a
+// broker failure and not a disconnect.
+type usbLink struct {
+	suspended   atomic.Bool
+	lastSuspend atomic.Int64 // unix nanos of the latest suspend
+}
+
+// watch reads link notices from the enabled connection until it closes,
+// which means the function was disabled, or until the host asks for a
+// function reset, which it reports as true. onSuspend is called on every
+// suspend and resume the connection reports, not when it ends.
+func (u *usbLink) watch(conn io.Reader, onSuspend func(bool), l *slog.Logger) (reset bool) {
+	defer u.suspended.Store(false)
+	r := bufio.NewReader(conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch b {
+		case common.LinkReset:
+			return true
+		case common.LinkSuspended:
+			u.lastSuspend.Store(time.Now().UnixNano())
+			if !u.suspended.Swap(true) {
+				l.Info("host suspended USB; pausing")
+				onSuspend(true)
+			}
+		case common.LinkResumed:
+			if u.suspended.Swap(false) {
+				l.Info("host resumed USB")
+				onSuspend(false)
+			}
+		}
+	}
+}
+
+// suspendedSince reports whether the link is or was suspended after t.
+func (u *usbLink) suspendedSince(t time.Time) bool {
+	return u.suspended.Load() || u.lastSuspend.Load() >= t.UnixNano()
+}
+
+// waitResumed blocks until the host resumes or ctx ends.
+func (u *usbLink) waitResumed(ctx context.Context) {
+	for u.suspended.Load() && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
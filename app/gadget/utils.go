@@ -14,7 +14,26 @@ func exists(p string) bool {
 	return err == nil
 }
 
-func waitForFunctionFSEndpoints(root string, timeout time.Duration) (in0, out0, in1, out1 string, err error) {
+// envBool reports whether an on/off setting is on ("1", "true", "on", "yes").
+func envBool(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// functionFSEndpoints are the endpoint files of the tezsign function. The
+// admin pair (IF2) is empty when the registrar predates it: the app is
+// updated separately from the rootfs that carries the registrar.
+type functionFSEndpoints struct {
+	in0, out0 string // IF0 sign
+	in1, out1 string // IF1 mgmt
+	in2, out2 string // IF2 admin (optional)
+}
+
+func waitForFunctionFSEndpoints(root string, timeout time.Duration) (functionFSEndpoints, error) {
 	deadline := time.Now().Add(timeout)
 
 	ep := func(n string) string { return filepath.Join(root, n) }
@@ -30,10 +49,19 @@ func waitForFunctionFSEndpoints(root string, timeout time.Duration) (in0, out0,
 		}
 
 		if ok {
-			return ep("ep1"), ep("ep2"), ep("ep3"), ep("ep4"), nil
+			// FunctionFS creates all endpoint files at once, so IF2 is
+			// either there already or not described at all.
+			eps := functionFSEndpoints{
+				in0: ep("ep1"), out0: ep("ep2"),
+				in1: ep("ep3"), out1: ep("ep4"),
+			}
+			if exists(ep("ep5")) && exists(ep("ep6")) {
+				eps.in2, eps.out2 = ep("ep5"), ep("ep6")
+			}
+			return eps, nil
 		}
 		if time.Now().After(deadline) {
-			return "", "", "", "", fmt.Errorf("timeout waiting for %s", strings.Join(want, ", "))
+			return functionFSEndpoints{}, fmt.Errorf("timeout waiting for %s", strings.Join(want, ", "))
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
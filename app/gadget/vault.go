@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tez-capital/tezsign/keychain"
+)
+
+const (
+	// envVault moves the keystore into a LUKS container opened with the
+	// master passphrase ("1" to enable). Set it before `init`.
+	envVault = "TEZSIGN_VAULT"
+
+	vaultSock      = "/run/tezsign-vault.sock"
+	vaultContainer = "/data/tezsign-vault.img"
+	vaultDir       = "/data/tezsign-vault.d" // mount point; outside DATA_STORE, see tezsign-vault.sh
+	vaultTimeout   = 2 * time.Minute
+)
+
+var (
+	errVaultBadPass = errors.New("vault: invalid passphrase")
+	errVaultMissing = errors.New("vault: not created yet (run init)")
+)
+
+// keystoreVault asks the root helper (tezsign-vault.sh) to create or open
+// the encrypted keystore. Until it is open the keystore directory is an
+// empty mount point. A nil *keystoreVault means the keystore is not
+// encrypted and is never sealed.
+type keystoreVault struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newKeystoreVault() *keystoreVault {
+	if !envBool(os.Getenv(envVault)) {
+		return nil
+	}
+	return &keystoreVault{dir: vaultDir}
+}
+
+// exists reports whether the container has been created.
+func (v *keystoreVault) exists() bool {
+	return v != nil && exists(vaultContainer)
+}
+
+// sealed reports whether the vault is configured but not mounted.
+func (v *keystoreVault) sealed() bool {
+	if v == nil {
+		return false
+	}
+	var dir, parent syscall.Stat_t
+	if syscall.Stat(v.dir, &dir) != nil || syscall.Stat(filepath.Dir(v.dir), &parent) != nil {
+		return true
+	}
+	return dir.Dev == parent.Dev
+}
+
+// open mounts the vault; with create it is made first when missing.
+func (v *keystoreVault) open(pass []byte, create bool) error {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.sealed() {
+		return nil
+	}
+	cmd := "open"
+	if !v.exists() {
+		if !create {
+			return errVaultMissing
+		}
+		cmd = "format"
+	}
+	return v.call(cmd, pass)
+}
+
+func (v *keystoreVault) call(cmd string, pass []byte) error {
+	conn, err := net.DialTimeout("unix", vaultSock, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("vault: helper unavailable: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(vaultTimeout))
+
+	msg := make([]byte, 0, len(cmd)+1+len(pass))
+	msg = append(append(append(msg, cmd...), '\n'), pass...)
+	_, err = conn.Write(msg)
+	clear(msg)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("vault: no reply from helper: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+	switch {
+	case reply == "ok":
+		return nil
+	case reply == "error: invalid passphrase":
+		return errVaultBadPass
+	default:
+		return fmt.Errorf("vault: %s", strings.TrimPrefix(reply, "error: "))
+	}
+}
+
+// openVault opens the vault (creating it if asked) and lays out the
+// keystore in it.
+func openVault(v *keystoreVault, fs *keychain.FileStore, pass []byte, create bool) error {
+	if !v.sealed() {
+		return nil
+	}
+	if err := v.open(pass, create); err != nil {
+		return err
+	}
+	return fs.EnsureDirs()
+}
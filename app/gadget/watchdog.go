@@ -0,0 +1,186 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/watchdog"
+)
+
+const (
+	// handlerStallAfter is how long one request may run before the signer
+	// counts as hung. Unlock (Argon2id) and vault opens take seconds.
+	handlerStallAfter = 2 * time.Minute
+	// brokerStallAfter is how long a stopped broker may wait to be rebuilt.
+	brokerStallAfter = brokerUnplugGrace + 30*time.Second
+	// storageProbeEvery limits probe writes to the SD card.
+	storageProbeEvery = time.Minute
+	storageProbeFile  = ".watchdog-probe"
+)
+
+// track wraps the innermost request handler so requests that never return
+// (timed out by handleWithLimits but still running) are noticed.
+func (h *healthMonitor) track(base broker.Handler) broker.Handler {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		h.mu.Lock()
+		h.nextCall++
+		id := h.nextCall
+		if h.inflight == nil {
+			h.inflight = map[uint64]time.Time{}
+		}
+		h.inflight[id] = time.Now()
+		h.mu.Unlock()
+		defer func() {
+			h.mu.Lock()
+			delete(h.inflight, id)
+			h.mu.Unlock()
+		}()
+		return base(ctx, payload)
+	}
+}
+
+// stalled returns why the signer should not be considered alive, or nil.
+func (h *healthMonitor) stalled(now time.Time) error {
+	h.mu.Lock()
+	var oldest time.Time
+	for _, started := range h.inflight {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = started
+		}
+	}
+	stopped := false
+	for _, b := range h.brokers {
+		select {
+		case <-b.Done():
+			stopped = true
+		default:
+		}
+	}
+	if !stopped {
+		h.brokerDeadSince = time.Time{}
+	} else if h.brokerDeadSince.IsZero() {
+		h.brokerDeadSince = now
+	}
+	deadSince := h.brokerDeadSince
+	h.mu.Unlock()
+
+	// A suspended host stalls endpoint I/O; requests and brokers wait for it.
+	suspended := h.link.suspendedSince(now.Add(-handlerStallAfter))
+	if !oldest.IsZero() && now.Sub(oldest) > handlerStallAfter && !suspended {
+		return fmt.Errorf("a request has been running for %s", now.Sub(oldest).Round(time.Second))
+	}
+	if !deadSince.IsZero() && now.Sub(deadSince) > brokerStallAfter && !suspended {
+		return fmt.Errorf("a broker stopped %s ago and was not rebuilt", now.Sub(deadSince).Round(time.Second))
+	}
+	if now.Sub(h.probedAt) >= storageProbeEvery {
+		h.probeErr, h.probedAt = probeStorage(h.dataDir), now
+	}
+	if h.probeErr != nil {
+		return fmt.Errorf("keystore not writable: %w", h.probeErr)
+	}
+	return nil
+}
+
+// probeStorage writes and syncs a small file where watermarks are kept.
+func probeStorage(dir string) error {
+	p := filepath.Join(dir, storageProbeFile)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(time.Now().UTC().Format(time.RFC3339))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(p); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// statusEvery is how often the state in `systemctl status` is refreshed
+// when there is no watchdog to ping.
+const statusEvery = 5 * time.Second
+
+// status is the line shown by `systemctl status`; unhealthy is the last
+// stall found, if any.
+func (h *healthMonitor) status(unhealthy error) string {
+	h.mu.Lock()
+	connected := len(h.brokers) > 0
+	h.mu.Unlock()
+
+	switch {
+	case h.kr.Tampered():
+		return "tampered; keys locked"
+	case unhealthy != nil:
+		return "unhealthy: " + unhealthy.Error()
+	case h.link.suspended.Load():
+		return "USB host suspended"
+	case !connected:
+		return "waiting for USB host"
+	}
+	unlocked := h.kr.UnlockedCount()
+	if total, err := h.kr.KeyCount(); err == nil {
+		return fmt.Sprintf("%d of %d keys unlocked", unlocked, total)
+	}
+	return fmt.Sprintf("%d keys unlocked", unlocked)
+}
+
+// petWatchdog reports readiness and state to systemd and pings its
+// watchdog only while the signer is healthy, so a hung signer gets
+// restarted instead of kept alive by a ticker. Only called from the
+// pinging goroutine: probe state in hm is not locked.
+func petWatchdog(ctx context.Context, hm *healthMonitor, l *slog.Logger) {
+	n := watchdog.New()
+	if err := n.Ready(); err != nil {
+		l.Warn("systemd notify", "err", err)
+	}
+	if n == nil {
+		return
+	}
+	n.OnFailure(3, func(err error, failing int) {
+		l.Error("systemd notifications failing; the watchdog may restart the signer", "err", err, "in_a_row", failing)
+	})
+	every := watchdog.Interval()
+	if every > 0 {
+		l.Info("watchdog enabled", "ping_every", every)
+	}
+
+	t := time.NewTicker(cmp.Or(every, statusEvery))
+	defer t.Stop()
+	var failing error
+	var shown string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if every > 0 {
+				err := hm.stalled(now)
+				switch {
+				case err != nil && failing == nil:
+					l.Error("signer unhealthy; withholding watchdog ping", "err", err)
+				case err == nil && failing != nil:
+					l.Info("signer healthy again; watchdog pings resumed")
+				}
+				failing = err
+				if err == nil {
+					_ = n.Ping()
+				}
+			}
+			if status := hm.status(failing); status != shown {
+				shown = status
+				_ = n.Status(status)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// keysFilePollInterval is how often the keys file mtime is checked for changes.
+const keysFilePollInterval = 2 * time.Second
+
+// allowedKeys is the set of keys served over HTTP. It is immutable and
+// swapped as a whole on reload.
+type allowedKeys struct {
+	tz4     map[string]tz4CacheEntry
+	byAlias map[string]string // key id -> tz4
+}
+
+func (a *allowedKeys) lookup(tz4 string) (tz4CacheEntry, bool) {
+	e, ok := a.tz4[tz4]
+	return e, ok
+}
+
+func (a *allowedKeys) set() map[string]struct{} {
+	out := make(map[string]struct{}, len(a.tz4))
+	for tz4 := range a.tz4 {
+		out[tz4] = struct{}{}
+	}
+	return out
+}
+
+// buildAllowedKeys resolves aliases against the device status. An empty
+// alias list allows every key on the device.
+func buildAllowedKeys(st *signer.StatusResponse, aliases []string, l *slog.Logger) (*allowedKeys, error) {
+	if st == nil || len(st.GetKeys()) == 0 {
+		return nil, ErrDeviceHasNoKeys
+	}
+
+	if len(aliases) == 0 {
+		aliases = make([]string, 0, len(st.GetKeys()))
+		for _, k := range st.GetKeys() {
+			aliases = append(aliases, k.GetKeyId())
+		}
+		l.Info("no aliases provided; allowing all keys from device", slog.Any("keys", aliases))
+	}
+
+	// Index status for quick lookups and verify unlocked
+	known := make(map[string]*signer.KeyStatus, len(st.GetKeys()))
+	for _, k := range st.GetKeys() {
+		known[k.GetKeyId()] = k
+	}
+
+	ak := &allowedKeys{
+		tz4:     make(map[string]tz4CacheEntry, len(aliases)),
+		byAlias: make(map[string]string, len(aliases)),
+	}
+	locked := []string{}
+	missing := []string{}
+	for _, a := range aliases {
+		ks, ok := known[a]
+		if !ok {
+			missing = append(missing, a)
+			continue
+		}
+		if ks.GetLockState() != signer.LockState_UNLOCKED {
+			locked = append(locked, a)
+		}
+		ak.byAlias[a] = ks.GetTz4()
+		ak.tz4[ks.GetTz4()] = tz4CacheEntry{
+			publicKey: ks.GetBlPubkey(),
+			pop:       ks.GetPop(),
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("one or more invalid key aliases provided: %s", strings.Join(missing, ", "))
+	}
+	if len(locked) > 0 {
+		// Don’t hard-fail. HTTP /sign will 403 on locked keys anyway.
+		l.Warn("some allowed keys are locked; requests for them will be denied",
+			slog.String("locked", strings.Join(locked, ", ")))
+	}
+	return ak, nil
+}
+
+// readKeysFile reads one alias per line; blank lines and '#' comments are ignored.
+func readKeysFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out, sc.Err()
+}
+
+// keySource yields the configured aliases: the keys file if set, otherwise
+// env/args. Companions of listed consensus keys are served with them.
+type keySource struct {
+	file       string
+	args       []string
+	companions companionMap
+}
+
+func (s keySource) aliases() ([]string, error) {
+	if s.file != "" {
+		aliases, err := readKeysFile(s.file)
+		if err != nil {
+			return nil, err
+		}
+		return s.companions.expand(aliases), nil
+	}
+	return s.companions.expand(resolveKeysFromEnvOrArgs(s.args)), nil
+}
+
+// watchAllowedKeys rebuilds the allow-list on SIGHUP or when the keys file
+// changes. A failed reload keeps the previous list.
+func watchAllowedKeys(ctx context.Context, l *slog.Logger, src keySource, getB func() *broker.Broker, keys *atomic.Pointer[allowedKeys], onReload func(*signer.StatusResponse, *allowedKeys)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	var lastMod time.Time
+	if src.file != "" {
+		if fi, err := os.Stat(src.file); err == nil {
+			lastMod = fi.ModTime()
+		}
+		t := time.NewTicker(keysFilePollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+
+	reload := func(reason string) {
+		aliases, err := src.aliases()
+		if err != nil {
+			l.Error("reload allowed keys", slog.String("reason", reason), slog.Any("err", err))
+			return
+		}
+		st, err := common.ReqStatus(getB())
+		if err != nil {
+			l.Error("reload allowed keys: status", slog.String("reason", reason), slog.Any("err", err))
+			return
+		}
+		ak, err := buildAllowedKeys(st, aliases, l)
+		if err != nil {
+			l.Error("reload allowed keys", slog.String("reason", reason), slog.Any("err", err))
+			return
+		}
+		keys.Store(ak)
+		if onReload != nil {
+			onReload(st, ak)
+		}
+		l.Info("allowed keys reloaded", slog.String("reason", reason), slog.Int("count", len(ak.tz4)))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload("SIGHUP")
+		case <-poll:
+			fi, err := os.Stat(src.file)
+			if err != nil || fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			reload("keys file changed")
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/urfave/cli/v3"
+)
+
+const attestNonceSize = 32
+
+func cmdAttest() *cli.Command {
+	return &cli.Command{
+		Name:  "attest",
+		Usage: "Challenge the gadget to prove its identity and report the firmware it runs",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "identity",
+				Usage: "Expected device identity public key (hex); fail on mismatch",
+			},
+			&cli.StringFlag{
+				Name:  "app-sha256",
+				Usage: "Expected SHA-256 of the gadget app binary (hex); fail on mismatch",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			nonce := make([]byte, attestNonceSize)
+			if _, err := rand.Read(nonce); err != nil {
+				return err
+			}
+			res, err := common.ReqAttest(h.Session.Broker, nonce)
+			if err != nil {
+				return err
+			}
+			st, err := signer.VerifyAttestation(res, nonce)
+			if err != nil {
+				return err
+			}
+
+			identity := hex.EncodeToString(res.GetIdentityPublicKey())
+			app := hex.EncodeToString(st.GetAppSha256())
+			var mismatch []string
+			if want := c.String("identity"); want != "" && !hexEqual(want, res.GetIdentityPublicKey()) {
+				mismatch = append(mismatch, "identity")
+			}
+			if want := c.String("app-sha256"); want != "" && !hexEqual(want, st.GetAppSha256()) {
+				mismatch = append(mismatch, "app-sha256")
+			}
+
+			if !isTTY(os.Stdout) {
+				out := map[string]any{
+					"ok":             len(mismatch) == 0,
+					"identity":       identity,
+					"version":        st.GetVersion(),
+					"app_sha256":     app,
+					"config_sha256":  hex.EncodeToString(st.GetConfigSha256()),
+					"kernel_release": st.GetKernelRelease(),
+				}
+				if len(mismatch) > 0 {
+					out["mismatch"] = mismatch
+				}
+				if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+					return err
+				}
+			} else {
+				fmt.Printf("Identity:  %s\n", identity)
+				fmt.Printf("Version:   %s\n", st.GetVersion())
+				fmt.Printf("App:       sha256:%s\n", app)
+				fmt.Printf("Config:    sha256:%x\n", st.GetConfigSha256())
+				fmt.Printf("Kernel:    %s\n", st.GetKernelRelease())
+				fmt.Println("Signature: valid for this challenge")
+			}
+
+			if len(mismatch) > 0 {
+				return fmt.Errorf("attestation does not match the expected %s", strings.Join(mismatch, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+func hexEqual(want string, got []byte) bool {
+	b, err := hex.DecodeString(strings.TrimSpace(want))
+	return err == nil && bytes.Equal(b, got)
+}
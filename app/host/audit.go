@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tez-capital/tezsign/audit"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/urfave/cli/v3"
+)
+
+func cmdAudit() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Print the gadget's hash-chained sign audit trail (JSON lines)",
+		Flags: []cli.Flag{
+			&cli.Uint64Flag{
+				Name:  "since",
+				Usage: "Only records with a sequence number above this one",
+			},
+			&cli.BoolFlag{
+				Name:  "verify",
+				Usage: "Check the chain here and on the gadget instead of printing it",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			var v audit.Verifier
+			var chainErr error
+			after := c.Uint64("since")
+			for {
+				recs, more, err := common.ReqAudit(b, after, 0)
+				if err != nil {
+					return err
+				}
+				for _, rec := range recs {
+					if c.Bool("verify") {
+						if chainErr == nil {
+							chainErr = v.Add(rec)
+						}
+					} else {
+						fmt.Println(string(rec))
+					}
+					if seq, _, err := audit.Parse(rec); err == nil {
+						after = seq
+					}
+				}
+				if !more || len(recs) == 0 {
+					break
+				}
+			}
+			if !c.Bool("verify") {
+				return nil
+			}
+
+			dev, err := common.ReqAuditVerify(b)
+			if err != nil {
+				return err
+			}
+			// Records may have been added between the two checks; compare
+			// heads only when both stopped at the same record.
+			if chainErr == nil && dev.GetOk() && dev.GetLastSeq() == v.Last &&
+				hex.EncodeToString(dev.GetHeadHash()) != v.Head.String() {
+				chainErr = fmt.Errorf("%w: gadget and host disagree on record %d", audit.ErrBrokenLink, v.Last)
+			}
+
+			if !isTTY(os.Stdout) {
+				out := map[string]any{
+					"ok":           chainErr == nil && dev.GetOk(),
+					"first_seq":    v.First,
+					"last_seq":     v.Last,
+					"head":         v.Head.String(),
+					"gadget_ok":    dev.GetOk(),
+					"gadget_error": dev.GetError(),
+				}
+				if chainErr != nil {
+					out["error"] = chainErr.Error()
+				}
+				if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+					return err
+				}
+			} else {
+				if chainErr == nil {
+					fmt.Printf("host:   records %d..%d intact, head %s\n", v.First, v.Last, v.Head)
+				} else {
+					fmt.Printf("host:   %v\n", chainErr)
+				}
+				if dev.GetOk() {
+					fmt.Printf("gadget: records %d..%d intact\n", dev.GetFirstSeq(), dev.GetLastSeq())
+				} else {
+					fmt.Printf("gadget: %s\n", dev.GetError())
+				}
+			}
+
+			switch {
+			case chainErr != nil:
+				return chainErr
+			case !dev.GetOk():
+				return fmt.Errorf("gadget: %s", dev.GetError())
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/urfave/cli/v3"
+)
+
+// syncDeviceClock pushes the host clock to the gadget. Failures only cost
+// meaningful gadget timestamps, so they are logged and otherwise ignored.
+// Only the management and admin interfaces take set_time, so a sign
+// session borrows a short admin one (IF1 on older gadgets).
+func syncDeviceClock(s *common.Session, ch common.Channel, l *slog.Logger) {
+	b := s.Broker
+	if ch == common.ChanSign {
+		aside, err := common.Connect(common.ConnectParams{Serial: s.Serial, Logger: l, Channel: common.ChanAdmin})
+		if err != nil {
+			l.Warn("could not set gadget clock", slog.String("serial", s.Serial), slog.Any("err", err))
+			return
+		}
+		defer aside.Close()
+		b = aside.Broker
+	}
+	res, err := common.ReqSetTime(b, time.Now(), nil)
+	switch {
+	case errors.Is(err, common.ErrUnknownRequest):
+		l.Debug("gadget does not support set_time", slog.String("serial", s.Serial))
+	case err != nil:
+		l.Warn("could not set gadget clock", slog.String("serial", s.Serial), slog.Any("err", err))
+	case res.GetStepped():
+		l.Info("gadget clock set", slog.String("serial", s.Serial),
+			slog.Time("was", time.Unix(0, res.GetPreviousUnixNanos())))
+	}
+}
+
+func cmdClock() *cli.Command {
+	return &cli.Command{
+		Name:  "clock",
+		Usage: "Set the gadget clock to the host's; --reset also moves it back (requires master passphrase)",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "reset",
+				Usage: "Allow moving the clock back, e.g. after a host pushed it into the future",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			var pass []byte
+			if c.Bool("reset") {
+				var err error
+				if pass, err = obtainPassword("Master passphrase", false); err != nil {
+					return fmt.Errorf("clock: %w", err)
+				}
+				defer keychain.MemoryWipe(pass)
+			}
+
+			now := time.Now()
+			res, err := common.ReqSetTime(h.Session.Broker, now, pass)
+			if err != nil {
+				return err
+			}
+			was := time.Unix(0, res.GetPreviousUnixNanos())
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(map[string]any{"stepped": res.GetStepped(), "was": was, "now": now})
+			}
+			if res.GetStepped() {
+				fmt.Printf("Gadget clock moved from %s to %s.\n", was.Format(time.RFC3339), now.Format(time.RFC3339))
+			} else {
+				fmt.Println("Gadget clock already agrees with the host.")
+			}
+			return nil
+		},
+	}
+}
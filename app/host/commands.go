@@ -7,11 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -20,7 +23,9 @@ import (
 	"github.com/tez-capital/tezsign/broker"
 	"github.com/tez-capital/tezsign/common"
 	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/logging"
 	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/watchdog"
 	"github.com/urfave/cli/v3"
 	"golang.org/x/term"
 )
@@ -45,7 +50,21 @@ func cmdListDevices() *cli.Command {
 				return nil
 			}
 			for i, inf := range infos {
-				fmt.Printf("%d) serial=%s manufacturer=%s product=%s\n", i, inf.Serial, inf.Manufacturer, inf.Product)
+				fmt.Printf("%d) serial=%s manufacturer=%s product=%s", i, inf.Serial, inf.Manufacturer, inf.Product)
+				if gi := inf.Info; gi != nil && gi.Version != "" {
+					fmt.Printf(" firmware=%s", gi.Version)
+				}
+				if inf.Interfaces == 0 {
+					fmt.Print(" (no FFS interfaces)")
+				}
+				if st := inf.State; st != nil {
+					if st.Ready {
+						fmt.Printf(" keys=%d unlocked=%d locked=%d uptime=%s", st.Keys, st.Unlocked, st.Locked, time.Duration(st.UptimeSeconds)*time.Second)
+					} else {
+						fmt.Print(" (app not ready)")
+					}
+				}
+				fmt.Println()
 			}
 			return nil
 		},
@@ -87,14 +106,17 @@ func cmdInit() *cli.Command {
 			}
 			defer keychain.MemoryWipe(pass)
 
-			confirm, err := obtainPassword("Confirm master passphrase", false)
-			if err != nil {
-				return fmt.Errorf("init master: %w", err)
-			}
-			defer keychain.MemoryWipe(confirm)
+			// Nothing to confirm against when the passphrase comes from a file/fd.
+			if !hasPasswordSource() {
+				confirm, err := obtainPassword("Confirm master passphrase", false)
+				if err != nil {
+					return fmt.Errorf("init master: %w", err)
+				}
+				defer keychain.MemoryWipe(confirm)
 
-			if subtle.ConstantTimeCompare(pass, confirm) != 1 {
-				return fmt.Errorf("passphrases do not match")
+				if subtle.ConstantTimeCompare(pass, confirm) != 1 {
+					return fmt.Errorf("passphrases do not match")
+				}
 			}
 
 			ok, err := common.ReqInitMaster(b, c.Bool("deterministic"), pass)
@@ -102,6 +124,7 @@ func cmdInit() *cli.Command {
 				l.Warn("init master", slog.Any("err", err))
 			} else if ok {
 				fmt.Println("Master initialized.")
+				return verifyIdentity(h.Session, l)
 			}
 			return nil
 		},
@@ -118,11 +141,66 @@ func cmdRun() *cli.Command {
 				Name:  "listen",
 				Usage: fmt.Sprintf("HTTP listen address (default port %s). If empty, no server is started.", defaultPort),
 			},
+			&cli.StringFlag{
+				Name:    "keys-file",
+				Usage:   "File with one allowed key alias per line; reloaded on change or SIGHUP (overrides args and env)",
+				Sources: cli.EnvVars(envKeysFile),
+			},
 			&cli.BoolFlag{
 				Name:  "no-retry",
 				Usage: "Exit with non-zero on disconnect instead of auto-retrying",
 				Value: false,
 			},
+			&cli.StringFlag{
+				Name:    "magic-bytes",
+				Usage:   "Comma-separated watermark bytes any key may sign over HTTP (e.g. 0x11,0x12,0x13). Empty allows all.",
+				Sources: cli.EnvVars(envMagicBytes),
+			},
+			&cli.StringFlag{
+				Name:    "key-magic-bytes",
+				Usage:   "Per-key overrides of --magic-bytes, e.g. \"baker=0x11,0x12;companion=0x13\" (alias or tz4)",
+				Sources: cli.EnvVars(envKeyMagicBytes),
+			},
+			&cli.StringFlag{
+				Name:    "allow-ip",
+				Usage:   "Comma-separated source IPs/CIDRs allowed to reach the HTTP API (e.g. 127.0.0.1,10.0.0.0/24). Empty allows all.",
+				Sources: cli.EnvVars(envAllowIP),
+			},
+			&cli.StringFlag{
+				Name:    "route-allow-ip",
+				Usage:   "Per-path overrides of --allow-ip, e.g. \"/keys=10.0.0.5;/authorized_keys=10.0.0.0/24\"",
+				Sources: cli.EnvVars(envRouteAllow),
+			},
+			&cli.StringFlag{
+				Name:    "backup-device",
+				Usage:   "USB serial of a backup gadget holding the same keys; signing fails over to it if the primary goes away, after its watermarks are raised past what the primary may have signed",
+				Sources: cli.EnvVars(envBackup),
+			},
+			&cli.DurationFlag{
+				Name:  "sign-timeout",
+				Usage: "End-to-end deadline for one sign request, retries included; expired requests get HTTP 504",
+				Value: common.DefaultSignTimeout,
+			},
+			&cli.IntFlag{
+				Name:  "sign-retries",
+				Usage: "Extra attempts when the USB transport fails mid-request (within --sign-timeout)",
+				Value: 1,
+			},
+			&cli.DurationFlag{
+				Name:  "sign-retry-backoff",
+				Usage: "Pause before each sign retry",
+				Value: 100 * time.Millisecond,
+			},
+			&cli.DurationFlag{
+				Name:  "sig-cache-ttl",
+				Usage: "How long to answer identical sign retries from cache instead of the device (0 disables)",
+				Value: time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  "shutdown-timeout",
+				Usage: "On SIGTERM/SIGINT, how long to wait for in-flight sign requests before tearing down the device session",
+				Value: 10 * time.Second,
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
@@ -145,68 +223,113 @@ func cmdRun() *cli.Command {
 			if st == nil || len(st.GetKeys()) == 0 {
 				return ErrDeviceHasNoKeys
 			}
+			// a fresh challenge right before serving, not only the one at connect
+			if err := verifyIdentity(h.Session, l); err != nil {
+				return fmt.Errorf("run: %w", err)
+			}
 
-			// Build allow-list from env or args; if empty, allow ALL existing keys
-			allow := resolveKeysFromEnvOrArgs(c.Args().Slice())
-			if len(allow) == 0 {
-				allow = make([]string, 0, len(st.GetKeys()))
-				for _, k := range st.GetKeys() {
-					allow = append(allow, k.GetKeyId())
-				}
-				if len(allow) == 0 {
-					return ErrDeviceHasNoKeys
-				}
-				l.Info("no aliases provided; allowing all keys from device", slog.Any("keys", allow))
+			companions, err := companionKeys(c)
+			if err != nil {
+				return err
+			}
+			if err := companions.validate(st); err != nil {
+				return fmt.Errorf("run: %w", err)
 			}
 
-			allowSet := make(map[string]struct{}, len(allow))
+			// Build allow-list from keys file, env or args; if empty, allow ALL existing keys
+			src := keySource{file: strings.TrimSpace(c.String("keys-file")), args: c.Args().Slice(), companions: companions}
+			allow, err := src.aliases()
+			if err != nil {
+				return fmt.Errorf("run: keys file: %w", err)
+			}
+			ak, err := buildAllowedKeys(st, allow, l)
+			if err != nil {
+				return err
+			}
+			var keys atomic.Pointer[allowedKeys]
+			keys.Store(ak)
+			allowSet := ak.set()
 
-			// Index status for quick lookups and verify unlocked
-			known := make(map[string]*signer.KeyStatus, len(st.GetKeys()))
-			locked := []string{}
-			missing := []string{}
-			for _, k := range st.GetKeys() {
-				known[k.GetKeyId()] = k
+			policy := &magicPolicy{keys: &keys}
+			if v := strings.TrimSpace(c.String("magic-bytes")); v != "" {
+				if policy.def, err = parseMagicBytes(v); err != nil {
+					return fmt.Errorf("--magic-bytes: %w", err)
+				}
 			}
-			for _, a := range allow {
-				ks, ok := known[a]
+			if v := strings.TrimSpace(c.String("key-magic-bytes")); v != "" {
+				known := func(k string) bool {
+					cur := keys.Load()
+					if _, ok := cur.byAlias[k]; ok {
+						return true
+					}
+					_, ok := cur.tz4[k]
+					return ok
+				}
+				if policy.perKey, err = parseKeyMagicBytes(v, known); err != nil {
+					return fmt.Errorf("--key-magic-bytes: %w", err)
+				}
+			}
+			// Companion keys only sign DAL attestations unless configured otherwise.
+			for _, companion := range companions {
+				tz4, ok := ak.byAlias[companion]
 				if !ok {
-					missing = append(missing, a)
 					continue
 				}
-				if ks.GetLockState().String() != "UNLOCKED" {
-					locked = append(locked, a)
+				if _, set := policy.forKey(tz4); set {
+					continue
+				}
+				if policy.perKey == nil {
+					policy.perKey = make(map[string]map[byte]struct{})
 				}
-				allowSet[ks.GetTz4()] = struct{}{}
+				policy.perKey[companion] = companionMagic
 			}
-			if len(missing) > 0 {
-				return fmt.Errorf("one or more invalid key aliases provided: %s", strings.Join(missing, ", "))
+
+			sp := signPolicy{
+				timeout: c.Duration("sign-timeout"),
+				retries: int(c.Int("sign-retries")),
+				backoff: c.Duration("sign-retry-backoff"),
 			}
-			if len(locked) > 0 {
-				// Don’t hard-fail. HTTP /sign will 403 on locked keys anyway.
-				l.Warn("some allowed keys are locked; requests for them will be denied",
-					slog.String("locked", strings.Join(locked, ", ")))
+			if sp.timeout <= 0 || sp.retries < 0 {
+				return fmt.Errorf("--sign-timeout must be > 0 and --sign-retries >= 0")
 			}
 
-			cachedKeys := make(map[string]tz4CacheEntry, len(allowSet))
-			for _, ks := range st.GetKeys() {
-				tz4 := ks.GetTz4()
-				if _, ok := allowSet[tz4]; !ok {
-					continue
+			var ipf *ipFilter
+			if v := strings.TrimSpace(c.String("allow-ip")); v != "" {
+				ipf = &ipFilter{}
+				if ipf.def, err = parseCIDRs(v); err != nil {
+					return fmt.Errorf("--allow-ip: %w", err)
+				}
+			}
+			if v := strings.TrimSpace(c.String("route-allow-ip")); v != "" {
+				if ipf == nil {
+					ipf = &ipFilter{}
 				}
-				cachedKeys[tz4] = tz4CacheEntry{
-					publicKey: ks.GetBlPubkey(),
-					pop:       ks.GetPop(),
+				if ipf.routes, err = parseRouteAllow(v); err != nil {
+					return fmt.Errorf("--route-allow-ip: %w", err)
 				}
 			}
 
+			var fo *failover
+			if backup := strings.TrimSpace(c.String("backup-device")); backup != "" {
+				if backup == h.Session.Serial {
+					return fmt.Errorf("--backup-device %s is the primary device; select the primary with --device", backup)
+				}
+				fo = newFailover(l, &current, backup, st, allowSet)
+				if err := fo.probe(); err != nil {
+					return err
+				}
+			}
+
+			go watchAllowedKeys(ctx, l, src, getBroker, &keys, fo.setKeys)
+
 			addr := c.String("listen")
 			noRetry := c.Bool("no-retry")
 
 			// Only start HTTP if --listen was provided at all
 			if !c.IsSet("listen") {
 				fmt.Println("Connected; no --listen provided. Press Ctrl+C to quit.")
-				return runWatchdog(ctx, &current, h, noRetry)
+				startLiveness(ctx, getBroker, l)
+				return runWatchdog(ctx, &current, h, noRetry, fo)
 			}
 
 			if _, _, err := net.SplitHostPort(addr); err != nil {
@@ -214,20 +337,30 @@ func cmdRun() *cli.Command {
 			}
 
 			// Start HTTP server with allow-list
-			app := buildFiberApp(getBroker, l, allowSet, cachedKeys)
+			var inflight sync.WaitGroup
+			tel := &telemetryCache{}
+			go followTelemetry(ctx, getBroker, tel, l)
+			app := buildFiberApp(getBroker, tel, l.With(logging.ComponentKey, "http"), &keys, policy, ipf, fo, &inflight, newSigCache(c.Duration("sig-cache-ttl")), sp)
 
+			ln, err := listenHTTP(addr, l)
+			if err != nil {
+				return fmt.Errorf("--listen: %w", err)
+			}
+			startLiveness(ctx, getBroker, l)
 			httpErrCh := make(chan error, 1)
 			go func() {
 				l.Debug("HTTP server listening", slog.String("addr", addr))
-				if err := app.Listen(addr); err != nil {
+				if err := app.Listener(ln); err != nil {
 					httpErrCh <- err
 				}
 			}()
 
 			// watchdog runs alongside HTTP; if it exits with error, stop HTTP and bubble up
+			wdCtx, stopWd := context.WithCancel(ctx)
+			defer stopWd()
 			wdErrCh := make(chan error, 1)
 			go func() {
-				if err := runWatchdog(ctx, &current, h, noRetry); err != nil {
+				if err := runWatchdog(wdCtx, &current, h, noRetry, fo); err != nil {
 					wdErrCh <- err
 				} else {
 					wdErrCh <- nil
@@ -240,9 +373,26 @@ func cmdRun() *cli.Command {
 
 			select {
 			case <-sigCh:
-				ctxTO, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				// Order matters: stop taking requests, let in-flight signatures reach
+				// the baker, tell systemd, and only then drop the broker.
+				timeout := c.Duration("shutdown-timeout")
+				l.Info("shutting down; draining in-flight requests", slog.Duration("timeout", timeout))
+				// a --shutdown-timeout past TimeoutStopSec must not get us killed
+				n := watchdog.New()
+				_ = n.ExtendTimeout(timeout + 10*time.Second)
+				_ = n.Status("draining in-flight sign requests")
+				ctxTO, cancel := context.WithTimeout(context.Background(), timeout)
 				defer cancel()
 				_ = app.ShutdownWithContext(ctxTO)
+				if !waitInflight(ctxTO, &inflight) {
+					l.Warn("shutdown timeout reached with sign requests still in flight")
+				}
+				_ = n.Stopping()
+
+				stopWd()
+				<-wdErrCh
+				// the watchdog may have replaced the session; make sure the live one is closed
+				h.Session = current.Load().(*cur).sess
 				return nil
 			case err := <-httpErrCh:
 				return err
@@ -338,6 +488,144 @@ func cmdNewKeys() *cli.Command {
 	}
 }
 
+func cmdKeygen() *cli.Command {
+	return &cli.Command{
+		Name:      "keygen",
+		Usage:     "Create a single key on the device and print its tz4, BLpk and proof of possession",
+		ArgsUsage: "<alias>",
+		Flags: []cli.Flag{
+			&cli.UintFlag{
+				Name:  "hd-index",
+				Usage: "HD derivation index (deterministic mode only; 0 = next free index)",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace an existing key with the same alias (the old key is kept aside on the device)",
+			},
+			&cli.BoolFlag{
+				Name:  "reuse-hd-index",
+				Usage: "Allow an --hd-index that may already be in use, re-deriving its key; it starts at the highest watermark on record",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("usage: keygen <alias> [--hd-index N [--reuse-hd-index]] [--force]")
+			}
+			alias := strings.TrimSpace(c.Args().First())
+			if alias == "" {
+				return fmt.Errorf("empty alias")
+			}
+			hdIndex := c.Uint("hd-index")
+			if hdIndex > math.MaxUint32 {
+				return fmt.Errorf("hd-index %d out of range", hdIndex)
+			}
+
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			st, err := common.ReqStatus(b)
+			if err != nil {
+				return err
+			}
+			if !c.Bool("force") && slices.ContainsFunc(st.GetKeys(), func(k *signer.KeyStatus) bool { return k.GetKeyId() == alias }) {
+				return fmt.Errorf("keygen: key %q already exists (use --force to replace it)", alias)
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("keygen: %w", err)
+			}
+			defer keychain.MemoryWipe(pass)
+
+			results, err := common.ReqNewKeysWith(b, &signer.NewKeysRequest{
+				KeyIds:       []string{alias},
+				HdIndex:      uint32(hdIndex),
+				Overwrite:    c.Bool("force"),
+				ReuseHdIndex: c.Bool("reuse-hd-index"),
+			}, pass)
+			if err != nil {
+				return err
+			}
+			if len(results) != 1 {
+				return fmt.Errorf("keygen: unexpected %d results", len(results))
+			}
+			r := results[0]
+			if !r.GetOk() {
+				return fmt.Errorf("keygen: %s", r.GetError())
+			}
+
+			// The PoP is computed on creation and served with the status.
+			var pop string
+			if st, err = common.ReqStatus(b); err == nil {
+				for _, k := range st.GetKeys() {
+					if k.GetKeyId() == r.GetKeyId() {
+						pop = k.GetPop()
+					}
+				}
+			}
+
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(map[string]string{
+					"id":        r.GetKeyId(),
+					"tz4":       r.GetTz4(),
+					"bl_pubkey": r.GetBlPubkey(),
+					"pop":       pop,
+				})
+			}
+			fmt.Printf("id:   %s\ntz4:  %s\nBLpk: %s\nPoP:  %s\n", r.GetKeyId(), r.GetTz4(), r.GetBlPubkey(), pop)
+			return nil
+		},
+	}
+}
+
+func cmdPop() *cli.Command {
+	return &cli.Command{
+		Name:      "pop",
+		Usage:     "Print the BLS proof of possession needed to register a tz4 consensus key",
+		ArgsUsage: "<alias|tz4>",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("usage: pop <alias|tz4>")
+			}
+			want := strings.TrimSpace(c.Args().First())
+
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			st, err := common.ReqStatus(b)
+			if err != nil {
+				return err
+			}
+			var ks *signer.KeyStatus
+			for _, k := range st.GetKeys() {
+				if k.GetKeyId() == want || k.GetTz4() == want {
+					ks = k
+					break
+				}
+			}
+			if ks == nil {
+				return fmt.Errorf("key %q not found", want)
+			}
+
+			pop, err := common.ReqPop(b, ks.GetTz4())
+			if err != nil {
+				return err
+			}
+
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(map[string]string{
+					"id":        ks.GetKeyId(),
+					"tz4":       ks.GetTz4(),
+					"bl_pubkey": ks.GetBlPubkey(),
+					"pop":       pop,
+				})
+			}
+			fmt.Printf("id:   %s\ntz4:  %s\nBLpk: %s\nPoP:  %s\n", ks.GetKeyId(), ks.GetTz4(), ks.GetBlPubkey(), pop)
+			return nil
+		},
+	}
+}
+
 func cmdStatus() *cli.Command {
 	return &cli.Command{
 		Name:      "status",
@@ -357,6 +645,10 @@ func cmdStatus() *cli.Command {
 			if err != nil {
 				return err
 			}
+			companions, err := companionKeys(c)
+			if err != nil {
+				return err
+			}
 			filter := map[string]bool{}
 			for _, k := range c.Args().Slice() {
 				filter[k] = true
@@ -369,13 +661,29 @@ func cmdStatus() *cli.Command {
 						if len(filter) > 0 && !filter[ks.GetKeyId()] {
 							continue
 						}
-						out = append(out, getKeysStatusJSON(ks))
+						j := getKeysStatusJSON(ks)
+						j.Companion = companions[ks.GetKeyId()]
+						j.CompanionOf, _ = companions.companionOf(ks.GetKeyId())
+						out = append(out, j)
 					}
 				}
 				return json.NewEncoder(os.Stdout).Encode(out)
 			}
 
+			if st.GetVaultSealed() {
+				fmt.Println("Keystore vault is sealed; unlock to list keys.")
+				return nil
+			}
+
 			if c.Bool("full") {
+				if r := st.GetRelease(); r != nil {
+					printRelease(r)
+				}
+				if hl, err := common.ReqHealth(b); err != nil {
+					fmt.Printf("health: unavailable (%v)\n\n", err)
+				} else {
+					printHealth(hl)
+				}
 				for _, k := range st.GetKeys() {
 					if len(filter) > 0 && !filter[k.GetKeyId()] {
 						continue
@@ -388,6 +696,12 @@ func cmdStatus() *cli.Command {
 
 					fmt.Printf("%s  [%s]\n", k.GetKeyId(), state)
 					fmt.Printf("  tz4:       %s\n", k.GetTz4())
+					if c, ok := companions[k.GetKeyId()]; ok {
+						fmt.Printf("  companion: %s\n", c)
+					}
+					if c, ok := companions.companionOf(k.GetKeyId()); ok {
+						fmt.Printf("  companion of: %s\n", c)
+					}
 					fmt.Printf("  BLpk:      %s\n", k.GetBlPubkey())
 					fmt.Printf("  PoP(BLsig): %s\n", k.GetPop())
 					fmt.Printf("  last block:        level=%d round=%d\n", k.GetLastBlockLevel(), k.GetLastBlockRound())
@@ -399,7 +713,7 @@ func cmdStatus() *cli.Command {
 			}
 
 			// TTY: bordered table with fixed-width columns
-			fmt.Println(renderStatusTable(statusRows(st.GetKeys()), statusTableOpts{Selectable: false, Cursor: -1}))
+			fmt.Println(renderStatusTable(withCompanions(statusRows(st.GetKeys()), companions), statusTableOpts{Selectable: false, Cursor: -1}))
 			return nil
 		},
 	}
@@ -415,6 +729,20 @@ func cmdLogs() *cli.Command {
 				Aliases: []string{"n"},
 				Usage:   "Max number of lines (newest last, 0 = gadget default)",
 			},
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "Keep polling and print new lines as they appear",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Poll interval for --follow",
+				Value: time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  "memory",
+				Usage: "Read the lines the gadget keeps in memory instead of its log file",
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
@@ -425,24 +753,73 @@ func cmdLogs() *cli.Command {
 				return fmt.Errorf("limit must be >= 0")
 			}
 
-			lines, err := common.ReqLogs(b, limit)
+			memory := c.Bool("memory")
+			lines, err := common.ReqLogs(b, int(limit), memory)
 			if err != nil {
 				return err
 			}
 
-			if !isTTY(os.Stdout) {
+			tty := isTTY(os.Stdout)
+			if !tty && !c.Bool("follow") {
 				return json.NewEncoder(os.Stdout).Encode(lines)
 			}
 
-			for _, line := range lines {
-				fmt.Println(line)
+			enc := json.NewEncoder(os.Stdout)
+			emit := func(lines []string) {
+				for _, line := range lines {
+					if tty {
+						fmt.Println(line)
+					} else {
+						_ = enc.Encode(line) // one JSON string per line
+					}
+				}
 			}
+			emit(lines)
 
-			return nil
+			if !c.Bool("follow") {
+				return nil
+			}
+
+			ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			ticker := time.NewTicker(c.Duration("interval"))
+			defer ticker.Stop()
+			prev := lines
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+				cur, err := common.ReqLogs(b, logsFollowWindow, memory)
+				if err != nil {
+					return err
+				}
+				emit(newLogLines(prev, cur))
+				prev = cur
+			}
 		},
 	}
 }
 
+// newLogLines returns the lines of cur that come after the end of prev.
+// The gadget only hands out a tail, so we look for the last few lines of
+// prev inside cur; if they are gone (burst larger than the window, or the
+// log was truncated) everything in cur is new.
+func newLogLines(prev, cur []string) []string {
+	if len(prev) == 0 {
+		return cur
+	}
+	anchor := prev[max(0, len(prev)-logsFollowAnchor):]
+	for i := len(cur) - len(anchor); i >= 0; i-- {
+		if slices.Equal(cur[i:i+len(anchor)], anchor) {
+			return cur[i+len(anchor):]
+		}
+	}
+	return cur
+}
+
 func cmdUnlockKeys() *cli.Command {
 	return &cli.Command{
 		Name:      "unlock",
@@ -454,8 +831,18 @@ func cmdUnlockKeys() *cli.Command {
 
 			keys := resolveKeysFromEnvOrArgs(c.Args().Slice())
 
+			// A sealed vault lists no keys until it is opened; unlock them all
+			sealed := false
+			if len(keys) == 0 {
+				st, err := common.ReqStatus(b)
+				if err != nil {
+					return err
+				}
+				sealed = st.GetVaultSealed()
+			}
+
 			// If interactive TTY and no keys -> open picker to choose keys
-			if len(keys) == 0 && isTTY(os.Stdout) {
+			if len(keys) == 0 && !sealed && isTTY(os.Stdout) {
 				chosen, aborted, err := runKeyPicker(b)
 				if err != nil {
 					return err
@@ -479,7 +866,7 @@ func cmdUnlockKeys() *cli.Command {
 			}
 			defer keychain.MemoryWipe(pass)
 
-			if len(keys) == 0 {
+			if len(keys) == 0 && !sealed {
 				st, err := common.ReqStatus(b)
 				if err != nil {
 					return err
@@ -493,6 +880,12 @@ func cmdUnlockKeys() *cli.Command {
 			if err != nil {
 				return err
 			}
+			// the identity key opens with the first key
+			if slices.ContainsFunc(res, func(r *signer.PerKeyResult) bool { return r.GetOk() }) {
+				if err := verifyIdentity(h.Session, h.Log); err != nil {
+					return err
+				}
+			}
 
 			if !isTTY(os.Stdout) {
 				out := make([]keyStateJSON, 0, len(res))
@@ -693,12 +1086,40 @@ func cmdDeleteKeys() *cli.Command {
 	}
 }
 
+func cmdAckTamper() *cli.Command {
+	return &cli.Command{
+		Name:  "tamper-ack",
+		Usage: "Acknowledge a tamper event so keys can be unlocked again (requires master passphrase)",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("tamper-ack: %w", err)
+			}
+			defer keychain.MemoryWipe(pass)
+
+			if err := common.ReqAckTamper(b, pass); err != nil {
+				return err
+			}
+
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(map[string]bool{"ok": true})
+			}
+			fmt.Println("Tamper latch cleared; keys can be unlocked again.")
+			return nil
+		},
+	}
+}
+
 func cmdAdvanced() *cli.Command {
 	return &cli.Command{
 		Name:  "advanced",
 		Usage: "Advanced / low-level maintenance commands",
 		Commands: []*cli.Command{
 			withBefore(cmdUSBPortReset(), withLoggerOnly()),
+			withBefore(cmdFunctionReset(), withLoggerOnly()),
 			withBefore(cmdSetLevel(), withLoggerOnly()), // IMPORTANT: do NOT use withSession here
 
 		},
@@ -723,6 +1144,24 @@ func cmdUSBPortReset() *cli.Command {
 	}
 }
 
+func cmdFunctionReset() *cli.Command {
+	return &cli.Command{
+		Name:  "function-reset",
+		Usage: "Ask the gadget to reopen its USB endpoints without re-enumerating",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			serial := c.String("device") // global flag
+			if err := common.ResetFunction(serial, h.Log); err != nil {
+				return err
+			}
+
+			fmt.Println("OK: function reset sent. The gadget reopens its endpoints.")
+
+			return nil
+		},
+	}
+}
+
 func cmdSetLevel() *cli.Command {
 	return &cli.Command{
 		Name:      "set-level",
@@ -749,7 +1188,7 @@ func cmdSetLevel() *cli.Command {
 			l := h.Log
 
 			devSerial := c.String("device")
-			sess, err := common.Connect(common.ConnectParams{
+			sess, err := connectPaired(common.ConnectParams{
 				Serial:  devSerial,
 				Logger:  l,
 				Channel: common.ChanMgmt,
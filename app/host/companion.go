@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/urfave/cli/v3"
+)
+
+// companionMagic is what a DAL companion key signs: attestations carrying
+// DAL content (Tenderbake attestation watermark).
+var companionMagic = map[byte]struct{}{0x13: {}}
+
+// companionMap pairs consensus keys with their DAL companion keys, both by
+// alias. Octez asks for the companion signature under the companion's own
+// pkh, so the pair is only needed to serve and report them together.
+type companionMap map[string]string // consensus alias -> companion alias
+
+// companionKeys reads the global --companion-keys flag; nil when unset.
+func companionKeys(c *cli.Command) (companionMap, error) {
+	v := strings.TrimSpace(c.String("companion-keys"))
+	if v == "" {
+		return nil, nil
+	}
+	m, err := parseCompanions(v)
+	if err != nil {
+		return nil, fmt.Errorf("--companion-keys: %w", err)
+	}
+	return m, nil
+}
+
+// parseCompanions parses "consensus=companion;baker2=dal2".
+func parseCompanions(s string) (companionMap, error) {
+	out := companionMap{}
+	used := map[string]string{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		consensus, companion, ok := strings.Cut(entry, "=")
+		consensus, companion = strings.TrimSpace(consensus), strings.TrimSpace(companion)
+		if !ok || consensus == "" || companion == "" {
+			return nil, fmt.Errorf("invalid companion entry %q (want <consensus>=<companion>)", entry)
+		}
+		if consensus == companion {
+			return nil, fmt.Errorf("key %q cannot be its own companion", consensus)
+		}
+		if _, dup := out[consensus]; dup {
+			return nil, fmt.Errorf("key %q has more than one companion", consensus)
+		}
+		if prev, dup := used[companion]; dup {
+			return nil, fmt.Errorf("companion %q is already paired with %q", companion, prev)
+		}
+		out[consensus] = companion
+		used[companion] = consensus
+	}
+	for consensus := range out {
+		if _, ok := used[consensus]; ok {
+			return nil, fmt.Errorf("key %q is both a consensus and a companion key", consensus)
+		}
+	}
+	return out, nil
+}
+
+// validate checks that every paired alias exists on the device.
+func (m companionMap) validate(st *signer.StatusResponse) error {
+	known := make(map[string]struct{}, len(st.GetKeys()))
+	for _, k := range st.GetKeys() {
+		known[k.GetKeyId()] = struct{}{}
+	}
+	var missing []string
+	for consensus, companion := range m {
+		for _, a := range []string{consensus, companion} {
+			if _, ok := known[a]; !ok {
+				missing = append(missing, a)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("unknown key aliases in companion keys: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expand adds the companions of the listed aliases. An empty list means all
+// keys and is returned as is.
+func (m companionMap) expand(aliases []string) []string {
+	if len(aliases) == 0 || len(m) == 0 {
+		return aliases
+	}
+	seen := make(map[string]struct{}, len(aliases))
+	for _, a := range aliases {
+		seen[a] = struct{}{}
+	}
+	out := aliases
+	for _, a := range aliases {
+		c, ok := m[a]
+		if !ok {
+			continue
+		}
+		if _, dup := seen[c]; !dup {
+			seen[c] = struct{}{}
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// companionOf returns the consensus key a companion belongs to.
+func (m companionMap) companionOf(alias string) (string, bool) {
+	for consensus, companion := range m {
+		if companion == alias {
+			return consensus, true
+		}
+	}
+	return "", false
+}
+
+// label describes alias's pairing for status output: "→ companion" on a
+// consensus key, "← consensus" on its companion, empty when unpaired.
+func (m companionMap) label(alias string) string {
+	if c, ok := m[alias]; ok {
+		return "→ " + c
+	}
+	if c, ok := m.companionOf(alias); ok {
+		return "← " + c
+	}
+	return ""
+}
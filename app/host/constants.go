@@ -1,12 +1,36 @@
 package main
 
 const (
-	envBroker = "BROKER"
-	envDevice = "TEZSIGN_DEVICE"
-	envKeys   = "TEZSIGN_UNLOCK_KEYS"
-	envPass   = "TEZSIGN_UNLOCK_PASS"
+	envBroker   = "BROKER"
+	envDevice   = "TEZSIGN_DEVICE"
+	envBackup   = "TEZSIGN_BACKUP_DEVICE"
+	envKeys     = "TEZSIGN_UNLOCK_KEYS"
+	envKeysFile = "TEZSIGN_KEYS_FILE"
+	envPass     = "TEZSIGN_UNLOCK_PASS"
 
-	logFileName = "host.log"
+	envPassFile           = "TEZSIGN_PASSWORD_FILE"
+	defaultPassCredential = "tezsign-passphrase"
+
+	envMagicBytes    = "TEZSIGN_MAGIC_BYTES"
+	envKeyMagicBytes = "TEZSIGN_KEY_MAGIC_BYTES"
+
+	envCompanionKeys = "TEZSIGN_COMPANION_KEYS"
+
+	envUSBControlTimeout = "TEZSIGN_USB_CONTROL_TIMEOUT"
+	envUSBWriteTimeout   = "TEZSIGN_USB_WRITE_TIMEOUT"
+	envUSBRetries        = "TEZSIGN_USB_RETRIES"
+
+	envAllowIP    = "TEZSIGN_ALLOW_IP"
+	envRouteAllow = "TEZSIGN_ROUTE_ALLOW_IP"
+
+	logFileName  = "host.log"
+	pairFileName = "paired_devices.json"
+	envPairFile  = "TEZSIGN_PAIR_FILE"
 
 	defaultPort = "20090"
+
+	// logs --follow: lines fetched per poll, and how many trailing lines
+	// identify where the previous poll ended.
+	logsFollowWindow = 500
+	logsFollowAnchor = 3
 )
@@ -3,8 +3,11 @@ package main
 import "errors"
 
 var (
-	ErrAborted         = errors.New("aborted")
-	ErrDeviceHasNoKeys = errors.New("device has no keys. Run `tezsign-host init` then `tezsign-host new` first")
-	ErrEmptyPassphrase = errors.New("empty passphrase")
-	ErrNoKeysSelected  = errors.New("no keys selected")
+	ErrAborted          = errors.New("aborted")
+	ErrDeviceHasNoKeys  = errors.New("device has no keys. Run `tezsign-host init` then `tezsign-host new` first")
+	ErrEmptyPassphrase  = errors.New("empty passphrase")
+	ErrNoKeysSelected   = errors.New("no keys selected")
+	ErrDeviceNotPaired  = errors.New("device is not paired")
+	ErrIdentityMismatch = errors.New("device identity does not match the paired one")
+	ErrWatchNeedsTTY    = errors.New("watch needs an interactive terminal; use `status` for scripts")
 )
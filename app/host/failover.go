@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// failover switches the signing session from the primary device to a backup
+// holding the same keys. Switching is one-way; once on the backup, the usual
+// reconnect logic applies to the backup's serial.
+type failover struct {
+	log     *slog.Logger
+	current *atomic.Value // of type *cur
+	backup  string        // USB serial of the backup device
+	tz4s    []string      // keys the backup must hold
+
+	mu        sync.Mutex
+	switched  bool
+	switching bool                         // a trigger is connecting to the backup
+	seen      map[string]common.Watermarks // tz4 -> highest watermark possibly signed so far
+}
+
+func newFailover(l *slog.Logger, current *atomic.Value, backup string, st *signer.StatusResponse, allowSet map[string]struct{}) *failover {
+	f := &failover{
+		log:     l,
+		current: current,
+		backup:  backup,
+		seen:    make(map[string]common.Watermarks, len(allowSet)),
+	}
+	f.track(st, allowSet)
+	return f
+}
+
+// setKeys follows allow-list reloads so new keys are checked on the backup too.
+func (f *failover) setKeys(st *signer.StatusResponse, ak *allowedKeys) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.track(st, ak.set())
+}
+
+func (f *failover) track(st *signer.StatusResponse, allowSet map[string]struct{}) {
+	f.tz4s = f.tz4s[:0]
+	for _, ks := range st.GetKeys() {
+		tz4 := ks.GetTz4()
+		if _, ok := allowSet[tz4]; !ok {
+			continue
+		}
+		f.tz4s = append(f.tz4s, tz4)
+		if _, ok := f.seen[tz4]; !ok {
+			f.seen[tz4] = common.StatusWatermarks(ks)
+		}
+	}
+}
+
+// probe connects to the backup once to verify it holds every allowed key.
+// An unreachable backup is only reported; it may be plugged in later.
+func (f *failover) probe() error {
+	s, err := connectPaired(common.ConnectParams{Serial: f.backup, Logger: f.log, Channel: common.ChanSign})
+	if errors.Is(err, ErrDeviceNotPaired) {
+		return err
+	}
+	if err != nil {
+		f.log.Warn("backup device not reachable; failover will retry when needed", slog.String("backup", f.backup), slog.Any("err", err))
+		return nil
+	}
+	defer s.Close()
+
+	st, err := common.ReqStatus(s.Broker)
+	if err != nil {
+		return fmt.Errorf("backup status: %w", err)
+	}
+	have := make(map[string]struct{}, len(st.GetKeys()))
+	for _, ks := range st.GetKeys() {
+		have[ks.GetTz4()] = struct{}{}
+	}
+	for _, tz4 := range f.tz4s {
+		if _, ok := have[tz4]; !ok {
+			return fmt.Errorf("backup device %s does not hold key %s", f.backup, tz4)
+		}
+	}
+	return nil
+}
+
+// observe records a payload as possibly signed; call it before the payload
+// is sent, as a request that times out may still have been signed. The
+// backup is then compared against everything the primary may have signed,
+// not only against the startup status.
+func (f *failover) observe(tz4 string, raw []byte) {
+	if f == nil {
+		return
+	}
+	kind, level, round, _, err := keychain.DecodeAndValidateSignPayload(raw)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.seen[tz4]
+	if !ok {
+		m = make(common.Watermarks)
+		f.seen[tz4] = m
+	}
+	if w := (common.Watermark{Level: level, Round: round}); m[kind].Behind(w) {
+		m[kind] = w
+	}
+}
+
+// trigger switches signing to the backup. It returns false if failover is not
+// configured, already happened or is underway, or the backup is unusable; the
+// caller then falls back to its normal reconnect logic. The old session is
+// left for the watchdog to close.
+//
+// Connecting happens without f.mu, so sign requests keep being recorded;
+// the backup's watermarks are raised past them (see syncBackup) and checked
+// against them once it answers, under the lock that also switches the
+// session.
+func (f *failover) trigger(reason string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	if f.switched || f.switching {
+		f.mu.Unlock()
+		return false
+	}
+	f.switching = true
+	f.mu.Unlock()
+
+	s, err := connectPaired(common.ConnectParams{Serial: f.backup, Logger: f.log, Channel: common.ChanSign})
+	if err != nil {
+		f.log.Error("failover: backup device unavailable", slog.String("backup", f.backup), slog.Any("err", err))
+		f.mu.Lock()
+		f.switching = false
+		f.mu.Unlock()
+		return false
+	}
+	st, err := common.ReqStatus(s.Broker)
+	if err == nil {
+		st, err = f.syncBackup(s, st)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.switching = false
+	if err == nil {
+		err = f.checkBackupLocked(st)
+	}
+	if err != nil {
+		s.Close()
+		f.log.Error("failover refused", slog.String("backup", f.backup), slog.Any("err", err))
+		return false
+	}
+
+	primary := f.current.Load().(*cur).sess.Serial
+	f.current.Store(&cur{sess: s})
+	f.switched = true
+
+	f.log.Error("!!! FAILOVER: signing switched to backup device !!!",
+		slog.String("reason", reason),
+		slog.String("primary", primary),
+		slog.String("backup", s.Serial),
+	)
+	return true
+}
+
+// syncBackup raises the backup's watermarks past what the primary may
+// have signed. A backup on standby signs nothing, so it is nearly always
+// behind; without this, failover would be refused until set-level was run
+// by hand. Like set-level it moves every kind to one level with round 0:
+// the level after the highest one seen. It returns the backup's status
+// afterwards; requests observed meanwhile are still caught by
+// checkBackupLocked.
+func (f *failover) syncBackup(s *common.Session, st *signer.StatusResponse) (*signer.StatusResponse, error) {
+	type raise struct {
+		keyID string
+		level uint64
+	}
+	var raises []raise
+	f.mu.Lock()
+	byTz4 := make(map[string]*signer.KeyStatus, len(st.GetKeys()))
+	for _, ks := range st.GetKeys() {
+		byTz4[ks.GetTz4()] = ks
+	}
+	for _, tz4 := range f.tz4s {
+		ks, ok := byTz4[tz4]
+		if !ok {
+			continue // checkBackupLocked reports it
+		}
+		have, behind, top := common.StatusWatermarks(ks), false, uint64(0)
+		for kind, want := range f.seen[tz4] {
+			behind = behind || have[kind].Behind(want)
+			top = max(top, want.Level)
+		}
+		for _, w := range have {
+			top = max(top, w.Level)
+		}
+		if behind {
+			raises = append(raises, raise{ks.GetKeyId(), top + 1})
+		}
+	}
+	f.mu.Unlock()
+	if len(raises) == 0 {
+		return st, nil
+	}
+
+	mgmt, err := connectPaired(common.ConnectParams{Serial: f.backup, Logger: f.log, Channel: common.ChanMgmt})
+	if err != nil {
+		return nil, fmt.Errorf("raise backup watermarks: %w", err)
+	}
+	defer mgmt.Close()
+	for _, r := range raises {
+		ok, err := common.ReqSetLevel(mgmt.Broker, r.keyID, r.level)
+		if err == nil && !ok {
+			err = errors.New("refused")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("raise backup watermarks of %s to %d: %w", r.keyID, r.level, err)
+		}
+		f.log.Warn("failover: raised backup watermarks", slog.String("key", r.keyID), slog.Uint64("level", r.level))
+	}
+	return common.ReqStatus(s.Broker)
+}
+
+func (f *failover) checkBackupLocked(st *signer.StatusResponse) error {
+	byTz4 := make(map[string]*signer.KeyStatus, len(st.GetKeys()))
+	for _, ks := range st.GetKeys() {
+		byTz4[ks.GetTz4()] = ks
+	}
+	for _, tz4 := range f.tz4s {
+		ks, ok := byTz4[tz4]
+		if !ok {
+			return fmt.Errorf("key %s missing on backup", tz4)
+		}
+		if ks.GetLockState() != signer.LockState_UNLOCKED {
+			return fmt.Errorf("key %s locked on backup", tz4)
+		}
+		have := common.StatusWatermarks(ks)
+		for kind, want := range f.seen[tz4] {
+			if have[kind].Behind(want) {
+				return fmt.Errorf("key %s: backup watermark for 0x%02x is behind what the primary may have signed (%d/%d < %d/%d); raise it with set-level",
+					tz4, byte(kind), have[kind].Level, have[kind].Round, want.Level, want.Round)
+			}
+		}
+	}
+	return nil
+}
@@ -5,11 +5,16 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/logging"
 	"github.com/urfave/cli/v3"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 type hostCtxKey struct{}
 
 type HostContext struct {
@@ -19,34 +24,58 @@ type HostContext struct {
 
 func main() {
 	app := &cli.Command{
-		Name:  "tezsign-host",
-		Usage: "USB host CLI for TezSign gadget (signer)",
-		Flags: []cli.Flag{
+		Name:    "tezsign-host",
+		Usage:   "USB host CLI for TezSign gadget (signer)",
+		Version: version,
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "device",
 				Aliases: []string{"d"},
 				Usage:   "USB serial to select (if multiple gadgets present)",
 				Sources: cli.EnvVars(envDevice),
 			},
+			&cli.StringFlag{
+				Name:    "companion-keys",
+				Usage:   "Pair consensus keys with their DAL companion keys, e.g. \"consensus=companion\" (aliases, ';'-separated)",
+				Sources: cli.EnvVars(envCompanionKeys),
+			},
+		}, append(passwordFlags(), usbFlags()...)...),
+		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+			ctx, err := loadPasswordSource(ctx, cmd)
+			if err != nil {
+				return ctx, err
+			}
+			return loadUSBConfig(ctx, cmd)
 		},
 		After: closeSession,
 		Commands: []*cli.Command{
 			withBefore(cmdListDevices(), withLoggerOnly()),      // no session needed
+			withBefore(cmdPair(), withLoggerOnly()),             // no session needed
 			withBefore(cmdRun(), withSession(common.ChanSign)),  // signer interface
 			withBefore(cmdInit(), withSession(common.ChanMgmt)), // mgmt interface
 			withBefore(cmdList(), withSession(common.ChanMgmt)),
 			withBefore(cmdNewKeys(), withSession(common.ChanMgmt)),
+			withBefore(cmdKeygen(), withSession(common.ChanMgmt)),
+			withBefore(cmdPop(), withSession(common.ChanMgmt)),
 			withBefore(cmdStatus(), withSession(common.ChanMgmt)),
-			withBefore(cmdLogs(), withSession(common.ChanMgmt)),
+			withBefore(cmdLogs(), withSession(common.ChanAdmin)), // admin interface (IF1 on older gadgets)
+			withBefore(cmdAudit(), withSession(common.ChanAdmin)),
+			withBefore(cmdAttest(), withSession(common.ChanMgmt)),
+			withBefore(cmdClock(), withSession(common.ChanMgmt)),
+			withBefore(cmdWatch(), withSession(common.ChanMgmt)),
 			withBefore(cmdUnlockKeys(), withSession(common.ChanMgmt)),
 			withBefore(cmdLockKeys(), withSession(common.ChanMgmt)),
 			withBefore(cmdDeleteKeys(), withSession(common.ChanMgmt)),
+			withBefore(cmdAckTamper(), withSession(common.ChanMgmt)),
+			withBefore(cmdUpdate(), withSession(common.ChanAdmin)),
 
 			cmdAdvanced(),
 		},
 	}
 
-	if err := app.Run(context.Background(), os.Args); err != nil {
+	err := app.Run(context.Background(), os.Args)
+	logging.Flush(5 * time.Second)
+	if err != nil {
 		log.Fatal(err)
 	}
 }
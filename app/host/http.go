@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/netip"
 	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -24,31 +31,53 @@ type signResp struct {
 	Signature string `json:"signature"`
 }
 
+// requestIDLocal holds the per-request [16]byte correlation ID in fiber locals.
+const requestIDLocal = "request_id"
+
 type tz4CacheEntry struct {
 	publicKey string
 	pop       string
 }
 
-func buildFiberApp(getB func() *broker.Broker, l *slog.Logger, allowedTZ4 map[string]struct{}, cache map[string]tz4CacheEntry) *fiber.App {
+func buildFiberApp(getB func() *broker.Broker, tel *telemetryCache, l *slog.Logger, keys *atomic.Pointer[allowedKeys], magic *magicPolicy, ipf *ipFilter, fo *failover, inflight *sync.WaitGroup, sigs *sigCache, sp signPolicy) *fiber.App {
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		ReadTimeout:           10 * time.Second,
-		WriteTimeout:          10 * time.Second,
+		WriteTimeout:          sp.httpWriteTimeout(),
 		IdleTimeout:           60 * time.Second,
 
 		// BodyLimit: 1<<20, // 1MB; uncomment if you want a hard cap
 	})
 
-	// Middlewares: recover from panics + compact request log
+	perKey := newKeySerializer()
+
+	// Middlewares: recover from panics + request id + compact request log
 	app.Use(recover.New())
+	app.Use(func(c *fiber.Ctx) error {
+		// Always generated here (never taken from the client): it doubles as the
+		// broker frame ID, which must be unique.
+		id := broker.NewMessageID()
+		c.Locals(requestIDLocal, id)
+		c.Set(fiber.HeaderXRequestID, hex.EncodeToString(id[:]))
+		return c.Next()
+	})
 	app.Use(logger.New(logger.Config{
 		// Keep logs short; you already have slog for app logs.
-		Format: "${time} ${method} ${path} ${status} ${latency}\n",
+		Format: "${time} ${respHeader:X-Request-ID} ${method} ${path} ${status} ${latency}\n",
 	}))
 	app.Use(func(c *fiber.Ctx) error {
 		c.Path(path.Clean(c.Path()))
 		return c.Next()
 	})
+	app.Use(func(c *fiber.Ctx) error {
+		// Peer address only; forwarding headers are not trusted.
+		addr, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+		if !ipf.allows(c.Path(), addr) {
+			l.Warn("http request from disallowed address", slog.String("remote", addr.String()), slog.String("path", c.Path()))
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "source address not allowed"})
+		}
+		return c.Next()
+	})
 
 	// -------------------------------------------------------------------------
 	// GET /authorized_keys
@@ -58,6 +87,57 @@ func buildFiberApp(getB func() *broker.Broker, l *slog.Logger, allowedTZ4 map[st
 		return c.JSON(fiber.Map{})
 	})
 
+	// -------------------------------------------------------------------------
+	// GET /keys, GET /known_keys → return {"known_keys":["tz4...", ...]}
+	// -------------------------------------------------------------------------
+	knownKeys := func(c *fiber.Ctx) error {
+		known := slices.Sorted(maps.Keys(keys.Load().tz4))
+		return c.JSON(fiber.Map{"known_keys": known})
+	}
+	app.Get("/keys", knownKeys)
+	app.Get("/known_keys", knownKeys)
+
+	// -------------------------------------------------------------------------
+	// GET /version → return {"version":"..."}
+	// -------------------------------------------------------------------------
+	app.Get("/version", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"version": version})
+	})
+
+	// -------------------------------------------------------------------------
+	// GET /metrics → gadget health in Prometheus text format, from the
+	// latest pushed telemetry or, failing that, a health request
+	// -------------------------------------------------------------------------
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4")
+		h := tel.fresh(3 * telemetryInterval)
+		if h == nil {
+			var err error
+			if h, err = common.ReqHealth(getB()); err != nil {
+				l.Warn("metrics: health request failed", slog.Any("err", err))
+			}
+		}
+		writeHealthMetrics(c, h)
+		return nil
+	})
+
+	// -------------------------------------------------------------------------
+	// Deterministic nonces need an HMAC under the secret key, which never
+	// leaves the device and has no RPC for it; report them as unsupported
+	// so octez-client falls back to random nonces.
+	// -------------------------------------------------------------------------
+	app.Get("/supports_deterministic_nonces/:tz4", func(c *fiber.Ctx) error {
+		if _, ok := keys.Load().lookup(c.Params("tz4")); !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
+		}
+		return c.JSON(fiber.Map{"supports_deterministic_nonces": false})
+	})
+	noNonces := func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": "deterministic nonces are not supported"})
+	}
+	app.Post("/deterministic_nonce/:tz4", noNonces)
+	app.Post("/deterministic_nonce_hash/:tz4", noNonces)
+
 	// -------------------------------------------------------------------------
 	// GET /keys/:tz4 → return {"public_key":"BLpk..."}
 	// -------------------------------------------------------------------------
@@ -66,11 +146,7 @@ func buildFiberApp(getB func() *broker.Broker, l *slog.Logger, allowedTZ4 map[st
 		if tz4 == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing PKH"})
 		}
-		if _, ok := allowedTZ4[tz4]; !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
-		}
-
-		entry, ok := cache[tz4]
+		entry, ok := keys.Load().lookup(tz4)
 		if !ok {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
 		}
@@ -87,22 +163,31 @@ func buildFiberApp(getB func() *broker.Broker, l *slog.Logger, allowedTZ4 map[st
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing PKH"})
 		}
 
-		if _, ok := allowedTZ4[tz4]; !ok {
+		entry, ok := keys.Load().lookup(tz4)
+		if !ok {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
 		}
 
-		entry, ok := cache[tz4]
-		if !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
+		pop := entry.pop
+		if pop == "" {
+			// key stored without a PoP; the gadget computes and keeps one
+			var err error
+			if pop, err = common.ReqPop(getB(), tz4); err != nil {
+				l.Warn("pop failed", slog.String("tz4", tz4), slog.Any("err", err))
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+			}
 		}
 
-		return c.JSON(fiber.Map{"bls_prove_possession": entry.pop})
+		return c.JSON(fiber.Map{"bls_prove_possession": pop})
 	})
 
 	// -------------------------------------------------------------------------
 	// POST /keys/:tz4 → return {"signature":"BLsig..."}
 	// -------------------------------------------------------------------------
 	app.Post("/keys/:tz4", func(c *fiber.Ctx) error {
+		inflight.Add(1)
+		defer inflight.Done()
+
 		tz4 := c.Params("tz4")
 		if tz4 == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "missing PKH"})
@@ -117,33 +202,71 @@ func buildFiberApp(getB func() *broker.Broker, l *slog.Logger, allowedTZ4 map[st
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("bad payload_hex: %v", err)})
 		}
 
-		if _, ok := allowedTZ4[tz4]; !ok {
+		if _, ok := keys.Load().lookup(tz4); !ok {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
 		}
+		if len(raw) > 0 && !magic.allows(tz4, raw[0]) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "magic byte not allowed for this key"})
+		}
+
+		if sig, ok := sigs.get(tz4, raw); ok {
+			return c.JSON(&signResp{Signature: sig})
+		}
 
-		sig, err := common.ReqSign(getB(), tz4, raw)
+		rid := c.Locals(requestIDLocal).([16]byte)
+		rl := l.With(slog.String("req_id", hex.EncodeToString(rid[:])), slog.String("tz4", tz4))
+
+		// The deadline covers waiting for the key as well as signing.
+		ctx, cancel := context.WithTimeout(broker.WithRequestID(context.Background(), rid), sp.timeout)
+		defer cancel()
+
+		release, err := perKey.acquire(ctx, tz4)
+		if err != nil {
+			rl.Warn("sign timed out waiting for key", slog.Any("err", err))
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": fmt.Sprintf("sign timed out after %s", sp.timeout)})
+		}
+		defer release()
+
+		// an identical request may have been answered while we waited
+		if sig, ok := sigs.get(tz4, raw); ok {
+			return c.JSON(&signResp{Signature: sig})
+		}
+
+		// Recorded before sending: a request that times out may still
+		// have been signed, and the backup must not sign it again.
+		fo.observe(tz4, raw)
+		sig, err := sp.sign(ctx, getB, tz4, raw, rl)
+		if err != nil {
+			rl.Warn("sign failed", slog.Any("err", err))
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			go fo.trigger("sign request timed out")
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": fmt.Sprintf("sign timed out after %s", sp.timeout)})
+		}
 		if err != nil {
-			if re, ok := err.(*common.RemoteError); ok {
-				switch re.Code {
-				case common.RpcKeyNotFound:
-					return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": re.Msg})
-				case common.RpcKeyLocked:
-					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": re.Msg})
-				case common.RpcStaleWatermark:
-					return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": re.Msg})
-				case common.RpcBadPayload:
-					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": re.Msg})
-				default:
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": re.Msg})
-				}
+			status := fiber.StatusInternalServerError
+			switch {
+			case errors.Is(err, common.ErrKeyNotFound):
+				status = fiber.StatusNotFound
+			case errors.Is(err, common.ErrKeyLocked):
+				status = fiber.StatusForbidden
+			case errors.Is(err, common.ErrStaleWatermark):
+				status = fiber.StatusConflict
+			case errors.Is(err, common.ErrBadPayload):
+				status = fiber.StatusBadRequest
+			case errors.Is(err, common.ErrRateLimited):
+				status = fiber.StatusTooManyRequests
 			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
 		}
 
+		rl.Debug("signed")
+
 		blSig, err := signer.EncodeBLSignature(sig)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 		}
+		sigs.put(tz4, raw, blSig)
 
 		return c.JSON(&signResp{Signature: blSig})
 	})
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ipFilter restricts which source addresses may reach the HTTP API. Route
+// rules override the default list for paths under their prefix. An empty
+// filter allows everyone.
+type ipFilter struct {
+	def    []netip.Prefix // nil = any
+	routes []routeRule    // first matching prefix wins
+}
+
+type routeRule struct {
+	prefix string
+	nets   []netip.Prefix
+}
+
+// parseCIDRs parses "10.0.0.0/8,192.168.1.5"; bare addresses are single hosts.
+func parseCIDRs(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			a, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", p)
+			}
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		n, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", p)
+		}
+		out = append(out, n.Masked())
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty address list %q", s)
+	}
+	return out, nil
+}
+
+// parseRouteAllow parses "/keys=10.0.0.5;/bls_prove_possession=10.0.0.0/24".
+// Longer prefixes are checked first.
+func parseRouteAllow(s string) ([]routeRule, error) {
+	var out []routeRule
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, list, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid route allow entry %q (want /<path>=<cidrs>)", entry)
+		}
+		nets, err := parseCIDRs(list)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, routeRule{prefix: prefix, nets: nets})
+	}
+	// longest prefix first so "/keys/tz4..." beats "/keys"
+	slices.SortStableFunc(out, func(a, b routeRule) int { return len(b.prefix) - len(a.prefix) })
+	return out, nil
+}
+
+func (f *ipFilter) allows(path string, addr netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	nets := f.def
+	for _, r := range f.routes {
+		if path == r.prefix || strings.HasPrefix(path, strings.TrimSuffix(r.prefix, "/")+"/") {
+			nets = r.nets
+			break
+		}
+	}
+	if nets == nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// keySerializer lets sign requests for different keys run concurrently over
+// the broker while requests for the same tz4 go one at a time, in arrival
+// order as far as the Go scheduler allows. The device would reject a lower
+// level arriving after a higher one anyway; queueing keeps the baker's own
+// order.
+type keySerializer struct {
+	mu    sync.Mutex
+	slots map[string]*keySlot
+}
+
+type keySlot struct {
+	ch      chan struct{} // buffered(1): holding the token = holding the key
+	waiters int
+}
+
+func newKeySerializer() *keySerializer {
+	return &keySerializer{slots: make(map[string]*keySlot)}
+}
+
+// acquire waits for tz4 to be free or ctx to end. The returned release must
+// be called exactly once.
+func (k *keySerializer) acquire(ctx context.Context, tz4 string) (release func(), err error) {
+	k.mu.Lock()
+	s, ok := k.slots[tz4]
+	if !ok {
+		s = &keySlot{ch: make(chan struct{}, 1)}
+		k.slots[tz4] = s
+	}
+	s.waiters++
+	k.mu.Unlock()
+
+	done := func() {
+		k.mu.Lock()
+		if s.waiters--; s.waiters == 0 {
+			delete(k.slots, tz4)
+		}
+		k.mu.Unlock()
+	}
+
+	select {
+	case s.ch <- struct{}{}:
+		return func() { <-s.ch; done() }, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
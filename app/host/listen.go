@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/tez-capital/tezsign/watchdog"
+)
+
+// httpFDName is the listening socket in systemd's file descriptor store.
+const httpFDName = "http"
+
+// listenHTTP returns the listener for addr. Under systemd with
+// FileDescriptorStoreMax= the socket is kept in the fd store and taken
+// back on restart, so the baker never sees the port closed while the host
+// upgrades. USB handles belong to libusb and cannot be kept that way.
+func listenHTTP(addr string, l *slog.Logger) (net.Listener, error) {
+	n := watchdog.New()
+	for _, f := range watchdog.Files()[httpFDName] {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			l.Warn("stored HTTP socket unusable", "err", err)
+			continue
+		}
+		if sameTCPAddr(ln.Addr(), addr) {
+			l.Info("HTTP socket taken over from the previous run", slog.String("addr", ln.Addr().String()))
+			return ln, nil
+		}
+		ln.Close()
+		_ = n.RemoveFiles(httpFDName)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tl, ok := ln.(*net.TCPListener); ok && n != nil {
+		f, err := tl.File()
+		if err != nil {
+			return ln, nil
+		}
+		defer f.Close()
+		if err := n.StoreFiles(httpFDName, f); err != nil {
+			l.Debug("HTTP socket not stored with systemd", "err", err)
+		}
+	}
+	return ln, nil
+}
+
+// sameTCPAddr reports whether a listener on got serves addr.
+func sameTCPAddr(got net.Addr, addr string) bool {
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	tcp, ok := got.(*net.TCPAddr)
+	if err != nil || !ok || want.Port != tcp.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return tcp.IP.IsUnspecified()
+	}
+	return want.IP.Equal(tcp.IP)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/watchdog"
+)
+
+const (
+	// livenessProbeEvery is how often `run` asks the device for its status
+	// while the systemd watchdog is enabled.
+	livenessProbeEvery = 5 * time.Second
+	// livenessStaleAfter is how long without an answered status request
+	// the host counts as wedged; it spans a reconnect.
+	livenessStaleAfter = 30 * time.Second
+)
+
+// liveness is the probe behind the watchdog pings of `run`: the current
+// session's broker is running and the device answered a status request
+// recently. A process whose goroutines run but whose session is wedged
+// then stops pinging and is restarted by systemd.
+type liveness struct {
+	getB    func() *broker.Broker
+	log     *slog.Logger
+	lastOK  atomic.Int64 // unix nanos of the last answered status
+	failing atomic.Bool
+}
+
+// startLiveness sends READY to systemd and, while its watchdog is enabled,
+// probes the device and pings only while the probe passes.
+func startLiveness(ctx context.Context, getB func() *broker.Broker, l *slog.Logger) {
+	n := watchdog.New()
+	if err := n.Ready(); err != nil {
+		l.Warn("systemd notify", slog.Any("err", err))
+	}
+	if n == nil || watchdog.Interval() <= 0 {
+		return
+	}
+	lv := &liveness{getB: getB, log: l}
+	lv.lastOK.Store(time.Now().UnixNano()) // run starts with a status round trip
+	go lv.probe(ctx)
+	n.StartPinger(ctx, lv.healthy)
+	l.Info("watchdog enabled", slog.Duration("ping_every", watchdog.Interval()))
+}
+
+func (lv *liveness) probe(ctx context.Context) {
+	t := time.NewTicker(livenessProbeEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if _, err := common.NewClient(lv.getB()).Status(ctx); err != nil {
+			lv.log.Debug("liveness: status failed", slog.Any("err", err))
+			continue
+		}
+		lv.lastOK.Store(time.Now().UnixNano())
+	}
+}
+
+// healthy is the StartPinger probe; it logs when the answer changes.
+func (lv *liveness) healthy() bool {
+	reason := ""
+	select {
+	case <-lv.getB().Done():
+		reason = "broker stopped"
+	default:
+		if since := time.Since(time.Unix(0, lv.lastOK.Load())); since > livenessStaleAfter {
+			reason = "no status answer for " + since.Round(time.Second).String()
+		}
+	}
+	switch failing := reason != ""; {
+	case failing && !lv.failing.Swap(true):
+		lv.log.Error("host unhealthy; withholding watchdog ping", slog.String("reason", reason))
+	case !failing && lv.failing.Swap(false):
+		lv.log.Info("host healthy again; watchdog pings resumed")
+	}
+	return reason == ""
+}
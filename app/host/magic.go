@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// magicPolicy mirrors octez-signer's --magic-bytes: it restricts which
+// watermark (first payload byte) a key may sign through the HTTP API.
+// An empty policy allows everything and leaves validation to the device.
+type magicPolicy struct {
+	def    map[byte]struct{}            // applies to keys without an override; nil = any
+	perKey map[string]map[byte]struct{} // alias or tz4 -> allowed bytes
+	keys   *atomic.Pointer[allowedKeys] // resolves aliases on every lookup, so reloads apply
+}
+
+// parseMagicBytes parses an octez style list such as "0x11,0x12,0x13".
+func parseMagicBytes(s string) (map[byte]struct{}, error) {
+	out := make(map[byte]struct{})
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(p, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid magic byte %q", p)
+		}
+		out[byte(v)] = struct{}{}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty magic byte list %q", s)
+	}
+	return out, nil
+}
+
+// parseKeyMagicBytes parses "alias=0x11,0x12;other=0x13" into per-key lists.
+// Keys may be given as alias or tz4 and are kept as given; known reports
+// whether one names an allowed key.
+func parseKeyMagicBytes(s string, known func(string) bool) (map[string]map[byte]struct{}, error) {
+	out := make(map[string]map[byte]struct{})
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key magic bytes entry %q (want <key>=<bytes>)", entry)
+		}
+		key = strings.TrimSpace(key)
+		if !known(key) {
+			return nil, fmt.Errorf("unknown key %q in key magic bytes", key)
+		}
+		bytes, err := parseMagicBytes(list)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = bytes
+	}
+	return out, nil
+}
+
+func (p *magicPolicy) allows(tz4 string, magic byte) bool {
+	if p == nil {
+		return true
+	}
+	set, ok := p.forKey(tz4)
+	if !ok {
+		set = p.def
+	}
+	if set == nil {
+		return true
+	}
+	_, ok = set[magic]
+	return ok
+}
+
+// forKey returns the override for tz4, given by tz4 or by an alias that
+// currently maps to it.
+func (p *magicPolicy) forKey(tz4 string) (map[byte]struct{}, bool) {
+	if set, ok := p.perKey[tz4]; ok {
+		return set, true
+	}
+	if p.keys == nil || len(p.perKey) == 0 {
+		return nil, false
+	}
+	ak := p.keys.Load()
+	for key, set := range p.perKey {
+		if ak.byAlias[key] == tz4 {
+			return set, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// writeHealthMetrics renders a gadget health snapshot in the Prometheus text
+// exposition format. A nil snapshot reports the gadget as down.
+func writeHealthMetrics(w io.Writer, h *signer.HealthResponse) {
+	metric := func(name, typ, help string, v string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, v)
+	}
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	micros := func(v uint64) string { return strconv.FormatFloat(float64(v)/1e6, 'f', 6, 64) }
+
+	if h == nil {
+		metric("tezsign_gadget_up", "gauge", "Whether the gadget answered the health request.", "0")
+		return
+	}
+	metric("tezsign_gadget_up", "gauge", "Whether the gadget answered the health request.", "1")
+	fmt.Fprintf(w, "# HELP tezsign_gadget_info Gadget app version.\n# TYPE tezsign_gadget_info gauge\ntezsign_gadget_info{version=%q} 1\n", h.GetVersion())
+	metric("tezsign_gadget_uptime_seconds", "gauge", "Seconds since the gadget app started.", u(h.GetUptimeSeconds()))
+	if h.GetTemperatureAvailable() {
+		metric("tezsign_gadget_temperature_celsius", "gauge", "SoC temperature.",
+			strconv.FormatFloat(float64(h.GetTemperatureMillicelsius())/1000, 'f', 3, 64))
+	}
+	metric("tezsign_gadget_data_free_bytes", "gauge", "Free space on the data partition.", u(h.GetDataFreeBytes()))
+	metric("tezsign_gadget_data_size_bytes", "gauge", "Size of the data partition.", u(h.GetDataTotalBytes()))
+	metric("tezsign_gadget_goroutines", "gauge", "Goroutines in the gadget app.", u(uint64(h.GetGoroutines())))
+	metric("tezsign_gadget_queue_depth", "gauge", "Requests being handled plus frames waiting to be written.", u(uint64(h.GetQueueDepth())))
+	metric("tezsign_gadget_watermark_persist_failures_total", "counter", "Watermark writes that failed to reach disk.", u(h.GetWatermarkPersistFailures()))
+	metric("tezsign_gadget_watermark_persist_seconds", "gauge", "Duration of the latest watermark write including fsync.", micros(h.GetWatermarkPersistLastMicros()))
+	metric("tezsign_gadget_watermark_persist_max_seconds", "gauge", "Slowest watermark write since the gadget app started.", micros(h.GetWatermarkPersistMaxMicros()))
+	metric("tezsign_gadget_mem_available_bytes", "gauge", "Memory available for new allocations.", u(h.GetMemAvailableBytes()))
+	metric("tezsign_gadget_signs_total", "counter", "Signatures handed out since the gadget app started.", u(h.GetSigns()))
+	metric("tezsign_gadget_sign_rejects_total", "counter", "Sign requests the gadget refused since it started.", u(h.GetSignRejects()))
+}
+
+// printHealth is the plain-text health header of `status --full`.
+func printHealth(h *signer.HealthResponse) {
+	temp := "n/a"
+	if h.GetTemperatureAvailable() {
+		temp = fmt.Sprintf("%.1f°C", float64(h.GetTemperatureMillicelsius())/1000)
+	}
+	fmt.Printf("gadget %s  up %s\n", h.GetVersion(), time.Duration(h.GetUptimeSeconds())*time.Second)
+	fmt.Printf("  temperature:        %s\n", temp)
+	fmt.Printf("  data free:          %d / %d MiB\n", h.GetDataFreeBytes()>>20, h.GetDataTotalBytes()>>20)
+	fmt.Printf("  goroutines:         %d\n", h.GetGoroutines())
+	fmt.Printf("  queue depth:        %d\n", h.GetQueueDepth())
+	fmt.Printf("  memory available:   %d MiB\n", h.GetMemAvailableBytes()>>20)
+	fmt.Printf("  signs / rejected:   %d / %d\n", h.GetSigns(), h.GetSignRejects())
+	fmt.Printf("  persist latency:    %s (max %s)\n", time.Duration(h.GetWatermarkPersistLastMicros())*time.Microsecond, time.Duration(h.GetWatermarkPersistMaxMicros())*time.Microsecond)
+	fmt.Printf("  persist failures:   %d\n\n", h.GetWatermarkPersistFailures())
+}
+
+func printRelease(r *signer.ImageRelease) {
+	fmt.Printf("image %s (%s, %s, %s layout)\n", r.GetVersion(), r.GetFlavour(), r.GetBoard(), r.GetLayout())
+	fmt.Printf("  commit:             %s\n", r.GetCommit())
+	fmt.Printf("  built:              %s\n", r.GetBuildTime())
+	fmt.Printf("  partition layout:   %s\n\n", r.GetPartitionLayoutSha256())
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/urfave/cli/v3"
+)
+
+// pairedDevice is one gadget the host trusts.
+type pairedDevice struct {
+	Serial    string    `json:"serial"`
+	PublicKey string    `json:"public_key,omitempty"` // device identity key (hex), pinned at the first attestation
+	PairedAt  time.Time `json:"paired_at"`
+}
+
+type pairStore struct {
+	path    string
+	Devices []pairedDevice `json:"devices"`
+}
+
+func pairFilePath() string {
+	if p := strings.TrimSpace(os.Getenv(envPairFile)); p != "" {
+		return p
+	}
+	return defaultHostFile(pairFileName)
+}
+
+// loadPairs reads the pairing file. exists is false when no device was ever
+// paired, which is what enables trust on first use.
+func loadPairs() (ps *pairStore, exists bool, err error) {
+	ps = &pairStore{path: pairFilePath()}
+	b, err := os.ReadFile(ps.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ps, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("pairing file: %w", err)
+	}
+	if err := json.Unmarshal(b, ps); err != nil {
+		return nil, false, fmt.Errorf("pairing file %s: %w", ps.path, err)
+	}
+	return ps, true, nil
+}
+
+func (ps *pairStore) save() error {
+	if err := logging.EnsureDir(ps.path); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := ps.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path)
+}
+
+func (ps *pairStore) find(serial string) *pairedDevice {
+	for i := range ps.Devices {
+		if ps.Devices[i].Serial == serial {
+			return &ps.Devices[i]
+		}
+	}
+	return nil
+}
+
+func (ps *pairStore) add(serial string) *pairedDevice {
+	if d := ps.find(serial); d != nil {
+		return d
+	}
+	ps.Devices = append(ps.Devices, pairedDevice{Serial: serial, PairedAt: time.Now().UTC()})
+	return &ps.Devices[len(ps.Devices)-1]
+}
+
+// verifyPaired refuses devices that were not paired. The very first device
+// ever seen is paired automatically (trust on first use).
+func verifyPaired(serial string, l *slog.Logger) error {
+	ps, exists, err := loadPairs()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		ps.add(serial)
+		if err := ps.save(); err != nil {
+			return fmt.Errorf("pairing file: %w", err)
+		}
+		l.Info("paired device on first use", slog.String("serial", serial), slog.String("file", ps.path))
+		return nil
+	}
+	if ps.find(serial) == nil {
+		return fmt.Errorf("%w: serial %q (run `tezsign --device %s pair` if you replaced it on purpose)", ErrDeviceNotPaired, serial, serial)
+	}
+	return nil
+}
+
+// attestIdentity challenges s and returns its verified identity key (hex).
+func attestIdentity(s *common.Session) (string, error) {
+	nonce := make([]byte, attestNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	res, err := common.ReqAttest(s.Broker, nonce)
+	if err != nil {
+		return "", err
+	}
+	if _, err := signer.VerifyAttestation(res, nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(res.GetIdentityPublicKey()), nil
+}
+
+// verifyIdentity checks the identity key of s against the one pinned for
+// its serial, and pins it when there is none yet. A device with a pinned
+// key that does not attest is refused, with one exception: the key is
+// sealed under the master passphrase, so a gadget that was not unlocked
+// since it booted cannot attest yet. Such a device cannot sign either, so
+// it passes only while it reports no unlocked key; unlock and init check
+// it right after, and run again before it serves.
+func verifyIdentity(s *common.Session, l *slog.Logger) error {
+	ps, _, err := loadPairs()
+	if err != nil {
+		return err
+	}
+	d := ps.find(s.Serial)
+	if d == nil {
+		return fmt.Errorf("%w: serial %q", ErrDeviceNotPaired, s.Serial)
+	}
+	key, err := attestIdentity(s)
+	switch {
+	case errors.Is(err, common.ErrIdentityLocked) && d.PublicKey != "":
+		st, serr := common.ReqStatus(s.Broker)
+		if serr != nil {
+			return fmt.Errorf("%w: serial %q does not attest and its status is unavailable: %w", ErrIdentityMismatch, s.Serial, serr)
+		}
+		for _, k := range st.GetKeys() {
+			if k.GetLockState() == signer.LockState_UNLOCKED {
+				return fmt.Errorf("%w: serial %q has unlocked keys but does not attest", ErrIdentityMismatch, s.Serial)
+			}
+		}
+		l.Warn("device identity not checked: the gadget is locked", slog.String("serial", s.Serial))
+		return nil
+	case errors.Is(err, common.ErrIdentityLocked):
+		l.Warn("device identity not pinned: the gadget is locked", slog.String("serial", s.Serial))
+		return nil
+	case err != nil && d.PublicKey == "":
+		l.Warn("device identity not pinned: the gadget does not attest", slog.String("serial", s.Serial), slog.Any("err", err))
+		return nil
+	case err != nil:
+		return fmt.Errorf("%w: serial %q: %w", ErrIdentityMismatch, s.Serial, err)
+	case d.PublicKey == "":
+		d.PublicKey = key
+		if err := ps.save(); err != nil {
+			return fmt.Errorf("pairing file: %w", err)
+		}
+		l.Info("pinned device identity", slog.String("serial", s.Serial), slog.String("public_key", key))
+		return nil
+	case d.PublicKey != key:
+		return fmt.Errorf("%w: serial %q has key %s, paired with %s (run `tezsign --device %s pair` if you replaced it on purpose)", ErrIdentityMismatch, s.Serial, key, d.PublicKey, s.Serial)
+	}
+	return nil
+}
+
+// connectPaired is common.Connect restricted to paired devices whose
+// identity checks out. It also sets the gadget clock, which has no other
+// time source.
+func connectPaired(p common.ConnectParams) (*common.Session, error) {
+	s, err := common.Connect(p)
+	if err != nil {
+		return nil, err
+	}
+	l := p.Logger
+	if l == nil {
+		l = s.Log
+	}
+	if err := verifyPaired(s.Serial, l); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := verifyIdentity(s, l); err != nil {
+		s.Close()
+		return nil, err
+	}
+	syncDeviceClock(s, p.Channel, l)
+	return s, nil
+}
+
+func cmdPair() *cli.Command {
+	return &cli.Command{
+		Name:  "pair",
+		Usage: "Trust a gadget (selected with --device, or the only one connected); list or forget paired gadgets",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "list",
+				Usage: "List paired gadgets",
+			},
+			&cli.StringFlag{
+				Name:  "forget",
+				Usage: "Remove the gadget with this serial from the paired list",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			ps, _, err := loadPairs()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case c.Bool("list"):
+				if !isTTY(os.Stdout) {
+					return json.NewEncoder(os.Stdout).Encode(ps.Devices)
+				}
+				if len(ps.Devices) == 0 {
+					fmt.Println("No paired devices.")
+				}
+				for _, d := range ps.Devices {
+					key := d.PublicKey
+					if key == "" {
+						key = "-"
+					}
+					fmt.Printf("serial=%s paired_at=%s identity=%s\n", d.Serial, d.PairedAt.Format(time.RFC3339), key)
+				}
+				return nil
+
+			case c.IsSet("forget"):
+				serial := strings.TrimSpace(c.String("forget"))
+				n := len(ps.Devices)
+				ps.Devices = slices.DeleteFunc(ps.Devices, func(d pairedDevice) bool { return d.Serial == serial })
+				if len(ps.Devices) == n {
+					return fmt.Errorf("%w: serial %q", ErrDeviceNotPaired, serial)
+				}
+				if err := ps.save(); err != nil {
+					return err
+				}
+				fmt.Printf("OK: forgot %s\n", serial)
+				return nil
+			}
+
+			h := mustHost(ctx)
+			infos, err := common.ListFFSDevices(h.Log)
+			if err != nil {
+				return err
+			}
+			serial := strings.TrimSpace(c.String("device")) // global flag
+			if serial == "" {
+				if len(infos) != 1 {
+					return fmt.Errorf("found %d devices; select the one to pair with --device", len(infos))
+				}
+				serial = infos[0].Serial
+			} else if !slices.ContainsFunc(infos, func(d common.DeviceInfo) bool { return d.Serial == serial }) {
+				return fmt.Errorf("%w: serial=%q", common.ErrDeviceNotFound, serial)
+			}
+
+			// Pairing replaces whatever was pinned for the serial.
+			d := ps.add(serial)
+			d.PublicKey = ""
+			s, err := common.Connect(common.ConnectParams{Serial: serial, Logger: h.Log, Channel: common.ChanMgmt})
+			if err != nil {
+				return err
+			}
+			key, err := attestIdentity(s)
+			s.Close()
+			switch {
+			case err == nil:
+				d.PublicKey = key
+			case errors.Is(err, common.ErrIdentityLocked):
+				fmt.Println("The gadget is locked; its identity key is pinned at the next unlock.")
+			default:
+				h.Log.Warn("gadget does not attest; pairing by serial only", slog.Any("err", err))
+			}
+			if err := ps.save(); err != nil {
+				return err
+			}
+			if d.PublicKey != "" {
+				fmt.Printf("OK: paired %s (identity %s)\n", serial, d.PublicKey)
+			} else {
+				fmt.Printf("OK: paired %s\n", serial)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// passSource holds the non-interactive passphrase sources picked on the
+// command line; filled by loadPasswordSource before any command runs.
+var passSource = struct {
+	file       string // path, or "-" for stdin
+	fd         int    // -1 when unset
+	credential string // systemd credential name
+	consumed   bool   // stdin / fd already read
+}{fd: -1}
+
+func passwordFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "password-file",
+			Usage:   "Read the passphrase from this file (\"-\" for stdin) instead of prompting",
+			Sources: cli.EnvVars(envPassFile),
+		},
+		&cli.IntFlag{
+			Name:  "password-fd",
+			Usage: "Read the passphrase from this already-open file descriptor",
+			Value: -1,
+		},
+		&cli.StringFlag{
+			Name:  "password-credential",
+			Usage: "systemd credential name (LoadCredential=/SetCredentialEncrypted=) holding the unlock passphrase",
+			Value: defaultPassCredential,
+		},
+	}
+}
+
+func loadPasswordSource(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	passSource.file = strings.TrimSpace(cmd.String("password-file"))
+	passSource.fd = int(cmd.Int("password-fd"))
+	passSource.credential = strings.TrimSpace(cmd.String("password-credential"))
+	if passSource.file != "" && passSource.fd >= 0 {
+		return ctx, errors.New("use only one of --password-file and --password-fd")
+	}
+	return ctx, nil
+}
+
+// hasPasswordSource reports whether a non-interactive source was given explicitly.
+func hasPasswordSource() bool {
+	return passSource.file != "" || passSource.fd >= 0
+}
+
+// readPasswordSource reads --password-file / --password-fd, if given.
+// Stdin and fds can only be read once per process.
+func readPasswordSource() ([]byte, bool, error) {
+	if (passSource.file == "-" || passSource.fd >= 0) && passSource.consumed {
+		return nil, true, errors.New("passphrase source already consumed")
+	}
+	switch {
+	case passSource.file == "-":
+		passSource.consumed = true
+		return readPassword(os.Stdin, "stdin")
+	case passSource.file != "":
+		f, err := os.Open(passSource.file)
+		if err != nil {
+			return nil, true, fmt.Errorf("password file: %w", err)
+		}
+		defer f.Close()
+		return readPassword(f, passSource.file)
+	case passSource.fd >= 0:
+		f := os.NewFile(uintptr(passSource.fd), "password-fd")
+		passSource.consumed = true
+		if f == nil {
+			return nil, true, errors.New("password fd: invalid descriptor")
+		}
+		defer f.Close()
+		return readPassword(f, "password fd")
+	}
+	return nil, false, nil
+}
+
+// readPasswordCredential reads $CREDENTIALS_DIRECTORY/<name> when systemd
+// passed credentials to the service.
+func readPasswordCredential() ([]byte, bool, error) {
+	dir := strings.TrimSpace(os.Getenv("CREDENTIALS_DIRECTORY"))
+	if dir == "" || passSource.credential == "" {
+		return nil, false, nil
+	}
+	f, err := os.Open(filepath.Join(dir, passSource.credential))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("credential %s: %w", passSource.credential, err)
+	}
+	defer f.Close()
+	return readPassword(f, "credential "+passSource.credential)
+}
+
+// readPassword reads a passphrase and strips one trailing line terminator
+// (\n or \r\n); any other whitespace is part of the passphrase.
+func readPassword(r io.Reader, what string) ([]byte, bool, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", what, err)
+	}
+	line, ok := bytes.CutSuffix(raw, []byte("\n"))
+	if ok {
+		line, _ = bytes.CutSuffix(line, []byte("\r"))
+	}
+	pass := append([]byte(nil), line...)
+	clear(raw)
+	if len(pass) == 0 {
+		return nil, true, ErrEmptyPassphrase
+	}
+	return pass, true, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// sigCache remembers recently produced signatures so an identical retry from
+// octez (same key, same payload) is answered without touching the device —
+// which would otherwise reject it as a stale watermark.
+type sigCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[[32]byte]sigCacheEntry
+}
+
+type sigCacheEntry struct {
+	sig     string
+	expires time.Time
+}
+
+// newSigCache returns nil (caching disabled) for a non-positive ttl.
+func newSigCache(ttl time.Duration) *sigCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &sigCache{ttl: ttl, entries: make(map[[32]byte]sigCacheEntry)}
+}
+
+func sigCacheKey(tz4 string, payload []byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte(tz4))
+	h.Write([]byte{0})
+	h.Write(payload)
+	var k [32]byte
+	h.Sum(k[:0])
+	return k
+}
+
+func (c *sigCache) get(tz4 string, payload []byte) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	k := sigCacheKey(tz4, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.sig, true
+}
+
+func (c *sigCache) put(tz4 string, payload []byte, sig string) {
+	if c == nil {
+		return
+	}
+	k := sigCacheKey(tz4, payload)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[k] = sigCacheEntry{sig: sig, expires: now.Add(c.ttl)}
+}
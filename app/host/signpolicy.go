@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/common"
+)
+
+// signPolicy bounds a sign request end to end. Tenderbake rounds are a few
+// seconds long, so a signature that arrives after the deadline is useless and
+// the baker is better off with a quick 504.
+type signPolicy struct {
+	timeout time.Duration // whole request, retries included
+	retries int           // extra attempts on transport errors
+	backoff time.Duration // pause before each retry
+}
+
+func (p signPolicy) httpWriteTimeout() time.Duration {
+	return max(10*time.Second, p.timeout+2*time.Second)
+}
+
+// sign calls Client.Sign, retrying transport failures until the deadline.
+// A transport failure leaves it unknown whether the device signed, so each
+// retry is a new request with its own frame ID. A retry may go to another
+// session: after a reconnect the same device's watermarks refuse a second
+// signature, and a backup only takes over once its watermarks are at or
+// above every payload failover observed, this one included, so it refuses
+// the payload as well.
+func (p signPolicy) sign(ctx context.Context, getB func() *broker.Broker, tz4 string, raw []byte, l *slog.Logger) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	b, attemptCtx := getB(), ctx
+	for attempt := 0; ; attempt++ {
+		sig, err := common.NewClient(b).Sign(attemptCtx, tz4, raw)
+		if err == nil || attempt >= p.retries || !common.IsTransient(err) {
+			return sig, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(p.backoff):
+		}
+		b = getB()
+		id := broker.NewMessageID()
+		attemptCtx = broker.WithRequestID(ctx, id)
+		l.Debug("sign transport error; retrying", slog.Int("attempt", attempt+1), slog.String("frame_id", hex.EncodeToString(id[:])), slog.Any("err", err))
+	}
+}
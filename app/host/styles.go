@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 
@@ -35,6 +36,8 @@ type keyStatusJSON struct {
 	LastAttestationLevel uint64 `json:"last_attestation_level"`
 	LastAttestationRound uint32 `json:"last_attestation_round"`
 	StateCorrupted       bool   `json:"state_corrupted"`
+	Companion            string `json:"companion,omitempty"`    // DAL companion of this consensus key
+	CompanionOf          string `json:"companion_of,omitempty"` // consensus key this companion serves
 }
 
 func getKeysStatusJSON(ks *signer.KeyStatus) keyStatusJSON {
@@ -70,6 +73,7 @@ var (
 	stateUnlocked  = lipgloss.NewStyle().Foreground(okColor).Bold(true)
 	stateLocked    = lipgloss.NewStyle().Foreground(errColor).Bold(true)
 	stateCorrupted = lipgloss.NewStyle().Foreground(errColor).Bold(true)
+	stateTampered  = lipgloss.NewStyle().Foreground(errColor).Bold(true)
 )
 
 // --- Bubble Tea password prompt ---
@@ -122,18 +126,31 @@ func isTTY(f *os.File) bool {
 
 // obtainPassword prompts for a password using Bubble Tea when interactive.
 // Order of precedence:
-//  1. TEZSIGN_UNLOCK_PASS env
-//  2. Bubble Tea masked prompt if stdout is a TTY
+//  1. --password-file (path or "-" for stdin) / --password-fd
+//  2. systemd credential (LoadCredential=), only when withEnv
+//  3. TEZSIGN_UNLOCK_PASS env, only when withEnv
+//  4. Bubble Tea masked prompt if stdout is a TTY
 //
 // Returns a zero-copy []byte the caller must wipe via keychain.MemoryWipe.
 func obtainPassword(prompt string, withEnv bool) ([]byte, error) {
-	// 1) env
+	// 1) explicit file / fd
+	if pass, ok, err := readPasswordSource(); ok || err != nil {
+		return pass, err
+	}
+
+	// 2) systemd credential
+	if withEnv {
+		if pass, ok, err := readPasswordCredential(); ok || err != nil {
+			return pass, err
+		}
+	}
 
+	// 3) env
 	if v := strings.TrimSpace(os.Getenv(envPass)); withEnv && v != "" {
 		return []byte(v), nil
 	}
 
-	// 2) interactive? (stdout TTY)
+	// 4) interactive? (stdout TTY)
 	interactive := isTTY(os.Stdout) && isTTY(os.Stdin)
 
 	if interactive {
@@ -204,6 +221,7 @@ func renderAliasChips(ids []string, maxWidth int) string {
 
 type statusRow struct {
 	ID, State, TZ4 string
+	Companion      string // paired key, see companionLabel
 	BLevel         uint64
 	BRound         uint32
 	PLevel         uint64
@@ -220,6 +238,8 @@ func chipState(s string) string {
 		return stateLocked.Render("LOCKED")
 	case "CORRUPTED":
 		return stateCorrupted.Render("CORRUPTED")
+	case "TAMPERED":
+		return stateTampered.Render("TAMPERED")
 	default:
 		return s
 	}
@@ -232,6 +252,8 @@ type statusTableOpts struct {
 }
 
 func renderStatusTable(rows []statusRow, opts statusTableOpts) string {
+	showCompanion := slices.ContainsFunc(rows, func(r statusRow) bool { return r.Companion != "" })
+
 	// Build data rows
 	data := make([][]string, 0, len(rows))
 	for i, r := range rows {
@@ -256,8 +278,11 @@ func renderStatusTable(rows []statusRow, opts statusTableOpts) string {
 			r.ID,
 			chipState(r.State),
 			r.TZ4,
-			b, p, a,
 		)
+		if showCompanion {
+			row = append(row, r.Companion)
+		}
+		row = append(row, b, p, a)
 		data = append(data, row)
 	}
 
@@ -270,6 +295,11 @@ func renderStatusTable(rows []statusRow, opts statusTableOpts) string {
 		headerStyle.Render("id"),
 		headerStyle.Render("state"),
 		headerStyle.Render("tz4"),
+	)
+	if showCompanion {
+		headers = append(headers, headerStyle.Render("companion"))
+	}
+	headers = append(headers,
 		headerStyle.Render("Block"),
 		headerStyle.Render("PreAtt"),
 		headerStyle.Render("Att"),
@@ -287,10 +317,14 @@ func renderStatusTable(rows []statusRow, opts statusTableOpts) string {
 			// Right align numeric columns: at the end, always last 3 columns
 			// When Selectable: columns are [0:select, 1:id, 2:state, 3:tz4, 4:Block, 5:PreAtt, 6:Att]
 			// When not:        columns are [0:id,     1:state, 2:tz4, 3:Block, 4:PreAtt, 5:Att]
+			// A companion column, when shown, sits right after tz4.
 			last3Start := 4
 			if !opts.Selectable {
 				last3Start = 3
 			}
+			if showCompanion {
+				last3Start++
+			}
 			if col >= last3Start {
 				s = s.Align(lipgloss.Right)
 			} else {
@@ -430,3 +464,11 @@ func statusRows(statuses []*signer.KeyStatus) []statusRow {
 	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
 	return rows
 }
+
+// withCompanions fills the companion column from the configured pairs.
+func withCompanions(rows []statusRow, m companionMap) []statusRow {
+	for i := range rows {
+		rows[i].Companion = m.label(rows[i].ID)
+	}
+	return rows
+}
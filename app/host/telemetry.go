@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/signer"
+	"google.golang.org/protobuf/proto"
+)
+
+const telemetryInterval = 10 * time.Second
+
+// telemetryCache keeps the newest Telemetry event pushed by the gadget, so
+// /metrics can answer without a round trip.
+type telemetryCache struct {
+	latest atomic.Pointer[signer.Telemetry]
+	at     atomic.Int64 // unix nanos of latest
+}
+
+func (t *telemetryCache) update(payload []byte) {
+	var tel signer.Telemetry
+	if err := proto.Unmarshal(payload, &tel); err != nil {
+		return
+	}
+	for {
+		cur := t.latest.Load()
+		if cur != nil && cur.GetSeq() >= tel.GetSeq() {
+			return // out of order
+		}
+		if t.latest.CompareAndSwap(cur, &tel) {
+			t.at.Store(time.Now().UnixNano())
+			return
+		}
+	}
+}
+
+// fresh returns the latest health snapshot unless it is older than maxAge.
+func (t *telemetryCache) fresh(maxAge time.Duration) *signer.HealthResponse {
+	tel := t.latest.Load()
+	if tel == nil || time.Since(time.Unix(0, t.at.Load())) > maxAge {
+		return nil
+	}
+	return tel.GetHealth()
+}
+
+// followTelemetry subscribes each session run switches to. Gadgets that do
+// not know the request are left alone and /metrics keeps polling them.
+func followTelemetry(ctx context.Context, getB func() *broker.Broker, t *telemetryCache, l *slog.Logger) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	var last *broker.Broker
+	for {
+		if b := getB(); b != last {
+			// A new session restarts the gadget's sequence numbers.
+			t.latest.Store(nil)
+			b.OnEvent(t.update)
+			if err := common.ReqTelemetry(b, telemetryInterval); err != nil {
+				l.Debug("gadget does not push telemetry; /metrics polls", slog.Any("err", err))
+			}
+			last = b
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
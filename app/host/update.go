@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/urfave/cli/v3"
+)
+
+// updateChunkSize stays within the broker's pooled payload size.
+const updateChunkSize = 256 << 10
+
+func cmdUpdate() *cli.Command {
+	return &cli.Command{
+		Name:  "update",
+		Usage: "Push a new gadget app binary over USB; the gadget verifies its signature and swaps it in",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "app",
+				Usage:    "Path to the new gadget binary",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "signature",
+				Usage: "Ed25519 signature of the binary, raw or hex (default: <app>.sig)",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			appPath := c.String("app")
+			sigPath := c.String("signature")
+			if sigPath == "" {
+				sigPath = appPath + ".sig"
+			}
+
+			bin, err := os.ReadFile(appPath)
+			if err != nil {
+				return err
+			}
+			sig, err := readUpdateSignature(sigPath)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(bin)
+
+			if err := common.ReqUpdateBegin(b, uint64(len(bin)), sum[:], sig); err != nil {
+				return fmt.Errorf("update begin: %w", err)
+			}
+
+			tty := isTTY(os.Stdout)
+			for off := 0; off < len(bin); off += updateChunkSize {
+				end := min(off+updateChunkSize, len(bin))
+				if err := common.ReqUpdateChunk(b, uint64(off), bin[off:end]); err != nil {
+					return fmt.Errorf("update upload at %d: %w", off, err)
+				}
+				if tty {
+					fmt.Printf("\rUploading… %3d%%", end*100/len(bin))
+				}
+			}
+			if tty {
+				fmt.Println()
+			}
+
+			res, err := common.ReqUpdateCommit(b)
+			if err != nil {
+				return fmt.Errorf("update commit: %w", err)
+			}
+
+			if !tty {
+				return json.NewEncoder(os.Stdout).Encode(map[string]string{
+					"version":          res.GetVersion(),
+					"previous_version": res.GetPreviousVersion(),
+				})
+			}
+			fmt.Printf("OK: gadget app %s staged (was %s). The gadget restarts to apply it.\n",
+				res.GetVersion(), res.GetPreviousVersion())
+			return nil
+		},
+	}
+}
+
+// readUpdateSignature accepts a raw 64-byte signature or its hex encoding.
+func readUpdateSignature(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if len(raw) == ed25519.SignatureSize {
+		return raw, nil
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature %s: expected %d raw bytes or hex", path, ed25519.SignatureSize)
+	}
+	return sig, nil
+}
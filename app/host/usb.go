@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tez-capital/tezsign/common"
+	"github.com/urfave/cli/v3"
+)
+
+func usbFlags() []cli.Flag {
+	def := common.DefaultUSBConfig()
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:    "usb-control-timeout",
+			Usage:   "Timeout of a USB control request (0 = none)",
+			Value:   def.ControlTimeout,
+			Sources: cli.EnvVars(envUSBControlTimeout),
+		},
+		&cli.DurationFlag{
+			Name:    "usb-write-timeout",
+			Usage:   "Timeout of a USB bulk write (0 = none)",
+			Value:   def.WriteTimeout,
+			Sources: cli.EnvVars(envUSBWriteTimeout),
+		},
+		&cli.IntFlag{
+			Name:    "usb-retries",
+			Usage:   "Retries of a USB transfer that timed out, e.g. behind a slow hub",
+			Value:   def.Retries,
+			Sources: cli.EnvVars(envUSBRetries),
+		},
+	}
+}
+
+// loadUSBConfig applies the --usb-* flags before any command runs.
+func loadUSBConfig(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	cfg := common.DefaultUSBConfig()
+	cfg.ControlTimeout = cmd.Duration("usb-control-timeout")
+	cfg.WriteTimeout = cmd.Duration("usb-write-timeout")
+	cfg.Retries = int(cmd.Int("usb-retries"))
+	if cfg.ControlTimeout < 0 || cfg.WriteTimeout < 0 || cfg.Retries < 0 {
+		return ctx, errors.New("--usb-* values must not be negative")
+	}
+	common.SetUSBConfig(cfg)
+	return ctx, nil
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -38,6 +41,24 @@ func resolveKeysFromEnvOrArgs(args []string) []string {
 	return nil
 }
 
+// defaultHostFile locates host state files. On Linux they live next to the
+// executable. macOS and Windows installs are usually read-only, so there they
+// go to the per-user config dir unless a file already sits next to the binary.
+func defaultHostFile(name string) string {
+	local := logging.DefaultFileInExecDir(name)
+	if runtime.GOOS == "linux" {
+		return local
+	}
+	if _, err := os.Stat(local); err == nil {
+		return local
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return local
+	}
+	return filepath.Join(dir, "tezsign", name)
+}
+
 func mustHost(ctx context.Context) *HostContext {
 	v := ctx.Value(hostCtxKey{})
 	if v == nil {
@@ -46,62 +67,98 @@ func mustHost(ctx context.Context) *HostContext {
 	return v.(*HostContext)
 }
 
-func runWatchdog(ctx context.Context, curRef *atomic.Value, initial *HostContext, noRetry bool) error {
+func runWatchdog(ctx context.Context, curRef *atomic.Value, initial *HostContext, noRetry bool, fo *failover) error {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	v := curRef.Load().(*cur)
 	for {
+		brokerDown := false
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-ticker.C:
-			v := curRef.Load().(*cur)
+		case <-v.sess.Broker.Done():
+			brokerDown = true
+		}
 
-			// Probe EP0 vendor ready
-			idx := uint16(0)
-			if v.sess != nil && v.sess.Intf != nil {
-				idx = uint16(v.sess.Intf.Setting.Number)
-			}
-			ok, err := common.VendorReadyInInterface(v.sess.Dev, common.VendorReqReady, idx, v.sess.Log)
+		// Session swapped underneath us (failover from the HTTP path); retire the old one.
+		if nv := curRef.Load().(*cur); nv != v {
+			v.sess.Close()
+			v = nv
+			continue
+		}
 
-			if ok && err == nil {
-				continue
-			}
+		// Probe EP0 vendor ready
+		idx := uint16(0)
+		if v.sess != nil && v.sess.Intf != nil {
+			idx = uint16(v.sess.Intf.Setting.Number)
+		}
+		ok, err := common.VendorReadyInInterface(v.sess.Dev, common.VendorReqReady, idx, v.sess.Log)
 
-			// Not ready or errored → handle policy
-			if noRetry {
-				// Exit non-zero by returning an error
-				if err != nil {
-					v.sess.Log.Error("gadget disconnected", slog.Any("err", err))
-					return fmt.Errorf("gadget disconnected: %w", err)
-				}
-				v.sess.Log.Error("gadget not ready; exiting (--no-retry)")
-				return fmt.Errorf("gadget not ready")
-			}
+		if ok && err == nil && !brokerDown {
+			continue
+		}
 
+		reason := "gadget not ready"
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case brokerDown:
+			reason = "broker stopped"
+		}
+
+		if fo.trigger(reason) {
+			v.sess.Close()
+			v = curRef.Load().(*cur)
+			continue
+		}
+
+		// Not ready or errored → handle policy
+		if noRetry {
+			// Exit non-zero by returning an error
 			if err != nil {
-				v.sess.Log.Warn("gadget not ready, attempting reconnect...", slog.Any("err", err))
-			} else {
-				v.sess.Log.Warn("gadget not ready, attempting reconnect...")
+				v.sess.Log.Error("gadget disconnected", slog.Any("err", err))
+				return fmt.Errorf("gadget disconnected: %w", err)
 			}
+			v.sess.Log.Error("gadget not ready; exiting (--no-retry)", slog.String("reason", reason))
+			return fmt.Errorf("gadget not ready")
+		}
 
-			oldSess := v.sess
+		v.sess.Log.Warn("gadget not ready, attempting reconnect...", slog.String("reason", reason))
 
-			// Try indefinitely until success or context cancelled
-			params := common.ConnectParams{
-				Serial:  oldSess.Serial,
-				Logger:  initial.Log,
-				Channel: oldSess.Channel,
-			}
-			oldSess.Close()
+		oldSess := v.sess
 
-			s, rerr := tryReconnect(ctx, params)
-			if rerr != nil {
-				return rerr
-			}
-			v.sess.Log.Info("reconnected", slog.String("serial", s.Serial))
-			curRef.Store(&cur{sess: s})
+		// Try indefinitely until success or context cancelled
+		params := common.ConnectParams{
+			Serial:  oldSess.Serial,
+			Logger:  initial.Log,
+			Channel: oldSess.Channel,
+		}
+		oldSess.Close()
+
+		s, rerr := tryReconnect(ctx, params)
+		if rerr != nil {
+			return rerr
 		}
+		initial.Log.Info("reconnected", slog.String("serial", s.Serial))
+		v = &cur{sess: s}
+		curRef.Store(v)
+	}
+}
+
+// waitInflight waits for wg or ctx, whichever comes first. It reports whether wg drained.
+func waitInflight(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -111,7 +168,7 @@ func tryReconnect(ctx context.Context, p common.ConnectParams) (*common.Session,
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
-			if s, err := common.Connect(p); err == nil {
+			if s, err := connectPaired(p); err == nil {
 				return s, nil
 			}
 			time.Sleep(500 * time.Millisecond)
@@ -132,12 +189,9 @@ func withSession(channel common.Channel) func(ctx context.Context, cmd *cli.Comm
 
 		devSerial := cmd.String("device")
 
-		l.Debug("opening USB", slog.String("cmd", cmd.Name), slog.String("channel", map[common.Channel]string{
-			common.ChanSign: "sign",
-			common.ChanMgmt: "mgmt",
-		}[channel]))
+		l.Debug("opening USB", slog.String("cmd", cmd.Name), slog.String("channel", channel.String()))
 
-		sess, err := common.Connect(common.ConnectParams{
+		sess, err := connectPaired(common.ConnectParams{
 			Serial:  devSerial,
 			Logger:  l,
 			Channel: channel,
@@ -156,7 +210,7 @@ func withLoggerOnly() func(ctx context.Context, cmd *cli.Command) (context.Conte
 	return func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 		logCfg := logging.NewConfigFromEnv()
 		if logCfg.File == "" {
-			logCfg.File = logging.DefaultFileInExecDir(logFileName)
+			logCfg.File = defaultHostFile(logFileName)
 		}
 		if err := logging.EnsureDir(logCfg.File); err != nil {
 			return ctx, fmt.Errorf("log dir: %w", err)
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/urfave/cli/v3"
+)
+
+// throughputWindow is the span over which the sign rate is averaged.
+const throughputWindow = time.Minute
+
+func cmdWatch() *cli.Command {
+	return &cli.Command{
+		Name:  "watch",
+		Usage: "Live dashboard of devices, key states, sign throughput and broker health (holds the management interface while open)",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Refresh interval",
+				Value: time.Second,
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if !isTTY(os.Stdout) {
+				return ErrWatchNeedsTTY
+			}
+			h := mustHost(ctx)
+			m := newWatchModel(h, c.Duration("interval"))
+			_, err := tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+			if errors.Is(err, tea.ErrProgramKilled) {
+				return nil
+			}
+			return err
+		},
+	}
+}
+
+type watchTickMsg time.Time
+
+type watchPollMsg struct {
+	at      time.Time
+	keys    []*signer.KeyStatus
+	devices []common.DeviceInfo
+	rtt     time.Duration
+	err     error
+}
+
+// signEvent is one observed watermark advance, i.e. at least one signature.
+type signEvent struct {
+	at time.Time
+	n  int
+}
+
+type watchModel struct {
+	h        *HostContext
+	interval time.Duration
+	width    int
+
+	rows    []statusRow
+	devices []common.DeviceInfo
+	rtt     time.Duration
+	lastErr error
+	lastOK  time.Time
+	fails   int
+	polls   int
+
+	prev   map[string]statusRow // by key id, for throughput
+	events []signEvent
+}
+
+func newWatchModel(h *HostContext, interval time.Duration) *watchModel {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &watchModel{h: h, interval: interval, width: 100}
+}
+
+func (m *watchModel) Init() tea.Cmd {
+	return m.poll()
+}
+
+func (m *watchModel) tick() tea.Cmd {
+	return tea.Tick(m.interval, func(t time.Time) tea.Msg { return watchTickMsg(t) })
+}
+
+func (m *watchModel) poll() tea.Cmd {
+	b := m.h.Session.Broker
+	l := m.h.Log
+	return func() tea.Msg {
+		msg := watchPollMsg{at: time.Now()}
+		start := time.Now()
+		st, err := common.ReqStatus(b)
+		msg.rtt = time.Since(start)
+		msg.err = err
+		msg.keys = st.GetKeys()
+		msg.devices, _ = common.ListFFSDevices(l)
+		return msg
+	}
+}
+
+func (m *watchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case watchTickMsg:
+		return m, m.poll()
+	case watchPollMsg:
+		m.polls++
+		m.devices = msg.devices
+		m.rtt = msg.rtt
+		if msg.err != nil {
+			m.fails++
+			m.lastErr = msg.err
+		} else {
+			m.lastErr = nil
+			m.lastOK = msg.at
+			m.observe(msg.at, statusRows(msg.keys))
+		}
+		return m, m.tick()
+	}
+	return m, nil
+}
+
+// observe counts watermark advances since the previous poll. Several
+// signatures between two polls count once per kind, so the rate is a floor.
+func (m *watchModel) observe(at time.Time, rows []statusRow) {
+	n := 0
+	next := make(map[string]statusRow, len(rows))
+	for _, r := range rows {
+		if p, ok := m.prev[r.ID]; ok {
+			if r.BLevel != p.BLevel || r.BRound != p.BRound {
+				n++
+			}
+			if r.PLevel != p.PLevel || r.PRound != p.PRound {
+				n++
+			}
+			if r.ALevel != p.ALevel || r.ARound != p.ARound {
+				n++
+			}
+		}
+		next[r.ID] = r
+	}
+	m.rows = rows
+	m.prev = next
+
+	if n > 0 {
+		m.events = append(m.events, signEvent{at: at, n: n})
+	}
+	cut := at.Add(-throughputWindow)
+	i := 0
+	for i < len(m.events) && m.events[i].at.Before(cut) {
+		i++
+	}
+	m.events = m.events[i:]
+}
+
+func (m *watchModel) signsPerMinute() int {
+	total := 0
+	for _, e := range m.events {
+		total += e.n
+	}
+	return total
+}
+
+func (m *watchModel) brokerHealth() string {
+	select {
+	case <-m.h.Session.Broker.Done():
+		return stateLocked.Render("DOWN")
+	default:
+	}
+	if m.lastErr != nil {
+		return stateLocked.Render("ERROR") + " " + m.lastErr.Error()
+	}
+	if m.lastOK.IsZero() {
+		return "connecting…"
+	}
+	return stateUnlocked.Render("OK")
+}
+
+func (m *watchModel) View() string {
+	var sb strings.Builder
+
+	sb.WriteString(headerStyle.Render("TezSign watch") + "  " + time.Now().Format(time.TimeOnly) + "\n\n")
+
+	serials := make([]string, 0, len(m.devices))
+	for _, d := range m.devices {
+		label := d.Serial
+		if d.Serial == m.h.Session.Serial {
+			label += " (this)"
+		}
+		serials = append(serials, label)
+	}
+	if len(serials) == 0 {
+		sb.WriteString("Devices: none\n")
+	} else {
+		sb.WriteString("Devices:\n" + renderAliasChips(serials, m.width) + "\n")
+	}
+
+	var lastBlock, lastAtt uint64
+	for _, r := range m.rows {
+		lastBlock = max(lastBlock, r.BLevel)
+		lastAtt = max(lastAtt, r.ALevel)
+	}
+	stats := []string{
+		fmt.Sprintf("broker: %s", m.brokerHealth()),
+		fmt.Sprintf("rtt: %s", m.rtt.Round(time.Millisecond)),
+		fmt.Sprintf("failed polls: %d/%d", m.fails, m.polls),
+		fmt.Sprintf("signs/min: %d", m.signsPerMinute()),
+		fmt.Sprintf("last block: %d", lastBlock),
+		fmt.Sprintf("last attestation: %d", lastAtt),
+	}
+	sb.WriteString("\n" + strings.Join(stats, "  •  ") + "\n\n")
+
+	if len(m.rows) > 0 {
+		sb.WriteString(renderStatusTable(m.rows, statusTableOpts{Cursor: -1}) + "\n")
+	} else if m.lastErr == nil {
+		sb.WriteString("No keys found.\n")
+	}
+
+	sb.WriteString("\nq/esc quit\n")
+	return sb.String()
+}
@@ -0,0 +1,304 @@
+package main
+
+// Octez remote-signer conformance check.
+//
+// Starts a simulated gadget (keychain in a temp dir, broker on a unix socket),
+// runs `tezsign run` against it and exercises the octez HTTP signer API. If
+// octez-client is on PATH it is also used to import the remote key and sign.
+//
+//	go run ./app/tests/octez_conformance [--host-bin ./tezsign]
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+	"google.golang.org/protobuf/proto"
+)
+
+var failures int
+
+func check(name string, ok bool, detail string) {
+	if ok {
+		fmt.Println("PASS", name)
+		return
+	}
+	failures++
+	fmt.Println("FAIL", name, "-", detail)
+}
+
+func main() {
+	hostBin := flag.String("host-bin", "", "prebuilt tezsign host binary, built with -tags simulator (default: go build -tags simulator ./app/host)")
+	port := flag.Int("port", 20190, "HTTP port for the signer under test")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "tezsign-conformance-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tz4, sock := startSimulator(dir, l)
+
+	if *hostBin == "" {
+		*hostBin = filepath.Join(dir, "tezsign")
+		build := exec.Command("go", "build", "-tags", "simulator", "-o", *hostBin, "./app/host")
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err := build.Run(); err != nil {
+			log.Fatalf("build host: %v", err)
+		}
+	}
+
+	base := fmt.Sprintf("http://127.0.0.1:%d", *port)
+	host := exec.Command(*hostBin, "run", "--listen", fmt.Sprintf("127.0.0.1:%d", *port))
+	host.Env = append(os.Environ(),
+		common.EnvSimulator+"="+sock,
+		"TEZSIGN_PAIR_FILE="+filepath.Join(dir, "paired.json"),
+		"LOG_FILE="+filepath.Join(dir, "host.log"),
+		"LOG_STDERR=false",
+	)
+	if err := host.Start(); err != nil {
+		log.Fatalf("start host: %v", err)
+	}
+	defer host.Process.Kill()
+
+	if !waitHTTP(base+"/authorized_keys", 15*time.Second) {
+		log.Fatalf("signer did not come up on %s", base)
+	}
+
+	runHTTPChecks(base, tz4)
+	runOctezClient(base, tz4, dir)
+
+	if failures > 0 {
+		fmt.Printf("%d check(s) failed\n", failures)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+}
+
+// startSimulator creates an unlocked key and serves the gadget RPCs the HTTP
+// API needs (status, sign, pop, health) on a unix socket.
+func startSimulator(dir string, l *slog.Logger) (tz4, sock string) {
+	pass := []byte("conformance")
+	fs, err := keychain.NewFileStore(filepath.Join(dir, "keystore"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := fs.InitMaster(); err != nil {
+		log.Fatal(err)
+	}
+	if err := fs.WriteSeed(pass, false); err != nil {
+		log.Fatal(err)
+	}
+	kr := keychain.NewKeyRing(l, fs)
+	id, _, tz4, err := kr.CreateKey("baker", pass)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := kr.Unlock(id, pass); err != nil {
+		log.Fatal(err)
+	}
+
+	sock = filepath.Join(dir, "gadget.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t := common.ConnTransport{Conn: conn}
+			broker.New(t, t, broker.WithLogger(l), broker.WithHandler(simHandler(kr)))
+		}
+	}()
+	return tz4, sock
+}
+
+func simHandler(kr *keychain.KeyRing) broker.Handler {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		var req signer.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		var resp signer.Response
+		switch p := req.Payload.(type) {
+		case *signer.Request_Status:
+			resp.Payload = &signer.Response_Status{Status: &signer.StatusResponse{Keys: kr.Status()}}
+		case *signer.Request_Sign:
+			sig, err := kr.SignAndUpdate(p.Sign.GetTz4(), p.Sign.GetMessage())
+			if err != nil {
+				code := uint32(30)
+				if errors.Is(err, keychain.ErrStaleWatermark) {
+					code = common.RpcStaleWatermark
+				}
+				resp.Payload = &signer.Response_Error{Error: &signer.Error{Code: code, Message: err.Error()}}
+				break
+			}
+			resp.Payload = &signer.Response_Sign{Sign: &signer.SignResponse{Signature: sig}}
+		case *signer.Request_Pop:
+			pop, err := kr.ProvePossession(p.Pop.GetTz4())
+			if err != nil {
+				resp.Payload = &signer.Response_Error{Error: &signer.Error{Code: 100, Message: err.Error()}}
+				break
+			}
+			resp.Payload = &signer.Response_Pop{Pop: &signer.PopResponse{Pop: pop}}
+		case *signer.Request_Health:
+			resp.Payload = &signer.Response_Health{Health: &signer.HealthResponse{Version: "simulator"}}
+		default:
+			resp.Payload = &signer.Response_Error{Error: &signer.Error{Code: 1000, Message: "unknown request"}}
+		}
+		return proto.Marshal(&resp)
+	}
+}
+
+func runHTTPChecks(base, tz4 string) {
+	var m map[string]any
+
+	code := getJSON(base+"/authorized_keys", &m)
+	check("GET /authorized_keys", code == 200 && len(m) == 0, fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = getJSON(base+"/keys/"+tz4, &m)
+	pk, _ := m["public_key"].(string)
+	check("GET /keys/<pkh>", code == 200 && strings.HasPrefix(pk, "BLpk"), fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = getJSON(base+"/keys/tz4unknown", &m)
+	check("GET /keys/<unknown> is 404", code == 404, fmt.Sprintf("status=%d", code))
+
+	var known struct {
+		KnownKeys []string `json:"known_keys"`
+	}
+	code = getJSON(base+"/known_keys", &known)
+	check("GET /known_keys", code == 200 && len(known.KnownKeys) == 1 && known.KnownKeys[0] == tz4, fmt.Sprintf("status=%d body=%v", code, known))
+
+	m = nil
+	code = getJSON(base+"/bls_prove_possession/"+tz4, &m)
+	pop, _ := m["bls_prove_possession"].(string)
+	check("GET /bls_prove_possession/<pkh>", code == 200 && strings.HasPrefix(pop, "BLsig"), fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = getJSON(base+"/supports_deterministic_nonces/"+tz4, &m)
+	check("GET /supports_deterministic_nonces/<pkh>", code == 200 && m["supports_deterministic_nonces"] == false, fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = getJSON(base+"/version", &m)
+	check("GET /version", code == 200 && m["version"] != nil, fmt.Sprintf("status=%d body=%v", code, m))
+
+	code, body := getText(base + "/metrics")
+	check("GET /metrics", code == 200 && strings.Contains(body, "tezsign_gadget_up 1"), fmt.Sprintf("status=%d body=%q", code, body))
+
+	m = nil
+	code = postJSON(base+"/keys/"+tz4, hex.EncodeToString(attestation(100, 0)), &m)
+	sig, _ := m["signature"].(string)
+	check("POST /keys/<pkh> attestation", code == 200 && strings.HasPrefix(sig, "BLsig"), fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = postJSON(base+"/keys/"+tz4, hex.EncodeToString(attestation(99, 0)), &m)
+	check("POST /keys/<pkh> below watermark is 409", code == 409, fmt.Sprintf("status=%d body=%v", code, m))
+
+	m = nil
+	code = postJSON(base+"/keys/"+tz4, "zz", &m)
+	check("POST /keys/<pkh> bad hex is 400", code == 400, fmt.Sprintf("status=%d body=%v", code, m))
+}
+
+// runOctezClient imports the signer's key into a throwaway octez-client
+// base dir and signs one attestation through it.
+func runOctezClient(base, tz4, dir string) {
+	client, err := exec.LookPath("octez-client")
+	if err != nil {
+		fmt.Println("SKIP octez-client checks (octez-client not on PATH)")
+		return
+	}
+	baseDir := filepath.Join(dir, "octez-client")
+	run := func(args ...string) (string, error) {
+		out, err := exec.Command(client, append([]string{"--base-dir", baseDir, "--endpoint", "http://127.0.0.1:1"}, args...)...).CombinedOutput()
+		return string(out), err
+	}
+
+	out, err := run("import", "secret", "key", "tezsign", base+"/"+tz4, "--force")
+	check("octez-client import remote key", err == nil, out)
+
+	out, err = run("show", "address", "tezsign")
+	check("octez-client show address", err == nil && strings.Contains(out, tz4), out)
+
+	out, err = run("sign", "bytes", "0x"+hex.EncodeToString(attestation(200, 0)), "for", "tezsign")
+	check("octez-client sign bytes", err == nil && strings.Contains(out, "BLsig"), out)
+}
+
+func attestation(level, round uint32) []byte {
+	// w(1)=0x13 | chain_id(4) | branch(32) | kind(1) | level(i32) | round(i32)
+	buf := make([]byte, 1+4+32+1+4+4)
+	buf[0] = 0x13
+	copy(buf[1:5], []byte{0x7a, 0x06, 0xa7, 0x70})
+	buf[37] = 0x15
+	binary.BigEndian.PutUint32(buf[38:], level)
+	binary.BigEndian.PutUint32(buf[42:], round)
+	return buf
+}
+
+func waitHTTP(url string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+			return true
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return false
+}
+
+func getJSON(url string, out any) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	_ = json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
+
+func getText(url string) (int, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func postJSON(url string, body any, out any) int {
+	b, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	_ = json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
@@ -0,0 +1,109 @@
+// Package audit builds and checks the hash chain of the gadget's audit logs.
+//
+// Every record is one JSON object per line that starts with its sequence
+// number and the SHA-256 of the previous line:
+//
+//	{"seq":42,"prev":"9f86d0…",...fields}
+//
+// The first record of a chain has seq 1 and an all-zero prev. Editing,
+// dropping or reordering a line breaks the link to the next one.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Hash is the SHA-256 of a record line (without its newline).
+type Hash [sha256.Size]byte
+
+func (h Hash) String() string { return hex.EncodeToString(h[:]) }
+
+var (
+	ErrNotObject  = errors.New("audit: record is not a JSON object")
+	ErrBadRecord  = errors.New("audit: malformed record")
+	ErrBrokenLink = errors.New("audit: chain broken")
+)
+
+// Link serializes rec as the record after prev and returns the line and its
+// hash. rec must marshal to a non-empty JSON object without seq or prev.
+func Link(seq uint64, prev Hash, rec any) ([]byte, Hash, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, Hash{}, err
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) < 3 || body[0] != '{' {
+		return nil, Hash{}, ErrNotObject
+	}
+	line := fmt.Appendf(nil, `{"seq":%d,"prev":"%s",`, seq, prev)
+	line = append(line, body[1:]...)
+	return line, sha256.Sum256(line), nil
+}
+
+type header struct {
+	Seq  uint64 `json:"seq"`
+	Prev string `json:"prev"`
+}
+
+// Parse returns the sequence number and previous-record hash of line.
+func Parse(line []byte) (uint64, Hash, error) {
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil || h.Seq == 0 {
+		return 0, Hash{}, ErrBadRecord
+	}
+	var prev Hash
+	raw, err := hex.DecodeString(h.Prev)
+	if err != nil || len(raw) != len(prev) {
+		return 0, Hash{}, ErrBadRecord
+	}
+	copy(prev[:], raw)
+	return h.Seq, prev, nil
+}
+
+// Torn reports whether line is the fragment of a record cut short by a
+// power loss: not even valid JSON. The writer leaves it on its own line and
+// links the next record to the one before it, so skipping it loses nothing;
+// a record replaced by one still breaks the next link.
+func Torn(line []byte) bool {
+	return !json.Valid(line)
+}
+
+// Verifier checks consecutive records, skipping torn fragments. Without an
+// anchor the first record is trusted as-is, unless it claims to start the
+// chain (seq 1), in which case its prev must be zero.
+type Verifier struct {
+	First, Last uint64 // sequence numbers seen so far
+	Head        Hash   // hash of the last record
+	Torn        int    // fragments skipped
+	started     bool
+}
+
+// Add checks line against the record before it.
+func (v *Verifier) Add(line []byte) error {
+	seq, prev, err := Parse(line)
+	if err != nil && Torn(line) {
+		v.Torn++
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w (after seq %d)", err, v.Last)
+	}
+	switch {
+	case v.started && seq != v.Last+1:
+		return fmt.Errorf("%w: seq %d follows %d", ErrBrokenLink, seq, v.Last)
+	case v.started && prev != v.Head:
+		return fmt.Errorf("%w: seq %d does not match the hash of seq %d", ErrBrokenLink, seq, v.Last)
+	case !v.started && seq == 1 && prev != (Hash{}):
+		return fmt.Errorf("%w: seq 1 has a predecessor", ErrBrokenLink)
+	}
+	if !v.started {
+		v.First, v.started = seq, true
+	}
+	v.Last, v.Head = seq, sha256.Sum256(line)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log/slog"
 	"runtime"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -26,6 +27,7 @@ type Handler func(ctx context.Context, payload []byte) ([]byte, error)
 
 type options struct {
 	bufSize int
+	workers int
 	handler Handler
 	logger  *slog.Logger
 }
@@ -40,6 +42,16 @@ func WithBufferSize(n int) Option {
 	}
 }
 
+// WithWorkers caps how many requests the handler runs at once; further
+// requests are accepted and wait for a free worker. 0 = no cap.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.workers = n
+		}
+	}
+}
+
 func WithHandler(h Handler) Option {
 	return func(o *options) { o.handler = h }
 }
@@ -60,6 +72,8 @@ type Broker struct {
 
 	waiters waiterMap
 	handler Handler
+	workers chan struct{} // nil = no cap
+	onEvent atomic.Pointer[func(payload []byte)]
 
 	writeChan           chan []byte
 	processingRequests  requestMap[struct{}]
@@ -67,11 +81,13 @@ type Broker struct {
 
 	capacity int
 	logger   *slog.Logger
+	stats    stats
 
 	ctx            context.Context
 	cancel         context.CancelFunc
 	readLoopDone   <-chan struct{}
 	writerLoopDone <-chan struct{}
+	done           chan struct{}
 }
 
 func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
@@ -105,13 +121,73 @@ func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
 		stash:  newStash(o.bufSize, o.logger),
 		ctx:    ctx,
 		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if o.workers > 0 {
+		b.workers = make(chan struct{}, o.workers)
 	}
 
 	b.readLoopDone = b.readLoop()
 	b.writerLoopDone = b.writerLoop()
+	go b.watchLoops()
 	return b
 }
 
+// watchLoops tears the broker down as soon as either loop exits, so pending
+// requests fail fast instead of waiting for their own deadlines.
+func (b *Broker) watchLoops() {
+	select {
+	case <-b.readLoopDone:
+	case <-b.writerLoopDone:
+	case <-b.ctx.Done():
+	}
+	b.cancel()
+	close(b.done)
+}
+
+// Done is closed once the broker can no longer carry traffic, either because
+// Stop was called or because the underlying transport failed.
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// QueueDepth reports incoming requests still being handled plus outgoing
+// frames waiting for the writer.
+func (b *Broker) QueueDepth() int {
+	return b.processingRequests.Len() + len(b.writeChan)
+}
+
+// OnEvent sets the callback for events sent by the peer's Notify; events
+// arriving without one are dropped. The callback runs on its own goroutine
+// per event, so events may be seen out of order.
+func (b *Broker) OnEvent(fn func(payload []byte)) {
+	b.onEvent.Store(&fn)
+}
+
+// Notify queues an unsolicited event for the peer without waiting for room
+// or for an answer; it returns ErrWriteQueueFull when the writer is behind.
+// Only send events to peers that asked for them: older peers log unknown
+// frame types.
+func (b *Broker) Notify(payload []byte) error {
+	frame, err := newMessage(payloadTypeEvent, NewMessageID(), payload)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-b.ctx.Done():
+		return io.EOF
+	default:
+	}
+	select {
+	case b.writeChan <- frame:
+		b.stats.eventsSent.Add(1)
+		return nil
+	default:
+		return ErrWriteQueueFull
+	}
+}
+
 func (b *Broker) Request(ctx context.Context, payload []byte) ([]byte, [16]byte, error) {
 	var id [16]byte
 	payloadLen := len(payload)
@@ -123,14 +199,21 @@ func (b *Broker) Request(ctx context.Context, payload []byte) ([]byte, [16]byte,
 		return nil, id, fmt.Errorf("payload exceeds maximum message payload (%d bytes)", MAX_MESSAGE_PAYLOAD)
 	}
 
-	id, ch := b.waiters.NewWaiter()
+	var ch chan []byte
+	if rid, ok := RequestID(ctx); ok {
+		id, ch = b.waiters.NewWaiterWithID(rid)
+	} else {
+		id, ch = b.waiters.NewWaiter()
+	}
 	b.unconfirmedRequests.Store(id, payload)
+	b.stats.requestsSent.Add(1)
 
 	b.logger.Debug("tx req", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", payloadLen))
 
 	if err := b.writeFrame(ctx, payloadTypeRequest, id, payload); err != nil {
 		b.logger.Debug("tx req write failed", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
 		b.waiters.Delete(id)
+		b.stats.requestsFailed.Add(1)
 		return nil, id, err
 	}
 
@@ -140,10 +223,12 @@ func (b *Broker) Request(ctx context.Context, payload []byte) ([]byte, [16]byte,
 	case <-ctx.Done():
 		b.unconfirmedRequests.Delete(id)
 		b.waiters.Delete(id)
+		b.stats.requestsFailed.Add(1)
 		return nil, id, ctx.Err()
 	case <-b.ctx.Done():
 		b.unconfirmedRequests.Delete(id)
 		b.waiters.Delete(id)
+		b.stats.requestsFailed.Add(1)
 		return nil, id, io.EOF
 	}
 }
@@ -165,12 +250,14 @@ func (b *Broker) writerLoop() <-chan struct{} {
 			for retries := 0; retries < maxWriteRetries; retries++ {
 				if _, err := b.w.WriteContext(b.ctx, data); err != nil {
 					if isRetryable(err) {
+						b.stats.writeRetries.Add(1)
 						b.logger.Debug("write retryable error", slog.Any("err", err), slog.Int("retry", retries+1))
 						continue
 					}
 					b.logger.Error("write loop exit", slog.Any("err", err))
 					return
 				}
+				b.stats.bytesWritten.Add(uint64(len(data)))
 				success = true
 				break
 			}
@@ -190,6 +277,7 @@ func (b *Broker) readLoop() <-chan struct{} {
 		for {
 			n, err := b.r.ReadContext(b.ctx, buf[:])
 			if n > 0 {
+				b.stats.bytesRead.Add(uint64(n))
 				b.stash.Write(buf[:n])
 				clear(buf[:n]) // clear buffer after we used it
 				b.processStash()
@@ -197,6 +285,7 @@ func (b *Broker) readLoop() <-chan struct{} {
 
 			if err != nil {
 				if isRetryable(err) {
+					b.stats.readRetries.Add(1)
 					// send retry packet to
 					b.writeFrame(b.ctx, payloadTypeRetry, [16]byte{}, nil)
 					b.logger.Debug("read retryable error", slog.Any("err", err))
@@ -220,9 +309,11 @@ func (b *Broker) processStash() {
 			runtime.GC() // encourage freeing stash buffers
 			return
 		case errors.Is(err, ErrInvalidPayloadSize):
+			b.stats.resyncs.Add(1)
 			continue // resync
 		case err != nil:
 			b.logger.Warn("bad payload; resync", slog.Any("err", err))
+			b.stats.resyncs.Add(1)
 			continue // resync
 		}
 
@@ -255,13 +346,28 @@ func (b *Broker) processStash() {
 					return
 				}
 				defer b.processingRequests.Delete(id)
-				resp, _ := b.handler(b.ctx, payload)
+				if b.workers != nil {
+					select {
+					case b.workers <- struct{}{}:
+						defer func() { <-b.workers }()
+					case <-b.ctx.Done():
+						return
+					}
+				}
+				resp, _ := b.handler(WithRequestID(withBroker(b.ctx, b), id), payload)
+				b.stats.requestsHandled.Add(1)
 
 				b.logger.Debug("tx resp", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(resp)))
 				_ = b.writeFrame(b.ctx, payloadTypeResponse, id, resp) // Put is deferred inside writeFrame if pooled
 			case payloadTypeAcceptRequest:
 				b.logger.Debug("rx accept", slog.String("id", fmt.Sprintf("%x", id)))
 				b.unconfirmedRequests.Delete(id)
+			case payloadTypeEvent:
+				b.logger.Debug("rx event", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(payload)))
+				b.stats.eventsReceived.Add(1)
+				if fn := b.onEvent.Load(); fn != nil && *fn != nil {
+					(*fn)(payload)
+				}
 			case payloadTypeRetry:
 				b.logger.Debug("rx retry", slog.String("id", fmt.Sprintf("%x", id)))
 				allUnconfirmed := b.unconfirmedRequests.All()
@@ -269,6 +375,7 @@ func (b *Broker) processStash() {
 					b.writeFrame(b.ctx, payloadTypeRequest, reqID, reqPayload)
 				}
 			default:
+				b.stats.resyncs.Add(1)
 				b.logger.Warn("unknown type; resync", slog.String("type", fmt.Sprintf("%02x", payloadType)), slog.String("id", fmt.Sprintf("%x", id)))
 			}
 		}(id, pt, payload)
@@ -292,8 +399,14 @@ func (b *Broker) writeFrame(ctx context.Context, msgType payloadType, id [16]byt
 		return err
 	}
 
-	b.writeChan <- frame
-	return nil
+	select {
+	case b.writeChan <- frame:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.ctx.Done():
+		return io.EOF
+	}
 }
 
 func (b *Broker) Stop() {
@@ -37,4 +37,5 @@ const (
 	payloadTypeResponse      payloadType = 0x02
 	payloadTypeAcceptRequest payloadType = 0x03
 	payloadTypeRetry         payloadType = 0x04
+	payloadTypeEvent         payloadType = 0x05 // unsolicited, never acknowledged
 )
@@ -0,0 +1,31 @@
+package broker
+
+import "context"
+
+type requestIDKey struct{}
+
+type brokerKey struct{}
+
+// WithRequestID makes Request use id as the frame ID instead of a random one,
+// so a caller-side correlation ID is what the peer sees on the wire.
+func WithRequestID(ctx context.Context, id [16]byte) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the frame ID carried by ctx. Inside a Handler this is
+// the ID of the incoming request.
+func RequestID(ctx context.Context) ([16]byte, bool) {
+	id, ok := ctx.Value(requestIDKey{}).([16]byte)
+	return id, ok
+}
+
+func withBroker(ctx context.Context, b *Broker) context.Context {
+	return context.WithValue(ctx, brokerKey{}, b)
+}
+
+// FromContext returns the broker that received the request a Handler is
+// serving, e.g. to Notify the same peer later.
+func FromContext(ctx context.Context) (*Broker, bool) {
+	b, ok := ctx.Value(brokerKey{}).(*Broker)
+	return b, ok
+}
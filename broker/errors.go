@@ -18,4 +18,6 @@ var (
 	ErrDecodeHeaderShort             = errors.New("short header")
 	ErrDecodeHeaderBadMagic          = errors.New("bad magic")
 	ErrDecodeHeaderBadParity         = errors.New("bad parity")
+
+	ErrWriteQueueFull = errors.New("write queue full")
 )
@@ -0,0 +1,49 @@
+package broker
+
+import "sync/atomic"
+
+// Stats counts a broker's traffic since New.
+type Stats struct {
+	RequestsSent    uint64 `json:"requests_sent"`
+	RequestsFailed  uint64 `json:"requests_failed"` // write failed, timed out, or broker stopped
+	RequestsHandled uint64 `json:"requests_handled"`
+	EventsSent      uint64 `json:"events_sent"`
+	EventsReceived  uint64 `json:"events_received"`
+	BytesWritten    uint64 `json:"bytes_written"`
+	BytesRead       uint64 `json:"bytes_read"`
+	WriteRetries    uint64 `json:"write_retries"`
+	ReadRetries     uint64 `json:"read_retries"`
+	Resyncs         uint64 `json:"resyncs"` // bad frames skipped
+	QueueDepth      int    `json:"queue_depth"`
+}
+
+type stats struct {
+	requestsSent    atomic.Uint64
+	requestsFailed  atomic.Uint64
+	requestsHandled atomic.Uint64
+	eventsSent      atomic.Uint64
+	eventsReceived  atomic.Uint64
+	bytesWritten    atomic.Uint64
+	bytesRead       atomic.Uint64
+	writeRetries    atomic.Uint64
+	readRetries     atomic.Uint64
+	resyncs         atomic.Uint64
+}
+
+// Stats reads the counters; they keep counting after the broker is done.
+func (b *Broker) Stats() Stats {
+	s := &b.stats
+	return Stats{
+		RequestsSent:    s.requestsSent.Load(),
+		RequestsFailed:  s.requestsFailed.Load(),
+		RequestsHandled: s.requestsHandled.Load(),
+		EventsSent:      s.eventsSent.Load(),
+		EventsReceived:  s.eventsReceived.Load(),
+		BytesWritten:    s.bytesWritten.Load(),
+		BytesRead:       s.bytesRead.Load(),
+		WriteRetries:    s.writeRetries.Load(),
+		ReadRetries:     s.readRetries.Load(),
+		Resyncs:         s.resyncs.Load(),
+		QueueDepth:      b.QueueDepth(),
+	}
+}
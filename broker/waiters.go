@@ -17,6 +17,16 @@ func (wm *waiterMap) NewWaiter() ([16]byte, chan []byte) {
 	return id, ch
 }
 
+// NewWaiterWithID registers a waiter under a caller-chosen id.
+// It falls back to a random id if that one is already in flight.
+func (wm *waiterMap) NewWaiterWithID(id [16]byte) ([16]byte, chan []byte) {
+	ch := make(chan []byte, 1)
+	if _, loaded := wm.Map.LoadOrStore(id, ch); loaded {
+		return wm.NewWaiter()
+	}
+	return id, ch
+}
+
 func (wm *waiterMap) Delete(id [16]byte) {
 	wm.Map.Delete(id)
 }
@@ -63,6 +73,12 @@ func (rm *requestMap[T]) Delete(id [16]byte) {
 	delete(rm.store, id)
 }
 
+func (rm *requestMap[T]) Len() int {
+	rm.mtx.RLock()
+	defer rm.mtx.RUnlock()
+	return len(rm.store)
+}
+
 func (rm *requestMap[T]) All() map[[16]byte]T {
 	rm.mtx.RLock()
 	defer rm.mtx.RUnlock()
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signer"
+)
+
+// Client sends typed requests to the gadget on one broker. Every method
+// has a default timeout; a deadline on ctx replaces it, and WithTimeout
+// replaces both. A request ID set with broker.WithRequestID is used as the
+// frame ID so it shows up in gadget logs. Errors the gadget answers with
+// are *RemoteError and match the ErrKey*/Err* sentinels with errors.Is.
+type Client struct {
+	b *broker.Broker
+}
+
+func NewClient(b *broker.Broker) *Client {
+	return &Client{b: b}
+}
+
+type requestOptions struct {
+	timeout time.Duration
+}
+
+// RequestOption tunes a single Client request.
+type RequestOption func(*requestOptions)
+
+// WithTimeout bounds the request by d instead of its default or ctx's
+// deadline, whichever would apply.
+func WithTimeout(d time.Duration) RequestOption {
+	return func(o *requestOptions) { o.timeout = d }
+}
+
+func (c *Client) do(ctx context.Context, req *signer.Request, def time.Duration, opts []RequestOption) (*signer.Response, error) {
+	o := requestOptions{timeout: def}
+	if _, ok := ctx.Deadline(); ok {
+		o.timeout = 0
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	return send(ctx, c.b, req)
+}
+
+func (c *Client) Status(ctx context.Context, opts ...RequestOption) (*signer.StatusResponse, error) {
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Status{Status: &signer.StatusRequest{}},
+	}, 3*time.Second, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStatus(), nil
+}
+
+// Sign returns the compressed BLS signature of rawMsg by tz4.
+func (c *Client) Sign(ctx context.Context, tz4 string, rawMsg []byte, opts ...RequestOption) ([]byte, error) {
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Sign{
+			Sign: &signer.SignRequest{Tz4: tz4, Message: rawMsg},
+		},
+	}, DefaultSignTimeout, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetSign().GetSignature(), nil
+}
+
+// Unlock unlocks keys with pass; the copy sent is wiped afterwards.
+func (c *Client) Unlock(ctx context.Context, keys []string, pass []byte, opts ...RequestOption) ([]*signer.PerKeyResult, error) {
+	p := append([]byte(nil), pass...)
+	defer keychain.MemoryWipe(p)
+
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Unlock{
+			Unlock: &signer.UnlockRequest{KeyIds: keys, Passphrase: p},
+		},
+	}, 3*time.Second, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetUnlock().GetResults(), nil
+}
+
+func (c *Client) Lock(ctx context.Context, keys []string, opts ...RequestOption) ([]*signer.PerKeyResult, error) {
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Lock{
+			Lock: &signer.LockRequest{KeyIds: keys},
+		},
+	}, 3*time.Second, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetLock().GetResults(), nil
+}
+
+// Keygen creates the keys req describes (HD index, overwrite); the
+// passphrase is set from pass and wiped afterwards.
+func (c *Client) Keygen(ctx context.Context, req *signer.NewKeysRequest, pass []byte, opts ...RequestOption) ([]*signer.NewKeyPerKeyResult, error) {
+	p := append([]byte(nil), pass...)
+	defer keychain.MemoryWipe(p)
+
+	req.Passphrase = p
+	defer func() { req.Passphrase = nil }()
+
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_NewKeys{NewKeys: req},
+	}, 10*time.Second+ButtonConfirmTimeout, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetNewKey().GetResults(), nil
+}
+
+// Watermark is the highest (level, round) a key has signed for one kind.
+type Watermark struct {
+	Level uint64
+	Round uint32
+}
+
+// Behind reports whether w is below o.
+func (w Watermark) Behind(o Watermark) bool {
+	return w.Level < o.Level || (w.Level == o.Level && w.Round < o.Round)
+}
+
+// Watermarks are a key's watermarks by kind.
+type Watermarks map[keychain.SIGN_KIND]Watermark
+
+// StatusWatermarks reads the watermarks of ks.
+func StatusWatermarks(ks *signer.KeyStatus) Watermarks {
+	return Watermarks{
+		keychain.BLOCK:          {ks.GetLastBlockLevel(), ks.GetLastBlockRound()},
+		keychain.PREATTESTATION: {ks.GetLastPreattestationLevel(), ks.GetLastPreattestationRound()},
+		keychain.ATTESTATION:    {ks.GetLastAttestationLevel(), ks.GetLastAttestationRound()},
+	}
+}
+
+// Watermarks returns the watermarks of every key, by tz4.
+func (c *Client) Watermarks(ctx context.Context, opts ...RequestOption) (map[string]Watermarks, error) {
+	st, err := c.Status(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Watermarks, len(st.GetKeys()))
+	for _, ks := range st.GetKeys() {
+		out[ks.GetTz4()] = StatusWatermarks(ks)
+	}
+	return out, nil
+}
+
+func (c *Client) Health(ctx context.Context, opts ...RequestOption) (*signer.HealthResponse, error) {
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Health{Health: &signer.HealthRequest{}},
+	}, 3*time.Second, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetHealth(), nil
+}
+
+// Logs returns up to limit recent gadget log lines, from its in-memory
+// log when memory is set.
+func (c *Client) Logs(ctx context.Context, limit int, memory bool, opts ...RequestOption) ([]string, error) {
+	resp, err := c.do(ctx, &signer.Request{
+		Payload: &signer.Request_Logs{
+			Logs: &signer.LogsRequest{Limit: uint32(limit), Memory: memory},
+		},
+	}, 3*time.Second, opts)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetLogs().GetLines(), nil
+}
@@ -2,8 +2,12 @@ package common
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"runtime"
 	"sort"
 	"strings"
 
@@ -15,6 +19,11 @@ type DeviceInfo struct {
 	Serial       string
 	Manufacturer string
 	Product      string
+	// Interfaces is how many FFS interfaces the gadget advertises: 0 until
+	// its app has opened the endpoints, 2 on gadgets without the admin one.
+	Interfaces int
+	State      *GadgetState `json:",omitempty"` // nil if the gadget did not answer
+	Info       *GadgetInfo  `json:",omitempty"` // firmware; nil if the gadget did not answer
 }
 
 type ConnectParams struct {
@@ -31,10 +40,24 @@ type ConnectParams struct {
 type Channel int
 
 const (
-	ChanSign Channel = iota // IF0: sign
-	ChanMgmt                // IF1: management
+	ChanSign  Channel = iota // IF0: sign
+	ChanMgmt                 // IF1: management
+	ChanAdmin                // IF2: admin/telemetry (logs, health, updates)
 )
 
+func (c Channel) String() string {
+	switch c {
+	case ChanSign:
+		return "sign"
+	case ChanMgmt:
+		return "mgmt"
+	case ChanAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("Channel(%d)", int(c))
+	}
+}
+
 // Session owns the whole USB + broker stack and knows how to clean up.
 type Session struct {
 	Ctx *gousb.Context
@@ -48,7 +71,10 @@ type Session struct {
 	Channel Channel
 
 	Serial string
+	Info   GadgetInfo // zero for simulator sessions
 	Log    *slog.Logger
+
+	closer io.Closer // simulator connection
 }
 
 // Close in reverse order of creation
@@ -66,9 +92,14 @@ func (s *Session) Close() {
 	if s.Ctx != nil {
 		_ = s.Ctx.Close()
 	}
+	if s.closer != nil {
+		_ = s.closer.Close()
+	}
 }
 
-// ListFFSDevices lists all devices matching VID/PID with their serial/manufacturer/product.
+// ListFFSDevices lists all devices matching VID/PID with their
+// serial/manufacturer/product, the FFS interfaces they advertise and, from
+// the vendor requests, their state and firmware.
 func ListFFSDevices(l *slog.Logger) ([]DeviceInfo, error) {
 	if l == nil {
 		l = slog.New(slog.NewTextHandler(nil, nil))
@@ -81,7 +112,7 @@ func ListFFSDevices(l *slog.Logger) ([]DeviceInfo, error) {
 		return desc.Vendor == gousb.ID(VID) && desc.Product == gousb.ID(PID)
 	})
 	if err != nil {
-		return nil, err
+		return nil, openError(err)
 	}
 	defer func() {
 		for _, d := range devs {
@@ -94,11 +125,25 @@ func ListFFSDevices(l *slog.Logger) ([]DeviceInfo, error) {
 		sn, _ := d.SerialNumber()
 		man, _ := d.Manufacturer()
 		prod, _ := d.Product()
-		infos = append(infos, DeviceInfo{
+		info := DeviceInfo{
 			Serial:       strings.TrimSpace(sn),
 			Manufacturer: strings.TrimSpace(man),
 			Product:      strings.TrimSpace(prod),
-		})
+		}
+		_, ifaces := vendorInterfaces(d.Desc)
+		info.Interfaces = len(ifaces)
+		// Interfaces held by a running signer refuse control requests.
+		for iface := uint16(0); iface < 3 && info.State == nil; iface++ {
+			st, err := ReadGadgetState(d, iface, l)
+			if err != nil {
+				continue
+			}
+			info.State = &st
+			if gi, err := ReadGadgetInfo(d, iface, l); err == nil || errors.Is(err, ErrGadgetIncompatible) {
+				info.Info = &gi
+			}
+		}
+		infos = append(infos, info)
 	}
 	return infos, nil
 }
@@ -106,6 +151,35 @@ func ListFFSDevices(l *slog.Logger) ([]DeviceInfo, error) {
 // ResetDevice opens the first matching VID/PID device (or a specific serial if provided),
 // issues a USB port reset, and closes everything. No interface claim, no broker.
 func ResetDevice(serial string, l *slog.Logger) error {
+	return withDevice(serial, l, func(d *gousb.Device, serial string) error {
+		l.Info("USB port reset", slog.String("serial", serial))
+		if err := d.Reset(); err != nil {
+			return fmt.Errorf("%w: %w", ErrUSBResetFailed, err)
+		}
+		return nil
+	})
+}
+
+// ResetFunction asks the registrar to have the gadget app reopen its
+// endpoints (vendor request 0x5F). The device stays enumerated; use it to
+// clear a wedged data path. Interfaces held by a running signer refuse the
+// request, so each one is tried in turn.
+func ResetFunction(serial string, l *slog.Logger) error {
+	return withDevice(serial, l, func(d *gousb.Device, serial string) error {
+		l.Info("function reset", slog.String("serial", serial))
+		var err error
+		for iface := uint16(0); iface < 3; iface++ {
+			if err = ctrlOut(l, d, bmReqTypeVendorOut, VendorReqReset, 0, iface); err == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %w", ErrUSBResetFailed, err)
+	})
+}
+
+// withDevice opens the first matching VID/PID device (or a specific serial
+// if provided), runs fn on it and closes everything.
+func withDevice(serial string, l *slog.Logger, fn func(d *gousb.Device, serial string) error) error {
 	if l == nil {
 		l = slog.New(slog.NewTextHandler(nil, nil))
 	}
@@ -117,7 +191,7 @@ func ResetDevice(serial string, l *slog.Logger) error {
 		return desc.Vendor == gousb.ID(VID) && desc.Product == gousb.ID(PID)
 	})
 	if err != nil {
-		return fmt.Errorf("usb: open devices: %w", err)
+		return fmt.Errorf("usb: open devices: %w", openError(err))
 	}
 	defer func() {
 		for _, d := range devs {
@@ -154,16 +228,13 @@ func ResetDevice(serial string, l *slog.Logger) error {
 		return fmt.Errorf("%w: VID=%04x PID=%04x serial=%q (have: %v)", ErrDeviceNotFound, VID, PID, serial, alts)
 	}
 
-	l.Info("USB port reset", slog.String("serial", chosenSerial))
-	if err := chosen.Reset(); err != nil {
-		return fmt.Errorf("%w: %w", ErrUSBResetFailed, err)
-	}
-	return nil
+	return fn(chosen, chosenSerial)
 }
 
-// VendorReadyInInterface: IN | vendor | interface = 0x81; pass wIndex = interface number
+// VendorReadyInInterface: IN | vendor | interface = 0x81; pass wIndex = interface number.
+// It is the claiming session's check, so it clears the suspend flag.
 func VendorReadyInInterface(d *gousb.Device, bRequest byte, iface uint16, l *slog.Logger) (bool, error) {
-	n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, bRequest, 0, iface, 8)
+	n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, bRequest, readyClearSuspend, iface, 8)
 	if err != nil {
 		return false, fmt.Errorf("vendor ready (iface): %w", err)
 	}
@@ -173,6 +244,79 @@ func VendorReadyInInterface(d *gousb.Device, bRequest byte, iface uint16, l *slo
 	return buf[6] == 1, nil
 }
 
+// GadgetState is the READY reply. Key counts and uptime stay zero while the
+// app is down and on gadgets before protocol 3.
+type GadgetState struct {
+	Ready         bool
+	Suspended     bool // the host suspended the bus since the signer last checked
+	Keys          int
+	Unlocked      int
+	Locked        int
+	UptimeSeconds uint32
+}
+
+// ReadGadgetState reads the READY reply on iface. It leaves the suspend
+// flag set for the session that claims the device.
+func ReadGadgetState(d *gousb.Device, iface uint16, l *slog.Logger) (GadgetState, error) {
+	n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, VendorReqReady, 0, iface, readyReplySize)
+	if err != nil {
+		return GadgetState{}, fmt.Errorf("vendor ready (iface): %w", err)
+	}
+	if n < 7 || string(buf[:4]) != "TZSG" {
+		return GadgetState{}, fmt.Errorf("bad reply (iface) n=%d", n)
+	}
+	st := GadgetState{Ready: buf[6] == 1}
+	if n >= 8 {
+		st.Suspended = buf[7]&readyFlagSuspended != 0
+	}
+	if n >= readyReplySize {
+		st.Keys = int(binary.LittleEndian.Uint16(buf[8:10]))
+		st.Unlocked = int(binary.LittleEndian.Uint16(buf[10:12]))
+		st.Locked = int(binary.LittleEndian.Uint16(buf[12:14]))
+		st.UptimeSeconds = binary.LittleEndian.Uint32(buf[14:18])
+	}
+	return st, nil
+}
+
+// GadgetInfo is what the gadget reports on EP0 before any bulk traffic.
+// Gadgets that predate the info requests report Protocol 1 and nothing else.
+type GadgetInfo struct {
+	Protocol uint16
+	Version  string
+	Commit   string
+	Serial   string
+}
+
+// ReadGadgetInfo queries the vendor info requests on iface and fails with
+// ErrGadgetIncompatible when the gadget no longer serves this host.
+func ReadGadgetInfo(d *gousb.Device, iface uint16, l *slog.Logger) (GadgetInfo, error) {
+	n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, VendorReqProtocol, 0, iface, 4)
+	if err != nil || n < 4 {
+		// STALLed: registrar from before protocol 2
+		return GadgetInfo{Protocol: 1}, nil
+	}
+	info := GadgetInfo{Protocol: binary.LittleEndian.Uint16(buf[0:2])}
+	if minHost := binary.LittleEndian.Uint16(buf[2:4]); minHost > ProtoVersion {
+		return info, fmt.Errorf("%w: gadget protocol %d needs host protocol %d, have %d", ErrGadgetIncompatible, info.Protocol, minHost, ProtoVersion)
+	}
+	for _, f := range []struct {
+		req byte
+		dst *string
+	}{
+		{VendorReqVersion, &info.Version},
+		{VendorReqCommit, &info.Commit},
+		{VendorReqSerial, &info.Serial},
+	} {
+		n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, f.req, 0, iface, 128)
+		if err != nil {
+			return info, fmt.Errorf("vendor info 0x%02x: %w", f.req, err)
+		}
+		s, _, _ := strings.Cut(string(buf[:n]), "\x00")
+		*f.dst = s
+	}
+	return info, nil
+}
+
 // Connect discovers vendor FFS interfaces, claims the requested channel, and returns ready brokers.
 func Connect(p ConnectParams) (*Session, error) {
 	l := p.Logger
@@ -187,10 +331,14 @@ func Connect(p ConnectParams) (*Session, error) {
 		}
 	}
 
-	if p.Channel != ChanSign && p.Channel != ChanMgmt {
+	if p.Channel != ChanSign && p.Channel != ChanMgmt && p.Channel != ChanAdmin {
 		return nil, ErrInvalidChannel
 	}
 
+	if addr := simulatorAddr(); addr != "" {
+		return connectSimulator(p, l, addr)
+	}
+
 	ctx := gousb.NewContext()
 
 	// Open all matching VID/PID
@@ -199,7 +347,7 @@ func Connect(p ConnectParams) (*Session, error) {
 	})
 	if err != nil {
 		ctx.Close()
-		return nil, err
+		return nil, openError(err)
 	}
 
 	// Close all non-chosen devices; chosen one is transferred to session
@@ -253,58 +401,13 @@ func Connect(p ConnectParams) (*Session, error) {
 	// Some UDCs can wedge if the host selects a config before the FFS userspace
 	// function has opened endpoints. Only touch the configuration once we see
 	// the vendor-class interface advertised in the descriptors.
-	type ifaceEndpoints struct {
-		ifaceNum    int
-		epIn, epOut gousb.EndpointAddress
-	}
-
-	var cfgNum = -1
-	var ifaces []ifaceEndpoints
-
-	for _, cfgDesc := range chosen.Desc.Configs {
-		// collect all vendor-specific interfaces in this config
-		var cand []ifaceEndpoints
-		for _, iface := range cfgDesc.Interfaces {
-			if len(iface.AltSettings) == 0 {
-				continue
-			}
-
-			as := iface.AltSettings[0]
-			if as.Class != gousb.ClassVendorSpec {
-				continue
-			}
-
-			ie := ifaceEndpoints{ifaceNum: int(as.Number)}
-			for _, ed := range as.Endpoints {
-				if ed.TransferType != gousb.TransferTypeBulk {
-					continue
-				}
-				if ed.Direction == gousb.EndpointDirectionIn {
-					ie.epIn = ed.Address
-				}
-				if ed.Direction == gousb.EndpointDirectionOut {
-					ie.epOut = ed.Address
-				}
-			}
-			if ie.epIn != 0 && ie.epOut != 0 {
-				cand = append(cand, ie)
-			}
-
-		}
-		if len(cand) > 0 {
-			cfgNum = int(cfgDesc.Number)
-			ifaces = cand
-			break
-		}
-	}
-	if cfgNum == -1 || len(ifaces) == 0 {
+	cfgNum, ifaces := vendorInterfaces(chosen.Desc)
+	if len(ifaces) == 0 {
 		ctx.Close()
 		_ = chosen.Close()
 		return nil, ErrGadgetNotReady
 	}
 
-	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].ifaceNum < ifaces[j].ifaceNum })
-
 	// Now it’s safe to select the discovered configuration and claim the chosen interface
 	cfg, err := chosen.Config(cfgNum)
 	if err != nil {
@@ -313,6 +416,7 @@ func Connect(p ConnectParams) (*Session, error) {
 		return nil, fmt.Errorf("config(%d): %w", cfgNum, err)
 	}
 
+	var info GadgetInfo
 	openPair := func(ie ifaceEndpoints) (*gousb.Interface, *gousb.InEndpoint, *gousb.OutEndpoint, error) {
 		intf, err := cfg.Interface(ie.ifaceNum, 0)
 		if err != nil {
@@ -327,6 +431,12 @@ func Connect(p ConnectParams) (*Session, error) {
 			}
 			return nil, nil, nil, fmt.Errorf("%w: iface %d", ErrInterfaceNotReady, ie.ifaceNum)
 		}
+		// and that it still talks to this host, before any bulk traffic
+		if info, err = ReadGadgetInfo(chosen, uint16(ie.ifaceNum), l); err != nil {
+			intf.Close()
+			return nil, nil, nil, err
+		}
+		l.Debug("gadget info", slog.Int("protocol", int(info.Protocol)), slog.String("version", info.Version), slog.String("commit", info.Commit))
 
 		outEp, err := intf.OutEndpoint(int(ie.epOut & 0x0f))
 		if err != nil {
@@ -342,9 +452,18 @@ func Connect(p ConnectParams) (*Session, error) {
 		return intf, inEp, outEp, nil
 	}
 
-	// Choose exactly one interface: vendors[0] = IF0 (sign), vendors[1] = IF1 (management).
+	// Choose exactly one interface: vendors[0] = IF0 (sign), vendors[1] = IF1 (management),
+	// vendors[2] = IF2 (admin). Gadgets without IF2 serve admin requests on IF1.
 	pick := ifaces[0]
 
+	if p.Channel == ChanAdmin {
+		if len(ifaces) >= 3 {
+			pick = ifaces[2]
+		} else {
+			l.Debug("no admin interface; falling back to management")
+			p.Channel = ChanMgmt
+		}
+	}
 	if p.Channel == ChanMgmt {
 		if len(ifaces) < 2 {
 			_ = cfg.Close()
@@ -357,7 +476,7 @@ func Connect(p ConnectParams) (*Session, error) {
 
 	l.Debug("claiming interface",
 		slog.Int("iface", pick.ifaceNum),
-		slog.String("channel", map[Channel]string{ChanSign: "sign", ChanMgmt: "mgmt"}[p.Channel]),
+		slog.String("channel", p.Channel.String()),
 	)
 
 	openIntf, inEp, outEp, err := openPair(pick)
@@ -371,7 +490,7 @@ func Connect(p ConnectParams) (*Session, error) {
 
 	// Build broker
 	br := broker.New(inEp, newLibusbWriter(outEp),
-		broker.WithLogger(l.With("component", "broker", "chan", map[Channel]string{ChanSign: "sign", ChanMgmt: "mgmt"}[p.Channel])),
+		broker.WithLogger(l.With("component", "broker", "chan", p.Channel.String())),
 		broker.WithHandler(p.BrokerHandler),
 	)
 
@@ -389,10 +508,73 @@ func Connect(p ConnectParams) (*Session, error) {
 		Channel: p.Channel,
 
 		Serial: chosenSerial,
+		Info:   info,
 		Log:    l,
 	}, nil
 }
 
+// ifaceEndpoints is a vendor-specific (FFS) interface with its bulk pair.
+type ifaceEndpoints struct {
+	ifaceNum    int
+	epIn, epOut gousb.EndpointAddress
+}
+
+// vendorInterfaces finds the first configuration advertising
+// vendor-specific interfaces with a bulk IN/OUT pair, and those
+// interfaces in order; none until the gadget app has opened its
+// endpoints. It reads descriptors only.
+func vendorInterfaces(desc *gousb.DeviceDesc) (int, []ifaceEndpoints) {
+	for _, cfgDesc := range desc.Configs {
+		// collect all vendor-specific interfaces in this config
+		var cand []ifaceEndpoints
+		for _, iface := range cfgDesc.Interfaces {
+			if len(iface.AltSettings) == 0 {
+				continue
+			}
+
+			as := iface.AltSettings[0]
+			if as.Class != gousb.ClassVendorSpec {
+				continue
+			}
+
+			ie := ifaceEndpoints{ifaceNum: int(as.Number)}
+			for _, ed := range as.Endpoints {
+				if ed.TransferType != gousb.TransferTypeBulk {
+					continue
+				}
+				if ed.Direction == gousb.EndpointDirectionIn {
+					ie.epIn = ed.Address
+				}
+				if ed.Direction == gousb.EndpointDirectionOut {
+					ie.epOut = ed.Address
+				}
+			}
+			if ie.epIn != 0 && ie.epOut != 0 {
+				cand = append(cand, ie)
+			}
+
+		}
+		if len(cand) > 0 {
+			sort.Slice(cand, func(i, j int) bool { return cand[i].ifaceNum < cand[j].ifaceNum })
+			return int(cfgDesc.Number), cand
+		}
+	}
+	return -1, nil
+}
+
+// openError explains libusb open failures that have a platform-specific fix.
+// On Windows a device without a usable driver fails to open with NOT_FOUND
+// or NOT_SUPPORTED.
+func openError(err error) error {
+	switch {
+	case errors.Is(err, gousb.ErrorAccess):
+		return fmt.Errorf("%w: %w", ErrUSBAccessDenied, err)
+	case runtime.GOOS == "windows" && (errors.Is(err, gousb.ErrorNotFound) || errors.Is(err, gousb.ErrorNotSupported)):
+		return fmt.Errorf("%w: %w", ErrUSBNoDriver, err)
+	}
+	return err
+}
+
 func interfaceClaimError(ifaceNum int, err error) error {
 	switch ifaceNum {
 	case 0:
@@ -1,15 +1,61 @@
 package common
 
+import "time"
+
 const (
 	VID = 0x9997
 	PID = 0x0001
 
-	VendorReqReady    = 0x5A
-	bmReqTypeVendorIn = 0x81
+	VendorReqReady     = 0x5A
+	VendorReqVersion   = 0x5B
+	VendorReqCommit    = 0x5C
+	VendorReqSerial    = 0x5D
+	VendorReqProtocol  = 0x5E
+	VendorReqReset     = 0x5F // OUT, no data: reopen the gadget endpoints
+	bmReqTypeVendorIn  = 0x81
+	bmReqTypeVendorOut = 0x41
+	readyReplySize     = 18     // protocol 3; older gadgets send 8
+	readyFlagSuspended = 1 << 2 // link flags, byte 7 of the READY reply
+	readyClearSuspend  = 1 << 0 // wValue of READY: the claiming session read the suspend flag
+
+	// ProtoVersion is the EP0 vendor protocol this host speaks; gadgets
+	// report the oldest one they still serve.
+	ProtoVersion = 0x0003
 
 	// error codes from rpc
 	RpcKeyNotFound    uint32 = 31
 	RpcKeyLocked      uint32 = 32
 	RpcStaleWatermark uint32 = 33
 	RpcBadPayload     uint32 = 34
+	RpcRateLimited    uint32 = 35
+
+	RpcNewKeysBadHDIndex uint32 = 40 // hd_index with other than one key_id
+
+	RpcPopFailed uint32 = 100
+
+	RpcTelemetryNoChannel uint32 = 130
+
+	RpcAuditUnavailable uint32 = 140
+	RpcAuditFailed      uint32 = 141
+
+	RpcAttestBadNonce uint32 = 150
+	RpcAttestFailed   uint32 = 151
+	RpcIdentityLocked uint32 = 152 // attest before the first unlock
+
+	RpcAckTamperNoPass    uint32 = 120
+	RpcAckTamperThrottled uint32 = 121
+	RpcAckTamperBadPass   uint32 = 122
+	RpcAckTamperFailed    uint32 = 123
+
+	RpcUnknownRequest  uint32 = 1000 // the gadget predates the request
+	RpcBusy            uint32 = 1002 // no free worker on the gadget in time
+	RpcNotAdminRequest uint32 = 1003 // IF2 serves admin requests only
+	RpcNotConfirmed    uint32 = 1004 // the device button was not pressed
+
+	// ButtonConfirmTimeout is how long the gadget waits for its button
+	// when one is configured; requests that may need it allow for it.
+	ButtonConfirmTimeout = 20 * time.Second
+
+	// DefaultSignTimeout bounds ReqSign when the caller sets no deadline.
+	DefaultSignTimeout = 5 * time.Second
 )
@@ -35,7 +35,13 @@ func logCtrl(l *slog.Logger, tag string, s ctrlSetup, n int, data []byte, err er
 
 func ctrlIn(l *slog.Logger, d *gousb.Device, bm, bReq byte, wValue, wIndex, wLength uint16) (int, []byte, error) {
 	buf := make([]byte, wLength)
-	n, err := d.Control(bm, bReq, wValue, wIndex, buf)
+	n, err := control(d, bm, bReq, wValue, wIndex, buf)
 	logCtrl(l, "CTRL-IN", ctrlSetup{bm, bReq, wValue, wIndex, wLength}, n, buf, err)
 	return n, buf, err
 }
+
+func ctrlOut(l *slog.Logger, d *gousb.Device, bm, bReq byte, wValue, wIndex uint16) error {
+	_, err := control(d, bm, bReq, wValue, wIndex, nil)
+	logCtrl(l, "CTRL-OUT", ctrlSetup{bm, bReq, wValue, wIndex, 0}, 0, nil, err)
+	return err
+}
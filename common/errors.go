@@ -3,7 +3,7 @@ package common
 import "errors"
 
 var (
-	ErrInvalidChannel       = errors.New("Connect: Channel must be ChanSign or ChanMgmt")
+	ErrInvalidChannel       = errors.New("Connect: Channel must be ChanSign, ChanMgmt or ChanAdmin")
 	ErrNoDevices            = errors.New("no devices with VID/PID")
 	ErrDeviceNotFound       = errors.New("device with requested serial not found")
 	ErrUSBResetFailed       = errors.New("usb: reset failed")
@@ -11,7 +11,35 @@ var (
 	ErrNoManagementIface    = errors.New("no management interface present")
 	ErrVendorProbeFailed    = errors.New("vendor probe failed")
 	ErrInterfaceNotReady    = errors.New("interface not ready")
+	ErrGadgetIncompatible   = errors.New("gadget firmware requires a newer host (update tezsign)")
 	ErrInterfaceClaimFailed = errors.New("claim interface failed")
 	ErrSignInterfaceBusy    = errors.New("Unable to connect to sign interface of the device, device is busy")
 	ErrMgmtInterfaceBusy    = errors.New("Unable to connect to management interface of the device, device is busy")
+	ErrUSBAccessDenied      = errors.New("usb: access to the device denied (on Linux install the udev rules with tools/add_udev_rules.sh)")
+	ErrUSBNoDriver          = errors.New("usb: no WinUSB driver bound to the device (update the gadget image, or bind WinUSB with Zadig)")
 )
+
+// Errors the gadget answers with, matched by *RemoteError.Is.
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrKeyLocked      = errors.New("key locked")
+	ErrStaleWatermark = errors.New("stale watermark")
+	ErrBadPayload     = errors.New("bad payload")
+	ErrRateLimited    = errors.New("rate limited")
+	ErrUnknownRequest = errors.New("request not supported by the gadget")
+	ErrBusy           = errors.New("gadget busy")
+	ErrNotConfirmed   = errors.New("not confirmed on the device")
+	ErrIdentityLocked = errors.New("device identity locked until the gadget is unlocked")
+)
+
+var remoteErrors = map[uint32]error{
+	RpcKeyNotFound:    ErrKeyNotFound,
+	RpcKeyLocked:      ErrKeyLocked,
+	RpcStaleWatermark: ErrStaleWatermark,
+	RpcBadPayload:     ErrBadPayload,
+	RpcRateLimited:    ErrRateLimited,
+	RpcUnknownRequest: ErrUnknownRequest,
+	RpcBusy:           ErrBusy,
+	RpcNotConfirmed:   ErrNotConfirmed,
+	RpcIdentityLocked: ErrIdentityLocked,
+}
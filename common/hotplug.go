@@ -0,0 +1,91 @@
+package common
+
+/*
+#cgo pkg-config: libusb-1.0
+#include <stdint.h>
+#include <libusb.h>
+
+extern void tezsignHotplugWake(int id);
+
+static int LIBUSB_CALL tezsign_hotplug_cb(libusb_context *ctx, libusb_device *dev, libusb_hotplug_event event, void *user_data) {
+	tezsignHotplugWake((int)(intptr_t)user_data);
+	return 0; // stay registered
+}
+
+static int tezsign_hotplug_register(libusb_context *ctx, int vid, int pid, int id, libusb_hotplug_callback_handle *handle) {
+	return libusb_hotplug_register_callback(ctx,
+		LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED | LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT, 0,
+		vid, pid, LIBUSB_HOTPLUG_MATCH_ANY,
+		tezsign_hotplug_cb, (void *)(intptr_t)id, handle);
+}
+*/
+import "C"
+
+import "sync"
+
+// gousb has no hotplug API, so WatchDevices gets libusb's callbacks here,
+// on a libusb context of its own. They only wake the watcher, which then
+// rescans; the bus is the source of truth either way.
+
+var (
+	hotplugMu   sync.Mutex
+	hotplugNext int
+	hotplugSubs = map[int]chan struct{}{}
+)
+
+// hotplugEvents signals on events whenever a VID/PID device arrives or
+// leaves, until stop. ok is false where libusb has no hotplug support, as
+// on Windows; the caller then only polls.
+func hotplugEvents() (events <-chan struct{}, stop func(), ok bool) {
+	if C.libusb_has_capability(C.LIBUSB_CAP_HAS_HOTPLUG) == 0 {
+		return nil, nil, false
+	}
+	var ctx *C.libusb_context
+	if C.libusb_init(&ctx) != 0 {
+		return nil, nil, false
+	}
+
+	ch := make(chan struct{}, 1)
+	hotplugMu.Lock()
+	hotplugNext++
+	id := hotplugNext
+	hotplugSubs[id] = ch
+	hotplugMu.Unlock()
+	unsubscribe := func() {
+		hotplugMu.Lock()
+		delete(hotplugSubs, id)
+		hotplugMu.Unlock()
+	}
+
+	var handle C.libusb_hotplug_callback_handle
+	if C.tezsign_hotplug_register(ctx, VID, PID, C.int(id), &handle) != 0 {
+		unsubscribe()
+		C.libusb_exit(ctx)
+		return nil, nil, false
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		// callbacks run from here; the timeout bounds how long stop waits
+		tv := C.struct_timeval{tv_sec: 0, tv_usec: 250000}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			C.libusb_handle_events_timeout_completed(ctx, &tv, nil)
+		}
+	}()
+
+	stop = func() {
+		close(done)
+		<-exited
+		C.libusb_hotplug_deregister_callback(ctx, handle)
+		C.libusb_exit(ctx)
+		unsubscribe()
+	}
+	return ch, stop, true
+}
@@ -0,0 +1,21 @@
+package common
+
+import "C"
+
+// tezsignHotplugWake is called by libusb, via tezsign_hotplug_cb, for the
+// watcher registered as id. It lives apart from hotplug.go because a file
+// that exports to C may not define C functions.
+//
+//export tezsignHotplugWake
+func tezsignHotplugWake(id C.int) {
+	hotplugMu.Lock()
+	ch := hotplugSubs[int(id)]
+	hotplugMu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -28,13 +28,13 @@ func (w *libusbWriter) WriteContext(ctx context.Context, p []byte) (int, error)
 		if len(chunk) > w.packetSize {
 			chunk = chunk[:w.packetSize]
 		}
-		n, err := w.ep.WriteContext(ctx, chunk)
+		n, err := writeTimeout(ctx, w.ep, chunk)
 		if err != nil {
 			return n, err
 		}
 		written += n
 		if written == total {
-			w.ep.WriteContext(ctx, []byte{}) // ZLP
+			writeTimeout(ctx, w.ep, []byte{}) // ZLP
 			return n, nil
 		}
 		p = p[n:]
@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/tez-capital/tezsign/broker"
@@ -11,86 +12,34 @@ import (
 )
 
 func ReqUnlockKeys(b *broker.Broker, keys []string, pass []byte) ([]*signer.PerKeyResult, error) {
-	p := append([]byte(nil), pass...)
-	defer keychain.MemoryWipe(p)
-
-	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_Unlock{
-			Unlock: &signer.UnlockRequest{
-				KeyIds:     keys,
-				Passphrase: p,
-			},
-		},
-	}, 3*time.Second)
-	if err != nil {
-		return nil, err
-	}
-
-	return resp.GetUnlock().GetResults(), nil
+	return NewClient(b).Unlock(context.Background(), keys, pass)
 }
 
 func ReqLockKeys(b *broker.Broker, keys []string) ([]*signer.PerKeyResult, error) {
-	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_Lock{
-			Lock: &signer.LockRequest{
-				KeyIds: keys,
-			},
-		},
-	}, 3*time.Second)
-	if err != nil {
-		return nil, err
-	}
-
-	return resp.GetLock().GetResults(), nil
+	return NewClient(b).Lock(context.Background(), keys)
 }
 
 func ReqStatus(b *broker.Broker) (*signer.StatusResponse, error) {
-	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_Status{
-			Status: &signer.StatusRequest{},
-		},
-	}, 3*time.Second)
-	if err != nil {
-		return nil, err
-	}
-
-	return resp.GetStatus(), nil
+	return NewClient(b).Status(context.Background())
 }
 
 func ReqSign(b *broker.Broker, tz4 string, rawMsg []byte) ([]byte, error) {
-	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_Sign{
-			Sign: &signer.SignRequest{
-				Tz4:     tz4,
-				Message: rawMsg,
-			},
-		},
-	}, 5*time.Second)
-	if err != nil {
-		return nil, err
-	}
-
-	s := resp.GetSign()
+	return ReqSignContext(context.Background(), b, tz4, rawMsg)
+}
 
-	return s.GetSignature(), nil
+// ReqSignContext is ReqSign with a caller context, see Client.Sign.
+func ReqSignContext(ctx context.Context, b *broker.Broker, tz4 string, rawMsg []byte) ([]byte, error) {
+	return NewClient(b).Sign(ctx, tz4, rawMsg)
 }
 
 func ReqNewKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signer.NewKeyPerKeyResult, error) {
-	p := append([]byte(nil), pass...)
-	defer keychain.MemoryWipe(p)
+	return ReqNewKeysWith(b, &signer.NewKeysRequest{KeyIds: keyIDs}, pass)
+}
 
-	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_NewKeys{
-			NewKeys: &signer.NewKeysRequest{
-				KeyIds:     keyIDs,
-				Passphrase: p,
-			},
-		},
-	}, 10*time.Second)
-	if err != nil {
-		return nil, err
-	}
-	return resp.GetNewKey().GetResults(), nil
+// ReqNewKeysWith sends a fully specified NewKeysRequest (HD index,
+// overwrite); the passphrase is set from pass.
+func ReqNewKeysWith(b *broker.Broker, req *signer.NewKeysRequest, pass []byte) ([]*signer.NewKeyPerKeyResult, error) {
+	return NewClient(b).Keygen(context.Background(), req, pass)
 }
 
 func ReqDeleteKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signer.PerKeyResult, error) {
@@ -104,23 +53,41 @@ func ReqDeleteKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signer.Pe
 				Passphrase: p,
 			},
 		},
-	}, 5*time.Second)
+	}, 5*time.Second+ButtonConfirmTimeout)
 	if err != nil {
 		return nil, err
 	}
 	return resp.GetDeleteKeys().GetResults(), nil
 }
 
-func ReqLogs(b *broker.Broker, limit int) ([]string, error) {
+// ReqLogs returns up to limit recent gadget log lines, from its in-memory
+// log when memory is set.
+func ReqLogs(b *broker.Broker, limit int, memory bool) ([]string, error) {
+	return NewClient(b).Logs(context.Background(), limit, memory)
+}
+
+// ReqAudit returns up to limit audit records with seq > after (0 = gadget
+// default page size) and whether more follow.
+func ReqAudit(b *broker.Broker, after uint64, limit int) ([][]byte, bool, error) {
 	resp, err := doReq(b, &signer.Request{
-		Payload: &signer.Request_Logs{
-			Logs: &signer.LogsRequest{Limit: uint32(limit)},
+		Payload: &signer.Request_Audit{
+			Audit: &signer.AuditRequest{AfterSeq: after, Limit: uint32(limit)},
 		},
-	}, 3*time.Second)
+	}, 10*time.Second)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp.GetAudit().GetRecords(), resp.GetAudit().GetMore(), nil
+}
+
+func ReqAuditVerify(b *broker.Broker) (*signer.AuditVerifyResponse, error) {
+	resp, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_AuditVerify{AuditVerify: &signer.AuditVerifyRequest{}},
+	}, 30*time.Second)
 	if err != nil {
 		return nil, err
 	}
-	return resp.GetLogs().GetLines(), nil
+	return resp.GetAuditVerify(), nil
 }
 
 func ReqInitMaster(b *broker.Broker, deterministic bool, pass []byte) (bool, error) {
@@ -134,7 +101,7 @@ func ReqInitMaster(b *broker.Broker, deterministic bool, pass []byte) (bool, err
 				Passphrase:    p,
 			},
 		},
-	}, 5*time.Second)
+	}, 5*time.Second+ButtonConfirmTimeout)
 	if err != nil {
 		return false, err
 	}
@@ -166,13 +133,125 @@ func ReqSetLevel(b *broker.Broker, keyID string, level uint64) (bool, error) {
 	return resp.GetOk().GetOk(), nil
 }
 
-func doReq(b *broker.Broker, req *signer.Request, timeout time.Duration) (*signer.Response, error) {
-	pb, err := proto.Marshal(req)
+func ReqPop(b *broker.Broker, tz4 string) (string, error) {
+	resp, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_Pop{Pop: &signer.PopRequest{Tz4: tz4}},
+	}, 3*time.Second)
+	if err != nil {
+		return "", err
+	}
+	return resp.GetPop().GetPop(), nil
+}
+
+func ReqHealth(b *broker.Broker) (*signer.HealthResponse, error) {
+	return NewClient(b).Health(context.Background())
+}
+
+// ReqAttest sends nonce for the device identity key to sign; check the
+// response with signer.VerifyAttestation.
+func ReqAttest(b *broker.Broker, nonce []byte) (*signer.AttestResponse, error) {
+	resp, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_Attest{Attest: &signer.AttestRequest{Nonce: nonce}},
+	}, 10*time.Second)
 	if err != nil {
 		return nil, err
 	}
+	return resp.GetAttest(), nil
+}
+
+// ReqSetTime pushes t to the gadget clock on the management or admin
+// channel. The gadget refuses times before its own clock unless pass, the
+// master passphrase, is given.
+func ReqSetTime(b *broker.Broker, t time.Time, pass []byte) (*signer.SetTimeResponse, error) {
+	p := append([]byte(nil), pass...)
+	defer keychain.MemoryWipe(p)
+
+	timeout := 3 * time.Second
+	if len(p) > 0 {
+		timeout = 5 * time.Second // the gadget derives the KEK
+	}
+	resp, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_SetTime{SetTime: &signer.SetTimeRequest{UnixNanos: t.UnixNano(), Passphrase: p}},
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetSetTime(), nil
+}
+
+// ReqTelemetry asks the gadget to push signer.Telemetry events on b every
+// interval (0 = gadget default); receive them with b.OnEvent.
+func ReqTelemetry(b *broker.Broker, interval time.Duration) error {
+	_, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_Telemetry{
+			Telemetry: &signer.TelemetryRequest{IntervalSeconds: uint32(interval / time.Second)},
+		},
+	}, 3*time.Second)
+	return err
+}
+
+func ReqAckTamper(b *broker.Broker, pass []byte) error {
+	p := append([]byte(nil), pass...)
+	defer keychain.MemoryWipe(p)
+
+	_, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_AckTamper{
+			AckTamper: &signer.AckTamperRequest{Passphrase: p},
+		},
+	}, 5*time.Second+ButtonConfirmTimeout)
+	return err
+}
+
+func ReqUpdateBegin(b *broker.Broker, size uint64, sum, sig []byte) error {
+	_, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_UpdateBegin{
+			UpdateBegin: &signer.UpdateBeginRequest{
+				Size:      size,
+				Sha256:    sum,
+				Signature: sig,
+			},
+		},
+	}, 5*time.Second)
+	return err
+}
+
+func ReqUpdateChunk(b *broker.Broker, offset uint64, data []byte) error {
+	_, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_UpdateChunk{
+			UpdateChunk: &signer.UpdateChunkRequest{
+				Offset: offset,
+				Data:   data,
+			},
+		},
+	}, 10*time.Second)
+	return err
+}
+
+// ReqUpdateCommit has a long timeout: the gadget may wait for its button,
+// then re-reads and verifies the whole binary and runs it once to learn
+// its version.
+func ReqUpdateCommit(b *broker.Broker) (*signer.UpdateCommitResponse, error) {
+	resp, err := doReq(b, &signer.Request{
+		Payload: &signer.Request_UpdateCommit{UpdateCommit: &signer.UpdateCommitRequest{}},
+	}, 30*time.Second+ButtonConfirmTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetUpdateCommit(), nil
+}
+
+func doReq(b *broker.Broker, req *signer.Request, timeout time.Duration) (*signer.Response, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	return send(ctx, b, req)
+}
+
+// send is one round trip; an error response becomes a *RemoteError.
+func send(ctx context.Context, b *broker.Broker, req *signer.Request) (*signer.Response, error) {
+	pb, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
 	raw, _, err := b.Request(ctx, pb)
 	if err != nil {
 		return nil, err
@@ -195,3 +274,24 @@ type RemoteError struct {
 }
 
 func (e *RemoteError) Error() string { return e.Msg }
+
+// Is matches e to the sentinel of its code, e.g. ErrKeyLocked.
+func (e *RemoteError) Is(target error) bool {
+	sentinel, ok := remoteErrors[e.Code]
+	return ok && sentinel == target
+}
+
+// IsTransient reports whether err came from the transport rather than from
+// the device or the caller's deadline. The request may or may not have
+// reached the device; whether it is safe to send again is for the caller
+// to decide. A sign in particular may have been signed already.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var re *RemoteError
+	if errors.As(err, &re) {
+		return false
+	}
+	return !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled)
+}
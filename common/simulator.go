@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+// EnvSimulator, when set to a unix socket path, makes Connect talk to a
+// simulated gadget on that socket instead of USB. Only builds with the
+// simulator tag read it; release builds always use USB.
+const EnvSimulator = "TEZSIGN_SIMULATOR"
+
+// SimulatorSerial is the serial reported for simulated devices.
+const SimulatorSerial = "simulator"
+
+// ConnTransport adapts a stream connection to the broker's reader/writer.
+type ConnTransport struct {
+	Conn net.Conn
+}
+
+func (t ConnTransport) ReadContext(ctx context.Context, p []byte) (int, error) {
+	stop := context.AfterFunc(ctx, func() { _ = t.Conn.SetReadDeadline(time.Unix(1, 0)) })
+	defer stop()
+	n, err := t.Conn.Read(p)
+	if ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	if errors.Is(err, io.EOF) {
+		// the broker retries EOF (USB endpoints bounce); a closed socket is final
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (t ConnTransport) WriteContext(ctx context.Context, p []byte) (int, error) {
+	stop := context.AfterFunc(ctx, func() { _ = t.Conn.SetWriteDeadline(time.Unix(1, 0)) })
+	defer stop()
+	n, err := t.Conn.Write(p)
+	if ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	return n, err
+}
+
+func connectSimulator(p ConnectParams, l *slog.Logger, addr string) (*Session, error) {
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := ConnTransport{Conn: conn}
+	b := broker.New(t, t, broker.WithLogger(l.With("component", "broker")), broker.WithHandler(p.BrokerHandler))
+	l.Warn("connected to simulated device", slog.String("socket", addr))
+	return &Session{
+		Broker:  b,
+		Channel: p.Channel,
+		Serial:  SimulatorSerial,
+		Log:     l,
+		closer:  conn,
+	}, nil
+}
@@ -0,0 +1,5 @@
+//go:build !simulator
+
+package common
+
+func simulatorAddr() string { return "" }
@@ -0,0 +1,12 @@
+//go:build simulator
+
+package common
+
+import (
+	"os"
+	"strings"
+)
+
+func simulatorAddr() string {
+	return strings.TrimSpace(os.Getenv(EnvSimulator))
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// USBConfig bounds the USB transfers of this package and how often a
+// transfer that timed out is tried again, so a slow hub or a gadget
+// resuming from suspend costs a retry rather than the session. Bulk reads
+// are not bounded: the host waits on them for the gadget to answer.
+type USBConfig struct {
+	ControlTimeout time.Duration // per control request; 0 = none
+	WriteTimeout   time.Duration // per bulk OUT packet; 0 = none
+	Retries        int           // further attempts after a timeout
+	RetryBackoff   time.Duration // before the first retry, doubling after
+}
+
+func DefaultUSBConfig() USBConfig {
+	return USBConfig{
+		ControlTimeout: time.Second,
+		WriteTimeout:   5 * time.Second,
+		Retries:        2,
+		RetryBackoff:   100 * time.Millisecond,
+	}
+}
+
+var (
+	usbCfgMu sync.RWMutex
+	usbCfg   = DefaultUSBConfig()
+)
+
+// SetUSBConfig replaces the USB settings for transfers started after it.
+func SetUSBConfig(c USBConfig) {
+	usbCfgMu.Lock()
+	usbCfg = c
+	usbCfgMu.Unlock()
+}
+
+// CurrentUSBConfig returns the USB settings in use.
+func CurrentUSBConfig() USBConfig {
+	usbCfgMu.RLock()
+	defer usbCfgMu.RUnlock()
+	return usbCfg
+}
+
+// backoff is the wait before retry attempt (1-based).
+func (c USBConfig) backoff(attempt int) time.Duration {
+	return c.RetryBackoff << (attempt - 1)
+}
+
+// retryableControl tells a control request that may pass when repeated
+// from one the gadget refused; a STALL is an answer and never retried.
+func retryableControl(err error) bool {
+	return errors.Is(err, gousb.ErrorTimeout) ||
+		errors.Is(err, gousb.ErrorInterrupted) ||
+		errors.Is(err, gousb.ErrorIO)
+}
+
+// control runs a control request on d under the USB settings.
+func control(d *gousb.Device, bm, bReq byte, wValue, wIndex uint16, data []byte) (int, error) {
+	cfg := CurrentUSBConfig()
+	d.ControlTimeout = cfg.ControlTimeout
+	for attempt := 1; ; attempt++ {
+		n, err := d.Control(bm, bReq, wValue, wIndex, data)
+		if err == nil || attempt > cfg.Retries || !retryableControl(err) {
+			return n, err
+		}
+		time.Sleep(cfg.backoff(attempt))
+	}
+}
+
+// writeTimeout writes one bulk packet, retrying it when it times out;
+// only cancelling ctx ends a write for good.
+func writeTimeout(ctx context.Context, ep *gousb.OutEndpoint, p []byte) (int, error) {
+	cfg := CurrentUSBConfig()
+	if cfg.WriteTimeout <= 0 {
+		return ep.WriteContext(ctx, p)
+	}
+	written := 0
+	for attempt := 1; ; attempt++ {
+		tctx, cancel := context.WithTimeout(ctx, cfg.WriteTimeout)
+		n, err := ep.WriteContext(tctx, p[written:])
+		timedOut := errors.Is(tctx.Err(), context.DeadlineExceeded)
+		cancel()
+		written += n
+		if err == nil || ctx.Err() != nil || !timedOut || attempt > cfg.Retries {
+			return written, err
+		}
+		if written == len(p) && len(p) > 0 {
+			return written, nil
+		}
+		select {
+		case <-time.After(cfg.backoff(attempt)):
+		case <-ctx.Done():
+			return written, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// watchPollEvery is how often WatchDevices rescans the bus. With libusb
+// hotplug support it also rescans as soon as a device comes or goes.
+const watchPollEvery = 2 * time.Second
+
+type DeviceEventKind int
+
+const (
+	DeviceArrived DeviceEventKind = iota
+	DeviceLeft
+)
+
+func (k DeviceEventKind) String() string {
+	switch k {
+	case DeviceArrived:
+		return "arrived"
+	case DeviceLeft:
+		return "left"
+	default:
+		return fmt.Sprintf("DeviceEventKind(%d)", int(k))
+	}
+}
+
+// DeviceEvent is a TezSign device appearing on or leaving the bus. A
+// replugged device comes back at a new address.
+type DeviceEvent struct {
+	Kind    DeviceEventKind
+	Serial  string // empty if the device could not be opened, e.g. for lack of permissions
+	Bus     int
+	Address int
+}
+
+type usbAddr struct{ bus, address int }
+
+// WatchDevices calls fn for every device that arrives or leaves until ctx
+// is done, starting with an arrival for each device already present. fn
+// runs on the watching goroutine; a slow fn delays later events. Under
+// the simulator the simulated device arrives once.
+func WatchDevices(ctx context.Context, fn func(DeviceEvent)) error {
+	if simulatorAddr() != "" {
+		fn(DeviceEvent{Kind: DeviceArrived, Serial: SimulatorSerial})
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	usb := gousb.NewContext()
+	defer usb.Close()
+
+	var wake <-chan struct{}
+	if events, stop, ok := hotplugEvents(); ok {
+		defer stop()
+		wake = events
+	}
+
+	known := map[usbAddr]string{}
+	t := time.NewTicker(watchPollEvery)
+	defer t.Stop()
+	for {
+		scanDevices(usb, known, fn)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		case <-wake:
+		}
+	}
+}
+
+// scanDevices compares the bus with known, the devices seen so far with
+// their serials, reports the difference and updates known. Only new
+// devices are opened, to read their serial.
+func scanDevices(usb *gousb.Context, known map[usbAddr]string, fn func(DeviceEvent)) {
+	present := map[usbAddr]bool{}
+	devs, _ := usb.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if desc.Vendor != gousb.ID(VID) || desc.Product != gousb.ID(PID) {
+			return false
+		}
+		a := usbAddr{desc.Bus, desc.Address}
+		present[a] = true
+		_, seen := known[a]
+		return !seen
+	})
+	serials := map[usbAddr]string{}
+	for _, d := range devs {
+		sn, _ := d.SerialNumber()
+		serials[usbAddr{d.Desc.Bus, d.Desc.Address}] = strings.TrimSpace(sn)
+		_ = d.Close()
+	}
+
+	for a, serial := range known {
+		if !present[a] {
+			delete(known, a)
+			fn(DeviceEvent{Kind: DeviceLeft, Serial: serial, Bus: a.bus, Address: a.address})
+		}
+	}
+	for a := range present {
+		if _, seen := known[a]; seen {
+			continue
+		}
+		// a device that failed to open is reported without its serial
+		known[a] = serials[a]
+		fn(DeviceEvent{Kind: DeviceArrived, Serial: serials[a], Bus: a.bus, Address: a.address})
+	}
+}
@@ -0,0 +1,153 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// CheckResult is how one check went.
+type CheckResult struct {
+	Name  string        `json:"name"`
+	OK    bool          `json:"ok"`
+	Error string        `json:"error,omitempty"`
+	Took  time.Duration `json:"took"`
+}
+
+// Report is the outcome of every registered check.
+type Report struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []CheckResult `json:"checks"`
+}
+
+// Failing are the checks that did not pass.
+func (r Report) Failing() []CheckResult {
+	var out []CheckResult
+	for _, c := range r.Checks {
+		if !c.OK {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Register adds checks to those IsHealthy and Serve run.
+func (m *Monitor) Register(checks ...Check) {
+	m.mu.Lock()
+	m.checks = append(m.checks, checks...)
+	m.mu.Unlock()
+}
+
+func (m *Monitor) registered() []Check {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checks[:len(m.checks):len(m.checks)]
+}
+
+// IsHealthy runs the registered checks in order, each bounded by
+// checkTimeout, and reports on every one of them. A change from the last
+// report fires the OnChange funcs.
+func (m *Monitor) IsHealthy(ctx context.Context) (bool, Report) {
+	checks := m.registered()
+	r := Report{Healthy: true, Checks: make([]CheckResult, 0, len(checks))}
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		start := time.Now()
+		err := c.Check(cctx)
+		cancel()
+		res := CheckResult{Name: c.Name(), OK: err == nil, Took: time.Since(start)}
+		if err != nil {
+			res.Error = err.Error()
+			r.Healthy = false
+		}
+		r.Checks = append(r.Checks, res)
+	}
+	m.noteHealth(r)
+	return r.Healthy, r
+}
+
+// RSSLimit fails once the resident set of this process exceeds limit
+// bytes. It reads /proc, so it only works on Linux.
+func RSSLimit(limit uint64) Check {
+	return CheckFunc("rss", func(context.Context) error {
+		raw, err := os.ReadFile("/proc/self/statm")
+		if err != nil {
+			return err
+		}
+		fields := bytes.Fields(raw)
+		if len(fields) < 2 {
+			return errors.New("unexpected /proc/self/statm")
+		}
+		pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if rss := pages * uint64(os.Getpagesize()); rss > limit {
+			return fmt.Errorf("resident set %d bytes over %d", rss, limit)
+		}
+		return nil
+	})
+}
+
+// OpenFDs fails once this process holds more than limit file descriptors.
+// It reads /proc, so it only works on Linux.
+func OpenFDs(limit int) Check {
+	return CheckFunc("open_fds", func(context.Context) error {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			return err
+		}
+		if n := len(entries); n > limit {
+			return fmt.Errorf("%d open file descriptors, over %d", n, limit)
+		}
+		return nil
+	})
+}
+
+// DiskFree fails once the file system holding path has less than min
+// bytes available, e.g. the data partition that keeps the watermarks.
+func DiskFree(path string, min uint64) Check {
+	return CheckFunc("disk_free", func(context.Context) error {
+		free, err := diskFree(path)
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%d bytes free on %s, under %d", free, path, min)
+		}
+		return nil
+	})
+}
+
+// BrokerAlive fails once the channel done returns is closed, as
+// broker.Broker.Done is when its loops stop, or when there is none, e.g.
+// between device sessions.
+func BrokerAlive(done func() <-chan struct{}) Check {
+	return CheckFunc("broker", func(context.Context) error {
+		ch := done()
+		if ch == nil {
+			return errors.New("no broker")
+		}
+		select {
+		case <-ch:
+			return errors.New("broker stopped")
+		default:
+			return nil
+		}
+	})
+}
+
+// WatermarkLatency fails once the latest watermark write, as latest
+// reports it, took longer than limit.
+func WatermarkLatency(limit time.Duration, latest func() time.Duration) Check {
+	return CheckFunc("watermark_latency", func(context.Context) error {
+		if took := latest(); took > limit {
+			return fmt.Errorf("last watermark write took %v, over %v", took, limit)
+		}
+		return nil
+	})
+}
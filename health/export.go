@@ -0,0 +1,86 @@
+package health
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+// Export is the one call that makes m, and the stats of the brokers that
+// brokers returns, visible to existing tooling: as the expvar named after
+// m's namespace (served at /debug/vars by Handler and by expvar itself on
+// http.DefaultServeMux), and as <namespace>_broker_* series in
+// WriteMetrics for Prometheus. brokers may be nil; it is called on every
+// read, so it can follow brokers that come and go with device sessions.
+//
+// expvar names are global: call Export once per namespace.
+func (m *Monitor) Export(brokers func() []*broker.Broker) {
+	if brokers != nil {
+		m.brokers.Store(&brokers)
+	}
+	expvar.Publish(m.namespace, expvar.Func(func() any {
+		v := struct {
+			Health Snapshot      `json:"health"`
+			Broker *broker.Stats `json:"broker,omitempty"`
+		}{Health: m.Snapshot()}
+		if s, ok := m.brokerStats(); ok {
+			v.Broker = &s
+		}
+		return v
+	}))
+}
+
+// brokerStats sums the stats of the exported brokers; false if none were.
+func (m *Monitor) brokerStats() (broker.Stats, bool) {
+	fn := m.brokers.Load()
+	if fn == nil {
+		return broker.Stats{}, false
+	}
+	var sum broker.Stats
+	for _, b := range (*fn)() {
+		s := b.Stats()
+		sum.RequestsSent += s.RequestsSent
+		sum.RequestsFailed += s.RequestsFailed
+		sum.RequestsHandled += s.RequestsHandled
+		sum.EventsSent += s.EventsSent
+		sum.EventsReceived += s.EventsReceived
+		sum.BytesWritten += s.BytesWritten
+		sum.BytesRead += s.BytesRead
+		sum.WriteRetries += s.WriteRetries
+		sum.ReadRetries += s.ReadRetries
+		sum.Resyncs += s.Resyncs
+		sum.QueueDepth += s.QueueDepth
+	}
+	return sum, true
+}
+
+// writeBrokerMetrics writes the exported brokers' stats. They restart
+// from zero with every new broker, which Prometheus takes as a counter
+// reset.
+func (m *Monitor) writeBrokerMetrics(w io.Writer) {
+	s, ok := m.brokerStats()
+	if !ok {
+		return
+	}
+	for _, c := range []struct {
+		name, typ, help string
+		v               uint64
+	}{
+		{"requests_sent_total", "counter", "Requests sent to the peer.", s.RequestsSent},
+		{"requests_failed_total", "counter", "Requests sent that got no answer.", s.RequestsFailed},
+		{"requests_handled_total", "counter", "Requests from the peer answered.", s.RequestsHandled},
+		{"events_sent_total", "counter", "Events sent to the peer.", s.EventsSent},
+		{"events_received_total", "counter", "Events from the peer.", s.EventsReceived},
+		{"written_bytes_total", "counter", "Bytes written to the transport.", s.BytesWritten},
+		{"read_bytes_total", "counter", "Bytes read from the transport.", s.BytesRead},
+		{"write_retries_total", "counter", "Transport writes retried.", s.WriteRetries},
+		{"read_retries_total", "counter", "Transport reads retried.", s.ReadRetries},
+		{"resyncs_total", "counter", "Bad frames skipped.", s.Resyncs},
+		{"queue_depth", "gauge", "Requests being handled plus frames waiting to be written.", uint64(s.QueueDepth)},
+	} {
+		name := m.namespace + "_broker_" + c.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, c.help, name, c.typ, name, c.v)
+	}
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+// Check is one thing that must hold for a process to count as healthy.
+type Check interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type checkFunc struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (c checkFunc) Name() string                    { return c.name }
+func (c checkFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// CheckFunc makes a Check of fn.
+func CheckFunc(name string, fn func(ctx context.Context) error) Check {
+	return checkFunc{name: name, fn: fn}
+}
+
+// Monitor is the health state of one process, shared by the gadget and
+// the host: whether it is ready to serve, since when it runs, and how its
+// sign requests went. Metric names start with its namespace, e.g.
+// tezsign_host. Safe for concurrent use.
+type Monitor struct {
+	namespace string
+	start     time.Time
+	ready     atomic.Bool
+
+	signLatency Histogram
+	keys        sync.Map // key -> *keyCounters
+
+	mu       sync.Mutex
+	checks   []Check
+	onChange []ChangeFunc
+
+	notifyMu  sync.Mutex // serializes transitions; see noteHealth
+	unhealthy atomic.Bool
+
+	brokers atomic.Pointer[func() []*broker.Broker] // see Export
+}
+
+func NewMonitor(namespace string) *Monitor {
+	return &Monitor{namespace: namespace, start: time.Now()}
+}
+
+// SetReady marks the process ready to serve, e.g. once a device session
+// is up, or not.
+func (m *Monitor) SetReady(ready bool) {
+	m.ready.Store(ready)
+}
+
+func (m *Monitor) Ready() bool {
+	return m.ready.Load()
+}
+
+func (m *Monitor) Uptime() time.Duration {
+	return time.Since(m.start)
+}
+
+// runChecks runs checks in order and joins their failures, each prefixed
+// with the check's name.
+func runChecks(ctx context.Context, checks []Check) error {
+	var errs []error
+	for _, c := range checks {
+		if err := c.Check(ctx); err != nil {
+			errs = append(errs, &CheckError{Name: c.Name(), Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckError is a failed check.
+type CheckError struct {
+	Name string
+	Err  error
+}
+
+func (e *CheckError) Error() string { return e.Name + ": " + e.Err.Error() }
+func (e *CheckError) Unwrap() error { return e.Err }
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Latency buckets grow by √2 from 100µs, so any duration up to about 4.6s
+// is known within 41%; slower ones land in an overflow bucket.
+const (
+	histBase    = 100 * time.Microsecond
+	histBuckets = 32
+)
+
+// histBounds are the bucket upper bounds.
+var histBounds = func() [histBuckets]time.Duration {
+	var b [histBuckets]time.Duration
+	for i := range b {
+		b[i] = time.Duration(float64(histBase) * math.Pow(2, float64(i)/2))
+	}
+	return b
+}()
+
+// Histogram counts durations in fixed exponential buckets. Observe takes
+// no lock, so it is cheap enough for the sign path.
+type Histogram struct {
+	buckets [histBuckets + 1]atomic.Uint64 // the last one overflows
+	count   atomic.Uint64
+	sum     atomic.Int64 // nanoseconds
+	max     atomic.Int64
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(histBuckets, func(i int) bool { return d <= histBounds[i] })
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+	for {
+		cur := h.max.Load()
+		if int64(d) <= cur || h.max.CompareAndSwap(cur, int64(d)) {
+			return
+		}
+	}
+}
+
+// Bucket counts the observations up to UpperBound, as in Prometheus.
+type Bucket struct {
+	UpperBound time.Duration `json:"le"`
+	Count      uint64        `json:"count"`
+}
+
+// HistogramSnapshot is a Histogram at one point in time. Buckets are
+// cumulative and only go up to the slowest observation; Count includes
+// the overflow.
+type HistogramSnapshot struct {
+	Count   uint64        `json:"count"`
+	Sum     time.Duration `json:"sum"`
+	Max     time.Duration `json:"max"`
+	Buckets []Bucket      `json:"buckets,omitempty"`
+}
+
+// Snapshot reads h. Concurrent observations may be counted partly.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: h.count.Load(),
+		Sum:   time.Duration(h.sum.Load()),
+		Max:   time.Duration(h.max.Load()),
+	}
+	var cum uint64
+	for i, bound := range histBounds {
+		if cum >= s.Count && bound > s.Max {
+			break
+		}
+		cum += h.buckets[i].Load()
+		s.Buckets = append(s.Buckets, Bucket{UpperBound: bound, Count: cum})
+	}
+	return s
+}
+
+// Quantile estimates the q-quantile (0..1) as the upper bound of the
+// bucket it falls in; Max when it is in the overflow, 0 without data.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(s.Count)))
+	for _, b := range s.Buckets {
+		if b.Count >= max(rank, 1) {
+			return min(b.UpperBound, s.Max)
+		}
+	}
+	return s.Max
+}
+
+// Mean is Sum/Count, 0 without data.
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
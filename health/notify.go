@@ -0,0 +1,100 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ChangeFunc is called when the process turns healthy or unhealthy, with
+// the checks that failed, none once it recovered.
+type ChangeFunc func(healthy bool, failing []CheckResult)
+
+// OnChange registers fn for every transition IsHealthy sees, Watch's
+// included. A Monitor starts out healthy, so fn is not called until a
+// check first fails.
+func (m *Monitor) OnChange(fn ChangeFunc) {
+	m.mu.Lock()
+	m.onChange = append(m.onChange, fn)
+	m.mu.Unlock()
+}
+
+// noteHealth calls the OnChange funcs if r differs from the last report.
+// The funcs are called in order, one transition at a time.
+func (m *Monitor) noteHealth(r Report) {
+	m.notifyMu.Lock()
+	defer m.notifyMu.Unlock()
+	if r.Healthy != m.unhealthy.Load() {
+		return
+	}
+	m.unhealthy.Store(!r.Healthy)
+	m.mu.Lock()
+	fns := m.onChange[:len(m.onChange):len(m.onChange)]
+	m.mu.Unlock()
+	failing := r.Failing()
+	for _, fn := range fns {
+		fn(r.Healthy, failing)
+	}
+}
+
+// Watch runs IsHealthy every interval until ctx is done, so the OnChange
+// funcs fire without anyone polling /healthz.
+func (m *Monitor) Watch(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		m.IsHealthy(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// webhookTimeout bounds one webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+// Webhook is a ChangeFunc that POSTs each transition to url as JSON,
+// {"healthy":false,"failing":[...]}. It delivers in the background, so a
+// slow endpoint does not hold up the other funcs; failures go to log.
+func Webhook(url string, log *slog.Logger) ChangeFunc {
+	return func(healthy bool, failing []CheckResult) {
+		body, err := json.Marshal(struct {
+			Healthy bool          `json:"healthy"`
+			Failing []CheckResult `json:"failing,omitempty"`
+		}{healthy, failing})
+		if err != nil {
+			log.Error("health webhook", "err", err)
+			return
+		}
+		go func() {
+			if err := postJSON(url, body); err != nil {
+				log.Warn("health webhook", "url", url, "err", err)
+			}
+		}()
+	}
+}
+
+func postJSON(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
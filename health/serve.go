@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds the checks behind one health request.
+const checkTimeout = 5 * time.Second
+
+// Serve answers health requests on addr until the returned server is shut
+// down; addr is host:port, or unix:/path for a socket, which the gadget's
+// sandbox still allows. extraChecks are run after the registered ones.
+//
+//	/healthz     200 while every check passes, else 503 with the failures
+//	/readyz      as /healthz, and 503 until the Monitor is ready
+//	/metrics     the Monitor in the Prometheus text format
+//	/debug/vars  expvar, with the Monitor in it once exported
+func Serve(addr string, m *Monitor, extraChecks ...Check) (*http.Server, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("health: %w", err)
+	}
+	srv := &http.Server{Handler: Handler(m, extraChecks...), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			ln.Close()
+		}
+	}()
+	return srv, nil
+}
+
+// Handler is what Serve serves, to mount on an existing server.
+func Handler(m *Monitor, extraChecks ...Check) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeCheckResult(w, m.check(r.Context(), extraChecks))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !m.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		writeCheckResult(w, m.check(r.Context(), extraChecks))
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteMetrics(r.Context(), w, extraChecks...)
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+func (m *Monitor) check(ctx context.Context, extra []Check) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return runChecks(ctx, append(m.registered(), extra...))
+}
+
+func writeCheckResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// WriteMetrics writes the Monitor, its sign latency and per-key counters
+// included, the exported brokers' stats, and whether each check passes,
+// in the Prometheus text format.
+func (m *Monitor) WriteMetrics(ctx context.Context, w io.Writer, extraChecks ...Check) {
+	metric := func(name, typ, help, v string) {
+		name = m.namespace + "_" + name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, v)
+	}
+	metric("uptime_seconds", "gauge", "Seconds since the process started.", strconv.FormatFloat(m.Uptime().Seconds(), 'f', 0, 64))
+	metric("ready", "gauge", "Whether the process is ready to serve.", boolValue(m.Ready()))
+
+	snap := m.Snapshot()
+	name := m.namespace + "_sign_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time to answer a sign request.\n# TYPE %s histogram\n", name, name)
+	for _, b := range snap.SignLatency.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, seconds(b.UpperBound), b.Count)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", name, snap.SignLatency.Count, name, seconds(snap.SignLatency.Sum), name, snap.SignLatency.Count)
+	keys := slices.Sorted(maps.Keys(snap.Keys))
+	for _, c := range []struct {
+		name, help string
+		v          func(KeyStats) uint64
+	}{
+		{"key_signs_total", "Signatures handed out, by key.", func(k KeyStats) uint64 { return k.Signs }},
+		{"key_sign_rejects_total", "Sign requests refused, by key.", func(k KeyStats) uint64 { return k.Rejects }},
+	} {
+		name := m.namespace + "_" + c.name
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, c.help, name)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{key=%q} %d\n", name, k, c.v(snap.Keys[k]))
+		}
+	}
+
+	m.writeBrokerMetrics(w)
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	name = m.namespace + "_check_ok"
+	fmt.Fprintf(w, "# HELP %s Whether the health check passes.\n# TYPE %s gauge\n", name, name)
+	for _, c := range append(m.registered(), extraChecks...) {
+		fmt.Fprintf(w, "%s{check=%q} %s\n", name, c.Name(), boolValue(c.Check(ctx) == nil))
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+func boolValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type keyCounters struct {
+	signs    atomic.Uint64
+	rejects  atomic.Uint64
+	lastSign atomic.Int64 // unix nanoseconds
+}
+
+// KeyStats are the sign counters of one key.
+type KeyStats struct {
+	Signs    uint64    `json:"signs"`
+	Rejects  uint64    `json:"rejects"`
+	LastSign time.Time `json:"last_sign,omitzero"`
+}
+
+// ObserveSign records a sign request for key that took took; err is why
+// it was refused, or nil if a signature was handed out.
+func (m *Monitor) ObserveSign(key string, took time.Duration, err error) {
+	m.signLatency.Observe(took)
+	c, ok := m.keys.Load(key)
+	if !ok {
+		c, _ = m.keys.LoadOrStore(key, &keyCounters{})
+	}
+	kc := c.(*keyCounters)
+	if err != nil {
+		kc.rejects.Add(1)
+		return
+	}
+	kc.signs.Add(1)
+	kc.lastSign.Store(time.Now().UnixNano())
+}
+
+// Snapshot is what a Monitor knows at one point in time, for the status
+// RPC and the metrics exporters.
+type Snapshot struct {
+	Uptime      time.Duration       `json:"uptime"`
+	Ready       bool                `json:"ready"`
+	SignLatency HistogramSnapshot   `json:"sign_latency"`
+	Keys        map[string]KeyStats `json:"keys,omitempty"`
+}
+
+func (m *Monitor) Snapshot() Snapshot {
+	s := Snapshot{
+		Uptime:      m.Uptime(),
+		Ready:       m.Ready(),
+		SignLatency: m.signLatency.Snapshot(),
+		Keys:        map[string]KeyStats{},
+	}
+	m.keys.Range(func(k, v any) bool {
+		kc := v.(*keyCounters)
+		ks := KeyStats{Signs: kc.signs.Load(), Rejects: kc.rejects.Load()}
+		if ns := kc.lastSign.Load(); ns != 0 {
+			ks.LastSign = time.Unix(0, ns)
+		}
+		s.Keys[k.(string)] = ks
+		return true
+	})
+	return s
+}
@@ -0,0 +1,96 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// EpochCounter is a small monotonic counter kept outside the data
+// partition, e.g. in battery-backed RTC memory or OTP fuses.
+type EpochCounter interface {
+	Load() (uint64, error)
+	// Advance raises the counter to at least to; it never lowers it.
+	Advance(to uint64) error
+}
+
+var errEpochLevels = errors.New("epoch: levels per epoch must be > 0")
+
+// epochGuard keeps a coarse copy of the highest signed level in an
+// EpochCounter: epoch = level / levels.
+type epochGuard struct {
+	mu      sync.Mutex
+	counter EpochCounter
+	levels  uint64
+	current uint64
+}
+
+// SetEpochCounter makes the key ring keep the highest signed level, in
+// steps of levels, in c. On unlock, watermarks below the recorded floor
+// are raised to it, so a data partition restored from an old copy cannot
+// sign below the floor. Call before any key is unlocked. All keys share
+// the counter: they must sign for the same chain.
+func (kr *KeyRing) SetEpochCounter(c EpochCounter, levels uint64) error {
+	if levels == 0 {
+		return errEpochLevels
+	}
+	cur, err := c.Load()
+	if err != nil {
+		return fmt.Errorf("epoch: load: %w", err)
+	}
+	kr.epoch = &epochGuard{counter: c, levels: levels, current: cur}
+	return nil
+}
+
+// EpochFloor is the lowest level keys may sign at per the epoch counter;
+// 0 without one.
+func (kr *KeyRing) EpochFloor() uint64 {
+	g := kr.epoch
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.current > math.MaxUint64/g.levels {
+		return math.MaxUint64
+	}
+	return g.current * g.levels
+}
+
+// noteSignedLevel advances the counter once level enters a new epoch.
+func (kr *KeyRing) noteSignedLevel(level uint64) error {
+	g := kr.epoch
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	epoch := level / g.levels
+	if epoch <= g.current {
+		return nil
+	}
+	if err := g.counter.Advance(epoch); err != nil {
+		return fmt.Errorf("epoch: advance to %d: %w", epoch, err)
+	}
+	g.current = epoch
+	return nil
+}
+
+// applyEpochFloorLocked raises watermarks below the epoch floor so no level
+// under it can be signed. It reports whether any watermark was raised.
+func (k *gKey) applyEpochFloorLocked(floor uint64) bool {
+	if floor == 0 {
+		return false
+	}
+	k.ensureWatermarksLocked()
+	raised := false
+	for _, kind := range signKinds() {
+		if k.watermark[kind].level < floor {
+			// Next allowed: (floor, 0).
+			k.watermark[kind] = HighWatermark{level: floor - 1, round: math.MaxUint32}
+			raised = true
+		}
+	}
+	return raised
+}
@@ -7,4 +7,8 @@ var (
 	ErrKeyNotFound    = errors.New("key not found")
 	ErrStaleWatermark = errors.New("stale level/round")
 	ErrBadPayload     = errors.New("bad sign payload")
+	ErrHDIndexNoSeed  = errors.New("hd index requires deterministic mode")
+	ErrHDIndexUsed    = errors.New("hd index may already be in use")
+	ErrTampered       = errors.New("tamper detected: acknowledge before unlocking")
+	ErrRateLimited    = errors.New("sign rate limit exceeded")
 )
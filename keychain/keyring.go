@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/tez-capital/tezsign/logging"
 	"github.com/tez-capital/tezsign/signer"
@@ -36,7 +37,7 @@ func (hw HighWatermark) ToKeyState() *KindState {
 
 // gKey: device-held key state (per key, per proto kind).
 type gKey struct {
-	mu sync.Mutex // per-key lock
+	mu sync.Mutex // per-key lock; signs for one key run one at a time, other keys in parallel
 
 	// in-memory working material (present only while "unlocked")
 	dek       []byte // 32B per-key data encryption key (wrapped by master on disk)
@@ -48,10 +49,41 @@ type gKey struct {
 	tz4      string
 
 	watermark map[SIGN_KIND]HighWatermark
+	recent    map[SIGN_KIND][]time.Time // signatures within signRateWindow
 
 	stateCorrupted bool
 }
 
+// signRateWindow is the window SetSignRate counts signatures in.
+const signRateWindow = time.Minute
+
+// allowSignLocked reports whether another kind signature fits the limit
+// (signatures per signRateWindow, 0 = unlimited) and forgets older ones.
+func (k *gKey) allowSignLocked(kind SIGN_KIND, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+	cutoff := now.Add(-signRateWindow)
+	recent := k.recent[kind]
+	i := 0
+	for i < len(recent) && !recent[i].After(cutoff) {
+		i++
+	}
+	recent = recent[i:]
+	if k.recent == nil {
+		k.recent = make(map[SIGN_KIND][]time.Time, len(signKinds()))
+	}
+	k.recent[kind] = recent
+	return len(recent) < limit
+}
+
+func (k *gKey) noteSignLocked(kind SIGN_KIND, now time.Time) {
+	if k.recent == nil {
+		k.recent = make(map[SIGN_KIND][]time.Time, len(signKinds()))
+	}
+	k.recent[kind] = append(k.recent[kind], now)
+}
+
 func (k *gKey) ensureWatermarksLocked() {
 	if k.watermark == nil {
 		k.watermark = make(map[SIGN_KIND]HighWatermark, len(signKinds()))
@@ -93,6 +125,15 @@ type KeyRing struct {
 	nextID atomic.Uint64 // atomic counter for auto key ids (key1, key2, ...)
 	log    *slog.Logger
 	store  *FileStore
+
+	persistFailures atomic.Uint64 // failed watermark writes since start
+	persistLast     atomic.Int64  // duration of the latest watermark write
+	persistMax      atomic.Int64  // slowest watermark write since start
+	signs           atomic.Uint64 // successful signatures since start
+	signRejects     atomic.Uint64 // refused sign requests since start
+	signRate        atomic.Int64  // max signatures per key and kind per minute; 0 = unlimited
+	epoch           *epochGuard   // nil without an epoch counter; see SetEpochCounter
+	tampered        atomic.Bool   // mirrors the on-disk tamper latch
 }
 
 func NewKeyRing(log *slog.Logger, store *FileStore) *KeyRing {
@@ -100,15 +141,45 @@ func NewKeyRing(log *slog.Logger, store *FileStore) *KeyRing {
 		log, _ = logging.NewFromEnv()
 	}
 
-	return &KeyRing{log: log, store: store}
+	kr := &KeyRing{log: log, store: store}
+	if store != nil {
+		kr.tampered.Store(store.tampered())
+	}
+	return kr
 }
 
 func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey, tz4 string, err error) {
+	return kr.CreateKeyAt(wanted, masterPassword, NewKeyOptions{})
+}
+
+// NewKeyOptions are the choices CreateKeyAt offers over CreateKey.
+type NewKeyOptions struct {
+	HDIndex uint32 // deterministic mode only; 0 = next free one
+	// ReuseHDIndex allows an HDIndex below the next free one, which may
+	// re-derive a key that exists or existed.
+	ReuseHDIndex bool
+	// Overwrite replaces a key with the same id. The old key is kept
+	// under keys/.retired, not deleted.
+	Overwrite bool
+}
+
+// CreateKeyAt is CreateKey with an explicit HD index and the option to
+// replace an existing key. A key that may share its secret with an older
+// one, being a replacement or at a reused index, starts at the highest
+// watermark on record of any key instead of at zero.
+func (kr *KeyRing) CreateKeyAt(wanted string, masterPassword []byte, opts NewKeyOptions) (id, blPubkey, tz4 string, err error) {
 	id = normalizeID(wanted)
+	hdIndex, overwrite := opts.HDIndex, opts.Overwrite
 
 	if id != "" && !isValidID(id) {
 		return "", "", "", fmt.Errorf("invalid key_id")
 	}
+	if overwrite && id == "" {
+		return "", "", "", fmt.Errorf("overwrite requires a key_id")
+	}
+	if opts.ReuseHDIndex && hdIndex == 0 {
+		return "", "", "", fmt.Errorf("reusing an hd index requires one")
+	}
 
 	// --- Decide deterministic vs random ---
 	enabled, seed, seedErr := kr.store.readSeed(masterPassword)
@@ -118,6 +189,9 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 	}
 
 	useDeterministic := enabled
+	if hdIndex != 0 && !useDeterministic {
+		return "", "", "", ErrHDIndexNoSeed
+	}
 
 	var mf *masterFile
 	if useDeterministic {
@@ -128,7 +202,7 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 	}
 
 	var deterministicIndex uint32
-	var detIndexReserved bool
+	var detIndexReserved, reusedIndex bool
 
 	for {
 		candidate := id
@@ -137,11 +211,15 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 			candidate = fmt.Sprintf("key%d", n)
 		}
 
+		replacing := false
 		if kr.store.hasKey(candidate) {
-			if id != "" {
+			if id == "" {
+				continue
+			}
+			if !overwrite {
 				return "", "", "", ErrKeyExists
 			}
-			continue
+			replacing = true
 		}
 
 		var (
@@ -152,7 +230,12 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 		)
 		if useDeterministic {
 			if !detIndexReserved {
-				deterministicIndex, err = kr.store.nextDeterministicIndex()
+				if hdIndex != 0 {
+					reusedIndex, err = kr.store.reserveDeterministicIndex(hdIndex, opts.ReuseHDIndex)
+					deterministicIndex = hdIndex
+				} else {
+					deterministicIndex, err = kr.store.nextDeterministicIndex()
+				}
 				if err != nil {
 					return "", "", "", err
 				}
@@ -176,12 +259,38 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 			return "", "", "", err
 		}
 
+		if reusedIndex {
+			if other, rErr := kr.resolveKeyIDByTZ4(tz4); rErr == nil && other != candidate {
+				return "", "", "", fmt.Errorf("%w: hd index %d is key %s", ErrKeyExists, index, other)
+			}
+		}
+
+		var state *KeyState
+		if replacing || reusedIndex {
+			if state, err = kr.highestWatermarks(masterPassword); err != nil {
+				return "", "", "", fmt.Errorf("read watermarks on record: %w", err)
+			}
+		}
+		var restore func() error
+		if replacing {
+			kr.forget(candidate)
+			if restore, err = kr.store.retireKey(candidate); err != nil {
+				return "", "", "", fmt.Errorf("retire key %s: %w", candidate, err)
+			}
+			kr.log.Warn(fmt.Sprintf("NEWKEY replacing existing key id=%s", candidate))
+		}
+
 		// persist (runs Argon2id exactly once)
 		func() {
 			skLE := secretKey.ToLEndian()
 			defer MemoryWipe(skLE)
 
-			pErr := kr.store.createKey(candidate, masterPassword, skLE, blPubkey, tz4, popBLsig)
+			pErr := kr.store.createKey(candidate, masterPassword, skLE, blPubkey, tz4, popBLsig, state)
+			if pErr != nil && restore != nil {
+				if rErr := restore(); rErr != nil {
+					pErr = errors.Join(pErr, fmt.Errorf("restore replaced key: %w", rErr))
+				}
+			}
 			if pErr == nil {
 				id = candidate
 				err = nil
@@ -210,12 +319,8 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 		}
 
 		// success: populate in-memory state
-		wm := make(map[SIGN_KIND]HighWatermark, 3)
-		for _, k := range signKinds() {
-			wm[k] = HighWatermark{level: 0, round: 0}
-		}
-
-		newKey := &gKey{blPubkey: blPubkey, tz4: tz4, watermark: wm}
+		newKey := &gKey{blPubkey: blPubkey, tz4: tz4}
+		newKey.applyKeyStateLocked(state)
 		if _, loaded := kr.keys.LoadOrStore(id, newKey); loaded {
 			return "", "", "", ErrKeyExists
 		}
@@ -225,6 +330,10 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 }
 
 func (kr *KeyRing) Unlock(id string, masterPassword []byte) error {
+	if kr.tampered.Load() {
+		return ErrTampered
+	}
+
 	// 1) load materials from disk
 	dek, enc, nonce, blPubkey, tz4, err := kr.store.unlock(id, masterPassword)
 	if err != nil {
@@ -232,7 +341,7 @@ func (kr *KeyRing) Unlock(id string, masterPassword []byte) error {
 	}
 
 	// 2) ensure we have a gKey entry (create empty if missing)
-	v, _ := kr.keys.LoadOrStore(id, &gKey{})
+	v, _ := kr.keys.LoadOrStore(id, &gKey{blPubkey: blPubkey, tz4: tz4})
 	key := v.(*gKey)
 
 	key.mu.Lock()
@@ -259,18 +368,30 @@ func (kr *KeyRing) Unlock(id string, masterPassword []byte) error {
 		ks.ByKind = map[int32]*KindState{}
 	}
 
+	// a tamper event may have fired while the KEK was being derived
+	if kr.tampered.Load() {
+		MemoryWipe(dek)
+		return ErrTampered
+	}
+
 	// 4) attach sensitive material only after successful state read
 	if key.dek != nil {
 		MemoryWipe(key.dek)
 	}
 	key.dek, key.encSecret, key.dataNonce = dek, enc, nonce
-	key.blPubkey, key.tz4 = blPubkey, tz4
+	// getByTz4 scans tz4 without the key lock; only write on change.
+	if key.blPubkey != blPubkey || key.tz4 != tz4 {
+		key.blPubkey, key.tz4 = blPubkey, tz4
+	}
 
 	// 5) ensure watermark map exists and populate from disk (default zeros)
 	key.applyKeyStateLocked(ks)
 	if missing {
 		key.resetWatermarksLocked()
 	}
+	if floor := kr.EpochFloor(); key.applyEpochFloorLocked(floor) && !missing {
+		kr.log.Warn("watermarks below the epoch counter raised; was the data partition restored from an old copy?", "key", id, "floor", floor)
+	}
 
 	kr.log.Info("key unlocked", "key", id)
 
@@ -306,6 +427,12 @@ func (kr *KeyRing) DeleteKey(wanted string) error {
 		return ErrKeyNotFound
 	}
 
+	kr.forget(id)
+	return kr.store.removeKey(id)
+}
+
+// forget drops a key from memory, wiping its unlocked material.
+func (kr *KeyRing) forget(id string) {
 	if v, ok := kr.keys.LoadAndDelete(id); ok {
 		if key, _ := v.(*gKey); key != nil {
 			key.mu.Lock()
@@ -318,8 +445,25 @@ func (kr *KeyRing) DeleteKey(wanted string) error {
 			key.mu.Unlock()
 		}
 	}
+}
 
-	return kr.store.removeKey(id)
+// highestWatermarks is the highest watermark of each kind of any key, as
+// stored or as held by unlocked keys.
+func (kr *KeyRing) highestWatermarks(masterPassword []byte) (*KeyState, error) {
+	ks, err := kr.store.highestKeyState(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	kr.keys.Range(func(_, v any) bool {
+		key := v.(*gKey)
+		key.mu.Lock()
+		if key.watermark != nil {
+			mergeKeyState(ks, key.GetKeyState())
+		}
+		key.mu.Unlock()
+		return true
+	})
+	return ks, nil
 }
 
 func (kr *KeyRing) VerifyMasterPassword(masterPassword []byte) error {
@@ -338,6 +482,7 @@ func (kr *KeyRing) Status() []*signer.KeyStatus {
 		return nil
 	}
 
+	tampered := kr.tampered.Load()
 	out := make([]*signer.KeyStatus, 0, len(ids))
 	for _, id := range ids {
 		ks := &signer.KeyStatus{KeyId: id}
@@ -349,6 +494,9 @@ func (kr *KeyRing) Status() []*signer.KeyStatus {
 		}
 
 		ks.LockState = signer.LockState_LOCKED
+		if tampered {
+			ks.LockState = signer.LockState_TAMPERED
+		}
 		ks.Tz4 = meta.TZ4
 		ks.BlPubkey = meta.BLPubkey
 		ks.Pop = meta.Pop
@@ -407,6 +555,60 @@ func (kr *KeyRing) Status() []*signer.KeyStatus {
 	return out
 }
 
+// ProvePossession returns the stored BLS proof of possession for tz4. Keys
+// stored without one get it computed (the key must be unlocked) and saved.
+func (kr *KeyRing) ProvePossession(tz4 string) (string, error) {
+	id, err := kr.resolveKeyIDByTZ4(tz4)
+	if err != nil {
+		return "", ErrKeyNotFound
+	}
+	meta, err := kr.store.readKeyMeta(id)
+	if err != nil {
+		return "", err
+	}
+	if meta.Pop != "" {
+		return meta.Pop, nil
+	}
+
+	key := kr.get(id)
+	if key == nil {
+		return "", ErrKeyLocked
+	}
+	key.mu.Lock()
+	defer key.mu.Unlock()
+	if key.dek == nil || key.encSecret == nil || key.dataNonce == nil {
+		return "", ErrKeyLocked
+	}
+
+	gcmDEK, err := newAESGCM(key.dek)
+	if err != nil {
+		return "", err
+	}
+	aad := []byte("bl=" + key.blPubkey + "|tz4=" + key.tz4)
+	le, err := gcmDEK.Open(nil, key.dataNonce, key.encSecret, aad)
+	if err != nil {
+		return "", fmt.Errorf("corrupted key (secret)")
+	}
+	defer MemoryWipe(le)
+
+	var sk signer.SecretKey
+	if sk.FromLEndian(le) == nil {
+		return "", fmt.Errorf("invalid scalar")
+	}
+	defer sk.Zeroize()
+
+	pk, _ := signer.PublicKeyFromSecret(&sk)
+	_, pop, err := signer.SignPoPCompressed(&sk, pk)
+	if err != nil {
+		return "", err
+	}
+	if err := kr.store.setKeyPop(id, pop); err != nil {
+		kr.log.Warn("pop: persist", "key", id, "err", err)
+	}
+	kr.log.Info(fmt.Sprintf("POP generated id=%s tz4=%s", id, tz4))
+	return pop, nil
+}
+
 // resolveKeyIDByTZ4 scans the store to find the key id for a given tz4.
 // Works whether the key is locked or unlocked.
 func (kr *KeyRing) resolveKeyIDByTZ4(tz4 string) (string, error) {
@@ -433,6 +635,12 @@ func (kr *KeyRing) resolveKeyIDByTZ4(tz4 string) (string, error) {
 // SignAndUpdate validates key state + monotonic (level, round) and signs.
 // Monotonic rule: (level > lastLevel) OR (level == lastLevel && round > lastRound)
 func (kr *KeyRing) SignAndUpdate(tz4 string, raw []byte) (sig []byte, err error) {
+	defer func() {
+		if err != nil {
+			kr.signRejects.Add(1)
+		}
+	}()
+
 	knd, level, round, signBytes, err := DecodeAndValidateSignPayload(raw)
 	if err != nil {
 		return nil, ErrBadPayload
@@ -456,54 +664,153 @@ func (kr *KeyRing) SignAndUpdate(tz4 string, raw []byte) (sig []byte, err error)
 		return nil, ErrStaleWatermark
 	}
 
+	now := time.Now()
+	if !key.allowSignLocked(knd, int(kr.signRate.Load()), now) {
+		return nil, ErrRateLimited
+	}
+
+	// Raise the watermark before signing. The write to disk overlaps with
+	// the signature but always completes before the key is released, so
+	// the next request for this key sees the persisted state.
+	key.watermark[knd] = HighWatermark{level: level, round: round}
+	state, dek := key.GetKeyState(), key.dek
 	writeChan := make(chan error, 1)
 	go func() {
-		// Update in-memory
+		started := time.Now()
+		err := kr.store.writeKeyState(keyID, dek, tz4, state)
+		kr.notePersist(time.Since(started))
+		writeChan <- err
+	}()
 
-		key.watermark[knd] = HighWatermark{level: level, round: round}
-		// Persist level.bin using DEK
-		if err := kr.store.writeKeyState(keyID, key.dek, key.tz4, key.GetKeyState()); err != nil {
-			writeChan <- fmt.Errorf("persist state: %w", err)
-			return
-		}
+	sig, signErr := key.signLocked(signBytes)
+	if err := <-writeChan; err != nil {
+		kr.persistFailures.Add(1)
+		return nil, fmt.Errorf("persist state: %w", err)
+	}
+	key.stateCorrupted = false
+	if signErr != nil {
+		return nil, signErr
+	}
+	key.noteSignLocked(knd, now)
+	kr.signs.Add(1)
+	if err := kr.noteSignedLevel(level); err != nil {
+		kr.log.Error("epoch counter not advanced", "err", err)
+	}
 
-		key.stateCorrupted = false
-		writeChan <- nil
-	}()
+	return sig, nil
+}
 
-	// decrypt secret (32B LE) using in-memory DEK; authenticate with AAD
+// signLocked decrypts the secret with the in-memory DEK just for this
+// signature. The caller holds key.mu.
+func (key *gKey) signLocked(msg []byte) ([]byte, error) {
 	gcmDEK, err := newAESGCM(key.dek)
 	if err != nil {
 		return nil, err
 	}
 	aad := []byte("bl=" + key.blPubkey + "|tz4=" + key.tz4)
 
+	// decrypt secret (32B LE); authenticate with AAD
 	le, err := gcmDEK.Open(nil, key.dataNonce, key.encSecret, aad)
 	if err != nil {
-		key.mu.Unlock()
 		return nil, fmt.Errorf("corrupted key (secret)")
 	}
+	defer MemoryWipe(le)
 	if len(le) != 32 {
-		MemoryWipe(le)
 		return nil, fmt.Errorf("secret length invalid")
 	}
 
 	// build blst.SecretKey from LE just for this sign
 	var sk signer.SecretKey
 	if sk.FromLEndian(le) == nil {
-		MemoryWipe(le)
 		return nil, fmt.Errorf("invalid scalar")
 	}
+	defer sk.Zeroize()
 
-	sig, _ = signer.SignCompressed(&sk, signBytes)
-	MemoryWipe(le)
-	sk.Zeroize()
-	err = <-writeChan
+	sig, _ := signer.SignCompressed(&sk, msg)
+	return sig, nil
+}
+
+// SetSignRate limits how many signatures of one kind each key may produce
+// per minute; 0 lifts the limit. Requests over the limit fail with
+// ErrRateLimited and do not count.
+func (kr *KeyRing) SetSignRate(perMinute int) {
+	kr.signRate.Store(int64(perMinute))
+}
+
+// PersistFailures counts watermark updates that could not be written to disk
+// (the signature was withheld for each of them).
+func (kr *KeyRing) PersistFailures() uint64 {
+	return kr.persistFailures.Load()
+}
+
+func (kr *KeyRing) notePersist(d time.Duration) {
+	kr.persistLast.Store(int64(d))
+	for {
+		slowest := kr.persistMax.Load()
+		if int64(d) <= slowest || kr.persistMax.CompareAndSwap(slowest, int64(d)) {
+			return
+		}
+	}
+}
+
+// PersistLatency reports how long the latest watermark write (including
+// fsync) took, and the slowest one since start.
+func (kr *KeyRing) PersistLatency() (last, slowest time.Duration) {
+	return time.Duration(kr.persistLast.Load()), time.Duration(kr.persistMax.Load())
+}
+
+// SignRejects counts sign requests refused since start.
+func (kr *KeyRing) SignRejects() uint64 {
+	return kr.signRejects.Load()
+}
+
+// Signs counts signatures handed out since start.
+func (kr *KeyRing) Signs() uint64 {
+	return kr.signs.Load()
+}
+
+// KeyCount reports how many keys are in the store.
+func (kr *KeyRing) KeyCount() (int, error) {
+	ids, err := kr.store.list()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	return len(ids), nil
+}
 
-	return sig, nil
+// UnlockedCount reports how many keys are unlocked in memory.
+func (kr *KeyRing) UnlockedCount() int {
+	n := 0
+	kr.keys.Range(func(_, value any) bool {
+		k, _ := value.(*gKey)
+		k.mu.Lock()
+		if k.dek != nil && k.encSecret != nil && k.dataNonce != nil {
+			n++
+		}
+		k.mu.Unlock()
+		return true
+	})
+	return n
+}
+
+// LockAll wipes every unlocked key from memory and reports how many were
+// unlocked.
+func (kr *KeyRing) LockAll() int {
+	n := 0
+	kr.keys.Range(func(_, value any) bool {
+		key, _ := value.(*gKey)
+		key.mu.Lock()
+		if key.dek != nil {
+			MemoryWipe(key.dek)
+			key.dek = nil
+			n++
+		}
+		key.encSecret = nil
+		key.dataNonce = nil
+		key.mu.Unlock()
+		return true
+	})
+	return n
 }
 
 func (kr *KeyRing) SetLevel(id string, level uint64) error {
@@ -573,6 +880,10 @@ func signKinds() []SIGN_KIND {
 	return []SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION}
 }
 
+func (sk SIGN_KIND) String() string {
+	return signKindName(sk)
+}
+
 func signKindName(sk SIGN_KIND) string {
 	switch sk {
 	case BLOCK:
@@ -0,0 +1,54 @@
+package keychain
+
+import (
+	"fmt"
+	"os"
+)
+
+// StateMirror keeps a second copy of each key's encrypted watermark state
+// on storage that does not share a filesystem with the keystore, e.g. a
+// reserved area of the app partition. Copies are opaque; they carry the
+// same nonce, ciphertext and AAD as level.bin.
+type StateMirror interface {
+	// Load returns the copy stored under name, or nil when there is none.
+	Load(name string) ([]byte, error)
+	Store(name string, b []byte) error
+	Remove(name string) error
+}
+
+// SetStateMirror makes fs write every watermark update to m as well and
+// read the highest valid copy on load. Call before any key is unlocked.
+func (fs *FileStore) SetStateMirror(m StateMirror) {
+	fs.mirror = m
+}
+
+// mirrorName includes tz4 so a key recreated under the same alias never
+// picks up a copy left by its predecessor.
+func mirrorName(id, tz4 string) string {
+	return id + "|" + tz4
+}
+
+func (fs *FileStore) readMirroredKeyState(id string, dek []byte, tz4 string) (*KeyState, bool, error) {
+	b, err := fs.mirror.Load(mirrorName(id, tz4))
+	if err != nil {
+		return nil, false, fmt.Errorf("mirror: %w", err)
+	}
+	if b == nil {
+		return &KeyState{ByKind: map[int32]*KindState{}}, true, nil
+	}
+	return decodeKeyState(b, dek, id, tz4)
+}
+
+func (fs *FileStore) removeMirroredKeyState(id string) error {
+	if fs.mirror == nil {
+		return nil
+	}
+	meta, err := fs.readKeyMeta(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return fs.mirror.Remove(mirrorName(id, meta.TZ4))
+}
@@ -27,6 +27,8 @@ const (
 	keyMetaFileName    = "meta.json"
 	keyBinFileName     = "encrypted.bin"
 	keyStateFileName   = "level.bin"
+	tamperFileName     = "tampered" // present while the tamper latch is set
+	retiredDirName     = ".retired" // under keys; replaced keys, see retireKey
 
 	tmpSuffix = ".tmp"
 )
@@ -40,6 +42,7 @@ var (
 type FileStore struct {
 	base     string
 	masterMu sync.Mutex
+	mirror   StateMirror // nil without a second copy; see SetStateMirror
 }
 
 // ----- on-disk formats -----
@@ -138,6 +141,12 @@ func NewFileStore(base string) (*FileStore, error) {
 	return &FileStore{base: base}, nil
 }
 
+// EnsureDirs recreates the keystore layout, e.g. after a fresh filesystem
+// has been mounted over the base directory.
+func (fs *FileStore) EnsureDirs() error {
+	return mkDirs(fs.base)
+}
+
 // ----- per-key paths -----
 
 func (fs *FileStore) keysRoot() string {
@@ -205,6 +214,37 @@ func (fs *FileStore) nextDeterministicIndex() (uint32, error) {
 	return uint32(idx), nil
 }
 
+// reserveDeterministicIndex moves the next auto index past idx so an
+// explicitly chosen index is never handed out again. An index below the
+// next one may have been used already, so it fails with ErrHDIndexUsed
+// unless reuse is set, and reports reused.
+func (fs *FileStore) reserveDeterministicIndex(idx uint32, reuse bool) (reused bool, err error) {
+	fs.masterMu.Lock()
+	defer fs.masterMu.Unlock()
+
+	masterPath := filepath.Join(fs.base, masterFileName)
+	var mf masterFile
+	if err := readJSON(masterPath, &mf); err != nil {
+		return false, err
+	}
+	if mf.NextDeterministicIndex == 0 {
+		ids, err := fs.list()
+		if err != nil {
+			return false, err
+		}
+		mf.NextDeterministicIndex = uint64(len(ids)) + 1
+	}
+	if uint64(idx) < mf.NextDeterministicIndex {
+		if !reuse {
+			return false, fmt.Errorf("%w: %d (next free is %d)", ErrHDIndexUsed, idx, mf.NextDeterministicIndex)
+		}
+		return true, nil
+	}
+	mf.NextDeterministicIndex = uint64(idx) + 1
+
+	return false, writeJSONAtomic(masterPath, &mf, 0o600)
+}
+
 // InitInfo returns (master.json present, deterministic flag)
 func (fs *FileStore) InitInfo() (masterPresent, deterministic bool, err error) {
 	masterPath := filepath.Join(fs.base, masterFileName)
@@ -295,7 +335,9 @@ func (fs *FileStore) list() ([]string, error) {
 	return ids, nil
 }
 
-func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte, blPubkey, tz4, pop string) error {
+// createKey stores a new key; with a non-nil state its watermarks start
+// there instead of at zero.
+func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte, blPubkey, tz4, pop string, state *KeyState) error {
 	if id == "" {
 		return errors.New("id required")
 	}
@@ -355,7 +397,12 @@ func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte,
 		EncSecret:  encSecret,
 	}
 
-	// write files
+	// write files; the state first, as the key exists once meta.json does
+	if state != nil {
+		if err := fs.writeKeyState(id, dek, tz4, state); err != nil {
+			return err
+		}
+	}
 	if err := writeJSONAtomic(metaPath, &meta, 0o600); err != nil {
 		return err
 	}
@@ -363,45 +410,109 @@ func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte,
 	return writeBytesAtomic(binPath, encodeBundle(bundle), 0o600)
 }
 
+// retireKey moves a key out of the way of a replacement, keeping its
+// material and watermarks under keys/.retired. It returns a func that
+// moves it back, for when the replacement fails.
+func (fs *FileStore) retireKey(id string) (restore func() error, err error) {
+	dir := filepath.Join(fs.keysRoot(), retiredDirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	to := filepath.Join(dir, fmt.Sprintf("%s.%d", id, time.Now().UnixNano()))
+	if err := os.Rename(fs.keyDir(id), to); err != nil {
+		return nil, err
+	}
+	return func() error { return os.Rename(to, fs.keyDir(id)) }, nil
+}
+
+// highestKeyState merges the watermarks of every stored key, for a new key
+// that may share its secret with one of them. A state that cannot be read
+// fails it rather than let the new key start too low.
+func (fs *FileStore) highestKeyState(masterPassword []byte) (*KeyState, error) {
+	kek, _, err := fs.deriveKEK(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer MemoryWipe(kek)
+
+	ids, err := fs.list()
+	if err != nil {
+		return nil, err
+	}
+	out := &KeyState{ByKind: map[int32]*KindState{}}
+	for _, id := range ids {
+		dek, meta, _, err := fs.openKey(id, kek)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		ks, _, _, err := fs.readKeyState(id, dek, meta.TZ4)
+		MemoryWipe(dek)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		mergeKeyState(out, ks)
+	}
+	return out, nil
+}
+
+// setKeyPop stores a proof of possession for a key created without one.
+func (fs *FileStore) setKeyPop(id, pop string) error {
+	m, err := fs.readKeyMeta(id)
+	if err != nil {
+		return err
+	}
+	m.Pop = pop
+	return writeJSONAtomic(fs.keyMetaPath(id), &m, 0o600)
+}
+
 func (fs *FileStore) removeKey(id string) error {
 	if id == "" {
 		return fmt.Errorf("refusing to remove empty key id")
 	}
+	if err := fs.removeMirroredKeyState(id); err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
 	return os.RemoveAll(fs.keyDir(id))
 }
 
 func (fs *FileStore) unlock(id string, masterPassword []byte) (dek []byte, encSecret, dataNonce []byte, blPubkey, tz4 string, err error) {
-	var meta keyMeta
-	metaPath := fs.keyMetaPath(id)
-	binPath := fs.keyBinPath(id)
-	if err = readJSON(metaPath, &meta); err != nil {
-		return nil, nil, nil, "", "", err
-	}
-	raw, err := os.ReadFile(binPath)
+	kek, _, err := fs.deriveKEK(masterPassword)
 	if err != nil {
 		return nil, nil, nil, "", "", err
 	}
-	bundle, err := decodeBundle(raw)
+	defer MemoryWipe(kek)
+
+	dek, meta, bundle, err := fs.openKey(id, kek)
 	if err != nil {
 		return nil, nil, nil, "", "", err
 	}
+	return dek, bundle.EncSecret, meta.DataNonce, meta.BLPubkey, meta.TZ4, nil
+}
 
-	kek, _, err := fs.deriveKEK(masterPassword)
+// openKey unwraps a key's DEK with kek.
+func (fs *FileStore) openKey(id string, kek []byte) ([]byte, keyMeta, keyBundle, error) {
+	var meta keyMeta
+	if err := readJSON(fs.keyMetaPath(id), &meta); err != nil {
+		return nil, keyMeta{}, keyBundle{}, err
+	}
+	raw, err := os.ReadFile(fs.keyBinPath(id))
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return nil, keyMeta{}, keyBundle{}, err
+	}
+	bundle, err := decodeBundle(raw)
+	if err != nil {
+		return nil, keyMeta{}, keyBundle{}, err
 	}
-	defer MemoryWipe(kek)
 
 	gcmKEK, err := newAESGCM(kek)
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return nil, keyMeta{}, keyBundle{}, err
 	}
-	dek, err = gcmKEK.Open(nil, meta.WrapNonce, bundle.WrappedDEK, []byte("id="+id+"|tz4="+meta.TZ4))
+	dek, err := gcmKEK.Open(nil, meta.WrapNonce, bundle.WrappedDEK, []byte("id="+id+"|tz4="+meta.TZ4))
 	if err != nil {
-		return nil, nil, nil, "", "", fmt.Errorf("bad password or corrupted key (unwrap)")
+		return nil, keyMeta{}, keyBundle{}, fmt.Errorf("bad password or corrupted key (unwrap)")
 	}
-
-	return dek, bundle.EncSecret, meta.DataNonce, meta.BLPubkey, meta.TZ4, nil
+	return dek, meta, bundle, nil
 }
 
 func (fs *FileStore) readKeyMeta(id string) (keyMeta, error) {
@@ -502,6 +613,48 @@ func (fs *FileStore) WriteSeed(masterPassword []byte, enabled bool) error {
 	return writeBytesAtomic(path, out, 0o600)
 }
 
+// SealWithMaster encrypts data under the KEK of masterPassword, bound to
+// master.json and label the way seed.bin is, for secrets kept outside the
+// keystore.
+func (fs *FileStore) SealWithMaster(masterPassword []byte, label string, data []byte) ([]byte, error) {
+	gcm, aad, err := fs.masterAEAD(masterPassword, label)
+	if err != nil {
+		return nil, err
+	}
+	nonce := randBytes(12)
+	return gcm.Seal(nonce, nonce, data, aad), nil
+}
+
+// OpenWithMaster reverses SealWithMaster.
+func (fs *FileStore) OpenWithMaster(masterPassword []byte, label string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 12+16 {
+		return nil, fmt.Errorf("sealed %s too short", label)
+	}
+	gcm, aad, err := fs.masterAEAD(masterPassword, label)
+	if err != nil {
+		return nil, err
+	}
+	data, err := gcm.Open(nil, sealed[:12], sealed[12:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%s corrupted or bad password", label)
+	}
+	return data, nil
+}
+
+func (fs *FileStore) masterAEAD(masterPassword []byte, label string) (cipher.AEAD, []byte, error) {
+	kek, mf, err := fs.deriveKEK(masterPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer MemoryWipe(kek)
+	gcm, err := newAESGCM(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	aad := append([]byte{byte(mf.Version)}, mf.Salt...)
+	return gcm, append(aad, label...), nil
+}
+
 // readSeed loads seed.bin and returns (enabled, seed32).
 func (fs *FileStore) readSeed(masterPassword []byte) (bool, []byte, error) {
 	path := filepath.Join(fs.base, seedFileName)
@@ -554,6 +707,10 @@ func readKeyStateFile(path string, dek []byte, id, tz4 string) (*KeyState, bool,
 		}
 		return nil, false, err
 	}
+	return decodeKeyState(b, dek, id, tz4)
+}
+
+func decodeKeyState(b, dek []byte, id, tz4 string) (*KeyState, bool, error) {
 	if len(b) < 12+16 {
 		return nil, false, fmt.Errorf("%w: file too short", ErrKeyStateCorrupted)
 	}
@@ -581,7 +738,9 @@ func readKeyStateFile(path string, dek []byte, id, tz4 string) (*KeyState, bool,
 }
 
 // readKeyState loads level.bin with DEK. If missing, returns zero-initialized state.
-// It also reports whether any of the backing files failed integrity checks.
+// With a state mirror its copy is read as well; the highest watermark of any
+// valid copy wins. It also reports whether any of the backing files failed
+// integrity checks.
 func (fs *FileStore) readKeyState(id string, dek []byte, tz4 string) (*KeyState, bool, bool, error) {
 	if len(dek) != 32 {
 		return nil, false, false, fmt.Errorf("invalid DEK (len=%d)", len(dek))
@@ -589,28 +748,49 @@ func (fs *FileStore) readKeyState(id string, dek []byte, tz4 string) (*KeyState,
 	path := fs.keyStatePath(id)
 
 	backupPath := path + tmpSuffix
-	keyState, missing, err := readKeyStateFile(path, dek, id, tz4)
-	backupKeyState, backupMissing, backupErr := readKeyStateFile(backupPath, dek, id, tz4)
-
-	missingAll := missing && backupMissing
-	corrupted := errors.Is(err, ErrKeyStateCorrupted) || errors.Is(backupErr, ErrKeyStateCorrupted)
-
-	switch {
-	case err == nil && backupErr == nil:
-		for k, v := range backupKeyState.ByKind {
-			existing, ok := keyState.ByKind[k]
-			if !ok || v.GetLevel() > existing.GetLevel() {
-				keyState.ByKind[k] = v
+	reads := []func() (*KeyState, bool, error){
+		func() (*KeyState, bool, error) { return readKeyStateFile(path, dek, id, tz4) },
+		func() (*KeyState, bool, error) { return readKeyStateFile(backupPath, dek, id, tz4) },
+	}
+	if fs.mirror != nil {
+		reads = append(reads, func() (*KeyState, bool, error) { return fs.readMirroredKeyState(id, dek, tz4) })
+	}
+
+	var keyState *KeyState
+	var firstErr error
+	missingAll, corrupted := true, false
+	for _, read := range reads {
+		ks, missing, err := read()
+		missingAll = missingAll && missing
+		if errors.Is(err, ErrKeyStateCorrupted) {
+			corrupted = true
+		}
+		switch {
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
 			}
+		case keyState == nil:
+			keyState = ks
+		default:
+			mergeKeyState(keyState, ks)
+		}
+	}
+	if keyState == nil {
+		// every copy failed with hard errors (not handled as "missing")
+		return nil, missingAll, corrupted, firstErr
+	}
+	return keyState, missingAll, corrupted, nil
+}
+
+// mergeKeyState raises each kind in dst to src where src is ahead.
+func mergeKeyState(dst, src *KeyState) {
+	for k, v := range src.ByKind {
+		existing, ok := dst.ByKind[k]
+		if !ok || v.GetLevel() > existing.GetLevel() ||
+			(v.GetLevel() == existing.GetLevel() && v.GetRound() > existing.GetRound()) {
+			dst.ByKind[k] = v
 		}
-		return keyState, missingAll, corrupted, nil
-	case err == nil:
-		return keyState, missingAll, corrupted, nil
-	case backupErr == nil:
-		return backupKeyState, missingAll, corrupted, nil
-	default:
-		// both files failed with hard errors (not handled as "missing")
-		return nil, missingAll, corrupted, err
 	}
 }
 
@@ -634,6 +814,13 @@ func (fs *FileStore) writeKeyState(id string, dek []byte, tz4 string, ks *KeySta
 	copy(out[:12], nonce)
 	copy(out[12:], ct)
 
-	err = writeBytesAtomic(path, out, 0o600)
-	return err
+	if err := writeBytesAtomic(path, out, 0o600); err != nil {
+		return err
+	}
+	if fs.mirror != nil {
+		if err := fs.mirror.Store(mirrorName(id, tz4), out); err != nil {
+			return fmt.Errorf("mirror: %w", err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,67 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ----- tamper latch -----
+//
+// The latch is a small file in the keystore so it survives a reboot or an
+// app restart: pulling power after opening the case must not re-arm the
+// device. While it is set no key can be unlocked.
+
+func (fs *FileStore) tamperPath() string {
+	return filepath.Join(fs.base, tamperFileName)
+}
+
+func (fs *FileStore) tampered() bool {
+	_, err := os.Stat(fs.tamperPath())
+	return err == nil
+}
+
+func (fs *FileStore) setTampered(reason string) error {
+	line := fmt.Sprintf("%s %s\n", time.Now().UTC().Format(time.RFC3339), reason)
+	return writeBytesAtomic(fs.tamperPath(), []byte(line), 0o600)
+}
+
+func (fs *FileStore) clearTampered() error {
+	if err := os.Remove(fs.tamperPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Tamper wipes every decrypted key from memory and latches the TAMPERED
+// state until ClearTamper. The in-memory latch is set even if persisting it
+// fails; that error is returned so the caller can report it.
+func (kr *KeyRing) Tamper(reason string) error {
+	kr.tampered.Store(true)
+
+	kr.LockAll()
+
+	kr.log.Error("tamper detected; all keys locked", "reason", reason)
+
+	return kr.store.setTampered(reason)
+}
+
+// Tampered reports whether the tamper latch is set.
+func (kr *KeyRing) Tampered() bool {
+	return kr.tampered.Load()
+}
+
+// ClearTamper releases the tamper latch. Callers must have checked the
+// master password first (see VerifyMasterPassword).
+func (kr *KeyRing) ClearTamper() error {
+	if err := kr.store.clearTampered(); err != nil {
+		return err
+	}
+	kr.tampered.Store(false)
+
+	kr.log.Warn("tamper acknowledged; unlocking allowed again")
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalSocket is where journald takes entries in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// maxJournalMessage keeps an entry within one datagram.
+const maxJournalMessage = 64 << 10
+
+// JournalHandler writes records to journald with their attributes as
+// journal fields (KEY=value, keys upper-cased, groups joined with _), so
+// `journalctl KEY=value` filters on them. Levels map to syslog
+// priorities.
+type JournalHandler struct {
+	conn       *net.UnixConn
+	level      slog.Leveler
+	addSource  bool
+	identifier string
+	prefix     string // of attribute keys, from groups
+	fields     []byte // encoded attributes from WithAttrs
+}
+
+// NewJournalHandler connects to journald. Entries carry identifier as
+// SYSLOG_IDENTIFIER, the program name when empty.
+func NewJournalHandler(identifier string, opts *slog.HandlerOptions) (*JournalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: %w", err)
+	}
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	h := &JournalHandler{conn: conn, level: slog.LevelInfo, identifier: identifier}
+	if opts != nil {
+		if opts.Level != nil {
+			h.level = opts.Level
+		}
+		h.addSource = opts.AddSource
+	}
+	return h, nil
+}
+
+func (h *JournalHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h *JournalHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	msg := r.Message
+	if len(msg) > maxJournalMessage {
+		msg = msg[:maxJournalMessage]
+	}
+	writeJournalField(&b, "MESSAGE", msg)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(journalPriority(r.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", h.identifier)
+	if h.addSource && r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		writeJournalField(&b, "CODE_FILE", f.File)
+		writeJournalField(&b, "CODE_LINE", strconv.Itoa(f.Line))
+		writeJournalField(&b, "CODE_FUNC", f.Function)
+	}
+	b.Write(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		appendJournalAttr(&b, h.prefix, a)
+		return true
+	})
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b bytes.Buffer
+	b.Write(h.fields)
+	for _, a := range attrs {
+		appendJournalAttr(&b, h.prefix, a)
+	}
+	out := *h
+	out.fields = b.Bytes()
+	return &out
+}
+
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.prefix = h.prefix + journalFieldName(name) + "_"
+	return &out
+}
+
+// journalPriority maps a level to a syslog priority.
+func journalPriority(lvl slog.Level) int {
+	switch {
+	case lvl >= slog.LevelError:
+		return 3 // err
+	case lvl >= slog.LevelWarn:
+		return 4 // warning
+	case lvl >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+func appendJournalAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += journalFieldName(a.Key) + "_"
+		}
+		for _, ga := range v.Group() {
+			appendJournalAttr(b, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	s := v.String()
+	if v.Kind() == slog.KindTime {
+		s = v.Time().Format(time.RFC3339Nano)
+	}
+	writeJournalField(b, prefix+journalFieldName(a.Key), s)
+}
+
+// journalFieldName upper-cases key into what journald accepts: A-Z, 0-9
+// and _, not starting with _ or a digit, at most 64 bytes.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(name), "_")
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "F_" + s
+	}
+	return s[:min(len(s), 64)]
+}
+
+// writeJournalField encodes one field; values with a newline use the
+// length-prefixed form.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package logging
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// JournalStderr reports whether stderr is connected to journald, as it is
+// for a service started by systemd with the default StandardError=.
+func JournalStderr() bool {
+	dev, ino, ok := strings.Cut(os.Getenv("JOURNAL_STREAM"), ":")
+	if !ok {
+		return false
+	}
+	st, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	return ok && strconv.FormatUint(uint64(sys.Dev), 10) == dev && strconv.FormatUint(sys.Ino, 10) == ino
+}
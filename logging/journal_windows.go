@@ -0,0 +1,4 @@
+package logging
+
+// JournalStderr is always false: there is no journald on Windows.
+func JournalStderr() bool { return false }
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// ComponentKey is the attribute that names the part of the program a
+// logger belongs to, e.g. l.With(ComponentKey, "broker").
+const ComponentKey = "component"
+
+// Levels are per-component log levels. A logger With'd a component logs
+// at that component's level when one is set and at the default level
+// otherwise, so broker debug logging can be turned on alone. Safe for
+// concurrent use; changes apply to loggers already handed out.
+type Levels struct {
+	def slog.Leveler
+
+	mu sync.RWMutex
+	m  map[string]slog.Level
+}
+
+func NewLevels(def slog.Leveler) *Levels {
+	return &Levels{def: def}
+}
+
+// Level is the level of component.
+func (l *Levels) Level(component string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if lvl, ok := l.m[component]; ok {
+		return lvl
+	}
+	return l.def.Level()
+}
+
+// Set replaces the component levels with spec, e.g.
+// "broker=debug,keychain=warn"; "" clears them. On error nothing changes.
+func (l *Levels) Set(spec string) error {
+	m := map[string]slog.Level{}
+	var errs []error
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(item, "=")
+		lvl, known := ParseLevel(level)
+		if !ok || strings.TrimSpace(component) == "" || !known {
+			errs = append(errs, fmt.Errorf("invalid component level %q", item))
+			continue
+		}
+		m[strings.TrimSpace(component)] = lvl
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	l.mu.Lock()
+	l.m = m
+	l.mu.Unlock()
+	return nil
+}
+
+// min is the lowest level of any component, for the handlers underneath.
+func (l *Levels) min() slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	lvl := l.def.Level()
+	for _, c := range l.m {
+		lvl = min(lvl, c)
+	}
+	return lvl
+}
+
+// minLeveler lets the wrapped handlers pass everything some component
+// logs; componentHandler does the filtering.
+type minLeveler struct{ levels *Levels }
+
+func (m minLeveler) Level() slog.Level { return m.levels.min() }
+
+// componentHandler filters records by the level of the component its
+// logger was With'd.
+type componentHandler struct {
+	inner     slog.Handler
+	levels    *Levels
+	component string
+}
+
+func (h componentHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.levels.Level(h.component) && h.inner.Enabled(ctx, lvl)
+}
+
+func (h componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			h.component = a.Value.String()
+		}
+	}
+	h.inner = h.inner.WithAttrs(attrs)
+	return h
+}
+
+func (h componentHandler) WithGroup(name string) slog.Handler {
+	h.inner = h.inner.WithGroup(name)
+	return h
+}
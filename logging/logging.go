@@ -3,6 +3,7 @@ package logging
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -10,17 +11,37 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ----------------- Config -----------------
 
+// NoFile as LOG_FILE turns file logging off, e.g. to spare an SD card.
+const NoFile = "none"
+
 type Config struct {
 	Level        slog.Level // default: Info
 	Format       string     // "text" or "json" (default "text")
-	File         string     // path to log file; empty = no file
+	File         string     // path to log file; empty or NoFile = no file
+	RingLines    int        // lines kept in memory for CurrentRing; 0 = none
 	AlsoStderr   bool       // default true
+	Journal      bool       // send to journald instead of stderr
+	Ship         string     // collector URL to ship records to, see Shipper
 	MaxSizeMB    int        // default 50
 	SetAsDefault bool       // set slog.SetDefault
+
+	// Rotation of File, see RotatingWriter.
+	MaxBackups int           // default 3
+	MaxAge     time.Duration // default 14 days; 0 = no limit
+	Compress   bool          // default true
+
+	// Leveler overrides Level when set, e.g. a *slog.LevelVar to change the
+	// level at runtime.
+	Leveler slog.Leveler
+	// ComponentLevels are per-component levels as Levels.Set takes them.
+	// They go to Levels when set, so they can be changed at runtime.
+	ComponentLevels string
+	Levels          *Levels
 }
 
 func DefaultConfig() Config {
@@ -29,6 +50,9 @@ func DefaultConfig() Config {
 		Format:     "text",
 		AlsoStderr: true,
 		MaxSizeMB:  50,
+		MaxBackups: 3,
+		MaxAge:     14 * 24 * time.Hour,
+		Compress:   true,
 	}
 }
 
@@ -37,15 +61,8 @@ func NewConfigFromEnv() Config {
 	cfg := DefaultConfig()
 
 	// Level
-	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
-	case "all":
-		cfg.Level = slog.Level(-100)
-	case "debug":
-		cfg.Level = slog.LevelDebug
-	case "warn", "warning":
-		cfg.Level = slog.LevelWarn
-	case "error":
-		cfg.Level = slog.LevelError
+	if lvl, ok := ParseLevel(os.Getenv("LOG_LEVEL")); ok {
+		cfg.Level = lvl
 	}
 
 	// Format
@@ -57,13 +74,40 @@ func NewConfigFromEnv() Config {
 	}
 
 	cfg.File = strings.TrimSpace(os.Getenv("LOG_FILE"))
+	cfg.RingLines = envInt(os.Getenv("LOG_RING_LINES"), 0)
+	cfg.ComponentLevels = os.Getenv("LOG_LEVELS")
 	cfg.AlsoStderr = envBool(os.Getenv("LOG_STDERR"), true)
+	// by default only where stderr already ends up in the journal
+	cfg.Journal = envBool(os.Getenv("LOG_JOURNAL"), JournalStderr())
+	cfg.Ship = strings.TrimSpace(os.Getenv("LOG_SHIP"))
 	cfg.MaxSizeMB = envInt(os.Getenv("LOG_MAX_SIZE_MB"), 5)
+	cfg.MaxBackups = envInt(os.Getenv("LOG_MAX_BACKUPS"), cfg.MaxBackups)
+	cfg.MaxAge = time.Duration(envInt(os.Getenv("LOG_MAX_AGE_DAYS"), int(cfg.MaxAge/(24*time.Hour)))) * 24 * time.Hour
+	cfg.Compress = envBool(os.Getenv("LOG_COMPRESS"), cfg.Compress)
 
 	cfg.SetAsDefault = true
 	return cfg
 }
 
+// ParseLevel maps all/debug/info/warn/error to a level; ok is false for
+// anything else.
+func ParseLevel(s string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "all":
+		return slog.Level(-100), true
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return 0, false
+	}
+}
+
 func envBool(s string, def bool) bool {
 	if s == "" {
 		return def
@@ -89,9 +133,11 @@ func envInt(s string, def int) int {
 
 // ----------------- Setup -----------------
 
-// globals for Logs RPC to know the file to tail
+// globals for Logs RPC to know the file to tail or the ring to read
 var (
 	curFilePath string
+	curRing     *Ring
+	curShipper  *Shipper
 	curFileMu   sync.RWMutex
 )
 
@@ -100,6 +146,35 @@ func CurrentFile() string {
 	defer curFileMu.RUnlock()
 	return curFilePath
 }
+
+// CurrentRing is the in-memory log of the last New with RingLines, or nil.
+func CurrentRing() *Ring {
+	curFileMu.RLock()
+	defer curFileMu.RUnlock()
+	return curRing
+}
+func setCurrentRing(r *Ring) {
+	curFileMu.Lock()
+	curRing = r
+	curFileMu.Unlock()
+}
+func setCurrentShipper(s *Shipper) {
+	curFileMu.Lock()
+	curShipper = s
+	curFileMu.Unlock()
+}
+
+// Flush waits up to timeout for records still to be shipped; call it
+// before exiting.
+func Flush(timeout time.Duration) {
+	curFileMu.RLock()
+	s := curShipper
+	curFileMu.RUnlock()
+	if s != nil {
+		s.Flush(timeout)
+	}
+}
+
 func setCurrentFile(p string) {
 	curFileMu.Lock()
 	curFilePath = p
@@ -163,19 +238,63 @@ func EnsureDir(path string) error {
 	return os.MkdirAll(dir, 0o755)
 }
 
-// New builds a slog.Logger using cfg; returns the logger and the truncating simple log writer.
+// New builds a slog.Logger using cfg; returns the logger and the rotating log writer.
 func New(cfg Config) (*slog.Logger, io.Writer) {
 	handlers := make([]slog.Handler, 0, 2)
+	var level slog.Leveler = cfg.Level
+	if cfg.Leveler != nil {
+		level = cfg.Leveler
+	}
+	levels := cfg.Levels
+	if levels == nil && cfg.ComponentLevels != "" {
+		levels = NewLevels(level)
+	}
+	if levels != nil {
+		if err := levels.Set(cfg.ComponentLevels); err != nil {
+			fmt.Fprintf(os.Stderr, "LOG_LEVELS: %v\n", err)
+		}
+		level = minLeveler{levels}
+	}
 
 	var logWriter io.Writer
-	if cfg.File != "" {
-		logWriter, _ = NewSimpleLogResetWriter(cfg.File, cfg.MaxSizeMB*1024*1024)
+	if cfg.File != "" && cfg.File != NoFile {
+		w, err := NewRotatingWriter(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups, cfg.MaxAge, cfg.Compress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log file %s: %v\n", cfg.File, err)
+			logWriter = io.Discard
+		} else {
+			logWriter = w
+		}
 		setCurrentFile(cfg.File)
 		switch cfg.Format {
 		case "json":
-			handlers = append(handlers, slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: level}))
 		default: // text
-			handlers = append(handlers, slog.NewTextHandler(logWriter, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewTextHandler(logWriter, &slog.HandlerOptions{Level: level}))
+		}
+	}
+
+	if cfg.RingLines > 0 {
+		ring := NewRing(cfg.RingLines)
+		setCurrentRing(ring)
+		handlers = append(handlers, slog.NewTextHandler(ring, &slog.HandlerOptions{Level: level}))
+	}
+
+	// journald replaces the stderr handler
+	if cfg.Journal {
+		if jh, err := NewJournalHandler("", &slog.HandlerOptions{Level: level}); err == nil {
+			handlers = append(handlers, jh)
+			cfg.AlsoStderr = false
+		}
+	}
+
+	// shipped records are JSON whatever the format, for collectors to parse
+	if cfg.Ship != "" {
+		if s, err := NewShipper(cfg.Ship); err != nil {
+			fmt.Fprintf(os.Stderr, "LOG_SHIP: %v\n", err)
+		} else {
+			setCurrentShipper(s)
+			handlers = append(handlers, slog.NewJSONHandler(s, &slog.HandlerOptions{Level: level}))
 		}
 	}
 
@@ -183,22 +302,26 @@ func New(cfg Config) (*slog.Logger, io.Writer) {
 	if cfg.AlsoStderr {
 		switch cfg.Format {
 		case "json":
-			handlers = append(handlers, slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 		default:
-			handlers = append(handlers, slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 		}
 	}
 
 	var h slog.Handler
 	if len(handlers) == 0 {
 		// fallback to stderr text
-		h = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level})
+		h = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
 	} else if len(handlers) == 1 {
 		h = handlers[0]
 	} else {
 		h = MultiHandler{hs: handlers}
 	}
 
+	if levels != nil {
+		h = componentHandler{inner: h, levels: levels}
+	}
+
 	l := slog.New(h)
 	if cfg.SetAsDefault {
 		slog.SetDefault(l)
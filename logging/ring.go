@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+)
+
+// Ring keeps the last lines written to it in memory, one per Write as slog
+// handlers do, so recent logs can be read back without a log file.
+type Ring struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{lines: make([]string, max(size, 1))}
+}
+
+func (r *Ring) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	r.mu.Lock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+	return len(p), nil
+}
+
+// Last returns up to n of the newest lines, newest last; n <= 0 means 100
+// like TailLastLines.
+func (r *Ring) Last(n int) []string {
+	if n <= 0 {
+		n = 100
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []string
+	if r.full {
+		all = append(all, r.lines[r.next:]...)
+	}
+	all = append(all, r.lines[:r.next]...)
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all
+}
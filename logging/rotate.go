@@ -0,0 +1,257 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingWriter appends to FilePath and, once it reaches MaxSize bytes,
+// moves it to FilePath.1 (.1.gz when compressed), shifting older backups
+// up. Backups beyond MaxBackups or older than MaxAge are deleted, so the
+// logs never take more than about MaxSize*(1+MaxBackups) on the card.
+// Compression runs in the background, so writers only wait for a rename;
+// a file that fails to compress is kept as an uncompressed backup.
+type RotatingWriter struct {
+	mu         sync.Mutex
+	FilePath   string
+	MaxSize    int64
+	MaxBackups int           // 0 keeps no backup: the file is started over
+	MaxAge     time.Duration // 0 keeps backups regardless of age
+	Compress   bool
+
+	file *os.File
+	size int64
+
+	compressMu sync.Mutex     // serializes compressStaged
+	bg         sync.WaitGroup // running compressStaged calls
+}
+
+func NewRotatingWriter(filePath string, maxSize int64, maxBackups int, maxAge time.Duration, compress bool) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		FilePath:   filePath,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+		Compress:   compress,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.prune()
+	if compress {
+		// logs rotated before a crash or restart, still uncompressed
+		w.bg.Go(w.compressStaged)
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, st.Size()
+	return nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil && w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize {
+		// a failed rotation keeps writing to the file it has
+		_ = w.rotate()
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// backup is the name of the i-th newest backup, compressed or not.
+func (w *RotatingWriter) backup(i int, compressed bool) string {
+	name := w.FilePath + "." + strconv.Itoa(i)
+	if compressed {
+		name += ".gz"
+	}
+	return name
+}
+
+// stagedPrefix names rotated files waiting for compressStaged; the
+// zero-padded time keeps them in rotation order. Each one takes a backup
+// slot once compressed, so prune counts them as backups.
+func (w *RotatingWriter) stagedPrefix() string {
+	return w.FilePath + ".rotated-"
+}
+
+// rotate closes the file, moves it to the first backup and starts a new
+// one. When compressing, the file is only renamed aside and compressed by
+// a background compressStaged. w.mu is held.
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	if w.MaxBackups <= 0 {
+		if err := os.Truncate(w.FilePath, 0); err != nil {
+			return err
+		}
+		return w.open()
+	}
+	if w.Compress {
+		staged := fmt.Sprintf("%s%020d", w.stagedPrefix(), time.Now().UnixNano())
+		if err := os.Rename(w.FilePath, staged); err != nil {
+			return err
+		}
+		if err := w.open(); err != nil {
+			return err
+		}
+		w.bg.Go(w.compressStaged)
+		return nil
+	}
+	w.shift()
+	if err := os.Rename(w.FilePath, w.backup(1, false)); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// shift moves every backup one up, dropping the oldest, so backup 1 is free.
+func (w *RotatingWriter) shift() {
+	for _, compressed := range []bool{false, true} {
+		os.Remove(w.backup(w.MaxBackups, compressed))
+		for i := w.MaxBackups - 1; i >= 1; i-- {
+			os.Rename(w.backup(i, compressed), w.backup(i+1, compressed))
+		}
+	}
+}
+
+// compressStaged turns the staged files, oldest first, into backups. One
+// that fails to compress becomes backup 1 as it is, so the slot shift
+// made room for is not left empty; one that cannot even be renamed stays
+// staged and is retried on the next rotation or start.
+func (w *RotatingWriter) compressStaged() {
+	w.compressMu.Lock()
+	defer w.compressMu.Unlock()
+
+	staged, _ := filepath.Glob(w.stagedPrefix() + "*")
+	slices.Sort(staged)
+	for _, s := range staged {
+		w.shift()
+		if err := compressFile(s, w.backup(1, true)); err != nil {
+			if err := os.Rename(s, w.backup(1, false)); err != nil {
+				break
+			}
+			continue
+		}
+		if err := os.Remove(s); err != nil {
+			break
+		}
+	}
+	w.prune()
+}
+
+// prune deletes backups past MaxAge and stray ones past MaxBackups, e.g.
+// after MaxBackups was lowered. Staged files are backups waiting for
+// their slot: the oldest past MaxBackups and those past MaxAge are
+// deleted, and the numbered backups they will push out go now.
+func (w *RotatingWriter) prune() {
+	expired := func(name string) bool {
+		st, err := os.Stat(name)
+		return err == nil && w.MaxAge > 0 && time.Since(st.ModTime()) > w.MaxAge
+	}
+
+	staged, _ := filepath.Glob(w.stagedPrefix() + "*")
+	slices.Sort(staged)
+	if extra := len(staged) - max(w.MaxBackups, 0); extra > 0 {
+		for _, s := range staged[:extra] {
+			os.Remove(s)
+		}
+		staged = staged[extra:]
+	}
+	pending := 0
+	for _, s := range staged {
+		if expired(s) {
+			os.Remove(s)
+			continue
+		}
+		pending++
+	}
+
+	matches, _ := filepath.Glob(w.FilePath + ".*")
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, w.FilePath+"."), ".gz")
+		i, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		if i > w.MaxBackups-pending || expired(m) {
+			os.Remove(m)
+		}
+	}
+}
+
+// compressFile writes src gzipped to dst; the caller removes src.
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compress %s: %w", src, err)
+	}
+	return nil
+}
+
+// Close closes the file and waits for background compression to finish.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.bg.Wait()
+	return err
+}
@@ -0,0 +1,244 @@
+package logging
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shipper limits: lines wait in memory while the collector is away, the
+// oldest going first when the buffer is full.
+const (
+	shipBuffer     = 10000
+	shipBatch      = 200
+	shipFlushEvery = time.Second
+	shipBackoffMax = time.Minute
+	shipTimeout    = 10 * time.Second
+)
+
+// Shipper sends log records to a collector, so a fleet of hosts can be
+// watched in one place without an agent next to each. Records are the
+// JSON lines of a slog.JSONHandler writing to it; each Write is one. The
+// target is a URL:
+//
+//	syslog://host[:514]      RFC 5424 over UDP, one record per datagram
+//	syslog+tcp://host[:601]  RFC 5424 over TCP, octet-counted
+//	https://host/path        batches POSTed as newline-delimited JSON
+//
+// Writes never block: lines are buffered and a failed delivery is retried
+// with exponential backoff.
+type Shipper struct {
+	target *url.URL
+	host   string // for syslog HOSTNAME
+	app    string // for syslog APP-NAME
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   [][]byte
+	dropped int
+	wake    chan struct{}
+	flushed chan struct{} // closed and replaced when the queue empties
+
+	conn net.Conn // syslog; owned by the sending goroutine
+}
+
+// NewShipper starts shipping to target.
+func NewShipper(target string) (*Shipper, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("log ship target: %w", err)
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tcp", "http", "https":
+	default:
+		return nil, fmt.Errorf("log ship target %q: scheme must be syslog, syslog+tcp, http or https", target)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("log ship target %q: no host", target)
+	}
+	host, _ := os.Hostname()
+	s := &Shipper{
+		target:  u,
+		host:    cmp.Or(host, "-"),
+		app:     filepath.Base(os.Args[0]),
+		client:  &http.Client{Timeout: shipTimeout},
+		wake:    make(chan struct{}, 1),
+		flushed: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *Shipper) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\n")
+	s.mu.Lock()
+	if len(s.queue) >= shipBuffer {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, bytes.Clone(line))
+	full := len(s.queue) >= shipBatch
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Flush waits up to timeout for the buffered lines to be delivered.
+func (s *Shipper) Flush(timeout time.Duration) {
+	s.mu.Lock()
+	if len(s.queue) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	done := s.flushed
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (s *Shipper) run() {
+	t := time.NewTicker(shipFlushEvery)
+	defer t.Stop()
+	backoff := time.Duration(0)
+	for {
+		select {
+		case <-t.C:
+		case <-s.wake:
+		}
+		for {
+			s.mu.Lock()
+			batch := s.queue[:min(len(s.queue), shipBatch)]
+			dropped := s.dropped
+			s.mu.Unlock()
+			if len(batch) == 0 && dropped == 0 {
+				break
+			}
+			if dropped > 0 {
+				batch = append([][]byte{droppedLine(dropped)}, batch...)
+			}
+			if err := s.send(batch); err != nil {
+				backoff = min(max(2*backoff, shipFlushEvery), shipBackoffMax)
+				fmt.Fprintf(os.Stderr, "log shipping to %s failed, retrying in %s: %v\n", s.target.Redacted(), backoff, err)
+				time.Sleep(backoff)
+				continue
+			}
+			backoff = 0
+			s.mu.Lock()
+			// lines dropped while sending may have shifted the queue
+			sent := len(batch)
+			if dropped > 0 {
+				sent--
+			}
+			shifted := s.dropped - dropped
+			s.queue = s.queue[max(sent-shifted, 0):]
+			s.dropped = 0
+			if len(s.queue) == 0 {
+				close(s.flushed)
+				s.flushed = make(chan struct{})
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// droppedLine tells the collector about records lost to a full buffer.
+func droppedLine(n int) []byte {
+	line, _ := json.Marshal(map[string]any{
+		"time":    time.Now().Format(time.RFC3339Nano),
+		"level":   "WARN",
+		"msg":     "log records dropped while the collector was unreachable",
+		"dropped": n,
+	})
+	return line
+}
+
+func (s *Shipper) send(batch [][]byte) error {
+	if s.target.Scheme == "http" || s.target.Scheme == "https" {
+		body := bytes.Join(batch, []byte("\n"))
+		body = append(body, '\n')
+		resp, err := s.client.Post(s.target.String(), "application/x-ndjson", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("collector answered %s", resp.Status)
+		}
+		return nil
+	}
+
+	if s.conn == nil {
+		network, port := "udp", "514"
+		if s.target.Scheme == "syslog+tcp" {
+			network, port = "tcp", "601"
+		}
+		addr := s.target.Host
+		if s.target.Port() == "" {
+			addr = net.JoinHostPort(s.target.Hostname(), port)
+		}
+		c, err := net.DialTimeout(network, addr, shipTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = c
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(shipTimeout))
+	for _, line := range batch {
+		msg := s.syslogMessage(line)
+		if s.target.Scheme == "syslog+tcp" {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if _, err := s.conn.Write(msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// syslogMessage wraps a JSON record in an RFC 5424 header; the record
+// stays the message so collectors can parse its fields.
+func (s *Shipper) syslogMessage(line []byte) []byte {
+	var rec struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+	}
+	_ = json.Unmarshal(line, &rec)
+	severity := 6
+	switch {
+	case strings.HasPrefix(rec.Level, "ERROR"):
+		severity = 3
+	case strings.HasPrefix(rec.Level, "WARN"):
+		severity = 4
+	case strings.HasPrefix(rec.Level, "DEBUG"):
+		severity = 7
+	}
+	const facilityDaemon = 3
+	ts := cmp.Or(rec.Time, time.Now().Format(time.RFC3339Nano))
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", facilityDaemon*8+severity, ts, s.host, s.app, os.Getpid())
+	return append([]byte(header), line...)
+}
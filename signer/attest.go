@@ -0,0 +1,50 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// attestContext separates attestation signatures from any other use of the
+// device identity key.
+var attestContext = []byte("tezsign-attest-v1\x00")
+
+const (
+	AttestNonceMin = 16
+	AttestNonceMax = 64
+)
+
+var (
+	ErrAttestSignature = errors.New("attestation signature invalid")
+	ErrAttestNonce     = errors.New("attestation nonce mismatch")
+	errAttestKey       = errors.New("attestation identity key must be 32 bytes")
+)
+
+// AttestMessage is the byte string the device identity key signs.
+func AttestMessage(statement []byte) []byte {
+	return append(append([]byte(nil), attestContext...), statement...)
+}
+
+// VerifyAttestation checks res against the nonce the host sent and returns
+// the signed statement. Comparing the identity key with a known one is up
+// to the caller.
+func VerifyAttestation(res *AttestResponse, nonce []byte) (*AttestStatement, error) {
+	pub := res.GetIdentityPublicKey()
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, errAttestKey
+	}
+	if !ed25519.Verify(pub, AttestMessage(res.GetStatement()), res.GetSignature()) {
+		return nil, ErrAttestSignature
+	}
+	var st AttestStatement
+	if err := proto.Unmarshal(res.GetStatement(), &st); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(st.GetNonce(), nonce) {
+		return nil, ErrAttestNonce
+	}
+	return &st, nil
+}
@@ -28,6 +28,7 @@ const (
 	LockState_LOCK_STATE_UNSPECIFIED LockState = 0
 	LockState_LOCKED                 LockState = 1
 	LockState_UNLOCKED               LockState = 2
+	LockState_TAMPERED               LockState = 3 // tamper input fired; keys wiped until acknowledged
 )
 
 // Enum value maps for LockState.
@@ -36,11 +37,13 @@ var (
 		0: "LOCK_STATE_UNSPECIFIED",
 		1: "LOCKED",
 		2: "UNLOCKED",
+		3: "TAMPERED",
 	}
 	LockState_value = map[string]int32{
 		"LOCK_STATE_UNSPECIFIED": 0,
 		"LOCKED":                 1,
 		"UNLOCKED":               2,
+		"TAMPERED":               3,
 	}
 )
 
@@ -449,6 +452,100 @@ func (x *KeyStatus) GetStateCorrupted() bool {
 	return false
 }
 
+// Build of the image the gadget runs from, as the image builder wrote it
+// to /etc/tezsign-release.
+type ImageRelease struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	Version               string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Commit                string                 `protobuf:"bytes,2,opt,name=commit,proto3" json:"commit,omitempty"`                        // git commit of the builder checkout
+	BuildTime             string                 `protobuf:"bytes,3,opt,name=build_time,json=buildTime,proto3" json:"build_time,omitempty"` // RFC 3339, the reproducible build epoch
+	Flavour               string                 `protobuf:"bytes,4,opt,name=flavour,proto3" json:"flavour,omitempty"`                      // prod or dev
+	Board                 string                 `protobuf:"bytes,5,opt,name=board,proto3" json:"board,omitempty"`
+	Layout                string                 `protobuf:"bytes,6,opt,name=layout,proto3" json:"layout,omitempty"`                                                              // single or ab
+	PartitionLayoutSha256 string                 `protobuf:"bytes,7,opt,name=partition_layout_sha256,json=partitionLayoutSha256,proto3" json:"partition_layout_sha256,omitempty"` // partition table type, offsets, sizes and UUIDs
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *ImageRelease) Reset() {
+	*x = ImageRelease{}
+	mi := &file_signer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageRelease) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageRelease) ProtoMessage() {}
+
+func (x *ImageRelease) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageRelease.ProtoReflect.Descriptor instead.
+func (*ImageRelease) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ImageRelease) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetCommit() string {
+	if x != nil {
+		return x.Commit
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetBuildTime() string {
+	if x != nil {
+		return x.BuildTime
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetFlavour() string {
+	if x != nil {
+		return x.Flavour
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetBoard() string {
+	if x != nil {
+		return x.Board
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetLayout() string {
+	if x != nil {
+		return x.Layout
+	}
+	return ""
+}
+
+func (x *ImageRelease) GetPartitionLayoutSha256() string {
+	if x != nil {
+		return x.PartitionLayoutSha256
+	}
+	return ""
+}
+
 type StatusRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -457,7 +554,7 @@ type StatusRequest struct {
 
 func (x *StatusRequest) Reset() {
 	*x = StatusRequest{}
-	mi := &file_signer_proto_msgTypes[6]
+	mi := &file_signer_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -469,7 +566,7 @@ func (x *StatusRequest) String() string {
 func (*StatusRequest) ProtoMessage() {}
 
 func (x *StatusRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[6]
+	mi := &file_signer_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -482,19 +579,21 @@ func (x *StatusRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
 func (*StatusRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{6}
+	return file_signer_proto_rawDescGZIP(), []int{7}
 }
 
 type StatusResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Keys          []*KeyStatus           `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	VaultSealed   bool                   `protobuf:"varint,2,opt,name=vault_sealed,json=vaultSealed,proto3" json:"vault_sealed,omitempty"` // keystore vault not opened yet; keys are listed after unlock
+	Release       *ImageRelease          `protobuf:"bytes,3,opt,name=release,proto3" json:"release,omitempty"`                             // unset when the gadget runs without a release file
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *StatusResponse) Reset() {
 	*x = StatusResponse{}
-	mi := &file_signer_proto_msgTypes[7]
+	mi := &file_signer_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -506,7 +605,7 @@ func (x *StatusResponse) String() string {
 func (*StatusResponse) ProtoMessage() {}
 
 func (x *StatusResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[7]
+	mi := &file_signer_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -519,7 +618,7 @@ func (x *StatusResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
 func (*StatusResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{7}
+	return file_signer_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *StatusResponse) GetKeys() []*KeyStatus {
@@ -529,6 +628,20 @@ func (x *StatusResponse) GetKeys() []*KeyStatus {
 	return nil
 }
 
+func (x *StatusResponse) GetVaultSealed() bool {
+	if x != nil {
+		return x.VaultSealed
+	}
+	return false
+}
+
+func (x *StatusResponse) GetRelease() *ImageRelease {
+	if x != nil {
+		return x.Release
+	}
+	return nil
+}
+
 // ---- sign ----
 // Gadget decodes raw bytes to determine both.
 type SignRequest struct {
@@ -541,7 +654,7 @@ type SignRequest struct {
 
 func (x *SignRequest) Reset() {
 	*x = SignRequest{}
-	mi := &file_signer_proto_msgTypes[8]
+	mi := &file_signer_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -553,7 +666,7 @@ func (x *SignRequest) String() string {
 func (*SignRequest) ProtoMessage() {}
 
 func (x *SignRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[8]
+	mi := &file_signer_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -566,7 +679,7 @@ func (x *SignRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SignRequest.ProtoReflect.Descriptor instead.
 func (*SignRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{8}
+	return file_signer_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *SignRequest) GetTz4() string {
@@ -592,7 +705,7 @@ type SignResponse struct {
 
 func (x *SignResponse) Reset() {
 	*x = SignResponse{}
-	mi := &file_signer_proto_msgTypes[9]
+	mi := &file_signer_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -604,7 +717,7 @@ func (x *SignResponse) String() string {
 func (*SignResponse) ProtoMessage() {}
 
 func (x *SignResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[9]
+	mi := &file_signer_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -617,7 +730,7 @@ func (x *SignResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SignResponse.ProtoReflect.Descriptor instead.
 func (*SignResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{9}
+	return file_signer_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *SignResponse) GetSignature() []byte {
@@ -641,7 +754,7 @@ type NewKeyPerKeyResult struct {
 
 func (x *NewKeyPerKeyResult) Reset() {
 	*x = NewKeyPerKeyResult{}
-	mi := &file_signer_proto_msgTypes[10]
+	mi := &file_signer_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -653,7 +766,7 @@ func (x *NewKeyPerKeyResult) String() string {
 func (*NewKeyPerKeyResult) ProtoMessage() {}
 
 func (x *NewKeyPerKeyResult) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[10]
+	mi := &file_signer_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -666,7 +779,7 @@ func (x *NewKeyPerKeyResult) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NewKeyPerKeyResult.ProtoReflect.Descriptor instead.
 func (*NewKeyPerKeyResult) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{10}
+	return file_signer_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *NewKeyPerKeyResult) GetKeyId() string {
@@ -708,15 +821,20 @@ type NewKeysRequest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Optional: provide one or more aliases
 	// If empty, gadget auto-assigns (e.g., "key3").
-	KeyIds        []string `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
-	Passphrase    []byte   `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	KeyIds     []string `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
+	Passphrase []byte   `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	HdIndex    uint32   `protobuf:"varint,3,opt,name=hd_index,json=hdIndex,proto3" json:"hd_index,omitempty"` // HD mode, single key_id only; 0 => next free index
+	Overwrite  bool     `protobuf:"varint,4,opt,name=overwrite,proto3" json:"overwrite,omitempty"`            // replace existing key_ids instead of failing
+	// allow an hd_index below the next free one, i.e. one that may already
+	// be in use; the key starts at the highest watermark on record
+	ReuseHdIndex  bool `protobuf:"varint,5,opt,name=reuse_hd_index,json=reuseHdIndex,proto3" json:"reuse_hd_index,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *NewKeysRequest) Reset() {
 	*x = NewKeysRequest{}
-	mi := &file_signer_proto_msgTypes[11]
+	mi := &file_signer_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -728,7 +846,7 @@ func (x *NewKeysRequest) String() string {
 func (*NewKeysRequest) ProtoMessage() {}
 
 func (x *NewKeysRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[11]
+	mi := &file_signer_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -741,7 +859,7 @@ func (x *NewKeysRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NewKeysRequest.ProtoReflect.Descriptor instead.
 func (*NewKeysRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{11}
+	return file_signer_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *NewKeysRequest) GetKeyIds() []string {
@@ -758,6 +876,27 @@ func (x *NewKeysRequest) GetPassphrase() []byte {
 	return nil
 }
 
+func (x *NewKeysRequest) GetHdIndex() uint32 {
+	if x != nil {
+		return x.HdIndex
+	}
+	return 0
+}
+
+func (x *NewKeysRequest) GetOverwrite() bool {
+	if x != nil {
+		return x.Overwrite
+	}
+	return false
+}
+
+func (x *NewKeysRequest) GetReuseHdIndex() bool {
+	if x != nil {
+		return x.ReuseHdIndex
+	}
+	return false
+}
+
 type NewKeysResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// One result per attempted key (includes ok/error + key material)
@@ -768,7 +907,7 @@ type NewKeysResponse struct {
 
 func (x *NewKeysResponse) Reset() {
 	*x = NewKeysResponse{}
-	mi := &file_signer_proto_msgTypes[12]
+	mi := &file_signer_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -780,7 +919,7 @@ func (x *NewKeysResponse) String() string {
 func (*NewKeysResponse) ProtoMessage() {}
 
 func (x *NewKeysResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[12]
+	mi := &file_signer_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -793,7 +932,7 @@ func (x *NewKeysResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NewKeysResponse.ProtoReflect.Descriptor instead.
 func (*NewKeysResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{12}
+	return file_signer_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *NewKeysResponse) GetResults() []*NewKeyPerKeyResult {
@@ -808,14 +947,17 @@ type LogsRequest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Max number of most-recent log lines to return.
 	// If zero, gadget picks a sensible default (e.g., 100).
-	Limit         uint32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Limit uint32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Read the gadget's in-memory log of recent lines instead of its log
+	// file. Used anyway when file logging is off.
+	Memory        bool `protobuf:"varint,2,opt,name=memory,proto3" json:"memory,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *LogsRequest) Reset() {
 	*x = LogsRequest{}
-	mi := &file_signer_proto_msgTypes[13]
+	mi := &file_signer_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -827,7 +969,7 @@ func (x *LogsRequest) String() string {
 func (*LogsRequest) ProtoMessage() {}
 
 func (x *LogsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[13]
+	mi := &file_signer_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -840,7 +982,7 @@ func (x *LogsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LogsRequest.ProtoReflect.Descriptor instead.
 func (*LogsRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{13}
+	return file_signer_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *LogsRequest) GetLimit() uint32 {
@@ -850,6 +992,13 @@ func (x *LogsRequest) GetLimit() uint32 {
 	return 0
 }
 
+func (x *LogsRequest) GetMemory() bool {
+	if x != nil {
+		return x.Memory
+	}
+	return false
+}
+
 type LogsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Lines         []string               `protobuf:"bytes,1,rep,name=lines,proto3" json:"lines,omitempty"` // newest last
@@ -859,7 +1008,7 @@ type LogsResponse struct {
 
 func (x *LogsResponse) Reset() {
 	*x = LogsResponse{}
-	mi := &file_signer_proto_msgTypes[14]
+	mi := &file_signer_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -871,7 +1020,7 @@ func (x *LogsResponse) String() string {
 func (*LogsResponse) ProtoMessage() {}
 
 func (x *LogsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[14]
+	mi := &file_signer_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -884,7 +1033,7 @@ func (x *LogsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use LogsResponse.ProtoReflect.Descriptor instead.
 func (*LogsResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{14}
+	return file_signer_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *LogsResponse) GetLines() []string {
@@ -905,7 +1054,7 @@ type InitMasterRequest struct {
 
 func (x *InitMasterRequest) Reset() {
 	*x = InitMasterRequest{}
-	mi := &file_signer_proto_msgTypes[15]
+	mi := &file_signer_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -917,7 +1066,7 @@ func (x *InitMasterRequest) String() string {
 func (*InitMasterRequest) ProtoMessage() {}
 
 func (x *InitMasterRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[15]
+	mi := &file_signer_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -930,7 +1079,7 @@ func (x *InitMasterRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use InitMasterRequest.ProtoReflect.Descriptor instead.
 func (*InitMasterRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{15}
+	return file_signer_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *InitMasterRequest) GetDeterministic() bool {
@@ -956,7 +1105,7 @@ type InitInfoRequest struct {
 
 func (x *InitInfoRequest) Reset() {
 	*x = InitInfoRequest{}
-	mi := &file_signer_proto_msgTypes[16]
+	mi := &file_signer_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -968,7 +1117,7 @@ func (x *InitInfoRequest) String() string {
 func (*InitInfoRequest) ProtoMessage() {}
 
 func (x *InitInfoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[16]
+	mi := &file_signer_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -981,7 +1130,7 @@ func (x *InitInfoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use InitInfoRequest.ProtoReflect.Descriptor instead.
 func (*InitInfoRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{16}
+	return file_signer_proto_rawDescGZIP(), []int{17}
 }
 
 type InitInfoResponse struct {
@@ -994,7 +1143,7 @@ type InitInfoResponse struct {
 
 func (x *InitInfoResponse) Reset() {
 	*x = InitInfoResponse{}
-	mi := &file_signer_proto_msgTypes[17]
+	mi := &file_signer_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1006,7 +1155,7 @@ func (x *InitInfoResponse) String() string {
 func (*InitInfoResponse) ProtoMessage() {}
 
 func (x *InitInfoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[17]
+	mi := &file_signer_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1019,7 +1168,7 @@ func (x *InitInfoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use InitInfoResponse.ProtoReflect.Descriptor instead.
 func (*InitInfoResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{17}
+	return file_signer_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *InitInfoResponse) GetMasterPresent() bool {
@@ -1048,7 +1197,7 @@ type SetLevelRequest struct {
 
 func (x *SetLevelRequest) Reset() {
 	*x = SetLevelRequest{}
-	mi := &file_signer_proto_msgTypes[18]
+	mi := &file_signer_proto_msgTypes[19]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1060,7 +1209,7 @@ func (x *SetLevelRequest) String() string {
 func (*SetLevelRequest) ProtoMessage() {}
 
 func (x *SetLevelRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[18]
+	mi := &file_signer_proto_msgTypes[19]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1073,7 +1222,7 @@ func (x *SetLevelRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SetLevelRequest.ProtoReflect.Descriptor instead.
 func (*SetLevelRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{18}
+	return file_signer_proto_rawDescGZIP(), []int{19}
 }
 
 func (x *SetLevelRequest) GetKeyId() string {
@@ -1101,7 +1250,7 @@ type DeleteKeysRequest struct {
 
 func (x *DeleteKeysRequest) Reset() {
 	*x = DeleteKeysRequest{}
-	mi := &file_signer_proto_msgTypes[19]
+	mi := &file_signer_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1113,7 +1262,7 @@ func (x *DeleteKeysRequest) String() string {
 func (*DeleteKeysRequest) ProtoMessage() {}
 
 func (x *DeleteKeysRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[19]
+	mi := &file_signer_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1126,7 +1275,7 @@ func (x *DeleteKeysRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteKeysRequest.ProtoReflect.Descriptor instead.
 func (*DeleteKeysRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{19}
+	return file_signer_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *DeleteKeysRequest) GetKeyIds() []string {
@@ -1152,7 +1301,7 @@ type DeleteKeysResponse struct {
 
 func (x *DeleteKeysResponse) Reset() {
 	*x = DeleteKeysResponse{}
-	mi := &file_signer_proto_msgTypes[20]
+	mi := &file_signer_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1164,7 +1313,7 @@ func (x *DeleteKeysResponse) String() string {
 func (*DeleteKeysResponse) ProtoMessage() {}
 
 func (x *DeleteKeysResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[20]
+	mi := &file_signer_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1177,7 +1326,7 @@ func (x *DeleteKeysResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeleteKeysResponse.ProtoReflect.Descriptor instead.
 func (*DeleteKeysResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{20}
+	return file_signer_proto_rawDescGZIP(), []int{21}
 }
 
 func (x *DeleteKeysResponse) GetResults() []*PerKeyResult {
@@ -1187,28 +1336,29 @@ func (x *DeleteKeysResponse) GetResults() []*PerKeyResult {
 	return nil
 }
 
-type Ok struct {
+// ---- proof of possession ----
+type PopRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Tz4           string                 `protobuf:"bytes,1,opt,name=tz4,proto3" json:"tz4,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Ok) Reset() {
-	*x = Ok{}
-	mi := &file_signer_proto_msgTypes[21]
+func (x *PopRequest) Reset() {
+	*x = PopRequest{}
+	mi := &file_signer_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Ok) String() string {
+func (x *PopRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Ok) ProtoMessage() {}
+func (*PopRequest) ProtoMessage() {}
 
-func (x *Ok) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[21]
+func (x *PopRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1219,41 +1369,40 @@ func (x *Ok) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Ok.ProtoReflect.Descriptor instead.
-func (*Ok) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{21}
+// Deprecated: Use PopRequest.ProtoReflect.Descriptor instead.
+func (*PopRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{22}
 }
 
-func (x *Ok) GetOk() bool {
+func (x *PopRequest) GetTz4() string {
 	if x != nil {
-		return x.Ok
+		return x.Tz4
 	}
-	return false
+	return ""
 }
 
-type Error struct {
+type PopResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Code          uint32                 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
-	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Pop           string                 `protobuf:"bytes,1,opt,name=pop,proto3" json:"pop,omitempty"` // BLsig…
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Error) Reset() {
-	*x = Error{}
-	mi := &file_signer_proto_msgTypes[22]
+func (x *PopResponse) Reset() {
+	*x = PopResponse{}
+	mi := &file_signer_proto_msgTypes[23]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Error) String() string {
+func (x *PopResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Error) ProtoMessage() {}
+func (*PopResponse) ProtoMessage() {}
 
-func (x *Error) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[22]
+func (x *PopResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[23]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1264,59 +1413,45 @@ func (x *Error) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Error.ProtoReflect.Descriptor instead.
-func (*Error) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{22}
-}
-
-func (x *Error) GetCode() uint32 {
-	if x != nil {
-		return x.Code
-	}
-	return 0
+// Deprecated: Use PopResponse.ProtoReflect.Descriptor instead.
+func (*PopResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{23}
 }
 
-func (x *Error) GetMessage() string {
+func (x *PopResponse) GetPop() string {
 	if x != nil {
-		return x.Message
+		return x.Pop
 	}
 	return ""
 }
 
-type Request struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Payload:
-	//
-	//	*Request_Unlock
-	//	*Request_Lock
-	//	*Request_Status
-	//	*Request_Sign
-	//	*Request_NewKeys
-	//	*Request_Logs
-	//	*Request_InitMaster
-	//	*Request_InitInfo
-	//	*Request_SetLevel
-	//	*Request_DeleteKeys
-	Payload       isRequest_Payload `protobuf_oneof:"payload"`
+// ---- app update ----
+// Begin -> Chunk... -> Commit. The gadget verifies the signature and stages
+// the binary; a root helper on the device swaps it in and restarts the app.
+type UpdateBeginRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Size          uint64                 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`          // total binary size in bytes
+	Sha256        []byte                 `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"`       // digest of the whole binary
+	Signature     []byte                 `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"` // ed25519 signature over the binary
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *Request) Reset() {
-	*x = Request{}
-	mi := &file_signer_proto_msgTypes[23]
+func (x *UpdateBeginRequest) Reset() {
+	*x = UpdateBeginRequest{}
+	mi := &file_signer_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Request) String() string {
+func (x *UpdateBeginRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Request) ProtoMessage() {}
+func (*UpdateBeginRequest) ProtoMessage() {}
 
-func (x *Request) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[23]
+func (x *UpdateBeginRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1327,9 +1462,1168 @@ func (x *Request) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Request.ProtoReflect.Descriptor instead.
-func (*Request) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{23}
+// Deprecated: Use UpdateBeginRequest.ProtoReflect.Descriptor instead.
+func (*UpdateBeginRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *UpdateBeginRequest) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *UpdateBeginRequest) GetSha256() []byte {
+	if x != nil {
+		return x.Sha256
+	}
+	return nil
+}
+
+func (x *UpdateBeginRequest) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type UpdateChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"` // must equal the bytes received so far
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateChunkRequest) Reset() {
+	*x = UpdateChunkRequest{}
+	mi := &file_signer_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateChunkRequest) ProtoMessage() {}
+
+func (x *UpdateChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateChunkRequest.ProtoReflect.Descriptor instead.
+func (*UpdateChunkRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *UpdateChunkRequest) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *UpdateChunkRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type UpdateCommitRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateCommitRequest) Reset() {
+	*x = UpdateCommitRequest{}
+	mi := &file_signer_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateCommitRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateCommitRequest) ProtoMessage() {}
+
+func (x *UpdateCommitRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateCommitRequest.ProtoReflect.Descriptor instead.
+func (*UpdateCommitRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{26}
+}
+
+type UpdateCommitResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Version         string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`                                        // reported by the staged binary
+	PreviousVersion string                 `protobuf:"bytes,2,opt,name=previous_version,json=previousVersion,proto3" json:"previous_version,omitempty"` // running binary
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *UpdateCommitResponse) Reset() {
+	*x = UpdateCommitResponse{}
+	mi := &file_signer_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateCommitResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateCommitResponse) ProtoMessage() {}
+
+func (x *UpdateCommitResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateCommitResponse.ProtoReflect.Descriptor instead.
+func (*UpdateCommitResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *UpdateCommitResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *UpdateCommitResponse) GetPreviousVersion() string {
+	if x != nil {
+		return x.PreviousVersion
+	}
+	return ""
+}
+
+// ---- health ----
+// Point-in-time gadget health, cheap enough to poll for metrics.
+type HealthRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthRequest) Reset() {
+	*x = HealthRequest{}
+	mi := &file_signer_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthRequest) ProtoMessage() {}
+
+func (x *HealthRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthRequest.ProtoReflect.Descriptor instead.
+func (*HealthRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{28}
+}
+
+type HealthResponse struct {
+	state                      protoimpl.MessageState `protogen:"open.v1"`
+	UptimeSeconds              uint64                 `protobuf:"varint,1,opt,name=uptime_seconds,json=uptimeSeconds,proto3" json:"uptime_seconds,omitempty"`
+	TemperatureAvailable       bool                   `protobuf:"varint,2,opt,name=temperature_available,json=temperatureAvailable,proto3" json:"temperature_available,omitempty"` // false if the board exposes no thermal zone
+	TemperatureMillicelsius    int32                  `protobuf:"zigzag32,3,opt,name=temperature_millicelsius,json=temperatureMillicelsius,proto3" json:"temperature_millicelsius,omitempty"`
+	DataFreeBytes              uint64                 `protobuf:"varint,4,opt,name=data_free_bytes,json=dataFreeBytes,proto3" json:"data_free_bytes,omitempty"` // data partition (keystore)
+	DataTotalBytes             uint64                 `protobuf:"varint,5,opt,name=data_total_bytes,json=dataTotalBytes,proto3" json:"data_total_bytes,omitempty"`
+	Goroutines                 uint32                 `protobuf:"varint,6,opt,name=goroutines,proto3" json:"goroutines,omitempty"`
+	QueueDepth                 uint32                 `protobuf:"varint,7,opt,name=queue_depth,json=queueDepth,proto3" json:"queue_depth,omitempty"`                                             // requests being handled + frames waiting to be written
+	WatermarkPersistFailures   uint64                 `protobuf:"varint,8,opt,name=watermark_persist_failures,json=watermarkPersistFailures,proto3" json:"watermark_persist_failures,omitempty"` // failed level.bin writes/fsyncs since start
+	Version                    string                 `protobuf:"bytes,9,opt,name=version,proto3" json:"version,omitempty"`
+	MemAvailableBytes          uint64                 `protobuf:"varint,10,opt,name=mem_available_bytes,json=memAvailableBytes,proto3" json:"mem_available_bytes,omitempty"`
+	Signs                      uint64                 `protobuf:"varint,11,opt,name=signs,proto3" json:"signs,omitempty"`                                                                                 // signatures handed out since start
+	SignRejects                uint64                 `protobuf:"varint,12,opt,name=sign_rejects,json=signRejects,proto3" json:"sign_rejects,omitempty"`                                                  // sign requests refused since start
+	WatermarkPersistLastMicros uint64                 `protobuf:"varint,13,opt,name=watermark_persist_last_micros,json=watermarkPersistLastMicros,proto3" json:"watermark_persist_last_micros,omitempty"` // latest level.bin write+fsync
+	WatermarkPersistMaxMicros  uint64                 `protobuf:"varint,14,opt,name=watermark_persist_max_micros,json=watermarkPersistMaxMicros,proto3" json:"watermark_persist_max_micros,omitempty"`    // slowest since start
+	unknownFields              protoimpl.UnknownFields
+	sizeCache                  protoimpl.SizeCache
+}
+
+func (x *HealthResponse) Reset() {
+	*x = HealthResponse{}
+	mi := &file_signer_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthResponse) ProtoMessage() {}
+
+func (x *HealthResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthResponse.ProtoReflect.Descriptor instead.
+func (*HealthResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *HealthResponse) GetUptimeSeconds() uint64 {
+	if x != nil {
+		return x.UptimeSeconds
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetTemperatureAvailable() bool {
+	if x != nil {
+		return x.TemperatureAvailable
+	}
+	return false
+}
+
+func (x *HealthResponse) GetTemperatureMillicelsius() int32 {
+	if x != nil {
+		return x.TemperatureMillicelsius
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetDataFreeBytes() uint64 {
+	if x != nil {
+		return x.DataFreeBytes
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetDataTotalBytes() uint64 {
+	if x != nil {
+		return x.DataTotalBytes
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetGoroutines() uint32 {
+	if x != nil {
+		return x.Goroutines
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetQueueDepth() uint32 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetWatermarkPersistFailures() uint64 {
+	if x != nil {
+		return x.WatermarkPersistFailures
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *HealthResponse) GetMemAvailableBytes() uint64 {
+	if x != nil {
+		return x.MemAvailableBytes
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetSigns() uint64 {
+	if x != nil {
+		return x.Signs
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetSignRejects() uint64 {
+	if x != nil {
+		return x.SignRejects
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetWatermarkPersistLastMicros() uint64 {
+	if x != nil {
+		return x.WatermarkPersistLastMicros
+	}
+	return 0
+}
+
+func (x *HealthResponse) GetWatermarkPersistMaxMicros() uint64 {
+	if x != nil {
+		return x.WatermarkPersistMaxMicros
+	}
+	return 0
+}
+
+// Asks the gadget to push Telemetry events on this channel until it
+// disconnects; answered with Ok. Sending it again changes the interval.
+type TelemetryRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	IntervalSeconds uint32                 `protobuf:"varint,1,opt,name=interval_seconds,json=intervalSeconds,proto3" json:"interval_seconds,omitempty"` // 0 = gadget default
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *TelemetryRequest) Reset() {
+	*x = TelemetryRequest{}
+	mi := &file_signer_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TelemetryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TelemetryRequest) ProtoMessage() {}
+
+func (x *TelemetryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TelemetryRequest.ProtoReflect.Descriptor instead.
+func (*TelemetryRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *TelemetryRequest) GetIntervalSeconds() uint32 {
+	if x != nil {
+		return x.IntervalSeconds
+	}
+	return 0
+}
+
+// Pages through the sign audit trail (hash-chained JSON lines, see package
+// audit), oldest first.
+type AuditRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AfterSeq      uint64                 `protobuf:"varint,1,opt,name=after_seq,json=afterSeq,proto3" json:"after_seq,omitempty"` // records with seq > after_seq
+	Limit         uint32                 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`                       // 0 = 200; at most 1000
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditRequest) Reset() {
+	*x = AuditRequest{}
+	mi := &file_signer_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditRequest) ProtoMessage() {}
+
+func (x *AuditRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditRequest.ProtoReflect.Descriptor instead.
+func (*AuditRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *AuditRequest) GetAfterSeq() uint64 {
+	if x != nil {
+		return x.AfterSeq
+	}
+	return 0
+}
+
+func (x *AuditRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type AuditResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       [][]byte               `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"` // JSON lines without the newline
+	More          bool                   `protobuf:"varint,2,opt,name=more,proto3" json:"more,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditResponse) Reset() {
+	*x = AuditResponse{}
+	mi := &file_signer_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditResponse) ProtoMessage() {}
+
+func (x *AuditResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditResponse.ProtoReflect.Descriptor instead.
+func (*AuditResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *AuditResponse) GetRecords() [][]byte {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *AuditResponse) GetMore() bool {
+	if x != nil {
+		return x.More
+	}
+	return false
+}
+
+// Checks the whole trail on the device.
+type AuditVerifyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditVerifyRequest) Reset() {
+	*x = AuditVerifyRequest{}
+	mi := &file_signer_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditVerifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditVerifyRequest) ProtoMessage() {}
+
+func (x *AuditVerifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditVerifyRequest.ProtoReflect.Descriptor instead.
+func (*AuditVerifyRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{33}
+}
+
+type AuditVerifyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	FirstSeq      uint64                 `protobuf:"varint,2,opt,name=first_seq,json=firstSeq,proto3" json:"first_seq,omitempty"`
+	LastSeq       uint64                 `protobuf:"varint,3,opt,name=last_seq,json=lastSeq,proto3" json:"last_seq,omitempty"`   // last good record when !ok
+	HeadHash      []byte                 `protobuf:"bytes,4,opt,name=head_hash,json=headHash,proto3" json:"head_hash,omitempty"` // SHA-256 of that record
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuditVerifyResponse) Reset() {
+	*x = AuditVerifyResponse{}
+	mi := &file_signer_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuditVerifyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuditVerifyResponse) ProtoMessage() {}
+
+func (x *AuditVerifyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuditVerifyResponse.ProtoReflect.Descriptor instead.
+func (*AuditVerifyResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *AuditVerifyResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *AuditVerifyResponse) GetFirstSeq() uint64 {
+	if x != nil {
+		return x.FirstSeq
+	}
+	return 0
+}
+
+func (x *AuditVerifyResponse) GetLastSeq() uint64 {
+	if x != nil {
+		return x.LastSeq
+	}
+	return 0
+}
+
+func (x *AuditVerifyResponse) GetHeadHash() []byte {
+	if x != nil {
+		return x.HeadHash
+	}
+	return nil
+}
+
+func (x *AuditVerifyResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// Asks the device to prove its identity. The gadget signs the nonce together
+// with what it runs using its device identity key (ed25519, created on first
+// start and never exported).
+type AttestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nonce         []byte                 `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"` // 16..64 random bytes chosen by the host
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestRequest) Reset() {
+	*x = AttestRequest{}
+	mi := &file_signer_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestRequest) ProtoMessage() {}
+
+func (x *AttestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestRequest.ProtoReflect.Descriptor instead.
+func (*AttestRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *AttestRequest) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+type AttestResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	IdentityPublicKey []byte                 `protobuf:"bytes,1,opt,name=identity_public_key,json=identityPublicKey,proto3" json:"identity_public_key,omitempty"` // ed25519, 32 bytes
+	Statement         []byte                 `protobuf:"bytes,2,opt,name=statement,proto3" json:"statement,omitempty"`                                            // serialized AttestStatement
+	Signature         []byte                 `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`                                            // ed25519 over "tezsign-attest-v1\x00" || statement
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *AttestResponse) Reset() {
+	*x = AttestResponse{}
+	mi := &file_signer_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestResponse) ProtoMessage() {}
+
+func (x *AttestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestResponse.ProtoReflect.Descriptor instead.
+func (*AttestResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *AttestResponse) GetIdentityPublicKey() []byte {
+	if x != nil {
+		return x.IdentityPublicKey
+	}
+	return nil
+}
+
+func (x *AttestResponse) GetStatement() []byte {
+	if x != nil {
+		return x.Statement
+	}
+	return nil
+}
+
+func (x *AttestResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// What an attestation vouches for.
+type AttestStatement struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nonce         []byte                 `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Version       string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`                               // gadget app version
+	AppSha256     []byte                 `protobuf:"bytes,3,opt,name=app_sha256,json=appSha256,proto3" json:"app_sha256,omitempty"`          // running app binary
+	ConfigSha256  []byte                 `protobuf:"bytes,4,opt,name=config_sha256,json=configSha256,proto3" json:"config_sha256,omitempty"` // effective gadget settings (config file + environment)
+	KernelRelease string                 `protobuf:"bytes,5,opt,name=kernel_release,json=kernelRelease,proto3" json:"kernel_release,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AttestStatement) Reset() {
+	*x = AttestStatement{}
+	mi := &file_signer_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AttestStatement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AttestStatement) ProtoMessage() {}
+
+func (x *AttestStatement) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AttestStatement.ProtoReflect.Descriptor instead.
+func (*AttestStatement) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *AttestStatement) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *AttestStatement) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AttestStatement) GetAppSha256() []byte {
+	if x != nil {
+		return x.AppSha256
+	}
+	return nil
+}
+
+func (x *AttestStatement) GetConfigSha256() []byte {
+	if x != nil {
+		return x.ConfigSha256
+	}
+	return nil
+}
+
+func (x *AttestStatement) GetKernelRelease() string {
+	if x != nil {
+		return x.KernelRelease
+	}
+	return ""
+}
+
+// Pushes the host's wall clock to the gadget, which has neither an RTC nor
+// network time. The gadget rejects times outside 2025..2100 and only moves
+// its clock forward, unless the master passphrase is given to reset it,
+// e.g. after a bad host pushed it into the future. Served on IF1 and IF2.
+type SetTimeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UnixNanos     int64                  `protobuf:"varint,1,opt,name=unix_nanos,json=unixNanos,proto3" json:"unix_nanos,omitempty"`
+	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"` // set to also allow moving the clock back
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTimeRequest) Reset() {
+	*x = SetTimeRequest{}
+	mi := &file_signer_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTimeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTimeRequest) ProtoMessage() {}
+
+func (x *SetTimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTimeRequest.ProtoReflect.Descriptor instead.
+func (*SetTimeRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *SetTimeRequest) GetUnixNanos() int64 {
+	if x != nil {
+		return x.UnixNanos
+	}
+	return 0
+}
+
+func (x *SetTimeRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+type SetTimeResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	PreviousUnixNanos int64                  `protobuf:"varint,1,opt,name=previous_unix_nanos,json=previousUnixNanos,proto3" json:"previous_unix_nanos,omitempty"` // gadget clock when the request arrived
+	Stepped           bool                   `protobuf:"varint,2,opt,name=stepped,proto3" json:"stepped,omitempty"`                                                // false when the clocks already agreed within 2 s
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *SetTimeResponse) Reset() {
+	*x = SetTimeResponse{}
+	mi := &file_signer_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTimeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTimeResponse) ProtoMessage() {}
+
+func (x *SetTimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTimeResponse.ProtoReflect.Descriptor instead.
+func (*SetTimeResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *SetTimeResponse) GetPreviousUnixNanos() int64 {
+	if x != nil {
+		return x.PreviousUnixNanos
+	}
+	return 0
+}
+
+func (x *SetTimeResponse) GetStepped() bool {
+	if x != nil {
+		return x.Stepped
+	}
+	return false
+}
+
+// Broker event payload (not a Response).
+type Telemetry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"` // increases per event; events may arrive out of order
+	Health        *HealthResponse        `protobuf:"bytes,2,opt,name=health,proto3" json:"health,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Telemetry) Reset() {
+	*x = Telemetry{}
+	mi := &file_signer_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Telemetry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Telemetry) ProtoMessage() {}
+
+func (x *Telemetry) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Telemetry.ProtoReflect.Descriptor instead.
+func (*Telemetry) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *Telemetry) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Telemetry) GetHealth() *HealthResponse {
+	if x != nil {
+		return x.Health
+	}
+	return nil
+}
+
+type Ok struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ok) Reset() {
+	*x = Ok{}
+	mi := &file_signer_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ok) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ok) ProtoMessage() {}
+
+func (x *Ok) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ok.ProtoReflect.Descriptor instead.
+func (*Ok) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *Ok) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          uint32                 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_signer_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *Error) GetCode() uint32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// ---- tamper ----
+// Clears the tamper latch so keys can be unlocked again. Answered with Ok.
+type AckTamperRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passphrase    []byte                 `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AckTamperRequest) Reset() {
+	*x = AckTamperRequest{}
+	mi := &file_signer_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AckTamperRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AckTamperRequest) ProtoMessage() {}
+
+func (x *AckTamperRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AckTamperRequest.ProtoReflect.Descriptor instead.
+func (*AckTamperRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *AckTamperRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+type Request struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Payload:
+	//
+	//	*Request_Unlock
+	//	*Request_Lock
+	//	*Request_Status
+	//	*Request_Sign
+	//	*Request_NewKeys
+	//	*Request_Logs
+	//	*Request_InitMaster
+	//	*Request_InitInfo
+	//	*Request_SetLevel
+	//	*Request_DeleteKeys
+	//	*Request_UpdateBegin
+	//	*Request_UpdateChunk
+	//	*Request_UpdateCommit
+	//	*Request_Pop
+	//	*Request_Health
+	//	*Request_AckTamper
+	//	*Request_Telemetry
+	//	*Request_Audit
+	//	*Request_AuditVerify
+	//	*Request_Attest
+	//	*Request_SetTime
+	Payload       isRequest_Payload `protobuf_oneof:"payload"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Request) Reset() {
+	*x = Request{}
+	mi := &file_signer_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Request) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Request) ProtoMessage() {}
+
+func (x *Request) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Request.ProtoReflect.Descriptor instead.
+func (*Request) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{44}
 }
 
 func (x *Request) GetPayload() isRequest_Payload {
@@ -1429,6 +2723,105 @@ func (x *Request) GetDeleteKeys() *DeleteKeysRequest {
 	return nil
 }
 
+func (x *Request) GetUpdateBegin() *UpdateBeginRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_UpdateBegin); ok {
+			return x.UpdateBegin
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetUpdateChunk() *UpdateChunkRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_UpdateChunk); ok {
+			return x.UpdateChunk
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetUpdateCommit() *UpdateCommitRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_UpdateCommit); ok {
+			return x.UpdateCommit
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetPop() *PopRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Pop); ok {
+			return x.Pop
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetHealth() *HealthRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Health); ok {
+			return x.Health
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetAckTamper() *AckTamperRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_AckTamper); ok {
+			return x.AckTamper
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetTelemetry() *TelemetryRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Telemetry); ok {
+			return x.Telemetry
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetAudit() *AuditRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Audit); ok {
+			return x.Audit
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetAuditVerify() *AuditVerifyRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_AuditVerify); ok {
+			return x.AuditVerify
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetAttest() *AttestRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Attest); ok {
+			return x.Attest
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetSetTime() *SetTimeRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_SetTime); ok {
+			return x.SetTime
+		}
+	}
+	return nil
+}
+
 type isRequest_Payload interface {
 	isRequest_Payload()
 }
@@ -1473,6 +2866,50 @@ type Request_DeleteKeys struct {
 	DeleteKeys *DeleteKeysRequest `protobuf:"bytes,10,opt,name=delete_keys,json=deleteKeys,proto3,oneof"`
 }
 
+type Request_UpdateBegin struct {
+	UpdateBegin *UpdateBeginRequest `protobuf:"bytes,11,opt,name=update_begin,json=updateBegin,proto3,oneof"`
+}
+
+type Request_UpdateChunk struct {
+	UpdateChunk *UpdateChunkRequest `protobuf:"bytes,12,opt,name=update_chunk,json=updateChunk,proto3,oneof"`
+}
+
+type Request_UpdateCommit struct {
+	UpdateCommit *UpdateCommitRequest `protobuf:"bytes,13,opt,name=update_commit,json=updateCommit,proto3,oneof"`
+}
+
+type Request_Pop struct {
+	Pop *PopRequest `protobuf:"bytes,14,opt,name=pop,proto3,oneof"`
+}
+
+type Request_Health struct {
+	Health *HealthRequest `protobuf:"bytes,15,opt,name=health,proto3,oneof"`
+}
+
+type Request_AckTamper struct {
+	AckTamper *AckTamperRequest `protobuf:"bytes,16,opt,name=ack_tamper,json=ackTamper,proto3,oneof"`
+}
+
+type Request_Telemetry struct {
+	Telemetry *TelemetryRequest `protobuf:"bytes,17,opt,name=telemetry,proto3,oneof"`
+}
+
+type Request_Audit struct {
+	Audit *AuditRequest `protobuf:"bytes,18,opt,name=audit,proto3,oneof"`
+}
+
+type Request_AuditVerify struct {
+	AuditVerify *AuditVerifyRequest `protobuf:"bytes,19,opt,name=audit_verify,json=auditVerify,proto3,oneof"`
+}
+
+type Request_Attest struct {
+	Attest *AttestRequest `protobuf:"bytes,20,opt,name=attest,proto3,oneof"`
+}
+
+type Request_SetTime struct {
+	SetTime *SetTimeRequest `protobuf:"bytes,21,opt,name=set_time,json=setTime,proto3,oneof"`
+}
+
 func (*Request_Unlock) isRequest_Payload() {}
 
 func (*Request_Lock) isRequest_Payload() {}
@@ -1493,6 +2930,28 @@ func (*Request_SetLevel) isRequest_Payload() {}
 
 func (*Request_DeleteKeys) isRequest_Payload() {}
 
+func (*Request_UpdateBegin) isRequest_Payload() {}
+
+func (*Request_UpdateChunk) isRequest_Payload() {}
+
+func (*Request_UpdateCommit) isRequest_Payload() {}
+
+func (*Request_Pop) isRequest_Payload() {}
+
+func (*Request_Health) isRequest_Payload() {}
+
+func (*Request_AckTamper) isRequest_Payload() {}
+
+func (*Request_Telemetry) isRequest_Payload() {}
+
+func (*Request_Audit) isRequest_Payload() {}
+
+func (*Request_AuditVerify) isRequest_Payload() {}
+
+func (*Request_Attest) isRequest_Payload() {}
+
+func (*Request_SetTime) isRequest_Payload() {}
+
 type Response struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Types that are valid to be assigned to Payload:
@@ -1505,8 +2964,15 @@ type Response struct {
 	//	*Response_Logs
 	//	*Response_InitInfo
 	//	*Response_DeleteKeys
+	//	*Response_UpdateCommit
+	//	*Response_Pop
+	//	*Response_Health
+	//	*Response_Audit
+	//	*Response_AuditVerify
+	//	*Response_Attest
 	//	*Response_Ok
 	//	*Response_Error
+	//	*Response_SetTime
 	Payload       isResponse_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -1514,7 +2980,7 @@ type Response struct {
 
 func (x *Response) Reset() {
 	*x = Response{}
-	mi := &file_signer_proto_msgTypes[24]
+	mi := &file_signer_proto_msgTypes[45]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1526,7 +2992,7 @@ func (x *Response) String() string {
 func (*Response) ProtoMessage() {}
 
 func (x *Response) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[24]
+	mi := &file_signer_proto_msgTypes[45]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1539,7 +3005,7 @@ func (x *Response) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Response.ProtoReflect.Descriptor instead.
 func (*Response) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{24}
+	return file_signer_proto_rawDescGZIP(), []int{45}
 }
 
 func (x *Response) GetPayload() isResponse_Payload {
@@ -1621,6 +3087,60 @@ func (x *Response) GetDeleteKeys() *DeleteKeysResponse {
 	return nil
 }
 
+func (x *Response) GetUpdateCommit() *UpdateCommitResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_UpdateCommit); ok {
+			return x.UpdateCommit
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetPop() *PopResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Pop); ok {
+			return x.Pop
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetHealth() *HealthResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Health); ok {
+			return x.Health
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetAudit() *AuditResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Audit); ok {
+			return x.Audit
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetAuditVerify() *AuditVerifyResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_AuditVerify); ok {
+			return x.AuditVerify
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetAttest() *AttestResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Attest); ok {
+			return x.Attest
+		}
+	}
+	return nil
+}
+
 func (x *Response) GetOk() *Ok {
 	if x != nil {
 		if x, ok := x.Payload.(*Response_Ok); ok {
@@ -1639,6 +3159,15 @@ func (x *Response) GetError() *Error {
 	return nil
 }
 
+func (x *Response) GetSetTime() *SetTimeResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_SetTime); ok {
+			return x.SetTime
+		}
+	}
+	return nil
+}
+
 type isResponse_Payload interface {
 	isResponse_Payload()
 }
@@ -1675,14 +3204,42 @@ type Response_DeleteKeys struct {
 	DeleteKeys *DeleteKeysResponse `protobuf:"bytes,8,opt,name=delete_keys,json=deleteKeys,proto3,oneof"`
 }
 
+type Response_UpdateCommit struct {
+	UpdateCommit *UpdateCommitResponse `protobuf:"bytes,9,opt,name=update_commit,json=updateCommit,proto3,oneof"`
+}
+
+type Response_Pop struct {
+	Pop *PopResponse `protobuf:"bytes,10,opt,name=pop,proto3,oneof"`
+}
+
+type Response_Health struct {
+	Health *HealthResponse `protobuf:"bytes,11,opt,name=health,proto3,oneof"`
+}
+
+type Response_Audit struct {
+	Audit *AuditResponse `protobuf:"bytes,12,opt,name=audit,proto3,oneof"`
+}
+
+type Response_AuditVerify struct {
+	AuditVerify *AuditVerifyResponse `protobuf:"bytes,13,opt,name=audit_verify,json=auditVerify,proto3,oneof"`
+}
+
+type Response_Attest struct {
+	Attest *AttestResponse `protobuf:"bytes,14,opt,name=attest,proto3,oneof"`
+}
+
 type Response_Ok struct {
-	Ok *Ok `protobuf:"bytes,15,opt,name=ok,proto3,oneof"` // for init_master & set_level
+	Ok *Ok `protobuf:"bytes,15,opt,name=ok,proto3,oneof"` // for init_master, set_level, ack_tamper & update begin/chunk
 }
 
 type Response_Error struct {
 	Error *Error `protobuf:"bytes,16,opt,name=error,proto3,oneof"`
 }
 
+type Response_SetTime struct {
+	SetTime *SetTimeResponse `protobuf:"bytes,17,opt,name=set_time,json=setTime,proto3,oneof"`
+}
+
 func (*Response_Unlock) isResponse_Payload() {}
 
 func (*Response_Lock) isResponse_Payload() {}
@@ -1699,10 +3256,24 @@ func (*Response_InitInfo) isResponse_Payload() {}
 
 func (*Response_DeleteKeys) isResponse_Payload() {}
 
+func (*Response_UpdateCommit) isResponse_Payload() {}
+
+func (*Response_Pop) isResponse_Payload() {}
+
+func (*Response_Health) isResponse_Payload() {}
+
+func (*Response_Audit) isResponse_Payload() {}
+
+func (*Response_AuditVerify) isResponse_Payload() {}
+
+func (*Response_Attest) isResponse_Payload() {}
+
 func (*Response_Ok) isResponse_Payload() {}
 
 func (*Response_Error) isResponse_Payload() {}
 
+func (*Response_SetTime) isResponse_Payload() {}
+
 var File_signer_proto protoreflect.FileDescriptor
 
 const file_signer_proto_rawDesc = "" +
@@ -1737,10 +3308,21 @@ const file_signer_proto_rawDesc = "" +
 	"\x10last_block_round\x18\x14 \x01(\rR\x0elastBlockRound\x12:\n" +
 	"\x19last_preattestation_round\x18\x15 \x01(\rR\x17lastPreattestationRound\x124\n" +
 	"\x16last_attestation_round\x18\x16 \x01(\rR\x14lastAttestationRound\x12'\n" +
-	"\x0fstate_corrupted\x18\x1e \x01(\bR\x0estateCorrupted\"\x0f\n" +
-	"\rStatusRequest\"7\n" +
+	"\x0fstate_corrupted\x18\x1e \x01(\bR\x0estateCorrupted\"\xdf\x01\n" +
+	"\fImageRelease\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x16\n" +
+	"\x06commit\x18\x02 \x01(\tR\x06commit\x12\x1d\n" +
+	"\n" +
+	"build_time\x18\x03 \x01(\tR\tbuildTime\x12\x18\n" +
+	"\aflavour\x18\x04 \x01(\tR\aflavour\x12\x14\n" +
+	"\x05board\x18\x05 \x01(\tR\x05board\x12\x16\n" +
+	"\x06layout\x18\x06 \x01(\tR\x06layout\x126\n" +
+	"\x17partition_layout_sha256\x18\a \x01(\tR\x15partitionLayoutSha256\"\x0f\n" +
+	"\rStatusRequest\"\x8a\x01\n" +
 	"\x0eStatusResponse\x12%\n" +
-	"\x04keys\x18\x01 \x03(\v2\x11.signer.KeyStatusR\x04keys\"9\n" +
+	"\x04keys\x18\x01 \x03(\v2\x11.signer.KeyStatusR\x04keys\x12!\n" +
+	"\fvault_sealed\x18\x02 \x01(\bR\vvaultSealed\x12.\n" +
+	"\arelease\x18\x03 \x01(\v2\x14.signer.ImageReleaseR\arelease\"9\n" +
 	"\vSignRequest\x12\x10\n" +
 	"\x03tz4\x18\x01 \x01(\tR\x03tz4\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\fR\amessage\",\n" +
@@ -1751,16 +3333,20 @@ const file_signer_proto_rawDesc = "" +
 	"\tbl_pubkey\x18\x02 \x01(\tR\bblPubkey\x12\x10\n" +
 	"\x03tz4\x18\x03 \x01(\tR\x03tz4\x12\x0e\n" +
 	"\x02ok\x18\x04 \x01(\bR\x02ok\x12\x14\n" +
-	"\x05error\x18\x05 \x01(\tR\x05error\"I\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\xa8\x01\n" +
 	"\x0eNewKeysRequest\x12\x17\n" +
 	"\akey_ids\x18\x01 \x03(\tR\x06keyIds\x12\x1e\n" +
 	"\n" +
 	"passphrase\x18\x02 \x01(\fR\n" +
-	"passphrase\"G\n" +
+	"passphrase\x12\x19\n" +
+	"\bhd_index\x18\x03 \x01(\rR\ahdIndex\x12\x1c\n" +
+	"\toverwrite\x18\x04 \x01(\bR\toverwrite\x12$\n" +
+	"\x0ereuse_hd_index\x18\x05 \x01(\bR\freuseHdIndex\"G\n" +
 	"\x0fNewKeysResponse\x124\n" +
-	"\aresults\x18\x01 \x03(\v2\x1a.signer.NewKeyPerKeyResultR\aresults\"#\n" +
+	"\aresults\x18\x01 \x03(\v2\x1a.signer.NewKeyPerKeyResultR\aresults\";\n" +
 	"\vLogsRequest\x12\x14\n" +
-	"\x05limit\x18\x01 \x01(\rR\x05limit\"$\n" +
+	"\x05limit\x18\x01 \x01(\rR\x05limit\x12\x16\n" +
+	"\x06memory\x18\x02 \x01(\bR\x06memory\"$\n" +
 	"\fLogsResponse\x12\x14\n" +
 	"\x05lines\x18\x01 \x03(\tR\x05lines\"Y\n" +
 	"\x11InitMasterRequest\x12$\n" +
@@ -1781,12 +3367,92 @@ const file_signer_proto_rawDesc = "" +
 	"passphrase\x18\x02 \x01(\fR\n" +
 	"passphrase\"D\n" +
 	"\x12DeleteKeysResponse\x12.\n" +
-	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\x14\n" +
+	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\x1e\n" +
+	"\n" +
+	"PopRequest\x12\x10\n" +
+	"\x03tz4\x18\x01 \x01(\tR\x03tz4\"\x1f\n" +
+	"\vPopResponse\x12\x10\n" +
+	"\x03pop\x18\x01 \x01(\tR\x03pop\"^\n" +
+	"\x12UpdateBeginRequest\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x04R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x02 \x01(\fR\x06sha256\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\"@\n" +
+	"\x12UpdateChunkRequest\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\x15\n" +
+	"\x13UpdateCommitRequest\"[\n" +
+	"\x14UpdateCommitResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12)\n" +
+	"\x10previous_version\x18\x02 \x01(\tR\x0fpreviousVersion\"\x0f\n" +
+	"\rHealthRequest\"\xff\x04\n" +
+	"\x0eHealthResponse\x12%\n" +
+	"\x0euptime_seconds\x18\x01 \x01(\x04R\ruptimeSeconds\x123\n" +
+	"\x15temperature_available\x18\x02 \x01(\bR\x14temperatureAvailable\x129\n" +
+	"\x18temperature_millicelsius\x18\x03 \x01(\x11R\x17temperatureMillicelsius\x12&\n" +
+	"\x0fdata_free_bytes\x18\x04 \x01(\x04R\rdataFreeBytes\x12(\n" +
+	"\x10data_total_bytes\x18\x05 \x01(\x04R\x0edataTotalBytes\x12\x1e\n" +
+	"\n" +
+	"goroutines\x18\x06 \x01(\rR\n" +
+	"goroutines\x12\x1f\n" +
+	"\vqueue_depth\x18\a \x01(\rR\n" +
+	"queueDepth\x12<\n" +
+	"\x1awatermark_persist_failures\x18\b \x01(\x04R\x18watermarkPersistFailures\x12\x18\n" +
+	"\aversion\x18\t \x01(\tR\aversion\x12.\n" +
+	"\x13mem_available_bytes\x18\n" +
+	" \x01(\x04R\x11memAvailableBytes\x12\x14\n" +
+	"\x05signs\x18\v \x01(\x04R\x05signs\x12!\n" +
+	"\fsign_rejects\x18\f \x01(\x04R\vsignRejects\x12A\n" +
+	"\x1dwatermark_persist_last_micros\x18\r \x01(\x04R\x1awatermarkPersistLastMicros\x12?\n" +
+	"\x1cwatermark_persist_max_micros\x18\x0e \x01(\x04R\x19watermarkPersistMaxMicros\"=\n" +
+	"\x10TelemetryRequest\x12)\n" +
+	"\x10interval_seconds\x18\x01 \x01(\rR\x0fintervalSeconds\"A\n" +
+	"\fAuditRequest\x12\x1b\n" +
+	"\tafter_seq\x18\x01 \x01(\x04R\bafterSeq\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\rR\x05limit\"=\n" +
+	"\rAuditResponse\x12\x18\n" +
+	"\arecords\x18\x01 \x03(\fR\arecords\x12\x12\n" +
+	"\x04more\x18\x02 \x01(\bR\x04more\"\x14\n" +
+	"\x12AuditVerifyRequest\"\x90\x01\n" +
+	"\x13AuditVerifyResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x1b\n" +
+	"\tfirst_seq\x18\x02 \x01(\x04R\bfirstSeq\x12\x19\n" +
+	"\blast_seq\x18\x03 \x01(\x04R\alastSeq\x12\x1b\n" +
+	"\thead_hash\x18\x04 \x01(\fR\bheadHash\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"%\n" +
+	"\rAttestRequest\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\fR\x05nonce\"|\n" +
+	"\x0eAttestResponse\x12.\n" +
+	"\x13identity_public_key\x18\x01 \x01(\fR\x11identityPublicKey\x12\x1c\n" +
+	"\tstatement\x18\x02 \x01(\fR\tstatement\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\"\xac\x01\n" +
+	"\x0fAttestStatement\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\fR\x05nonce\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"app_sha256\x18\x03 \x01(\fR\tappSha256\x12#\n" +
+	"\rconfig_sha256\x18\x04 \x01(\fR\fconfigSha256\x12%\n" +
+	"\x0ekernel_release\x18\x05 \x01(\tR\rkernelRelease\"O\n" +
+	"\x0eSetTimeRequest\x12\x1d\n" +
+	"\n" +
+	"unix_nanos\x18\x01 \x01(\x03R\tunixNanos\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x02 \x01(\fR\n" +
+	"passphrase\"[\n" +
+	"\x0fSetTimeResponse\x12.\n" +
+	"\x13previous_unix_nanos\x18\x01 \x01(\x03R\x11previousUnixNanos\x12\x18\n" +
+	"\astepped\x18\x02 \x01(\bR\astepped\"M\n" +
+	"\tTelemetry\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12.\n" +
+	"\x06health\x18\x02 \x01(\v2\x16.signer.HealthResponseR\x06health\"\x14\n" +
 	"\x02Ok\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\"5\n" +
 	"\x05Error\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\rR\x04code\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\"\x98\x04\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"2\n" +
+	"\x10AckTamperRequest\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x01 \x01(\fR\n" +
+	"passphrase\"\x81\t\n" +
 	"\aRequest\x12/\n" +
 	"\x06unlock\x18\x01 \x01(\v2\x15.signer.UnlockRequestH\x00R\x06unlock\x12)\n" +
 	"\x04lock\x18\x02 \x01(\v2\x13.signer.LockRequestH\x00R\x04lock\x12/\n" +
@@ -1800,8 +3466,20 @@ const file_signer_proto_rawDesc = "" +
 	"\tset_level\x18\t \x01(\v2\x17.signer.SetLevelRequestH\x00R\bsetLevel\x12<\n" +
 	"\vdelete_keys\x18\n" +
 	" \x01(\v2\x19.signer.DeleteKeysRequestH\x00R\n" +
-	"deleteKeysB\t\n" +
-	"\apayload\"\xee\x03\n" +
+	"deleteKeys\x12?\n" +
+	"\fupdate_begin\x18\v \x01(\v2\x1a.signer.UpdateBeginRequestH\x00R\vupdateBegin\x12?\n" +
+	"\fupdate_chunk\x18\f \x01(\v2\x1a.signer.UpdateChunkRequestH\x00R\vupdateChunk\x12B\n" +
+	"\rupdate_commit\x18\r \x01(\v2\x1b.signer.UpdateCommitRequestH\x00R\fupdateCommit\x12&\n" +
+	"\x03pop\x18\x0e \x01(\v2\x12.signer.PopRequestH\x00R\x03pop\x12/\n" +
+	"\x06health\x18\x0f \x01(\v2\x15.signer.HealthRequestH\x00R\x06health\x129\n" +
+	"\n" +
+	"ack_tamper\x18\x10 \x01(\v2\x18.signer.AckTamperRequestH\x00R\tackTamper\x128\n" +
+	"\ttelemetry\x18\x11 \x01(\v2\x18.signer.TelemetryRequestH\x00R\ttelemetry\x12,\n" +
+	"\x05audit\x18\x12 \x01(\v2\x14.signer.AuditRequestH\x00R\x05audit\x12?\n" +
+	"\faudit_verify\x18\x13 \x01(\v2\x1a.signer.AuditVerifyRequestH\x00R\vauditVerify\x12/\n" +
+	"\x06attest\x18\x14 \x01(\v2\x15.signer.AttestRequestH\x00R\x06attest\x123\n" +
+	"\bset_time\x18\x15 \x01(\v2\x16.signer.SetTimeRequestH\x00R\asetTimeB\t\n" +
+	"\apayload\"\xe7\x06\n" +
 	"\bResponse\x120\n" +
 	"\x06unlock\x18\x01 \x01(\v2\x16.signer.UnlockResponseH\x00R\x06unlock\x12*\n" +
 	"\x04lock\x18\x02 \x01(\v2\x14.signer.LockResponseH\x00R\x04lock\x120\n" +
@@ -1811,16 +3489,25 @@ const file_signer_proto_rawDesc = "" +
 	"\x04logs\x18\x06 \x01(\v2\x14.signer.LogsResponseH\x00R\x04logs\x127\n" +
 	"\tinit_info\x18\a \x01(\v2\x18.signer.InitInfoResponseH\x00R\binitInfo\x12=\n" +
 	"\vdelete_keys\x18\b \x01(\v2\x1a.signer.DeleteKeysResponseH\x00R\n" +
-	"deleteKeys\x12\x1c\n" +
+	"deleteKeys\x12C\n" +
+	"\rupdate_commit\x18\t \x01(\v2\x1c.signer.UpdateCommitResponseH\x00R\fupdateCommit\x12'\n" +
+	"\x03pop\x18\n" +
+	" \x01(\v2\x13.signer.PopResponseH\x00R\x03pop\x120\n" +
+	"\x06health\x18\v \x01(\v2\x16.signer.HealthResponseH\x00R\x06health\x12-\n" +
+	"\x05audit\x18\f \x01(\v2\x15.signer.AuditResponseH\x00R\x05audit\x12@\n" +
+	"\faudit_verify\x18\r \x01(\v2\x1b.signer.AuditVerifyResponseH\x00R\vauditVerify\x120\n" +
+	"\x06attest\x18\x0e \x01(\v2\x16.signer.AttestResponseH\x00R\x06attest\x12\x1c\n" +
 	"\x02ok\x18\x0f \x01(\v2\n" +
 	".signer.OkH\x00R\x02ok\x12%\n" +
-	"\x05error\x18\x10 \x01(\v2\r.signer.ErrorH\x00R\x05errorB\t\n" +
-	"\apayload*A\n" +
+	"\x05error\x18\x10 \x01(\v2\r.signer.ErrorH\x00R\x05error\x124\n" +
+	"\bset_time\x18\x11 \x01(\v2\x17.signer.SetTimeResponseH\x00R\asetTimeB\t\n" +
+	"\apayload*O\n" +
 	"\tLockState\x12\x1a\n" +
 	"\x16LOCK_STATE_UNSPECIFIED\x10\x00\x12\n" +
 	"\n" +
 	"\x06LOCKED\x10\x01\x12\f\n" +
-	"\bUNLOCKED\x10\x02B\x11Z\x0f./signer;signerb\x06proto3"
+	"\bUNLOCKED\x10\x02\x12\f\n" +
+	"\bTAMPERED\x10\x03B\x11Z\x0f./signer;signerb\x06proto3"
 
 var (
 	file_signer_proto_rawDescOnce sync.Once
@@ -1835,67 +3522,108 @@ func file_signer_proto_rawDescGZIP() []byte {
 }
 
 var file_signer_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 25)
+var file_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 46)
 var file_signer_proto_goTypes = []any{
-	(LockState)(0),             // 0: signer.LockState
-	(*PerKeyResult)(nil),       // 1: signer.PerKeyResult
-	(*UnlockRequest)(nil),      // 2: signer.UnlockRequest
-	(*UnlockResponse)(nil),     // 3: signer.UnlockResponse
-	(*LockRequest)(nil),        // 4: signer.LockRequest
-	(*LockResponse)(nil),       // 5: signer.LockResponse
-	(*KeyStatus)(nil),          // 6: signer.KeyStatus
-	(*StatusRequest)(nil),      // 7: signer.StatusRequest
-	(*StatusResponse)(nil),     // 8: signer.StatusResponse
-	(*SignRequest)(nil),        // 9: signer.SignRequest
-	(*SignResponse)(nil),       // 10: signer.SignResponse
-	(*NewKeyPerKeyResult)(nil), // 11: signer.NewKeyPerKeyResult
-	(*NewKeysRequest)(nil),     // 12: signer.NewKeysRequest
-	(*NewKeysResponse)(nil),    // 13: signer.NewKeysResponse
-	(*LogsRequest)(nil),        // 14: signer.LogsRequest
-	(*LogsResponse)(nil),       // 15: signer.LogsResponse
-	(*InitMasterRequest)(nil),  // 16: signer.InitMasterRequest
-	(*InitInfoRequest)(nil),    // 17: signer.InitInfoRequest
-	(*InitInfoResponse)(nil),   // 18: signer.InitInfoResponse
-	(*SetLevelRequest)(nil),    // 19: signer.SetLevelRequest
-	(*DeleteKeysRequest)(nil),  // 20: signer.DeleteKeysRequest
-	(*DeleteKeysResponse)(nil), // 21: signer.DeleteKeysResponse
-	(*Ok)(nil),                 // 22: signer.Ok
-	(*Error)(nil),              // 23: signer.Error
-	(*Request)(nil),            // 24: signer.Request
-	(*Response)(nil),           // 25: signer.Response
+	(LockState)(0),               // 0: signer.LockState
+	(*PerKeyResult)(nil),         // 1: signer.PerKeyResult
+	(*UnlockRequest)(nil),        // 2: signer.UnlockRequest
+	(*UnlockResponse)(nil),       // 3: signer.UnlockResponse
+	(*LockRequest)(nil),          // 4: signer.LockRequest
+	(*LockResponse)(nil),         // 5: signer.LockResponse
+	(*KeyStatus)(nil),            // 6: signer.KeyStatus
+	(*ImageRelease)(nil),         // 7: signer.ImageRelease
+	(*StatusRequest)(nil),        // 8: signer.StatusRequest
+	(*StatusResponse)(nil),       // 9: signer.StatusResponse
+	(*SignRequest)(nil),          // 10: signer.SignRequest
+	(*SignResponse)(nil),         // 11: signer.SignResponse
+	(*NewKeyPerKeyResult)(nil),   // 12: signer.NewKeyPerKeyResult
+	(*NewKeysRequest)(nil),       // 13: signer.NewKeysRequest
+	(*NewKeysResponse)(nil),      // 14: signer.NewKeysResponse
+	(*LogsRequest)(nil),          // 15: signer.LogsRequest
+	(*LogsResponse)(nil),         // 16: signer.LogsResponse
+	(*InitMasterRequest)(nil),    // 17: signer.InitMasterRequest
+	(*InitInfoRequest)(nil),      // 18: signer.InitInfoRequest
+	(*InitInfoResponse)(nil),     // 19: signer.InitInfoResponse
+	(*SetLevelRequest)(nil),      // 20: signer.SetLevelRequest
+	(*DeleteKeysRequest)(nil),    // 21: signer.DeleteKeysRequest
+	(*DeleteKeysResponse)(nil),   // 22: signer.DeleteKeysResponse
+	(*PopRequest)(nil),           // 23: signer.PopRequest
+	(*PopResponse)(nil),          // 24: signer.PopResponse
+	(*UpdateBeginRequest)(nil),   // 25: signer.UpdateBeginRequest
+	(*UpdateChunkRequest)(nil),   // 26: signer.UpdateChunkRequest
+	(*UpdateCommitRequest)(nil),  // 27: signer.UpdateCommitRequest
+	(*UpdateCommitResponse)(nil), // 28: signer.UpdateCommitResponse
+	(*HealthRequest)(nil),        // 29: signer.HealthRequest
+	(*HealthResponse)(nil),       // 30: signer.HealthResponse
+	(*TelemetryRequest)(nil),     // 31: signer.TelemetryRequest
+	(*AuditRequest)(nil),         // 32: signer.AuditRequest
+	(*AuditResponse)(nil),        // 33: signer.AuditResponse
+	(*AuditVerifyRequest)(nil),   // 34: signer.AuditVerifyRequest
+	(*AuditVerifyResponse)(nil),  // 35: signer.AuditVerifyResponse
+	(*AttestRequest)(nil),        // 36: signer.AttestRequest
+	(*AttestResponse)(nil),       // 37: signer.AttestResponse
+	(*AttestStatement)(nil),      // 38: signer.AttestStatement
+	(*SetTimeRequest)(nil),       // 39: signer.SetTimeRequest
+	(*SetTimeResponse)(nil),      // 40: signer.SetTimeResponse
+	(*Telemetry)(nil),            // 41: signer.Telemetry
+	(*Ok)(nil),                   // 42: signer.Ok
+	(*Error)(nil),                // 43: signer.Error
+	(*AckTamperRequest)(nil),     // 44: signer.AckTamperRequest
+	(*Request)(nil),              // 45: signer.Request
+	(*Response)(nil),             // 46: signer.Response
 }
 var file_signer_proto_depIdxs = []int32{
 	1,  // 0: signer.UnlockResponse.results:type_name -> signer.PerKeyResult
 	1,  // 1: signer.LockResponse.results:type_name -> signer.PerKeyResult
 	0,  // 2: signer.KeyStatus.lock_state:type_name -> signer.LockState
 	6,  // 3: signer.StatusResponse.keys:type_name -> signer.KeyStatus
-	11, // 4: signer.NewKeysResponse.results:type_name -> signer.NewKeyPerKeyResult
-	1,  // 5: signer.DeleteKeysResponse.results:type_name -> signer.PerKeyResult
-	2,  // 6: signer.Request.unlock:type_name -> signer.UnlockRequest
-	4,  // 7: signer.Request.lock:type_name -> signer.LockRequest
-	7,  // 8: signer.Request.status:type_name -> signer.StatusRequest
-	9,  // 9: signer.Request.sign:type_name -> signer.SignRequest
-	12, // 10: signer.Request.new_keys:type_name -> signer.NewKeysRequest
-	14, // 11: signer.Request.logs:type_name -> signer.LogsRequest
-	16, // 12: signer.Request.init_master:type_name -> signer.InitMasterRequest
-	17, // 13: signer.Request.init_info:type_name -> signer.InitInfoRequest
-	19, // 14: signer.Request.set_level:type_name -> signer.SetLevelRequest
-	20, // 15: signer.Request.delete_keys:type_name -> signer.DeleteKeysRequest
-	3,  // 16: signer.Response.unlock:type_name -> signer.UnlockResponse
-	5,  // 17: signer.Response.lock:type_name -> signer.LockResponse
-	8,  // 18: signer.Response.status:type_name -> signer.StatusResponse
-	10, // 19: signer.Response.sign:type_name -> signer.SignResponse
-	13, // 20: signer.Response.new_key:type_name -> signer.NewKeysResponse
-	15, // 21: signer.Response.logs:type_name -> signer.LogsResponse
-	18, // 22: signer.Response.init_info:type_name -> signer.InitInfoResponse
-	21, // 23: signer.Response.delete_keys:type_name -> signer.DeleteKeysResponse
-	22, // 24: signer.Response.ok:type_name -> signer.Ok
-	23, // 25: signer.Response.error:type_name -> signer.Error
-	26, // [26:26] is the sub-list for method output_type
-	26, // [26:26] is the sub-list for method input_type
-	26, // [26:26] is the sub-list for extension type_name
-	26, // [26:26] is the sub-list for extension extendee
-	0,  // [0:26] is the sub-list for field type_name
+	7,  // 4: signer.StatusResponse.release:type_name -> signer.ImageRelease
+	12, // 5: signer.NewKeysResponse.results:type_name -> signer.NewKeyPerKeyResult
+	1,  // 6: signer.DeleteKeysResponse.results:type_name -> signer.PerKeyResult
+	30, // 7: signer.Telemetry.health:type_name -> signer.HealthResponse
+	2,  // 8: signer.Request.unlock:type_name -> signer.UnlockRequest
+	4,  // 9: signer.Request.lock:type_name -> signer.LockRequest
+	8,  // 10: signer.Request.status:type_name -> signer.StatusRequest
+	10, // 11: signer.Request.sign:type_name -> signer.SignRequest
+	13, // 12: signer.Request.new_keys:type_name -> signer.NewKeysRequest
+	15, // 13: signer.Request.logs:type_name -> signer.LogsRequest
+	17, // 14: signer.Request.init_master:type_name -> signer.InitMasterRequest
+	18, // 15: signer.Request.init_info:type_name -> signer.InitInfoRequest
+	20, // 16: signer.Request.set_level:type_name -> signer.SetLevelRequest
+	21, // 17: signer.Request.delete_keys:type_name -> signer.DeleteKeysRequest
+	25, // 18: signer.Request.update_begin:type_name -> signer.UpdateBeginRequest
+	26, // 19: signer.Request.update_chunk:type_name -> signer.UpdateChunkRequest
+	27, // 20: signer.Request.update_commit:type_name -> signer.UpdateCommitRequest
+	23, // 21: signer.Request.pop:type_name -> signer.PopRequest
+	29, // 22: signer.Request.health:type_name -> signer.HealthRequest
+	44, // 23: signer.Request.ack_tamper:type_name -> signer.AckTamperRequest
+	31, // 24: signer.Request.telemetry:type_name -> signer.TelemetryRequest
+	32, // 25: signer.Request.audit:type_name -> signer.AuditRequest
+	34, // 26: signer.Request.audit_verify:type_name -> signer.AuditVerifyRequest
+	36, // 27: signer.Request.attest:type_name -> signer.AttestRequest
+	39, // 28: signer.Request.set_time:type_name -> signer.SetTimeRequest
+	3,  // 29: signer.Response.unlock:type_name -> signer.UnlockResponse
+	5,  // 30: signer.Response.lock:type_name -> signer.LockResponse
+	9,  // 31: signer.Response.status:type_name -> signer.StatusResponse
+	11, // 32: signer.Response.sign:type_name -> signer.SignResponse
+	14, // 33: signer.Response.new_key:type_name -> signer.NewKeysResponse
+	16, // 34: signer.Response.logs:type_name -> signer.LogsResponse
+	19, // 35: signer.Response.init_info:type_name -> signer.InitInfoResponse
+	22, // 36: signer.Response.delete_keys:type_name -> signer.DeleteKeysResponse
+	28, // 37: signer.Response.update_commit:type_name -> signer.UpdateCommitResponse
+	24, // 38: signer.Response.pop:type_name -> signer.PopResponse
+	30, // 39: signer.Response.health:type_name -> signer.HealthResponse
+	33, // 40: signer.Response.audit:type_name -> signer.AuditResponse
+	35, // 41: signer.Response.audit_verify:type_name -> signer.AuditVerifyResponse
+	37, // 42: signer.Response.attest:type_name -> signer.AttestResponse
+	42, // 43: signer.Response.ok:type_name -> signer.Ok
+	43, // 44: signer.Response.error:type_name -> signer.Error
+	40, // 45: signer.Response.set_time:type_name -> signer.SetTimeResponse
+	46, // [46:46] is the sub-list for method output_type
+	46, // [46:46] is the sub-list for method input_type
+	46, // [46:46] is the sub-list for extension type_name
+	46, // [46:46] is the sub-list for extension extendee
+	0,  // [0:46] is the sub-list for field type_name
 }
 
 func init() { file_signer_proto_init() }
@@ -1903,7 +3631,7 @@ func file_signer_proto_init() {
 	if File_signer_proto != nil {
 		return
 	}
-	file_signer_proto_msgTypes[23].OneofWrappers = []any{
+	file_signer_proto_msgTypes[44].OneofWrappers = []any{
 		(*Request_Unlock)(nil),
 		(*Request_Lock)(nil),
 		(*Request_Status)(nil),
@@ -1914,8 +3642,19 @@ func file_signer_proto_init() {
 		(*Request_InitInfo)(nil),
 		(*Request_SetLevel)(nil),
 		(*Request_DeleteKeys)(nil),
-	}
-	file_signer_proto_msgTypes[24].OneofWrappers = []any{
+		(*Request_UpdateBegin)(nil),
+		(*Request_UpdateChunk)(nil),
+		(*Request_UpdateCommit)(nil),
+		(*Request_Pop)(nil),
+		(*Request_Health)(nil),
+		(*Request_AckTamper)(nil),
+		(*Request_Telemetry)(nil),
+		(*Request_Audit)(nil),
+		(*Request_AuditVerify)(nil),
+		(*Request_Attest)(nil),
+		(*Request_SetTime)(nil),
+	}
+	file_signer_proto_msgTypes[45].OneofWrappers = []any{
 		(*Response_Unlock)(nil),
 		(*Response_Lock)(nil),
 		(*Response_Status)(nil),
@@ -1924,8 +3663,15 @@ func file_signer_proto_init() {
 		(*Response_Logs)(nil),
 		(*Response_InitInfo)(nil),
 		(*Response_DeleteKeys)(nil),
+		(*Response_UpdateCommit)(nil),
+		(*Response_Pop)(nil),
+		(*Response_Health)(nil),
+		(*Response_Audit)(nil),
+		(*Response_AuditVerify)(nil),
+		(*Response_Attest)(nil),
 		(*Response_Ok)(nil),
 		(*Response_Error)(nil),
+		(*Response_SetTime)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -1933,7 +3679,7 @@ func file_signer_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_signer_proto_rawDesc), len(file_signer_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   25,
+			NumMessages:   46,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// envWiFiPSK keeps the Wi-Fi passphrase off the command line.
+const envWiFiPSK = "TEZSIGN_WIFI_PSK"
+
+// wifiInterface is the interface the dev Wi-Fi brings up.
+const wifiInterface = "wlan0"
+
+var (
+	// WiFiInjectFiles bring the provisioned Wi-Fi up after first boot
+	// disabled networking.
+	WiFiInjectFiles = map[string]string{
+		"tools/builder/assets/tezsign-dev-wifi.sh":      "/usr/local/bin/tezsign-dev-wifi.sh",
+		"tools/builder/assets/tezsign-dev-wifi.service": "/etc/systemd/system/tezsign-dev-wifi.service",
+	}
+
+	WiFiAdjustPermissions = map[string]os.FileMode{
+		"/usr/local/bin/tezsign-dev-wifi.sh": 0700, // Only root can execute
+	}
+
+	WiFiCreateSymlinks = map[string]string{
+		"/etc/systemd/system/tezsign-dev-wifi.service": "/etc/systemd/system/multi-user.target.wants/tezsign-dev-wifi.service",
+	}
+
+	// wifiOverlays power the radio down; they are dropped from the board
+	// profile when Wi-Fi is configured.
+	wifiOverlays = []string{"disable-wifi", "radxa-zero3-disabled-wireless"}
+
+	hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+	countryRe  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// accessConfig provisions what developers used to set by hand after the
+// build. Only the hostname applies to prod images.
+type accessConfig struct {
+	// Hostname replaces "tezsign", set by first-boot-setup.sh.
+	Hostname string `yaml:"hostname"`
+	// AuthorizedKeys is a host file of SSH public keys for the dev user.
+	AuthorizedKeys string     `yaml:"authorized_keys"`
+	WiFi           wifiConfig `yaml:"wifi"`
+}
+
+type wifiConfig struct {
+	SSID string `yaml:"ssid"`
+	// PSK is the passphrase; $TEZSIGN_WIFI_PSK overrides it. Only the
+	// derived key goes into the image.
+	PSK     string `yaml:"psk"`
+	Country string `yaml:"country"` // regulatory domain, e.g. "DE"
+}
+
+func (c *buildConfig) validateAccess() []error {
+	var errs []error
+	a := c.Access
+	if a.Hostname != "" && !hostnameRe.MatchString(a.Hostname) {
+		errs = append(errs, fmt.Errorf("invalid hostname %q", a.Hostname))
+	}
+	if c.Flavour != DevImage {
+		if a.AuthorizedKeys != "" {
+			errs = append(errs, errors.New("authorized keys are only injected into dev images"))
+		}
+		if a.WiFi.SSID != "" {
+			errs = append(errs, errors.New("Wi-Fi is only provisioned on dev images"))
+		}
+	}
+	if a.AuthorizedKeys != "" {
+		if _, err := readAuthorizedKeys(a.AuthorizedKeys); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.WiFi.SSID != "" {
+		if len(a.WiFi.SSID) > 32 {
+			errs = append(errs, fmt.Errorf("Wi-Fi SSID %q is longer than 32 bytes", a.WiFi.SSID))
+		}
+		if n := len(a.WiFi.PSK); n < 8 || n > 63 {
+			errs = append(errs, fmt.Errorf("Wi-Fi passphrase must be 8 to 63 characters (set psk or $%s)", envWiFiPSK))
+		}
+		if a.WiFi.Country != "" && !countryRe.MatchString(a.WiFi.Country) {
+			errs = append(errs, fmt.Errorf("invalid Wi-Fi country %q (expected a two-letter code like DE)", a.WiFi.Country))
+		}
+	}
+	return errs
+}
+
+// applyAccess drops the overlays that would keep the configured Wi-Fi off.
+func (c *buildConfig) applyAccess() {
+	if psk := os.Getenv(envWiFiPSK); psk != "" {
+		c.Access.WiFi.PSK = psk
+	}
+	if c.Access.WiFi.SSID == "" {
+		return
+	}
+	for _, overlay := range wifiOverlays {
+		delete(c.Boot.Overlays, overlay)
+	}
+}
+
+// readAuthorizedKeys returns the keys in path, one per line, after
+// checking each parses.
+func readAuthorizedKeys(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys strings.Builder
+	for line := range strings.Lines(string(b)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys.WriteString(line + "\n")
+	}
+	if keys.Len() == 0 {
+		return nil, fmt.Errorf("%s has no keys", path)
+	}
+	return []byte(keys.String()), nil
+}
+
+// wpaSupplicantConf writes the network with the key wpa_passphrase would
+// derive, so the passphrase itself is not stored in the image.
+func wpaSupplicantConf(wifi wifiConfig) (string, error) {
+	psk, err := pbkdf2.Key(sha1.New, wifi.PSK, []byte(wifi.SSID), 4096, 32)
+	if err != nil {
+		return "", err
+	}
+	var conf strings.Builder
+	conf.WriteString("ctrl_interface=DIR=/run/wpa_supplicant GROUP=netdev\n")
+	if wifi.Country != "" {
+		conf.WriteString("country=" + wifi.Country + "\n")
+	}
+	fmt.Fprintf(&conf, "network={\n\tssid=%s\n\tpsk=%s\n}\n", hex.EncodeToString([]byte(wifi.SSID)), hex.EncodeToString(psk))
+	return conf.String(), nil
+}
+
+// installAccess writes the hostname, the dev user's authorized keys and the
+// dev Wi-Fi into the rootfs.
+func installAccess(rootfs partitionFS, cfg *buildConfig, logger *slog.Logger) error {
+	a := cfg.Access
+	if a.Hostname != "" {
+		logger.Info("Setting hostname", slog.String("hostname", a.Hostname))
+		if err := appendFile(rootfs, "/etc/default/tezsign", []byte("TEZSIGN_HOSTNAME="+a.Hostname+"\n")); err != nil {
+			return fmt.Errorf("failed to write /etc/default/tezsign: %w", err)
+		}
+	}
+
+	if a.AuthorizedKeys != "" {
+		keys, err := readAuthorizedKeys(a.AuthorizedKeys)
+		if err != nil {
+			return err
+		}
+		logger.Info("Injecting authorized keys for the dev user", slog.String("src", a.AuthorizedKeys))
+		// kept out of /home/dev, which enable-dev.sh creates on first boot
+		if err := rootfs.MkdirAll("/etc/ssh/authorized_keys"); err != nil {
+			return err
+		}
+		if err := rootfs.WriteFile("/etc/ssh/authorized_keys/dev", keys, 0644); err != nil {
+			return err
+		}
+		sshdConf := "AuthorizedKeysFile .ssh/authorized_keys /etc/ssh/authorized_keys/%u\n"
+		if err := rootfs.MkdirAll("/etc/ssh/sshd_config.d"); err != nil {
+			return err
+		}
+		if err := rootfs.WriteFile("/etc/ssh/sshd_config.d/tezsign-dev.conf", []byte(sshdConf), 0644); err != nil {
+			return err
+		}
+	}
+
+	if a.WiFi.SSID != "" {
+		logger.Info("Provisioning Wi-Fi", slog.String("ssid", a.WiFi.SSID), slog.String("interface", wifiInterface))
+		conf, err := wpaSupplicantConf(a.WiFi)
+		if err != nil {
+			return err
+		}
+		if err := rootfs.MkdirAll("/etc/wpa_supplicant"); err != nil {
+			return err
+		}
+		if err := rootfs.WriteFile("/etc/wpa_supplicant/wpa_supplicant-"+wifiInterface+".conf", []byte(conf), 0600); err != nil {
+			return err
+		}
+		network := fmt.Sprintf("[Match]\nName=%s\n\n[Network]\nDHCP=yes\n", wifiInterface)
+		if err := rootfs.MkdirAll("/etc/systemd/network"); err != nil {
+			return err
+		}
+		if err := rootfs.WriteFile("/etc/systemd/network/20-tezsign-wifi.network", []byte(network), 0644); err != nil {
+			return err
+		}
+		if err := injectFiles(rootfs, WiFiInjectFiles, WiFiCreateSymlinks, WiFiAdjustPermissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
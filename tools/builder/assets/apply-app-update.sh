@@ -0,0 +1,69 @@
+#!/bin/bash
+set -euo pipefail
+
+# Installs a gadget binary staged by tezsign over USB. The app has already
+# checked its digest and signature, but the staged files stay writable by
+# the tezsign user, so this copies them somewhere root-owned and checks the
+# signature again with the running binary before it writes the copy to the
+# inactive app slot and starts it on trial (see select-app-slot.sh). The
+# running slot is kept for rollback.
+STAGED="/data/tezsign/update/tezsign.staged"
+STAGED_SIG="${STAGED}.sig"
+TRIAL="/data/tezsign-slot/trial" # root-only; see select-app-slot.sh
+CONFIRMED="/data/tezsign/update/confirmed"
+APP_DIR="/app"
+
+if [[ ! -e "${STAGED}" && ! -L "${STAGED}" ]]; then
+  echo "Nothing staged."
+  exit 0
+fi
+# the staged files belong to the tezsign user; only regular files are taken
+if [[ ! -f "${STAGED}" || -L "${STAGED}" || -L "${STAGED_SIG}" ]]; then
+  echo "Staged update is not a regular file; discarded." >&2
+  rm -f "${STAGED}" "${STAGED_SIG}"
+  exit 1
+fi
+
+ACTIVE="$(cat "${APP_DIR}/slot" 2>/dev/null || echo a)"
+if [[ "${ACTIVE}" == "b" ]]; then
+  TARGET="a"
+  TARGET_FILE="${APP_DIR}/tezsign"
+else
+  ACTIVE="a"
+  TARGET="b"
+  TARGET_FILE="${APP_DIR}/tezsign.b"
+fi
+
+ACTIVE_FILE="${APP_DIR}/tezsign"
+[[ "${ACTIVE}" == "b" ]] && ACTIVE_FILE="${APP_DIR}/tezsign.b"
+
+WORK="$(mktemp -d /run/tezsign-update.XXXXXX)"
+trap 'rm -rf "${WORK}"' EXIT
+cp "${STAGED}" "${WORK}/tezsign"
+cp "${STAGED_SIG}" "${WORK}/tezsign.sig" 2>/dev/null || true
+rm -f "${STAGED}" "${STAGED_SIG}"
+if ! "${ACTIVE_FILE}" --verify-update "${WORK}/tezsign" "${WORK}/tezsign.sig"; then
+  echo "Staged binary failed signature verification; discarded." >&2
+  exit 1
+fi
+
+mount -o remount,rw "${APP_DIR}"
+trap 'sync; mount -o remount,ro "${APP_DIR}"; rm -rf "${WORK}"' EXIT
+
+cp "${WORK}/tezsign" "${TARGET_FILE}.new"
+chown root:root "${TARGET_FILE}.new"
+chmod 0755 "${TARGET_FILE}.new"
+sync "${TARGET_FILE}.new"
+mv -f "${TARGET_FILE}.new" "${TARGET_FILE}" # atomic on the same filesystem
+
+install -d -o root -g root -m 0700 "$(dirname "${TRIAL}")"
+echo "${TARGET} ${ACTIVE} 0" > "${TRIAL}.new"
+sync "${TRIAL}.new"
+mv -f "${TRIAL}.new" "${TRIAL}"
+rm -f "${CONFIRMED}" # a marker from before the install confirms nothing
+echo "${TARGET}" > "${APP_DIR}/slot.new"
+sync "${APP_DIR}/slot.new"
+mv -f "${APP_DIR}/slot.new" "${APP_DIR}/slot"
+
+echo "Installed new tezsign binary in slot ${TARGET} (previous: ${ACTIVE}); restarting."
+systemctl restart tezsign.service
@@ -0,0 +1,39 @@
+#!/bin/bash
+set -euo pipefail
+
+# Exposes the unformatted tail of the app partition to the gadget as a loop
+# device holding a second copy of its watermarks (runs as root before every
+# tezsign start). A data partition that is corrupted or swapped for an old
+# copy then cannot roll watermarks back on its own. Images built before the
+# reserve existed have a filesystem over the whole partition and get no
+# mirror.
+readonly APP_LABEL="app"
+readonly LINK="/run/tezsign/state-mirror"
+readonly MIRROR_BYTES=$((1024 * 1024)) # appStateMirrorSize in tools/builder
+
+if [[ -b "${LINK}" ]]; then
+  exit 0 # attached by an earlier start
+fi
+
+# A/B images mount app or app_b depending on the booted slot
+DEV="$(findmnt -no SOURCE /app 2>/dev/null || blkid -L "${APP_LABEL}" 2>/dev/null || true)"
+if [[ -z "${DEV}" ]]; then
+  echo "App partition not found; no state mirror."
+  exit 0
+fi
+
+PART_BYTES="$(blockdev --getsize64 "${DEV}")"
+read -r BLOCKS BLOCK_SIZE < <(dumpe2fs -h "${DEV}" 2>/dev/null | awk -F: '/^Block count/ {c=$2} /^Block size/ {s=$2} END {print c, s}')
+FS_BYTES=$((BLOCKS * BLOCK_SIZE))
+OFFSET=$((PART_BYTES - MIRROR_BYTES))
+if (( OFFSET < FS_BYTES )); then
+  echo "No room for a state mirror after the app filesystem on ${DEV}."
+  exit 0
+fi
+
+LOOP="$(losetup --find --show --offset "${OFFSET}" --sizelimit "${MIRROR_BYTES}" "${DEV}")"
+chown tezsign:tezsign "${LOOP}"
+chmod 0600 "${LOOP}"
+mkdir -p "$(dirname "${LINK}")"
+ln -sfn "${LOOP}" "${LINK}"
+echo "State mirror attached at ${LOOP} (${DEV} offset ${OFFSET})."
@@ -62,12 +62,45 @@ chown registrar:registrar /usr/local/bin/ffs_registrar # claim registrar binary
 echo "[+] Users configured."
 
 ### 4) Device identity / hostname / serial
-hostnamectl set-hostname tezsign
+# the builder may set another hostname (access.hostname)
+HOSTNAME_NEW="tezsign"
+if [[ -f /etc/default/tezsign ]]; then
+    v="$(sed -n 's/^TEZSIGN_HOSTNAME=//p' /etc/default/tezsign | tr -d '"')"
+    if [[ -n "${v}" ]]; then HOSTNAME_NEW="${v}"; fi
+fi
+hostnamectl set-hostname "${HOSTNAME_NEW}"
 echo "127.0.1.1 $(cat /etc/hostname)" >> /etc/hosts # ensure hostname resolves locally
 
 # Generate/persist device serial (goes to /app/tezsign_id)
 /usr/local/bin/generate-serial-number.sh >/dev/null
 
+### 4b) Factory provisioning bundle (builder option provision:)
+readonly PROVISION_DIR="/data/provision"
+readonly TEZSIGN_DATA_STORE="/data/tezsign"
+if [[ -d "${PROVISION_DIR}" ]]; then
+    echo "[*] Installing provisioning bundle..."
+    if [[ -f "${PROVISION_DIR}/tezsign.yaml" ]]; then
+        install -m 0644 -o root -g root "${PROVISION_DIR}/tezsign.yaml" /data/tezsign.yaml
+        echo "[+] Gadget configuration installed."
+    fi
+    if [[ -d "${PROVISION_DIR}/keystore" ]]; then
+        if [[ -e "${TEZSIGN_DATA_STORE}/keystore/master.json" ]]; then
+            echo "[!] The device has a keystore already; the bundled keystore is not imported."
+        else
+            mkdir -p "${TEZSIGN_DATA_STORE}/keystore"
+            cp -a "${PROVISION_DIR}/keystore/." "${TEZSIGN_DATA_STORE}/keystore/"
+            chown -R "${TEZSIGN_ID}:${TEZSIGN_ID}" "${TEZSIGN_DATA_STORE}/keystore"
+            chmod -R go-rwx "${TEZSIGN_DATA_STORE}/keystore"
+            echo "[+] Keystore imported."
+        fi
+    fi
+    # best effort on flash, but the keystore is encrypted anyway
+    find "${PROVISION_DIR}" -type f -exec shred -u {} +
+    rm -rf "${PROVISION_DIR}"
+    sync
+    echo "[+] Provisioning bundle removed."
+fi
+
 ### 5) Prepare read-only mounts for next boot
 echo "[*] Configuring root/boot as read-only for next boot..."
 # add 'ro,' to root and /boot lines in fstab (idempotent)
@@ -76,6 +109,14 @@ sed -i -E 's|^(\S+\s+/boot\s+\w+\s+)([^,]*)(.*)|\1ro,\2\3|' /etc/fstab
 sed -i -E 's|^(\S+\s+/boot/firmware\s+\w+\s+)([^,]*)(.*)|\1ro,\2\3|' /etc/fstab
 echo "[+] /etc/fstab updated."
 
+# Keep key material off the SD card: no disk-backed swap (the app refuses to
+# start with it). zram swap is RAM-backed and may stay.
+sed -i -E '\#^/dev/zram#!{/^\S+\s+\S+\s+swap\s/d}' /etc/fstab
+for dev in $(awk 'NR > 1 && $1 !~ /^\/dev\/zram/ { print $1 }' /proc/swaps); do
+    swapoff "${dev}" || true
+done
+echo "[+] Disk-backed swap disabled."
+
 ### 6) Lock down
 chmod u-s /usr/bin/sudo
 echo "Setuid bit removed from /usr/bin/sudo. Privilege escalation via sudo is disabled."
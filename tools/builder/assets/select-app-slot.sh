@@ -0,0 +1,73 @@
+#!/bin/bash
+set -euo pipefail
+
+# Picks the gadget binary to start (runs as root before every tezsign start).
+# The app partition holds two slots: /app/tezsign (a) and /app/tezsign.b (b);
+# /app/slot names the active one. A freshly installed slot is on trial until
+# the app confirms a start by creating CONFIRMED; if it fails to get there
+# MAX_ATTEMPTS times, the previous slot is restored. The trial record lives
+# in a root-only directory: the tezsign user owns /data/tezsign and could
+# otherwise point it at any file root then writes.
+readonly APP_DIR="/app"
+readonly STATE_DIR="/data/tezsign-slot"
+readonly TRIAL="${STATE_DIR}/trial"                   # "<slot> <previous slot> <attempts>"
+readonly CONFIRMED="/data/tezsign/update/confirmed" # created by the app; only ever removed here
+readonly RUN_LINK="/run/tezsign/app"
+readonly MAX_ATTEMPTS=3
+
+slot_file() {
+  case "$1" in
+    b) echo "${APP_DIR}/tezsign.b" ;;
+    *) echo "${APP_DIR}/tezsign" ;;
+  esac
+}
+
+set_active() {
+  mount -o remount,rw "${APP_DIR}"
+  echo "$1" > "${APP_DIR}/slot.new"
+  sync "${APP_DIR}/slot.new"
+  mv -f "${APP_DIR}/slot.new" "${APP_DIR}/slot"
+  sync
+  mount -o remount,ro "${APP_DIR}"
+}
+
+# write_trial replaces the trial record in one rename.
+write_trial() {
+  echo "$1" > "${TRIAL}.new"
+  sync "${TRIAL}.new"
+  mv -f "${TRIAL}.new" "${TRIAL}"
+}
+
+install -d -o root -g root -m 0700 "${STATE_DIR}"
+
+ACTIVE="$(cat "${APP_DIR}/slot" 2>/dev/null || echo a)"
+[[ "${ACTIVE}" == "b" && -x "$(slot_file b)" ]] || ACTIVE="a"
+
+# a marker from the previous start confirms it; rm does not follow links
+CONFIRMED_START=0
+if [[ -e "${CONFIRMED}" || -L "${CONFIRMED}" ]]; then
+  CONFIRMED_START=1
+  rm -f "${CONFIRMED}"
+fi
+
+if [[ -f "${TRIAL}" && "${CONFIRMED_START}" == 1 ]]; then
+  echo "Slot ${ACTIVE} confirmed."
+  rm -f "${TRIAL}"
+elif [[ -f "${TRIAL}" ]]; then
+  read -r T_SLOT T_PREV T_ATTEMPTS < "${TRIAL}" || true
+  if [[ "${T_SLOT:-}" != "${ACTIVE}" || ! "${T_PREV:-}" =~ ^[ab]$ || ! "${T_ATTEMPTS:-}" =~ ^[0-9]+$ ]]; then
+    echo "Ignoring stale trial record."
+    rm -f "${TRIAL}"
+  elif (( T_ATTEMPTS >= MAX_ATTEMPTS )); then
+    echo "Slot ${ACTIVE} failed ${T_ATTEMPTS} starts; rolling back to slot ${T_PREV}."
+    set_active "${T_PREV}"
+    ACTIVE="${T_PREV}"
+    rm -f "${TRIAL}"
+  else
+    write_trial "${T_SLOT} ${T_PREV} $((T_ATTEMPTS + 1))"
+    echo "Starting slot ${ACTIVE} on trial (attempt $((T_ATTEMPTS + 1)) of ${MAX_ATTEMPTS})."
+  fi
+fi
+
+mkdir -p "$(dirname "${RUN_LINK}")"
+ln -sfn "$(slot_file "${ACTIVE}")" "${RUN_LINK}"
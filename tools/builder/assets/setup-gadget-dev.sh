@@ -4,7 +4,7 @@ GADGET_DIR="/sys/kernel/config/usb_gadget/g1"
 MAC_ADDR="ae:d3:e6:cd:ff:f2"
 HOST_MAC_ADDR="ae:d3:e6:cd:ff:f3"
 
-echo "Adding ECM function to gadget..."
+echo "Adding ECM and ACM functions to gadget..."
 
 # 1. Create the ECM function directory
 ECM_FUNC_DIR="${GADGET_DIR}/functions/ecm.usb0"
@@ -16,4 +16,9 @@ echo "${HOST_MAC_ADDR}" > "${ECM_FUNC_DIR}/host_addr" # Set host MAC
 # 2. Link the new ECM function to the existing configuration
 #    !!! THIS IS THE CRITICAL MISSING STEP !!!
 CONF_DIR="${GADGET_DIR}/configs/c.1"
-ln -s "${ECM_FUNC_DIR}" "${CONF_DIR}"
\ No newline at end of file
+ln -s "${ECM_FUNC_DIR}" "${CONF_DIR}"
+
+# 3. Add a CDC-ACM serial function for the debug console (tezsign-console.service)
+ACM_FUNC_DIR="${GADGET_DIR}/functions/acm.GS0"
+mkdir -p "${ACM_FUNC_DIR}"
+ln -s "${ACM_FUNC_DIR}" "${CONF_DIR}"
\ No newline at end of file
@@ -20,6 +20,19 @@ readonly PRODUCT="tezsign-gadget"
 # --- Setup ---
 set -e # Exit on error
 
+# feature <ENV_NAME> <config key>: a feature setting from /etc/default/tezsign,
+# else from /data/tezsign.yaml, the same sources the app reads
+feature() {
+  local v=""
+  if [[ -f /etc/default/tezsign ]]; then
+    v="$(sed -n "s/^$1=//p" /etc/default/tezsign | tr -d '"')"
+  fi
+  if [[ -z "${v}" && -f /data/tezsign.yaml ]]; then
+    v="$(sed -n -E "s/^[[:space:]]*$2[[:space:]]*:[[:space:]]*[\"']?([^\"'#]*)[\"']?.*/\\1/p" /data/tezsign.yaml | tr -d ' ')"
+  fi
+  echo "${v}"
+}
+
 # 1. Create the gadget directory
 GADGET_DIR="/sys/kernel/config/usb_gadget/g1"
 mkdir -p "${GADGET_DIR}"
@@ -41,8 +54,30 @@ mkdir -p "${FFS_FUNC_DIR}"
 # 5. Create a configuration and link the function to it
 CONF_DIR="${GADGET_DIR}/configs/c.1"
 mkdir -p "${CONF_DIR}"
+echo 0xa0 > "${CONF_DIR}/bmAttributes" # bus powered, remote wakeup
 ln -s "${FFS_FUNC_DIR}" "${CONF_DIR}"
 
+# 6. Answer Microsoft OS descriptor requests so Windows binds WinUSB to the
+#    interfaces (compat IDs come from the FunctionFS descriptors)
+echo 1 > "${GADGET_DIR}/os_desc/use"
+echo 0xcd > "${GADGET_DIR}/os_desc/b_vendor_code"
+echo MSFT100 > "${GADGET_DIR}/os_desc/qw_sign"
+ln -s "${CONF_DIR}" "${GADGET_DIR}/os_desc"
+
+# 7. Optionally expose the audit logs as a read-only USB drive; the medium
+#    is filled in by tezsign-audit-export.sh
+case "$(feature TEZSIGN_AUDIT_EXPORT audit_export)" in
+1 | true | on | yes)
+  MSC_FUNC_DIR="${GADGET_DIR}/functions/mass_storage.audit"
+  mkdir -p "${MSC_FUNC_DIR}"
+  echo 1 > "${MSC_FUNC_DIR}/lun.0/removable"
+  echo 1 > "${MSC_FUNC_DIR}/lun.0/ro"
+  echo "TzC     tezsign audit" > "${MSC_FUNC_DIR}/lun.0/inquiry_string"
+  [[ -f /data/tezsign-audit.img ]] && echo /data/tezsign-audit.img > "${MSC_FUNC_DIR}/lun.0/file"
+  ln -s "${MSC_FUNC_DIR}" "${CONF_DIR}"
+  ;;
+esac
+
 mkdir -p /dev/ffs/tezsign
 mount -t functionfs tezsign /dev/ffs/tezsign
 
@@ -52,6 +87,45 @@ chown :dev_manager /dev/ffs/tezsign
 chown registrar:registrar /dev/ffs/tezsign/ep0
 chown tezsign:tezsign -R /data/tezsign # restore data permissions
 
+# The vault mount point is kept out of /data/tezsign (see tezsign-vault.sh);
+# the app needs it to exist while the vault is sealed
+case "$(feature TEZSIGN_VAULT vault)" in
+1 | true | on | yes)
+  [[ -L /data/tezsign-vault.d ]] && rm -f /data/tezsign-vault.d
+  install -d -o tezsign -g tezsign -m 0700 /data/tezsign-vault.d
+  ;;
+esac
+
+# Let the app drive a status LED (see TEZSIGN_LED)
+for led in /sys/class/leds/*; do
+  chown tezsign "${led}/trigger" "${led}/brightness" 2>/dev/null || true
+done
+
+# ... and an optional I2C status display (see TEZSIGN_DISPLAY)
+TEZSIGN_DISPLAY="$(feature TEZSIGN_DISPLAY display)"
+if [[ -n "${TEZSIGN_DISPLAY}" ]]; then
+  DISPLAY_BUS="$(echo "${TEZSIGN_DISPLAY}" | cut -s -d, -f2)"
+  chown tezsign "${DISPLAY_BUS:-/dev/i2c-1}" 2>/dev/null || true
+fi
+
+# ... and an optional case-open switch (see TEZSIGN_TAMPER_GPIO)
+TEZSIGN_TAMPER_GPIO="$(feature TEZSIGN_TAMPER_GPIO tamper_gpio)"
+if [[ -n "${TEZSIGN_TAMPER_GPIO}" ]]; then
+  TAMPER_PIN="${TEZSIGN_TAMPER_GPIO%%,*}"
+  [[ -d "/sys/class/gpio/gpio${TAMPER_PIN}" ]] || echo "${TAMPER_PIN}" > /sys/class/gpio/export || true
+  echo in > "/sys/class/gpio/gpio${TAMPER_PIN}/direction" 2>/dev/null || true
+  chown tezsign "/sys/class/gpio/gpio${TAMPER_PIN}/value" 2>/dev/null || true
+fi
+
+# ... and an optional confirmation button (see TEZSIGN_BUTTON)
+TEZSIGN_BUTTON="$(feature TEZSIGN_BUTTON button)"
+if [[ -n "${TEZSIGN_BUTTON}" ]]; then
+  BUTTON_PIN="${TEZSIGN_BUTTON%%,*}"
+  [[ -d "/sys/class/gpio/gpio${BUTTON_PIN}" ]] || echo "${BUTTON_PIN}" > /sys/class/gpio/export || true
+  echo in > "/sys/class/gpio/gpio${BUTTON_PIN}/direction" 2>/dev/null || true
+  chown tezsign "/sys/class/gpio/gpio${BUTTON_PIN}/value" 2>/dev/null || true
+fi
+
 # Tune performance
 echo schedutil | tee /sys/devices/system/cpu/cpu*/cpufreq/scaling_governor
 echo 600000 | tee /sys/devices/system/cpu/cpu*/cpufreq/scaling_min_freq
@@ -0,0 +1,55 @@
+#!/bin/bash
+# Rebuilds the FAT image behind the read-only audit drive (mass_storage.audit)
+# from DATA_STORE/audit and swaps it in. Does nothing unless setup-gadget.sh
+# created the function (TEZSIGN_AUDIT_EXPORT / audit_export).
+
+set -euo pipefail
+
+readonly LUN="/sys/kernel/config/usb_gadget/g1/functions/mass_storage.audit/lun.0"
+readonly AUDIT_DIR="/data/tezsign/audit"
+readonly IMAGE="/data/tezsign-audit.img"
+readonly IMAGE_SIZE="16M" # audit logs rotate at 4M + 4M, health at 1M + 1M
+
+[[ -d "${LUN}" ]] || exit 0
+
+TMP_IMAGE="${IMAGE}.new"
+MNT="$(mktemp -d)"
+cleanup() {
+  umount "${MNT}" 2>/dev/null || true
+  rmdir "${MNT}"
+  rm -f "${TMP_IMAGE}"
+}
+trap cleanup EXIT
+
+rm -f "${TMP_IMAGE}"
+truncate -s "${IMAGE_SIZE}" "${TMP_IMAGE}"
+mkfs.vfat -n TEZSIGN "${TMP_IMAGE}" >/dev/null
+mount -o loop "${TMP_IMAGE}" "${MNT}"
+
+# The logs belong to the tezsign user, who could swap one for a link to a
+# file only root may read; take regular files only and read them as tezsign.
+shopt -s nullglob
+for f in "${AUDIT_DIR}"/*.log "${AUDIT_DIR}"/*.log.1; do
+  [[ -f "${f}" && ! -L "${f}" ]] || continue
+  runuser -u tezsign -- cat -- "${f}" > "${MNT}/$(basename -- "${f}")"
+done
+{
+  echo "tezsign audit export"
+  echo "device:    $(cat /app/tezsign_id 2>/dev/null || echo unknown)"
+  echo "generated: $(date -u +%Y-%m-%dT%H:%M:%SZ)"
+  echo
+  echo "sign.log    one JSON line per sign request (signed or rejected)"
+  echo "health.log  periodic health snapshots"
+  echo "*.log.1     the previous file after rotation"
+} > "${MNT}/README.txt"
+( cd "${MNT}" && sha256sum -- * > SHA256SUMS )
+umount "${MNT}"
+
+# Swap the medium; the host sees the drive ejected and reinserted.
+if [[ -e "${LUN}/forced_eject" ]]; then
+  echo 1 > "${LUN}/forced_eject"
+else
+  echo > "${LUN}/file"
+fi
+mv "${TMP_IMAGE}" "${IMAGE}"
+echo "${IMAGE}" > "${LUN}/file"
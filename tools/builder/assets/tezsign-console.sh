@@ -0,0 +1,60 @@
+#!/bin/bash
+# Serial debug console on the CDC-ACM gadget function (dev images only).
+#
+# The line carries a read-only follow of the gadget log and a small menu of
+# fixed diagnostics. Input only selects a menu entry; it is never run.
+
+set -uo pipefail
+
+readonly LOG_FILE="/data/tezsign/gadget.log"
+readonly UNITS=(tezsign.service ffs_registrar.service setup-gadget.service attach-gadget.service)
+
+usage() {
+    cat <<'USAGE'
+tezsign debug console
+  logs     follow the gadget log (Enter to stop)
+  journal  last 100 journal lines of the tezsign units
+  status   state of the tezsign units
+  net      network interfaces
+  disk     mounts and free space
+  mem      memory usage
+  dmesg    last 100 kernel messages
+  help     this text
+USAGE
+}
+
+follow_logs() {
+    echo "[following ${LOG_FILE}; press Enter to return]"
+    tail -n 50 -F "${LOG_FILE}" 2>&1 &
+    local pid=$!
+    read -r _ || true
+    kill "${pid}" 2>/dev/null
+    wait "${pid}" 2>/dev/null
+}
+
+usage
+while true; do
+    printf 'tezsign> '
+    if ! IFS= read -r cmd; then
+        # the host closed the port; wait for the next session
+        sleep 1
+        continue
+    fi
+
+    case "${cmd//[[:space:]]/}" in
+    "") ;;
+    logs) follow_logs ;;
+    journal)
+        args=()
+        for u in "${UNITS[@]}"; do args+=(-u "${u}"); done
+        journalctl --no-pager -n 100 "${args[@]}"
+        ;;
+    status) systemctl --no-pager status "${UNITS[@]}" ;;
+    net) ip -brief addr ;;
+    disk) df -h ;;
+    mem) free -h ;;
+    dmesg) dmesg | tail -n 100 ;;
+    help) usage ;;
+    *) echo "unknown command: ${cmd} (try help)" ;;
+    esac
+done
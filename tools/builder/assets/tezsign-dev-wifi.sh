@@ -0,0 +1,20 @@
+#!/bin/bash
+# Brings up the Wi-Fi the builder provisioned on a dev image. first-boot-setup.sh
+# disables networking, so this runs after it on every boot.
+set -euo pipefail
+
+INTERFACE="wlan0"
+
+if command -v rfkill >/dev/null 2>&1; then
+  rfkill unblock wifi
+fi
+
+if [[ ! -e "/sys/class/net/${INTERFACE}" ]]; then
+  echo "Interface ${INTERFACE} not found"
+  exit 1
+fi
+
+/sbin/ip link set "${INTERFACE}" up
+systemctl start "wpa_supplicant@${INTERFACE}.service"
+systemctl start systemd-networkd.service
+echo "Wi-Fi started on ${INTERFACE}."
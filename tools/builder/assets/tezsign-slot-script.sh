@@ -0,0 +1,38 @@
+#!/bin/sh
+# initramfs-tools local-top: boot the rootfs slot named by the tezsign_env
+# partition of A/B images. Its first sector reads "TZENV1\nslot=<a|b>\n";
+# anything else boots slot a.
+PREREQ=""
+prereqs() { echo "${PREREQ}"; }
+case "$1" in
+prereqs)
+  prereqs
+  exit 0
+  ;;
+esac
+
+. /scripts/functions
+
+ENV_DEV="/dev/disk/by-partlabel/tezsign_env"
+i=0
+while [ "${i}" -lt "${ROOTDELAY:-30}" ]; do
+  [ -b "${ENV_DEV}" ] && break
+  sleep 1
+  i=$((i + 1))
+done
+[ -b "${ENV_DEV}" ] || exit 0 # not an A/B image
+
+SLOT="a"
+ENV="$(head -c 512 "${ENV_DEV}" | tr -d '\000')"
+case "${ENV}" in
+TZENV1*)
+  for line in ${ENV}; do
+    case "${line}" in
+    slot=b) SLOT="b" ;;
+    esac
+  done
+  ;;
+*) log_warning_msg "tezsign-slot: unrecognised env partition; booting slot a" ;;
+esac
+
+echo "ROOT=PARTLABEL=rootfs_${SLOT}" >> /conf/param.conf
@@ -0,0 +1,110 @@
+#!/bin/bash
+set -uo pipefail
+
+# Root helper for the encrypted keystore vault (see TEZSIGN_VAULT). Started
+# per connection by tezsign-vault.socket, which only the tezsign user can
+# reach. Protocol: one command line ("format" or "open"), then the
+# passphrase until EOF. Replies "ok" or "error: <reason>".
+#
+# Images built with an encrypted data partition keep the vault there
+# instead of in a container file. The builder leaves it with a provisioning
+# keyslot; format swaps that for the passphrase and links CONTAINER to the
+# partition, so the gadget sees the vault as created.
+readonly CONTAINER="/data/tezsign-vault.img"
+readonly DEVICE="/dev/disk/by-partlabel/tezsign_vault"
+readonly PROVISIONING_KEY="/data/tezsign-vault.key"
+readonly MAPPER="tezsign-vault"
+# Outside /data/tezsign, which the tezsign user owns and could otherwise
+# swap the mount point in for a link to any directory root then mounts over.
+readonly MOUNT_POINT="/data/tezsign-vault.d"
+readonly SIZE_MB=32
+
+reply() { echo "$*"; exit 0; }
+
+command -v cryptsetup >/dev/null 2>&1 || reply "error: cryptsetup is not installed"
+
+read -r CMD || reply "error: no command"
+# The passphrase is every byte up to EOF; $(...) alone would strip
+# trailing newlines.
+PASS="$(cat; printf x)"
+PASS="${PASS%x}"
+[[ -n "${PASS}" ]] || reply "error: passphrase required"
+
+# crypt <what> <cryptsetup args...>: runs cryptsetup with the passphrase on
+# stdin; on failure replies with cryptsetup's own message. Exit code 2 is
+# cryptsetup's "no permission", a wrong passphrase.
+crypt() {
+  local what="$1" out rc
+  shift
+  out="$(printf '%s' "${PASS}" | cryptsetup "$@" 2>&1)"
+  rc=$?
+  case "${rc}" in
+    0) return 0 ;;
+    2) reply "error: invalid passphrase" ;;
+    *) reply "error: ${what} failed (cryptsetup exit ${rc}): $(printf '%s' "${out}" | tr '\n' ' ' | cut -c1-200)" ;;
+  esac
+}
+
+# open_vault maps and mounts the vault. Only format (fresh) may create the
+# file system: an open that finds none must not wipe what is there.
+open_vault() {
+  local fresh="${1:-}"
+  if [[ ! -e "/dev/mapper/${MAPPER}" ]]; then
+    crypt "open" open --type luks2 --key-file=- "${CONTAINER}" "${MAPPER}"
+  fi
+  if ! blkid -p "/dev/mapper/${MAPPER}" >/dev/null 2>&1; then
+    [[ -n "${fresh}" ]] || reply "error: vault has no file system"
+    mkfs.ext4 -q -L tezsign-vault "/dev/mapper/${MAPPER}" || reply "error: mkfs failed"
+  fi
+  if [[ -L "${MOUNT_POINT}" || (-e "${MOUNT_POINT}" && ! -d "${MOUNT_POINT}") ]]; then
+    reply "error: ${MOUNT_POINT} is not a directory"
+  fi
+  mkdir -p -m 0700 "${MOUNT_POINT}"
+  if ! mountpoint -q "${MOUNT_POINT}"; then
+    mount -o nodev,nosuid,noexec,data=journal "/dev/mapper/${MAPPER}" "${MOUNT_POINT}" ||
+      reply "error: mount failed"
+  fi
+  chown -h tezsign:tezsign "${MOUNT_POINT}"
+  chmod 0700 "${MOUNT_POINT}"
+}
+
+enroll_device() {
+  [[ -f "${PROVISIONING_KEY}" ]] || reply "error: no provisioning key for ${DEVICE}"
+  crypt "luksAddKey" luksAddKey --batch-mode --pbkdf argon2id --pbkdf-memory 131072 \
+    --key-file="${PROVISIONING_KEY}" "${DEVICE}" /dev/stdin
+  cryptsetup luksRemoveKey --batch-mode "${DEVICE}" "${PROVISIONING_KEY}" ||
+    reply "error: cannot remove the provisioning keyslot"
+  shred -u "${PROVISIONING_KEY}" 2>/dev/null || rm -f "${PROVISIONING_KEY}"
+  ln -sfn "${DEVICE}" "${CONTAINER}"
+  sync
+}
+
+case "${CMD}" in
+  format)
+    [[ -e "${CONTAINER}" ]] && reply "error: vault already exists"
+    if [[ -b "${DEVICE}" ]]; then
+      enroll_device
+      open_vault fresh
+      reply "ok"
+    fi
+    truncate -s "${SIZE_MB}M" "${CONTAINER}.new" && chmod 0600 "${CONTAINER}.new" ||
+      reply "error: cannot create container"
+    trap 'rm -f "${CONTAINER}.new"' EXIT
+    # Cap Argon2 memory so the smallest boards can still open the vault.
+    crypt "luksFormat" luksFormat --batch-mode --type luks2 \
+      --pbkdf argon2id --pbkdf-memory 131072 --key-file=- "${CONTAINER}.new"
+    trap - EXIT
+    mv -f "${CONTAINER}.new" "${CONTAINER}"
+    sync
+    open_vault fresh
+    reply "ok"
+    ;;
+  open)
+    [[ -e "${CONTAINER}" ]] || reply "error: no vault"
+    open_vault
+    reply "ok"
+    ;;
+  *)
+    reply "error: unknown command"
+    ;;
+esac
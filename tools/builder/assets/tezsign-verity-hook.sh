@@ -0,0 +1,16 @@
+#!/bin/sh
+# initramfs-tools hook: ship veritysetup and dm-verity for the
+# tezsign-verity local-top script.
+PREREQ=""
+prereqs() { echo "${PREREQ}"; }
+case "$1" in
+prereqs)
+  prereqs
+  exit 0
+  ;;
+esac
+
+. /usr/share/initramfs-tools/hook-functions
+
+copy_exec "$(command -v veritysetup)" /sbin
+manual_add_modules dm-verity
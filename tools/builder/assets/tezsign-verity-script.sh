@@ -0,0 +1,42 @@
+#!/bin/sh
+# initramfs-tools local-top: open the rootfs through dm-verity when the
+# builder put tezsign.verity=<root hash>,<hash offset> on the cmdline.
+PREREQ=""
+prereqs() { echo "${PREREQ}"; }
+case "$1" in
+prereqs)
+  prereqs
+  exit 0
+  ;;
+esac
+
+. /scripts/functions
+
+VERITY=""
+for arg in $(cat /proc/cmdline); do
+  case "${arg}" in
+  tezsign.verity=*) VERITY="${arg#tezsign.verity=}" ;;
+  esac
+done
+[ -n "${VERITY}" ] || exit 0
+
+ROOT_HASH="${VERITY%%,*}"
+HASH_OFFSET="${VERITY#*,}"
+
+modprobe dm-verity 2>/dev/null || true
+
+# wait for the root device like local_device_setup would
+DEV=""
+i=0
+while [ "${i}" -lt "${ROOTDELAY:-30}" ]; do
+  DEV="$(resolve_device "${ROOT}")"
+  [ -b "${DEV}" ] && break
+  sleep 1
+  i=$((i + 1))
+done
+[ -b "${DEV}" ] || panic "tezsign-verity: root device ${ROOT} not found"
+
+veritysetup open "${DEV}" vroot "${DEV}" "${ROOT_HASH}" --hash-offset="${HASH_OFFSET}" ||
+  panic "tezsign-verity: rootfs does not match the image root hash"
+
+echo "ROOT=/dev/mapper/vroot" >> /conf/param.conf
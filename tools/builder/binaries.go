@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// imageBinary is a Go program the image carries. The configure stage
+// injects Asset, so building it in place makes the build one command.
+type imageBinary struct {
+	Name    string
+	Package string
+	Asset   string
+	// CGO is set for the gadget, whose BLS signatures link blst; the cross
+	// compiler comes from $CC (zig in the builder container).
+	CGO bool
+}
+
+var imageBinaries = []imageBinary{
+	{Name: "tezsign", Package: "./app/gadget", Asset: "tools/builder/assets/tezsign", CGO: true},
+	{Name: "ffs_registrar", Package: "./app/ffs_registrar", Asset: "tools/builder/assets/ffs_registrar"},
+}
+
+// binariesConfig controls the binaries injected into the image.
+type binariesConfig struct {
+	// Build cross-compiles them for arm64 from this checkout before the
+	// image is built, instead of using the ones already in the assets.
+	Build bool `yaml:"build"`
+	// SHA256 pins the expected digest of a binary by name; a mismatch fails
+	// the build, built or not.
+	SHA256 map[string]string `yaml:"sha256"`
+}
+
+// prepareBinaries builds the binaries when configured and checks their
+// digests.
+func prepareBinaries(cfg *buildConfig, logger *slog.Logger) error {
+	for _, b := range imageBinaries {
+		if cfg.Binaries.Build {
+			if err := buildBinary(b, logger); err != nil {
+				return err
+			}
+		}
+		sum, _, err := fileDigest(b.Asset)
+		if err != nil {
+			return fmt.Errorf("binary %s: %w", b.Name, err)
+		}
+		if want, ok := cfg.Binaries.SHA256[b.Name]; ok && want != sum {
+			return fmt.Errorf("binary %s has sha256 %s, expected %s", b.Name, sum, want)
+		}
+		logger.Info("Using binary", slog.String("name", b.Name), slog.String("path", b.Asset), slog.String("sha256", sum))
+	}
+	return nil
+}
+
+// buildBinary runs the same go build as the manual steps in tools/readme.md.
+func buildBinary(b imageBinary, logger *slog.Logger) error {
+	logger.Info("Building binary", slog.String("name", b.Name), slog.String("package", b.Package))
+	cgo := "0"
+	if b.CGO {
+		cgo = "1"
+	}
+	cmd := exec.Command("go", "build", "-buildvcs=false", "-trimpath",
+		`-ldflags=-s -w -extldflags "-static"`,
+		"-o", b.Asset, b.Package)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=arm64", "CGO_ENABLED="+cgo)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build %s: %w, output: %s", b.Package, err, out)
+	}
+	return nil
+}
+
+// binaryDigests returns the sha256 of each binary for the manifest.
+func binaryDigests() (map[string]string, error) {
+	digests := map[string]string{}
+	for _, b := range imageBinaries {
+		sum, _, err := fileDigest(b.Asset)
+		if err != nil {
+			return nil, fmt.Errorf("binary %s: %w", b.Name, err)
+		}
+		digests[b.Name] = sum
+	}
+	return digests, nil
+}
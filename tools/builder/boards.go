@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type bootLayout string
+
+const (
+	// BootLayoutAuto takes the second partition as rootfs when there is
+	// one, else the first.
+	BootLayoutAuto bootLayout = "auto"
+	// BootLayoutSeparate has a FAT boot partition ahead of the rootfs.
+	BootLayoutSeparate bootLayout = "separate"
+	// BootLayoutRootfs boots from /boot on the only partition.
+	BootLayoutRootfs bootLayout = "rootfs"
+)
+
+// boardProfile holds what differs between the boards we build for: where
+// the rootfs lives, which overlays put the OTG port in peripheral mode and
+// which kernel arguments it needs.
+type boardProfile struct {
+	Description string
+	Layout      bootLayout
+	Overlays    map[string]string // overlay → options
+	ArmbianEnv  map[string]string
+	ConfigTxt   map[string]string
+	Cmdline     []string // kernel arguments
+}
+
+const defaultBoard = "generic"
+
+var boardProfiles = map[string]boardProfile{
+	// generic activates every overlay we know of; only the ones the image
+	// ships a .dtbo for are applied.
+	defaultBoard: {
+		Description: "any supported board (all known overlays)",
+		Layout:      BootLayoutAuto,
+		Overlays:    ArmbianActivateOverlays,
+		ConfigTxt:   map[string]string{"otg_mode": "0"},
+	},
+	"radxa-zero3": {
+		Description: "Radxa Zero 3W/3E (RK3566, DWC3)",
+		Layout:      BootLayoutAuto, // binman images have a single partition
+		Overlays: map[string]string{
+			"radxa-zero3-disabled-ethernet": "",
+			"radxa-zero3-disabled-wireless": "",
+			"rk3568-dwc3-peripheral":        "",
+		},
+	},
+	"rpi-zero2w": {
+		Description: "Raspberry Pi Zero 2 W (DWC2)",
+		Layout:      BootLayoutSeparate,
+		Overlays: map[string]string{
+			"dwc2":         "dr_mode=peripheral",
+			"disable-bt":   "",
+			"disable-wifi": "",
+		},
+		ConfigTxt: map[string]string{"otg_mode": "0"},
+		Cmdline:   []string{"modules-load=dwc2"},
+	},
+	"orangepi-zero": {
+		Description: "Orange Pi Zero (H2+/H3, MUSB)",
+		Layout:      BootLayoutRootfs,
+		// The mainline device tree already puts the OTG port in
+		// peripheral mode; no overlay needed.
+	},
+}
+
+// boardNames lists the profiles for usage messages.
+func boardNames() string {
+	return strings.Join(slices.Sorted(maps.Keys(boardProfiles)), ", ")
+}
+
+// applyBoard resolves the board profile and lays the configured boot
+// settings over it.
+func (c *buildConfig) applyBoard() error {
+	p, ok := boardProfiles[c.Board]
+	if !ok {
+		return fmt.Errorf("unknown board %q (valid: %s)", c.Board, boardNames())
+	}
+	c.Boot.Overlays = overlaid(p.Overlays, c.Boot.Overlays)
+	c.Boot.ArmbianEnv = overlaid(p.ArmbianEnv, c.Boot.ArmbianEnv)
+	c.Boot.ConfigTxt = overlaid(p.ConfigTxt, c.Boot.ConfigTxt)
+	c.Boot.Cmdline = append(slices.Clone(p.Cmdline), c.Boot.Cmdline...)
+	if c.Boot.Layout == "" {
+		c.Boot.Layout = p.Layout
+	}
+	return nil
+}
+
+// overlaid returns base with over applied; "-" drops a key.
+func overlaid(base, over map[string]string) map[string]string {
+	out := maps.Clone(base)
+	if out == nil {
+		out = map[string]string{}
+	}
+	for k, v := range over {
+		if v == "-" {
+			delete(out, k)
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
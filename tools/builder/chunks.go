@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/ulikunitz/xz"
+)
+
+// chunkXZConfig compresses one chunk; a dictionary the size of a chunk
+// is all it can use.
+var chunkXZConfig = xz.WriterConfig{
+	Properties: xzConfig.Properties,
+	DictCap:    common.ChunkSize,
+	BufSize:    xzConfig.BufSize,
+	BlockSize:  common.ChunkSize,
+	CheckSum:   xz.CRC64,
+}
+
+// pendingChunk is a chunk of the raw image that goes into the pack.
+type pendingChunk struct {
+	data   []byte
+	packed []byte // nil when the pack has the content already
+}
+
+// writeChunks writes the chunk pack and index of a delta update (see
+// tools/common/chunks.go) next to output and returns the index file for
+// the manifest. Chunks are compressed in parallel and written in image
+// order, so the pack is the same on every build of the same image.
+func writeChunks(rawPath, output string, logger *slog.Logger) (*common.ManifestFile, error) {
+	img, err := diskfs.Open(rawPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return nil, errors.Join(common.ErrFailedToOpenImage, err)
+	}
+	boot, rootfs, app, _, err := common.GetTezsignPartitions(img)
+	img.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.Open(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	packPath := output + common.ChunkPackSuffix
+	pack, err := os.Create(packPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pack.Close()
+
+	cw := &chunkWriter{pack: pack, seen: map[string]common.Chunk{}}
+	idx := common.ChunkIndex{ChunkSize: common.ChunkSize}
+
+	head := make([]byte, common.ChunkHeadSize)
+	if _, err := io.ReadFull(raw, head); err != nil {
+		return nil, fmt.Errorf("failed to read the image head: %w", err)
+	}
+	heads, err := cw.add([]*pendingChunk{{data: head}})
+	if err != nil {
+		return nil, err
+	}
+	idx.Head = heads[0]
+
+	for _, p := range []part.Partition{boot, rootfs, app} {
+		if p == nil {
+			continue
+		}
+		logger.Info("Writing delta chunks", slog.Int64("offset", p.GetStart()), slog.Int64("bytes", p.GetSize()))
+		cp, err := cw.partition(raw, p)
+		if err != nil {
+			return nil, err
+		}
+		idx.Partitions = append(idx.Partitions, cp)
+	}
+	if err := pack.Close(); err != nil {
+		return nil, err
+	}
+	if idx.Pack, err = manifestFile(packPath); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return nil, err
+	}
+	indexPath := output + common.ChunkIndexSuffix
+	if err := os.WriteFile(indexPath, append(b, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	index, err := manifestFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Wrote delta chunks", slog.String("pack", filepath.Base(packPath)), slog.Int64("pack_bytes", idx.Pack.Size), slog.Int("unique_chunks", len(cw.seen)))
+	return &index, nil
+}
+
+// chunkWriter appends chunks to the pack, each content once.
+type chunkWriter struct {
+	pack *os.File
+	off  int64
+	seen map[string]common.Chunk // by sha256
+}
+
+// partition cuts p into chunks, a batch per round of compression.
+func (cw *chunkWriter) partition(raw io.ReaderAt, p part.Partition) (common.ChunkPartition, error) {
+	cp := common.ChunkPartition{Start: p.GetStart(), Size: p.GetSize()}
+	h := sha256.New()
+	batch := runtime.NumCPU() * 2
+	progress := startProgress(fmt.Sprintf("delta chunks at %d", p.GetStart()), p.GetSize())
+	defer progress.Finish()
+	src := progress.Reader(io.NewSectionReader(raw, p.GetStart(), p.GetSize()))
+	for done := int64(0); done < cp.Size; {
+		var pending []*pendingChunk
+		for len(pending) < batch && done < cp.Size {
+			c := &pendingChunk{data: make([]byte, min(common.ChunkSize, cp.Size-done))}
+			if _, err := io.ReadFull(src, c.data); err != nil {
+				return cp, fmt.Errorf("failed to read the partition at %d: %w", cp.Start, err)
+			}
+			h.Write(c.data)
+			pending = append(pending, c)
+			done += int64(len(c.data))
+		}
+		chunks, err := cw.add(pending)
+		if err != nil {
+			return cp, err
+		}
+		cp.Chunks = append(cp.Chunks, chunks...)
+	}
+	cp.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return cp, nil
+}
+
+// add compresses the chunks not in the pack yet and appends them in order.
+func (cw *chunkWriter) add(pending []*pendingChunk) ([]common.Chunk, error) {
+	sums := make([]string, len(pending))
+	var wg sync.WaitGroup
+	errs := make([]error, len(pending))
+	queued := map[string]bool{}
+	for i, c := range pending {
+		sum := sha256.Sum256(c.data)
+		sums[i] = hex.EncodeToString(sum[:])
+		if _, ok := cw.seen[sums[i]]; ok || queued[sums[i]] {
+			continue
+		}
+		queued[sums[i]] = true
+		wg.Go(func() {
+			var buf bytes.Buffer
+			w, err := chunkXZConfig.NewWriter(&buf)
+			if err == nil {
+				_, err = w.Write(c.data)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			c.packed, errs[i] = buf.Bytes(), err
+		})
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("failed to compress a chunk: %w", err)
+	}
+
+	chunks := make([]common.Chunk, len(pending))
+	for i, c := range pending {
+		if c.packed != nil {
+			if _, err := cw.pack.Write(c.packed); err != nil {
+				return nil, err
+			}
+			cw.seen[sums[i]] = common.Chunk{SHA256: sums[i], Offset: cw.off, Length: int64(len(c.packed))}
+			cw.off += int64(len(c.packed))
+		}
+		chunks[i] = cw.seen[sums[i]]
+	}
+	return chunks, nil
+}
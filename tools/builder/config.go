@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+type compression string
+
+const (
+	CompressionXZ   compression = "xz"
+	CompressionNone compression = "none"
+)
+
+// buildConfig describes one image build. Anything left out of build.yaml
+// keeps the defaults from constants.go.
+type buildConfig struct {
+	Source      string       `yaml:"source"`
+	Output      string       `yaml:"output"`
+	Flavour     imageFlavour `yaml:"flavour"`
+	Board       string       `yaml:"board"`
+	SkipWait    bool         `yaml:"skip_wait"`
+	Compression compression  `yaml:"compression"`
+	// SourceDateEpoch stamps every file the build writes (see
+	// reproducible.go); 0 takes $SOURCE_DATE_EPOCH or the source image's
+	// modification time.
+	SourceDateEpoch int64 `yaml:"source_date_epoch"`
+	// Version and Commit go into /etc/tezsign-release and the signed
+	// manifest (see release.go). Version defaults to $IMAGE_ID, Commit to
+	// $GIT_COMMIT or the HEAD of the checkout.
+	Version string `yaml:"version"`
+	Commit  string `yaml:"commit"`
+	// SignKey is a minisign secret key or PEM ed25519 key; when set the
+	// output gets a detached signature and a signed manifest (see sign.go).
+	SignKey string `yaml:"sign_key"`
+
+	Partitions partitionConfig `yaml:"partitions"`
+	Rootfs     rootfsConfig    `yaml:"rootfs"`
+	Boot       bootConfig      `yaml:"boot"`
+
+	// Files and AppFiles are injected next to the built-in assets
+	// (host path → path in the rootfs or app partition).
+	Files    map[string]string `yaml:"files"`
+	AppFiles map[string]string `yaml:"app_files"`
+	// Modules are loaded at boot next to the USB gadget modules.
+	Modules []string `yaml:"modules"`
+	// Binaries are the gadget programs injected into the image.
+	Binaries binariesConfig `yaml:"binaries"`
+	// Access sets the hostname and, on dev images, SSH keys and Wi-Fi
+	// (see access.go).
+	Access accessConfig `yaml:"access"`
+	// Inject copies host files and directories into any partition with
+	// the given ownership and mode (see inject.go).
+	Inject []injection `yaml:"inject"`
+	// Provision is a bundle directory the device installs on first boot
+	// (see provision.go).
+	Provision string `yaml:"provision"`
+
+	// seed makes the build's UUIDs and salts; set from the source image.
+	seed []byte
+}
+
+type partitionLayout string
+
+const (
+	PartitionLayoutSingle partitionLayout = "single"
+	// PartitionLayoutAB duplicates the rootfs and app partitions and adds
+	// an env partition naming the slot to boot (see slots.go).
+	PartitionLayoutAB partitionLayout = "ab"
+)
+
+type partitionConfig struct {
+	Layout partitionLayout `yaml:"layout"`
+	AppMB  uint64          `yaml:"app_mb"`
+	DataMB uint64          `yaml:"data_mb"`
+	// EncryptedDataMB adds a LUKS2 partition the gadget opens as its
+	// keystore vault at unlock (see vault.go); 0 for none.
+	EncryptedDataMB uint64 `yaml:"encrypted_data_mb"`
+}
+
+type rootfsConfig struct {
+	// Verity protects the rootfs with a dm-verity hash tree whose root
+	// hash goes on the kernel cmdline (see verity.go).
+	Verity bool `yaml:"verity"`
+	// Minimize shrinks the rootfs to its content plus HeadroomMB and zeroes
+	// the free space of the ext4 partitions (see minimize.go).
+	Minimize   bool   `yaml:"minimize"`
+	HeadroomMB uint64 `yaml:"headroom_mb"`
+}
+
+// bootConfig is laid over the board profile (see boards.go).
+type bootConfig struct {
+	// Layout overrides where the profile expects the rootfs.
+	Layout bootLayout `yaml:"layout"`
+	// Overlays maps overlay names to their options ("" for none). Entries
+	// are added to the profile's; an overlay set to "-" is dropped.
+	Overlays map[string]string `yaml:"overlays"`
+	// ArmbianEnv and ConfigTxt are key=value edits for armbianEnv.txt and
+	// the Pi firmware config.txt ("-" drops a profile edit).
+	ArmbianEnv map[string]string `yaml:"armbian_env"`
+	ConfigTxt  map[string]string `yaml:"config_txt"`
+	// Cmdline are kernel arguments added to the profile's.
+	Cmdline []string `yaml:"cmdline"`
+}
+
+func defaultBuildConfig() *buildConfig {
+	return &buildConfig{
+		Flavour:     StandardImage,
+		Board:       defaultBoard,
+		Compression: CompressionXZ,
+		Partitions: partitionConfig{
+			Layout: PartitionLayoutSingle,
+			AppMB:  appPartitionSizeMB,
+			DataMB: dataPartitionSizeMB,
+		},
+		Rootfs: rootfsConfig{
+			HeadroomMB: defaultRootfsHeadroomMB,
+		},
+	}
+}
+
+// loadBuildConfig reads path over the defaults.
+func loadBuildConfig(path string) (*buildConfig, error) {
+	cfg := defaultBuildConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *buildConfig) validate() error {
+	var errs []error
+	if c.Source == "" {
+		errs = append(errs, errors.New("source image is not set"))
+	}
+	if c.Output == "" {
+		errs = append(errs, errors.New("output image is not set"))
+	}
+	switch c.Flavour {
+	case StandardImage, DevImage:
+	default:
+		errs = append(errs, fmt.Errorf("invalid image flavour %q (valid: prod, dev)", c.Flavour))
+	}
+	switch c.Boot.Layout {
+	case BootLayoutAuto, BootLayoutSeparate, BootLayoutRootfs:
+	default:
+		errs = append(errs, fmt.Errorf("invalid boot layout %q (valid: auto, separate, rootfs)", c.Boot.Layout))
+	}
+	if c.Rootfs.Verity && c.Boot.Layout == BootLayoutRootfs {
+		errs = append(errs, fmt.Errorf("board %s boots from the rootfs; verity needs a separate boot partition", c.Board))
+	}
+	switch c.Partitions.Layout {
+	case PartitionLayoutSingle:
+	case PartitionLayoutAB:
+		if c.Rootfs.Verity {
+			errs = append(errs, errors.New("verity is not supported with the A/B layout"))
+		}
+		if c.Boot.Layout == BootLayoutRootfs {
+			errs = append(errs, fmt.Errorf("board %s boots from the rootfs; the A/B layout needs a separate boot partition", c.Board))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid partition layout %q (valid: single, ab)", c.Partitions.Layout))
+	}
+	switch c.Compression {
+	case CompressionXZ, CompressionNone:
+	default:
+		errs = append(errs, fmt.Errorf("invalid compression %q (valid: xz, none)", c.Compression))
+	}
+	if c.Partitions.AppMB*1024*1024 <= appStateMirrorSize {
+		errs = append(errs, fmt.Errorf("app partition of %d MB leaves no room for the filesystem", c.Partitions.AppMB))
+	}
+	if c.Partitions.DataMB == 0 {
+		errs = append(errs, errors.New("data partition size is not set"))
+	}
+	if c.Partitions.EncryptedDataMB != 0 && c.Partitions.EncryptedDataMB < vaultMinSizeMB {
+		errs = append(errs, fmt.Errorf("encrypted data partition of %d MB is too small (minimum %d MB)", c.Partitions.EncryptedDataMB, vaultMinSizeMB))
+	}
+	errs = append(errs, c.validateAccess()...)
+	errs = append(errs, c.validateInjections()...)
+	errs = append(errs, c.validateProvision()...)
+	return errors.Join(errs...)
+}
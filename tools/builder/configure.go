@@ -7,12 +7,10 @@ import (
 	"maps"
 	"os"
 	"path"
-	"path/filepath"
 	"slices"
 	"strings"
 
 	"github.com/diskfs/go-diskfs"
-	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/partition/part"
 	"github.com/tez-capital/tezsign/tools/common"
 	"github.com/tez-capital/tezsign/tools/constants"
@@ -23,10 +21,10 @@ const (
 	ROOTFS_PARTITION_NUM = 2
 )
 
-func serializeOverlays(overlays []string) string {
+func serializeOverlays(overlays []string, cfg *buildConfig) string {
 	overlaysWithOptions := []string{}
 	for _, overlay := range overlays {
-		options, ok := ArmbianActivateOverlays[overlay]
+		options, ok := cfg.Boot.Overlays[overlay]
 		if ok && options != "" {
 			overlaysWithOptions = append(overlaysWithOptions, fmt.Sprintf("%s,%s", overlay, options))
 		} else {
@@ -37,37 +35,46 @@ func serializeOverlays(overlays []string) string {
 	return strings.Join(overlaysWithOptions, " ")
 }
 
-func patchArmbianEnvTxt(bootMountPoint string, availableOverlays map[string]string, logger *slog.Logger) error {
-	armbianEnvTxtPath := path.Join(bootMountPoint, "armbianEnv.txt")
+func patchArmbianEnvTxt(fsys partitionFS, bootRoot string, availableOverlays map[string]string, cfg *buildConfig, logger *slog.Logger) error {
+	armbianEnvTxtPath := path.Join(bootRoot, "armbianEnv.txt")
 
-	if _, err := os.Stat(armbianEnvTxtPath); err != nil {
-		return err
+	if !fsys.Exists(armbianEnvTxtPath) {
+		return fmt.Errorf("%s: %w", armbianEnvTxtPath, os.ErrNotExist)
 	}
-	userOverlayDir := path.Join(bootMountPoint, "overlay-user")
+	userOverlayDir := path.Join(bootRoot, "overlay-user")
 
-	if err := os.MkdirAll(userOverlayDir, 0755); err != nil {
+	if err := fsys.MkdirAll(userOverlayDir); err != nil {
 		return fmt.Errorf("failed to create overlay-user directory: %w", err)
 	}
 
 	// copy overlays to overlay-user/
-	for overlayName, overlayPath := range availableOverlays {
+	for overlayName, overlayPath := range inOrder(availableOverlays) {
 		destPath := path.Join(userOverlayDir, overlayName+".dtbo")
 		logger.Info("Copying dtbo file to overlay-user", slog.String("src", overlayPath), slog.String("dst", destPath))
-		input, err := os.ReadFile(overlayPath)
+		input, err := fsys.ReadFile(overlayPath)
 		if err != nil {
 			return fmt.Errorf("failed to read overlay file %s: %w", overlayPath, err)
 		}
-		err = os.WriteFile(destPath, input, 0644)
+		err = fsys.WriteFile(destPath, input, 0644)
 		if err != nil {
 			return fmt.Errorf("failed to write overlay file %s: %w", destPath, err)
 		}
 	}
 
-	overlays := serializeOverlays(slices.Collect(maps.Keys(availableOverlays)))
-
+	overlays := serializeOverlays(slices.Sorted(maps.Keys(availableOverlays)), cfg)
 	logger.Info("Patching armbianEnv.txt", slog.String("path", armbianEnvTxtPath), slog.String("overlays", overlays))
-	err := EditTxtFile(armbianEnvTxtPath, []Edit{
-		{Key: "user_overlays", Value: overlays},
+	err := editFile(fsys, armbianEnvTxtPath, func(hostPath string) error {
+		edits := append([]Edit{
+			{Key: "user_overlays", Value: overlays},
+		}, txtEdits(cfg.Boot.ArmbianEnv)...)
+		if len(cfg.Boot.Cmdline) > 0 {
+			extraArgs, err := readTxtValue(hostPath, "extraargs")
+			if err != nil {
+				return err
+			}
+			edits = append(edits, Edit{Key: "extraargs", Value: appendArgs(extraArgs, cfg.Boot.Cmdline)})
+		}
+		return EditTxtFile(hostPath, edits)
 	})
 	if err != nil {
 		return fmt.Errorf("failed to edit armbianEnv.txt: %w", err)
@@ -76,24 +83,14 @@ func patchArmbianEnvTxt(bootMountPoint string, availableOverlays map[string]stri
 	return nil
 }
 
-func patchConfigTxt(bootMountPoint string, availableOverlays map[string]string, logger *slog.Logger) error {
-	configTxtPath := path.Join(bootMountPoint, "config.txt")
-	if _, err := os.Stat(configTxtPath); err != nil {
-		return err
-	}
-
-	err := EditTxtFile(configTxtPath, []Edit{
-		{Key: "otg_mode", Value: "0"},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to edit config.txt: %w", err)
-	}
+func patchConfigTxt(fsys partitionFS, bootRoot string, availableOverlays map[string]string, cfg *buildConfig, logger *slog.Logger) error {
+	configTxtPath := path.Join(bootRoot, "config.txt")
 
 	// Build the exact dtoverlay lines (one per overlay)
 	var dtoLines []string
-	for _, name := range slices.Collect(maps.Keys(availableOverlays)) {
-		// If you have options in ArmbianActivateOverlays map, apply them here
-		if opts, ok := ArmbianActivateOverlays[name]; ok && opts != "" {
+	for _, name := range slices.Sorted(maps.Keys(availableOverlays)) {
+		// If you have options in the overlays config, apply them here
+		if opts, ok := cfg.Boot.Overlays[name]; ok && opts != "" {
 			// no spaces around commas!
 			dtoLines = append(dtoLines, fmt.Sprintf("dtoverlay=%s,%s", name, opts))
 		} else {
@@ -101,322 +98,287 @@ func patchConfigTxt(bootMountPoint string, availableOverlays map[string]string,
 		}
 	}
 
-	// Read, remove existing dtoverlay= lines, append our clean ones
-	b, err := os.ReadFile(configTxtPath)
-	if err != nil {
-		return fmt.Errorf("read config.txt: %w", err)
-	}
-	lines := strings.Split(string(b), "\n")
-	out := make([]string, 0, len(lines)+len(dtoLines))
-	for _, ln := range lines {
-		if strings.HasPrefix(strings.TrimSpace(ln), "dtoverlay=") {
-			continue // drop any existing overlay lines
+	err := editFile(fsys, configTxtPath, func(hostPath string) error {
+		if err := EditTxtFile(hostPath, txtEdits(cfg.Boot.ConfigTxt)); err != nil {
+			return fmt.Errorf("failed to edit config.txt: %w", err)
 		}
-		out = append(out, ln)
-	}
-	out = append(out, dtoLines...)
 
-	newContent := strings.Join(out, "\n")
-	if err := os.WriteFile(configTxtPath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("write config.txt: %w", err)
-	}
-
-	logger.Info("Patching config.txt (Pi firmware)", slog.String("path", configTxtPath), slog.Any("dtoverlay_lines", dtoLines))
-	return nil
-}
-
-func patchBootConfiguration(mountPoint string, flavour imageFlavour, logger *slog.Logger) error {
-	availableOverlays := map[string]string{}
-	err := filepath.Walk(mountPoint, func(path string, info os.FileInfo, err error) error {
+		// Read, remove existing dtoverlay= lines, append our clean ones
+		b, err := os.ReadFile(hostPath)
 		if err != nil {
-			logger.Error("Error accessing path", slog.String("path", path), "error", err)
-			return err
+			return fmt.Errorf("read config.txt: %w", err)
 		}
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".dtbo") {
-			return nil
-		}
-		overlayName := strings.TrimSuffix(info.Name(), ".dtbo")
-		if _, exists := ArmbianActivateOverlays[overlayName]; exists {
-			if _, exists := availableOverlays[overlayName]; exists {
-				return nil
+		lines := strings.Split(string(b), "\n")
+		out := make([]string, 0, len(lines)+len(dtoLines))
+		for _, ln := range lines {
+			if strings.HasPrefix(strings.TrimSpace(ln), "dtoverlay=") {
+				continue // drop any existing overlay lines
 			}
-			availableOverlays[overlayName] = path
-			logger.Debug("Found dtbo file", slog.String("path", path))
+			out = append(out, ln)
+		}
+		out = append(out, dtoLines...)
+
+		newContent := strings.Join(out, "\n")
+		if err := os.WriteFile(hostPath, []byte(newContent), 0644); err != nil {
+			return fmt.Errorf("write config.txt: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to read dtbo files from boot mount point: %w", err)
-	}
-
-	armbianEnvTxtPath := path.Join(mountPoint, "armbianEnv.txt")
-	if _, err := os.Stat(armbianEnvTxtPath); err == nil { // armbianEnv.txt exists -> patch it
-		if err = patchArmbianEnvTxt(mountPoint, availableOverlays, logger); err != nil {
-			return fmt.Errorf("failed to patch armbianEnv.txt: %w", err)
-		}
+		return err
 	}
 
-	configTxtPath := path.Join(mountPoint, "config.txt")
-	if _, err := os.Stat(configTxtPath); err == nil { // config.txt exists -> patch it
-		if err = patchConfigTxt(mountPoint, availableOverlays, logger); err != nil {
-			return fmt.Errorf("failed to patch config.txt: %w", err)
-		}
-	}
+	logger.Info("Patching config.txt (Pi firmware)", slog.String("path", configTxtPath), slog.Any("dtoverlay_lines", dtoLines))
 	return nil
 }
 
-func patchBootPartition(img *disk.Disk, bootPartition part.Partition, flavour imageFlavour, logger *slog.Logger) error {
-	bootImg := path.Join(workDir, "boot.img")
-	f, err := os.Create(bootImg)
+// patchCmdlineTxt adds the board's kernel arguments to the Pi firmware
+// cmdline.txt, which must stay a single line.
+func patchCmdlineTxt(fsys partitionFS, bootRoot string, cfg *buildConfig, logger *slog.Logger) error {
+	cmdlinePath := path.Join(bootRoot, "cmdline.txt")
+	b, err := fsys.ReadFile(cmdlinePath)
 	if err != nil {
-		return errors.Join(common.ErrFailedToConfigureImage, err)
+		return err
 	}
-	defer f.Close()
+	cmdline := appendArgs(strings.TrimSpace(string(b)), cfg.Boot.Cmdline)
+	logger.Info("Patching cmdline.txt", slog.String("path", cmdlinePath), slog.String("cmdline", cmdline))
+	return fsys.WriteFile(cmdlinePath, []byte(cmdline+"\n"), 0644)
+}
 
-	n, err := bootPartition.ReadContents(img.Backend, f)
+// patchBootConfiguration patches the boot files under bootRoot: "/" on a
+// boot partition, "/boot" on a rootfs that carries its own.
+func patchBootConfiguration(fsys partitionFS, bootRoot string, cfg *buildConfig, logger *slog.Logger) error {
+	files, err := fsys.Files(bootRoot)
 	if err != nil {
-		return errors.Join(common.ErrFailedToConfigureImage, err)
+		return fmt.Errorf("failed to read dtbo files from %s: %w", bootRoot, err)
 	}
-	if n != bootPartition.GetSize() {
-		return errors.Join(common.ErrFailedToConfigureImage, fmt.Errorf("expected to read %d bytes from boot partition, but read %d", bootPartition.GetSize(), n))
-	}
-	f.Close()
+	slices.Sort(files)
 
-	logger.Debug("Wrote boot partition to file", slog.String("path", bootImg), slog.Int64("bytes_written", n))
-	bootMountPoint := path.Join(workDir, "boot")
-	unmount, err := fusefat_mount(bootImg, bootMountPoint, logger)
-	if err != nil {
-		return err
+	availableOverlays := map[string]string{}
+	for _, filePath := range files {
+		if !strings.HasSuffix(filePath, ".dtbo") {
+			continue
+		}
+		overlayName := strings.TrimSuffix(path.Base(filePath), ".dtbo")
+		if _, exists := cfg.Boot.Overlays[overlayName]; exists {
+			if _, exists := availableOverlays[overlayName]; exists {
+				continue
+			}
+			availableOverlays[overlayName] = filePath
+			logger.Debug("Found dtbo file", slog.String("path", filePath))
+		}
 	}
-	_ = unmount
-	defer unmount(true)
 
-	if err = patchBootConfiguration(bootMountPoint, flavour, logger); err != nil {
-		return errors.Join(common.ErrFailedToConfigureImage, err)
+	if fsys.Exists(path.Join(bootRoot, "armbianEnv.txt")) { // armbianEnv.txt exists -> patch it
+		if err = patchArmbianEnvTxt(fsys, bootRoot, availableOverlays, cfg, logger); err != nil {
+			return fmt.Errorf("failed to patch armbianEnv.txt: %w", err)
+		}
 	}
 
-	unmount(false)
-
-	// write back to partition
-	f, err = os.OpenFile(bootImg, os.O_RDWR, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open boot image for writing: %w", err)
+	if fsys.Exists(path.Join(bootRoot, "config.txt")) { // config.txt exists -> patch it
+		if err = patchConfigTxt(fsys, bootRoot, availableOverlays, cfg, logger); err != nil {
+			return fmt.Errorf("failed to patch config.txt: %w", err)
+		}
 	}
-	defer f.Close()
 
-	writable, err := img.Backend.Writable()
-	if err != nil {
-		return fmt.Errorf("failed to get writable backend: %w", err)
-	}
-	nnew, err := bootPartition.WriteContents(writable, f)
-	if err != nil {
-		return fmt.Errorf("failed to write back boot partition: %w", err)
-	}
-	if int64(nnew) != bootPartition.GetSize() {
-		return fmt.Errorf("expected to write %d bytes to boot partition, but wrote %d", bootPartition.GetSize(), nnew)
+	if len(cfg.Boot.Cmdline) > 0 && fsys.Exists(path.Join(bootRoot, "cmdline.txt")) {
+		if err = patchCmdlineTxt(fsys, bootRoot, cfg, logger); err != nil {
+			return fmt.Errorf("failed to patch cmdline.txt: %w", err)
+		}
 	}
-
 	return nil
 }
 
-func patchAppPartition(imgPath string, appPartition part.Partition, flavour imageFlavour, logger *slog.Logger) error {
-	appfs := path.Join(workDir, "appfs")
-
-	unmount, err := fuse2fs_mount(imgPath, appfs, int(appPartition.GetStart()), logger)
-	if err != nil {
-		return err
+func patchBootPartition(imgPath string, bootPartition part.Partition, cfg *buildConfig, logger *slog.Logger) error {
+	logger.Debug("Patching boot partition", slog.Int64("offset", bootPartition.GetStart()))
+	bootfs := newFATFS(imgPath, bootPartition.GetStart())
+	if err := patchBootConfiguration(bootfs, "/", cfg, logger); err != nil {
+		return errors.Join(common.ErrFailedToConfigureImage, err)
 	}
-	_ = unmount
-	defer unmount(true)
-
-	for src, dst := range AppInjectFiles {
-		logger.Info("Injecting file into app partition", slog.String("src", src), slog.String("dst", dst))
-		srcPath := src
-		dstPath := path.Join(appfs, dst)
+	return applyInjections(bootfs, "/", InjectBoot, cfg.Inject, logger)
+}
 
-		dstDir := path.Dir(dstPath)
-		if _, err = os.Stat(dstDir); err != nil {
-			if err = os.MkdirAll(dstDir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory for %s: %w", dstPath, err)
-			}
-		}
-		if err := copyFile(srcPath, dstPath); err != nil {
-			return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
-		}
+func patchAppPartition(imgPath string, appPartition part.Partition, cfg *buildConfig, logger *slog.Logger) error {
+	appfs := newExt4FS(imgPath, appPartition.GetStart())
 
-		if err := os.Chown(dstPath, 1000, 1000); err != nil {
-			return fmt.Errorf("failed to chown %s: %w", dstPath, err)
+	for src, dst := range inOrder(mergedFiles(AppInjectFiles, cfg.AppFiles)) {
+		logger.Info("Injecting file into app partition", slog.String("src", src), slog.String("dst", dst))
+		if err := copyIn(appfs, src, dst, 0555); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
 		}
 
-		if err := os.Chmod(dstPath, 0555); err != nil {
-			return fmt.Errorf("failed to chmod %s: %w", dstPath, err)
+		if err := appfs.Chown(dst, 1000, 1000); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", dst, err)
 		}
 	}
 
 	// inject .image-flavour from IMAGE_ID env variable
-	flavourFilePath := path.Join(appfs, ".image-flavour")
-	if err := os.WriteFile(flavourFilePath, []byte(os.Getenv("IMAGE_ID")), 0444); err != nil {
+	flavourFilePath := "/.image-flavour"
+	if err := appfs.WriteFile(flavourFilePath, []byte(os.Getenv("IMAGE_ID")), 0444); err != nil {
 		return fmt.Errorf("failed to write image flavour file %s: %w", flavourFilePath, err)
 	}
+	if err := writeAppVersion(appfs, cfg); err != nil {
+		return err
+	}
 
-	return nil
+	return applyInjections(appfs, "/", InjectApp, cfg.Inject, logger)
 }
 
-func patchDataPartition(imgPath string, dataPartition part.Partition, flavour imageFlavour, logger *slog.Logger) error {
-	datafs := path.Join(workDir, "datafs")
+func patchDataPartition(imgPath string, dataPartition part.Partition, cfg *buildConfig, logger *slog.Logger) error {
+	datafs := newExt4FS(imgPath, dataPartition.GetStart())
 
-	unmount, err := fuse2fs_mount(imgPath, datafs, int(dataPartition.GetStart()), logger)
-	if err != nil {
-		return err
+	// create data dir and set ownership to tezsign user
+	dataMountPoint := "/tezsign"
+	if err := datafs.MkdirAll(dataMountPoint); err != nil {
+		return fmt.Errorf("failed to create data mount point %s: %w", dataMountPoint, err)
+	}
+	if err := datafs.Chown(dataMountPoint, 1000, 1000); err != nil {
+		return fmt.Errorf("failed to chown data mount point %s: %w", dataMountPoint, err)
 	}
-	_ = unmount
-	defer unmount(true)
 
-	// create data dir and set ownership to tezsign user
-	dataMountPoint := path.Join(datafs, "tezsign")
-	if _, err = os.Stat(dataMountPoint); err != nil {
-		if err := os.MkdirAll(dataMountPoint, 0755); err != nil {
-			return fmt.Errorf("failed to create data mount point %s: %w", dataMountPoint, err)
+	if cfg.Partitions.EncryptedDataMB > 0 {
+		if err := installVaultKey(datafs); err != nil {
+			return fmt.Errorf("failed to install vault provisioning key: %w", err)
 		}
 	}
-	if err := os.Chown(dataMountPoint, 1000, 1000); err != nil {
-		return fmt.Errorf("failed to chown data mount point %s: %w", dataMountPoint, err)
+
+	if cfg.Provision != "" {
+		if err := installProvisioning(datafs, cfg.Provision, logger); err != nil {
+			return fmt.Errorf("failed to install provisioning bundle: %w", err)
+		}
 	}
 
-	return nil
+	return applyInjections(datafs, "/", InjectData, cfg.Inject, logger)
 }
 
-func setupModules(rootFsPath, fileName string, modules []string, logger *slog.Logger) error {
-	modulesLoadPath := path.Join(rootFsPath, "etc", "modules-load.d", fileName)
-	return os.WriteFile(modulesLoadPath, []byte(strings.Join(modules, "\n")), 0644)
+func setupModules(rootfs partitionFS, fileName string, modules []string, logger *slog.Logger) error {
+	modulesLoadPath := path.Join("/etc/modules-load.d", fileName)
+	return rootfs.WriteFile(modulesLoadPath, []byte(strings.Join(modules, "\n")), 0644)
 }
 
-func patchRootPartition(imgPath string, rootPartition part.Partition, flavour imageFlavour, logger *slog.Logger) error {
-	unmount, err := fuse2fs_mount(imgPath, path.Join(workDir, "rootfs"), int(rootPartition.GetStart()), logger)
-	if err != nil {
-		return err
+// injectFiles copies host files into the rootfs, creates symlinks and
+// adjusts modes, in that order.
+func injectFiles(rootfs partitionFS, files, symlinks map[string]string, permissions map[string]os.FileMode) error {
+	for src, dst := range inOrder(files) {
+		if err := copyIn(rootfs, src, dst, 0644); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+		}
 	}
-	_ = unmount
-	defer unmount(true)
+
+	for src, dst := range inOrder(symlinks) {
+		if err := rootfs.MkdirAll(path.Dir(dst)); err != nil {
+			return fmt.Errorf("failed to create directory for symlink %s: %w", dst, err)
+		}
+		if err := rootfs.RemoveAll(dst); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", dst, err)
+		}
+		if err := rootfs.Symlink(src, dst); err != nil {
+			return fmt.Errorf("failed to create symlink from %s to %s: %w", src, dst, err)
+		}
+	}
+
+	for filePath, mode := range inOrder(permissions) {
+		if err := rootfs.Chmod(filePath, mode); err != nil {
+			return fmt.Errorf("failed to chmod %o %s: %w", mode, filePath, err)
+		}
+	}
+	return nil
+}
+
+func patchRootPartition(imgPath string, rootPartition part.Partition, cfg *buildConfig, logger *slog.Logger) error {
+	rootfs := newExt4FS(imgPath, rootPartition.GetStart())
 
 	// Patch /etc/fstab
-	rootfs := path.Join(workDir, "rootfs")
-	fstabPath := path.Join(rootfs, "etc", "fstab")
-
-	err = PathFsTab(fstabPath, []mount{
-		{point: "tmpfs /tmp", options: []string{"tmpfs", "defaults,noatime,nosuid,size=50m"}},
-		{point: "tmpfs /var/log", options: []string{"tmpfs", "defaults,noatime,nosuid,size=50m"}},
-		{point: "tmpfs /var/tmp", options: []string{"tmpfs", "defaults,noatime,nosuid,size=30m"}},
-		{point: fmt.Sprintf("LABEL=%s /app", constants.AppPartitionLabel), options: []string{"ext4", "ro,exec,noatime,nofail,data=journal  0   2"}},
-		{point: fmt.Sprintf("LABEL=%s /data", constants.DataPartitionLabel), options: []string{"ext4", "rw,noatime,nofail,data=journal   0   2"}},
+	err := editFile(rootfs, "/etc/fstab", func(fstabPath string) error {
+		err := PathFsTab(fstabPath, []mount{
+			{point: "tmpfs /tmp", options: []string{"tmpfs", "defaults,noatime,nosuid,size=50m"}},
+			{point: "tmpfs /var/log", options: []string{"tmpfs", "defaults,noatime,nosuid,size=50m"}},
+			{point: "tmpfs /var/tmp", options: []string{"tmpfs", "defaults,noatime,nosuid,size=30m"}},
+			{point: fmt.Sprintf("LABEL=%s /app", constants.AppPartitionLabel), options: []string{"ext4", "ro,exec,noatime,nofail,data=journal  0   2"}},
+			{point: fmt.Sprintf("LABEL=%s /data", constants.DataPartitionLabel), options: []string{"ext4", "rw,noatime,nofail,data=journal   0   2"}},
+		})
+		if err == nil && cfg.Partitions.Layout == PartitionLayoutAB {
+			err = setFsTabDevice(fstabPath, "/", "PARTLABEL="+constants.RootfsAPartitionLabel)
+		}
+		return err
 	})
+	if err != nil {
+		return fmt.Errorf("failed to patch fstab: %w", err)
+	}
 
-	bootMountPoint := path.Join(rootfs, "boot")
-	if _, err := os.Stat(bootMountPoint); err == nil {
-		if err = patchBootConfiguration(bootMountPoint, flavour, logger); err != nil {
+	if rootfs.Exists("/boot") {
+		if err = patchBootConfiguration(rootfs, "/boot", cfg, logger); err != nil {
 			return errors.Join(common.ErrFailedToConfigureImage, err)
 		}
 	}
 
 	// remove files
 	for _, filePath := range ArmbianRootfsRemove {
-		fullPath := path.Join(rootfs, filePath)
-		if err := os.RemoveAll(fullPath); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", fullPath, err)
+		if err := rootfs.RemoveAll(filePath); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", filePath, err)
 		}
 	}
 
 	for _, dirPath := range ArmbianRootFsCreateDirs {
-		fullPath := path.Join(rootfs, dirPath)
-		if err := os.MkdirAll(fullPath, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
+		if err := rootfs.MkdirAll(dirPath); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dirPath, err)
 		}
 	}
 
-	// inject files
-	for src, dst := range ArmbianInjectFiles {
-		srcPath := src
-		dstPath := path.Join(rootfs, dst)
+	if err := injectFiles(rootfs, mergedFiles(ArmbianInjectFiles, cfg.Files), ArmbianCreateSymlinks, ArmbianAdjustPermissions); err != nil {
+		return err
+	}
 
-		if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory for %s: %w", dstPath, err)
-		}
-		if err := copyFile(srcPath, dstPath); err != nil {
-			return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+	if cfg.Rootfs.Verity {
+		if err := injectFiles(rootfs, VerityInjectFiles, nil, VerityAdjustPermissions); err != nil {
+			return err
 		}
 	}
 
-	// create symlinks
-	for src, dst := range ArmbianCreateSymlinks {
-		dstPath := path.Join(rootfs, dst)
-
-		if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory for symlink %s: %w", dstPath, err)
-		}
-		if err := os.Symlink(src, dstPath); err != nil {
-			return fmt.Errorf("failed to create symlink from %s to %s: %w", src, dstPath, err)
+	if cfg.Partitions.EncryptedDataMB > 0 {
+		if err := installVault(rootfs, logger); err != nil {
+			return err
 		}
 	}
 
-	// adjust permissions
-	for filePath, mode := range ArmbianAdjustPermissions {
-		fullPath := path.Join(rootfs, filePath)
-		if err := os.Chmod(fullPath, mode); err != nil {
-			return fmt.Errorf("failed to chmod %o %s: %w", mode, fullPath, err)
+	if cfg.Partitions.Layout == PartitionLayoutAB {
+		if err := injectFiles(rootfs, SlotInjectFiles, nil, SlotAdjustPermissions); err != nil {
+			return err
 		}
 	}
 
-	switch flavour {
+	switch cfg.Flavour {
 	case DevImage:
 		for _, filePath := range DevArmbianRootfsRemove {
-			fullPath := path.Join(rootfs, filePath)
-			if err := os.RemoveAll(fullPath); err != nil {
-				return fmt.Errorf("failed to remove %s: %w", fullPath, err)
-			}
-		}
-
-		for src, dst := range DevArmbianInjectFiles {
-			srcPath := src
-			dstPath := path.Join(rootfs, dst)
-
-			if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for %s: %w", dstPath, err)
-			}
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+			if err := rootfs.RemoveAll(filePath); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", filePath, err)
 			}
 		}
 
-		for src, dst := range DevArmbianCreateSymlinks {
-			dstPath := path.Join(rootfs, dst)
-
-			if err := os.MkdirAll(path.Dir(dstPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for symlink %s: %w", dstPath, err)
-			}
-
-			if err := os.Symlink(src, dstPath); err != nil {
-				return fmt.Errorf("failed to create symlink from %s to %s: %w", src, dstPath, err)
-			}
-		}
-
-		for filePath, mode := range DevArmbianAdjustPermissions {
-			fullPath := path.Join(rootfs, filePath)
-			if err := os.Chmod(fullPath, mode); err != nil {
-				return fmt.Errorf("failed to chmod %o %s: %w", mode, fullPath, err)
-			}
+		if err := injectFiles(rootfs, DevArmbianInjectFiles, DevArmbianCreateSymlinks, DevArmbianAdjustPermissions); err != nil {
+			return err
 		}
 	default:
 		// no dev files to inject
 	}
 
+	if err := installAccess(rootfs, cfg, logger); err != nil {
+		return fmt.Errorf("failed to install access settings: %w", err)
+	}
+
 	if err = setupModules(rootfs, "tezsign-usb.conf", PreloadTezsignUsbModules, logger); err != nil {
 		return fmt.Errorf("failed to setup tezsign-usb modules: %w", err)
 	}
+	if len(cfg.Modules) > 0 {
+		if err = setupModules(rootfs, "tezsign-extra.conf", cfg.Modules, logger); err != nil {
+			return fmt.Errorf("failed to setup extra modules: %w", err)
+		}
+	}
 
 	return nil
 }
 
-func ConfigureImage(workDir, imagePath string, flavour imageFlavour, logger *slog.Logger) error {
+func ConfigureImage(workDir, imagePath string, cfg *buildConfig, logger *slog.Logger) error {
 	img, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadWrite))
 	if err != nil {
 		return errors.Join(common.ErrFailedToOpenImage, err)
@@ -433,25 +395,32 @@ func ConfigureImage(workDir, imagePath string, flavour imageFlavour, logger *slo
 		slog.Group("app", slog.Int64("start", appPartition.GetStart()), slog.Int64("size", appPartition.GetSize())),
 		slog.Group("data", slog.Int64("start", dataPartition.GetStart()), slog.Int64("size", dataPartition.GetSize())))
 
-	// patch boot partition
+	// Each step edits its own partition, so they run concurrently.
+	steps := []func() error{
+		func() error {
+			if err := patchRootPartition(imagePath, rootfsPartition, cfg, logger); err != nil {
+				return err
+			}
+			rootfs := newExt4FS(imagePath, rootfsPartition.GetStart())
+			if err := writeRelease(rootfs, img, cfg, logger); err != nil {
+				return err
+			}
+			if bootPartition == nil { // the boot files are in /boot on the rootfs
+				if err := applyInjections(rootfs, "/boot", InjectBoot, cfg.Inject, logger); err != nil {
+					return err
+				}
+			}
+			return applyInjections(rootfs, "/", InjectRootfs, cfg.Inject, logger)
+		},
+		func() error { return patchAppPartition(imagePath, appPartition, cfg, logger) },
+		func() error { return patchDataPartition(imagePath, dataPartition, cfg, logger) },
+	}
 	if bootPartition != nil { // some images may not have a separate boot partition
-		if err := patchBootPartition(img, bootPartition, flavour, logger); err != nil {
-			return errors.Join(common.ErrFailedToConfigureImage, err)
-		}
+		steps = append(steps, func() error { return patchBootPartition(imagePath, bootPartition, cfg, logger) })
 	} else {
 		logger.Info("No separate boot partition found, skipping boot partition patching.")
 	}
-
-	// patch rootfs partition
-	if err := patchRootPartition(imagePath, rootfsPartition, flavour, logger); err != nil {
-		return errors.Join(common.ErrFailedToConfigureImage, err)
-	}
-
-	if err := patchAppPartition(imagePath, appPartition, flavour, logger); err != nil {
-		return errors.Join(common.ErrFailedToConfigureImage, err)
-	}
-
-	if err := patchDataPartition(imagePath, dataPartition, flavour, logger); err != nil {
+	if err := runParallel(steps...); err != nil {
 		return errors.Join(common.ErrFailedToConfigureImage, err)
 	}
 
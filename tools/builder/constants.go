@@ -12,8 +12,11 @@ const (
 )
 
 const (
-	appPartitionSizeMB  = 64
+	appPartitionSizeMB  = 128 // two app slots (see select-app-slot.sh)
 	dataPartitionSizeMB = 128
+	// appStateMirrorSize is left unformatted at the end of the app partition
+	// for the second copy of the watermarks (see attach-state-mirror.sh).
+	appStateMirrorSize = 1 << 20
 
 	workDir  = "/tmp/tezsign_image_builder"
 	tmpImage = workDir + "/image.img"
@@ -29,6 +32,8 @@ var (
 		"libcomposite",
 		"usb_f_fs",
 		"usb_f_ecm",
+		"usb_f_acm",
+		"usb_f_mass_storage",
 	}
 
 	ArmbianRootfsRemove = []string{
@@ -54,6 +59,17 @@ var (
 		"tools/builder/assets/ffs_registrar":                  "/usr/local/bin/ffs_registrar",
 		"tools/builder/assets/ffs_registrar.service":          "/etc/systemd/system/ffs_registrar.service",
 		"tools/builder/assets/tezsign.service":                "/etc/systemd/system/tezsign.service",
+		"tools/builder/assets/apply-app-update.sh":            "/usr/local/bin/apply-app-update.sh",
+		"tools/builder/assets/select-app-slot.sh":             "/usr/local/bin/select-app-slot.sh",
+		"tools/builder/assets/attach-state-mirror.sh":         "/usr/local/bin/attach-state-mirror.sh",
+		"tools/builder/assets/tezsign-vault.sh":               "/usr/local/bin/tezsign-vault.sh",
+		"tools/builder/assets/tezsign-vault.socket":           "/etc/systemd/system/tezsign-vault.socket",
+		"tools/builder/assets/tezsign-vault@.service":         "/etc/systemd/system/tezsign-vault@.service",
+		"tools/builder/assets/tezsign-audit-export.sh":        "/usr/local/bin/tezsign-audit-export.sh",
+		"tools/builder/assets/tezsign-audit-export.service":   "/etc/systemd/system/tezsign-audit-export.service",
+		"tools/builder/assets/tezsign-audit-export.timer":     "/etc/systemd/system/tezsign-audit-export.timer",
+		"tools/builder/assets/apply-app-update.service":       "/etc/systemd/system/apply-app-update.service",
+		"tools/builder/assets/apply-app-update.path":          "/etc/systemd/system/apply-app-update.path",
 		"tools/builder/assets/generate-serial-number.sh":      "/usr/local/bin/generate-serial-number.sh",
 		"tools/builder/assets/setup-gadget-dev-dummy.service": "/etc/systemd/system/setup-gadget-dev.service", // dummy to satisfy dependencies
 	}
@@ -68,14 +84,22 @@ var (
 		"/usr/local/bin/attach-gadget.sh":          0700,
 		"/usr/local/bin/ffs_registrar":             0700,
 		"/usr/local/bin/generate-serial-number.sh": 0700,
+		"/usr/local/bin/apply-app-update.sh":       0700,
+		"/usr/local/bin/select-app-slot.sh":        0700,
+		"/usr/local/bin/attach-state-mirror.sh":    0700,
+		"/usr/local/bin/tezsign-vault.sh":          0700,
+		"/usr/local/bin/tezsign-audit-export.sh":   0700,
 	}
 
 	ArmbianCreateSymlinks = map[string]string{
-		"/etc/systemd/system/first-boot-setup.service": "/etc/systemd/system/multi-user.target.wants/first-boot-setup.service",
-		"/etc/systemd/system/setup-gadget.service":     "/etc/systemd/system/multi-user.target.wants/setup-gadget.service",
-		"/etc/systemd/system/attach-gadget.service":    "/etc/systemd/system/multi-user.target.wants/attach-gadget.service",
-		"/etc/systemd/system/ffs_registrar.service":    "/etc/systemd/system/multi-user.target.wants/ffs_registrar.service",
-		"/etc/systemd/system/tezsign.service":          "/etc/systemd/system/multi-user.target.wants/tezsign.service",
+		"/etc/systemd/system/first-boot-setup.service":   "/etc/systemd/system/multi-user.target.wants/first-boot-setup.service",
+		"/etc/systemd/system/setup-gadget.service":       "/etc/systemd/system/multi-user.target.wants/setup-gadget.service",
+		"/etc/systemd/system/attach-gadget.service":      "/etc/systemd/system/multi-user.target.wants/attach-gadget.service",
+		"/etc/systemd/system/ffs_registrar.service":      "/etc/systemd/system/multi-user.target.wants/ffs_registrar.service",
+		"/etc/systemd/system/tezsign.service":            "/etc/systemd/system/multi-user.target.wants/tezsign.service",
+		"/etc/systemd/system/apply-app-update.path":      "/etc/systemd/system/multi-user.target.wants/apply-app-update.path",
+		"/etc/systemd/system/tezsign-vault.socket":       "/etc/systemd/system/multi-user.target.wants/tezsign-vault.socket",
+		"/etc/systemd/system/tezsign-audit-export.timer": "/etc/systemd/system/timers.target.wants/tezsign-audit-export.timer",
 	}
 
 	ArmbianActivateOverlays = map[string]string{
@@ -93,6 +117,8 @@ var (
 		"tools/builder/assets/attach-gadget-dev.sh":      "/usr/local/bin/attach-gadget-dev.sh",
 		"tools/builder/assets/attach-gadget-dev.service": "/etc/systemd/system/attach-gadget-dev.service",
 		"tools/builder/assets/enable-dev.sh":             "/usr/local/bin/enable-dev.sh",
+		"tools/builder/assets/tezsign-console.sh":        "/usr/local/bin/tezsign-console.sh",
+		"tools/builder/assets/tezsign-console.service":   "/etc/systemd/system/tezsign-console.service",
 	}
 
 	DevArmbianRootfsRemove = []string{}
@@ -101,10 +127,12 @@ var (
 		"/usr/local/bin/setup-gadget-dev.sh":  0700, // Only root can execute
 		"/usr/local/bin/attach-gadget-dev.sh": 0700, // Only root can execute
 		"/usr/local/bin/enable-dev.sh":        0700, // Only root can execute
+		"/usr/local/bin/tezsign-console.sh":   0700, // Only root can execute
 	}
 
 	DevArmbianCreateSymlinks = map[string]string{
 		"/etc/systemd/system/setup-gadget-dev.service":  "/etc/systemd/system/multi-user.target.wants/setup-gadget-dev.service",
 		"/etc/systemd/system/attach-gadget-dev.service": "/etc/systemd/system/multi-user.target.wants/attach-gadget-dev.service",
+		"/etc/systemd/system/tezsign-console.service":   "/etc/systemd/system/multi-user.target.wants/tezsign-console.service",
 	}
 )
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sys/unix"
+)
+
+const flashUsage = `Usage: builder flash [-yes] [-allow-fixed] [-public-key key.pub] <image[.xz]> <device>
+
+Writes an image to a whole disk, e.g. /dev/sdb, and reads it back to verify.
+Disks that are mounted or used as swap are refused, and so are disks that
+are not removable unless -allow-fixed is set.
+`
+
+func runFlash(args []string) {
+	flags := flag.NewFlagSet("flash", flag.ExitOnError)
+	yes := flags.Bool("yes", false, "do not ask for confirmation")
+	allowFixed := flags.Bool("allow-fixed", false, "allow disks that are not removable")
+	publicKey := flags.String("public-key", "", "minisign public key the image must be signed with")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), flashUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	setupLogging(LogFormatText)
+	logger := slog.Default()
+	if err := flashImage(flags.Arg(0), flags.Arg(1), *yes, *allowFixed, *publicKey, logger); err != nil {
+		logger.Error("Failed to flash image", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+func flashImage(imagePath, devicePath string, yes, allowFixed bool, publicKey string, logger *slog.Logger) error {
+	manifest, err := flashManifest(imagePath, publicKey)
+	if err != nil {
+		return err
+	}
+	compressed := strings.HasSuffix(imagePath, ".xz")
+
+	// the size is unknown for an xz image without a manifest until it is
+	// written; the write then fails on a small card instead
+	var need int64
+	switch {
+	case manifest != nil:
+		need = manifest.Raw.Size
+	case !compressed:
+		st, err := os.Stat(imagePath)
+		if err != nil {
+			return err
+		}
+		need = st.Size()
+	}
+
+	dev, err := common.InspectBlockDevice(devicePath)
+	if err != nil {
+		return err
+	}
+	if err := dev.CheckWritable(need, allowFixed); err != nil {
+		return err
+	}
+	if !yes {
+		if err := confirmFlash(imagePath, dev); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Writing image", slog.String("image", imagePath), slog.String("device", dev.Path), slog.String("model", dev.Model))
+	sum, written, err := writeDevice(imagePath, dev, compressed)
+	if err != nil {
+		return err
+	}
+	if manifest != nil && (manifest.Raw.SHA256 != sum || manifest.Raw.Size != written) {
+		return fmt.Errorf("image does not match its manifest: wrote %d bytes with sha256 %s, manifest has %d bytes with %s", written, sum, manifest.Raw.Size, manifest.Raw.SHA256)
+	}
+
+	logger.Info("Verifying device", slog.String("device", dev.Path))
+	if err := verifyDevice(dev, written, sum); err != nil {
+		return err
+	}
+	logger.Info("✅ Image written and verified", slog.String("device", dev.Path), slog.Int64("bytes", written), slog.String("sha256", sum))
+	return nil
+}
+
+// flashManifest returns the manifest next to the image: verified when a
+// public key is given, else only if present, for its size and digest.
+func flashManifest(imagePath, publicKey string) (*common.ImageManifest, error) {
+	if publicKey != "" {
+		keyData, err := os.ReadFile(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := common.ParsePublicKey(keyData)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := common.VerifyImage(pub, imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("image signature: %w", err)
+		}
+		return manifest, nil
+	}
+	manifest, err := common.ReadImageManifest(imagePath + common.ManifestSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+// confirmFlash asks for the device name, so a mistyped path is not
+// confirmed by habit.
+func confirmFlash(imagePath string, dev *common.BlockDevice) error {
+	if !isTTY(os.Stdin) {
+		return errors.New("refusing to write without confirmation; pass -yes when not on a terminal")
+	}
+	fmt.Printf("\nAbout to write %s to %s.\nEVERYTHING on %s will be lost.\nType %q to continue: ", imagePath, dev, dev.Path, dev.Name)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(answer) != dev.Name {
+		return errors.New("aborted")
+	}
+	return nil
+}
+
+// writeDevice copies the (decompressed) image to the device and returns
+// the sha256 and size of what it wrote.
+func writeDevice(imagePath string, dev *common.BlockDevice, compressed bool) (string, int64, error) {
+	src, closeSource, err := trackFile(dev.Name, imagePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer closeSource()
+	if compressed {
+		if src, err = xz.NewReader(src); err != nil {
+			return "", 0, err
+		}
+	}
+
+	// O_EXCL fails while the kernel has any partition of the disk in use
+	f, err := os.OpenFile(dev.Path, os.O_WRONLY|unix.O_EXCL, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	written, err := copyBuffered(f, io.TeeReader(src, h))
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			err = fmt.Errorf("%w: %w", common.ErrDeviceTooSmall, err)
+		}
+		return "", 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return "", 0, err
+	}
+	// reread the partition table so the new partitions show up
+	_ = unix.IoctlSetInt(int(f.Fd()), unix.BLKRRPART, 0)
+	return hex.EncodeToString(h.Sum(nil)), written, f.Close()
+}
+
+// verifyDevice reads the written bytes back past the page cache.
+func verifyDevice(dev *common.BlockDevice, size int64, want string) error {
+	f, err := os.Open(dev.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := unix.IoctlSetInt(int(f.Fd()), unix.BLKFLSBUF, 0); err != nil {
+		return fmt.Errorf("failed to drop the device cache: %w", err)
+	}
+
+	p := startProgress(dev.Name+" verify", size)
+	h := sha256.New()
+	_, err = copyBuffered(h, p.Reader(io.LimitReader(f, size)))
+	p.Finish()
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		return fmt.Errorf("verification failed: device reads back sha256 %s, wrote %s", got, want)
+	}
+	return nil
+}
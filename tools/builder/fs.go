@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// partitionFS edits a filesystem inside the image file in place. Neither
+// implementation mounts anything, so the builder needs no root, FUSE or
+// mount points for them:
+//
+//   - ext4 goes through debugfs. go-diskfs can write ext4 files, but not
+//     symlinks, modes or owners, which every partition we patch needs.
+//   - FAT goes through mtools. go-diskfs only reads FAT32 (Pi boot
+//     partitions are often FAT16) and stamps what it writes with the wall
+//     clock, which would break reproducible builds.
+//
+// Paths are absolute within the filesystem.
+type partitionFS interface {
+	Exists(p string) bool
+	ReadFile(p string) ([]byte, error)
+	// WriteFile creates or replaces p, owned by root.
+	WriteFile(p string, data []byte, mode os.FileMode) error
+	MkdirAll(p string) error
+	RemoveAll(p string) error
+	Symlink(target, p string) error
+	Chmod(p string, mode os.FileMode) error
+	Chown(p string, uid, gid int) error
+	// Files lists the regular files under dir, recursively.
+	Files(dir string) ([]string, error)
+}
+
+// copyIn writes the host file src to p.
+func copyIn(fsys partitionFS, src, p string, mode os.FileMode) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := fsys.MkdirAll(path.Dir(p)); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", p, err)
+	}
+	return fsys.WriteFile(p, data, mode)
+}
+
+// editFile runs edit on a host copy of p and writes the result back, so
+// the text helpers in utils.go work on files inside the image.
+func editFile(fsys partitionFS, p string, edit func(hostPath string) error) error {
+	data, err := fsys.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(workDir, "edit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if err := edit(tmp.Name()); err != nil {
+		return err
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, data) {
+		return nil
+	}
+	return fsys.WriteFile(p, edited, 0644)
+}
+
+// appendFile adds data to p, creating it when missing. data already in p
+// is not added again, so a configure stage can run twice.
+func appendFile(fsys partitionFS, p string, data []byte) error {
+	var current []byte
+	if fsys.Exists(p) {
+		var err error
+		if current, err = fsys.ReadFile(p); err != nil {
+			return err
+		}
+	}
+	if bytes.Contains(current, data) {
+		return nil
+	}
+	return fsys.WriteFile(p, append(current, data...), 0644)
+}
+
+// stageFile writes data to a temporary host file for the tools to copy in.
+func stageFile(data []byte) (string, error) {
+	tmp, err := os.CreateTemp(workDir, "stage-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// ext4FS drives debugfs on the filesystem at offset in the image.
+type ext4FS struct {
+	device string // image?offset=N, understood by libext2fs
+}
+
+func newExt4FS(imagePath string, offset int64) *ext4FS {
+	return &ext4FS{device: fmt.Sprintf("%s?offset=%d", imagePath, offset)}
+}
+
+// run feeds cmds to one debugfs session. debugfs exits 0 whatever its
+// commands do, so anything on stderr past the version banner is an error.
+func (fs *ext4FS) run(write bool, cmds ...string) ([]byte, error) {
+	args := []string{"-f", "-"}
+	if write {
+		args = append([]string{"-w"}, args...)
+	}
+	cmd := fakeTimeCommand(context.Background(), "debugfs", append(args, fs.device)...)
+	cmd.Env = append(os.Environ(), "DEBUGFS_PAGER=__none__")
+	cmd.Stdin = strings.NewReader(strings.Join(cmds, "\n") + "\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("debugfs: %w, output: %s", err, stderr.String())
+	}
+	var errs []string
+	for line := range strings.Lines(stderr.String()) {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "debugfs ") {
+			errs = append(errs, line)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("debugfs %s: %s", strings.Join(cmds, "; "), strings.Join(errs, "; "))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (fs *ext4FS) Exists(p string) bool {
+	_, err := fs.run(false, "stat "+quote(p))
+	return err == nil
+}
+
+func (fs *ext4FS) ReadFile(p string) ([]byte, error) {
+	if !fs.Exists(p) {
+		return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	// -R keeps the "debugfs:" echo out of the contents
+	cmd := exec.Command("debugfs", "-R", "cat "+quote(p), fs.device)
+	cmd.Env = append(os.Environ(), "DEBUGFS_PAGER=__none__")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("debugfs cat %s: %w, output: %s", p, err, stderr.String())
+	}
+	return out, nil
+}
+
+func (fs *ext4FS) WriteFile(p string, data []byte, mode os.FileMode) error {
+	src, err := stageFile(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(src)
+	var cmds []string
+	if fs.Exists(p) {
+		cmds = append(cmds, "rm "+quote(p))
+	}
+	cmds = append(cmds,
+		"write "+quote(src)+" "+quote(p),
+		fmt.Sprintf("sif %s mode 0%o", quote(p), 0100000|mode.Perm()),
+		fmt.Sprintf("sif %s uid 0", quote(p)),
+		fmt.Sprintf("sif %s gid 0", quote(p)),
+	)
+	_, err = fs.run(true, cmds...)
+	return err
+}
+
+func (fs *ext4FS) MkdirAll(p string) error {
+	if p == "/" || fs.Exists(p) {
+		return nil
+	}
+	if err := fs.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	_, err := fs.run(true, "mkdir "+quote(p))
+	return err
+}
+
+func (fs *ext4FS) RemoveAll(p string) error {
+	entries, err := fs.list(p)
+	if err != nil {
+		return nil // nothing to remove
+	}
+	if entries == nil { // not a directory
+		_, err := fs.run(true, "rm "+quote(p))
+		return err
+	}
+	for _, e := range entries {
+		if err := fs.RemoveAll(path.Join(p, e.name)); err != nil {
+			return err
+		}
+	}
+	_, err = fs.run(true, "rmdir "+quote(p))
+	return err
+}
+
+func (fs *ext4FS) Symlink(target, p string) error {
+	_, err := fs.run(true, "symlink "+quote(p)+" "+quote(target))
+	return err
+}
+
+func (fs *ext4FS) Chmod(p string, mode os.FileMode) error {
+	st, err := fs.list(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	for _, e := range st {
+		if e.name == path.Base(p) {
+			_, err := fs.run(true, fmt.Sprintf("sif %s mode 0%o", quote(p), e.mode&^0o7777|uint32(mode.Perm())))
+			return err
+		}
+	}
+	return fmt.Errorf("%s: %w", p, os.ErrNotExist)
+}
+
+func (fs *ext4FS) Chown(p string, uid, gid int) error {
+	_, err := fs.run(true, fmt.Sprintf("sif %s uid %d", quote(p), uid), fmt.Sprintf("sif %s gid %d", quote(p), gid))
+	return err
+}
+
+func (fs *ext4FS) Files(dir string) ([]string, error) {
+	entries, err := fs.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		p := path.Join(dir, e.name)
+		switch e.mode & 0o170000 {
+		case 0o040000:
+			sub, err := fs.Files(p)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		case 0o100000:
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+type ext4Entry struct {
+	name string
+	mode uint32
+}
+
+// list returns the entries of dir without . and .., or nil with no error
+// when dir is not a directory.
+func (fs *ext4FS) list(dir string) ([]ext4Entry, error) {
+	out, err := fs.run(false, "stat "+quote(dir))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(out, []byte("Type: directory")) {
+		return nil, nil
+	}
+	// ls -p: /inode/mode/uid/gid/name/size/
+	out, err = fs.run(false, "ls -p "+quote(dir))
+	if err != nil {
+		return nil, err
+	}
+	entries := []ext4Entry{}
+	for line := range strings.Lines(string(out)) {
+		fields := strings.Split(strings.TrimSpace(line), "/")
+		if len(fields) < 7 || fields[5] == "." || fields[5] == ".." {
+			continue
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, ext4Entry{name: fields[5], mode: uint32(mode)})
+	}
+	return entries, nil
+}
+
+// fatFS drives mtools on the filesystem at offset in the image. FAT keeps
+// no owners or modes, so Chmod and Chown do nothing.
+type fatFS struct {
+	image string // image@@offset, understood by mtools
+}
+
+func newFATFS(imagePath string, offset int64) *fatFS {
+	return &fatFS{image: fmt.Sprintf("%s@@%d", imagePath, offset)}
+}
+
+func (fs *fatFS) run(tool string, args ...string) ([]byte, error) {
+	cmd := fakeTimeCommand(context.Background(), tool, append([]string{"-i", fs.image}, args...)...)
+	cmd.Env = append(os.Environ(), "MTOOLS_SKIP_CHECK=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w, output: %s", tool, strings.Join(args, " "), err, out)
+	}
+	return out, nil
+}
+
+func (fs *fatFS) Exists(p string) bool {
+	if p == "/" {
+		return true
+	}
+	// list the parent: mdir on an empty directory fails
+	out, err := fs.run("mdir", "-b", "-a", "::"+path.Dir(p))
+	if err != nil {
+		return false
+	}
+	for line := range strings.Lines(string(out)) {
+		if strings.EqualFold(path.Base(strings.TrimRight(strings.TrimSpace(line), "/")), path.Base(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *fatFS) ReadFile(p string) ([]byte, error) {
+	dst, err := stageFile(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dst)
+	if _, err := fs.run("mcopy", "-n", "::"+p, dst); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(dst)
+}
+
+func (fs *fatFS) WriteFile(p string, data []byte, _ os.FileMode) error {
+	src, err := stageFile(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(src)
+	_, err = fs.run("mcopy", "-D", "o", src, "::"+p)
+	return err
+}
+
+func (fs *fatFS) MkdirAll(p string) error {
+	if fs.Exists(p) {
+		return nil
+	}
+	if err := fs.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	_, err := fs.run("mmd", "::"+p)
+	return err
+}
+
+func (fs *fatFS) RemoveAll(p string) error {
+	if !fs.Exists(p) {
+		return nil
+	}
+	if _, err := fs.run("mdel", "::"+p); err == nil {
+		return nil
+	}
+	_, err := fs.run("mdeltree", "::"+p)
+	return err
+}
+
+func (fs *fatFS) Symlink(target, p string) error {
+	return errors.New("FAT has no symlinks")
+}
+
+func (fs *fatFS) Chmod(string, os.FileMode) error { return nil }
+
+func (fs *fatFS) Chown(string, int, int) error { return nil }
+
+func (fs *fatFS) Files(dir string) ([]string, error) {
+	out, err := fs.run("mdir", "-/", "-b", "-a", "::"+dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for line := range strings.Lines(string(out)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, "/") {
+			continue
+		}
+		p := strings.TrimPrefix(line, "::")
+		if !strings.HasPrefix(p, "/") {
+			p = path.Join(dir, p)
+		}
+		files = append(files, p)
+	}
+	return files, nil
+}
+
+// quote makes p a single debugfs argument.
+func quote(p string) string {
+	return strconv.Quote(p)
+}
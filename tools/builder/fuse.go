@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -32,7 +33,7 @@ func fusefat_mount(imagePath string, mountPoint string, logger *slog.Logger) (fu
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel() // Always call cancel to release resources
 
-	output, err := exec.CommandContext(ctx, executable, "-o", "rw+", imagePath, mountPoint).CombinedOutput()
+	output, err := fakeTimeCommand(ctx, executable, "-o", "rw+", imagePath, mountPoint).CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("failed to mount FAT filesystem: %w, output: %s", err, output)
 	}
@@ -62,7 +63,7 @@ func fuse2fs_mount(imagePath string, mountPoint string, offset int, logger *slog
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel() // Always call cancel to release resources
 
-	output, err := exec.CommandContext(ctx, "fuse2fs", "-o", fmt.Sprintf("rw,offset=%d", offset), imagePath, mountPoint).CombinedOutput()
+	output, err := fakeTimeCommand(ctx, "fuse2fs", "-o", fmt.Sprintf("rw,offset=%d", offset), imagePath, mountPoint).CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("failed to mount FAT filesystem: %w, output: %s", err, output)
 	}
@@ -78,3 +79,37 @@ func fuse2fs_mount(imagePath string, mountPoint string, offset int, logger *slog
 		}
 	}, nil
 }
+
+// checkFuseHost fails a build whose configure stage has to rebuild the
+// initramfs (verity and the A/B layout) on a host that cannot do it, before
+// the long stages run. rebuildInitramfs mounts the rootfs and boot
+// partitions with FUSE and chroots into them, which needs /dev/fuse and
+// root; in a container that means --privileged.
+func checkFuseHost(cfg *buildConfig) error {
+	if !cfg.Rootfs.Verity && cfg.Partitions.Layout != PartitionLayoutAB {
+		return nil
+	}
+	var errs []error
+	for _, tool := range []string{"fuse2fs", "fusermount", "chroot"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			errs = append(errs, fmt.Errorf("%s is not installed", tool))
+		}
+	}
+	if _, err := exec.LookPath("fusefat"); err != nil {
+		if _, err := exec.LookPath("fusefatfs"); err != nil {
+			errs = append(errs, errors.New("neither fusefat nor fusefatfs is installed"))
+		}
+	}
+	if f, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0); err != nil {
+		errs = append(errs, fmt.Errorf("cannot open /dev/fuse: %w", err))
+	} else {
+		f.Close()
+	}
+	if os.Geteuid() != 0 {
+		errs = append(errs, errors.New("chroot needs root"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("verity and A/B builds rebuild the initramfs in a FUSE-mounted chroot (run the container with --privileged): %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+type injectTarget string
+
+const (
+	InjectBoot   injectTarget = "boot"
+	InjectRootfs injectTarget = "rootfs"
+	InjectApp    injectTarget = "app"
+	InjectData   injectTarget = "data"
+)
+
+// injection copies a host file or directory tree into one of the image's
+// partitions. Injections run after everything the builder writes to that
+// partition, in the order listed, so they can replace built-in files.
+type injection struct {
+	Src       string       `yaml:"src"`
+	Dst       string       `yaml:"dst"`
+	Partition injectTarget `yaml:"partition"` // default rootfs
+	UID       int          `yaml:"uid"`
+	GID       int          `yaml:"gid"`
+	// Mode (files) and DirMode (directories) are octal strings such as
+	// "0644"; files keep their host permission bits by default, and
+	// directories get 0755. FAT ignores modes and ownership.
+	Mode    string `yaml:"mode"`
+	DirMode string `yaml:"dir_mode"`
+}
+
+func (in injection) target() injectTarget {
+	if in.Partition == "" {
+		return InjectRootfs
+	}
+	return in.Partition
+}
+
+func parseMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q (expected octal, e.g. 0644)", s)
+	}
+	return os.FileMode(m), nil
+}
+
+func (c *buildConfig) validateInjections() []error {
+	var errs []error
+	for i, in := range c.Inject {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("inject[%d]: "+format, append([]any{i}, args...)...))
+		}
+		switch in.target() {
+		case InjectRootfs, InjectApp, InjectData:
+		case InjectBoot:
+			if in.UID != 0 || in.GID != 0 || in.Mode != "" || in.DirMode != "" {
+				fail("the boot partition is FAT and has no ownership or modes")
+			}
+		default:
+			fail("unknown partition %q (valid: boot, rootfs, app, data)", in.Partition)
+		}
+		if _, err := os.Lstat(in.Src); err != nil {
+			fail("%v", err)
+		}
+		if !path.IsAbs(in.Dst) {
+			fail("destination %q is not absolute", in.Dst)
+		}
+		if in.UID < 0 || in.GID < 0 {
+			fail("negative uid or gid")
+		}
+		if _, err := parseMode(in.Mode, 0); err != nil {
+			fail("%v", err)
+		}
+		if _, err := parseMode(in.DirMode, 0); err != nil {
+			fail("%v", err)
+		}
+	}
+	return errs
+}
+
+// applyInjections copies the injections for target into fsys under root
+// ("/" for the partition itself, "/boot" for a boot directory on the
+// rootfs).
+func applyInjections(fsys partitionFS, root string, target injectTarget, injections []injection, logger *slog.Logger) error {
+	for _, in := range injections {
+		if in.target() != target {
+			continue
+		}
+		logger.Info("Injecting", slog.String("src", in.Src), slog.String("dst", in.Dst), slog.String("partition", string(target)))
+		if err := inject(fsys, path.Join(root, in.Dst), in); err != nil {
+			return fmt.Errorf("failed to inject %s into %s: %w", in.Src, in.Dst, err)
+		}
+	}
+	return nil
+}
+
+func inject(fsys partitionFS, dst string, in injection) error {
+	dirMode, _ := parseMode(in.DirMode, 0755)
+	return filepath.WalkDir(in.Src, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(in.Src, hostPath)
+		if err != nil {
+			return err
+		}
+		p := path.Join(dst, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			if p == "/" {
+				return nil // the partition root keeps its owner and mode
+			}
+			if err := fsys.MkdirAll(p); err != nil {
+				return err
+			}
+			if err := fsys.Chmod(p, dirMode); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(hostPath)
+			if err != nil {
+				return err
+			}
+			if err := fsys.MkdirAll(path.Dir(p)); err != nil {
+				return err
+			}
+			if err := fsys.RemoveAll(p); err != nil {
+				return err
+			}
+			return fsys.Symlink(target, p)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			mode, _ := parseMode(in.Mode, info.Mode().Perm())
+			if err := copyIn(fsys, hostPath, p, mode); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s is not a file, directory or symlink", hostPath)
+		}
+		return fsys.Chown(p, in.UID, in.GID)
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -13,49 +14,164 @@ func isTTY(f *os.File) bool {
 	return term.IsTerminal(f.Fd())
 }
 
+const usage = `Usage: builder [-config build.yaml] [-board name] [-layout single|ab] [-sign-key key] [-log-format text|json] [-minimize] [-build-binaries] [-hostname name] [-authorized-keys file] [-wifi-ssid ssid] [-provision dir] [-from stage] [-until stage] [<source.img> <destination.img> [prod|dev] [--skip-wait]]
+
+       builder flash [-yes] [-allow-fixed] [-public-key key.pub] <image[.xz]> <device>
+
+       builder sign -key key [-timestamp epoch] <file>...
+
+Arguments override the matching settings of the config file.
+`
+
 func main() {
-	// 1. Check for command-line arguments
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: go run your_program.go <source.img> <destination.img>")
+	if len(os.Args) > 1 && os.Args[1] == "flash" {
+		runFlash(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		runSign(os.Args[2:])
+		return
+	}
+
+	// 1. Read the build configuration and command-line arguments
+	configPath := flag.String("config", "", "build configuration (YAML)")
+	board := flag.String("board", "", "board profile: "+boardNames())
+	layout := flag.String("layout", "", "partition layout: single, ab")
+	hostname := flag.String("hostname", "", "hostname of the device (default: tezsign)")
+	authorizedKeys := flag.String("authorized-keys", "", "SSH public keys for the dev user (dev images only)")
+	provision := flag.String("provision", "", "provisioning bundle the device installs on first boot (keystore/, tezsign.yaml)")
+	wifiSSID := flag.String("wifi-ssid", "", "Wi-Fi network to join (dev images only; $"+envWiFiPSK+" holds the passphrase)")
+	from := flag.String("from", "", "stage to start at: "+stageNames()+" (default: resume after the last completed stage)")
+	until := flag.String("until", "", "stage to stop after, keeping the working image")
+	minimize := flag.Bool("minimize", false, "shrink the rootfs and zero free space for a smaller download")
+	buildBinaries := flag.Bool("build-binaries", false, "cross-compile the gadget binaries before building the image")
+	logFormatFlag := flag.String("log-format", "text", "log output: text, or json for one JSON object per line on stdout")
+	signKey := flag.String("sign-key", "", "minisign or PEM ed25519 key to sign the image with ($"+envSignPassword+" unlocks it)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	format := logFormat(*logFormatFlag)
+	if format != LogFormatText && format != LogFormatJSON {
+		fmt.Printf("invalid log format %q (valid: text, json)\n", *logFormatFlag)
 		os.Exit(1)
 	}
-	sourcePath := os.Args[1]
-	destPath := os.Args[2]
-	flavour := StandardImage
-
-	if len(os.Args) >= 4 {
-		flavour = imageFlavour(os.Args[3])
-		switch flavour {
-		case StandardImage, DevImage:
-			// valid flavour
-		default:
-			fmt.Println("Invalid image flavour. Valid options are: prod, dev")
+	setupLogging(format)
+
+	cfg := defaultBuildConfig()
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadBuildConfig(*configPath); err != nil {
+			fmt.Println("Failed to load build configuration:", err)
 			os.Exit(1)
 		}
 	}
 
-	skipWait := false
-	if len(os.Args) == 5 {
-		skipWait = os.Args[4] == "--skip-wait"
+	args := flag.Args()
+	if len(args) >= 2 {
+		cfg.Source = args[0]
+		cfg.Output = args[1]
+	}
+	if len(args) >= 3 {
+		cfg.Flavour = imageFlavour(args[2])
+	}
+	if len(args) == 4 && args[3] == "--skip-wait" {
+		cfg.SkipWait = true
+	}
+	if *board != "" {
+		cfg.Board = *board
+	}
+	if *layout != "" {
+		cfg.Partitions.Layout = partitionLayout(*layout)
+	}
+	if *signKey != "" {
+		cfg.SignKey = *signKey
+	}
+	if *minimize {
+		cfg.Rootfs.Minimize = true
+	}
+	if *buildBinaries {
+		cfg.Binaries.Build = true
+	}
+	if *hostname != "" {
+		cfg.Access.Hostname = *hostname
+	}
+	if *authorizedKeys != "" {
+		cfg.Access.AuthorizedKeys = *authorizedKeys
+	}
+	if *wifiSSID != "" {
+		cfg.Access.WiFi.SSID = *wifiSSID
+	}
+	if *provision != "" {
+		cfg.Provision = *provision
+	}
+	if cfg.Version == "" {
+		cfg.Version = os.Getenv("IMAGE_ID")
+	}
+	cfg.resolveCommit()
+	if err := cfg.applyBoard(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	cfg.applyAccess()
+	if err := cfg.validate(); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := cfg.resolveEpoch(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var fromStage, untilStage buildStage
+	if *from != "" {
+		var err error
+		if fromStage, err = parseStage(*from); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *until != "" {
+		var err error
+		if untilStage, err = parseStage(*until); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	sourcePath := cfg.Source
+	destPath := cfg.Output
+	flavour := cfg.Flavour
+	skipWait := cfg.SkipWait
 
-	fmt.Println()
-	fmt.Println()
-	fmt.Println("==================== CREATING TEZSIGN IMAGE ====================")
-	fmt.Println("Source Image:", sourcePath)
-	fmt.Println("Destination Image:", destPath)
-	fmt.Println("Image Flavour: -----> ", flavour, "<-----")
-	fmt.Println("===============================================================")
-	fmt.Println()
-	fmt.Println()
+	if format == LogFormatJSON {
+		// the banner would break the JSON lines; settings go in a record
+		slog.Info("Build settings", slog.String("source", sourcePath), slog.String("destination", destPath),
+			slog.String("flavour", string(flavour)), slog.String("board", cfg.Board),
+			slog.String("layout", string(cfg.Partitions.Layout)), slog.Int64("source_date_epoch", cfg.SourceDateEpoch))
+	} else {
+		fmt.Println()
+		fmt.Println()
+		fmt.Println("==================== CREATING TEZSIGN IMAGE ====================")
+		fmt.Println("Source Image:", sourcePath)
+		fmt.Println("Destination Image:", destPath)
+		fmt.Println("Image Flavour: -----> ", flavour, "<-----")
+		fmt.Println("Board:", cfg.Board)
+		fmt.Println("Partition Layout:", cfg.Partitions.Layout)
+		fmt.Println("Source Date Epoch:", cfg.SourceDateEpoch)
+		fmt.Println("===============================================================")
+		fmt.Println()
+		fmt.Println()
 
-	if isTTY(os.Stdout) && !skipWait {
-		fmt.Println("Starting in 10 seconds")
-		for i := 0; i < 10; i++ {
-			fmt.Print(".")
-			time.Sleep(1 * time.Second)
+		if isTTY(os.Stdout) && !skipWait {
+			fmt.Println("Starting in 10 seconds")
+			for i := 0; i < 10; i++ {
+				fmt.Print(".")
+				time.Sleep(1 * time.Second)
+			}
+			fmt.Println()
 		}
-		fmt.Println()
 	}
 
 	logger := slog.Default()
@@ -67,34 +183,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 2. Copy the source image to the destination
-	logger.Info("Copying image file", slog.String("source", sourcePath), slog.String("destination", tmpImage))
-	err = copyFile(sourcePath, tmpImage)
-	if err != nil {
-		logger.Error("Failed to copy image file", slog.Any("error", err))
-		os.Exit(1)
-	}
-
-	if err = PartitionImage(tmpImage, flavour, logger); err != nil {
-		logger.Error("Failed to partition image", slog.Any("error", err))
-		os.Exit(1)
-	}
-
-	if err = ConfigureImage(workDir, tmpImage, flavour, logger); err != nil {
-		logger.Error("Failed to configure image", slog.Any("error", err))
+	if err = prepareBinaries(cfg, logger); err != nil {
+		logger.Error("Failed to prepare binaries", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	// 3. Move the modified image to the final destination
-	_ = destPath
-	// logger.Info("Moving modified image to destination", slog.String("source", tmpImage), slog.String("destination", destPath))
-
-	logger.Info("Copying final image to destination")
-	err = copyFileToXZ(tmpImage, destPath)
-	defer os.Remove(tmpImage)
-	if err != nil {
-		logger.Error("Failed to copy final image to destination", slog.Any("error", err))
+	// 2. Run the build stages on a working copy of the source image
+	if err = runStages(cfg, fromStage, untilStage, logger); err != nil {
+		logger.Error("Build failed", slog.Any("error", err))
 		os.Exit(1)
 	}
-	logger.Info("✅ Successfully created the customized image.", slog.String("path", destPath))
 }
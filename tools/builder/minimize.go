@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"golang.org/x/sys/unix"
+)
+
+// Minimized images shrink the rootfs to its content plus some headroom
+// before the TezSign partitions are laid out behind it, and have the free
+// space of every ext4 filesystem zeroed, so unused blocks compress to
+// nothing. The device never grows the rootfs: it is read-only after first
+// boot.
+
+const defaultRootfsHeadroomMB = 64
+
+var (
+	minSizeRe   = regexp.MustCompile(`Estimated minimum size of the filesystem: (\d+)`)
+	blockSizeRe = regexp.MustCompile(`(?m)^Block size:\s+(\d+)`)
+)
+
+func ext4Device(imagePath string, offset int64) string {
+	return fmt.Sprintf("%s?offset=%d", imagePath, offset)
+}
+
+// fsck runs e2fsck -fy with extra args; exit codes below 4 mean the
+// filesystem is clean or was fixed.
+func fsck(device string, args ...string) error {
+	out, err := fakeTimeCommand(context.Background(), "e2fsck", append(append([]string{"-fy"}, args...), device)...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() < 4 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("e2fsck %s: %w, output: %s", device, err, out)
+	}
+	return nil
+}
+
+// shrinkRootfs fits the rootfs filesystem to its content plus the headroom
+// and returns its new size in bytes, rounded up to a whole MiB. The space it
+// frees is zeroed; the partition stage lays the next partitions over it.
+func shrinkRootfs(imagePath string, rootPartition part.Partition, cfg *buildConfig, logger *slog.Logger) (int64, error) {
+	device := ext4Device(imagePath, rootPartition.GetStart())
+	if err := fsck(device); err != nil {
+		return 0, err
+	}
+
+	out, err := exec.Command("dumpe2fs", "-h", device).Output()
+	if err != nil {
+		return 0, fmt.Errorf("dumpe2fs: %w", err)
+	}
+	m := blockSizeRe.FindSubmatch(out)
+	if m == nil {
+		return 0, errors.New("dumpe2fs printed no block size")
+	}
+	blockSize, _ := strconv.ParseInt(string(m[1]), 10, 64)
+
+	out, err = exec.Command("resize2fs", "-P", device).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("resize2fs -P: %w, output: %s", err, out)
+	}
+	m = minSizeRe.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("resize2fs printed no minimum size: %s", out)
+	}
+	minBlocks, _ := strconv.ParseInt(string(m[1]), 10, 64)
+
+	const mib = 1024 * 1024
+	size := minBlocks*blockSize + int64(cfg.Rootfs.HeadroomMB)*mib
+	size = (size + mib - 1) / mib * mib
+	if size >= rootPartition.GetSize() {
+		logger.Info("Rootfs is already minimal", slog.Int64("size", rootPartition.GetSize()))
+		return rootPartition.GetSize(), nil
+	}
+
+	logger.Info("Shrinking rootfs", slog.Int64("from", rootPartition.GetSize()), slog.Int64("to", size), slog.Uint64("headroom_MB", cfg.Rootfs.HeadroomMB))
+	out, err = fakeTimeCommand(context.Background(), "resize2fs", device, fmt.Sprintf("%dK", size/1024)).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("resize2fs: %w, output: %s", err, out)
+	}
+	if err := zeroRange(imagePath, rootPartition.GetStart()+size, rootPartition.GetSize()-size); err != nil {
+		return 0, fmt.Errorf("failed to zero the freed rootfs space: %w", err)
+	}
+	return size, nil
+}
+
+// zeroFreeSpace discards the free blocks of the ext4 partitions, which
+// reads back as zeros from an image file. It runs before verity hashes the
+// rootfs and before slot b is copied from slot a.
+func zeroFreeSpace(imagePath string, logger *slog.Logger) error {
+	img, err := diskfs.Open(imagePath)
+	if err != nil {
+		return errors.Join(common.ErrFailedToOpenImage, err)
+	}
+	_, rootfsPartition, appPartition, dataPartition, err := common.GetTezsignPartitions(img)
+	img.Close()
+	if err != nil {
+		return err
+	}
+	var steps []func() error
+	for _, p := range []part.Partition{rootfsPartition, appPartition, dataPartition} {
+		logger.Info("Zeroing free space", slog.Int64("offset", p.GetStart()))
+		steps = append(steps, func() error {
+			return fsck(ext4Device(imagePath, p.GetStart()), "-E", "discard")
+		})
+	}
+	return runParallel(steps...)
+}
+
+// zeroRange punches a hole over length bytes at offset, or writes zeros
+// where the host filesystem cannot.
+func zeroRange(imagePath string, offset, length int64) error {
+	f, err := os.OpenFile(imagePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, length)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, unix.EOPNOTSUPP) {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, zeroReader{}, length)
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
-	"github.com/diskfs/go-diskfs/partition/part"
 	"github.com/samber/lo"
 	"github.com/tez-capital/tezsign/tools/common"
 	"github.com/tez-capital/tezsign/tools/constants"
@@ -23,13 +22,24 @@ type partition struct {
 }
 
 type partitions struct {
-	size uint64
-	root partition
-	app  partition
-	data partition
+	size      uint64
+	rootIndex int // index of the rootfs in the partition table
+	root      partition
+	app       partition
+	data      partition
+	// slot b and the env partition, only for the A/B layout
+	ab    bool
+	rootB partition
+	appB  partition
+	env   partition
+	// the encrypted data partition, when configured
+	vault partition
 }
 
-func resizeImage(imagePath string, flavour imageFlavour, logger *slog.Logger) (*partitions, error) {
+// envPartitionSizeMB holds the slot selection (see slots.go).
+const envPartitionSizeMB = 1
+
+func resizeImage(imagePath string, cfg *buildConfig, logger *slog.Logger) (*partitions, error) {
 	img, err := diskfs.Open(imagePath)
 	if err != nil {
 		return nil, errors.Join(common.ErrFailedToOpenImage, err)
@@ -47,20 +57,36 @@ func resizeImage(imagePath string, flavour imageFlavour, logger *slog.Logger) (*
 		logger.Warn("Could not determine block size, falling back to 512.")
 	}
 	imgPartitions := partitionTable.GetPartitions()
-	var rootPartition part.Partition
-	if len(imgPartitions) > 1 {
-		rootPartition = imgPartitions[1] // second partition is rootfs
-	} else {
-		rootPartition = imgPartitions[0] // fallback to first partition if only one exists e.g. radxa with binman
+	rootIndex := 0 // fallback to first partition if only one exists e.g. radxa with binman
+	switch {
+	case cfg.Boot.Layout == BootLayoutRootfs:
+	case cfg.Boot.Layout == BootLayoutSeparate && len(imgPartitions) < 2:
+		return nil, fmt.Errorf("board %s expects a boot and a rootfs partition, image has %d", cfg.Board, len(imgPartitions))
+	case len(imgPartitions) > 1 && imgPartitions[1].GetSize() > 0:
+		rootIndex = 1 // second partition is rootfs
+	}
+	rootPartition := imgPartitions[rootIndex]
+
+	rootfsBytes := rootPartition.GetSize()
+	if cfg.Rootfs.Minimize {
+		if rootfsBytes, err = shrinkRootfs(imagePath, rootPartition, cfg, logger); err != nil {
+			return nil, fmt.Errorf("failed to shrink rootfs: %w", err)
+		}
 	}
 
 	rootFsPartitionStart := rootPartition.GetStart() / logicalBlockSize // 0 indexed
-	rootfsSizeInSectors := uint64(rootPartition.GetSize() / logicalBlockSize)
+	rootfsSizeInSectors := uint64(rootfsBytes / logicalBlockSize)
+	if cfg.Rootfs.Verity {
+		// room for the hash tree behind the filesystem
+		hashSectors := verityHashSize(uint64(rootfsBytes)) / uint64(logicalBlockSize)
+		logger.Info("Growing rootfs partition for the verity hash tree", slog.Uint64("sectors", hashSectors))
+		rootfsSizeInSectors += hashSectors
+	}
 	sectorsPerMB := uint64(1024 * 1024 / logicalBlockSize)
 	img.Close()
 
-	appSizeInSectors := uint64(appPartitionSizeMB * sectorsPerMB)
-	dataSizeInSectors := uint64(dataPartitionSizeMB * sectorsPerMB)
+	appSizeInSectors := cfg.Partitions.AppMB * sectorsPerMB
+	dataSizeInSectors := cfg.Partitions.DataMB * sectorsPerMB
 
 	rootPartEnd := uint64(rootFsPartitionStart) + rootfsSizeInSectors
 	appPartStart := rootPartEnd + 1
@@ -70,6 +96,27 @@ func resizeImage(imagePath string, flavour imageFlavour, logger *slog.Logger) (*
 
 	lastSector := dataPartEnd
 
+	var rootB, appB, env partition
+	ab := cfg.Partitions.Layout == PartitionLayoutAB
+	if ab {
+		rootB = partition{start: lastSector + 1, sectorCount: rootfsSizeInSectors}
+		rootB.end = rootB.start + rootB.sectorCount
+		appB = partition{start: rootB.end + 1, sectorCount: appSizeInSectors}
+		appB.end = appB.start + appB.sectorCount
+		env = partition{start: appB.end + 1, sectorCount: envPartitionSizeMB * sectorsPerMB}
+		env.end = env.start + env.sectorCount
+		logger.Info("Adding slot b partitions", "rootfs_b", fmt.Sprintf("%d - %d", rootB.start, rootB.end), "app_b", fmt.Sprintf("%d - %d", appB.start, appB.end), "env", fmt.Sprintf("%d - %d", env.start, env.end))
+		lastSector = env.end
+	}
+
+	var vault partition
+	if cfg.Partitions.EncryptedDataMB > 0 {
+		vault = partition{start: lastSector + 1, sectorCount: cfg.Partitions.EncryptedDataMB * sectorsPerMB}
+		vault.end = vault.start + vault.sectorCount
+		logger.Info("Adding encrypted data partition", "vault", fmt.Sprintf("%d - %d", vault.start, vault.end))
+		lastSector = vault.end
+	}
+
 	requiredSizeBytes := lastSector * uint64(logicalBlockSize)
 	logger.Info("Resizing image", slog.Int("sectors_per_MB", int(sectorsPerMB)), "rootfs", fmt.Sprintf("%d - %d", rootFsPartitionStart, rootPartEnd), "app_partition", fmt.Sprintf("%d - %d", appPartStart, appPartEnd), "data_partition", fmt.Sprintf("%d - %d", dataPartStart, dataPartEnd), slog.Uint64("size_MB", requiredSizeBytes/(1024*1024)))
 	if err := os.Truncate(imagePath, int64(requiredSizeBytes)); err != nil {
@@ -77,7 +124,8 @@ func resizeImage(imagePath string, flavour imageFlavour, logger *slog.Logger) (*
 	}
 
 	return &partitions{
-		size: requiredSizeBytes,
+		size:      requiredSizeBytes,
+		rootIndex: rootIndex,
 		root: partition{
 			start:       uint64(rootFsPartitionStart),
 			end:         rootPartEnd,
@@ -93,10 +141,15 @@ func resizeImage(imagePath string, flavour imageFlavour, logger *slog.Logger) (*
 			end:         dataPartEnd,
 			sectorCount: dataSizeInSectors,
 		},
+		ab:    ab,
+		rootB: rootB,
+		appB:  appB,
+		env:   env,
+		vault: vault,
 	}, nil
 }
 
-func createPartitions(path string, partitionSpecs *partitions) error {
+func createPartitions(path string, partitionSpecs *partitions, cfg *buildConfig) error {
 	img, err := diskfs.Open(path)
 	if err != nil {
 		return errors.Join(common.ErrFailedToOpenImage, err)
@@ -115,6 +168,13 @@ func createPartitions(path string, partitionSpecs *partitions) error {
 		if len(newPartitions) > 2 {
 			newPartitions = newPartitions[:2] // keep only first two partitions, there may be more but with size 0
 		}
+		rootPartition := newPartitions[partitionSpecs.rootIndex]
+		rootPartition.End = rootPartition.Start + partitionSpecs.root.sectorCount - 1
+		sectorSize := uint64(img.LogicalBlocksize)
+		if sectorSize == 0 {
+			sectorSize = 512 // same fallback as resizeImage
+		}
+		rootPartition.Size = partitionSpecs.root.sectorCount * sectorSize
 
 		partitionsToAdd := []*gpt.Partition{
 			{
@@ -131,6 +191,43 @@ func createPartitions(path string, partitionSpecs *partitions) error {
 			},
 		}
 
+		if partitionSpecs.ab {
+			rootPartition.Name = constants.RootfsAPartitionLabel
+			partitionsToAdd = append(partitionsToAdd,
+				&gpt.Partition{
+					Start: partitionSpecs.rootB.start,
+					End:   partitionSpecs.rootB.end - 1,
+					Type:  rootPartition.Type,
+					Name:  constants.RootfsBPartitionLabel,
+				},
+				&gpt.Partition{
+					Start: partitionSpecs.appB.start,
+					End:   partitionSpecs.appB.end,
+					Type:  gpt.MicrosoftBasicData,
+					Name:  constants.AppBPartitionLabel,
+				},
+				&gpt.Partition{
+					Start: partitionSpecs.env.start,
+					End:   partitionSpecs.env.end,
+					Type:  gpt.LinuxFilesystem,
+					Name:  constants.EnvPartitionLabel,
+				},
+			)
+		}
+
+		if partitionSpecs.vault.sectorCount > 0 {
+			partitionsToAdd = append(partitionsToAdd, &gpt.Partition{
+				Start: partitionSpecs.vault.start,
+				End:   partitionSpecs.vault.end,
+				Type:  gpt.LinuxFilesystem,
+				Name:  constants.VaultPartitionLabel,
+			})
+		}
+
+		for _, p := range partitionsToAdd {
+			p.GUID = cfg.uuidFor("partition " + p.Name)
+		}
+
 		gptTable.Partitions = append(newPartitions, partitionsToAdd...)
 		gptTable.Repair(partitionSpecs.size)
 
@@ -138,6 +235,12 @@ func createPartitions(path string, partitionSpecs *partitions) error {
 			return errors.Join(common.ErrFailedToWritePartitionTable, err)
 		}
 	case *mbr.Table:
+		if partitionSpecs.ab {
+			return errors.New("the A/B layout needs a GPT image; MBR has no room for its partitions")
+		}
+		if partitionSpecs.vault.sectorCount > 0 {
+			return errors.New("the encrypted data partition needs a GPT image; MBR has no room for it")
+		}
 		mbrTable := table
 		partitionsWithNonZeroSize := lo.Filter(mbrTable.Partitions, func(par *mbr.Partition, _ int) bool {
 			return par.Size > 0
@@ -151,6 +254,7 @@ func createPartitions(path string, partitionSpecs *partitions) error {
 		if len(newPartitions) > 2 {
 			newPartitions = newPartitions[:2] // keep only first two partitions, there may be more but with size 0
 		}
+		newPartitions[partitionSpecs.rootIndex].Size = uint32(partitionSpecs.root.sectorCount)
 
 		partitionsToAdd := []*mbr.Partition{
 			{
@@ -180,56 +284,75 @@ func createPartitions(path string, partitionSpecs *partitions) error {
 	return nil
 }
 
-func formatPartitionTable(path string, flavour imageFlavour, logger *slog.Logger) error {
+func formatPartitionTable(path string, cfg *buildConfig, logger *slog.Logger) error {
 	img, err := diskfs.Open(path)
 	if err != nil {
 		return errors.Join(common.ErrFailedToOpenImage, err)
 	}
 	defer img.Close()
 
-	table, err := img.GetPartitionTable()
+	_, _, appPartition, dataPartition, err := common.GetTezsignPartitions(img)
 	if err != nil {
-		return errors.Join(common.ErrFailedToOpenPartitionTable, err)
+		return err
+	}
+	_, appBPartition, _, err := common.GetTezsignSlotPartitions(img)
+	if err != nil {
+		return err
+	}
+	vaultPartition, err := common.GetTezsignVaultPartition(img)
+	if err != nil {
+		return err
 	}
-
-	partitions := table.GetPartitions()
-	appPartitionIndex := len(partitions) - 2
-	dataPartitionIndex := len(partitions) - 1
-	logger.Info("Formatting partitions", slog.Int("app_partition_index", appPartitionIndex), slog.Int("data_partition_index", dataPartitionIndex))
-
-	appPartition := partitions[appPartitionIndex]
-	dataPartition := partitions[dataPartitionIndex]
 
 	appPartitionOffset := int64(appPartition.GetStart())
 	dataPartitionOffset := int64(dataPartition.GetStart())
 	appPartitionSize := int64(appPartition.GetSize())
 	dataPartitionSize := int64(dataPartition.GetSize())
 
+	// The filesystems lie in disjoint ranges of the image, so they are
+	// created concurrently.
 	// mkfs.ext4 -E offset=104857600,root_owner=1000:1000 -F disk.img 51200K
 	slog.Info("Formatting app and data partitions", slog.Int64("app_offset", appPartitionOffset), slog.Int64("app_size", appPartitionSize), slog.Int64("data_offset", dataPartitionOffset), slog.Int64("data_size", dataPartitionSize))
-	if err := exec.Command("mkfs.ext4", "-E", fmt.Sprintf("offset=%d", appPartitionOffset), "-F", path, fmt.Sprintf("%dK", appPartitionSize/1024), "-L", constants.AppPartitionLabel).Run(); err != nil {
-		return errors.Join(common.ErrFailedToFormatPartition, err)
+	steps := []func() error{
+		func() error {
+			return exec.Command("mkfs.ext4", "-U", cfg.uuidFor(constants.AppPartitionLabel), "-E", fmt.Sprintf("offset=%d,hash_seed=%s", appPartitionOffset, cfg.uuidFor(constants.AppPartitionLabel+" hash")), "-F", path, fmt.Sprintf("%dK", (appPartitionSize-appStateMirrorSize)/1024), "-L", constants.AppPartitionLabel).Run()
+		},
+		// The data partition holds the keystore and watermarks. ext4 with a
+		// journal, mounted data=journal (see patchRootPartition), replays both
+		// metadata and data after a power cut. ext4 treats data=journal inodes
+		// as ineligible for fast commits, so fast_commit does not shorten
+		// those fsyncs; they take a full journal commit.
+		func() error {
+			return exec.Command("mkfs.ext4", "-J", "size=8", "-m", "0", "-I", "1024", "-O", "inline_data,fast_commit", "-U", cfg.uuidFor(constants.DataPartitionLabel), "-E", fmt.Sprintf("offset=%d,hash_seed=%s", dataPartitionOffset, cfg.uuidFor(constants.DataPartitionLabel+" hash")), "-F", path, fmt.Sprintf("%dK", dataPartitionSize/1024), "-L", constants.DataPartitionLabel).Run()
+		},
 	}
 
-	slog.Info("Formatting data partition", slog.Int64("data_offset", dataPartitionOffset), slog.Int64("data_size", dataPartitionSize))
-	if err := exec.Command("mkfs.ext4", "-J", "size=8", "-m", "0", "-I", "1024", "-O", "inline_data,fast_commit", "-E", fmt.Sprintf("offset=%d", dataPartitionOffset), "-F", path, fmt.Sprintf("%dK", dataPartitionSize/1024), "-L", constants.DataPartitionLabel).Run(); err != nil {
-		return errors.Join(common.ErrFailedToFormatPartition, err)
+	if appBPartition != nil {
+		slog.Info("Formatting app_b partition", slog.Int64("offset", appBPartition.GetStart()), slog.Int64("size", appBPartition.GetSize()))
+		steps = append(steps, func() error {
+			return exec.Command("mkfs.ext4", "-U", cfg.uuidFor(constants.AppBPartitionLabel), "-E", fmt.Sprintf("offset=%d,hash_seed=%s", appBPartition.GetStart(), cfg.uuidFor(constants.AppBPartitionLabel+" hash")), "-F", path, fmt.Sprintf("%dK", (appBPartition.GetSize()-appStateMirrorSize)/1024), "-L", constants.AppBPartitionLabel).Run()
+		})
 	}
 
+	if vaultPartition != nil {
+		steps = append(steps, func() error {
+			return formatVaultPartition(img, vaultPartition, logger)
+		})
+	}
+
+	if err := runParallel(steps...); err != nil {
+		return errors.Join(common.ErrFailedToFormatPartition, err)
+	}
 	return nil
 }
 
-func PartitionImage(path string, flavour imageFlavour, logger *slog.Logger) error {
-	partitionSpecs, err := resizeImage(path, flavour, logger)
+func PartitionImage(path string, cfg *buildConfig, logger *slog.Logger) error {
+	partitionSpecs, err := resizeImage(path, cfg, logger)
 	if err != nil {
 		return errors.Join(common.ErrFailedToPartitionImage, err)
 	}
 
-	if err := createPartitions(path, partitionSpecs); err != nil {
-		return errors.Join(common.ErrFailedToPartitionImage, err)
-	}
-
-	if err := formatPartitionTable(path, flavour, logger); err != nil {
+	if err := createPartitions(path, partitionSpecs, cfg); err != nil {
 		return errors.Join(common.ErrFailedToPartitionImage, err)
 	}
 
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type logFormat string
+
+const (
+	LogFormatText logFormat = "text"
+	// LogFormatJSON writes every log record, progress included, as one JSON
+	// object per line on stdout.
+	LogFormatJSON logFormat = "json"
+)
+
+var (
+	// progressBar redraws a status line on a terminal; otherwise progress
+	// goes to the log every progressLogInterval.
+	progressBar         bool
+	progressLogInterval = 10 * time.Second
+)
+
+// setupLogging installs the default logger for format.
+func setupLogging(format logFormat) {
+	switch format {
+	case LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	default:
+		progressBar = isTTY(os.Stderr)
+	}
+}
+
+// progress reports the bytes of a long copy: a status line on a terminal,
+// log records with percent and ETA elsewhere.
+type progress struct {
+	what  string
+	total int64
+	start time.Time
+	done  atomic.Int64
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func startProgress(what string, total int64) *progress {
+	p := &progress{what: what, total: total, start: time.Now(), stop: make(chan struct{})}
+	interval := progressLogInterval
+	if progressBar {
+		interval = time.Second
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.report()
+			}
+		}
+	}()
+	return p
+}
+
+// Reader counts what is read through r.
+func (p *progress) Reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+// Finish stops the reports and logs where the copy ended.
+func (p *progress) Finish() {
+	close(p.stop)
+	p.wg.Wait()
+	if progressBar {
+		fmt.Fprintln(os.Stderr)
+	}
+	slog.Info("Progress", p.attrs(true)...)
+}
+
+func (p *progress) report() {
+	if progressBar {
+		done := p.done.Load()
+		fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%.0f%%) ETA %s   ", p.what, humanBytes(done), humanBytes(p.total), percentOf(done, p.total), p.eta(done))
+		return
+	}
+	slog.Info("Progress", p.attrs(false)...)
+}
+
+// attrs are the fields of a progress record; times are whole seconds.
+func (p *progress) attrs(finished bool) []any {
+	done := p.done.Load()
+	attrs := []any{
+		slog.String("what", p.what),
+		slog.Int64("bytes", done),
+		slog.Int64("total", p.total),
+		slog.Float64("percent", float64(int(percentOf(done, p.total)*10))/10),
+		slog.Int64("elapsed_s", int64(time.Since(p.start).Seconds())),
+	}
+	if !finished {
+		attrs = append(attrs, slog.Int64("eta_s", int64(p.eta(done).Seconds())))
+	}
+	return attrs
+}
+
+func (p *progress) eta(done int64) time.Duration {
+	if p.total <= 0 || done <= 0 {
+		return 0
+	}
+	elapsed := time.Since(p.start)
+	return time.Duration(float64(elapsed) * float64(p.total-done) / float64(done)).Round(time.Second)
+}
+
+func percentOf(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(done) * 100 / float64(total)
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.done.Add(int64(n))
+	return n, err
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// trackFile opens path for reading with progress reporting.
+func trackFile(what, path string) (io.Reader, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	p := startProgress(what, st.Size())
+	return p.Reader(f), func() error {
+		p.Finish()
+		return f.Close()
+	}, nil
+}
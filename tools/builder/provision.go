@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// provisionDir is where the bundle lands on the data partition (/data on
+// the device). first-boot-setup.sh installs it and removes it.
+const provisionDir = "/provision"
+
+// provisionEntries are what a bundle may hold:
+//
+//	keystore/     an encrypted keystore, as in /data/tezsign/keystore of a
+//	              provisioned device; imported when the device has none
+//	tezsign.yaml  the gadget configuration, installed as /data/tezsign.yaml
+//
+// Key IDs are bound into each key's encryption, so the keys keep the
+// aliases they were created with.
+var provisionEntries = map[string]bool{"keystore": true, "tezsign.yaml": false}
+
+func (c *buildConfig) validateProvision() []error {
+	if c.Provision == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(c.Provision)
+	if err != nil {
+		return []error{fmt.Errorf("provisioning bundle: %w", err)}
+	}
+	var errs []error
+	for _, e := range entries {
+		isDir, known := provisionEntries[e.Name()]
+		switch {
+		case !known:
+			errs = append(errs, fmt.Errorf("provisioning bundle: unexpected %s (expected keystore/ or tezsign.yaml)", e.Name()))
+		case e.IsDir() != isDir:
+			errs = append(errs, fmt.Errorf("provisioning bundle: %s has the wrong type", e.Name()))
+		}
+	}
+	keystore := filepath.Join(c.Provision, "keystore")
+	if _, err := os.Stat(keystore); err == nil {
+		if _, err := os.Stat(filepath.Join(keystore, "master.json")); err != nil {
+			errs = append(errs, fmt.Errorf("provisioning bundle: keystore has no master.json: %w", err))
+		}
+		if c.Partitions.EncryptedDataMB > 0 {
+			// the vault is only created when the device is initialized
+			errs = append(errs, errors.New("provisioning bundle: a keystore cannot be imported into the encrypted data partition"))
+		}
+	}
+	if len(entries) == 0 {
+		errs = append(errs, fmt.Errorf("provisioning bundle %s is empty", c.Provision))
+	}
+	return errs
+}
+
+// installProvisioning copies the bundle to the data partition, readable by
+// root only.
+func installProvisioning(datafs partitionFS, bundle string, logger *slog.Logger) error {
+	logger.Info("Installing provisioning bundle", slog.String("bundle", bundle))
+	return filepath.WalkDir(bundle, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(bundle, hostPath)
+		if err != nil {
+			return err
+		}
+		p := path.Join(provisionDir, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			if err := datafs.MkdirAll(p); err != nil {
+				return err
+			}
+			return datafs.Chmod(p, 0700)
+		case d.Type().IsRegular():
+			return copyIn(datafs, hostPath, p, 0600)
+		default:
+			return fmt.Errorf("provisioning bundle: %s is not a regular file", hostPath)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// releasePath is read by the gadget and reported in its status reply.
+const releasePath = "/etc/tezsign-release"
+
+// resolveCommit defaults the commit to $GIT_COMMIT, else the HEAD of the
+// checkout the builder runs from.
+func (c *buildConfig) resolveCommit() {
+	if c.Commit != "" {
+		return
+	}
+	if c.Commit = os.Getenv("GIT_COMMIT"); c.Commit != "" {
+		return
+	}
+	if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		c.Commit = strings.TrimSpace(string(out))
+	}
+}
+
+// partitionLayoutDigest hashes the partition table type and each
+// partition's offset, size and UUID.
+func partitionLayoutDigest(img *disk.Disk) (string, error) {
+	table, err := img.GetPartitionTable()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintln(h, table.Type())
+	for _, p := range table.GetPartitions() {
+		fmt.Fprintf(h, "%d %d %s\n", p.GetStart(), p.GetSize(), p.UUID())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeRelease records what the image was built from in /etc/tezsign-release.
+func writeRelease(rootfs partitionFS, img *disk.Disk, cfg *buildConfig, logger *slog.Logger) error {
+	layout, err := partitionLayoutDigest(img)
+	if err != nil {
+		return err
+	}
+	values := []struct{ key, value string }{
+		{"TEZSIGN_VERSION", cfg.Version},
+		{"TEZSIGN_COMMIT", cfg.Commit},
+		{"TEZSIGN_BUILD_TIME", time.Unix(cfg.SourceDateEpoch, 0).UTC().Format(time.RFC3339)},
+		{"TEZSIGN_FLAVOUR", string(cfg.Flavour)},
+		{"TEZSIGN_BOARD", cfg.Board},
+		{"TEZSIGN_LAYOUT", string(cfg.Partitions.Layout)},
+		{"TEZSIGN_PARTITION_LAYOUT_SHA256", layout},
+	}
+	data, err := keyValueFile(values)
+	if err != nil {
+		return err
+	}
+	if err := rootfs.WriteFile(releasePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", releasePath, err)
+	}
+	logger.Info("Wrote release file", slog.String("version", cfg.Version), slog.String("commit", cfg.Commit), slog.String("partition_layout_sha256", layout))
+	return nil
+}
+
+// writeAppVersion records the release on the app partition too, where the
+// updater compares it with the image it is about to write.
+func writeAppVersion(appfs partitionFS, cfg *buildConfig) error {
+	data, err := keyValueFile([]struct{ key, value string }{
+		{"TEZSIGN_VERSION", cfg.Version},
+		{"TEZSIGN_COMMIT", cfg.Commit},
+		{"TEZSIGN_BUILD_TIME", time.Unix(cfg.SourceDateEpoch, 0).UTC().Format(time.RFC3339)},
+	})
+	if err != nil {
+		return err
+	}
+	if err := appfs.WriteFile(constants.AppVersionFile, data, 0444); err != nil {
+		return fmt.Errorf("failed to write %s: %w", constants.AppVersionFile, err)
+	}
+	return nil
+}
+
+func keyValueFile(values []struct{ key, value string }) ([]byte, error) {
+	var b strings.Builder
+	for _, v := range values {
+		if strings.ContainsAny(v.value, "\r\n") {
+			return nil, fmt.Errorf("%s must be a single line", v.key)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", v.key, v.value)
+	}
+	return []byte(b.String()), nil
+}
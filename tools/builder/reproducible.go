@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Two builds from the same source image and configuration produce the same
+// bytes: every timestamp is the build epoch, every UUID and salt is derived
+// from the build seed and files are written in sorted order. Only the LUKS
+// keys of the encrypted data partition stay random.
+
+// xzConfig pins the xz parameters so library defaults cannot change the
+// output.
+var xzConfig = xz.WriterConfig{
+	Properties: &lzma.Properties{LC: 3, LP: 0, PB: 2},
+	DictCap:    8 * 1024 * 1024,
+	BufSize:    4096,
+	BlockSize:  64 * 1024 * 1024,
+	CheckSum:   xz.CRC64,
+}
+
+// seedFrom hashes the source image together with the settings that change
+// the partition layout.
+func (c *buildConfig) seedFrom(sourceDigest []byte) {
+	h := sha256.New()
+	h.Write(sourceDigest)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%s", c.Flavour, c.Board, c.Partitions.Layout)
+	c.seed = h.Sum(nil)
+}
+
+// uuidFor derives a stable random-format UUID for what from the build seed.
+func (c *buildConfig) uuidFor(what string) string {
+	h := sha256.Sum256(append(append([]byte{}, c.seed...), what...))
+	id, _ := uuid.FromBytes(h[:16])
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id.String()
+}
+
+// saltFor derives a stable hex salt for what from the build seed.
+func (c *buildConfig) saltFor(what string) string {
+	h := sha256.Sum256(append(append([]byte("salt\x00"), c.seed...), what...))
+	return hex.EncodeToString(h[:])
+}
+
+// resolveEpoch picks the build time: source_date_epoch, else
+// $SOURCE_DATE_EPOCH, else the source image's modification time.
+func (c *buildConfig) resolveEpoch() error {
+	if c.SourceDateEpoch == 0 {
+		if v := os.Getenv("SOURCE_DATE_EPOCH"); v != "" {
+			epoch, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", v, err)
+			}
+			c.SourceDateEpoch = epoch
+		} else {
+			st, err := os.Stat(c.Source)
+			if err != nil {
+				return err
+			}
+			c.SourceDateEpoch = st.ModTime().Unix()
+		}
+	}
+	epoch := time.Unix(c.SourceDateEpoch, 0).UTC()
+	// inherited by mkfs.ext4, tune2fs, debugfs, update-initramfs and the fuse helpers
+	os.Setenv("TZ", "UTC")
+	os.Setenv("SOURCE_DATE_EPOCH", strconv.FormatInt(c.SourceDateEpoch, 10))
+	os.Setenv("E2FSPROGS_FAKE_TIME", strconv.FormatInt(c.SourceDateEpoch, 10))
+	os.Setenv("FAKETIME", epoch.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// fakeTimeCommand runs name under faketime when it is installed, so the
+// filesystem tools stamp what they write with the build epoch.
+func fakeTimeCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if _, err := exec.LookPath("faketime"); err != nil {
+		return exec.CommandContext(ctx, name, args...)
+	}
+	return exec.CommandContext(ctx, "faketime", append([]string{"-f", os.Getenv("FAKETIME"), name}, args...)...)
+}
+
+// copyFileDigest copies src to dst and returns the SHA256 of the contents.
+func copyFileDigest(src, dst string) ([]byte, error) {
+	sourceFile, closeSource, err := trackFile(filepath.Base(dst), src)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer destFile.Close()
+
+	h := sha256.New()
+	w := &sparseWriter{f: destFile}
+	if _, err := copyBuffered(io.MultiWriter(w, h), sourceFile); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), w.finish()
+}
+
+// fileDigest returns the hex SHA256 and size of path.
+func fileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := copyBuffered(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// writeChecksum writes path.sha256 in sha256sum format and returns the
+// digest.
+func writeChecksum(path string) (string, error) {
+	sum, _, err := fileDigest(path)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return sum, os.WriteFile(path+".sha256", []byte(line), 0644)
+}
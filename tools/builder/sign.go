@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+// envSignPassword unlocks an encrypted minisign secret key.
+const envSignPassword = "TEZSIGN_SIGN_PASSWORD"
+
+const signUsage = `Usage: builder sign -key key [-timestamp epoch] <file>...
+
+Writes <file>.sig, a minisign signature, next to each file, e.g. the gadget
+binary for app-only updates. Images are signed by the build itself.
+`
+
+func runSign(args []string) {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := flags.String("key", "", "minisign or PEM ed25519 key ($"+envSignPassword+" unlocks it)")
+	epoch := flags.Int64("timestamp", 0, "timestamp for the trusted comment (default: $SOURCE_DATE_EPOCH or now)")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), signUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *keyPath == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	setupLogging(LogFormatText)
+	logger := slog.Default()
+	if *epoch == 0 {
+		*epoch = time.Now().Unix()
+		if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+			var err error
+			if *epoch, err = strconv.ParseInt(s, 10, 64); err != nil {
+				logger.Error("Invalid SOURCE_DATE_EPOCH", slog.Any("error", err))
+				os.Exit(1)
+			}
+		}
+	}
+	key, err := loadSigningKey(*keyPath)
+	if err != nil {
+		logger.Error("Failed to load signing key", slog.Any("error", err))
+		os.Exit(1)
+	}
+	for _, path := range flags.Args() {
+		if err := signFile(key, path, *epoch); err != nil {
+			logger.Error("Failed to sign", slog.String("file", path), slog.Any("error", err))
+			os.Exit(1)
+		}
+		logger.Info("Signed", slog.String("file", path), slog.String("key_id", fmt.Sprintf("%X", key.ID)))
+	}
+}
+
+func loadSigningKey(path string) (*common.SigningKey, error) {
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return common.ParseSigningKey(keyData, []byte(os.Getenv(envSignPassword)))
+}
+
+// signFile writes path.sig. epoch goes into the trusted comment, so
+// signing the same file with the same key gives the same bytes.
+func signFile(key *common.SigningKey, path string, epoch int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", epoch, filepath.Base(path))
+	sig, err := key.Sign(f, "signature from tezsign builder", trusted)
+	if err != nil {
+		return fmt.Errorf("failed to sign %s: %w", path, err)
+	}
+	return os.WriteFile(path+common.SignatureSuffix, sig, 0644)
+}
+
+// signImage writes <image>.sig, <image>.manifest.json and its .sig. The
+// timestamp in the trusted comments is the build epoch, so a rebuild signs
+// to the same bytes. chunks is the chunk index of a delta update.
+func signImage(imagePath, rawPath string, chunks *common.ManifestFile, cfg *buildConfig, logger *slog.Logger) error {
+	key, err := loadSigningKey(cfg.SignKey)
+	if err != nil {
+		return err
+	}
+
+	image, err := manifestFile(imagePath)
+	if err != nil {
+		return err
+	}
+	raw, err := manifestFile(rawPath)
+	if err != nil {
+		return err
+	}
+	raw.Name = ""
+	binaries, err := binaryDigests()
+	if err != nil {
+		return err
+	}
+	manifest := common.ImageManifest{
+		Version:         cfg.Version,
+		Commit:          cfg.Commit,
+		Flavour:         string(cfg.Flavour),
+		Board:           cfg.Board,
+		Layout:          string(cfg.Partitions.Layout),
+		SourceDateEpoch: cfg.SourceDateEpoch,
+		KeyID:           fmt.Sprintf("%X", key.ID),
+		Binaries:        binaries,
+		Image:           image,
+		Raw:             raw,
+		Chunks:          chunks,
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestPath := imagePath + common.ManifestSuffix
+	if err := os.WriteFile(manifestPath, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	for _, path := range []string{imagePath, manifestPath} {
+		if err := signFile(key, path, cfg.SourceDateEpoch); err != nil {
+			return err
+		}
+	}
+
+	if _, err := common.VerifyImage(key.Public(), imagePath); err != nil {
+		return fmt.Errorf("signature self-check failed: %w", err)
+	}
+	logger.Info("Signed image", slog.String("key_id", manifest.KeyID), slog.String("public_key", key.Public().String()))
+	return nil
+}
+
+func manifestFile(path string) (common.ManifestFile, error) {
+	sum, size, err := fileDigest(path)
+	if err != nil {
+		return common.ManifestFile{}, err
+	}
+	return common.ManifestFile{Name: filepath.Base(path), Size: size, SHA256: sum}, nil
+}
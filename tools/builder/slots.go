@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+var (
+	// SlotInjectFiles teach the initramfs to mount the rootfs slot named
+	// by the env partition.
+	SlotInjectFiles = map[string]string{
+		"tools/builder/assets/tezsign-slot-script.sh": "/etc/initramfs-tools/scripts/local-top/tezsign-slot",
+	}
+
+	SlotAdjustPermissions = map[string]os.FileMode{
+		"/etc/initramfs-tools/scripts/local-top/tezsign-slot": 0755,
+	}
+)
+
+// slotEnv is the first sector of the env partition; the updater switches
+// slots by rewriting it in one sector write.
+func slotEnv(slot string) []byte {
+	env := make([]byte, 512)
+	copy(env, fmt.Sprintf("TZENV1\nslot=%s\n", slot))
+	return env
+}
+
+// setupSlots fills slot b from the configured slot a and points the env
+// partition at slot a. It runs after ConfigureImage, so both slots start
+// out identical apart from their filesystem UUIDs and fstab.
+func setupSlots(imagePath string, cfg *buildConfig, logger *slog.Logger) error {
+	img, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		return errors.Join(common.ErrFailedToOpenImage, err)
+	}
+	defer img.Close()
+
+	bootPartition, rootfsPartition, _, _, err := common.GetTezsignPartitions(img)
+	if err != nil {
+		return err
+	}
+	rootfsBPartition, appBPartition, envPartition, err := common.GetTezsignSlotPartitions(img)
+	if err != nil {
+		return err
+	}
+	if rootfsBPartition == nil {
+		return errors.New("image has no slot b partitions")
+	}
+	if bootPartition == nil {
+		// the slot is picked by the initramfs both slots would have to share
+		return errors.New("the A/B layout needs a separate boot partition")
+	}
+
+	if err := rebuildInitramfs(img, imagePath, bootPartition, rootfsPartition, nil, logger); err != nil {
+		return fmt.Errorf("failed to rebuild initramfs: %w", err)
+	}
+
+	// slot b's app partition is patched while the rootfs is copied
+	err = runParallel(
+		func() error { return copyRootfsToSlotB(img, imagePath, rootfsPartition, rootfsBPartition, cfg, logger) },
+		func() error { return patchAppPartition(imagePath, appBPartition, cfg, logger) },
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Selecting slot a in the env partition")
+	writable, err := img.Backend.Writable()
+	if err != nil {
+		return fmt.Errorf("failed to get writable backend: %w", err)
+	}
+	if _, err := envPartition.WriteContents(writable, bytes.NewReader(slotEnv("a"))); err != nil {
+		return fmt.Errorf("failed to write env partition: %w", err)
+	}
+	return nil
+}
+
+// copyRootfsToSlotB copies the rootfs with a new UUID and points the copy's
+// fstab at the slot b partitions.
+func copyRootfsToSlotB(img *disk.Disk, imagePath string, rootfsPartition, rootfsBPartition part.Partition, cfg *buildConfig, logger *slog.Logger) error {
+	rootImg := path.Join(workDir, "rootfs.img")
+	if err := extractPartition(img, rootfsPartition, rootImg); err != nil {
+		return err
+	}
+	defer os.Remove(rootImg)
+	// the copy must not be mistaken for slot a by UUID
+	if out, err := exec.Command("tune2fs", "-U", cfg.uuidFor(constants.RootfsBPartitionLabel), rootImg).CombinedOutput(); err != nil {
+		return fmt.Errorf("tune2fs: %w, output: %s", err, out)
+	}
+	logger.Info("Copying rootfs to slot b", slog.Int64("bytes", rootfsPartition.GetSize()))
+	if err := writePartition(img, rootfsBPartition, rootImg); err != nil {
+		return err
+	}
+
+	err := editFile(newExt4FS(imagePath, rootfsBPartition.GetStart()), "/etc/fstab", func(fstabPath string) error {
+		if err := setFsTabDevice(fstabPath, "/", "PARTLABEL="+constants.RootfsBPartitionLabel); err != nil {
+			return err
+		}
+		return setFsTabDevice(fstabPath, "/app", "LABEL="+constants.AppBPartitionLabel)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to patch slot b fstab: %w", err)
+	}
+	return nil
+}
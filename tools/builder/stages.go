@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/tools/common"
+	"gopkg.in/yaml.v3"
+)
+
+// The build runs as named stages on the working image. After each stage
+// the builder records it in the state file, so a failed build resumes after
+// the last completed stage instead of copying the source image again. Every
+// stage can be run again on an image it already went through.
+
+type buildStage string
+
+const (
+	StageCopy      buildStage = "copy"
+	StagePartition buildStage = "partition"
+	StageFormat    buildStage = "format"
+	StageConfigure buildStage = "configure"
+	StageCompress  buildStage = "compress"
+)
+
+var buildStages = []buildStage{StageCopy, StagePartition, StageFormat, StageConfigure, StageCompress}
+
+const stateFile = workDir + "/state.json"
+
+// buildState is what a resumed build needs to know about the working image.
+type buildState struct {
+	// Config fingerprints the source image and the settings the working
+	// image depends on; a state left by another build is discarded.
+	Config       string     `json:"config"`
+	SourceDigest string     `json:"source_digest"`
+	Completed    buildStage `json:"completed"`
+}
+
+func stageNames() string {
+	names := make([]string, len(buildStages))
+	for i, s := range buildStages {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+func parseStage(name string) (buildStage, error) {
+	if !slices.Contains(buildStages, buildStage(name)) {
+		return "", fmt.Errorf("unknown stage %q (expected one of: %s)", name, stageNames())
+	}
+	return buildStage(name), nil
+}
+
+// fingerprint covers everything that ends up in the working image, but not
+// the output settings only the compress stage reads.
+func (c *buildConfig) fingerprint() (string, error) {
+	st, err := os.Stat(c.Source)
+	if err != nil {
+		return "", err
+	}
+	image := *c
+	image.Output, image.Compression, image.SignKey, image.SkipWait = "", "", "", false
+	image.Binaries = binariesConfig{} // injected again on every configure
+	b, err := yaml.Marshal(image)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(b)
+	fmt.Fprintf(h, "\x00%d\x00%d", st.Size(), st.ModTime().UnixNano())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadBuildState returns the state of the working image if it was built
+// with the same fingerprint, or a fresh state.
+func loadBuildState(fingerprint string, logger *slog.Logger) *buildState {
+	fresh := &buildState{Config: fingerprint}
+	b, err := os.ReadFile(stateFile)
+	if err != nil {
+		return fresh
+	}
+	var st buildState
+	if err := json.Unmarshal(b, &st); err != nil || st.Config != fingerprint {
+		logger.Info("Discarding the state of a previous build with other settings")
+		return fresh
+	}
+	if _, err := os.Stat(tmpImage); err != nil {
+		return fresh
+	}
+	return &st
+}
+
+func (st *buildState) save() error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := stateFile + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, stateFile)
+}
+
+// done reports whether stage completed on the working image.
+func (st *buildState) done(stage buildStage) bool {
+	return st.Completed != "" && slices.Index(buildStages, stage) <= slices.Index(buildStages, st.Completed)
+}
+
+// stageRange picks the stages to run: from defaults to the first stage not
+// completed yet, until to the last stage.
+func (st *buildState) stageRange(from, until buildStage) ([]buildStage, error) {
+	if from == "" {
+		from = StageCopy
+		if st.Completed != "" && st.Completed != StageCompress {
+			from = buildStages[slices.Index(buildStages, st.Completed)+1]
+		}
+	}
+	if until == "" {
+		until = StageCompress
+	}
+	start, end := slices.Index(buildStages, from), slices.Index(buildStages, until)
+	if start > end {
+		return nil, fmt.Errorf("stage %s comes after %s", from, until)
+	}
+	if start > 0 && !st.done(buildStages[start-1]) {
+		return nil, fmt.Errorf("cannot start at %s: the working image has not been through %s with these settings", from, buildStages[start-1])
+	}
+	return buildStages[start : end+1], nil
+}
+
+// runStages runs the stages from..until and records each one as it
+// completes.
+func runStages(cfg *buildConfig, from, until buildStage, logger *slog.Logger) error {
+	fingerprint, err := cfg.fingerprint()
+	if err != nil {
+		return err
+	}
+	st := loadBuildState(fingerprint, logger)
+	stages, err := st.stageRange(from, until)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(stages, StageConfigure) {
+		if err := checkFuseHost(cfg); err != nil {
+			return err
+		}
+	}
+	if st.SourceDigest != "" && stages[0] != StageCopy {
+		digest, err := hex.DecodeString(st.SourceDigest)
+		if err != nil {
+			return err
+		}
+		cfg.seedFrom(digest)
+		logger.Info("Resuming build", slog.String("from", string(stages[0])))
+	}
+
+	for i, stage := range stages {
+		logger.Info("Running stage", slog.String("stage", string(stage)), slog.Int("step", i+1), slog.Int("steps", len(stages)))
+		started := time.Now()
+		again := st.done(stage)
+		if again && stage != StagePartition {
+			// the stages after this one no longer describe the image
+			st.Completed = ""
+			if i := slices.Index(buildStages, stage); i > 0 {
+				st.Completed = buildStages[i-1]
+			}
+			if err := st.save(); err != nil {
+				return fmt.Errorf("failed to save build state: %w", err)
+			}
+		}
+		if err := runStage(stage, again, st, cfg, logger); err != nil {
+			return fmt.Errorf("stage %s: %w", stage, err)
+		}
+		logger.Info("Stage completed", slog.String("stage", string(stage)), slog.Int64("elapsed_s", int64(time.Since(started).Seconds())))
+		if stage == StageCompress {
+			// the working image is gone, nothing left to resume
+			return errors.Join(os.Remove(tmpImage), os.Remove(stateFile))
+		}
+		st.Completed = stage
+		if err := st.save(); err != nil {
+			return fmt.Errorf("failed to save build state: %w", err)
+		}
+	}
+	logger.Info("Stopped after stage, working image kept", slog.String("stage", string(st.Completed)), slog.String("path", tmpImage))
+	return nil
+}
+
+// runStage runs one stage; again is set when the working image has been
+// through it before.
+func runStage(stage buildStage, again bool, st *buildState, cfg *buildConfig, logger *slog.Logger) error {
+	switch stage {
+	case StageCopy:
+		logger.Info("Copying image file", slog.String("source", cfg.Source), slog.String("destination", tmpImage))
+		sourceDigest, err := copyFileDigest(cfg.Source, tmpImage)
+		if err != nil {
+			return err
+		}
+		cfg.seedFrom(sourceDigest)
+		st.SourceDigest = hex.EncodeToString(sourceDigest)
+		return nil
+	case StagePartition:
+		if again {
+			// the table is already the one these settings produce
+			logger.Info("Image is already partitioned, skipping")
+			return nil
+		}
+		return PartitionImage(tmpImage, cfg, logger)
+	case StageFormat:
+		if cfg.Partitions.EncryptedDataMB > 0 {
+			logger.Warn("The encrypted data partition has random LUKS keys; the image is not reproducible")
+		}
+		if err := formatPartitionTable(tmpImage, cfg, logger); err != nil {
+			return errors.Join(common.ErrFailedToFormatPartition, err)
+		}
+		return nil
+	case StageConfigure:
+		if err := ConfigureImage(workDir, tmpImage, cfg, logger); err != nil {
+			return err
+		}
+		if cfg.Rootfs.Minimize {
+			if err := zeroFreeSpace(tmpImage, logger); err != nil {
+				return fmt.Errorf("failed to zero free space: %w", err)
+			}
+		}
+		if cfg.Partitions.Layout == PartitionLayoutAB {
+			if err := setupSlots(tmpImage, cfg, logger); err != nil {
+				return fmt.Errorf("failed to set up the A/B slots: %w", err)
+			}
+		}
+		if cfg.Rootfs.Verity {
+			if err := protectRootfs(tmpImage, cfg, logger); err != nil {
+				return fmt.Errorf("failed to protect rootfs with verity: %w", err)
+			}
+		}
+		return nil
+	case StageCompress:
+		return writeOutput(cfg, logger)
+	}
+	return fmt.Errorf("unknown stage %q", stage)
+}
+
+// writeOutput copies the working image to the destination, compressed as
+// configured, and writes its checksum and signatures.
+func writeOutput(cfg *buildConfig, logger *slog.Logger) error {
+	logger.Info("Copying final image to destination", slog.String("compression", string(cfg.Compression)))
+	var err error
+	if cfg.Compression == CompressionNone {
+		err = copyFile(tmpImage, cfg.Output)
+	} else {
+		err = copyFileToXZ(tmpImage, cfg.Output)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy final image to destination: %w", err)
+	}
+	sum, err := writeChecksum(cfg.Output)
+	if err != nil {
+		return fmt.Errorf("failed to write image checksum: %w", err)
+	}
+	if cfg.SignKey != "" {
+		// a delta update needs the signed manifest to trust the chunks
+		chunks, err := writeChunks(tmpImage, cfg.Output, logger)
+		if err != nil {
+			return fmt.Errorf("failed to write delta chunks: %w", err)
+		}
+		if err = signImage(cfg.Output, tmpImage, chunks, cfg, logger); err != nil {
+			return fmt.Errorf("failed to sign image: %w", err)
+		}
+	}
+	logger.Info("✅ Successfully created the customized image.", slog.String("path", cfg.Output), slog.String("sha256", sum))
+	return nil
+}
@@ -2,21 +2,77 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
-
-	"github.com/ulikunitz/xz"
+	"sync"
 )
 
+// copyBufferSize keeps image copies at a few syscalls per MiB; it is a
+// multiple of every sector and block size.
+const copyBufferSize = 4 * 1024 * 1024
+
+var copyBuffers = sync.Pool{New: func() any {
+	b := make([]byte, copyBufferSize)
+	return &b
+}}
+
+// copyBuffered copies src to dst through a copyBufferSize buffer. dst and
+// src are wrapped so *os.File cannot fall back to its 32 KiB io.Copy.
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	b := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(b)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *b)
+}
+
+// sparseWriter writes a new file, leaving holes for all-zero chunks; the
+// holes read back as zeros and keep the working files small on disk.
+type sparseWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	if bytes.Count(p, []byte{0}) == len(p) {
+		w.off += int64(len(p))
+		return len(p), nil
+	}
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// finish sets the file size, which a trailing hole does not.
+func (w *sparseWriter) finish() error {
+	return w.f.Truncate(w.off)
+}
+
+// runParallel runs steps concurrently and joins their errors. The steps
+// must write disjoint parts of the image, e.g. different partitions.
+func runParallel(steps ...func() error) error {
+	errs := make([]error, len(steps))
+	var wg sync.WaitGroup
+	for i, step := range steps {
+		wg.Go(func() { errs[i] = step() })
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 // copyFile is a helper function to copy file contents
 func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+	sourceFile, closeSource, err := trackFile(filepath.Base(dst), src)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer closeSource()
 
 	destFile, err := os.Create(dst)
 	if err != nil {
@@ -24,16 +80,19 @@ func copyFile(src, dst string) error {
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	w := &sparseWriter{f: destFile}
+	if _, err := copyBuffered(w, sourceFile); err != nil {
+		return err
+	}
+	return w.finish()
 }
 
 func copyFileToXZ(src, dst string) error {
-	sourceFile, err := os.Open(src)
+	sourceFile, closeSource, err := trackFile(filepath.Base(dst), src)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer closeSource()
 
 	destFile, err := os.Create(dst)
 	if err != nil {
@@ -41,13 +100,13 @@ func copyFileToXZ(src, dst string) error {
 	}
 	defer destFile.Close()
 
-	xzWriter, err := xz.NewWriter(destFile)
+	xzWriter, err := xzConfig.NewWriter(destFile)
 	if err != nil {
 		return err
 	}
 	defer xzWriter.Close()
 
-	_, err = io.Copy(xzWriter, sourceFile)
+	_, err = copyBuffered(xzWriter, sourceFile)
 	return err
 }
 
@@ -122,6 +181,54 @@ func EditTxtFile(filePath string, edits []Edit) error {
 	return nil
 }
 
+// txtEdits turns key=value settings into edits in a stable order.
+func txtEdits(settings map[string]string) []Edit {
+	edits := make([]Edit, 0, len(settings))
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		edits = append(edits, Edit{Key: key, Value: settings[key]})
+	}
+	return edits
+}
+
+// mergedFiles returns the built-in injections plus the configured ones.
+func mergedFiles(builtin, extra map[string]string) map[string]string {
+	files := maps.Clone(builtin)
+	maps.Copy(files, extra)
+	return files
+}
+
+// readTxtValue returns the value of key in a key=value file, or "".
+func readTxtValue(filePath, key string) (string, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	for line := range strings.Lines(string(b)) {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), key+"="); ok {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// appendArgs adds the kernel arguments cmdline does not have yet. A
+// key=value argument replaces the one with the same key.
+func appendArgs(cmdline string, args []string) string {
+	have := strings.Fields(cmdline)
+	for _, arg := range args {
+		key, _, isKV := strings.Cut(arg, "=")
+		i := slices.IndexFunc(have, func(h string) bool {
+			return h == arg || isKV && strings.HasPrefix(h, key+"=")
+		})
+		if i < 0 {
+			have = append(have, arg)
+		} else {
+			have[i] = arg
+		}
+	}
+	return strings.Join(have, " ")
+}
+
 type mount struct {
 	point   string
 	options []string
@@ -181,3 +288,38 @@ func PathFsTab(filePath string, mounts []mount) error {
 
 	return nil
 }
+
+// setFsTabDevice points the fstab entry for mountPoint at device, keeping
+// its type and options.
+func setFsTabDevice(filePath, mountPoint, device string) error {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	lines := strings.Split(string(b), "\n")
+	found := false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || fields[1] != mountPoint {
+			continue
+		}
+		lines[i] = device + strings.TrimPrefix(strings.TrimLeft(line, " \t"), fields[0])
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%s has no entry for %s", filePath, mountPoint)
+	}
+	return os.WriteFile(filePath, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// inOrder ranges over m by key, so files land in the image in the same
+// order on every build.
+func inOrder[V any](m map[string]V) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			if !yield(k, m[k]) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+const (
+	// vaultMinSizeMB leaves room for the 16 MiB LUKS2 header.
+	vaultMinSizeMB = 32
+
+	// vaultProvisioningKey holds the key of the provisioning keyslot on the
+	// data partition until `init` enrolls the master passphrase and
+	// tezsign-vault.sh shreds it.
+	vaultProvisioningKey = "/tezsign-vault.key"
+	vaultKeyFile         = workDir + "/vault.key"
+)
+
+// vaultCrypttab records the mapping tezsign-vault.sh opens at unlock.
+// noauto keeps it out of the boot and the initramfs.
+var vaultCrypttab = fmt.Sprintf("tezsign-vault PARTLABEL=%s none luks,noauto\n", constants.VaultPartitionLabel)
+
+// formatVaultPartition makes the encrypted data partition a LUKS2 container
+// whose only keyslot opens with a random provisioning key. The filesystem
+// inside is created when the gadget first opens the vault.
+func formatVaultPartition(img *disk.Disk, vaultPartition part.Partition, logger *slog.Logger) error {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := os.WriteFile(vaultKeyFile, key, 0400); err != nil {
+		return err
+	}
+	clear(key)
+
+	vaultImg := path.Join(workDir, "vault.img")
+	if err := extractPartition(img, vaultPartition, vaultImg); err != nil {
+		return err
+	}
+	defer os.Remove(vaultImg)
+
+	logger.Info("Formatting encrypted data partition", slog.Int64("offset", vaultPartition.GetStart()), slog.Int64("size", vaultPartition.GetSize()))
+	// The provisioning key is random, so a cheap KDF loses nothing; the
+	// passphrase keyslot gets argon2id when it is enrolled.
+	out, err := exec.Command("cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2",
+		"--label", constants.VaultPartitionLabel,
+		"--pbkdf", "pbkdf2", "--pbkdf-force-iterations", "1000",
+		"--key-file", vaultKeyFile, vaultImg).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cryptsetup luksFormat: %w, output: %s", err, out)
+	}
+	return writePartition(img, vaultPartition, vaultImg)
+}
+
+// installVault wires the encrypted data partition into the rootfs: the
+// crypttab entry, dm-crypt at boot and the gadget's vault switch.
+func installVault(rootfs partitionFS, logger *slog.Logger) error {
+	logger.Info("Installing the encrypted data partition")
+	if err := appendFile(rootfs, "/etc/crypttab", []byte(vaultCrypttab)); err != nil {
+		return fmt.Errorf("failed to write /etc/crypttab: %w", err)
+	}
+
+	if err := setupModules(rootfs, "tezsign-vault.conf", []string{"dm_crypt"}, logger); err != nil {
+		return err
+	}
+
+	if err := appendFile(rootfs, "/etc/default/tezsign", []byte("TEZSIGN_VAULT=1\n")); err != nil {
+		return fmt.Errorf("failed to write /etc/default/tezsign: %w", err)
+	}
+	return nil
+}
+
+// installVaultKey puts the provisioning key on the data partition, readable
+// by root only.
+func installVaultKey(datafs partitionFS) error {
+	if _, err := os.Stat(vaultKeyFile); errors.Is(err, os.ErrNotExist) && datafs.Exists(vaultProvisioningKey) {
+		return nil // installed by an earlier run of the configure stage
+	}
+	if err := copyIn(datafs, vaultKeyFile, vaultProvisioningKey, 0400); err != nil {
+		return err
+	}
+	return os.Remove(vaultKeyFile)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+const verityBlockSize = 4096
+
+var (
+	// VerityInjectFiles teach the initramfs to open the rootfs through
+	// dm-verity when the cmdline carries tezsign.verity=<root hash>,<offset>.
+	VerityInjectFiles = map[string]string{
+		"tools/builder/assets/tezsign-verity-hook.sh":   "/etc/initramfs-tools/hooks/tezsign-verity",
+		"tools/builder/assets/tezsign-verity-script.sh": "/etc/initramfs-tools/scripts/local-top/tezsign-verity",
+	}
+
+	VerityAdjustPermissions = map[string]os.FileMode{
+		"/etc/initramfs-tools/hooks/tezsign-verity":             0755,
+		"/etc/initramfs-tools/scripts/local-top/tezsign-verity": 0755,
+	}
+
+	rootHashRe = regexp.MustCompile(`(?m)^Root hash:\s*([0-9a-f]+)$`)
+)
+
+// verityHashSize is the room the hash tree of dataBytes needs: sha256 over
+// 4K blocks takes 1/128 per level, plus the superblock, rounded up to 1 MiB.
+func verityHashSize(dataBytes uint64) uint64 {
+	const mib = 1024 * 1024
+	size := uint64(verityBlockSize) // superblock
+	for blocks := (dataBytes + verityBlockSize - 1) / verityBlockSize; blocks > 1; {
+		blocks = (blocks*32 + verityBlockSize - 1) / verityBlockSize
+		size += blocks * verityBlockSize
+	}
+	return (size + mib - 1) / mib * mib
+}
+
+// ext4Size reads the filesystem size from the ext4 superblock at the start
+// of f, so the hash tree lands right behind the filesystem.
+func ext4Size(f *os.File) (uint64, error) {
+	sb := make([]byte, 1024)
+	if _, err := f.ReadAt(sb, 1024); err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint16(sb[0x38:]) != 0xEF53 {
+		return 0, errors.New("rootfs is not ext4")
+	}
+	blocks := uint64(binary.LittleEndian.Uint32(sb[0x04:]))
+	if binary.LittleEndian.Uint32(sb[0x60:])&0x80 != 0 { // INCOMPAT_64BIT
+		blocks |= uint64(binary.LittleEndian.Uint32(sb[0x150:])) << 32
+	}
+	return blocks << (10 + binary.LittleEndian.Uint32(sb[0x18:])), nil
+}
+
+// protectRootfs rebuilds the initramfs with the verity script, writes the
+// hash tree behind the rootfs filesystem and puts the root hash on the
+// kernel cmdline. It runs last: any later write to the rootfs breaks boot.
+func protectRootfs(imagePath string, cfg *buildConfig, logger *slog.Logger) error {
+	img, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		return errors.Join(common.ErrFailedToOpenImage, err)
+	}
+	defer img.Close()
+
+	bootPartition, rootfsPartition, _, _, err := common.GetTezsignPartitions(img)
+	if err != nil {
+		return err
+	}
+	if bootPartition == nil {
+		// the cmdline would live on the rootfs it protects
+		return errors.New("verity needs a separate boot partition")
+	}
+
+	if err := rebuildInitramfs(img, imagePath, bootPartition, rootfsPartition, []string{"veritysetup"}, logger); err != nil {
+		return fmt.Errorf("failed to rebuild initramfs: %w", err)
+	}
+
+	rootImg := path.Join(workDir, "rootfs.img")
+	if err := extractPartition(img, rootfsPartition, rootImg); err != nil {
+		return err
+	}
+	defer os.Remove(rootImg)
+
+	f, err := os.Open(rootImg)
+	if err != nil {
+		return err
+	}
+	fsSize, err := ext4Size(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if fsSize+verityHashSize(fsSize) > uint64(rootfsPartition.GetSize()) {
+		return fmt.Errorf("rootfs partition has no room for the hash tree (filesystem %d bytes, partition %d)", fsSize, rootfsPartition.GetSize())
+	}
+
+	logger.Info("Generating verity hash tree", slog.Uint64("data_bytes", fsSize))
+	out, err := exec.Command("veritysetup", "format",
+		fmt.Sprintf("--data-block-size=%d", verityBlockSize),
+		fmt.Sprintf("--hash-block-size=%d", verityBlockSize),
+		fmt.Sprintf("--data-blocks=%d", fsSize/verityBlockSize),
+		fmt.Sprintf("--hash-offset=%d", fsSize),
+		"--salt="+cfg.saltFor("verity"),
+		"--uuid="+cfg.uuidFor("verity"),
+		rootImg, rootImg).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("veritysetup format: %w, output: %s", err, out)
+	}
+	m := rootHashRe.FindSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("veritysetup printed no root hash: %s", out)
+	}
+	rootHash := string(m[1])
+	logger.Info("Rootfs root hash", slog.String("hash", rootHash))
+
+	if err := writePartition(img, rootfsPartition, rootImg); err != nil {
+		return err
+	}
+
+	cfg.Boot.Cmdline = append(cfg.Boot.Cmdline, "ro", fmt.Sprintf("tezsign.verity=%s,%d", rootHash, fsSize))
+	return patchBootPartition(imagePath, bootPartition, cfg, logger)
+}
+
+// rebuildInitramfs runs update-initramfs for the image's kernels in a
+// chroot, with the boot partition mounted where the kernel hooks expect it.
+// Building on another architecture needs qemu-user-static registered with
+// binfmt_misc (fix-binary). The build fails early when the rootfs lacks any
+// of the tools the injected initramfs scripts need. Unlike the partition
+// edits in fs.go it mounts with FUSE and needs root; see checkFuseHost.
+func rebuildInitramfs(img *disk.Disk, imagePath string, bootPartition, rootfsPartition part.Partition, tools []string, logger *slog.Logger) error {
+	rootfs := path.Join(workDir, "rootfs")
+	unmountRoot, err := fuse2fs_mount(imagePath, rootfs, int(rootfsPartition.GetStart()), logger)
+	if err != nil {
+		return err
+	}
+	defer unmountRoot(true)
+
+	for _, tool := range tools {
+		if _, err := exec.LookPath(path.Join(rootfs, "sbin", tool)); err != nil {
+			if _, err := exec.LookPath(path.Join(rootfs, "usr", "sbin", tool)); err != nil {
+				return fmt.Errorf("the source image has no %s", tool)
+			}
+		}
+	}
+
+	bootImg := path.Join(workDir, "boot.img")
+	if err := extractPartition(img, bootPartition, bootImg); err != nil {
+		return err
+	}
+	defer os.Remove(bootImg)
+	unmountBoot, err := fusefat_mount(bootImg, path.Join(rootfs, "boot"), logger)
+	if err != nil {
+		return err
+	}
+	defer unmountBoot(true)
+
+	logger.Info("Rebuilding initramfs in the image")
+	out, err := exec.Command("chroot", rootfs, "update-initramfs", "-u", "-k", "all").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("update-initramfs: %w, output: %s", err, out)
+	}
+
+	unmountBoot(false)
+	if err := writePartition(img, bootPartition, bootImg); err != nil {
+		return err
+	}
+	unmountRoot(false)
+	return nil
+}
+
+// extractPartition copies p to the new file dst. go-diskfs copies a sector
+// at a time, so the partition is read directly.
+func extractPartition(img *disk.Disk, p part.Partition, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := &sparseWriter{f: f}
+	n, err := copyBuffered(w, io.NewSectionReader(img.Backend, p.GetStart(), p.GetSize()))
+	if err != nil {
+		return err
+	}
+	if n != p.GetSize() {
+		return fmt.Errorf("expected to read %d bytes from partition, but read %d", p.GetSize(), n)
+	}
+	return w.finish()
+}
+
+// writePartition copies src, which must be exactly the size of p, over p.
+func writePartition(img *disk.Disk, p part.Partition, src string) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if st.Size() != p.GetSize() {
+		return fmt.Errorf("%s has %d bytes, partition has %d", src, st.Size(), p.GetSize())
+	}
+	f, closeSource, err := trackFile(filepath.Base(src), src)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+	writable, err := img.Backend.Writable()
+	if err != nil {
+		return fmt.Errorf("failed to get writable backend: %w", err)
+	}
+	n, err := copyBuffered(io.NewOffsetWriter(writable, p.GetStart()), f)
+	if err != nil {
+		return err
+	}
+	if n != p.GetSize() {
+		return fmt.Errorf("expected to write %d bytes to partition, but wrote %d", p.GetSize(), n)
+	}
+	return nil
+}
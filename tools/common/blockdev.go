@@ -0,0 +1,193 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotBlockDevice     = errors.New("not a whole-disk block device")
+	ErrDeviceInUse        = errors.New("device is in use")
+	ErrDeviceNotRemovable = errors.New("device is not removable")
+	ErrDeviceTooSmall     = errors.New("device is too small")
+)
+
+// BlockDevice describes a whole disk as sysfs reports it.
+type BlockDevice struct {
+	Name      string // e.g. sdb, mmcblk0
+	Path      string // e.g. /dev/sdb
+	Model     string
+	Size      int64
+	Removable bool
+	// Transport is how the disk is attached (usb, mmc, sata, nvme...) or
+	// "" when sysfs does not tell.
+	Transport string
+}
+
+func (d *BlockDevice) String() string {
+	model := d.Model
+	if model == "" {
+		model = "unknown model"
+	}
+	return fmt.Sprintf("%s (%s, %.1f GB)", d.Path, model, float64(d.Size)/1e9)
+}
+
+// InspectBlockDevice resolves path to a whole disk and reads its sysfs
+// attributes. Partitions are refused.
+func InspectBlockDevice(path string) (*BlockDevice, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if st.Mode()&os.ModeDevice == 0 || st.Mode()&os.ModeCharDevice != 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotBlockDevice)
+	}
+	name := filepath.Base(resolved)
+	sys := filepath.Join("/sys/block", name)
+	if _, err := os.Stat(sys); err != nil {
+		return nil, fmt.Errorf("%s: %w (is it a partition?)", path, ErrNotBlockDevice)
+	}
+
+	d := &BlockDevice{Name: name, Path: resolved}
+	sectors, err := strconv.ParseInt(readSysfs(sys, "size"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unreadable size: %w", path, err)
+	}
+	d.Size = sectors * 512 // sysfs counts 512-byte sectors
+	d.Removable = readSysfs(sys, "removable") == "1"
+	d.Model = strings.TrimSpace(readSysfs(sys, "device/vendor") + " " + readSysfs(sys, "device/model"))
+	if d.Model == "" {
+		d.Model = readSysfs(sys, "device/name") // mmc cards
+	}
+	if link, err := filepath.EvalSymlinks(filepath.Join(sys, "device")); err == nil {
+		for _, t := range []string{"usb", "mmc", "nvme", "ata", "virtio"} {
+			if strings.Contains(link, "/"+t) {
+				d.Transport = t
+				break
+			}
+		}
+	}
+	// USB card readers often claim to be fixed disks
+	if d.Transport == "usb" || d.Transport == "mmc" {
+		d.Removable = true
+	}
+	return d, nil
+}
+
+// Mount is the disk, one of its partitions or a device stacked on them,
+// mounted at Target or used as swap (Target "swap").
+type Mount struct {
+	Source string
+	Target string
+}
+
+func (m Mount) String() string {
+	return fmt.Sprintf("%s on %s", m.Source, m.Target)
+}
+
+// Mounts lists what of the disk is mounted or used as swap, including
+// through device-mapper holders (LUKS, LVM).
+func (d *BlockDevice) Mounts() ([]Mount, error) {
+	devices := d.devices()
+	var mounts []Mount
+	for _, table := range []string{"/proc/self/mounts", "/proc/swaps"} {
+		b, err := os.ReadFile(table)
+		if err != nil {
+			return nil, err
+		}
+		for line := range strings.Lines(string(b)) {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			source, err := filepath.EvalSymlinks(fields[0])
+			if err != nil {
+				source = fields[0]
+			}
+			if devices[filepath.Base(source)] {
+				target := fields[1]
+				if table == "/proc/swaps" {
+					target = "swap"
+				}
+				mounts = append(mounts, Mount{Source: source, Target: target})
+			}
+		}
+	}
+	return mounts, nil
+}
+
+// MountedPartitions is Mounts as "source on target" strings.
+func (d *BlockDevice) MountedPartitions() ([]string, error) {
+	mounts, err := d.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	inUse := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		inUse = append(inUse, m.String())
+	}
+	return inUse, nil
+}
+
+// devices returns the names of the disk, its partitions and everything
+// stacked on them.
+func (d *BlockDevice) devices() map[string]bool {
+	names := map[string]bool{d.Name: true}
+	sys := filepath.Join("/sys/block", d.Name)
+	entries, _ := os.ReadDir(sys)
+	queue := []string{sys}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), d.Name) {
+			names[e.Name()] = true
+			queue = append(queue, filepath.Join(sys, e.Name()))
+		}
+	}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		holders, _ := os.ReadDir(filepath.Join(dir, "holders"))
+		for _, h := range holders {
+			if !names[h.Name()] {
+				names[h.Name()] = true
+				queue = append(queue, filepath.Join("/sys/block", h.Name()))
+			}
+		}
+	}
+	return names
+}
+
+// CheckWritable refuses disks that are mounted or swapped on, which covers
+// the system disk, disks smaller than need bytes and, unless allowFixed,
+// disks that are not removable.
+func (d *BlockDevice) CheckWritable(need int64, allowFixed bool) error {
+	inUse, err := d.MountedPartitions()
+	if err != nil {
+		return err
+	}
+	if len(inUse) > 0 {
+		return fmt.Errorf("%s: %w: %s", d.Path, ErrDeviceInUse, strings.Join(inUse, ", "))
+	}
+	if !d.Removable && !allowFixed {
+		return fmt.Errorf("%s: %w", d.Path, ErrDeviceNotRemovable)
+	}
+	if need > d.Size {
+		return fmt.Errorf("%s: %w: %d bytes, need %d", d.Path, ErrDeviceTooSmall, d.Size, need)
+	}
+	return nil
+}
+
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// A delta update downloads only the parts of a release a card does not
+// hold yet. The builder cuts the boot, rootfs and app partitions of the
+// raw image into ChunkSize chunks and compresses each with xz on its own
+// into <image>.chunks, the pack. <image>.chunks.json, the index, lists
+// every chunk with its sha256 and where it sits in the pack; the signed
+// manifest carries the digest of the index, so the index vouches for
+// every chunk.
+const (
+	ChunkPackSuffix  = ".chunks"
+	ChunkIndexSuffix = ".chunks.json"
+
+	ChunkSize = 1 << 20
+	// ChunkHeadSize is the start of the raw image kept in the pack for
+	// its partition table; a GPT with 128 entries ends at 17 KiB.
+	ChunkHeadSize = 64 << 10
+)
+
+// ChunkIndex is the <image>.chunks.json of a release.
+type ChunkIndex struct {
+	ChunkSize  int64            `json:"chunk_size"`
+	Pack       ManifestFile     `json:"pack"`
+	Head       Chunk            `json:"head"`
+	Partitions []ChunkPartition `json:"partitions"`
+}
+
+// ChunkPartition is one partition of the raw image; its chunks follow each
+// other from Start, the last one short when Size is not a multiple of
+// ChunkSize.
+type ChunkPartition struct {
+	Start  int64   `json:"start"`
+	Size   int64   `json:"size"`
+	SHA256 string  `json:"sha256"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk is one chunk of the raw image. Offset and Length locate its xz
+// stream in the pack; chunks with the same content share one.
+type Chunk struct {
+	SHA256 string `json:"sha256"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// ParseChunkIndex checks data against the digest the manifest has for the
+// index and decodes it.
+func ParseChunkIndex(data []byte, want ManifestFile) (*ChunkIndex, error) {
+	sum := sha256.Sum256(data)
+	if err := want.matches(ManifestFile{Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}); err != nil {
+		return nil, fmt.Errorf("chunk index: %w", err)
+	}
+	var idx ChunkIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("chunk index: %w", err)
+	}
+	if idx.ChunkSize != ChunkSize {
+		return nil, fmt.Errorf("chunk index: chunk size %d, expected %d", idx.ChunkSize, ChunkSize)
+	}
+	for _, p := range idx.Partitions {
+		if want := (p.Size + ChunkSize - 1) / ChunkSize; int64(len(p.Chunks)) != want {
+			return nil, fmt.Errorf("chunk index: partition at %d has %d chunks, expected %d", p.Start, len(p.Chunks), want)
+		}
+	}
+	return &idx, nil
+}
+
+// Partition returns the partition of the index at start with size.
+func (idx *ChunkIndex) Partition(start, size int64) (*ChunkPartition, bool) {
+	for i := range idx.Partitions {
+		if p := &idx.Partitions[i]; p.Start == start && p.Size == size {
+			return p, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ManifestSuffix and SignatureSuffix name the files the builder writes next
+// to a signed image.
+const (
+	ManifestSuffix  = ".manifest.json"
+	SignatureSuffix = ".sig"
+)
+
+// ErrManifestMismatch is returned when an image is not the one its manifest
+// describes.
+var ErrManifestMismatch = errors.New("image does not match its manifest")
+
+// ImageManifest describes a built image. It is signed next to the image, so
+// checking its signature vouches for the hashes inside.
+type ImageManifest struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit,omitempty"`
+	Flavour         string `json:"flavour"`
+	Board           string `json:"board"`
+	Layout          string `json:"layout"`
+	SourceDateEpoch int64  `json:"source_date_epoch"`
+	KeyID           string `json:"key_id"`
+	// Binaries maps the gadget programs in the image to their sha256.
+	Binaries map[string]string `json:"binaries,omitempty"`
+
+	Image ManifestFile `json:"image"` // the file as published (e.g. .img.xz)
+	Raw   ManifestFile `json:"raw"`   // the image as written to the card
+	// Chunks is the chunk index of a delta update (see chunks.go).
+	Chunks *ManifestFile `json:"chunks,omitempty"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func ReadImageManifest(path string) (*ImageManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseImageManifest(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func ParseImageManifest(b []byte) (*ImageManifest, error) {
+	var m ImageManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// VerifyImage checks the signatures of imagePath and its manifest against
+// pub and returns the manifest. Both are signed on their own, so it also
+// checks that the manifest describes this image: Image must match the
+// file, and so must Raw unless the file is compressed, whose content then
+// follows from Image.
+func VerifyImage(pub *PublicKey, imagePath string) (*ImageManifest, error) {
+	var image ManifestFile
+	for _, path := range []string{imagePath, imagePath + ManifestSuffix} {
+		sig, err := os.ReadFile(path + SignatureSuffix)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n := &countingWriter{}
+		_, err = pub.Verify(io.TeeReader(f, io.MultiWriter(h, n)), sig)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if path == imagePath {
+			image = ManifestFile{Size: n.n, SHA256: hex.EncodeToString(h.Sum(nil))}
+		}
+	}
+	m, err := ReadImageManifest(imagePath + ManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Image.matches(image); err != nil {
+		return nil, fmt.Errorf("%s: %w", imagePath, err)
+	}
+	if !strings.HasSuffix(imagePath, ".xz") {
+		if err := m.Raw.matches(image); err != nil {
+			return nil, fmt.Errorf("%s: %w", imagePath, err)
+		}
+	}
+	return m, nil
+}
+
+// matches checks that the size and sha256 of got are the ones in f.
+func (f ManifestFile) matches(got ManifestFile) error {
+	if f.Size != got.Size || !strings.EqualFold(f.SHA256, got.SHA256) {
+		return fmt.Errorf("%w: %d bytes with sha256 %s, manifest has %d bytes with %s", ErrManifestMismatch, got.Size, got.SHA256, f.Size, f.SHA256)
+	}
+	return nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,222 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Release images are signed in the minisign format, so they can be checked
+// with `minisign -Vm` as well as by the updater. Only prehashed signatures
+// ("ED", BLAKE2b-512 of the file) are produced and accepted.
+
+var (
+	ErrInvalidSigningKey = errors.New("invalid signing key")
+	ErrInvalidSignature  = errors.New("invalid signature")
+	ErrSignatureMismatch = errors.New("signature does not match")
+)
+
+const (
+	minisignUntrusted = "untrusted comment: "
+	minisignTrusted   = "trusted comment: "
+)
+
+var (
+	minisignAlgEd       = []byte("Ed")
+	minisignAlgHashedEd = []byte("ED")
+)
+
+type SigningKey struct {
+	ID      [8]byte
+	Private ed25519.PrivateKey
+}
+
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// ParseSigningKey reads a minisign secret key (encrypted with password
+// unless it was created with -W) or a PKCS#8 PEM ed25519 key. PEM keys get
+// the first 8 bytes of the SHA256 of their public key as key ID.
+func ParseSigningKey(data []byte, password []byte) (*SigningKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidSigningKey, err)
+		}
+		priv, ok := k.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: not an ed25519 key", ErrInvalidSigningKey)
+		}
+		sk := &SigningKey{Private: priv}
+		id := sha256.Sum256(priv.Public().(ed25519.PublicKey))
+		copy(sk.ID[:], id[:8])
+		return sk, nil
+	}
+
+	raw, err := minisignPayload(data)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidSigningKey, err)
+	}
+	// alg(2) kdf(2) cksum(2) salt(32) opslimit(8) memlimit(8) keynum_sk(104)
+	if len(raw) != 158 || !bytes.Equal(raw[:2], minisignAlgEd) || string(raw[4:6]) != "B2" {
+		return nil, fmt.Errorf("%w: not a minisign secret key", ErrInvalidSigningKey)
+	}
+	keynum := bytes.Clone(raw[54:])
+	switch string(raw[2:4]) {
+	case "Sc":
+		salt := raw[6:38]
+		ops := binary.LittleEndian.Uint64(raw[38:])
+		mem := binary.LittleEndian.Uint64(raw[46:])
+		nLog2, r, p := scryptParams(ops, mem)
+		stream, err := scrypt.Key(password, salt, 1<<nLog2, r, p, len(keynum))
+		if err != nil {
+			return nil, errors.Join(ErrInvalidSigningKey, err)
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	case "\x00\x00":
+	default:
+		return nil, fmt.Errorf("%w: unsupported key derivation", ErrInvalidSigningKey)
+	}
+	// key_id(8) secret_key(64) checksum(32)
+	sum := blake2b.Sum256(append(append(bytes.Clone(minisignAlgEd), keynum[:8]...), keynum[8:72]...))
+	if !bytes.Equal(sum[:], keynum[72:]) {
+		return nil, fmt.Errorf("%w: wrong password or corrupted key", ErrInvalidSigningKey)
+	}
+	sk := &SigningKey{Private: ed25519.PrivateKey(keynum[8:72])}
+	copy(sk.ID[:], keynum[:8])
+	return sk, nil
+}
+
+// ParsePublicKey reads a minisign public key file or its base64 line.
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	raw, err := minisignPayload(data)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidSigningKey, err)
+	}
+	if len(raw) != 42 || !bytes.Equal(raw[:2], minisignAlgEd) {
+		return nil, fmt.Errorf("%w: not a minisign public key", ErrInvalidSigningKey)
+	}
+	pk := &PublicKey{Key: ed25519.PublicKey(raw[10:])}
+	copy(pk.ID[:], raw[2:10])
+	return pk, nil
+}
+
+func (k *SigningKey) Public() *PublicKey {
+	return &PublicKey{ID: k.ID, Key: k.Private.Public().(ed25519.PublicKey)}
+}
+
+// String returns the public key in minisign's .pub format.
+func (k *PublicKey) String() string {
+	raw := append(append(bytes.Clone(minisignAlgEd), k.ID[:]...), k.Key...)
+	return fmt.Sprintf("%sminisign public key %X\n%s\n", minisignUntrusted, k.ID, base64.StdEncoding.EncodeToString(raw))
+}
+
+// Sign returns a minisign signature file for the contents of r.
+func (k *SigningKey) Sign(r io.Reader, untrusted, trusted string) ([]byte, error) {
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(k.Private, h.Sum(nil))
+	global := ed25519.Sign(k.Private, append(bytes.Clone(sig), trusted...))
+
+	raw := append(append(bytes.Clone(minisignAlgHashedEd), k.ID[:]...), sig...)
+	var b strings.Builder
+	b.WriteString(minisignUntrusted + untrusted + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(raw) + "\n")
+	b.WriteString(minisignTrusted + trusted + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	return []byte(b.String()), nil
+}
+
+// Verify checks a minisign signature file over the contents of r and
+// returns its trusted comment.
+func (k *PublicKey) Verify(r io.Reader, sigFile []byte) (string, error) {
+	lines := strings.Split(strings.TrimRight(string(sigFile), "\r\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], minisignUntrusted) || !strings.HasPrefix(lines[2], minisignTrusted) {
+		return "", ErrInvalidSignature
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 74 {
+		return "", ErrInvalidSignature
+	}
+	if !bytes.Equal(raw[:2], minisignAlgHashedEd) {
+		return "", fmt.Errorf("%w: only prehashed (ED) signatures are supported", ErrInvalidSignature)
+	}
+	if !bytes.Equal(raw[2:10], k.ID[:]) {
+		return "", fmt.Errorf("%w: signed by key %X, expected %X", ErrSignatureMismatch, raw[2:10], k.ID)
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", ErrInvalidSignature
+	}
+
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	sig := raw[10:]
+	if !ed25519.Verify(k.Key, h.Sum(nil), sig) {
+		return "", ErrSignatureMismatch
+	}
+	trusted := strings.TrimPrefix(lines[2], minisignTrusted)
+	if !ed25519.Verify(k.Key, append(bytes.Clone(sig), trusted...), global) {
+		return "", fmt.Errorf("%w: trusted comment", ErrSignatureMismatch)
+	}
+	return trusted, nil
+}
+
+// minisignPayload decodes the base64 line of a minisign key file; a bare
+// base64 line is accepted too.
+func minisignPayload(data []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, minisignUntrusted) {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, errors.New("no key data")
+}
+
+// scryptParams mirrors libsodium's pickparams for
+// crypto_pwhash_scryptsalsa208sha256, which minisign uses.
+func scryptParams(ops, mem uint64) (nLog2 uint, r, p int) {
+	if ops < 32768 {
+		ops = 32768
+	}
+	r = 8
+	maxN := mem / (uint64(r) * 128)
+	if ops < mem/32 {
+		p = 1
+		maxN = ops / (uint64(r) * 4)
+	}
+	for nLog2 = 1; nLog2 < 63; nLog2++ {
+		if uint64(1)<<nLog2 > maxN/2 {
+			break
+		}
+	}
+	if ops >= mem/32 {
+		maxrp := (ops / 4) / (uint64(1) << nLog2)
+		if maxrp > 0x3fffffff {
+			maxrp = 0x3fffffff
+		}
+		p = int(maxrp) / r
+	}
+	return nLog2, r, p
+}
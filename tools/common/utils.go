@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition"
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/partition/part"
@@ -15,7 +16,12 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 	if err != nil {
 		return nil, nil, nil, nil, errors.Join(ErrFailedToOpenPartitionTable, err)
 	}
+	return TezsignPartitions(table)
+}
 
+// TezsignPartitions finds the TezSign partitions in a partition table read
+// without a disk, e.g. from the head of a compressed image.
+func TezsignPartitions(table partition.Table) (boot, rootfs, app, data part.Partition, err error) {
 	var bootPartition part.Partition
 	var rootfsPartition part.Partition
 	var appPartition part.Partition
@@ -31,7 +37,7 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 			switch partition.Name {
 			case "boot", "bootfs":
 				bootPartition = partition
-			case "root", "rootfs":
+			case "root", "rootfs", constants.RootfsAPartitionLabel:
 				rootfsPartition = partition
 			case constants.AppPartitionLabel:
 				appPartition = partition
@@ -45,10 +51,15 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 			return nil, nil, nil, nil, errors.Join(ErrFailedToConfigureImage, ErrUnexpectedPartitionCount)
 		}
 
-		bootPartition = mbrTable.Partitions[0]
-		rootfsPartition = mbrTable.Partitions[1]
 		appPartition = mbrTable.Partitions[2]
 		dataPartition = mbrTable.Partitions[3]
+		if mbrTable.Partitions[1].Size == 0 {
+			// single-partition image booting from /boot on the rootfs
+			rootfsPartition = mbrTable.Partitions[0]
+			break
+		}
+		bootPartition = mbrTable.Partitions[0]
+		rootfsPartition = mbrTable.Partitions[1]
 	default:
 		return nil, nil, nil, nil, errors.Join(ErrFailedToPartitionImage, ErrPartitionTableNotGPT)
 	}
@@ -58,3 +69,49 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 	}
 	return bootPartition, rootfsPartition, appPartition, dataPartition, nil
 }
+
+// GetTezsignSlotPartitions returns the slot b partitions and the env
+// partition of an A/B image; all nil for single-slot images.
+func GetTezsignSlotPartitions(img *disk.Disk) (rootfsB, appB, env part.Partition, err error) {
+	table, err := img.GetPartitionTable()
+	if err != nil {
+		return nil, nil, nil, errors.Join(ErrFailedToOpenPartitionTable, err)
+	}
+	gptTable, ok := table.(*gpt.Table)
+	if !ok {
+		return nil, nil, nil, nil
+	}
+	for _, partition := range gptTable.Partitions {
+		switch partition.Name {
+		case constants.RootfsBPartitionLabel:
+			rootfsB = partition
+		case constants.AppBPartitionLabel:
+			appB = partition
+		case constants.EnvPartitionLabel:
+			env = partition
+		}
+	}
+	if (rootfsB == nil) != (appB == nil) || (rootfsB == nil) != (env == nil) {
+		return nil, nil, nil, errors.Join(ErrUnexpectedPartitionCount, errors.New("incomplete A/B layout"))
+	}
+	return rootfsB, appB, env, nil
+}
+
+// GetTezsignVaultPartition returns the encrypted data partition, or nil
+// when the image has none.
+func GetTezsignVaultPartition(img *disk.Disk) (part.Partition, error) {
+	table, err := img.GetPartitionTable()
+	if err != nil {
+		return nil, errors.Join(ErrFailedToOpenPartitionTable, err)
+	}
+	gptTable, ok := table.(*gpt.Table)
+	if !ok {
+		return nil, nil
+	}
+	for _, partition := range gptTable.Partitions {
+		if partition.Name == constants.VaultPartitionLabel {
+			return partition, nil
+		}
+	}
+	return nil, nil
+}
@@ -5,4 +5,22 @@ const (
 	DataPartitionLabel = "data"
 	LatestReleaseURL   = "https://github.com/tez-capital/tezsign/releases/latest/download/"
 	AppBinaryName      = "tezsign-gadget-binary"
+
+	// A/B layout: slot a keeps the labels above (its rootfs is renamed),
+	// slot b gets its own copies and the env partition names the active one.
+	RootfsAPartitionLabel = "rootfs_a"
+	RootfsBPartitionLabel = "rootfs_b"
+	AppBPartitionLabel    = "app_b"
+	EnvPartitionLabel     = "tezsign_env"
+
+	// VaultPartitionLabel is the LUKS2 partition holding the keystore vault
+	// on images built with an encrypted data partition.
+	VaultPartitionLabel = "tezsign_vault"
+
+	// AppVersionFile on the app partition names the release the gadget
+	// binary comes from (KEY=value lines like /etc/tezsign-release);
+	// AppHistoryFile lists the updates the updater applied, one JSON
+	// object per line.
+	AppVersionFile = "/.tezsign-version"
+	AppHistoryFile = "/update-history"
 )
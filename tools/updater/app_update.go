@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -8,114 +9,263 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/diskfs/go-diskfs"
-	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/tez-capital/tezsign/tools/constants"
 )
 
-func ensureImageFlavour(fs filesystem.FileSystem, fallback string, logger *slog.Logger) (string, error) {
-	flavour, err := readImageFlavour(fs)
+// appImageFiles are the files of the app partition that come from the
+// image. An app update replaces only these; tezsign_id, .image-flavour and
+// everything else written on the device stays. The units live on the
+// read-only rootfs and change with a full update.
+var appImageFiles = []string{"tezsign"}
+
+// isImageSource tells an image (plain or xz) from a bare gadget binary.
+func isImageSource(path string) bool {
+	return strings.HasSuffix(path, ".img") || strings.HasSuffix(path, ".xz")
+}
+
+// performAppUpdate installs the gadget binary from source, which is either
+// a binary or an image whose app partition carries one. Either is verified
+// first. A bare binary has no version to compare.
+func performAppUpdate(source, destination string, opts updateOptions, logger *slog.Logger) (*updateReport, error) {
+	manifest, err := verifySource(source, opts, logger)
 	if err != nil {
-		return "", err
+		return &updateReport{}, err
 	}
-	if flavour != "" {
-		return flavour, nil
-	}
-	if fallback == "" {
-		return "", errors.New("unable to determine image flavour")
+	if !isImageSource(source) {
+		return performAppBinaryUpdate(source, source, imageVersion{}, destination, opts, logger)
 	}
 
-	if tmp, err := fs.OpenFile("/.image-flavour", os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err == nil {
-		if _, err := tmp.Write([]byte(fallback)); err != nil {
-			logger.Debug("Failed to write /.image-flavour; continuing", "error", err)
+	sourcePath := source
+	if strings.HasSuffix(source, ".xz") {
+		// only the app partition is decompressed, into a sparse file
+		x, err := openXZImage(source)
+		if err != nil {
+			return &updateReport{}, err
+		}
+		sourcePath, err = x.extractApp()
+		x.Close()
+		if err != nil {
+			return &updateReport{}, err
 		}
-		tmp.Close()
-		_ = fs.Chmod("/.image-flavour", 0444)
-	} else {
-		logger.Debug("Failed to persist /.image-flavour; continuing", "error", err)
+		defer os.Remove(sourcePath)
+	}
+
+	binaryPath, version, err := extractAppBinary(sourcePath)
+	if err != nil {
+		return &updateReport{}, err
+	}
+	defer os.Remove(binaryPath)
+	if manifest != nil {
+		version = manifestVersion(manifest)
+	}
+
+	return performAppBinaryUpdate(binaryPath, source, version, destination, opts, logger)
+}
+
+// extractAppBinary copies /tezsign out of the image's app partition into a
+// temp file and reads the version file next to it.
+func extractAppBinary(imagePath string) (string, imageVersion, error) {
+	img, _, _, appPartition, err := loadImage(imagePath, diskfs.ReadOnly)
+	if err != nil {
+		return "", imageVersion{}, fmt.Errorf("failed to load source image: %w", err)
+	}
+	defer img.Close()
+
+	fs, err := filesystemForPartition(img, appPartition)
+	if err != nil {
+		return "", imageVersion{}, fmt.Errorf("failed to open source app filesystem: %w", err)
+	}
+	defer fs.Close()
+
+	src, err := fs.OpenFile("/tezsign", os.O_RDONLY)
+	if err != nil {
+		return "", imageVersion{}, fmt.Errorf("source image has no gadget binary: %w", err)
+	}
+	defer src.Close()
+
+	tmpFile, err := os.CreateTemp("", "tezsign_gadget_*")
+	if err != nil {
+		return "", imageVersion{}, fmt.Errorf("failed to create temp file for gadget binary: %w", err)
+	}
+	if _, err := io.Copy(tmpFile, src); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", imageVersion{}, fmt.Errorf("failed to extract gadget binary: %w", err)
 	}
-	return fallback, nil
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", imageVersion{}, err
+	}
+	return tmpFile.Name(), readAppVersion(img, appPartition), nil
 }
 
-func performAppBinaryUpdate(binaryPath, destination string, logger *slog.Logger) error {
-	logger.Info("Starting TezSign app-only update", "source", binaryPath, "destination", destination)
+// performAppBinaryUpdate installs binaryPath, taken from source, whose
+// release is version (the zero value when unknown).
+func performAppBinaryUpdate(binaryPath, source string, version imageVersion, destination string, opts updateOptions, logger *slog.Logger) (*updateReport, error) {
+	logger.Info("Starting TezSign app-only update", "source", source, "destination", destination)
+	report := &updateReport{}
 
 	if err := ensureMountAvailable(); err != nil {
-		return err
+		return report, err
+	}
+	if err := checkDestination(destination, opts, logger); err != nil {
+		return report, err
+	}
+	st, err := os.Stat(binaryPath)
+	if err != nil {
+		return report, fmt.Errorf("failed to open gadget binary: %w", err)
 	}
 
-	dstImg, _, _, destinationAppPartition, err := loadImage(destination, diskfs.ReadWriteExclusive)
+	dstImg, _, _, destinationAppPartition, err := loadImage(destination, diskfs.ReadOnly)
 	if err != nil {
-		return fmt.Errorf("failed to load destination image: %w", err)
+		return report, fmt.Errorf("failed to load destination image: %w", err)
 	}
-	defer dstImg.Close()
 
 	if ok, err := checkTezsignMarker(dstImg); err != nil {
-		return fmt.Errorf("marker check failed: %w", err)
+		dstImg.Close()
+		return report, fmt.Errorf("marker check failed: %w", err)
 	} else if !ok {
-		return errors.New("destination does not match TezSign layout; aborting")
+		dstImg.Close()
+		return report, fmt.Errorf("%w; aborting", errNotTezsign)
 	}
 
-	fs, err := filesystemForPartition(dstImg, destinationAppPartition)
+	table, err := dstImg.GetPartitionTable()
 	if err != nil {
-		return fmt.Errorf("failed to open app filesystem: %w", err)
+		dstImg.Close()
+		return report, fmt.Errorf("failed to read partition table: %w", err)
 	}
-	defer fs.Close()
-
-	table, err := dstImg.GetPartitionTable()
+	appIndex, err := partitionIndex(table, destinationAppPartition)
 	if err != nil {
-		return fmt.Errorf("failed to read partition table: %w", err)
+		dstImg.Close()
+		return report, fmt.Errorf("failed to locate app partition: %w", err)
 	}
 
-	currentFlavour, _ := readImageFlavour(fs)
-	fallback := flavourFromTable(table)
-	if currentFlavour != "" {
-		fallback = currentFlavour
+	flavour := flavourFromTable(table)
+	if fs, err := filesystemForPartition(dstImg, destinationAppPartition); err == nil {
+		if current, _ := readImageFlavour(fs); current != "" {
+			flavour = current
+		}
+		fs.Close()
 	}
-	flavour, err := ensureImageFlavour(fs, fallback, logger)
-	if err != nil {
-		return fmt.Errorf("failed to ensure image flavour: %w", err)
+	if flavour == "" {
+		dstImg.Close()
+		return report, errors.New("unable to determine image flavour")
 	}
 	logger.Info("Using image flavour", "flavour", flavour)
 
-	in, err := os.Open(binaryPath)
-	if err != nil {
-		return fmt.Errorf("failed to open gadget binary: %w", err)
+	destinationVersion := readAppVersion(dstImg, destinationAppPartition)
+	if err := checkDowngrade(destinationVersion, version, opts, logger); err != nil {
+		dstImg.Close()
+		return report, err
+	}
+
+	if !opts.NoBackup && !opts.DryRun {
+		if report.Backup, err = backupAppPartition(destination, dstImg, destinationAppPartition, opts, logger); err != nil {
+			dstImg.Close()
+			return report, err
+		}
+	}
+	// the partition is written through the kernel from here on
+	dstImg.Close()
+
+	report.Plan = &updatePlan{Kind: UpdateKindAppOnly, Source: source, Destination: destination, From: destinationVersion, To: version}
+	for _, name := range appImageFiles {
+		report.Plan.Steps = append(report.Plan.Steps, planStep{Name: "app /" + name, Path: "/" + name, Bytes: st.Size(), DestinationOffset: destinationAppPartition.GetStart()})
+	}
+	report.Plan.Preserved = []string{"tezsign_id", ".image-flavour", historyFileName, "rootfs", "data partition"}
+	if opts.DryRun {
+		report.DryRun = true
+		return report, nil
 	}
-	defer in.Close()
 
 	// Always use mount-based write; direct go-diskfs writes are unreliable on RO-marked filesystems.
-	if err := writeAppViaMount(binaryPath, flavour, logger); err != nil {
-		return fmt.Errorf("failed to write gadget binary via mount: %w", err)
+	entry := historyEntry{Time: time.Now().UTC(), Kind: UpdateKindAppOnly, From: destinationVersion.String(), To: version.String(), Source: filepath.Base(source)}
+	if err := writeAppViaMount(binaryPath, destination, appIndex, flavour, version, entry, logger); err != nil {
+		return report, fmt.Errorf("%w: failed to write gadget binary via mount: %w", errWriteFailed, err)
 	}
 
+	if err := verifyAppViaMount(binaryPath, destination, appIndex, report, logger); err != nil {
+		return report, err
+	}
+	return report, report.err()
+}
+
+// verifyAppViaMount remounts the app partition read-only after flushing its
+// buffers and compares the replaced files with the source.
+func verifyAppViaMount(binaryPath, destination string, appPartitionIndex int, report *updateReport, logger *slog.Logger) error {
+	expected, size, err := readbackFile(binaryPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash gadget binary: %w", err)
+	}
+
+	partDevice := partitionDevicePath(destination, appPartitionIndex)
+	if out, err := exec.Command("blockdev", "--flushbufs", partDevice).CombinedOutput(); err != nil {
+		logger.Debug("blockdev flush failed before app verification", "error", err, "output", string(out))
+	}
+	verifyDir, cleanup, err := mountSpecificPartition(destination, appPartitionIndex, false)
+	if err != nil {
+		return fmt.Errorf("failed to remount app partition for verification: %w", err)
+	}
+	defer cleanup()
+
+	for _, name := range appImageFiles {
+		actual, _, err := readbackFile(filepath.Join(verifyDir, name))
+		report.add(partitionCheck{Name: "app /" + name, Bytes: size, Expected: expected, Actual: actual, Err: err})
+	}
 	return nil
 }
 
-func writeAppViaMount(binaryPath, flavour string, logger *slog.Logger) error {
-	tmpDir, cleanup, err := mountAppPartition(true)
+// writeAppViaMount replaces the image files, writes the version file and
+// appends to the update history. A binary of unknown version, written
+// with --allow-downgrade, leaves the version file as it is: dropping it
+// would make the device look older than any release and let the next
+// update through unchecked.
+func writeAppViaMount(binaryPath, destination string, appPartitionIndex int, flavour string, version imageVersion, entry historyEntry, logger *slog.Logger) error {
+	partDevice := partitionDevicePath(destination, appPartitionIndex)
+	if err := unmountIfMounted(partDevice, logger); err != nil {
+		return err
+	}
+
+	tmpDir, cleanup, err := mountSpecificPartition(destination, appPartitionIndex, true)
 	if err != nil {
 		return err
 	}
 	defer cleanup()
 
-	dstPath := filepath.Join(tmpDir, "tezsign")
-	src, err := os.Open(binaryPath)
-	if err != nil {
-		return fmt.Errorf("failed to open gadget binary: %w", err)
+	for _, name := range appImageFiles {
+		if err := replaceFile(binaryPath, filepath.Join(tmpDir, name), 0755); err != nil {
+			return err
+		}
+		logger.Info("Replaced app file", "file", name)
 	}
-	defer src.Close()
 
-	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	// The card now carries a new binary in slot a; make it the active one.
+	if err := os.Remove(filepath.Join(tmpDir, "slot")); err != nil && !os.IsNotExist(err) {
+		logger.Debug("Failed to reset active app slot via mount; continuing", "error", err)
+	}
+
+	if version.known() {
+		if err := replaceFileFrom(bytes.NewReader(version.file()), filepath.Join(tmpDir, constants.AppVersionFile), 0444); err != nil {
+			return err
+		}
+	}
+
+	historyPath := filepath.Join(tmpDir, historyFileName)
+	existing, err := os.ReadFile(historyPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", historyFileName, err)
+	}
+	history, err := appendHistory(existing, entry)
 	if err != nil {
-		return fmt.Errorf("failed to open %s for writing: %w", dstPath, err)
+		return err
 	}
-	if _, err := io.Copy(dst, src); err != nil {
-		dst.Close()
-		return fmt.Errorf("failed to write gadget binary via mount: %w", err)
+	if err := replaceFileFrom(bytes.NewReader(history), historyPath, appDeviceFiles[historyFileName]); err != nil {
+		return err
 	}
-	dst.Close()
-	_ = os.Chmod(dstPath, 0755)
 
 	flavourPath := filepath.Join(tmpDir, ".image-flavour")
 	if _, err := os.Stat(flavourPath); os.IsNotExist(err) && flavour != "" {
@@ -124,6 +274,9 @@ func writeAppViaMount(binaryPath, flavour string, logger *slog.Logger) error {
 		}
 	}
 
+	if err := fsyncPath(tmpDir); err != nil {
+		logger.Debug("Failed to fsync app mount directory", "error", err, "path", tmpDir)
+	}
 	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
 		logger.Debug("sync failed after mount write", "error", err, "output", string(out))
 	}
@@ -131,6 +284,50 @@ func writeAppViaMount(binaryPath, flavour string, logger *slog.Logger) error {
 	return nil
 }
 
+// replaceFile writes src next to dst and renames it over dst, so a pulled
+// card holds either the old or the new file, never half of one.
+func replaceFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	return replaceFileFrom(in, dst, mode)
+}
+
+// replaceFileFrom is replaceFile for content at hand.
+func replaceFileFrom(in io.Reader, dst string, mode os.FileMode) error {
+	tmp := dst + ".new"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", tmp, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := out.Chmod(mode); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	return nil
+}
+
 func ensureMountAvailable() error {
 	if _, err := exec.LookPath("mount"); err != nil {
 		return fmt.Errorf("mount binary not found: %w", err)
@@ -138,8 +335,5 @@ func ensureMountAvailable() error {
 	if _, err := exec.LookPath("umount"); err != nil {
 		return fmt.Errorf("umount binary not found: %w", err)
 	}
-	if _, err := os.Stat("/dev/disk/by-label/app"); err != nil {
-		return fmt.Errorf("app partition label not found: %w", err)
-	}
 	return nil
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// Every update first snapshots the app partition of the device to the
+// backup dir, one snapshot per device (by tezsign_id), replaced by the next
+// update. `rollback <device>` writes it back.
+
+var errNoBackup = errors.New("no app partition backup for this device")
+
+// appBackup is the sidecar of a snapshot, <device>.app.json.
+type appBackup struct {
+	Device  string    `json:"device"`
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	Created time.Time `json:"created"`
+}
+
+// backupDir is --backup-dir, or tezsign-updater under $XDG_STATE_HOME
+// (~/.local/state).
+func (o updateOptions) backupDir() (string, error) {
+	if o.BackupDir != "" {
+		return o.BackupDir, nil
+	}
+	if state := os.Getenv("XDG_STATE_HOME"); state != "" {
+		return filepath.Join(state, "tezsign-updater"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no backup dir: %w; pass --backup-dir", err)
+	}
+	return filepath.Join(home, ".local", "state", "tezsign-updater"), nil
+}
+
+// deviceKey names a device's snapshot: its tezsign_id, else the UUID of its
+// app partition.
+func deviceKey(d *disk.Disk, appPartition part.Partition) string {
+	if fs, err := filesystemForPartition(d, appPartition); err == nil {
+		defer fs.Close()
+		if f, err := fs.OpenFile("/tezsign_id", os.O_RDONLY); err == nil {
+			data, _ := io.ReadAll(f)
+			f.Close()
+			if id := sanitizeKey(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+	if uuid := sanitizeKey(appPartition.UUID()); uuid != "" {
+		return "app-" + uuid
+	}
+	return fmt.Sprintf("app-at-%d", appPartition.GetStart())
+}
+
+func sanitizeKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, strings.TrimSpace(s))
+}
+
+func backupPaths(dir, key string) (image, meta string) {
+	return filepath.Join(dir, key+".app.img"), filepath.Join(dir, key+".app.json")
+}
+
+// backupAppPartition snapshots the app partition of destination and
+// returns the path of the snapshot.
+func backupAppPartition(destination string, d *disk.Disk, appPartition part.Partition, opts updateOptions, logger *slog.Logger) (string, error) {
+	dir, err := opts.backupDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup dir: %w", err)
+	}
+	key := deviceKey(d, appPartition)
+	imagePath, metaPath := backupPaths(dir, key)
+
+	src, err := os.Open(destination)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp := imagePath + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+	h := sha256.New()
+	err = copyWithProgress("Backing up app partition", appPartition.GetSize(), io.MultiWriter(out, h), io.NewSectionReader(src, appPartition.GetStart(), appPartition.GetSize()))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to back up app partition: %w", err)
+	}
+
+	meta, err := json.MarshalIndent(appBackup{
+		Device:  key,
+		Offset:  appPartition.GetStart(),
+		Size:    appPartition.GetSize(),
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+		Created: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, imagePath); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.WriteFile(metaPath, meta, 0600); err != nil {
+		return "", err
+	}
+	logger.Info("Backed up app partition", "device", key, "path", imagePath)
+	return imagePath, nil
+}
+
+// performRollback writes the snapshot of the device's app partition back.
+func performRollback(destination string, opts updateOptions, logger *slog.Logger) (*updateReport, error) {
+	logger.Info("Starting TezSign app partition rollback", "destination", destination)
+	report := &updateReport{}
+	if err := checkDestination(destination, opts, logger); err != nil {
+		return report, err
+	}
+
+	mode := diskfs.ReadWriteExclusive
+	if opts.DryRun {
+		mode = diskfs.ReadOnly
+	}
+	dstImg, _, _, appPartition, err := loadImage(destination, mode)
+	if err != nil {
+		return report, fmt.Errorf("failed to load destination image: %w", err)
+	}
+	defer dstImg.Close()
+
+	dir, err := opts.backupDir()
+	if err != nil {
+		return report, err
+	}
+	key := deviceKey(dstImg, appPartition)
+	imagePath, metaPath := backupPaths(dir, key)
+	data, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return report, fmt.Errorf("%w (%s) in %s", errNoBackup, key, dir)
+	}
+	if err != nil {
+		return report, err
+	}
+	var meta appBackup
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return report, fmt.Errorf("%s: %w", metaPath, err)
+	}
+	if meta.Offset != appPartition.GetStart() || meta.Size != appPartition.GetSize() {
+		return report, fmt.Errorf("%w: backup of %s is for an app partition at %d (%d bytes), the device has it at %d (%d bytes)", errSizeMismatch, key, meta.Offset, meta.Size, appPartition.GetStart(), appPartition.GetSize())
+	}
+	if sum, size, err := readbackFile(imagePath); err != nil {
+		return report, err
+	} else if sum != meta.SHA256 || size != meta.Size {
+		return report, fmt.Errorf("backup %s is damaged: sha256 %s, expected %s", imagePath, sum, meta.SHA256)
+	}
+
+	report.Plan = &updatePlan{Kind: "rollback", Source: imagePath, Destination: destination}
+	report.Plan.Steps = []planStep{{Name: "app partition", Bytes: meta.Size, DestinationOffset: meta.Offset}}
+	report.Plan.Preserved = []string{"boot", "rootfs", "data partition"}
+	if opts.DryRun {
+		report.DryRun = true
+		return report, nil
+	}
+	beginOverall(2 * meta.Size)
+
+	tbl, err := dstImg.GetPartitionTable()
+	if err != nil {
+		return report, fmt.Errorf("failed to read destination partition table: %w", err)
+	}
+	if err := unmountDestinationPartitions(destination, tbl, logger, appPartition); err != nil {
+		return report, err
+	}
+
+	in, err := os.Open(imagePath)
+	if err != nil {
+		return report, err
+	}
+	defer in.Close()
+	writableDst, err := dstImg.Backend.Writable()
+	if err != nil {
+		return report, errors.New("failed to get writable backend for destination disk")
+	}
+	if err := copyWithProgress("Restoring app partition", meta.Size, io.NewOffsetWriter(writableDst, meta.Offset), in); err != nil {
+		return report, fmt.Errorf("%w: failed to restore app partition: %w", errWriteFailed, err)
+	}
+	if err := flushDevice(destination, logger); err != nil {
+		return report, err
+	}
+
+	sum, err := readbackPartition(destination, appPartition, "app partition")
+	report.add(partitionCheck{Name: "app partition", Bytes: meta.Size, Expected: meta.SHA256, Actual: sum, Err: err})
+	return report, report.err()
+}
+
+// copyWithProgress copies exactly total bytes behind a progress bar.
+func copyWithProgress(title string, total int64, dst io.Writer, src io.Reader) error {
+	counter := &countingReader{r: src}
+	return runProgress(title, total, counter, nil, func() error {
+		n, err := io.Copy(dst, io.LimitReader(counter, total))
+		if err == nil && n != total {
+			err = fmt.Errorf("copied %d of %d bytes", n, total)
+		}
+		return err
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Partition copies go through one large buffer at a time instead of the
+// sector-sized writes of go-diskfs. --buffer-size sets it, --direct writes
+// past the page cache (O_DIRECT) and --parallel copies the partitions of
+// a plain image at the same time.
+
+const (
+	defaultBufferSize = 4 << 20
+	minBufferSize     = 64 << 10
+	maxBufferSize     = 64 << 20
+	// directAlignment satisfies O_DIRECT on every card: buffers, offsets
+	// and lengths are multiples of it or of the 512-byte sector.
+	directAlignment = 4096
+)
+
+// destinationFile is where partitions are written; --skip-unchanged
+// also reads it.
+type destinationFile interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// bufferSize is --buffer-size, or the default.
+func (o updateOptions) bufferSize() int {
+	if o.BufferSize == 0 {
+		return defaultBufferSize
+	}
+	return o.BufferSize
+}
+
+// parseBufferSize reads sizes like 4M, 512K or 1048576.
+func parseBufferSize(s string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(strings.ToUpper(s), "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(strings.ToUpper(s), "M"):
+		multiplier = 1 << 20
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid buffer size %q", s)
+	}
+	size := n * multiplier
+	if size < minBufferSize || size > maxBufferSize || size%directAlignment != 0 {
+		return 0, fmt.Errorf("buffer size must be a multiple of %d between %s and %s", directAlignment, byteCountToHumanReadable(minBufferSize), byteCountToHumanReadable(maxBufferSize))
+	}
+	return size, nil
+}
+
+// alignedBuffer returns size bytes starting at a directAlignment boundary,
+// as O_DIRECT needs.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlignment)
+	off := int(-uintptr(unsafe.Pointer(&b[0])) & (directAlignment - 1))
+	return b[off : off+size : off+size]
+}
+
+// openDirect opens destination for writes that bypass the page cache.
+func openDirect(destination string) (*os.File, error) {
+	f, err := os.OpenFile(destination, os.O_RDWR|unix.O_DIRECT, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s with O_DIRECT: %w", destination, err)
+	}
+	return f, nil
+}
+
+// copyRange copies size bytes from src at srcOff to dst at dstOff through
+// buf and returns the sha256 of what it read. progress sees every byte.
+func copyRange(src io.ReaderAt, srcOff int64, dst io.WriterAt, dstOff, size int64, buf []byte, progress io.Writer) (string, error) {
+	h := sha256.New()
+	for done := int64(0); done < size; {
+		chunk := buf[:min(int64(len(buf)), size-done)]
+		if _, err := src.ReadAt(chunk, srcOff+done); err != nil {
+			return "", fmt.Errorf("error occurred while reading from source partition: %w", err)
+		}
+		if _, err := dst.WriteAt(chunk, dstOff+done); err != nil {
+			return "", fmt.Errorf("error occurred while writing to destination partition: %w", err)
+		}
+		h.Write(chunk)
+		progress.Write(chunk)
+		done += int64(len(chunk))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyPartitions copies the partitions of a plain image where their copies
+// say and sets the sha256 of what was read on each. dst returns the
+// destination for one copy.
+func copyPartitions(src io.ReaderAt, copies []*streamCopy, dst func() io.WriterAt, opts updateOptions, logger *slog.Logger) error {
+	if !opts.Parallel || len(copies) < 2 {
+		buf := alignedBuffer(opts.bufferSize())
+		for _, c := range copies {
+			logger.Info(fmt.Sprintf("Updating %s partition...", c.Name))
+			counter := &countingWriter{w: io.Discard}
+			err := runProgress(fmt.Sprintf("Copying %s partition", c.Name), c.Src.GetSize(), counter, nil, func() error {
+				var err error
+				c.SHA256, err = copyRange(src, c.Src.GetStart(), dst(), c.Offset, c.Src.GetSize(), buf, counter)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update %s partition: %w", c.Name, err)
+			}
+		}
+		return nil
+	}
+
+	var total int64
+	var names []string
+	for _, c := range copies {
+		total += c.Src.GetSize()
+		names = append(names, c.Name)
+	}
+	logger.Info("Updating partitions in parallel...", "partitions", names)
+	counter := &countingWriter{w: io.Discard}
+	title := fmt.Sprintf("Copying %s partitions", strings.Join(names, ", "))
+	return runProgress(title, total, counter, nil, func() error {
+		var wg sync.WaitGroup
+		errs := make([]error, len(copies))
+		for i, c := range copies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var err error
+				if c.SHA256, err = copyRange(src, c.Src.GetStart(), dst(), c.Offset, c.Src.GetSize(), alignedBuffer(opts.bufferSize()), counter); err != nil {
+					errs[i] = fmt.Errorf("failed to update %s partition: %w", c.Name, err)
+				}
+			}()
+		}
+		wg.Wait()
+		return errors.Join(errs...)
+	})
+}
+
+// blockWriter collects small writes into whole aligned buffers before
+// they reach dst at offset on.
+type blockWriter struct {
+	dst    io.WriterAt
+	offset int64
+	buf    []byte
+	n      int
+}
+
+func newBlockWriter(dst io.WriterAt, offset int64, size int) *blockWriter {
+	return &blockWriter{dst: dst, offset: offset, buf: alignedBuffer(size)}
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+		if w.n == len(w.buf) {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes what is buffered; the end of a partition is a whole
+// number of sectors.
+func (w *blockWriter) Flush() error {
+	if w.n == 0 {
+		return nil
+	}
+	if _, err := w.dst.WriteAt(w.buf[:w.n], w.offset); err != nil {
+		return err
+	}
+	w.offset += int64(w.n)
+	w.n = 0
+	return nil
+}
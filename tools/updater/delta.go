@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/ulikunitz/xz"
+)
+
+// --delta updates a card from a release URL without downloading the image:
+// the signed manifest names the chunk index, the index lists the sha256 of
+// every chunk of the boot, rootfs and app partitions, and only the chunks
+// the card does not hold already are fetched from the chunk pack with HTTP
+// range requests (see tools/common/chunks.go).
+
+const (
+	// deltaIndexLimit bounds the chunk index download; a 4 GiB image
+	// needs about 600 KiB.
+	deltaIndexLimit = 16 << 20
+	// deltaFetchMax is the most one range request asks for; neighbouring
+	// chunks are fetched together up to it.
+	deltaFetchMax = 8 << 20
+	// deltaFetchAttempts is how often a range request is tried.
+	deltaFetchAttempts = 3
+	// deltaCacheChunks is how many chunks that occur more than once in the
+	// image (e.g. zeros) are kept decompressed.
+	deltaCacheChunks = 64
+)
+
+var errDeltaUnavailable = errors.New("delta update unavailable")
+
+// deltaStats counts what a --delta update downloaded, rewrote and found on
+// the card already, over every partition.
+type deltaStats struct {
+	Downloaded atomic.Int64
+	Written    atomic.Int64
+	Skipped    atomic.Int64
+}
+
+// deltaSource is a release read chunk by chunk from its URL.
+type deltaSource struct {
+	packURL string
+	index   *common.ChunkIndex
+
+	boot, rootfs, app part.Partition
+
+	// shared holds decompressed chunks that occur more than once, by
+	// pack offset; repeats lists those offsets.
+	shared  map[int64][]byte
+	repeats map[int64]bool
+}
+
+// openDeltaSource fetches and checks the signed manifest of the image at
+// url, its chunk index and the partition table, and returns the manifest
+// and the source. Nothing of the card is read yet.
+func openDeltaSource(url string, opts updateOptions, logger *slog.Logger) (*common.ImageManifest, *deltaSource, error) {
+	if !strings.HasPrefix(url, "https://") || !isImageSource(url) {
+		return nil, nil, fmt.Errorf("%w: --delta needs the https:// URL of a released image", errDeltaUnavailable)
+	}
+	pub, err := opts.publicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := fetchAll(url+common.ManifestSuffix, 1<<20)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := fetchAll(url+common.ManifestSuffix+common.SignatureSuffix, 1<<20)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := pub.Verify(bytes.NewReader(data), sig); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", errUnsignedSource, url+common.ManifestSuffix, err)
+	}
+	manifest, err := common.ParseImageManifest(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %w", errUnsignedSource, url+common.ManifestSuffix, err)
+	}
+	// a signed manifest of another image must not pass for this one
+	if manifest.Image.Name != path.Base(url) {
+		return nil, nil, fmt.Errorf("%w: %w: %s describes %s", errUnsignedSource, common.ErrManifestMismatch, url+common.ManifestSuffix, manifest.Image.Name)
+	}
+	logger.Info("Verified source manifest", "source", url, "version", manifest.Version, "flavour", manifest.Flavour, "key_id", fmt.Sprintf("%X", pub.ID))
+	if manifest.Chunks == nil {
+		return nil, nil, fmt.Errorf("%w: the release has no chunk index; update without --delta", errDeltaUnavailable)
+	}
+
+	base := url[:strings.LastIndex(url, "/")+1]
+	data, err = fetchAll(base+manifest.Chunks.Name, deltaIndexLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := common.ParseChunkIndex(data, *manifest.Chunks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errUnsignedSource, err)
+	}
+	d := &deltaSource{packURL: base + idx.Pack.Name, index: idx, shared: map[int64][]byte{}, repeats: map[int64]bool{}}
+	seen := map[int64]bool{}
+	for _, p := range idx.Partitions {
+		for _, c := range p.Chunks {
+			d.repeats[c.Offset] = seen[c.Offset]
+			seen[c.Offset] = true
+		}
+	}
+
+	head, err := d.chunk(idx.Head, common.ChunkHeadSize, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch the partition table: %w", err)
+	}
+	table, err := partition.Read(&memFile{bytes.NewReader(head)}, sectorSize, sectorSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the partition table of %s: %w", url, err)
+	}
+	if d.boot, d.rootfs, d.app, _, err = common.TezsignPartitions(table); err != nil {
+		return nil, nil, fmt.Errorf("failed to read partitions from %s: %w", url, err)
+	}
+	for _, p := range []part.Partition{d.boot, d.rootfs, d.app} {
+		if p != nil {
+			if _, ok := idx.Partition(p.GetStart(), p.GetSize()); !ok {
+				return nil, nil, fmt.Errorf("%w: the chunk index has no partition at %d", errDeltaUnavailable, p.GetStart())
+			}
+		}
+	}
+	return manifest, d, nil
+}
+
+// apply brings every copy up to date on dst: it reads the card chunk by
+// chunk, then fetches and writes the chunks that differ. Each copy gets
+// the sha256 the index has for its partition, for the readback.
+func (d *deltaSource) apply(copies []*streamCopy, dst destinationFile, stats *deltaStats, logger *slog.Logger) error {
+	buf := alignedBuffer(common.ChunkSize)
+	for _, c := range copies {
+		p, _ := d.index.Partition(c.Src.GetStart(), c.Src.GetSize())
+
+		var stale []int
+		counter := &countingWriter{w: io.Discard}
+		err := runProgress(fmt.Sprintf("Comparing %s partition", c.Name), p.Size, counter, nil, func() error {
+			for i, chunk := range p.Chunks {
+				n := chunkLen(p, i)
+				// a chunk that cannot be read is fetched
+				if _, err := dst.ReadAt(buf[:n], c.Offset+int64(i)*common.ChunkSize); err != nil || sha256Hex(buf[:n]) != chunk.SHA256 {
+					stale = append(stale, i)
+				} else {
+					stats.Skipped.Add(n)
+				}
+				counter.Write(buf[:n])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Updating %s partition...", c.Name), "stale_chunks", len(stale), "chunks", len(p.Chunks))
+		c.SHA256 = p.SHA256
+		if len(stale) == 0 {
+			continue
+		}
+
+		var packBytes int64
+		for _, i := range stale {
+			packBytes += p.Chunks[i].Length
+		}
+		fetched := &countingWriter{w: io.Discard}
+		err = runProgress(fmt.Sprintf("Fetching %s chunks", c.Name), packBytes, fetched, nil, func() error {
+			for len(stale) > 0 {
+				run := d.run(p, stale)
+				if err := d.write(p, run, c.Offset, dst, stats, fetched); err != nil {
+					return fmt.Errorf("%s partition: %w", c.Name, err)
+				}
+				stale = stale[len(run):]
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run is the longest prefix of stale whose chunks follow each other in the
+// pack, up to deltaFetchMax, so they take one range request.
+func (d *deltaSource) run(p *common.ChunkPartition, stale []int) []int {
+	end := 1
+	next := p.Chunks[stale[0]].Offset + p.Chunks[stale[0]].Length
+	total := p.Chunks[stale[0]].Length
+	for end < len(stale) {
+		c := p.Chunks[stale[end]]
+		if c.Offset != next || total+c.Length > deltaFetchMax || d.repeats[c.Offset] {
+			break
+		}
+		next, total = next+c.Length, total+c.Length
+		end++
+	}
+	return stale[:end]
+}
+
+// write fetches the chunks of run, checks them against the index and writes
+// them to dst at the partition offset.
+func (d *deltaSource) write(p *common.ChunkPartition, run []int, offset int64, dst destinationFile, stats *deltaStats, fetched io.Writer) error {
+	first := p.Chunks[run[0]]
+	var packed []byte
+	if len(run) > 1 || d.shared[first.Offset] == nil {
+		last := p.Chunks[run[len(run)-1]]
+		var err error
+		if packed, err = d.fetch(first.Offset, last.Offset+last.Length-first.Offset); err != nil {
+			return err
+		}
+		stats.Downloaded.Add(int64(len(packed)))
+		fetched.Write(packed)
+	}
+	for _, i := range run {
+		c := p.Chunks[i]
+		var chunkPacked []byte
+		if packed != nil {
+			chunkPacked = packed[c.Offset-first.Offset : c.Offset-first.Offset+c.Length]
+		}
+		data, err := d.chunk(c, chunkLen(p, i), chunkPacked)
+		if err != nil {
+			return err
+		}
+		n, err := dst.WriteAt(data, offset+int64(i)*common.ChunkSize)
+		stats.Written.Add(int64(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunk decompresses c, fetching it unless packed holds it or it is kept
+// in shared, and checks its sha256 and length n.
+func (d *deltaSource) chunk(c common.Chunk, n int64, packed []byte) ([]byte, error) {
+	if data := d.shared[c.Offset]; data != nil {
+		return data, nil
+	}
+	if packed == nil {
+		var err error
+		if packed, err = d.fetch(c.Offset, c.Length); err != nil {
+			return nil, err
+		}
+	}
+	r, err := xz.NewReader(bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("chunk at %d: %w", c.Offset, err)
+	}
+	data := alignedBuffer(int(n))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("chunk at %d: %w", c.Offset, err)
+	}
+	if extra, _ := r.Read(make([]byte, 1)); extra != 0 || sha256Hex(data) != c.SHA256 {
+		return nil, fmt.Errorf("%w: chunk at %d does not match the chunk index", errChecksumMismatch, c.Offset)
+	}
+	if d.repeats[c.Offset] && len(d.shared) < deltaCacheChunks {
+		d.shared[c.Offset] = data
+	}
+	return data, nil
+}
+
+// fetch reads length bytes of the pack at offset.
+func (d *deltaSource) fetch(offset, length int64) ([]byte, error) {
+	var err error
+	for range deltaFetchAttempts {
+		var data []byte
+		if data, err = fetchRange(d.packURL, offset, length); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+func fetchRange(url string, offset, length int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("failed to download %s at %d: %s", url, offset, resp.Status)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to download %s at %d: %w", url, offset, err)
+	}
+	return data, nil
+}
+
+// fetchAll downloads a file of at most limit bytes.
+func fetchAll(url string, limit int64) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("failed to download %s: larger than %s", url, byteCountToHumanReadable(limit))
+	}
+	return data, nil
+}
+
+// chunkLen is the length of chunk i of p; the last one may be short.
+func chunkLen(p *common.ChunkPartition, i int) int64 {
+	return min(common.ChunkSize, p.Size-int64(i)*common.ChunkSize)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
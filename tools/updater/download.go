@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Sources may be https:// URLs. They are downloaded to the cache dir, into
+// a .part file that a later run resumes from where an interrupted one
+// stopped, and verified like local files: by their signatures or, with
+// --sha256, by that checksum alone.
+
+// isURL tells a source to download from a local path.
+func isURL(source string) bool {
+	return strings.Contains(source, "://")
+}
+
+// downloadDir is tezsign-updater under $XDG_CACHE_HOME (~/.cache).
+func downloadDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no download dir: %w", err)
+	}
+	return filepath.Join(cache, "tezsign-updater"), nil
+}
+
+// fetchSource downloads the source at url, and its signatures unless they
+// are not checked, and returns where it put them.
+func fetchSource(url string, opts updateOptions, logger *slog.Logger) (string, func(), error) {
+	if !strings.HasPrefix(url, "https://") {
+		return "", nil, fmt.Errorf("refusing to download %s: only https:// sources are supported", url)
+	}
+	downloaded, cleanup, err := downloadWithProgress(url, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	if opts.Insecure || opts.SHA256 != "" {
+		return downloaded, cleanup, nil
+	}
+	cleanupSigs, err := downloadSignatures(url, downloaded)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return downloaded, func() {
+		cleanupSigs()
+		cleanup()
+	}, nil
+}
+
+// downloadWithProgress downloads url into the download dir. The name keeps
+// the published one, which tells an image from a binary, behind a prefix
+// of the URL's hash so two releases never share a .part file.
+func downloadWithProgress(url string, logger *slog.Logger) (string, func(), error) {
+	dir, err := downloadDir()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", nil, fmt.Errorf("failed to create download dir: %w", err)
+	}
+	sum := sha256.Sum256([]byte(url))
+	target := filepath.Join(dir, hex.EncodeToString(sum[:6])+"-"+path.Base(url))
+	part := target + ".part"
+	// the validator of the server's copy a .part file was started from; a
+	// resume that does not match it starts over
+	validatorPath := part + ".etag"
+
+	var offset int64
+	var validator string
+	if st, err := os.Stat(part); err == nil {
+		if data, err := os.ReadFile(validatorPath); err == nil && len(data) > 0 {
+			offset, validator = st.Size(), string(data)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+		logger.Info("Resuming download", "url", url, "offset", offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// the .part file already holds everything
+		body = http.NoBody
+		resp.ContentLength = 0
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+		os.Remove(validatorPath)
+		if v := responseValidator(resp); v != "" {
+			if err := os.WriteFile(validatorPath, []byte(v), 0o600); err != nil {
+				return "", nil, fmt.Errorf("failed to create download file: %w", err)
+			}
+		}
+	default:
+		return "", nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	f, err := os.OpenFile(part, flags, 0o600)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create download file: %w", err)
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	cr := &countingReader{r: body, read: offset}
+	// cancelling keeps the .part file for the next run
+	cancel := func() {
+		resp.Body.Close()
+	}
+
+	title := fmt.Sprintf("Download %s → %s", path.Base(url), filepath.Base(target))
+	err = runProgress(title, total, cr, cancel, func() error {
+		_, copyErr := io.Copy(f, cr)
+		if closeErr := f.Close(); copyErr == nil {
+			copyErr = closeErr
+		}
+		return copyErr
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download %s (run again to resume): %w", url, err)
+	}
+	if total >= 0 && cr.BytesRead() != total {
+		return "", nil, fmt.Errorf("failed to download %s (run again to resume): %w", url, io.ErrUnexpectedEOF)
+	}
+	if err := os.Rename(part, target); err != nil {
+		return "", nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	os.Remove(validatorPath)
+	logger.Info("Downloaded source", "url", url, "path", target, "bytes", cr.BytesRead())
+
+	cleanup := func() {
+		os.Remove(target)
+	}
+	return target, cleanup, nil
+}
+
+// responseValidator is what If-Range can match the response against: a
+// strong ETag, else its Last-Modified.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+var errChecksumMismatch = errors.New("source checksum mismatch")
+
+// parseSHA256 reads the --sha256 value.
+func parseSHA256(s string) (string, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "sha256:")
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 %q: expected 64 hex digits", s)
+	}
+	return s, nil
+}
+
+// checkPinnedChecksum compares source with --sha256.
+func checkPinnedChecksum(source string, opts updateOptions, logger *slog.Logger) error {
+	actual, size, err := readbackFile(source)
+	if err != nil {
+		return err
+	}
+	if actual != opts.SHA256 {
+		return fmt.Errorf("%w: %s has sha256 %s, expected %s", errChecksumMismatch, source, actual, opts.SHA256)
+	}
+	logger.Info("Verified source checksum", "source", source, "sha256", actual, "bytes", size)
+	return nil
+}
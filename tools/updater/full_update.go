@@ -9,9 +9,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -19,7 +18,7 @@ import (
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/partition/part"
-	"github.com/ulikunitz/xz"
+	"github.com/tez-capital/tezsign/tools/common"
 )
 
 var validFlavours = map[string]bool{
@@ -29,153 +28,43 @@ var validFlavours = map[string]bool{
 	"radxa_zero3.dev":  true,
 }
 
-func maybeDecompressSource(path string, logger *slog.Logger) (string, func(), error) {
-	if !strings.HasSuffix(path, ".xz") {
-		return path, func() {}, nil
+// performUpdate writes source to destination. The report lists what was
+// read back, also when the readback fails the update.
+func performUpdate(source, destination string, kind UpdateKind, opts updateOptions, logger *slog.Logger) (*updateReport, error) {
+	if kind == UpdateKindAppOnly {
+		return performAppUpdate(source, destination, opts, logger)
 	}
+	logger.Info("Starting TezSign updater", "source", source, "destination", destination, "kind", string(kind))
+	report := &updateReport{}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to open compressed source %s: %w", path, err)
-	}
-	stat, _ := f.Stat()
-	totalBytes := stat.Size()
-
-	cr := &countingReader{r: f}
-	r, err := xz.NewReader(cr)
-	if err != nil {
-		f.Close()
-		return "", nil, fmt.Errorf("failed to create xz reader: %w", err)
+	var manifest *common.ImageManifest
+	var delta *deltaSource
+	var err error
+	if opts.Delta {
+		manifest, delta, err = openDeltaSource(source, opts, logger)
+	} else {
+		manifest, err = verifySource(source, opts, logger)
 	}
-
-	tmpFile, err := os.CreateTemp("", "tezsign_img_*.img")
 	if err != nil {
-		f.Close()
-		return "", nil, fmt.Errorf("failed to create temp file for decompression: %w", err)
-	}
-
-	logger.Info("Decompressing source image", "source", path, "destination", tmpFile.Name())
-
-	cancel := func() {
-		f.Close()
-		tmpFile.Close()
-	}
-
-	title := fmt.Sprintf("Decompress %s → %s", filepath.Base(path), filepath.Base(tmpFile.Name()))
-	p := tea.NewProgram(newProgressModel(title, totalBytes, cr, cancel))
-
-	go func() {
-		_, copyErr := io.Copy(tmpFile, r)
-		tmpFile.Close()
-		f.Close()
-		p.Send(finishMsg{err: copyErr})
-	}()
-
-	model, progErr := p.Run()
-	if progErr != nil {
-		os.Remove(tmpFile.Name())
-		return "", nil, fmt.Errorf("failed to render decompress progress: %w", progErr)
+		return report, err
 	}
-
-	res, ok := model.(progressModel)
-	if !ok {
-		os.Remove(tmpFile.Name())
-		return "", nil, errors.New("unexpected model type after decompression")
-	}
-
-	if res.err != nil {
-		os.Remove(tmpFile.Name())
-		return "", nil, fmt.Errorf("failed to decompress source image: %w", res.err)
+	if err := checkDestination(destination, opts, logger); err != nil {
+		return report, err
 	}
 
-	cleanup := func() {
-		os.Remove(tmpFile.Name())
+	mode := diskfs.ReadWriteExclusive
+	if opts.DryRun {
+		mode = diskfs.ReadOnly
 	}
-
-	return tmpFile.Name(), cleanup, nil
-}
-
-func copyPartitionData(srcDisk *disk.Disk, srcPartition part.Partition, dstDisk *disk.Disk, dstPartition part.Partition, description string, logger *slog.Logger) error {
-	pr, pw := io.Pipe()
-	writableDst, err := dstDisk.Backend.Writable()
+	dstImg, destinationBootPartition, destinationRootfsPartition, destinationAppPartition, err := loadImage(destination, mode)
 	if err != nil {
-		return errors.New("failed to get writable backend for destination disk")
-	}
-
-	totalBytes := srcPartition.GetSize()
-	counter := &countingWriter{w: pw}
-	progress := tea.NewProgram(newProgressModel(fmt.Sprintf("Copying %s", description), totalBytes, counter, nil))
-
-	errCh := make(chan error, 1)
-
-	go func() {
-		var wg sync.WaitGroup
-		var readErr, writeErr error
-		var readBytes int64
-
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer pw.Close()
-
-			readBytes, readErr = srcPartition.ReadContents(srcDisk.Backend, counter)
-			if readErr != nil {
-				logger.Error("Failed to read contents from source partition", "error", readErr)
-			}
-		}()
-
-		writtenBytes, writeErr := dstPartition.WriteContents(writableDst, pr)
-		if writeErr != nil {
-			logger.Error("Failed to write contents to destination partition", "error", writeErr)
-		}
-		pr.Close()
-		wg.Wait()
-
-		var copyErr error
-		if readErr != nil {
-			copyErr = errors.New("error occurred while reading from source partition: " + readErr.Error())
-		} else if writeErr != nil {
-			copyErr = errors.New("error occurred while writing to destination partition: " + writeErr.Error())
-		} else if uint64(readBytes) != writtenBytes {
-			copyErr = errors.New("mismatch in bytes read and written")
-		}
-
-		progress.Send(finishMsg{err: copyErr})
-		errCh <- copyErr
-	}()
-
-	if _, progErr := progress.Run(); progErr != nil {
-		return fmt.Errorf("failed to render copy progress: %w", progErr)
-	}
-
-	if copyErr := <-errCh; copyErr != nil {
-		return copyErr
-	}
-
-	return nil
-}
-
-func performUpdate(source, destination string, kind UpdateKind, logger *slog.Logger) error {
-	logger.Info("Starting TezSign updater", "source", source, "destination", destination, "kind", string(kind))
-
-	sourcePath, cleanup, err := maybeDecompressSource(source, logger)
-	if err != nil {
-		return err
-	}
-	defer cleanup()
-
-	dstImg, destinationBootPartition, destinationRootfsPartition, destinationAppPartition, err := loadImage(destination, diskfs.ReadWriteExclusive)
-	if err != nil {
-		return fmt.Errorf("failed to load destination image: %w", err)
+		return report, fmt.Errorf("failed to load destination image: %w", err)
 	}
 	defer dstImg.Close()
 
 	tbl, err := dstImg.GetPartitionTable()
 	if err != nil {
-		return fmt.Errorf("failed to read destination partition table: %w", err)
-	}
-	if err := unmountDestinationPartitions(destination, tbl, logger, destinationBootPartition, destinationRootfsPartition, destinationAppPartition); err != nil {
-		return err
+		return report, fmt.Errorf("failed to read destination partition table: %w", err)
 	}
 
 	if ok, err := checkTezsignMarker(dstImg); err != nil {
@@ -186,59 +75,200 @@ func performUpdate(source, destination string, kind UpdateKind, logger *slog.Log
 
 	switch kind {
 	case UpdateKindFull:
-		existingTezsignID := backupTezsignID(dstImg, destinationAppPartition, logger)
-		sourceImg, sourceBootPartition, sourceRootfsPartition, sourceAppPartition, err := loadImage(sourcePath, diskfs.ReadOnly)
-		if err != nil {
-			return fmt.Errorf("failed to load source image: %w", err)
+		// a compressed image is streamed once, straight to the destination
+		var sourceImg *disk.Disk
+		var xzSource *xzImage
+		var sourceBootPartition, sourceRootfsPartition, sourceAppPartition part.Partition
+		switch {
+		case delta != nil:
+			sourceBootPartition, sourceRootfsPartition, sourceAppPartition = delta.boot, delta.rootfs, delta.app
+		case strings.HasSuffix(source, ".xz"):
+			if xzSource, err = openXZImage(source); err != nil {
+				return report, err
+			}
+			defer xzSource.Close()
+			sourceBootPartition, sourceRootfsPartition, sourceAppPartition = xzSource.boot, xzSource.rootfs, xzSource.app
+		default:
+			if sourceImg, sourceBootPartition, sourceRootfsPartition, sourceAppPartition, err = loadImage(source, diskfs.ReadOnly); err != nil {
+				return report, fmt.Errorf("failed to load source image: %w", err)
+			}
+			defer sourceImg.Close()
 		}
-		defer sourceImg.Close()
 
 		if (sourceBootPartition == nil || destinationBootPartition == nil) && (sourceBootPartition != destinationBootPartition) {
-			return errors.New("boot partition missing in source image or destination device, cannot proceed with full update")
+			return report, fmt.Errorf("%w: boot partition missing in source image or destination device, cannot proceed with full update", errSizeMismatch)
 		}
 		if sourceBootPartition != nil && sourceBootPartition.GetSize() != destinationBootPartition.GetSize() {
-			return errors.New("boot partition size mismatch between source image and destination device, cannot proceed with update")
+			return report, fmt.Errorf("%w: boot partition size mismatch between source image and destination device, cannot proceed with update", errSizeMismatch)
 		}
 
 		if sourceRootfsPartition.GetSize() != destinationRootfsPartition.GetSize() {
-			return errors.New("rootfs partition size mismatch between source image and destination device, cannot proceed with update")
+			return report, fmt.Errorf("%w: rootfs partition size mismatch between source image and destination device, cannot proceed with update", errSizeMismatch)
 		}
 
 		if sourceAppPartition.GetSize() != destinationAppPartition.GetSize() {
-			return errors.New("app partition size mismatch between source image and destination device, cannot proceed with update")
+			return report, fmt.Errorf("%w: app partition size mismatch between source image and destination device, cannot proceed with update", errSizeMismatch)
 		}
 
-		if sourceBootPartition != nil {
-			logger.Info("Updating boot partition...")
-			if err = copyPartitionData(sourceImg, sourceBootPartition, dstImg, destinationBootPartition, "boot partition", logger); err != nil {
-				return fmt.Errorf("failed to update boot partition: %w", err)
+		var written []*streamCopy
+		dstParts := map[*streamCopy]part.Partition{}
+		for _, p := range []struct {
+			name     string
+			src, dst part.Partition
+		}{
+			{"boot", sourceBootPartition, destinationBootPartition},
+			{"rootfs", sourceRootfsPartition, destinationRootfsPartition},
+			{"app", sourceAppPartition, destinationAppPartition},
+		} {
+			if p.src == nil {
+				continue
 			}
+			c := &streamCopy{Name: p.name, Src: p.src, Offset: p.dst.GetStart()}
+			written = append(written, c)
+			dstParts[c] = p.dst
+		}
+		if err := checkDataUntouched(dstImg, written); err != nil {
+			return report, err
+		}
+
+		// an unsigned compressed image would have to be decompressed up to
+		// its app partition for the version; it stays unknown
+		var sourceVersion imageVersion
+		if manifest != nil {
+			sourceVersion = manifestVersion(manifest)
+		} else if sourceImg != nil {
+			sourceVersion = readAppVersion(sourceImg, sourceAppPartition)
+		}
+		destinationVersion := readAppVersion(dstImg, destinationAppPartition)
+		if err := checkDowngrade(destinationVersion, sourceVersion, opts, logger); err != nil {
+			return report, err
+		}
+
+		staged, err := stageAppDeviceFiles(dstImg, destinationAppPartition, logger)
+		if err != nil {
+			return report, err
+		}
+
+		report.Plan = &updatePlan{Kind: kind, Source: source, Destination: destination, From: destinationVersion, To: sourceVersion, SkipUnchanged: opts.SkipUnchanged, Delta: delta != nil}
+		for _, c := range written {
+			report.Plan.Steps = append(report.Plan.Steps, planStep{Name: c.Name + " partition", Bytes: c.Src.GetSize(), SourceOffset: c.Src.GetStart(), DestinationOffset: c.Offset})
+		}
+		for _, name := range staged.names {
+			report.Plan.Preserved = append(report.Plan.Preserved, "app /"+name)
+		}
+		report.Plan.Preserved = append(report.Plan.Preserved, "data partition")
+		if opts.DryRun {
+			staged.cleanup()
+			report.DryRun = true
+			return report, nil
 		}
 
-		logger.Info("Updating rootfs partition...")
-		if err = copyPartitionData(sourceImg, sourceRootfsPartition, dstImg, destinationRootfsPartition, "rootfs partition", logger); err != nil {
-			return fmt.Errorf("failed to update rootfs partition: %w", err)
+		// the backup, the copies (of the compressed file when streaming, of
+		// the card for a delta) and the readback
+		var partitionBytes int64
+		for _, c := range written {
+			partitionBytes += c.Src.GetSize()
+		}
+		total := 2 * partitionBytes
+		if xzSource != nil {
+			total = xzSource.size + partitionBytes
+		}
+		if !opts.NoBackup {
+			total += destinationAppPartition.GetSize()
 		}
+		beginOverall(total)
 
-		logger.Info("Updating app partition...")
-		if err = copyPartitionData(sourceImg, sourceAppPartition, dstImg, destinationAppPartition, "app partition", logger); err != nil {
-			return fmt.Errorf("failed to update app partition: %w", err)
+		if err := staged.recordUpdate(historyEntry{Time: time.Now().UTC(), Kind: kind, From: destinationVersion.String(), To: sourceVersion.String(), Source: filepath.Base(source)}); err != nil {
+			staged.cleanup()
+			return report, err
+		}
+		if !opts.NoBackup {
+			if report.Backup, err = backupAppPartition(destination, dstImg, destinationAppPartition, opts, logger); err != nil {
+				staged.cleanup()
+				return report, err
+			}
 		}
-		if err := flushDevice(destination, logger); err != nil {
-			return fmt.Errorf("failed to flush destination before tezsign_id restore: %w", err)
+		if err := unmountDestinationPartitions(destination, tbl, logger, destinationBootPartition, destinationRootfsPartition, destinationAppPartition); err != nil {
+			staged.cleanup()
+			return report, err
 		}
-		if existingTezsignID != "" {
-			if err := restoreTezsignID(existingTezsignID, destination, dstImg, destinationAppPartition, logger); err != nil {
-				return fmt.Errorf("failed to restore tezsign_id: %w", err)
+
+		err = func() error {
+			var base destinationFile
+			if opts.Direct {
+				f, err := openDirect(destination)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				base = f
+			} else {
+				writableDst, err := dstImg.Backend.Writable()
+				if err != nil {
+					return errors.New("failed to get writable backend for destination disk")
+				}
+				base = writableDst
+			}
+			if opts.SkipUnchanged && delta == nil {
+				report.SkipUnchanged = &skipStats{}
+			}
+			dst := func() io.WriterAt {
+				if report.SkipUnchanged != nil {
+					return newSkipWriter(base, report.SkipUnchanged)
+				}
+				return base
+			}
+
+			if delta != nil {
+				report.Delta = &deltaStats{}
+				if err := delta.apply(written, base, report.Delta, logger); err != nil {
+					return fmt.Errorf("failed to update partitions: %w", err)
+				}
+			} else if xzSource != nil {
+				for _, c := range written {
+					c.Dst = dst()
+				}
+				logger.Info("Updating partitions from the compressed image...")
+				if err := xzSource.stream(written, opts.bufferSize()); err != nil {
+					return fmt.Errorf("failed to update partitions: %w", err)
+				}
+			} else if err := copyPartitions(sourceImg.Backend, written, dst, opts, logger); err != nil {
+				return err
+			}
+			if err := flushDevice(destination, logger); err != nil {
+				return fmt.Errorf("failed to flush destination before device file restore: %w", err)
+			}
+
+			// read back before the device files change the app partition
+			for _, c := range written {
+				logger.Info(fmt.Sprintf("Verifying %s partition...", c.Name))
+				sum, err := readbackPartition(destination, dstParts[c], c.Name+" partition")
+				report.add(partitionCheck{Name: c.Name + " partition", Bytes: c.Src.GetSize(), Expected: c.SHA256, Actual: sum, Err: err})
+			}
+			if err := report.err(); err != nil {
+				return err
 			}
+
+			if err := staged.restore(destination, dstImg, destinationAppPartition, report, logger); err != nil {
+				return fmt.Errorf("failed to restore device files: %w", err)
+			}
+			return nil
+		}()
+		if err != nil {
+			if !errors.Is(err, errVerificationFailed) {
+				err = fmt.Errorf("%w: %w", errWriteFailed, err)
+			}
+			if staged.dir != "" {
+				logger.Error("The device files of the app partition are kept on this host", "dir", staged.dir, "files", staged.names)
+			}
+			return report, err
 		}
-	case UpdateKindAppOnly:
-		return errors.New("app-only updates require a gadget binary, not an image")
+		staged.cleanup()
 	default:
-		return fmt.Errorf("unsupported update kind: %s", kind)
+		return report, fmt.Errorf("unsupported update kind: %s", kind)
 	}
 
-	return nil
+	return report, nil
 }
 
 func deviceFlavour(devicePath string) (string, error) {
@@ -304,56 +334,6 @@ func readImageFlavour(fs filesystem.FileSystem) (string, error) {
 	return flavour, nil
 }
 
-func backupTezsignID(d *disk.Disk, appPartition part.Partition, logger *slog.Logger) string {
-	fs, err := filesystemForPartition(d, appPartition)
-	if err != nil {
-		logger.Debug("Failed to open app filesystem for tezsign_id backup", "error", err)
-		return ""
-	}
-	defer fs.Close()
-
-	f, err := fs.OpenFile("/tezsign_id", os.O_RDONLY)
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			logger.Debug("Failed to read tezsign_id from app partition", "error", err)
-		}
-		return ""
-	}
-	defer f.Close()
-
-	data, err := io.ReadAll(f)
-	if err != nil {
-		logger.Debug("Failed to read tezsign_id contents", "error", err)
-		return ""
-	}
-
-	id := strings.TrimSpace(string(data))
-	if id != "" {
-		logger.Debug("Preserving existing", "tezsign_id", id)
-	}
-	return id
-}
-
-func restoreTezsignID(id, destination string, d *disk.Disk, appPartition part.Partition, logger *slog.Logger) error {
-	if id == "" {
-		return nil
-	}
-
-	tbl, err := d.GetPartitionTable()
-	if err != nil {
-		return fmt.Errorf("failed to read partition table: %w", err)
-	}
-
-	idx, err := partitionIndex(tbl, appPartition)
-	if err != nil {
-		logger.Error("Unable to locate app partition index for tezsign_id restore", "error", err)
-		return fmt.Errorf("failed to locate app partition index: %w", err)
-	}
-
-	logger.Debug("Restoring tezsign_id via mount", "partition_index", idx)
-	return writeTezsignIDViaMount(id, destination, idx, logger)
-}
-
 func unmountDestinationPartitions(destination string, tbl partition.Table, logger *slog.Logger, partitions ...part.Partition) error {
 	for _, p := range partitions {
 		if p == nil {
@@ -377,6 +357,8 @@ func unmountIfMounted(devicePath string, logger *slog.Logger) error {
 		return fmt.Errorf("failed to read /proc/mounts: %w", err)
 	}
 
+	// an unresolvable path (an image file has no partition devices) only
+	// matches by name
 	resolvedTarget, _ := filepath.EvalSymlinks(devicePath)
 	var mountPoints []string
 
@@ -389,7 +371,7 @@ func unmountIfMounted(devicePath string, logger *slog.Logger) error {
 		mountPoint := fields[1]
 
 		resolvedDev, _ := filepath.EvalSymlinks(dev)
-		if dev == devicePath || resolvedDev == resolvedTarget {
+		if dev == devicePath || (resolvedTarget != "" && resolvedDev == resolvedTarget) {
 			mountPoints = append(mountPoints, mountPoint)
 			logger.Debug("Found mounted destination partition", "device", dev, "mount_point", mountPoint)
 		}
@@ -405,94 +387,6 @@ func unmountIfMounted(devicePath string, logger *slog.Logger) error {
 	return nil
 }
 
-func writeTezsignIDViaMount(id, destination string, appPartitionIndex int, logger *slog.Logger) error {
-	partDevice := partitionDevicePath(destination, appPartitionIndex)
-	if err := unmountIfMounted(partDevice, logger); err != nil {
-		return err
-	}
-
-	mountDir, mountCleanup, err := mountSpecificPartition(destination, appPartitionIndex, true)
-	if err != nil {
-		logger.Error("Failed to mount app partition for tezsign_id restore", "error", err, "destination", destination, "partition_index", appPartitionIndex, "device", partDevice)
-		return err
-	}
-	tmpDir := mountDir
-	cleanup := mountCleanup
-	defer cleanup()
-
-	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
-		for _, line := range strings.Split(string(mounts), "\n") {
-			if strings.Contains(line, tmpDir) {
-				logger.Debug("Mount entry for app partition", "entry", line)
-				break
-			}
-		}
-	}
-
-	idPath := fmt.Sprintf("%s/tezsign_id", tmpDir)
-	if err := os.WriteFile(idPath, []byte(id+"\n"), 0644); err != nil {
-		logger.Error("Failed to write tezsign_id to mounted app partition", "error", err, "path", idPath)
-		return err
-	}
-	if err := os.Chmod(idPath, 0400); err != nil {
-		logger.Debug("Failed to chmod tezsign_id", "error", err, "path", idPath)
-	}
-	if err := fsyncPath(idPath); err != nil {
-		logger.Debug("Failed to fsync tezsign_id", "error", err, "path", idPath)
-	}
-	if err := fsyncPath(tmpDir); err != nil {
-		logger.Debug("Failed to fsync app mount directory", "error", err, "path", tmpDir)
-	}
-
-	if out, err := exec.Command("ls", "-l", tmpDir).CombinedOutput(); err != nil {
-		logger.Debug("Failed to list app mount after writing tezsign_id", "error", err, "output", string(out))
-	} else {
-		logger.Debug("App mount contents after tezsign_id restore", "output", string(out))
-	}
-
-	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
-		logger.Debug("sync failed after tezsign_id restore", "error", err, "output", string(out))
-	}
-	if out, err := exec.Command("blockdev", "--flushbufs", partDevice).CombinedOutput(); err != nil {
-		logger.Debug("blockdev flush failed after tezsign_id restore", "error", err, "output", string(out))
-	}
-
-	if err := unmountIfMounted(partDevice, logger); err != nil {
-		return fmt.Errorf("failed to unmount app partition after tezsign_id write: %w", err)
-	}
-	// Avoid double umount/removal if cleanup runs later.
-	os.RemoveAll(tmpDir)
-	cleanup = func() {}
-
-	verifyDir, verifyCleanup, err := mountSpecificPartition(destination, appPartitionIndex, false)
-	if err != nil {
-		return fmt.Errorf("failed to remount app partition for tezsign_id verification: %w", err)
-	}
-	defer verifyCleanup()
-
-	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
-		for _, line := range strings.Split(string(mounts), "\n") {
-			if strings.Contains(line, verifyDir) {
-				logger.Debug("Mount entry for app partition (verify)", "entry", line)
-				break
-			}
-		}
-	}
-	if out, err := exec.Command("ls", "-l", verifyDir).CombinedOutput(); err == nil {
-		logger.Debug("App mount contents after remount verify", "output", string(out))
-	}
-
-	verifyPath := fmt.Sprintf("%s/tezsign_id", verifyDir)
-	data, err := os.ReadFile(verifyPath)
-	if err != nil {
-		return fmt.Errorf("failed to verify tezsign_id after remount: %w", err)
-	}
-	logger.Debug("Verified tezsign_id after remount", "value", strings.TrimSpace(string(data)))
-
-	logger.Debug("tezsign_id restored", "path", verifyPath)
-	return nil
-}
-
 func fsyncPath(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/diskfs/go-diskfs"
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+// Before anything is written the destination has to look like a TezSign
+// card: removable, no bigger than a card, with its partitions inside it and
+// nothing of it mounted but by a desktop automounter. --force overrides
+// after the device name is typed back.
+
+var errUnsafeDestination = errors.New("refusing to write this destination")
+
+// maxCardSize is above every card TezSign runs on; a bigger disk is
+// something else.
+const maxCardSize = 512 << 30
+
+// systemMountPoints make the disk they are mounted from the running
+// system's.
+var systemMountPoints = []string{"/", "/boot", "/boot/efi", "/boot/firmware", "/usr", "/var", "/home"}
+
+// automountRoots are where desktops mount inserted cards; the updater
+// unmounts those partitions itself.
+var automountRoots = []string{"/media/", "/run/media/"}
+
+// checkDestination refuses a destination that does not look like a
+// TezSign card unless --force is passed and confirmed. Image files are
+// not checked.
+func checkDestination(destination string, opts updateOptions, logger *slog.Logger) error {
+	dev, err := common.InspectBlockDevice(destination)
+	if errors.Is(err, common.ErrNotBlockDevice) {
+		if st, statErr := os.Stat(destination); statErr == nil && st.Mode().IsRegular() {
+			logger.Debug("Destination is an image file; skipping device checks", "destination", destination)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	problems, err := destinationProblems(destination, dev)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if !opts.Force {
+		return fmt.Errorf("%w %s: it %s; pass --force to override", errUnsafeDestination, dev, strings.Join(problems, ", "))
+	}
+	for _, p := range problems {
+		logger.Warn("Writing an unsafe destination (--force)", "device", dev.Path, "problem", p)
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return confirmForce(dev, problems)
+}
+
+func destinationProblems(destination string, dev *common.BlockDevice) ([]string, error) {
+	var problems []string
+	mounts, err := dev.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		switch {
+		case m.Target == "swap" || slices.Contains(systemMountPoints, m.Target):
+			problems = append(problems, fmt.Sprintf("looks like the running system disk (%s)", m))
+		case !slices.ContainsFunc(automountRoots, func(root string) bool { return strings.HasPrefix(m.Target, root) }):
+			problems = append(problems, fmt.Sprintf("is mounted (%s)", m))
+		}
+	}
+
+	if !dev.Removable {
+		transport := dev.Transport
+		if transport == "" {
+			transport = "unknown"
+		}
+		problems = append(problems, fmt.Sprintf("is a fixed disk, not a card (transport %s)", transport))
+	}
+	if dev.Size > maxCardSize {
+		problems = append(problems, fmt.Sprintf("is %s, bigger than any card", byteCountToHumanReadable(dev.Size)))
+	}
+
+	// a destination that cannot be read fails the update when it is loaded
+	d, _, _, _, err := loadImage(destination, diskfs.ReadOnly)
+	if err != nil {
+		return problems, nil
+	}
+	defer d.Close()
+	if tbl, err := d.GetPartitionTable(); err == nil {
+		for _, p := range tbl.GetPartitions() {
+			if end := p.GetStart() + p.GetSize(); p.GetSize() > 0 && end > dev.Size {
+				problems = append(problems, fmt.Sprintf("is %s, smaller than its partition table (%s)", byteCountToHumanReadable(dev.Size), byteCountToHumanReadable(end)))
+				break
+			}
+		}
+	}
+	return problems, nil
+}
+
+// confirmForce asks for the device name, so --force is not confirmed by
+// habit.
+func confirmForce(dev *common.BlockDevice, problems []string) error {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("%w %s: --force needs the device name typed on a terminal", errUnsafeDestination, dev)
+	}
+	fmt.Fprintf(os.Stderr, "\n%s:\n", dev)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  - it %s\n", p)
+	}
+	fmt.Fprintf(os.Stderr, "Type %q to write it anyway: ", dev.Name)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(answer) != dev.Name {
+		return errors.New("aborted")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+)
+
+// listedDevice is a line of `list --json`.
+type listedDevice struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Model   string `json:"model"`
+	Tezsign bool   `json:"tezsign"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Status  string `json:"status"`
+}
+
+// listDevices prints the removable disks, TezSign cards with the release
+// on their app partition.
+func listDevices(w io.Writer, opts updateOptions, logger *slog.Logger) error {
+	devices, err := scanBlockDevices(logger)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(w)
+		for _, d := range devices {
+			if err := enc.Encode(listedDevice{
+				Path:    d.Path,
+				Size:    int64(d.SizeBytes),
+				Model:   d.Model,
+				Tezsign: d.Valid,
+				Version: d.Version.Version,
+				Commit:  d.Version.Commit,
+				Status:  d.Status,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(devices) == 0 {
+		fmt.Fprintln(w, "No removable block devices detected.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tSIZE\tMODEL\tVERSION\tSTATUS")
+	for _, d := range devices {
+		version := "-"
+		if d.Valid {
+			version = d.Version.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Path, byteCountToHumanReadable(int64(d.SizeBytes)), d.Model, version, d.Status)
+	}
+	return tw.Flush()
+}
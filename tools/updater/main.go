@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -18,6 +16,7 @@ import (
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/tools/common"
 	"github.com/tez-capital/tezsign/tools/constants"
 )
 
@@ -29,12 +28,45 @@ const (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run returns the exit code rather than exiting, so downloads are removed
+// by their deferred cleanups first.
+func run() int {
 	logger, _ := logging.NewFromEnv()
 
-	args := os.Args[1:]
-	if hasHelpFlag(args) {
+	if hasHelpFlag(os.Args[1:]) {
 		printUsage()
-		return
+		return exitOK
+	}
+	args, opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		logger.Error("Invalid arguments", "error", err)
+		return exitUsage
+	}
+	logger = setupOutput(opts.JSON, logger)
+	// with --json stdout carries only JSON lines
+	out := io.Writer(os.Stdout)
+	if opts.JSON {
+		out = os.Stderr
+	}
+
+	if len(args) >= 1 && args[0] == "list" {
+		if err := listDevices(os.Stdout, opts, logger); err != nil {
+			logger.Error("Listing devices failed", "error", err)
+			return 1
+		}
+		return exitOK
+	}
+
+	if len(args) >= 1 && args[0] == "rollback" {
+		if len(args) != 2 {
+			printUsage()
+			return exitUsage
+		}
+		report, err := performRollback(args[1], opts, logger)
+		return finish("Rollback", report, err, out, opts, logger)
 	}
 
 	var source string
@@ -45,6 +77,20 @@ func main() {
 		sourceProvided = true
 		appBinary = source // allow supplying only the source while still using interactive flow
 	}
+	// a delta update reads the release chunk by chunk from its URL
+	if opts.Delta && (!sourceProvided || !isURL(source) || len(args) < 2 || len(args) >= 3 && UpdateKind(args[2]) != UpdateKindFull) {
+		logger.Error("--delta needs the https:// URL of a released image, a destination and a full update")
+		return exitUsage
+	}
+	if sourceProvided && isURL(source) && !opts.Delta {
+		downloaded, cleanup, err := fetchSource(source, opts, logger)
+		if err != nil {
+			logger.Error("Failed to download source", "error", err)
+			return 1
+		}
+		defer cleanup()
+		source, appBinary = downloaded, downloaded
+	}
 
 	// Keep the previous non-interactive flow when destination is provided explicitly.
 	if sourceProvided && len(args) >= 2 {
@@ -56,41 +102,24 @@ func main() {
 			case UpdateKindFull, UpdateKindAppOnly:
 			default:
 				logger.Error("Invalid update kind. Valid options are: full, app")
-				os.Exit(1)
+				return exitUsage
 			}
 		}
 
-		switch kind {
-		case UpdateKindFull:
-			if err := performUpdate(source, destination, kind, logger); err != nil {
-				logger.Error("Update failed", "error", err)
-				os.Exit(1)
-			}
-		case UpdateKindAppOnly:
-			appBinary = source
-			if err := performAppBinaryUpdate(appBinary, destination, logger); err != nil {
-				logger.Error("Update failed", "error", err)
-				os.Exit(1)
-			}
-		default:
-			logger.Error("Invalid update kind. Valid options are: full, app")
-			os.Exit(1)
-		}
-
-		logger.Info("Update completed successfully")
-		return
+		report, err := performUpdate(source, destination, kind, opts, logger)
+		return finish("Update", report, err, out, opts, logger)
 	}
 
 	devices, err := discoverTezsignDevices(logger)
 	if err != nil {
 		logger.Error("Failed to discover TezSign devices", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	selectedDevice, kind, err := runSelection(devices)
 	if err != nil {
 		logger.Error("Selection failed", "error", err)
-		os.Exit(1)
+		return 1
 	}
 
 	if !sourceProvided {
@@ -99,28 +128,28 @@ func main() {
 			flavour, err := deviceFlavour(selectedDevice.Path)
 			if err != nil {
 				logger.Error("Failed to detect device flavor", "error", err)
-				os.Exit(1)
+				return 1
 			}
 			url := fmt.Sprintf("%s%s.img.xz", constants.LatestReleaseURL, flavour)
-			downloaded, cleanupFn, err := downloadWithProgress(url)
+			downloaded, cleanup, err := fetchSource(url, opts, logger)
 			if err != nil {
 				logger.Error("Failed to download image", "error", err)
-				os.Exit(1)
+				return 1
 			}
-			defer cleanupFn()
+			defer cleanup()
 			source = downloaded
 		case UpdateKindAppOnly:
 			url := fmt.Sprintf("%s%s", constants.LatestReleaseURL, constants.AppBinaryName)
-			downloaded, cleanupFn, err := downloadWithProgress(url)
+			downloaded, cleanup, err := fetchSource(url, opts, logger)
 			if err != nil {
 				logger.Error("Failed to download gadget binary", "error", err)
-				os.Exit(1)
+				return 1
 			}
-			defer cleanupFn()
+			defer cleanup()
 			appBinary = downloaded
 		default:
 			logger.Error("Unsupported update kind", "kind", kind)
-			os.Exit(1)
+			return 1
 		}
 	}
 
@@ -128,51 +157,32 @@ func main() {
 	case UpdateKindFull:
 		if _, err := os.Stat(source); err != nil {
 			logger.Error("Invalid source image", "error", err)
-			os.Exit(1)
+			return 1
 		}
 	case UpdateKindAppOnly:
 		if _, err := os.Stat(appBinary); err != nil {
 			logger.Error("Invalid gadget binary", "error", err)
-			os.Exit(1)
+			return 1
 		}
 	}
 
-	fmt.Printf("Updating %s with a %s update...\n\n", selectedDevice.Path, string(kind))
+	fmt.Fprintf(out, "Updating %s with a %s update...\n\n", selectedDevice.Path, string(kind))
 
+	var report *updateReport
 	switch kind {
 	case UpdateKindFull:
-		if err := performUpdate(source, selectedDevice.Path, kind, logger); err != nil {
-			logger.Error("Update failed", "error", err)
-			os.Exit(1)
-		}
+		report, err = performUpdate(source, selectedDevice.Path, kind, opts, logger)
 	case UpdateKindAppOnly:
-		if err := performAppBinaryUpdate(appBinary, selectedDevice.Path, logger); err != nil {
-			logger.Error("Update failed", "error", err)
-			os.Exit(1)
-		}
+		report, err = performAppUpdate(appBinary, selectedDevice.Path, opts, logger)
 	default:
 		logger.Error("Unsupported update kind", "kind", kind)
-		os.Exit(1)
-	}
-
-	fmt.Println("✅ Update completed successfully")
-}
-
-func readSysfsValue(path string) string {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return ""
+		return 1
 	}
-	return string(data)
-}
-
-func readBlockSizeBytes(name string) uint64 {
-	sizeContent := readSysfsValue(filepath.Join("/sys/block", name, "size"))
-	sectors, err := strconv.ParseUint(strings.TrimSpace(sizeContent), 10, 64)
-	if err != nil {
-		return 0
+	code := finish("Update", report, err, out, opts, logger)
+	if code == exitOK && !report.DryRun {
+		fmt.Fprintln(out, "✅ Update completed successfully")
 	}
-	return sectors * 512 // sectors are 512-byte blocks
+	return code
 }
 
 func hasExpectedPartitionCount(t partition.Table) bool {
@@ -219,65 +229,75 @@ func checkTezsignMarker(disk *disk.Disk) (bool, error) {
 	return hasApp && hasData, nil
 }
 
-func probeTezsignDevice(path string) (bool, string) {
-	disk, _, _, _, err := loadImage(path, diskfs.ReadOnly)
+// probeTezsignDevice checks the partition labels and the /tezsign marker
+// and reads the release on the app partition.
+func probeTezsignDevice(path string) (bool, string, imageVersion) {
+	disk, _, _, appPartition, err := loadImage(path, diskfs.ReadOnly)
 	if err != nil {
-		return false, err.Error()
+		return false, err.Error(), imageVersion{}
 	}
 	defer disk.Close()
 
 	ok, err := checkTezsignMarker(disk)
 	switch {
 	case err != nil:
-		return false, "marker check failed"
+		return false, "marker check failed", imageVersion{}
 	case !ok:
-		return false, "device does not match TezSign layout"
+		return false, "device does not match TezSign layout", imageVersion{}
 	default:
-		return true, "OK"
+		return true, "OK", readAppVersion(disk, appPartition)
 	}
 }
 
-func discoverTezsignDevices(logger *slog.Logger) ([]deviceCandidate, error) {
-	paths, err := filepath.Glob("/sys/block/*/removable")
+// scanBlockDevices probes every removable disk, USB card readers that
+// claim to be fixed included.
+func scanBlockDevices(logger *slog.Logger) ([]deviceCandidate, error) {
+	entries, err := os.ReadDir("/sys/block")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list block devices: %w", err)
 	}
 
 	var devices []deviceCandidate
-	for _, removablePath := range paths {
-		flag, err := os.ReadFile(removablePath)
-		if err != nil || strings.TrimSpace(string(flag)) != "1" {
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
 			continue
 		}
-
-		name := filepath.Base(filepath.Dir(removablePath))
-		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+		dev, err := common.InspectBlockDevice(filepath.Join("/dev", name))
+		if err != nil {
+			logger.Debug("Skipping block device", "device", name, "error", err)
+			continue
+		}
+		if !dev.Removable {
 			continue
 		}
 
-		devicePath := filepath.Join("/dev", name)
-		sizeBytes := readBlockSizeBytes(name)
-		model := strings.TrimSpace(readSysfsValue(filepath.Join("/sys/block", name, "device/model")))
-
-		isTezsign, status := probeTezsignDevice(devicePath)
+		isTezsign, status, version := probeTezsignDevice(dev.Path)
 		if !isTezsign {
-			logger.Debug("Device did not validate as TezSign", "device", devicePath, "status", status)
+			logger.Debug("Device did not validate as TezSign", "device", dev.Path, "status", status)
 		}
 
 		devices = append(devices, deviceCandidate{
 			Name:      name,
-			Path:      devicePath,
-			SizeBytes: sizeBytes,
-			Model:     model,
+			Path:      dev.Path,
+			SizeBytes: uint64(dev.Size),
+			Model:     dev.Model,
 			Status:    status,
 			Valid:     isTezsign,
+			Version:   version,
 		})
 	}
+	return devices, nil
+}
 
+func discoverTezsignDevices(logger *slog.Logger) ([]deviceCandidate, error) {
+	devices, err := scanBlockDevices(logger)
+	if err != nil {
+		return nil, err
+	}
 	if len(devices) == 0 {
 		return nil, errors.New("no removable block devices detected")
 	}
-
 	return devices, nil
 }
 
@@ -304,64 +324,6 @@ func runSelection(devices []deviceCandidate) (deviceCandidate, UpdateKind, error
 	return *selection.selectedDevice, selection.selectedKind, nil
 }
 
-func downloadWithProgress(url string) (string, func(), error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to download image: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("failed to download image: %s", resp.Status)
-	}
-
-	tmpFile, err := os.CreateTemp("", "tezsign_download_*.img.xz")
-	if err != nil {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("failed to create temp file for download: %w", err)
-	}
-
-	total := resp.ContentLength
-	cr := &countingReader{r: resp.Body}
-	cancel := func() {
-		resp.Body.Close()
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-	}
-
-	title := fmt.Sprintf("Download %s → %s", filepath.Base(url), filepath.Base(tmpFile.Name()))
-	p := tea.NewProgram(newProgressModel(title, total, cr, cancel))
-
-	go func() {
-		_, copyErr := io.Copy(tmpFile, cr)
-		tmpFile.Close()
-		resp.Body.Close()
-		p.Send(finishMsg{err: copyErr})
-	}()
-
-	model, progErr := p.Run()
-	if progErr != nil {
-		cancel()
-		return "", nil, fmt.Errorf("failed to render download progress: %w", progErr)
-	}
-
-	res, ok := model.(progressModel)
-	if !ok {
-		cancel()
-		return "", nil, errors.New("unexpected model type after download")
-	}
-
-	if res.err != nil {
-		cancel()
-		return "", nil, fmt.Errorf("failed to download image: %w", res.err)
-	}
-
-	cleanup := func() {
-		os.Remove(tmpFile.Name())
-	}
-
-	return tmpFile.Name(), cleanup, nil
-}
-
 func hasHelpFlag(args []string) bool {
 	for _, arg := range args {
 		if arg == "-h" || arg == "-help" || arg == "--help" {
@@ -382,10 +344,66 @@ Usage:
       Interactive mode using a local image/binary; destination is still selected interactively.
   %[1]s <source> <destination> [full|app]
       Non-interactive update using local files (default kind: full).
-  %[1]s <app_binary> <destination> app
-      App-only update with a prebuilt gadget binary.
+      The source may be an https:// URL; it is downloaded with its
+      signatures to ~/.cache/tezsign-updater first, and an interrupted
+      download resumes when run again.
+  %[1]s list
+      List the removable disks with the TezSign version on each card.
+  %[1]s rollback <destination>
+      Write back the app partition as it was before the last update.
+  %[1]s <app_binary|image> <destination> app
+      App-only update: replaces the gadget binary on the app partition with a
+      prebuilt one or the one in an image, keeping the device's own files.
 
 Options:
-  -h, --help    Show this help message.
+  --public-key <file>  Minisign public key to verify the source with instead
+                       of the built-in release key.
+  --sha256 <hex>       Check the source against this checksum instead of
+                       its signature.
+  --dry-run            Print the partitions or files that would be rewritten,
+                       their sizes and offsets and an estimated duration,
+                       without touching the destination.
+  --backup-dir <dir>   Where the app partition is backed up before every
+                       update (default ~/.local/state/tezsign-updater).
+  --no-backup          Skip that backup.
+  --allow-downgrade    Write a source older than the release on the device,
+                       or one whose release is unknown.
+  --skip-unchanged     Full updates write only the 1 MiB chunks that differ
+                       from what the card holds; the whole image is still
+                       read, and the readback still covers everything.
+  --delta              Update from the https:// URL of a signed release
+                       without downloading the image: only the 1 MiB chunks
+                       that differ from the card are fetched, from the
+                       release's <image>.chunks, and written. The signed
+                       manifest vouches for every chunk and the readback
+                       covers every partition.
+  --buffer-size <n>    Copy partitions through buffers of n bytes (K and M
+                       suffixes; default 4M).
+  --direct             Write partitions with O_DIRECT, past the page cache.
+  --parallel           Copy the partitions of an uncompressed image at the
+                       same time, for readers that gain from it.
+  --force              Write a destination that is mounted (other than by a
+                       desktop under /media), looks like the system disk, is
+                       not removable or is bigger than a card, after typing
+                       its name to confirm.
+  --json               Write logs and progress as JSON lines on stdout (the
+                       report goes to stderr). Without a terminal progress
+                       is logged every 10 seconds.
+  --insecure           Write sources without a valid signature. Images need
+                       <image>.sig, <image>.manifest.json and its .sig next to
+                       them; a gadget binary needs <binary>.sig.
+  -h, --help           Show this help message.
+
+Exit codes:
+  0  success
+  1  other failure, e.g. a failed download
+  2  invalid usage
+  3  refused before writing: signature, downgrade, not a TezSign card or an
+     unsafe destination
+  4  partition layout mismatch between source and destination
+  5  write error; the app partition backup applies
+  6  the card reads back differently from what was written
+With --json the last line is a Result record with status, cause, the
+versions, the backup and every readback check.
 `, bin)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Rough SD card speeds for the estimate in a plan; cheap cards write
+// slower, fast readers read faster.
+const (
+	estimatedWriteBytesPerSecond = 20 << 20
+	estimatedReadBytesPerSecond  = 60 << 20
+)
+
+// planStep is one thing an update writes: a whole partition, or a file on
+// the app partition when Path is set.
+type planStep struct {
+	Name              string
+	Path              string
+	Bytes             int64
+	SourceOffset      int64
+	DestinationOffset int64
+}
+
+// updatePlan is what an update is going to write; --dry-run prints it and
+// stops.
+type updatePlan struct {
+	Kind        UpdateKind
+	Source      string
+	Destination string
+	Steps       []planStep
+	// Preserved lists what the update carries over from the destination.
+	Preserved []string
+	// From and To are the releases on the device and in the source.
+	From, To imageVersion
+	// SkipUnchanged skips the chunks the card already holds; Delta does
+	// not download them either.
+	SkipUnchanged bool
+	Delta         bool
+}
+
+// estimate covers writing every step and reading it back.
+func (p *updatePlan) estimate() time.Duration {
+	var total int64
+	for _, s := range p.Steps {
+		total += s.Bytes
+	}
+	seconds := float64(total)/estimatedWriteBytesPerSecond + float64(total)/estimatedReadBytesPerSecond
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+func (p *updatePlan) print(w io.Writer) {
+	fmt.Fprintf(w, "Plan (%s: %s → %s):\n", p.Kind, p.Source, p.Destination)
+	if p.From.known() || p.To.known() {
+		fmt.Fprintf(w, "  version %s → %s\n", p.From, p.To)
+	}
+	var total int64
+	for _, s := range p.Steps {
+		total += s.Bytes
+		if s.Path != "" {
+			fmt.Fprintf(w, "  %-18s %10s  replace %s (partition at %d)\n", s.Name, byteCountToHumanReadable(s.Bytes), s.Path, s.DestinationOffset)
+			continue
+		}
+		fmt.Fprintf(w, "  %-18s %10s  source offset %d → destination offset %d\n", s.Name, byteCountToHumanReadable(s.Bytes), s.SourceOffset, s.DestinationOffset)
+	}
+	for _, name := range p.Preserved {
+		fmt.Fprintf(w, "  keep %s\n", name)
+	}
+	if p.Delta {
+		fmt.Fprintln(w, "  delta: only the chunks the card does not hold are downloaded and written")
+	} else if p.SkipUnchanged {
+		fmt.Fprintln(w, "  skip-unchanged: chunks already on the card are not rewritten, so it may take less")
+	}
+	fmt.Fprintf(w, "  total %s, about %s including the readback\n\n", byteCountToHumanReadable(total), p.estimate())
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// appDeviceFiles are the files a device writes to its app partition, with
+// their modes. A full update stages them before the app partition is
+// overwritten and puts them back after. Keys, watermarks and the config
+// live on the data partition, which an update never writes.
+var appDeviceFiles = map[string]os.FileMode{
+	"tezsign_id":    0400, // the serial the gadget reports; see generate-serial-number.sh
+	historyFileName: 0444,
+}
+
+// historyFileName is constants.AppHistoryFile relative to the partition.
+var historyFileName = strings.TrimPrefix(constants.AppHistoryFile, "/")
+
+var errDataPartition = errors.New("refusing to write the data partition")
+
+// stagedFiles holds copies of the app device files on the host while the
+// partition is rewritten. A failed update leaves them in dir.
+type stagedFiles struct {
+	dir   string
+	names []string
+}
+
+// stageAppDeviceFiles copies the device files off the destination app
+// partition.
+func stageAppDeviceFiles(d *disk.Disk, appPartition part.Partition, logger *slog.Logger) (*stagedFiles, error) {
+	s := &stagedFiles{}
+	fs, err := filesystemForPartition(d, appPartition)
+	if err != nil {
+		logger.Debug("Failed to open app filesystem for staging device files", "error", err)
+		return s, nil
+	}
+	defer fs.Close()
+
+	for name := range appDeviceFiles {
+		f, err := fs.OpenFile("/"+name, os.O_RDONLY)
+		if err != nil {
+			// go-diskfs does not always return os.ErrNotExist; a missing file is the common case
+			logger.Debug("Device file not on the app partition", "file", name, "error", err)
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from the app partition: %w", name, err)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		if s.dir == "" {
+			if s.dir, err = os.MkdirTemp("", "tezsign_preserved_"); err != nil {
+				return nil, fmt.Errorf("failed to create staging dir: %w", err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(s.dir, name), data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to stage %s: %w", name, err)
+		}
+		s.names = append(s.names, name)
+		logger.Debug("Staged device file", "file", name, "value", strings.TrimSpace(string(data)))
+	}
+	slices.Sort(s.names)
+	return s, nil
+}
+
+// recordUpdate appends e to the staged history, which restore then writes
+// to the device with the other files.
+func (s *stagedFiles) recordUpdate(e historyEntry) error {
+	if s.dir == "" {
+		var err error
+		if s.dir, err = os.MkdirTemp("", "tezsign_preserved_"); err != nil {
+			return fmt.Errorf("failed to create staging dir: %w", err)
+		}
+	}
+	path := filepath.Join(s.dir, historyFileName)
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data, err := appendHistory(existing, e)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", historyFileName, err)
+	}
+	if !slices.Contains(s.names, historyFileName) {
+		s.names = append(s.names, historyFileName)
+		slices.Sort(s.names)
+	}
+	return nil
+}
+
+// cleanup removes the staged copies once they are back on the device.
+func (s *stagedFiles) cleanup() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// restore writes the staged files back to the app partition through a
+// mount, then remounts it read-only and reads them back into the report.
+func (s *stagedFiles) restore(destination string, d *disk.Disk, appPartition part.Partition, report *updateReport, logger *slog.Logger) error {
+	if len(s.names) == 0 {
+		return nil
+	}
+
+	tbl, err := d.GetPartitionTable()
+	if err != nil {
+		return fmt.Errorf("failed to read partition table: %w", err)
+	}
+	idx, err := partitionIndex(tbl, appPartition)
+	if err != nil {
+		logger.Error("Unable to locate app partition index for device file restore", "error", err)
+		return fmt.Errorf("failed to locate app partition index: %w", err)
+	}
+	logger.Debug("Restoring device files via mount", "partition_index", idx, "files", s.names)
+
+	partDevice := partitionDevicePath(destination, idx)
+	if err := unmountIfMounted(partDevice, logger); err != nil {
+		return err
+	}
+
+	mountDir, cleanup, err := mountSpecificPartition(destination, idx, true)
+	if err != nil {
+		logger.Error("Failed to mount app partition for device file restore", "error", err, "destination", destination, "partition_index", idx, "device", partDevice)
+		return err
+	}
+	for _, name := range s.names {
+		if err := replaceFile(filepath.Join(s.dir, name), filepath.Join(mountDir, name), appDeviceFiles[name]); err != nil {
+			cleanup()
+			return err
+		}
+	}
+	if err := fsyncPath(mountDir); err != nil {
+		logger.Debug("Failed to fsync app mount directory", "error", err, "path", mountDir)
+	}
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed after device file restore", "error", err, "output", string(out))
+	}
+	cleanup()
+
+	if out, err := exec.Command("blockdev", "--flushbufs", partDevice).CombinedOutput(); err != nil {
+		logger.Debug("blockdev flush failed after device file restore", "error", err, "output", string(out))
+	}
+
+	verifyDir, verifyCleanup, err := mountSpecificPartition(destination, idx, false)
+	if err != nil {
+		return fmt.Errorf("failed to remount app partition for device file verification: %w", err)
+	}
+	defer verifyCleanup()
+
+	for _, name := range s.names {
+		expected, size, err := readbackFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return err
+		}
+		actual, _, err := readbackFile(filepath.Join(verifyDir, name))
+		report.add(partitionCheck{Name: "app /" + name, Bytes: size, Expected: expected, Actual: actual, Err: err})
+	}
+	return report.err()
+}
+
+// checkDataUntouched refuses writes that would reach into the data or vault
+// partition of the destination, whatever the source layout says.
+func checkDataUntouched(d *disk.Disk, writes []*streamCopy) error {
+	_, _, _, dataPartition, err := common.GetTezsignPartitions(d)
+	if err != nil {
+		return err
+	}
+	vaultPartition, err := common.GetTezsignVaultPartition(d)
+	if err != nil {
+		return err
+	}
+	for _, p := range []part.Partition{dataPartition, vaultPartition} {
+		if p == nil {
+			continue
+		}
+		for _, w := range writes {
+			end := w.Offset + w.Src.GetSize()
+			if w.Offset < p.GetStart()+p.GetSize() && p.GetStart() < end {
+				return fmt.Errorf("%w: the %s partition would overwrite %d..%d, which holds the keys", errDataPartition, w.Name, p.GetStart(), p.GetStart()+p.GetSize())
+			}
+		}
+	}
+	return nil
+}
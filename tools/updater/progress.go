@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
+)
+
+var (
+	// progressBar shows copies in a bubbletea view; otherwise, e.g. when
+	// a script runs the updater, progress goes to progressLogger every
+	// progressLogInterval.
+	progressBar         = true
+	progressLogInterval = 10 * time.Second
+	progressLogger      = slog.Default()
+)
+
+// setupOutput picks how progress is shown. --json (jsonOutput) writes
+// every log record, progress included, as one JSON object per line on
+// stdout; the returned logger replaces the default one.
+func setupOutput(jsonOutput bool, logger *slog.Logger) *slog.Logger {
+	if jsonOutput {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		progressBar = false
+	} else {
+		progressBar = term.IsTerminal(os.Stdout.Fd())
+	}
+	progressLogger = logger
+	return logger
+}
+
+// overallProgress spans the copies of one update for the ETA of the whole
+// update; total stays 0 outside one.
+type overallProgress struct {
+	total int64
+	done  int64
+	start time.Time
+}
+
+var overall overallProgress
+
+// beginOverall starts an update that moves total bytes over all its
+// copies, readback included.
+func beginOverall(total int64) {
+	overall = overallProgress{total: total, start: time.Now()}
+}
+
+// eta is the time left of the update with current bytes of the copy under
+// way done.
+func (o *overallProgress) eta(current int64) (time.Duration, bool) {
+	if o.total <= 0 {
+		return 0, false
+	}
+	return remaining(o.done+current, o.total, time.Since(o.start))
+}
+
+// runProgress runs work, which advances counter towards total bytes, and
+// shows its progress. cancel, when set, lets q stop the work.
+func runProgress(title string, total int64, counter progressCounter, cancel func(), work func() error) error {
+	var err error
+	if progressBar {
+		err = runProgressView(title, total, counter, cancel, work)
+	} else {
+		err = runProgressLog(title, total, counter, work)
+	}
+	overall.done += counter.Count()
+	return err
+}
+
+func runProgressView(title string, total int64, counter progressCounter, cancel func(), work func() error) error {
+	program := tea.NewProgram(newProgressModel(title, total, counter, cancel))
+	errCh := make(chan error, 1)
+	go func() {
+		err := work()
+		program.Send(finishMsg{err: err})
+		errCh <- err
+	}()
+
+	model, err := program.Run()
+	if err != nil {
+		progressLogger.Warn("Failed to render progress; waiting for the operation", "what", title, "error", err)
+		return <-errCh
+	}
+	if m, ok := model.(progressModel); ok && m.cancelled {
+		return m.err
+	}
+	return <-errCh
+}
+
+func runProgressLog(title string, total int64, counter progressCounter, work func() error) error {
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- work()
+	}()
+
+	t := time.NewTicker(progressLogInterval)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-errCh:
+			attrs := progressAttrs(title, total, counter.Count(), start, true)
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			progressLogger.Info("Progress", attrs...)
+			return err
+		case <-t.C:
+			progressLogger.Info("Progress", progressAttrs(title, total, counter.Count(), start, false)...)
+		}
+	}
+}
+
+// progressAttrs are the fields of a Progress record, like the builder's;
+// times are whole seconds.
+func progressAttrs(title string, total, done int64, start time.Time, finished bool) []any {
+	elapsed := time.Since(start)
+	var percent float64
+	if total > 0 {
+		percent = float64(int(float64(done)*1000/float64(total))) / 10
+	}
+	attrs := []any{
+		slog.String("what", title),
+		slog.Int64("bytes", done),
+		slog.Int64("total", total),
+		slog.Float64("percent", percent),
+		slog.Int64("bytes_per_s", bytesPerSecond(done, elapsed)),
+		slog.Int64("elapsed_s", int64(elapsed.Seconds())),
+	}
+	if !finished {
+		if eta, ok := remaining(done, total, elapsed); ok {
+			attrs = append(attrs, slog.Int64("eta_s", int64(eta.Seconds())))
+		}
+	}
+	if eta, ok := overall.eta(done); ok && !finished {
+		attrs = append(attrs, slog.Int64("update_eta_s", int64(eta.Seconds())))
+	}
+	return attrs
+}
+
+func bytesPerSecond(n int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(n) / elapsed.Seconds())
+}
+
+// remaining extrapolates the time left from the rate so far.
+func remaining(done, total int64, elapsed time.Duration) (time.Duration, bool) {
+	if total <= 0 || done <= 0 {
+		return 0, false
+	}
+	left := max(total-done, 0)
+	return time.Duration(float64(elapsed) * float64(left) / float64(done)).Round(time.Second), true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/partition/part"
+	"golang.org/x/sys/unix"
+)
+
+var errVerificationFailed = errors.New("verification failed")
+
+// partitionCheck is the result of reading back what was written.
+type partitionCheck struct {
+	Name     string
+	Bytes    int64
+	Expected string // sha256 of what was written
+	Actual   string // sha256 read back; empty when the read failed
+	Err      error
+}
+
+func (c partitionCheck) ok() bool {
+	return c.Err == nil && c.Expected == c.Actual
+}
+
+// updateReport collects what an update planned to write and how it
+// checked out.
+type updateReport struct {
+	Plan   *updatePlan
+	DryRun bool
+	// Backup is the snapshot of the app partition taken before writing.
+	Backup string
+	// SkipUnchanged counts what a --skip-unchanged update rewrote.
+	SkipUnchanged *skipStats
+	// Delta counts what a --delta update downloaded and rewrote.
+	Delta  *deltaStats
+	Checks []partitionCheck
+}
+
+func (r *updateReport) add(c partitionCheck) {
+	r.Checks = append(r.Checks, c)
+}
+
+// err fails the update when anything read back differs.
+func (r *updateReport) err() error {
+	var failed []string
+	for _, c := range r.Checks {
+		if !c.ok() {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s read back differently; the card may be failing", errVerificationFailed, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (r *updateReport) print(w io.Writer) {
+	if r.Plan != nil && (r.DryRun || len(r.Checks) > 0) {
+		r.Plan.print(w)
+	}
+	if r.DryRun {
+		fmt.Fprintln(w, "Dry run: nothing was written.")
+		return
+	}
+	if r.Backup != "" {
+		fmt.Fprintf(w, "App partition backup: %s\n\n", r.Backup)
+	}
+	if r.Delta != nil {
+		fmt.Fprintf(w, "Delta: downloaded %s, rewrote %s, %s was already on the card\n\n", byteCountToHumanReadable(r.Delta.Downloaded.Load()), byteCountToHumanReadable(r.Delta.Written.Load()), byteCountToHumanReadable(r.Delta.Skipped.Load()))
+	}
+	if r.SkipUnchanged != nil {
+		fmt.Fprintf(w, "Skip unchanged: rewrote %s, %s was already on the card\n\n", byteCountToHumanReadable(r.SkipUnchanged.Written.Load()), byteCountToHumanReadable(r.SkipUnchanged.Skipped.Load()))
+	}
+	if len(r.Checks) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Verification:")
+	for _, c := range r.Checks {
+		switch {
+		case c.Err != nil:
+			fmt.Fprintf(w, "  ❌ %-18s read failed: %v\n", c.Name, c.Err)
+		case !c.ok():
+			fmt.Fprintf(w, "  ❌ %-18s %s, expected %s\n", c.Name, c.Actual, c.Expected)
+		default:
+			fmt.Fprintf(w, "  ✅ %-18s %s  %s\n", c.Name, byteCountToHumanReadable(c.Bytes), c.Actual)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// readbackPartition hashes the partition range of destination, past the
+// kernel's buffer cache, so what is compared is what the card returns.
+func readbackPartition(destination string, p part.Partition, description string) (string, error) {
+	f, err := os.Open(destination)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	dropCache(f)
+
+	total := p.GetSize()
+	h := sha256.New()
+	if err := copyWithProgress(fmt.Sprintf("Verifying %s", description), total, h, io.NewSectionReader(f, p.GetStart(), total)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readbackFile hashes a file, e.g. on a freshly mounted partition.
+func readbackFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// dropCache empties the buffer cache of a block device, or drops the
+// cached pages of an image file.
+func dropCache(f *os.File) {
+	if err := unix.IoctlSetInt(int(f.Fd()), unix.BLKFLSBUF, 0); err != nil {
+		_ = unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+	}
+}
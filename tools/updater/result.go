@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// Exit codes, for scripts that provision cards to branch on.
+const (
+	exitOK = 0
+	// exitFailure is anything not covered below, e.g. a failed download.
+	exitFailure = 1
+	exitUsage   = 2
+	// exitValidation: the source or destination was refused before
+	// anything was written (signature or checksum, downgrade, not a
+	// TezSign card, unsafe destination).
+	exitValidation = 3
+	// exitSizeMismatch: the partitions of source and destination differ.
+	exitSizeMismatch = 4
+	// exitWriteError: writing the card failed; it may be half updated and
+	// the app partition backup applies.
+	exitWriteError = 5
+	// exitVerifyError: the card reads back differently from what was
+	// written.
+	exitVerifyError = 6
+)
+
+var (
+	errSizeMismatch = errors.New("partition layout mismatch")
+	errWriteFailed  = errors.New("write failed")
+	errNotTezsign   = errors.New("destination does not match TezSign layout")
+)
+
+// failureCauses maps errors to their exit code and the cause in the JSON
+// result, most specific first.
+var failureCauses = []struct {
+	err   error
+	code  int
+	cause string
+}{
+	{errVerificationFailed, exitVerifyError, "verify"},
+	{errWriteFailed, exitWriteError, "write"},
+	{errSizeMismatch, exitSizeMismatch, "size_mismatch"},
+	{errUnsignedSource, exitValidation, "validation"},
+	{errChecksumMismatch, exitValidation, "validation"},
+	{errDowngrade, exitValidation, "validation"},
+	{errUnsafeDestination, exitValidation, "validation"},
+	{errDataPartition, exitValidation, "validation"},
+	{errNotTezsign, exitValidation, "validation"},
+	{errNoBackup, exitValidation, "validation"},
+}
+
+func exitCode(err error) (int, string) {
+	if err == nil {
+		return exitOK, ""
+	}
+	for _, c := range failureCauses {
+		if errors.Is(err, c.err) {
+			return c.code, c.cause
+		}
+	}
+	return exitFailure, "failure"
+}
+
+// updateResult is the Result record --json ends with.
+type updateResult struct {
+	Status      string        `json:"status"` // ok, dry-run or failed
+	ExitCode    int           `json:"exit_code"`
+	Cause       string        `json:"cause,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Kind        UpdateKind    `json:"kind,omitempty"`
+	Source      string        `json:"source,omitempty"`
+	Destination string        `json:"destination,omitempty"`
+	From        string        `json:"from,omitempty"`
+	To          string        `json:"to,omitempty"`
+	Backup      string        `json:"backup,omitempty"`
+	Checks      []checkResult `json:"checks,omitempty"`
+	Rewritten   *int64        `json:"rewritten,omitempty"`
+	Unchanged   *int64        `json:"unchanged,omitempty"`
+	Downloaded  *int64        `json:"downloaded,omitempty"` // --delta only
+}
+
+type checkResult struct {
+	Name     string `json:"name"`
+	Bytes    int64  `json:"bytes"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (r *updateReport) result(err error) updateResult {
+	code, cause := exitCode(err)
+	res := updateResult{Status: "ok", ExitCode: code, Cause: cause, Backup: r.Backup}
+	switch {
+	case err != nil:
+		res.Status = "failed"
+		res.Error = err.Error()
+	case r.DryRun:
+		res.Status = "dry-run"
+	}
+	if p := r.Plan; p != nil {
+		res.Kind, res.Source, res.Destination = p.Kind, p.Source, p.Destination
+		if p.From.known() || p.To.known() {
+			res.From, res.To = p.From.String(), p.To.String()
+		}
+	}
+	for _, c := range r.Checks {
+		check := checkResult{Name: c.Name, Bytes: c.Bytes, Expected: c.Expected, Actual: c.Actual, OK: c.ok()}
+		if c.Err != nil {
+			check.Error = c.Err.Error()
+		}
+		res.Checks = append(res.Checks, check)
+	}
+	if r.SkipUnchanged != nil {
+		written, skipped := r.SkipUnchanged.Written.Load(), r.SkipUnchanged.Skipped.Load()
+		res.Rewritten, res.Unchanged = &written, &skipped
+	}
+	if r.Delta != nil {
+		downloaded, written, skipped := r.Delta.Downloaded.Load(), r.Delta.Written.Load(), r.Delta.Skipped.Load()
+		res.Downloaded, res.Rewritten, res.Unchanged = &downloaded, &written, &skipped
+	}
+	return res
+}
+
+// finish prints the report, logs how the operation ended (with --json as a
+// Result record) and returns the exit code. what names the operation.
+func finish(what string, report *updateReport, err error, out io.Writer, opts updateOptions, logger *slog.Logger) int {
+	report.print(out)
+	code, _ := exitCode(err)
+	if opts.JSON {
+		logger.Info("Result", "result", report.result(err))
+	}
+	switch {
+	case err != nil:
+		logger.Error(what+" failed", "error", err, "exit_code", code)
+	case !report.DryRun:
+		logger.Info(what + " completed successfully")
+	}
+	return code
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+)
+
+// skipChunkSize is what --skip-unchanged compares and writes at a time.
+const skipChunkSize = 1 << 20
+
+// skipStats counts what a --skip-unchanged update rewrote and what was already on
+// the card, over every partition.
+type skipStats struct {
+	Written atomic.Int64
+	Skipped atomic.Int64
+}
+
+// skipWriter writes through to dst only the chunks that differ from what
+// dst already holds. Between two releases most of boot and rootfs is
+// unchanged, so --skip-unchanged rewrites megabytes instead of whole
+// partitions; the readback still covers every byte. The whole source is
+// still downloaded and read: this saves card writes, not transfer. A skipWriter serves one copy at
+// a time; parallel copies each get their own.
+type skipWriter struct {
+	dst     destinationFile
+	current []byte
+	stats   *skipStats
+}
+
+func newSkipWriter(dst destinationFile, stats *skipStats) *skipWriter {
+	return &skipWriter{dst: dst, current: alignedBuffer(skipChunkSize), stats: stats}
+}
+
+func (d *skipWriter) ReadAt(p []byte, off int64) (int, error) {
+	return d.dst.ReadAt(p, off)
+}
+
+func (d *skipWriter) WriteAt(p []byte, off int64) (int, error) {
+	done := 0
+	for done < len(p) {
+		chunk := p[done:min(len(p), done+skipChunkSize)]
+		current := d.current[:len(chunk)]
+		// a chunk that cannot be read is written
+		if n, err := d.dst.ReadAt(current, off+int64(done)); n == len(chunk) && (err == nil || err == io.EOF) && bytes.Equal(current, chunk) {
+			d.stats.Skipped.Add(int64(len(chunk)))
+			done += len(chunk)
+			continue
+		}
+		n, err := d.dst.WriteAt(chunk, off+int64(done))
+		d.stats.Written.Add(int64(n))
+		done += n
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
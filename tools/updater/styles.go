@@ -27,6 +27,7 @@ type deviceCandidate struct {
 	Model     string
 	Status    string
 	Valid     bool
+	Version   imageVersion
 }
 
 type selectionModel struct {
@@ -201,8 +202,11 @@ type progressModel struct {
 	total   int64
 	counter progressCounter
 	cancel  func()
+	start   time.Time
 	err     error
 	done    bool
+	// cancelled is set when q stopped the operation
+	cancelled bool
 }
 
 type tickMsg time.Time
@@ -217,6 +221,7 @@ func newProgressModel(title string, total int64, counter progressCounter, cancel
 		total:   total,
 		counter: counter,
 		cancel:  cancel,
+		start:   time.Now(),
 	}
 }
 
@@ -244,10 +249,13 @@ func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q", "esc":
-			if !m.done && m.cancel != nil {
-				m.cancel()
+			// a copy to the card cannot stop halfway; only downloads can
+			if m.done || m.cancel == nil {
+				return m, nil
 			}
+			m.cancel()
 			m.done = true
+			m.cancelled = true
 			m.err = errors.New("operation cancelled")
 			return m, tea.Quit
 		}
@@ -269,29 +277,38 @@ func renderProgressBar(pct float64, width int) string {
 	return fmt.Sprintf("[%s%s] %5.1f%%", strings.Repeat("█", fill), strings.Repeat("░", width-fill), pct)
 }
 
+// View shows the bar with throughput and ETA while the copy runs, and
+// leaves a single line behind once it is done, so the copies of an update
+// stack up above the one under way.
 func (m progressModel) View() string {
 	read := m.counter.Count()
-	var pct float64 = -1
-	if m.total > 0 {
-		pct = float64(read) / float64(m.total) * 100
+	elapsed := time.Since(m.start)
+	rate := bytesPerSecond(read, elapsed)
+
+	if m.done {
+		if m.err != nil {
+			return fmt.Sprintf("❌ %s: %v\n", m.title, m.err)
+		}
+		return fmt.Sprintf("✅ %s  %s in %s (%s/s)\n", m.title, byteCountToHumanReadable(read), elapsed.Round(time.Second), byteCountToHumanReadable(rate))
 	}
 
 	var builder strings.Builder
 	builder.WriteString(fmt.Sprintf("%s\n\n", m.title))
-	if pct >= 0 {
-		builder.WriteString(renderProgressBar(pct, 40))
-		builder.WriteString(fmt.Sprintf("  %s / %s\n", byteCountToHumanReadable(read), byteCountToHumanReadable(m.total)))
+	if m.total > 0 {
+		builder.WriteString(renderProgressBar(float64(read)/float64(m.total)*100, 40))
+		builder.WriteString(fmt.Sprintf("  %s / %s", byteCountToHumanReadable(read), byteCountToHumanReadable(m.total)))
 	} else {
-		builder.WriteString(fmt.Sprintf("%s read\n", byteCountToHumanReadable(read)))
+		builder.WriteString(fmt.Sprintf("%s read", byteCountToHumanReadable(read)))
 	}
-
-	if m.done {
-		if m.err != nil {
-			builder.WriteString(fmt.Sprintf("\nError: %v\n", m.err))
-		} else {
-			builder.WriteString("\nDone.\n")
-		}
-	} else {
+	builder.WriteString(fmt.Sprintf("  %s/s", byteCountToHumanReadable(rate)))
+	if eta, ok := remaining(read, m.total, elapsed); ok {
+		builder.WriteString(fmt.Sprintf("  ETA %s", eta))
+	}
+	builder.WriteString("\n")
+	if eta, ok := overall.eta(read); ok {
+		builder.WriteString(fmt.Sprintf("Update: %s / %s, about %s left\n", byteCountToHumanReadable(overall.done+read), byteCountToHumanReadable(overall.total), eta))
+	}
+	if m.cancel != nil {
 		builder.WriteString("\nPress q to cancel.")
 	}
 	return builder.String()
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/backend/file"
@@ -56,38 +55,6 @@ func filesystemForPartition(d *disk.Disk, p part.Partition) (filesystem.FileSyst
 	return d.GetFilesystem(idx)
 }
 
-func mountAppPartition(writable bool) (string, func(), error) {
-	if err := ensureMountAvailable(); err != nil {
-		return "", nil, err
-	}
-
-	appDev, err := filepath.EvalSymlinks("/dev/disk/by-label/app")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to resolve /dev/disk/by-label/app: %w", err)
-	}
-
-	tmpDir, err := os.MkdirTemp("", "tezsign_app_mount_")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
-	}
-
-	opts := "ro"
-	if writable {
-		opts = "rw,sync"
-	}
-	mountCmd := exec.Command("mount", "-o", opts, appDev, tmpDir)
-	if out, err := mountCmd.CombinedOutput(); err != nil {
-		os.RemoveAll(tmpDir)
-		return "", nil, fmt.Errorf("failed to mount app partition (%s): %v: %s", appDev, err, string(out))
-	}
-
-	cleanup := func() {
-		exec.Command("umount", tmpDir).Run()
-		os.RemoveAll(tmpDir)
-	}
-	return tmpDir, cleanup, nil
-}
-
 func mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error) {
 	if err := ensureMountAvailable(); err != nil {
 		return "", nil, err
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+// Set at build time to the minisign public key the releases are signed
+// with (the base64 line of the .pub file):
+//
+//	-ldflags "-X main.releasePublicKey=<base64 key>"
+//
+// Builds without one refuse every source unless --public-key or --insecure
+// is passed.
+var releasePublicKey = ""
+
+var errUnsignedSource = errors.New("source signature verification failed")
+
+// updateOptions are the flags that apply to every kind of update.
+type updateOptions struct {
+	// Insecure skips the signature check of the source.
+	Insecure bool
+	// PublicKeyPath overrides the built-in release key.
+	PublicKeyPath string
+	// SHA256 pins the source to this checksum instead of its signature.
+	SHA256 string
+	// DryRun reports the plan without touching the destination.
+	DryRun bool
+	// BackupDir overrides where app partition snapshots go; NoBackup skips
+	// them.
+	BackupDir string
+	NoBackup  bool
+	// AllowDowngrade writes a source older than what the device runs.
+	AllowDowngrade bool
+	// JSON writes logs and progress as JSON lines on stdout.
+	JSON bool
+	// SkipUnchanged writes only the chunks of a full update that differ
+	// from what the card holds.
+	SkipUnchanged bool
+	// Delta downloads only those chunks of a release (see delta.go).
+	Delta bool
+	// BufferSize, Direct and Parallel tune the partition copies; see
+	// copy.go.
+	BufferSize int
+	Direct     bool
+	Parallel   bool
+	// Force writes a destination that does not look like a TezSign card,
+	// after a typed confirmation.
+	Force bool
+}
+
+// parseArgs splits the flags off the positional arguments; flags may come
+// before or after them.
+func parseArgs(args []string) ([]string, updateOptions, error) {
+	var positional []string
+	var opts updateOptions
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		switch name {
+		case "insecure":
+			opts.Insecure = true
+		case "dry-run":
+			opts.DryRun = true
+		case "no-backup":
+			opts.NoBackup = true
+		case "allow-downgrade":
+			opts.AllowDowngrade = true
+		case "json":
+			opts.JSON = true
+		case "force":
+			opts.Force = true
+		case "skip-unchanged":
+			opts.SkipUnchanged = true
+		case "delta":
+			opts.Delta = true
+		case "direct":
+			opts.Direct = true
+		case "parallel":
+			opts.Parallel = true
+		case "public-key", "sha256", "backup-dir", "buffer-size":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, opts, fmt.Errorf("%s needs a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			switch name {
+			case "public-key":
+				opts.PublicKeyPath = value
+			case "sha256":
+				sum, err := parseSHA256(value)
+				if err != nil {
+					return nil, opts, err
+				}
+				opts.SHA256 = sum
+			case "backup-dir":
+				opts.BackupDir = value
+			case "buffer-size":
+				size, err := parseBufferSize(value)
+				if err != nil {
+					return nil, opts, err
+				}
+				opts.BufferSize = size
+			}
+		default:
+			return nil, opts, fmt.Errorf("unknown flag %s", arg)
+		}
+	}
+	if opts.Delta && (opts.Insecure || opts.SHA256 != "") {
+		return nil, opts, errors.New("--delta trusts the chunks through the signed manifest; it cannot be combined with --insecure or --sha256")
+	}
+	return positional, opts, nil
+}
+
+func (o updateOptions) publicKey() (*common.PublicKey, error) {
+	data := []byte(releasePublicKey)
+	if o.PublicKeyPath != "" {
+		var err error
+		if data, err = os.ReadFile(o.PublicKeyPath); err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+	} else if releasePublicKey == "" {
+		return nil, fmt.Errorf("%w: this build has no release public key; pass --public-key or --insecure", errUnsignedSource)
+	}
+	return common.ParsePublicKey(data)
+}
+
+// verifySource checks the minisign signature next to source before
+// anything is written. An image also needs its signed manifest, which is
+// returned; a gadget binary only has a signature. A source pinned with
+// --sha256 is checked against that instead and has no manifest.
+func verifySource(source string, opts updateOptions, logger *slog.Logger) (*common.ImageManifest, error) {
+	if opts.SHA256 != "" {
+		return nil, checkPinnedChecksum(source, opts, logger)
+	}
+	if opts.Insecure {
+		logger.Warn("Skipping signature verification of the source", "source", source)
+		return nil, nil
+	}
+	pub, err := opts.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if isImageSource(source) {
+		manifest, err := common.VerifyImage(pub, source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errUnsignedSource, err)
+		}
+		logger.Info("Verified source image", "source", source, "version", manifest.Version, "flavour", manifest.Flavour, "key_id", fmt.Sprintf("%X", pub.ID))
+		return manifest, nil
+	}
+
+	sig, err := os.ReadFile(source + common.SignatureSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnsignedSource, err)
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := pub.Verify(f, sig); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errUnsignedSource, source, err)
+	}
+	logger.Info("Verified gadget binary", "source", source, "key_id", fmt.Sprintf("%X", pub.ID))
+	return nil, nil
+}
+
+// downloadSignatures fetches the signature files published next to url
+// (and the manifest for an image) to the same names next to path.
+func downloadSignatures(url, path string) (func(), error) {
+	suffixes := []string{common.SignatureSuffix}
+	if isImageSource(path) {
+		suffixes = append(suffixes, common.ManifestSuffix, common.ManifestSuffix+common.SignatureSuffix)
+	}
+
+	var written []string
+	cleanup := func() {
+		for _, p := range written {
+			os.Remove(p)
+		}
+	}
+	for _, suffix := range suffixes {
+		if err := downloadSmall(url+suffix, path+suffix); err != nil {
+			cleanup()
+			return nil, err
+		}
+		written = append(written, path+suffix)
+	}
+	return cleanup, nil
+}
+
+// downloadSmall fetches a file of at most 1 MiB without progress.
+func downloadSmall(url, path string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return os.WriteFile(path, data, 0600)
+}
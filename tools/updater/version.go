@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// Images carry their release in constants.AppVersionFile on the app
+// partition. The updater compares it with the one on the device and
+// refuses to go back, or to write a source whose release it cannot place,
+// unless --allow-downgrade is passed, and appends every update to
+// constants.AppHistoryFile.
+
+var errDowngrade = errors.New("refusing to downgrade")
+
+// maxHistoryEntries bounds the history file; older entries are dropped.
+const maxHistoryEntries = 100
+
+// imageVersion is the release an app partition, image or manifest comes
+// from. The zero value is an unknown release.
+type imageVersion struct {
+	Version   string
+	Commit    string
+	BuildTime time.Time
+}
+
+func (v imageVersion) known() bool {
+	return v.Version != "" || !v.BuildTime.IsZero()
+}
+
+func (v imageVersion) String() string {
+	s := v.Version
+	if s == "" && !v.BuildTime.IsZero() {
+		s = v.BuildTime.Format(time.RFC3339)
+	}
+	if s == "" {
+		return "unknown"
+	}
+	if v.Commit != "" {
+		s += " (" + v.Commit[:min(len(v.Commit), 12)] + ")"
+	}
+	return s
+}
+
+// file renders v the way the builder writes constants.AppVersionFile.
+func (v imageVersion) file() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "TEZSIGN_VERSION=%s\n", v.Version)
+	fmt.Fprintf(&b, "TEZSIGN_COMMIT=%s\n", v.Commit)
+	if !v.BuildTime.IsZero() {
+		fmt.Fprintf(&b, "TEZSIGN_BUILD_TIME=%s\n", v.BuildTime.UTC().Format(time.RFC3339))
+	}
+	return b.Bytes()
+}
+
+func parseAppVersion(data []byte) imageVersion {
+	var v imageVersion
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "TEZSIGN_VERSION":
+			v.Version = value
+		case "TEZSIGN_COMMIT":
+			v.Commit = value
+		case "TEZSIGN_BUILD_TIME":
+			v.BuildTime, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return v
+}
+
+func manifestVersion(m *common.ImageManifest) imageVersion {
+	v := imageVersion{Version: m.Version, Commit: m.Commit}
+	if m.SourceDateEpoch != 0 {
+		v.BuildTime = time.Unix(m.SourceDateEpoch, 0).UTC()
+	}
+	return v
+}
+
+// readAppVersion reads the version file of an app partition; images built
+// before it existed have an unknown version.
+func readAppVersion(d *disk.Disk, appPartition part.Partition) imageVersion {
+	fs, err := filesystemForPartition(d, appPartition)
+	if err != nil {
+		return imageVersion{}
+	}
+	defer fs.Close()
+	f, err := fs.OpenFile(constants.AppVersionFile, os.O_RDONLY)
+	if err != nil {
+		return imageVersion{}
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return imageVersion{}
+	}
+	return parseAppVersion(data)
+}
+
+// compareVersions orders a and b by semver when both versions are semver,
+// else by build time. ok is false when neither applies.
+func compareVersions(a, b imageVersion) (c int, ok bool) {
+	if sa, okA := parseSemver(a.Version); okA {
+		if sb, okB := parseSemver(b.Version); okB {
+			return sa.compare(sb), true
+		}
+	}
+	if a.BuildTime.IsZero() || b.BuildTime.IsZero() {
+		return 0, false
+	}
+	return a.BuildTime.Compare(b.BuildTime), true
+}
+
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+// parseSemver accepts MAJOR.MINOR.PATCH with an optional v prefix,
+// pre-release and build metadata.
+func parseSemver(s string) (semver, bool) {
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, pre, _ := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	var n [3]int
+	for i, p := range parts {
+		var err error
+		if n[i], err = strconv.Atoi(p); err != nil || n[i] < 0 {
+			return semver{}, false
+		}
+	}
+	return semver{major: n[0], minor: n[1], patch: n[2], pre: pre}, true
+}
+
+func (a semver) compare(b semver) int {
+	for _, d := range []int{a.major - b.major, a.minor - b.minor, a.patch - b.patch} {
+		if d != 0 {
+			return max(-1, min(1, d))
+		}
+	}
+	switch {
+	case a.pre == b.pre:
+		return 0
+	case a.pre == "":
+		return 1
+	case b.pre == "":
+		return -1
+	}
+	return strings.Compare(a.pre, b.pre)
+}
+
+// checkDowngrade refuses to replace from with an older to, and with a to
+// it cannot place: an unknown source or versions that do not compare may
+// well be older. A device of unknown version predates the version file,
+// so anything known is newer.
+func checkDowngrade(from, to imageVersion, opts updateOptions, logger *slog.Logger) error {
+	var reason string
+	switch c, ok := compareVersions(to, from); {
+	case !to.known():
+		reason = "the version of the source is unknown"
+	case !from.known():
+		logger.Warn("The device predates version files; assuming an upgrade", "source", to.String())
+		return nil
+	case !ok:
+		reason = "the versions are not comparable"
+	case c >= 0:
+		return nil
+	default:
+		reason = "the source is older"
+	}
+	if opts.AllowDowngrade {
+		logger.Warn("Writing a possible downgrade", "reason", reason, "device", from.String(), "source", to.String())
+		return nil
+	}
+	return fmt.Errorf("%w from %s to %s: %s; pass --allow-downgrade", errDowngrade, from, to, reason)
+}
+
+// historyEntry is one line of constants.AppHistoryFile.
+type historyEntry struct {
+	Time   time.Time  `json:"time"`
+	Kind   UpdateKind `json:"kind"`
+	From   string     `json:"from"`
+	To     string     `json:"to"`
+	Source string     `json:"source"`
+}
+
+// appendHistory returns the history file with e added at the end, keeping
+// the last maxHistoryEntries lines.
+func appendHistory(existing []byte, e historyEntry) ([]byte, error) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, l := range strings.Split(string(existing), "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	lines = append(lines, string(line))
+	if len(lines) > maxHistoryEntries {
+		lines = lines[len(lines)-maxHistoryEntries:]
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/ulikunitz/xz"
+)
+
+// xzHeadSize is read from the start of a compressed image for its
+// partition table; a GPT with 128 entries ends at 17 KiB and partitions
+// start at 1 MiB or later.
+const xzHeadSize = 64 << 10
+
+// sectorSize is the logical sector size images are built with.
+const sectorSize = 512
+
+// xzImage reads a compressed image front to back, once. The partition
+// table comes from the head of the stream, so nothing is decompressed to
+// disk and a dry run decompresses only the head.
+type xzImage struct {
+	path       string
+	f          *os.File
+	size       int64 // of the compressed file
+	compressed *countingReader
+	r          io.Reader
+	pos        int64 // offset in the decompressed stream
+	head       []byte
+
+	table                   partition.Table
+	boot, rootfs, app, data part.Partition
+}
+
+func openXZImage(path string) (*xzImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open compressed source %s: %w", path, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	x := &xzImage{path: path, f: f, size: st.Size(), compressed: &countingReader{r: f}}
+	if x.r, err = xz.NewReader(x.compressed); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to create xz reader: %w", err)
+	}
+	x.head = make([]byte, xzHeadSize)
+	if _, err := io.ReadFull(x.r, x.head); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the head of %s: %w", path, err)
+	}
+	x.pos = xzHeadSize
+
+	if x.table, err = partition.Read(&memFile{bytes.NewReader(x.head)}, sectorSize, sectorSize); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the partition table of %s: %w", path, err)
+	}
+	if x.boot, x.rootfs, x.app, x.data, err = common.TezsignPartitions(x.table); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read partitions from %s: %w", path, err)
+	}
+	return x, nil
+}
+
+func (x *xzImage) Close() error {
+	return x.f.Close()
+}
+
+// streamCopy writes the source partition Src to Dst at Offset.
+type streamCopy struct {
+	Name   string
+	Src    part.Partition
+	Dst    io.WriterAt
+	Offset int64
+	SHA256 string // of what was written, set by stream
+}
+
+// stream decompresses the image once and writes each partition where its
+// copy says, skipping everything else. Partitions must not overlap and
+// must start behind the head.
+func (x *xzImage) stream(copies []*streamCopy, bufferSize int) error {
+	copies = slices.Clone(copies)
+	slices.SortFunc(copies, func(a, b *streamCopy) int {
+		return cmp.Compare(a.Src.GetStart(), b.Src.GetStart())
+	})
+
+	title := fmt.Sprintf("Copying %s", filepath.Base(x.path))
+	return runProgress(title, x.size, x.compressed, nil, func() error {
+		return x.streamTo(copies, bufferSize)
+	})
+}
+
+func (x *xzImage) streamTo(copies []*streamCopy, bufferSize int) error {
+	for _, c := range copies {
+		start, size := c.Src.GetStart(), c.Src.GetSize()
+		if start < x.pos {
+			return fmt.Errorf("%s starts at %d, already behind the stream at %d", c.Name, start, x.pos)
+		}
+		if _, err := io.CopyN(io.Discard, x.r, start-x.pos); err != nil {
+			return fmt.Errorf("failed to seek to %s: %w", c.Name, err)
+		}
+		h := sha256.New()
+		w := newBlockWriter(c.Dst, c.Offset, bufferSize)
+		n, err := io.CopyN(io.MultiWriter(w, h), x.r, size)
+		x.pos = start + n
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", c.Name, err)
+		}
+		c.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+// extractApp writes the partition table and the app partition to a sparse
+// image file, enough to open the app filesystem without decompressing the
+// rest.
+func (x *xzImage) extractApp() (string, error) {
+	tmpFile, err := os.CreateTemp("", "tezsign_app_*.img")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file for the app partition: %w", err)
+	}
+	fail := func(err error) (string, error) {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+
+	if _, err := tmpFile.WriteAt(x.head, 0); err != nil {
+		return fail(err)
+	}
+	copies := []*streamCopy{{Name: "app partition", Src: x.app, Dst: tmpFile, Offset: x.app.GetStart()}}
+	if err := x.stream(copies, defaultBufferSize); err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Truncate(x.data.GetStart() + x.data.GetSize()); err != nil {
+		return fail(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fail(err)
+	}
+	return tmpFile.Name(), nil
+}
+
+// memFile serves the head of an image to the partition table reader.
+type memFile struct {
+	*bytes.Reader
+}
+
+func (m *memFile) Stat() (fs.FileInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (m *memFile) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package watchdog
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first descriptor systemd passes (SD_LISTEN_FDS_START).
+const listenFDsStart = 3
+
+// StoreFiles hands duplicates of files to systemd's file descriptor store
+// under name. They come back from Files when the service restarts, so a
+// listening socket stays open across an upgrade. The unit needs
+// FileDescriptorStoreMax= and NotifyAccess=.
+func (n *Notifier) StoreFiles(name string, files ...*os.File) error {
+	if n == nil || len(files) == 0 {
+		return nil
+	}
+	if err := checkFDName(name); err != nil {
+		return err
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	return n.send("FDSTORE=1\nFDNAME="+name, unixRights(fds...))
+}
+
+// RemoveFiles drops what is stored under name.
+func (n *Notifier) RemoveFiles(name string) error {
+	if err := checkFDName(name); err != nil {
+		return err
+	}
+	return n.Notify("FDSTOREREMOVE=1\nFDNAME=" + name)
+}
+
+// checkFDName enforces what systemd accepts as FDNAME.
+func checkFDName(name string) error {
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, ":\n") {
+		return errors.New("invalid fd name " + strconv.Quote(name))
+	}
+	return nil
+}
+
+// Files returns the descriptors systemd passed to this process, stored
+// ones and socket-activated ones alike, by their FDNAME. It unsets
+// $LISTEN_FDS so they are claimed once; later calls return nil.
+func Files() map[string][]*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid := strings.TrimSpace(os.Getenv("LISTEN_PID")); pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(os.Getenv("LISTEN_FDS")))
+	if err != nil || count <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make(map[string][]*os.File)
+	for i := range count {
+		fd := listenFDsStart + i
+		closeOnExec(fd)
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[name] = append(files[name], os.NewFile(uintptr(fd), name))
+	}
+	return files
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package watchdog
+
+import (
+	"net"
+	"syscall"
+)
+
+func unixRights(fds ...int) []byte { return syscall.UnixRights(fds...) }
+
+func closeOnExec(fd int) { syscall.CloseOnExec(fd) }
+
+// sendWithRights sends state with oob as ancillary data; WriteMsgUnix
+// refuses a connected unixgram socket.
+func sendWithRights(c *net.UnixConn, state string, oob []byte) error {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	if err := raw.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), []byte(state), oob, nil, 0)
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return sendErr
+}
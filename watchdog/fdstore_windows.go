@@ -0,0 +1,16 @@
+package watchdog
+
+import (
+	"errors"
+	"net"
+)
+
+// Windows has no systemd; New returns nil there, so these are not reached.
+
+func unixRights(fds ...int) []byte { return nil }
+
+func closeOnExec(fd int) {}
+
+func sendWithRights(c *net.UnixConn, state string, oob []byte) error {
+	return errors.New("descriptor passing is not supported on windows")
+}
@@ -0,0 +1,196 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Notifier speaks the sd_notify protocol over $NOTIFY_SOCKET.
+// A nil *Notifier is valid and turns every call into a no-op, so callers
+// don't have to care whether they run under systemd.
+type Notifier struct {
+	mu   sync.Mutex
+	addr *net.UnixAddr
+	conn *net.UnixConn
+
+	sent, failed uint64
+	failing      int // sends failed in a row
+	failAfter    int
+	onFailure    func(err error, failing int)
+}
+
+// New returns a Notifier for $NOTIFY_SOCKET, or nil when it is not set.
+func New() *Notifier {
+	path := strings.TrimSpace(os.Getenv("NOTIFY_SOCKET"))
+	if path == "" {
+		return nil
+	}
+	return &Notifier{addr: &net.UnixAddr{Name: path, Net: "unixgram"}}
+}
+
+// OnFailure calls fn once failAfter sends in a row have failed, and again
+// after every further failAfter, so a watchdog that no longer reaches
+// systemd is noticed before systemd kills the process.
+func (n *Notifier) OnFailure(failAfter int, fn func(err error, failing int)) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.failAfter, n.onFailure = max(failAfter, 1), fn
+}
+
+// Stats reports how many notifications were sent and how many failed.
+func (n *Notifier) Stats() (sent, failed uint64) {
+	if n == nil {
+		return 0, 0
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.sent, n.failed
+}
+
+// Notify sends a raw state string such as "READY=1".
+func (n *Notifier) Notify(state string) error {
+	return n.send(state, nil)
+}
+
+// send writes state with oob, e.g. descriptors, as ancillary data. A send
+// that fails is retried once on a new connection, since systemd may have
+// been re-executed or the socket recreated.
+func (n *Notifier) send(state string, oob []byte) error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	err := n.write(state, oob)
+	if err != nil && n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+		err = n.write(state, oob)
+	}
+	var hook func(error, int)
+	if err != nil {
+		n.failed++
+		n.failing++
+		if n.onFailure != nil && n.failing%n.failAfter == 0 {
+			hook = n.onFailure
+		}
+	} else {
+		n.sent++
+		n.failing = 0
+	}
+	failing := n.failing
+	n.mu.Unlock()
+
+	if hook != nil {
+		hook(err, failing)
+	}
+	return err
+}
+
+// write sends on the cached connection, dialing it first if needed.
+// n.mu is held.
+func (n *Notifier) write(state string, oob []byte) error {
+	if n.conn == nil {
+		c, err := net.DialUnix("unixgram", nil, n.addr)
+		if err != nil {
+			return err
+		}
+		n.conn = c
+	}
+	if oob == nil {
+		_, err := n.conn.Write([]byte(state))
+		return err
+	}
+	return sendWithRights(n.conn, state, oob)
+}
+
+func (n *Notifier) Ready() error    { return n.Notify("READY=1") }
+func (n *Notifier) Stopping() error { return n.Notify("STOPPING=1") }
+func (n *Notifier) Ping() error     { return n.Notify("WATCHDOG=1") }
+
+// Status sets the one-line state shown by `systemctl status`.
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + strings.Join(strings.Fields(status), " "))
+}
+
+// Errno reports the errno behind err, if it wraps one, as the reason the
+// service is failing. Other errors send nothing.
+func (n *Notifier) Errno(err error) error {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) || errno == 0 {
+		return nil
+	}
+	return n.Notify("ERRNO=" + strconv.Itoa(int(errno)))
+}
+
+// ExtendTimeout asks systemd for d more time to finish starting, stopping
+// or, with RuntimeMaxSec=, running; d counts from now and replaces an
+// earlier extension. It does not reset the watchdog, which Ping does.
+func (n *Notifier) ExtendTimeout(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	return n.Notify("EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(d.Microseconds(), 10))
+}
+
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
+
+// Interval returns half of $WATCHDOG_USEC (the ping period systemd recommends),
+// or 0 when the watchdog is disabled for this process.
+func Interval() time.Duration {
+	usec, err := strconv.ParseUint(strings.TrimSpace(os.Getenv("WATCHDOG_USEC")), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	if pid := strings.TrimSpace(os.Getenv("WATCHDOG_PID")); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// StartPinger sends WATCHDOG=1 every Interval() until ctx is cancelled,
+// but only while healthy (nil: always) returns true, so systemd restarts
+// a process that is wedged even though its goroutines still run. healthy
+// runs on the pinging goroutine and should return quickly.
+// It does nothing if the watchdog is disabled.
+func (n *Notifier) StartPinger(ctx context.Context, healthy func() bool) {
+	every := Interval()
+	if n == nil || every <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if healthy == nil || healthy() {
+					_ = n.Ping()
+				}
+			}
+		}
+	}()
+}